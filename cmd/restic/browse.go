@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// browseKey is a key pressed in the browser, either one of the special keys
+// below or a printable rune.
+type browseKey rune
+
+// Special keys, these do not collide with printable runes.
+const (
+	keyUp browseKey = -(iota + 1)
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyBackspace
+	keyEscape
+	keyInterrupt
+)
+
+// parseKeys splits the input read from the terminal in raw mode into keys.
+func parseKeys(buf []byte) (keys []browseKey) {
+	sequences := []struct {
+		seq string
+		key browseKey
+	}{
+		{"\x1b[A", keyUp}, {"\x1bOA", keyUp},
+		{"\x1b[B", keyDown}, {"\x1bOB", keyDown},
+		{"\x1b[C", keyRight}, {"\x1bOC", keyRight},
+		{"\x1b[D", keyLeft}, {"\x1bOD", keyLeft},
+		{"\x1b[5~", keyPageUp}, {"\x1b[6~", keyPageDown},
+		{"\x1b[H", keyHome}, {"\x1b[1~", keyHome}, {"\x1bOH", keyHome},
+		{"\x1b[F", keyEnd}, {"\x1b[4~", keyEnd}, {"\x1bOF", keyEnd},
+	}
+
+	s := string(buf)
+next:
+	for len(s) > 0 {
+		for _, seq := range sequences {
+			if strings.HasPrefix(s, seq.seq) {
+				keys = append(keys, seq.key)
+				s = s[len(seq.seq):]
+				continue next
+			}
+		}
+
+		switch s[0] {
+		case '\x1b':
+			// a lone escape or an unknown escape sequence
+			keys = append(keys, keyEscape)
+			if len(s) > 1 && (s[1] == '[' || s[1] == 'O') {
+				s = ""
+				continue
+			}
+			s = s[1:]
+			continue
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+			s = s[1:]
+			continue
+		case '\x7f', '\b':
+			keys = append(keys, keyBackspace)
+			s = s[1:]
+			continue
+		case '\x03', '\x04':
+			keys = append(keys, keyInterrupt)
+			s = s[1:]
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s)
+		if r >= ' ' && r != utf8.RuneError {
+			keys = append(keys, browseKey(r))
+		}
+		s = s[size:]
+	}
+
+	return keys
+}
+
+// browseDir is a directory opened in the browser.
+type browseDir struct {
+	path   string
+	nodes  []*restic.Node
+	cursor int
+	offset int
+}
+
+// browseRestore is a restore requested by the user.
+type browseRestore struct {
+	snapshot *restic.Snapshot
+	paths    []string
+	target   string
+}
+
+// browseTreeLoader loads the trees shown in the browser.
+type browseTreeLoader interface {
+	LoadTree(context.Context, restic.ID) (*restic.Tree, error)
+}
+
+// browser holds the state of the interactive snapshot browser. It does not
+// access the terminal, the keys are passed to handleKey and the screen is
+// returned by render.
+type browser struct {
+	ctx  context.Context
+	repo browseTreeLoader
+
+	snapshots      restic.Snapshots
+	snapshotCursor int
+	snapshotOffset int
+
+	// snapshot is the snapshot being browsed, it is nil while the list of
+	// snapshots is shown.
+	snapshot *restic.Snapshot
+	dirs     []*browseDir
+	marked   map[string]bool
+
+	// prompting is set while the user enters the target directory for a
+	// restore into input.
+	prompting bool
+	input     string
+
+	// restore is set when the user has requested a restore, the caller
+	// runs it and resets the field.
+	restore *browseRestore
+
+	message string
+	quit    bool
+	height  int
+}
+
+// newBrowser returns a browser showing snapshots, newest first.
+func newBrowser(ctx context.Context, repo browseTreeLoader, snapshots restic.Snapshots) *browser {
+	sort.Sort(sort.Reverse(snapshots))
+	return &browser{
+		ctx:       ctx,
+		repo:      repo,
+		snapshots: snapshots,
+		height:    24,
+	}
+}
+
+// openSnapshot shows the root directory of sn.
+func (b *browser) openSnapshot(sn *restic.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+	}
+
+	dir, err := b.loadDir("/", *sn.Tree)
+	if err != nil {
+		return err
+	}
+
+	b.snapshot = sn
+	b.dirs = []*browseDir{dir}
+	b.marked = make(map[string]bool)
+	return nil
+}
+
+func (b *browser) loadDir(dirpath string, id restic.ID) (*browseDir, error) {
+	tree, err := b.repo.LoadTree(b.ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &browseDir{path: dirpath, nodes: tree.Nodes}, nil
+}
+
+// current returns the directory currently shown, or nil if the list of
+// snapshots is shown.
+func (b *browser) current() *browseDir {
+	if len(b.dirs) == 0 {
+		return nil
+	}
+	return b.dirs[len(b.dirs)-1]
+}
+
+// selected returns the node under the cursor and its path.
+func (b *browser) selected() (string, *restic.Node) {
+	dir := b.current()
+	if dir == nil || len(dir.nodes) == 0 {
+		return "", nil
+	}
+
+	node := dir.nodes[dir.cursor]
+	return path.Join(dir.path, node.Name), node
+}
+
+// markedPaths returns the marked paths, sorted.
+func (b *browser) markedPaths() []string {
+	paths := make([]string, 0, len(b.marked))
+	for p := range b.marked {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// listHeight returns the number of lines available for the list.
+func (b *browser) listHeight() int {
+	// header, metadata, help line and the line for messages
+	if h := b.height - 4; h > 0 {
+		return h
+	}
+	return 1
+}
+
+// move moves the cursor by delta entries within a list of length n.
+func (b *browser) move(cursor, offset *int, n, delta int) {
+	*cursor += delta
+	if *cursor >= n {
+		*cursor = n - 1
+	}
+	if *cursor < 0 {
+		*cursor = 0
+	}
+
+	h := b.listHeight()
+	if *cursor < *offset {
+		*offset = *cursor
+	}
+	if *cursor >= *offset+h {
+		*offset = *cursor - h + 1
+	}
+}
+
+// handleKey processes a single key.
+func (b *browser) handleKey(key browseKey) {
+	b.message = ""
+
+	if b.prompting {
+		b.handlePromptKey(key)
+		return
+	}
+
+	if key == keyInterrupt || key == 'q' {
+		b.quit = true
+		return
+	}
+
+	cursor, offset, n := &b.snapshotCursor, &b.snapshotOffset, len(b.snapshots)
+	if dir := b.current(); dir != nil {
+		cursor, offset, n = &dir.cursor, &dir.offset, len(dir.nodes)
+	}
+
+	switch key {
+	case keyUp, 'k':
+		b.move(cursor, offset, n, -1)
+	case keyDown, 'j':
+		b.move(cursor, offset, n, 1)
+	case keyPageUp:
+		b.move(cursor, offset, n, -b.listHeight())
+	case keyPageDown:
+		b.move(cursor, offset, n, b.listHeight())
+	case keyHome, 'g':
+		b.move(cursor, offset, n, -n)
+	case keyEnd, 'G':
+		b.move(cursor, offset, n, n)
+	case keyEnter, keyRight, 'l':
+		b.open()
+	case keyLeft, keyBackspace, keyEscape, 'h':
+		b.back()
+	case ' ':
+		b.toggleMark()
+		b.move(cursor, offset, n, 1)
+	case 'r':
+		b.startRestore()
+	}
+}
+
+// open opens the snapshot or directory under the cursor.
+func (b *browser) open() {
+	dir := b.current()
+	if dir == nil {
+		if len(b.snapshots) == 0 {
+			return
+		}
+		if err := b.openSnapshot(b.snapshots[b.snapshotCursor]); err != nil {
+			b.message = fmt.Sprintf("error: %v", err)
+		}
+		return
+	}
+
+	nodepath, node := b.selected()
+	if node == nil || node.Type != "dir" || node.Subtree == nil {
+		return
+	}
+
+	sub, err := b.loadDir(nodepath, *node.Subtree)
+	if err != nil {
+		b.message = fmt.Sprintf("error: %v", err)
+		return
+	}
+	b.dirs = append(b.dirs, sub)
+}
+
+// back returns to the parent directory, or to the list of snapshots.
+func (b *browser) back() {
+	switch len(b.dirs) {
+	case 0:
+		return
+	case 1:
+		if len(b.marked) > 0 {
+			b.message = fmt.Sprintf("discarded the marks of %d items", len(b.marked))
+		}
+		b.snapshot = nil
+		b.dirs = nil
+		b.marked = nil
+	default:
+		b.dirs = b.dirs[:len(b.dirs)-1]
+	}
+}
+
+// toggleMark marks or unmarks the item under the cursor for restore.
+func (b *browser) toggleMark() {
+	nodepath, node := b.selected()
+	if node == nil {
+		return
+	}
+
+	if b.marked[nodepath] {
+		delete(b.marked, nodepath)
+	} else {
+		b.marked[nodepath] = true
+	}
+}
+
+// startRestore asks for the target directory for restoring the marked
+// items, or the item under the cursor if nothing is marked.
+func (b *browser) startRestore() {
+	if b.snapshot == nil {
+		return
+	}
+
+	if len(b.marked) == 0 {
+		if _, node := b.selected(); node == nil {
+			return
+		}
+	}
+
+	b.prompting = true
+	b.input = "restore-" + b.snapshot.ID().Str()
+}
+
+func (b *browser) handlePromptKey(key browseKey) {
+	switch key {
+	case keyInterrupt, keyEscape:
+		b.prompting = false
+	case keyBackspace:
+		if r := []rune(b.input); len(r) > 0 {
+			b.input = string(r[:len(r)-1])
+		}
+	case keyEnter:
+		if b.input == "" {
+			return
+		}
+		b.prompting = false
+
+		paths := b.markedPaths()
+		if len(paths) == 0 {
+			nodepath, _ := b.selected()
+			paths = []string{nodepath}
+		}
+
+		b.restore = &browseRestore{
+			snapshot: b.snapshot,
+			paths:    paths,
+			target:   b.input,
+		}
+	default:
+		if key >= ' ' {
+			b.input += string(rune(key))
+		}
+	}
+}
+
+// render returns the lines of the screen for a terminal of the given size.
+func (b *browser) render(width, height int) []string {
+	b.height = height
+
+	var header string
+	var items []string
+	var cursor, offset int
+	var details string
+
+	if dir := b.current(); dir != nil {
+		header = fmt.Sprintf("snapshot %s of %s: %s", b.snapshot.ID().Str(), b.snapshot.Hostname, dir.path)
+		for _, node := range dir.nodes {
+			mark := " "
+			if b.marked[path.Join(dir.path, node.Name)] {
+				mark = "*"
+			}
+			name := node.Name
+			if node.Type == "dir" {
+				name += "/"
+			}
+			items = append(items, fmt.Sprintf("%s %s", mark, name))
+		}
+		cursor, offset = dir.cursor, dir.offset
+
+		if nodepath, node := b.selected(); node != nil {
+			details = formatNode(nodepath, node, true)
+		}
+	} else {
+		header = fmt.Sprintf("%d snapshots", len(b.snapshots))
+		for _, sn := range b.snapshots {
+			items = append(items, fmt.Sprintf("  %s  %s  %s  %s",
+				sn.ID().Str(), sn.Time.Local().Format(TimeFormat), sn.Hostname, strings.Join(sn.Paths, ", ")))
+		}
+		cursor, offset = b.snapshotCursor, b.snapshotOffset
+	}
+
+	lines := []string{truncate(header, width)}
+	for i := offset; i < offset+b.listHeight(); i++ {
+		if i >= len(items) {
+			lines = append(lines, "")
+			continue
+		}
+
+		line := truncate(items[i], width)
+		if i == cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, truncate(details, width))
+
+	switch {
+	case b.prompting:
+		lines = append(lines, truncate("restore to: "+b.input, width))
+	case b.message != "":
+		lines = append(lines, truncate(b.message, width))
+	case b.snapshot != nil:
+		lines = append(lines, truncate(fmt.Sprintf("%d marked | enter: open  backspace: back  space: mark  r: restore  q: quit", len(b.marked)), width))
+	default:
+		lines = append(lines, truncate("enter: open  q: quit", width))
+	}
+
+	return lines
+}
+
+// truncate shortens s to at most width runes.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width])
+}
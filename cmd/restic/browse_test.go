@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type testTreeLoader map[restic.ID]*restic.Tree
+
+func (l testTreeLoader) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	tree, ok := l[id]
+	if !ok {
+		return nil, errors.Errorf("tree %v not found", id.Str())
+	}
+	return tree, nil
+}
+
+func TestParseKeys(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		keys  []browseKey
+	}{
+		{"q", []browseKey{'q'}},
+		{"\x1b[A\x1b[B", []browseKey{keyUp, keyDown}},
+		{"\x1bOC\x1b[D", []browseKey{keyRight, keyLeft}},
+		{"\x1b[5~\x1b[6~", []browseKey{keyPageUp, keyPageDown}},
+		{"ab\r", []browseKey{'a', 'b', keyEnter}},
+		{"\x7f\x03", []browseKey{keyBackspace, keyInterrupt}},
+		{"\x1b", []browseKey{keyEscape}},
+		{"ä", []browseKey{'ä'}},
+	} {
+		rtest.Equals(t, test.keys, parseKeys([]byte(test.input)))
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	loader := testTreeLoader{}
+
+	subtree := restic.NewTree()
+	rtest.OK(t, subtree.Insert(&restic.Node{Name: "file2", Type: "file", Size: 23}))
+	subID := restic.NewRandomID()
+	loader[subID] = subtree
+
+	root := restic.NewTree()
+	rtest.OK(t, root.Insert(&restic.Node{Name: "dir", Type: "dir", Subtree: &subID}))
+	rtest.OK(t, root.Insert(&restic.Node{Name: "file1", Type: "file", Size: 42}))
+	rootID := restic.NewRandomID()
+	loader[rootID] = root
+
+	older, err := restic.NewSnapshot([]string{"/old"}, nil, "host", time.Unix(1000, 0))
+	rtest.OK(t, err)
+	newer, err := restic.NewSnapshot([]string{"/new"}, nil, "host", time.Unix(2000, 0))
+	rtest.OK(t, err)
+	newer.Tree = &rootID
+
+	b := newBrowser(context.TODO(), loader, restic.Snapshots{older, newer})
+
+	// the newest snapshot is shown first
+	lines := b.render(80, 10)
+	rtest.Equals(t, 9, len(lines))
+	rtest.Assert(t, strings.Contains(lines[1], "/new"), "newest snapshot not listed first: %q", lines[1])
+
+	// open the snapshot and the directory
+	b.handleKey(keyEnter)
+	rtest.Equals(t, newer, b.snapshot)
+	b.handleKey(keyEnter)
+	rtest.Equals(t, "/dir", b.current().path)
+
+	// mark the file in the directory and go back
+	b.handleKey(' ')
+	rtest.Equals(t, []string{"/dir/file2"}, b.markedPaths())
+	b.handleKey(keyBackspace)
+	rtest.Equals(t, "/", b.current().path)
+
+	// the metadata of the selected item is shown
+	b.handleKey(keyDown)
+	lines = b.render(80, 10)
+	rtest.Assert(t, strings.Contains(lines[len(lines)-2], "/file1"), "metadata of file1 not shown: %q", lines[len(lines)-2])
+
+	// restore the marked file
+	b.handleKey('r')
+	rtest.Assert(t, b.prompting, "no prompt for the target directory")
+	b.input = "/tmp"
+	for _, key := range parseKeys([]byte("/x\r")) {
+		b.handleKey(key)
+	}
+	rtest.Assert(t, b.restore != nil, "no restore requested")
+	rtest.Equals(t, []string{"/dir/file2"}, b.restore.paths)
+	rtest.Equals(t, newer, b.restore.snapshot)
+	rtest.Equals(t, "/tmp/x", b.restore.target)
+
+	// leaving the snapshot discards the marks
+	b.handleKey(keyLeft)
+	rtest.Assert(t, b.snapshot == nil, "snapshot still open")
+	rtest.Assert(t, b.marked == nil, "marks were not discarded")
+
+	b.handleKey('q')
+	rtest.Assert(t, b.quit, "browser did not quit")
+}
@@ -66,9 +66,9 @@ func CleanupHandler(c <-chan os.Signal) {
 		code := 0
 
 		if s == syscall.SIGINT {
-			code = 130
+			code = exitCodeInterrupted
 		} else {
-			code = 1
+			code = exitCodeError
 		}
 
 		Exit(code)
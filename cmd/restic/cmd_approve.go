@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdApprove = &cobra.Command{
+	Use:   "approve operation [key ID]",
+	Short: "Approve a destructive operation for another key",
+	Long: `
+The "approve" command creates a token which allows a destructive operation
+when the repository requires the approval of a second key. The requirement is
+enabled with "restic config set require-approval yes". The following
+operations can be approved:
+
+  prune              "prune" and "forget --prune"
+  forget             "forget" without "--prune"
+  key-remove         "key remove", the ID of the key must be given
+  repair-snapshots   "repair snapshots --forget"
+  config             "config set require-approval"
+
+The token is printed to stdout and must be passed with --approval to the
+command, which has to be run with a different key than the one which created
+the token. The token is valid for the duration given with --valid-for and can
+only be used once, its use is recorded in the audit log. A token for
+"key-remove" only allows removing the key given to "approve".
+
+Commands which rewrite snapshots, like "tag", do not require an approval.
+
+Approvals are checked by restic itself and protect against mistakes of a
+single operator. All keys share the same master key, so they cannot prevent
+someone with two passwords, or a modified client, from removing data.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runApprove(approveOptions, globalOptions, args)
+	},
+}
+
+// ApproveOptions bundles all options for the approve command.
+type ApproveOptions struct {
+	ValidFor time.Duration
+}
+
+var approveOptions ApproveOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdApprove)
+
+	f := cmdApprove.Flags()
+	f.DurationVar(&approveOptions.ValidFor, "valid-for", time.Hour, "the token expires after `duration`")
+}
+
+// approvalOperations lists the operations which require an approval.
+var approvalOperations = []string{"prune", "forget", "key-remove", "repair-snapshots", "config"}
+
+// approval is the content of an approval token. The random ID is recorded in
+// the audit log when the token is used, Target is the ID of the key which may
+// be removed for "key-remove".
+type approval struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target,omitempty"`
+	KeyID     string    `json:"key"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+// newApprovalID returns a random ID for an approval token.
+func newApprovalID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// encodeApproval encrypts and authenticates a with the master key, so that
+// the token can only be created and read with a key for the repository.
+func encodeApproval(key *crypto.Key, a approval) (string, error) {
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return "", errors.Wrap(err, "Marshal")
+	}
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := make([]byte, 0, len(nonce)+len(buf)+key.Overhead())
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = key.Seal(ciphertext, nonce, buf, nil)
+
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// decodeApproval returns the approval contained in token.
+func decodeApproval(key *crypto.Key, token string) (approval, error) {
+	var a approval
+
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return a, err
+	}
+	if len(buf) < key.NonceSize() {
+		return a, errors.New("token is too short")
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return a, err
+	}
+
+	err = json.Unmarshal(plaintext, &a)
+	return a, errors.Wrap(err, "Unmarshal")
+}
+
+// requireApproval returns an error if the repository requires approvals and
+// the token passed with --approval does not allow the operation on target,
+// which is the ID of the key for "key-remove" and empty otherwise. The use of
+// the token is recorded in the audit log, a token which was already used is
+// rejected.
+func requireApproval(gopts GlobalOptions, repo *repository.Repository, operation, target string) error {
+	if !repo.Config().RequireApproval {
+		return nil
+	}
+
+	if gopts.Approval == "" {
+		return errors.Fatalf("the repository requires the approval of a second key, create a token with \"restic approve %v\" using another key and pass it with --approval", operation)
+	}
+
+	a, err := decodeApproval(repo.Key(), gopts.Approval)
+	if err != nil {
+		return errors.Fatalf("invalid approval token: %v", err)
+	}
+
+	switch {
+	case a.ID == "":
+		return errors.Fatal("the approval token has no ID, create a new one")
+	case a.Operation != operation:
+		return errors.Fatalf("the approval token is for %q, not for %q", a.Operation, operation)
+	case !time.Now().Before(a.Expires):
+		return errors.Fatalf("the approval token expired on %v", a.Expires.Local().Format(TimeFormat))
+	case a.Target != target:
+		return errors.Fatalf("the approval token is for %q, not for %q", a.Target, target)
+	case a.KeyID == repo.KeyName():
+		return errors.Fatal("the approval token must be created with a different key")
+	}
+
+	k, err := repository.LoadKey(gopts.ctx, repo, a.KeyID)
+	if err != nil {
+		return errors.Fatalf("the key which created the approval token cannot be loaded: %v", err)
+	}
+	if !k.IsAdmin() {
+		return errors.Fatalf("the approval token was created with a key of scope %q", k.Scope)
+	}
+
+	entries, err := loadAuditEntries(gopts.ctx, repo)
+	if err != nil {
+		return errors.Fatalf("unable to check whether the approval token was used: %v", err)
+	}
+	for _, e := range entries {
+		if e.Approval == a.ID {
+			return errors.Fatalf("the approval token was already used on %v", e.Time.Local().Format(TimeFormat))
+		}
+	}
+
+	details := fmt.Sprintf("use approval %v for %v by key %v", a.ID, operation, a.KeyID)
+	if target != "" {
+		details += " on " + target
+	}
+	e := restic.NewAuditEntry(repo.KeyName(), "approval", []string{details})
+	e.Approval = a.ID
+	if err = saveAuditEntry(gopts.ctx, repo, e); err != nil {
+		return errors.Fatalf("unable to record the use of the approval token: %v", err)
+	}
+
+	Verbosef("%v approved by key %v\n", operation, a.KeyID[:8])
+	return nil
+}
+
+func runApprove(opts ApproveOptions, gopts GlobalOptions, args []string) error {
+	if len(args) < 1 || (args[0] == "key-remove" && len(args) != 2) || (args[0] != "key-remove" && len(args) != 1) {
+		return errors.Fatal("wrong number of arguments, \"key-remove\" needs the ID of the key")
+	}
+
+	operation := args[0]
+	valid := false
+	for _, op := range approvalOperations {
+		if op == operation {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errors.Fatalf("invalid operation %q, valid are %s", operation, strings.Join(approvalOperations, ", "))
+	}
+
+	if opts.ValidFor <= 0 {
+		return errors.Fatal("--valid-for must be positive")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = requireAdminKey(gopts, repo, "approve"); err != nil {
+		return err
+	}
+
+	var target string
+	if operation == "key-remove" {
+		id, err := restic.Find(repo.Backend(), restic.KeyFile, args[1])
+		if err != nil {
+			return err
+		}
+		target = id
+	}
+
+	approvalID, err := newApprovalID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	a := approval{
+		ID:        approvalID,
+		Operation: operation,
+		Target:    target,
+		KeyID:     repo.KeyName(),
+		Created:   now,
+		Expires:   now.Add(opts.ValidFor),
+	}
+
+	token, err := encodeApproval(repo.Key(), a)
+	if err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("approve %v (approval %v) until %v", operation, a.ID, a.Expires.Format(time.RFC3339))
+	if target != "" {
+		details = fmt.Sprintf("approve %v of %v (approval %v) until %v", operation, target, a.ID, a.Expires.Format(time.RFC3339))
+	}
+	auditLog(gopts.ctx, repo, "approve", nil, details)
+
+	Println(token)
+	return nil
+}
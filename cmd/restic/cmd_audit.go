@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdAudit = &cobra.Command{
+	Use:   "audit log",
+	Short: "Show the log of destructive operations",
+	Long: `
+The "audit log" command shows the audit log of the repository. restic records
+an entry when snapshots are removed by "forget" or "repair snapshots --forget",
+when "prune" removes pack files, when "tag" replaces a snapshot and when keys
+are removed or replaced. Each
+entry contains the time, the user and host, the ID of the key which was used
+and what was removed.
+
+The entries are stored encrypted in separate files in the repository, so they
+can only be written and read with a key for the repository. Someone with
+direct access to the storage can still delete them.
+
+The entries are stored in the new directory "audit", which rest-server does
+not accept, so no audit log is recorded for repositories on rest-server. The
+commands "replicate" and "copy" do not transfer the audit log.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAudit(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdAudit)
+}
+
+// auditLog records a destructive operation in the audit log of the
+// repository, snapshots lists the IDs of removed or replaced snapshots.
+// Errors are only reported, a backend which cannot store the audit log must
+// not prevent the operation.
+func auditLog(ctx context.Context, repo restic.Repository, command string, snapshots restic.IDs, details ...string) {
+	var keyID string
+	if r, ok := repo.(*repository.Repository); ok {
+		keyID = r.KeyName()
+	}
+
+	e := restic.NewAuditEntry(keyID, command, details)
+	e.Snapshots = snapshots
+
+	if err := saveAuditEntry(ctx, repo, e); err != nil {
+		Warnf("unable to save audit log entry: %v\n", err)
+	}
+}
+
+// saveAuditEntry signs e if a signing command is configured and stores it in
+// the repository. A failure to sign is only reported.
+func saveAuditEntry(ctx context.Context, repo restic.Repository, e *restic.AuditEntry) error {
+	if globalOptions.SignCommand != "" {
+		if err := signAuditEntry(ctx, e); err != nil {
+			Warnf("unable to sign audit log entry: %v\n", err)
+		}
+	}
+
+	return restic.SaveAuditEntry(ctx, repo, e)
+}
+
+func signAuditEntry(ctx context.Context, e *restic.AuditEntry) error {
+	sign, err := signCommand(globalOptions.SignCommand)
+	if err != nil {
+		return err
+	}
+
+	data, err := e.SignedData()
+	if err != nil {
+		return err
+	}
+
+	e.Signature, err = sign(ctx, data)
+	return err
+}
+
+// loadAuditEntries returns all audit entries in the repository, sorted by
+// time.
+func loadAuditEntries(ctx context.Context, repo restic.Repository) ([]*restic.AuditEntry, error) {
+	var entries []*restic.AuditEntry
+	err := repo.List(ctx, restic.AuditFile, func(id restic.ID, size int64) error {
+		e, err := restic.LoadAuditEntry(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+
+	// repositories without any entry may not have the directory
+	if err != nil && !repo.Backend().IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+func runAudit(gopts GlobalOptions, args []string) error {
+	if len(args) != 1 || args[0] != "log" {
+		return errors.Fatal("usage: restic audit log")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := loadAuditEntries(gopts.ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		type jsonEntry struct {
+			*restic.AuditEntry
+			ID *restic.ID `json:"id"`
+		}
+
+		list := []jsonEntry{}
+		for _, e := range entries {
+			list = append(list, jsonEntry{AuditEntry: e, ID: e.ID()})
+		}
+		return json.NewEncoder(gopts.stdout).Encode(list)
+	}
+
+	for _, e := range entries {
+		keyID := e.KeyID
+		if len(keyID) > 8 {
+			keyID = keyID[:8]
+		}
+
+		Printf("%v  %v@%v  key %v  %v\n", e.Time.Local().Format(TimeFormat), e.Username, e.Hostname, keyID, e.Command)
+		for _, d := range e.Details {
+			Printf("    %v\n", d)
+		}
+	}
+
+	return nil
+}
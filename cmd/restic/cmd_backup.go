@@ -12,12 +12,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	tomb "gopkg.in/tomb.v2"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -39,10 +41,9 @@ given as the arguments.
 EXIT STATUS
 ===========
 
-Exit status is 0 if the command was successful, and non-zero if there was any error.
-
-Note that some issues such as unreadable or deleted files during backup
-currently doesn't result in a non-zero error exit status.
+Exit status is 0 if the command was successful, 3 if the snapshot was created
+but some source files could not be read, and non-zero for any other error. See
+the documentation for the list of exit codes.
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if backupOptions.Host == "" {
@@ -68,7 +69,15 @@ currently doesn't result in a non-zero error exit status.
 		term := termstatus.New(globalOptions.stdout, globalOptions.stderr, globalOptions.Quiet)
 		t.Go(func() error { term.Run(t.Context(globalOptions.ctx)); return nil })
 
-		err := runBackup(backupOptions, globalOptions, term, args)
+		gopts := globalOptions
+		m, err := newMetrics(gopts, "backup")
+		if err != nil {
+			return err
+		}
+		gopts.metrics = m
+
+		err = runBackup(backupOptions, gopts, term, args)
+		err = gopts.metrics.Finish(gopts, err)
 		if err != nil {
 			return err
 		}
@@ -95,6 +104,7 @@ type BackupOptions struct {
 	TimeStamp           string
 	WithAtime           bool
 	IgnoreInode         bool
+	Chain               bool
 }
 
 var backupOptions BackupOptions
@@ -123,6 +133,7 @@ func init() {
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.BoolVar(&backupOptions.Chain, "chain", false, "store the ID of the previous snapshot of the same host and paths in the new snapshot, for verify-chain")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -422,6 +433,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	if err != nil {
 		return err
 	}
+	gopts.metrics.SetRepository(repo)
 
 	type ArchiveProgressReporter interface {
 		CompleteItem(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration)
@@ -471,6 +483,22 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	t.Go(func() error { return p.Run(t.Context(gopts.ctx)) })
 
+	statusOut, err := openStatusWriter(gopts)
+	if err != nil {
+		return err
+	}
+
+	// status receives the JSON progress messages for --status-fd and
+	// --status-socket in addition to p
+	var status *json.Backup
+	if statusOut != nil {
+		statusTerm, stop := startStatusTerminal(gopts.ctx, statusOut)
+		defer stop()
+
+		status = json.NewBackup(statusTerm, gopts.verbosity)
+		t.Go(func() error { return status.Run(t.Context(gopts.ctx)) })
+	}
+
 	if !gopts.JSON {
 		p.V("lock repository")
 	}
@@ -480,6 +508,22 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	var quota *backend.QuotaBackend
+	if maxSize := repo.Config().MaxRepoSize; maxSize > 0 {
+		used, err := backend.Size(gopts.ctx, repo.Backend())
+		if err != nil {
+			return err
+		}
+		if used >= maxSize {
+			return &backend.QuotaExceededError{Quota: maxSize, Used: used}
+		}
+
+		if !gopts.JSON {
+			p.V("repository uses %s of the quota of %s", formatBytes(used), formatBytes(maxSize))
+		}
+		quota = repo.UseQuota(maxSize, used)
+	}
+
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
 	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, targets)
 	if err != nil {
@@ -563,6 +607,79 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	arch.CompleteBlob = p.CompleteBlob
 	arch.IgnoreInode = opts.IgnoreInode
 
+	if status != nil {
+		sc.Error = func(item string, fi os.FileInfo, err error) error {
+			_ = status.ScannerError(item, fi, err)
+			return p.ScannerError(item, fi, err)
+		}
+		sc.Result = func(item string, s archiver.ScanStats) {
+			status.ReportTotal(item, s)
+			p.ReportTotal(item, s)
+		}
+		arch.Error = func(item string, fi os.FileInfo, err error) error {
+			_ = status.Error(item, fi, err)
+			return p.Error(item, fi, err)
+		}
+		arch.CompleteItem = func(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration) {
+			status.CompleteItem(item, previous, current, s, d)
+			p.CompleteItem(item, previous, current, s, d)
+		}
+		arch.StartFile = func(filename string) {
+			status.StartFile(filename)
+			p.StartFile(filename)
+		}
+		arch.CompleteBlob = func(filename string, bytes uint64) {
+			status.CompleteBlob(filename, bytes)
+			p.CompleteBlob(filename, bytes)
+		}
+	}
+
+	// the errors are counted to set the exit code
+	var stats backupStats
+	scannerError := sc.Error
+	sc.Error = func(item string, fi os.FileInfo, err error) error {
+		stats.addError()
+		gopts.metrics.AddError(item, err)
+		logf("warning", "error for %v: %v", item, err)
+		return scannerError(item, fi, err)
+	}
+	archiverError := arch.Error
+	arch.Error = func(item string, fi os.FileInfo, err error) error {
+		stats.addError()
+		gopts.metrics.AddError(item, err)
+		logf("warning", "error for %v: %v", item, err)
+		return archiverError(item, fi, err)
+	}
+
+	if gopts.metrics != nil || runLog != nil || systemd != nil {
+		completeItem := arch.CompleteItem
+		arch.CompleteItem = func(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration) {
+			stats.completeItem(previous, current, s)
+			completeItem(item, previous, current, s, d)
+		}
+		completeBlob := arch.CompleteBlob
+		arch.CompleteBlob = func(filename string, bytes uint64) {
+			stats.completeBlob(bytes)
+			completeBlob(filename, bytes)
+		}
+	}
+
+	if systemd != nil {
+		t.Go(func() error {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-t.Dying():
+					return nil
+				case <-ticker.C:
+					systemd.Status(stats.status())
+				}
+			}
+		})
+	}
+
 	if parentSnapshotID == nil {
 		parentSnapshotID = &restic.ID{}
 	}
@@ -573,6 +690,14 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		Time:           timeStamp,
 		Hostname:       opts.Host,
 		ParentSnapshot: *parentSnapshotID,
+		Chain:          opts.Chain,
+	}
+
+	if gopts.SignCommand != "" {
+		snapshotOpts.Sign, err = signCommand(gopts.SignCommand)
+		if err != nil {
+			return err
+		}
 	}
 
 	uploader := archiver.IndexUploader{
@@ -597,6 +722,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		p.V("start backup on %v", targets)
 	}
 	_, id, err := arch.Snapshot(gopts.ctx, targets, snapshotOpts)
+	stats.report(gopts.metrics)
+	if quota != nil && quota.Err() != nil {
+		// the data saved so far is removed by the next prune run
+		return quota.Err()
+	}
 	if err != nil {
 		return errors.Fatalf("unable to save snapshot: %v", err)
 	}
@@ -609,10 +739,97 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	// Report finished execution
 	p.Finish(id)
+	if status != nil {
+		status.Finish(id)
+	}
 	if !gopts.JSON {
 		p.P("snapshot %s saved\n", id.Str())
 	}
+	logf("info", "snapshot %s saved", id.Str())
 
 	// Return error if any
-	return err
+	if err != nil {
+		return err
+	}
+
+	if stats.numErrors() > 0 {
+		return withExitCode(exitCodeSkippedFiles, errors.Fatal("at least one source file could not be read"))
+	}
+
+	return nil
+}
+
+// backupStats counts the items reported in the metrics, the log and the
+// systemd status of backup.
+type backupStats struct {
+	m               sync.Mutex
+	filesNew        uint
+	filesChanged    uint
+	filesUnmodified uint
+	errors          uint
+	bytesAdded      uint64
+	filesDone       uint
+	bytesDone       uint64
+}
+
+func (s *backupStats) addError() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.errors++
+}
+
+func (s *backupStats) completeItem(previous, current *restic.Node, is archiver.ItemStats) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.bytesAdded += is.DataSize + is.TreeSize
+	if current == nil || current.Type != "file" {
+		return
+	}
+
+	s.filesDone++
+
+	switch {
+	case previous == nil:
+		s.filesNew++
+	case previous.Equals(*current):
+		s.filesUnmodified++
+	default:
+		s.filesChanged++
+	}
+}
+
+func (s *backupStats) numErrors() uint {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.errors
+}
+
+func (s *backupStats) completeBlob(bytes uint64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.bytesDone += bytes
+}
+
+// status returns a short description of the progress.
+func (s *backupStats) status() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return fmt.Sprintf("backup: %d files, %s processed, %d errors", s.filesDone, formatBytes(s.bytesDone), s.errors)
+}
+
+// report records the counters in m.
+func (s *backupStats) report(m *metrics) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	m.Set("files_new", "Number of new files in the last run.", float64(s.filesNew))
+	m.Set("files_changed", "Number of changed files in the last run.", float64(s.filesChanged))
+	m.Set("files_unmodified", "Number of unmodified files in the last run.", float64(s.filesUnmodified))
+	m.Set("errors", "Number of errors in the last run.", float64(s.errors))
+	m.Set("added_bytes", "Number of bytes added to the repository in the last run.", float64(s.bytesAdded))
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restorer"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var cmdBrowse = &cobra.Command{
+	Use:   "browse [flags] [snapshotID]",
+	Short: "Browse snapshots interactively and restore files",
+	Long: `
+The "browse" command shows the snapshots in the repository in an interactive
+terminal interface. Snapshots and directories are opened with enter or the
+right arrow key, backspace or the left arrow key returns to the parent
+directory. The metadata of the selected file is shown below the list. Files
+and directories are marked with space, and "r" restores the marked items, or
+the selected item if nothing is marked, to a directory. "q" quits.
+
+If a snapshot ID is given, the snapshot is opened directly. The special
+snapshot ID "latest" opens the latest snapshot.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBrowse(browseOptions, globalOptions, args)
+	},
+}
+
+// BrowseOptions collects all options for the browse command.
+type BrowseOptions struct {
+	Hosts []string
+	Tags  restic.TagLists
+	Paths []string
+}
+
+var browseOptions BrowseOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdBrowse)
+
+	flags := cmdBrowse.Flags()
+	flags.StringArrayVarP(&browseOptions.Hosts, "host", "H", nil, "only show snapshots for this `host` (can be specified multiple times)")
+	flags.Var(&browseOptions.Tags, "tag", "only show snapshots which include this `taglist` (can be specified multiple times)")
+	flags.StringArrayVar(&browseOptions.Paths, "path", nil, "only show snapshots which include this (absolute) `path` (can be specified multiple times)")
+}
+
+func runBrowse(opts BrowseOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 1 {
+		return errors.Fatalf("more than one snapshot ID specified: %v", args)
+	}
+
+	if gopts.JSON {
+		return errors.Fatal("browse does not support --json")
+	}
+
+	if !stdinIsTerminal() || !stdoutIsTerminal() {
+		return errors.Fatal("browse needs a terminal, use ls and restore in scripts")
+	}
+
+	ctx := gopts.ctx
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, nil) {
+		snapshots = append(snapshots, sn)
+	}
+
+	b := newBrowser(ctx, repo, snapshots)
+
+	if len(args) == 1 {
+		var id restic.ID
+		if args[0] == "latest" {
+			id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+		} else {
+			id, err = restic.FindSnapshot(repo, args[0])
+		}
+		if err != nil {
+			return errors.Fatalf("invalid snapshot ID %q: %v", args[0], err)
+		}
+
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		if err = b.openSnapshot(sn); err != nil {
+			return err
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return errors.Fatalf("unable to set up the terminal: %v", err)
+	}
+	defer func() {
+		_ = terminal.Restore(fd, state)
+	}()
+
+	// use the alternate screen, so that the previous content of the terminal
+	// is shown again when browse exits
+	fmt.Fprint(gopts.stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(gopts.stdout, "\x1b[?25h\x1b[?1049l")
+
+	buf := make([]byte, 64)
+	for !b.quit {
+		drawBrowser(gopts.stdout, b)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			b.handleKey(key)
+
+			if b.restore != nil {
+				req := b.restore
+				b.restore = nil
+
+				b.message = fmt.Sprintf("restoring %d items to %s", len(req.paths), req.target)
+				drawBrowser(gopts.stdout, b)
+				b.message = runBrowseRestore(ctx, repo, req)
+			}
+		}
+	}
+
+	return nil
+}
+
+// drawBrowser writes the screen of b to the terminal wr.
+func drawBrowser(wr io.Writer, b *browser) {
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	lines := b.render(width, height)
+
+	// move to the top left corner and clear each line after writing it
+	fmt.Fprint(wr, "\x1b[H"+strings.Join(lines, "\x1b[K\r\n")+"\x1b[K\x1b[J")
+}
+
+// runBrowseRestore restores the items selected in the browser and returns a
+// message for the user.
+func runBrowseRestore(ctx context.Context, repo *repository.Repository, req *browseRestore) string {
+	debug.Log("restore %v from %v to %v", req.paths, req.snapshot.ID(), req.target)
+
+	res, err := restorer.NewRestorer(repo, *req.snapshot.ID())
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	res.CheckSpace = true
+	res.SelectFilter = newFilesFromFilter(req.paths)
+
+	totalErrors := 0
+	res.Error = func(location string, err error) error {
+		debug.Log("error for %v: %v", location, err)
+		totalErrors++
+		return nil
+	}
+
+	err = res.RestoreTo(ctx, req.target)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("restore failed: %v", err)
+	case totalErrors > 0:
+		return fmt.Sprintf("restored %d items to %s with %d errors, run restore for details", len(req.paths), req.target, totalErrors)
+	default:
+		return fmt.Sprintf("restored %d items to %s", len(req.paths), req.target)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
 )
@@ -20,6 +22,12 @@ var cmdCache = &cobra.Command{
 	Long: `
 The "cache" command allows listing and cleaning local cache directories.
 
+The cache stores index files, snapshots and pack files containing trees, so
+that they do not need to be downloaded from the repository again. With
+--max-size, the least recently used files are removed until the total size of
+all cache directories is below the limit. With --stats, the number and size of
+the cached files are shown by type.
+
 EXIT STATUS
 ===========
 
@@ -31,11 +39,13 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 	},
 }
 
-// CacheOptions bundles all options for the snapshots command.
+// CacheOptions bundles all options for the cache command.
 type CacheOptions struct {
 	Cleanup bool
 	MaxAge  uint
+	MaxSize string
 	NoSize  bool
+	Stats   bool
 }
 
 var cacheOptions CacheOptions
@@ -46,7 +56,9 @@ func init() {
 	f := cmdCache.Flags()
 	f.BoolVar(&cacheOptions.Cleanup, "cleanup", false, "remove old cache directories")
 	f.UintVar(&cacheOptions.MaxAge, "max-age", 30, "max age in `days` for cache directories to be considered old")
+	f.StringVar(&cacheOptions.MaxSize, "max-size", "", "remove the least recently used files until the cache is smaller than `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&cacheOptions.NoSize, "no-size", false, "do not output the size of the cache directories")
+	f.BoolVar(&cacheOptions.Stats, "stats", false, "show the number and size of the cached files by type")
 }
 
 func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
@@ -70,30 +82,45 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
-	if opts.Cleanup || gopts.CleanupCache {
-		oldDirs, err := cache.OlderThan(cachedir, time.Duration(opts.MaxAge)*24*time.Hour)
-		if err != nil {
-			return err
+	if opts.Cleanup || gopts.CleanupCache || opts.MaxSize != "" {
+		var maxSize int64 = -1
+		if opts.MaxSize != "" {
+			maxSize, err = parseSizeStr(opts.MaxSize)
+			if err != nil {
+				return errors.Fatalf("invalid --max-size: %v", err)
+			}
 		}
 
-		if len(oldDirs) == 0 {
-			Verbosef("no old cache dirs found\n")
-			return nil
+		if opts.Cleanup || gopts.CleanupCache {
+			if err = cleanupOldCacheDirs(cachedir, time.Duration(opts.MaxAge)*24*time.Hour); err != nil {
+				return err
+			}
 		}
 
-		Verbosef("remove %d old cache directories\n", len(oldDirs))
-
-		for _, item := range oldDirs {
-			dir := filepath.Join(cachedir, item.Name())
-			err = fs.RemoveAll(dir)
+		if maxSize >= 0 {
+			removed, err := cache.Trim(cachedir, maxSize)
 			if err != nil {
-				Warnf("unable to remove %v: %v\n", dir, err)
+				return err
+			}
+
+			if removed.Count == 0 {
+				Verbosef("cache is smaller than %v\n", formatBytes(uint64(maxSize)))
+			} else {
+				Verbosef("removed %d least recently used files (%v)\n", removed.Count, formatBytes(uint64(removed.Size)))
 			}
 		}
 
 		return nil
 	}
 
+	if opts.Stats {
+		return printCacheStats(gopts, cachedir)
+	}
+
+	if gopts.JSON {
+		return printCacheDirsJSON(gopts, opts, cachedir)
+	}
+
 	tab := table.New()
 
 	type data struct {
@@ -154,6 +181,156 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
+// cleanupOldCacheDirs removes all cache directories in cachedir which were
+// not used within maxAge.
+func cleanupOldCacheDirs(cachedir string, maxAge time.Duration) error {
+	oldDirs, err := cache.OlderThan(cachedir, maxAge)
+	if err != nil {
+		return err
+	}
+
+	if len(oldDirs) == 0 {
+		Verbosef("no old cache dirs found\n")
+		return nil
+	}
+
+	Verbosef("remove %d old cache directories\n", len(oldDirs))
+
+	for _, item := range oldDirs {
+		dir := filepath.Join(cachedir, item.Name())
+		err = fs.RemoveAll(dir)
+		if err != nil {
+			Warnf("unable to remove %v: %v\n", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// cacheDir is printed by cache in JSON mode for each cache directory.
+type cacheDir struct {
+	ID       string    `json:"id"`
+	LastUsed time.Time `json:"last_used"`
+	Old      bool      `json:"old"`
+	Size     *int64    `json:"size,omitempty"`
+}
+
+// printCacheDirsJSON prints the cache directories as a JSON array.
+func printCacheDirsJSON(gopts GlobalOptions, opts CacheOptions, cachedir string) error {
+	dirs, err := cache.All(cachedir)
+	if err != nil {
+		return err
+	}
+
+	list := []cacheDir{}
+	for _, entry := range dirs {
+		d := cacheDir{
+			ID:       entry.Name(),
+			LastUsed: entry.ModTime(),
+			Old:      cache.IsOld(entry.ModTime(), time.Duration(opts.MaxAge)*24*time.Hour),
+		}
+
+		if !opts.NoSize {
+			size, err := dirSize(filepath.Join(cachedir, entry.Name()))
+			if err != nil {
+				return err
+			}
+			d.Size = &size
+		}
+
+		list = append(list, d)
+	}
+
+	return json.NewEncoder(gopts.stdout).Encode(list)
+}
+
+// cacheDirStats is printed by cache --stats in JSON mode for each cache
+// directory.
+type cacheDirStats struct {
+	ID    string                   `json:"id"`
+	Files map[string]cacheFileStat `json:"files"`
+}
+
+type cacheFileStat struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// printCacheStats prints the number and size of the cached files by type for
+// all cache directories.
+func printCacheStats(gopts GlobalOptions, cachedir string) error {
+	dirs, err := cache.All(cachedir)
+	if err != nil {
+		return err
+	}
+
+	types := []restic.FileType{restic.IndexFile, restic.SnapshotFile, restic.DataFile}
+
+	if gopts.JSON {
+		list := []cacheDirStats{}
+		for _, entry := range dirs {
+			stats, err := cache.DirStats(filepath.Join(cachedir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			d := cacheDirStats{ID: entry.Name(), Files: make(map[string]cacheFileStat)}
+			for _, t := range types {
+				d.Files[string(t)] = cacheFileStat{Count: stats[t].Count, Size: stats[t].Size}
+			}
+			list = append(list, d)
+		}
+
+		return json.NewEncoder(gopts.stdout).Encode(list)
+	}
+
+	if len(dirs) == 0 {
+		Printf("no cache dirs found, basedir is %v\n", cachedir)
+		return nil
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].ModTime().Before(dirs[j].ModTime())
+	})
+
+	type data struct {
+		ID    string
+		Type  string
+		Files string
+		Size  string
+	}
+
+	tab := table.New()
+	tab.AddColumn("Repo ID", "{{ .ID }}")
+	tab.AddColumn("Type", "{{ .Type }}")
+	tab.AddColumn("Files", "{{ .Files }}")
+	tab.AddColumn("Size", "{{ .Size }}")
+
+	var total cache.FileStats
+	for _, entry := range dirs {
+		stats, err := cache.DirStats(filepath.Join(cachedir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		for i, t := range types {
+			var id string
+			if i == 0 {
+				id = entry.Name()[:10]
+			}
+
+			tab.AddRow(data{id, string(t), fmt.Sprintf("%8d", stats[t].Count), fmt.Sprintf("%11s", formatBytes(uint64(stats[t].Size)))})
+			total.Count += stats[t].Count
+			total.Size += stats[t].Size
+		}
+	}
+
+	tab.Write(gopts.stdout)
+	Printf("%d files with %v in %d cache dirs in %s\n", total.Count, formatBytes(uint64(total.Size)), len(dirs), cachedir)
+
+	return nil
+}
+
 func dirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -26,10 +29,16 @@ finds. It can also be used to read all data and therefore simulate a restore.
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
 
+With --read-data-subset, only a part of the pack files is read. The subset is
+either selected as group n of m ("1/5"), as a random percentage of all pack
+files ("10%") or as random pack files up to a total size ("10G").
+
 EXIT STATUS
 ===========
 
-Exit status is 0 if the command was successful, and non-zero if there was any error.
+Exit status is 0 if the command was successful, 5 if the repository contains
+errors, and non-zero for any other error. See the documentation for the list of
+exit codes.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -55,7 +64,7 @@ func init() {
 
 	f := cmdCheck.Flags()
 	f.BoolVar(&checkOptions.ReadData, "read-data", false, "read all data blobs")
-	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read subset n of m data packs (format: `n/m`)")
+	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read a `subset` of data packs, specified as 'n/t' for a particular part of the data packs, as 'x%' for a random percentage or as a size like '10G' for random data packs up to that size")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
 }
@@ -65,19 +74,104 @@ func checkFlags(opts CheckOptions) error {
 		return errors.Fatalf("check flags --read-data and --read-data-subset cannot be used together")
 	}
 	if opts.ReadDataSubset != "" {
-		dataSubset, err := stringToIntSlice(opts.ReadDataSubset)
-		if err != nil || len(dataSubset) != 2 {
-			return errors.Fatalf("check flag --read-data-subset must have two positive integer values, e.g. --read-data-subset=1/2")
+		if _, err := parseDataSubset(opts.ReadDataSubset); err != nil {
+			return err
 		}
-		if dataSubset[0] == 0 || dataSubset[1] == 0 || dataSubset[0] > dataSubset[1] {
-			return errors.Fatalf("check flag --read-data-subset=n/t values must be positive integers, and n <= t, e.g. --read-data-subset=1/2")
+	}
+
+	return nil
+}
+
+// dataSubset describes which pack files are read by check. Exactly one of
+// the group (bucket and totalBuckets), percentage or size is set.
+type dataSubset struct {
+	bucket, totalBuckets uint
+	percentage           float64
+	size                 int64
+}
+
+// parseDataSubset parses the value of --read-data-subset, which is either
+// "n/t", a percentage like "2.5%" or a size like "10G".
+func parseDataSubset(s string) (dataSubset, error) {
+	switch {
+	case strings.Contains(s, "/"):
+		values, err := stringToIntSlice(s)
+		if err != nil || len(values) != 2 {
+			return dataSubset{}, errors.Fatalf("check flag --read-data-subset must have two positive integer values, e.g. --read-data-subset=1/2")
+		}
+		if values[0] == 0 || values[1] == 0 || values[0] > values[1] {
+			return dataSubset{}, errors.Fatalf("check flag --read-data-subset=n/t values must be positive integers, and n <= t, e.g. --read-data-subset=1/2")
+		}
+		if values[1] > totalBucketsMax {
+			return dataSubset{}, errors.Fatalf("check flag --read-data-subset=n/t t must be at most %d", totalBucketsMax)
 		}
-		if dataSubset[1] > totalBucketsMax {
-			return errors.Fatalf("check flag --read-data-subset=n/t t must be at most %d", totalBucketsMax)
+		return dataSubset{bucket: values[0], totalBuckets: values[1]}, nil
+
+	case strings.HasSuffix(s, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return dataSubset{}, errors.Fatalf("check flag --read-data-subset=x%% must be a percentage greater than 0 and at most 100, e.g. --read-data-subset=2.5%%")
+		}
+		return dataSubset{percentage: p}, nil
+
+	default:
+		size, err := parseSizeStr(s)
+		if err != nil || size == 0 {
+			return dataSubset{}, errors.Fatalf("check flag --read-data-subset must be n/t, a percentage or a positive size, e.g. --read-data-subset=10G")
 		}
+		return dataSubset{size: size}, nil
 	}
+}
 
-	return nil
+// selectPacksByBucket returns the packs in group bucket of totalBuckets.
+func selectPacksByBucket(allPacks restic.IDSet, bucket, totalBuckets uint) restic.IDSet {
+	packs := restic.NewIDSet()
+	for pack := range allPacks {
+		// If we ever check more than the first byte
+		// of pack, update totalBucketsMax.
+		if (uint(pack[0]) % totalBuckets) == (bucket - 1) {
+			packs.Insert(pack)
+		}
+	}
+	return packs
+}
+
+// shufflePacks returns the packs in random order.
+func shufflePacks(allPacks restic.IDSet) restic.IDs {
+	ids := allPacks.List()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return ids
+}
+
+// selectRandomPacksByPercentage returns a random selection of the given
+// percentage of packs. At least one pack is selected.
+func selectRandomPacksByPercentage(allPacks restic.IDSet, percentage float64) restic.IDSet {
+	count := int(math.Ceil(float64(len(allPacks)) * percentage / 100))
+
+	packs := restic.NewIDSet()
+	for _, id := range shufflePacks(allPacks)[:count] {
+		packs.Insert(id)
+	}
+	return packs
+}
+
+// selectRandomPacksBySize returns a random selection of packs with a total
+// size of at most maxSize.
+func selectRandomPacksBySize(allPacks restic.IDSet, packSizes map[restic.ID]int64, maxSize int64) restic.IDSet {
+	packs := restic.NewIDSet()
+	var total int64
+	for _, id := range shufflePacks(allPacks) {
+		size := packSizes[id]
+		if total+size > maxSize {
+			continue
+		}
+		total += size
+		packs.Insert(id)
+	}
+	return packs
 }
 
 // See doReadData in runCheck below for why this is 256.
@@ -124,6 +218,9 @@ func newReadProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
 	}
 
 	readProgress.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
+		if gopts.JSON {
+			return
+		}
 		fmt.Printf("\nduration: %s\n", formatDuration(d))
 	}
 
@@ -202,25 +299,31 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	Verbosef("load indexes\n")
 	hints, errs := chkr.LoadIndex(gopts.ctx)
 
+	printHint := Printf
+	if gopts.JSON {
+		printHint = Warnf
+	}
+
 	dupFound := false
 	for _, hint := range hints {
-		Printf("%v\n", hint)
+		printHint("%v\n", hint)
 		if _, ok := hint.(checker.ErrDuplicatePacks); ok {
 			dupFound = true
 		}
 	}
 
 	if dupFound {
-		Printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
+		printHint("This is non-critical, you can run `restic repair index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
 		for _, err := range errs {
 			Warnf("error: %v\n", err)
 		}
-		return errors.Fatal("LoadIndex returned errors")
+		return withExitCode(exitCodeRepositoryDamaged, errors.Fatal("LoadIndex returned errors"))
 	}
 
+	var summary checkSummary
 	errorsFound := false
 	orphanedPacks := 0
 	errChan := make(chan error)
@@ -235,9 +338,11 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 			continue
 		}
 		errorsFound = true
+		summary.addError(err)
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
 
+	summary.OrphanedPacks = orphanedPacks
 	if orphanedPacks > 0 {
 		Verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nYou can run `restic prune` to correct this.\n", orphanedPacks)
 	}
@@ -248,6 +353,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	for err := range errChan {
 		errorsFound = true
+		summary.addError(err)
 		if e, ok := err.(checker.TreeError); ok {
 			fmt.Fprintf(os.Stderr, "error for tree %v:\n", e.ID.Str())
 			for _, treeErr := range e.Errors {
@@ -262,25 +368,13 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		for _, id := range chkr.UnusedBlobs() {
 			Verbosef("unused blob %v\n", id.Str())
 			errorsFound = true
+			summary.UnusedBlobs++
 		}
 	}
 
-	doReadData := func(bucket, totalBuckets uint) {
-		packs := restic.IDSet{}
-		for pack := range chkr.GetPacks() {
-			// If we ever check more than the first byte
-			// of pack, update totalBucketsMax.
-			if (uint(pack[0]) % totalBuckets) == (bucket - 1) {
-				packs.Insert(pack)
-			}
-		}
+	doReadData := func(packs restic.IDSet) {
 		packCount := uint64(len(packs))
-
-		if packCount < chkr.CountPacks() {
-			Verbosef(fmt.Sprintf("read group #%d of %d data packs (out of total %d packs in %d groups)\n", bucket, packCount, chkr.CountPacks(), totalBuckets))
-		} else {
-			Verbosef("read all data\n")
-		}
+		summary.PacksRead += len(packs)
 
 		p := newReadProgress(gopts, restic.Stat{Blobs: packCount})
 		errChan := make(chan error)
@@ -289,23 +383,88 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 		for err := range errChan {
 			errorsFound = true
+			summary.addError(err)
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
 	}
 
 	switch {
 	case opts.ReadData:
-		doReadData(1, 1)
+		Verbosef("read all data\n")
+		doReadData(chkr.GetPacks())
 	case opts.ReadDataSubset != "":
-		dataSubset, _ := stringToIntSlice(opts.ReadDataSubset)
-		doReadData(dataSubset[0], dataSubset[1])
+		subset, _ := parseDataSubset(opts.ReadDataSubset)
+
+		var packs restic.IDSet
+		switch {
+		case subset.totalBuckets > 0:
+			packs = selectPacksByBucket(chkr.GetPacks(), subset.bucket, subset.totalBuckets)
+			Verbosef("read group #%d of %d data packs (out of total %d packs in %d groups)\n", subset.bucket, len(packs), chkr.CountPacks(), subset.totalBuckets)
+		case subset.percentage > 0:
+			packs = selectRandomPacksByPercentage(chkr.GetPacks(), subset.percentage)
+			Verbosef("read %.1f%% of data packs: %d of %d packs\n", subset.percentage, len(packs), chkr.CountPacks())
+		default:
+			packSizes := make(map[restic.ID]int64)
+			err = repo.List(gopts.ctx, restic.DataFile, func(id restic.ID, size int64) error {
+				packSizes[id] = size
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			packs = selectRandomPacksBySize(chkr.GetPacks(), packSizes, subset.size)
+			Verbosef("read %d of %d data packs with at most %v\n", len(packs), chkr.CountPacks(), formatBytes(uint64(subset.size)))
+		}
+		doReadData(packs)
+	}
+
+	if gopts.JSON {
+		summary.MessageType = "summary"
+		if err := json.NewEncoder(gopts.stdout).Encode(summary); err != nil {
+			return err
+		}
 	}
 
 	if errorsFound {
-		return errors.Fatal("repository contains errors")
+		return withExitCode(exitCodeRepositoryDamaged, errors.Fatal("repository contains errors"))
 	}
 
 	Verbosef("no errors were found\n")
 
 	return nil
 }
+
+// checkSummary is printed by check in JSON mode.
+type checkSummary struct {
+	MessageType   string `json:"message_type"` // "summary"
+	NumErrors     int    `json:"num_errors"`
+	OrphanedPacks int    `json:"orphaned_packs"`
+	UnusedBlobs   int    `json:"unused_blobs"`
+	PacksRead     int    `json:"packs_read"`
+
+	// ErrorCodes counts the errors found per error code.
+	ErrorCodes map[errors.Code]int `json:"error_codes,omitempty"`
+}
+
+// addError counts err in the summary. The errors collected for a tree are
+// counted as a single error, but each of them contributes its error code.
+func (s *checkSummary) addError(err error) {
+	s.NumErrors++
+
+	if s.ErrorCodes == nil {
+		s.ErrorCodes = make(map[errors.Code]int)
+	}
+
+	errs := []error{err}
+	if e, ok := err.(checker.TreeError); ok {
+		errs = e.Errors
+	}
+
+	for _, err := range errs {
+		if e, ok := err.(checker.Error); ok {
+			err = e.Err
+		}
+		s.ErrorCodes[errors.CodeOf(err)]++
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseDataSubset(t *testing.T) {
+	var tests = []struct {
+		input  string
+		subset dataSubset
+		err    bool
+	}{
+		{"1/5", dataSubset{bucket: 1, totalBuckets: 5}, false},
+		{"5/5", dataSubset{bucket: 5, totalBuckets: 5}, false},
+		{"10%", dataSubset{percentage: 10}, false},
+		{"2.5%", dataSubset{percentage: 2.5}, false},
+		{"100%", dataSubset{percentage: 100}, false},
+		{"10G", dataSubset{size: 10 * 1024 * 1024 * 1024}, false},
+		{"500m", dataSubset{size: 500 * 1024 * 1024}, false},
+		{"0/5", dataSubset{}, true},
+		{"6/5", dataSubset{}, true},
+		{"1/257", dataSubset{}, true},
+		{"1/2/3", dataSubset{}, true},
+		{"0%", dataSubset{}, true},
+		{"101%", dataSubset{}, true},
+		{"x%", dataSubset{}, true},
+		{"0", dataSubset{}, true},
+		{"foo", dataSubset{}, true},
+	}
+
+	for _, test := range tests {
+		subset, err := parseDataSubset(test.input)
+		if test.err {
+			rtest.Assert(t, err != nil, "expected error for %q", test.input)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.subset, subset)
+	}
+}
+
+func testPackSet(n int) restic.IDSet {
+	packs := restic.NewIDSet()
+	for i := 0; i < n; i++ {
+		packs.Insert(restic.NewRandomID())
+	}
+	return packs
+}
+
+func TestSelectPacksByBucket(t *testing.T) {
+	packs := testPackSet(100)
+
+	selected := restic.NewIDSet()
+	for bucket := uint(1); bucket <= 3; bucket++ {
+		group := selectPacksByBucket(packs, bucket, 3)
+		for id := range group {
+			rtest.Assert(t, !selected.Has(id), "pack %v selected in more than one group", id.Str())
+			selected.Insert(id)
+		}
+	}
+	rtest.Equals(t, packs, selected)
+}
+
+func TestSelectRandomPacksByPercentage(t *testing.T) {
+	packs := testPackSet(100)
+
+	for _, test := range []struct {
+		percentage float64
+		count      int
+	}{
+		{100, 100},
+		{10, 10},
+		{2.5, 3},
+		{0.1, 1},
+	} {
+		selected := selectRandomPacksByPercentage(packs, test.percentage)
+		rtest.Equals(t, test.count, len(selected))
+		rtest.Assert(t, packs.Intersect(selected).Equals(selected), "selected packs not in the list of packs")
+	}
+}
+
+func TestSelectRandomPacksBySize(t *testing.T) {
+	packs := testPackSet(100)
+	sizes := make(map[restic.ID]int64)
+	for id := range packs {
+		sizes[id] = 1000
+	}
+
+	selected := selectRandomPacksBySize(packs, sizes, 10500)
+	rtest.Equals(t, 10, len(selected))
+
+	selected = selectRandomPacksBySize(packs, sizes, 500)
+	rtest.Equals(t, 0, len(selected))
+
+	selected = selectRandomPacksBySize(packs, sizes, 1000*1000)
+	rtest.Equals(t, packs, selected)
+}
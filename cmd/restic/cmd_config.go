@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdConfig = &cobra.Command{
+	Use:   "config",
+	Short: "Show and change the repository configuration",
+	Long: `
+The "config" command contains subcommands to show and change settings which are
+stored in the repository configuration.
+
+The following settings are supported:
+
+  max-repo-size      limit for the size of the repository, backups which
+                     would exceed it are aborted with exit status 4 ("0" or
+                     "none" removes the limit)
+  max-key-age        maximum age of keys as a duration like "1y" or "6m",
+                     older keys are expired ("none" removes the limit)
+  expired-keys       whether commands "warn" or "refuse" to open the
+                     repository with an expired key
+  require-approval   whether destructive commands require an approval token
+                     created with a second key by "restic approve" ("yes" or
+                     "no")
+  kdf-params         key derivation function and parameters for new keys,
+                     usually set by "restic key calibrate" ("default" uses
+                     scrypt calibrated on each machine)
+`,
+	DisableAutoGenTag: true,
+}
+
+var cmdConfigGet = &cobra.Command{
+	Use:   "get [setting]",
+	Short: "Show the repository configuration",
+	Long: `
+The "config get" command prints the value of the given setting, or of all
+settings if none is given.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigGet(globalOptions, args)
+	},
+}
+
+var cmdConfigSet = &cobra.Command{
+	Use:   "set setting value",
+	Short: "Change the repository configuration",
+	Long: `
+The "config set" command changes a setting in the repository configuration.
+
+The configuration is replaced atomically, which is supported by the local,
+sftp (if the server supports the posix-rename extension, as OpenSSH does), S3,
+GCS, Azure, Swift and B2 backends, but not by the REST and rclone backends.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigSet(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdConfig)
+	cmdConfig.AddCommand(cmdConfigGet)
+	cmdConfig.AddCommand(cmdConfigSet)
+}
+
+// configSetting describes a setting which can be changed with "config set".
+type configSetting struct {
+	get func(cfg restic.Config) string
+	set func(cfg *restic.Config, value string) error
+}
+
+var configSettings = map[string]configSetting{
+	"max-repo-size": {
+		get: func(cfg restic.Config) string {
+			if cfg.MaxRepoSize == 0 {
+				return "none"
+			}
+			return formatBytes(cfg.MaxRepoSize)
+		},
+		set: func(cfg *restic.Config, value string) error {
+			if value == "none" {
+				cfg.MaxRepoSize = 0
+				return nil
+			}
+
+			size, err := parseSizeStr(value)
+			if err != nil {
+				return err
+			}
+			cfg.MaxRepoSize = uint64(size)
+			return nil
+		},
+	},
+	"max-key-age": {
+		get: func(cfg restic.Config) string {
+			if cfg.MaxKeyAge == "" {
+				return "none"
+			}
+			return cfg.MaxKeyAge
+		},
+		set: func(cfg *restic.Config, value string) error {
+			if value == "none" {
+				cfg.MaxKeyAge = ""
+				return nil
+			}
+
+			d, err := restic.ParseDuration(value)
+			if err != nil {
+				return errors.Fatalf("invalid key age %q: %v", value, err)
+			}
+			if d.Zero() {
+				return errors.Fatalf("invalid key age %q, use \"none\" to remove the limit", value)
+			}
+			cfg.MaxKeyAge = d.String()
+			return nil
+		},
+	},
+	"expired-keys": {
+		get: func(cfg restic.Config) string {
+			if cfg.RefuseExpiredKeys {
+				return "refuse"
+			}
+			return "warn"
+		},
+		set: func(cfg *restic.Config, value string) error {
+			switch value {
+			case "warn":
+				cfg.RefuseExpiredKeys = false
+			case "refuse":
+				cfg.RefuseExpiredKeys = true
+			default:
+				return errors.Fatalf("invalid value %q, must be \"warn\" or \"refuse\"", value)
+			}
+			return nil
+		},
+	},
+	"require-approval": {
+		get: func(cfg restic.Config) string {
+			if cfg.RequireApproval {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(cfg *restic.Config, value string) error {
+			switch value {
+			case "yes":
+				cfg.RequireApproval = true
+			case "no":
+				cfg.RequireApproval = false
+			default:
+				return errors.Fatalf("invalid value %q, must be \"yes\" or \"no\"", value)
+			}
+			return nil
+		},
+	},
+	"kdf-params": {
+		get: func(cfg restic.Config) string {
+			if cfg.KDFParams == "" {
+				return "default"
+			}
+			return cfg.KDFParams
+		},
+		set: func(cfg *restic.Config, value string) error {
+			if value == "default" {
+				cfg.KDFParams = ""
+				return nil
+			}
+
+			p, err := repository.ParseKDFParams(value)
+			if err != nil {
+				return err
+			}
+			if p.TargetTime > 0 {
+				return errors.Fatal("a target time depends on the machine, use \"restic key calibrate\" to select the parameters")
+			}
+			cfg.KDFParams = p.String()
+			return nil
+		},
+	},
+}
+
+// configSettingNames is the order in which settings are printed.
+var configSettingNames = []string{"max-repo-size", "max-key-age", "expired-keys", "require-approval", "kdf-params"}
+
+// maxKeyAge returns the maximum key age configured for the repository, which
+// is zero if keys do not expire.
+func maxKeyAge(cfg restic.Config) restic.Duration {
+	if cfg.MaxKeyAge == "" {
+		return restic.Duration{}
+	}
+
+	d, err := restic.ParseDuration(cfg.MaxKeyAge)
+	if err != nil {
+		Warnf("ignoring invalid max-key-age %q: %v\n", cfg.MaxKeyAge, err)
+		return restic.Duration{}
+	}
+	return d
+}
+
+func lookupConfigSetting(name string) (configSetting, error) {
+	setting, ok := configSettings[name]
+	if !ok {
+		return configSetting{}, errors.Fatalf("unknown setting %q", name)
+	}
+	return setting, nil
+}
+
+func runConfigGet(gopts GlobalOptions, args []string) error {
+	if len(args) > 1 {
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	names := configSettingNames
+	if len(args) == 1 {
+		if _, err := lookupConfigSetting(args[0]); err != nil {
+			return err
+		}
+		names = args
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		values := make(map[string]string, len(names))
+		for _, name := range names {
+			values[name] = configSettings[name].get(repo.Config())
+		}
+		return json.NewEncoder(gopts.stdout).Encode(values)
+	}
+
+	for _, name := range names {
+		Printf("%v: %v\n", name, configSettings[name].get(repo.Config()))
+	}
+	return nil
+}
+
+func runConfigSet(gopts GlobalOptions, args []string) error {
+	if len(args) != 2 {
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	setting, err := lookupConfigSetting(args[0])
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = requireAdminKey(gopts, repo, "config set"); err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	// disabling approvals must itself be approved
+	if args[0] == "require-approval" {
+		if err = requireApproval(gopts, repo, "config", ""); err != nil {
+			return err
+		}
+	}
+
+	cfg := repo.Config()
+	if err = setting.set(&cfg, args[1]); err != nil {
+		return errors.Fatalf("invalid value for %v: %v", args[0], err)
+	}
+
+	if err = repo.SaveConfig(gopts.ctx, cfg); err != nil {
+		return err
+	}
+
+	Verbosef("%v set to %v\n", args[0], setting.get(cfg))
+	return nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/restic/chunker"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var cmdCopy = &cobra.Command{
+	Use:   "copy [flags] [snapshot-ID ...]",
+	Short: "Copy snapshots from one repository to another",
+	Long: `
+The "copy" command copies one or more snapshots from the repository given with
+--from-repo to the repository given with --repo. Only data which is not
+already stored in the destination repository is transferred.
+
+Snapshots which were already copied are skipped. When no snapshot-ID is given,
+all snapshots matching the host, tag and path filter criteria are copied.
+
+Both repositories should use the same chunker parameters, otherwise data
+copied from the source repository does not deduplicate with data from backups
+made directly to the destination repository. A destination repository with the
+chunker parameters of the source repository can be created with "init
+--copy-chunker-params". For an existing destination repository, --rechunk
+splits the files again using the chunker parameters of the destination. This
+requires reading all file contents from the source repository and creates new
+trees, so the copied snapshots get a new tree ID.
+
+With --no-lock, the source repository is not locked, so that snapshots can be
+copied from read-only storage. The destination repository is always locked,
+as data is written to it and a concurrent prune must not remove it.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCopy(copyOptions, globalOptions, args)
+	},
+}
+
+// SourceRepoOptions bundles the options to access the repository a command
+// reads from in addition to the repository given with --repo.
+type SourceRepoOptions struct {
+	FromRepo            string
+	FromPasswordFile    string
+	FromPasswordCommand string
+	FromPasswordSource  string
+	FromKeyHint         string
+
+	// password for the source repository, set by tests
+	password string
+}
+
+func addSourceRepoOptions(f *pflag.FlagSet, opts *SourceRepoOptions) {
+	f.StringVarP(&opts.FromRepo, "from-repo", "", os.Getenv("RESTIC_FROM_REPOSITORY"), "source `repository` to read from (default: $RESTIC_FROM_REPOSITORY)")
+	f.StringVarP(&opts.FromPasswordFile, "from-password-file", "", os.Getenv("RESTIC_FROM_PASSWORD_FILE"), "read the source repository password from a `file` (default: $RESTIC_FROM_PASSWORD_FILE)")
+	f.StringVarP(&opts.FromPasswordCommand, "from-password-command", "", os.Getenv("RESTIC_FROM_PASSWORD_COMMAND"), "specify a shell `command` to obtain the source repository password (default: $RESTIC_FROM_PASSWORD_COMMAND)")
+	f.StringVarP(&opts.FromPasswordSource, "from-password-source", "", os.Getenv("RESTIC_FROM_PASSWORD_SOURCE"), "try the password `sources` for the source repository in the given order, like --password-source (default: $RESTIC_FROM_PASSWORD_SOURCE)")
+	f.StringVarP(&opts.FromKeyHint, "from-key-hint", "", os.Getenv("RESTIC_FROM_KEY_HINT"), "`key` ID of key to try decrypting the source repository first (default: $RESTIC_FROM_KEY_HINT)")
+}
+
+// CopyOptions bundles all options for the copy command.
+type CopyOptions struct {
+	SourceRepoOptions
+
+	Hosts   []string
+	Paths   []string
+	Tags    restic.TagLists
+	Rechunk bool
+}
+
+var copyOptions CopyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCopy)
+
+	f := cmdCopy.Flags()
+	addSourceRepoOptions(f, &copyOptions.SourceRepoOptions)
+	f.BoolVar(&copyOptions.Rechunk, "rechunk", false, "split files again using the chunker parameters of the destination repository")
+
+	f.StringArrayVarP(&copyOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+}
+
+// sourceGlobalOptions returns the global options used to open the source
+// repository.
+func sourceGlobalOptions(opts SourceRepoOptions, gopts GlobalOptions) (GlobalOptions, error) {
+	if opts.FromRepo == "" {
+		return GlobalOptions{}, errors.Fatal("Please specify a source repository location (--from-repo)")
+	}
+
+	srcOpts := gopts
+	srcOpts.Repo = opts.FromRepo
+	srcOpts.PasswordFile = opts.FromPasswordFile
+	srcOpts.PasswordCommand = opts.FromPasswordCommand
+	srcOpts.PasswordSource = opts.FromPasswordSource
+	srcOpts.KeyHint = opts.FromKeyHint
+	srcOpts.UnwrapCommand = ""
+	srcOpts.password = opts.password
+	srcOpts.passwordFromKeyring = false
+
+	if srcOpts.password == "" {
+		pwd, src, err := resolvePasswordSource(srcOpts, "RESTIC_FROM_PASSWORD")
+		if err != nil {
+			return GlobalOptions{}, err
+		}
+		srcOpts.password = pwd
+		srcOpts.passwordFromKeyring = src == "keyring"
+	}
+
+	return srcOpts, nil
+}
+
+// similarSnapshot returns the snapshot in list which has the same tree,
+// time, host and paths as sn, or nil if there is none.
+func similarSnapshot(list []*restic.Snapshot, sn *restic.Snapshot) *restic.Snapshot {
+	for _, other := range list {
+		if !other.Time.Equal(sn.Time) || other.Hostname != sn.Hostname || len(other.Paths) != len(sn.Paths) {
+			continue
+		}
+
+		equal := true
+		for i := range sn.Paths {
+			if other.Paths[i] != sn.Paths[i] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return other
+		}
+	}
+	return nil
+}
+
+// snapshotCopier copies the blobs referenced by snapshots from src to dst.
+type snapshotCopier struct {
+	src, dst restic.Repository
+
+	// blobs which were saved to dst, but may not be in its index yet
+	copied restic.BlobSet
+
+	// with --rechunk, rechunked maps the trees in src to the new trees in dst
+	chunker   *chunker.Chunker
+	rechunked map[restic.ID]restic.ID
+
+	// buf is reused for the chunks of all files
+	buf []byte
+}
+
+// copyBlob copies the blob h from src to dst, unless dst already contains it.
+func (c *snapshotCopier) copyBlob(ctx context.Context, h restic.BlobHandle) error {
+	if c.copied.Has(h) || c.dst.Index().Has(h.ID, h.Type) {
+		return nil
+	}
+
+	buf, err := c.src.LoadBlob(ctx, h.Type, h.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dst.SaveBlob(ctx, h.Type, buf, h.ID)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("copied blob %v", h)
+	c.copied.Insert(h)
+	return nil
+}
+
+// copyTree copies the tree with the given id together with all subtrees and
+// data blobs. Trees which are already stored in dst are assumed to be
+// complete and are skipped.
+func (c *snapshotCopier) copyTree(ctx context.Context, id restic.ID) error {
+	h := restic.BlobHandle{ID: id, Type: restic.TreeBlob}
+	if c.copied.Has(h) || c.dst.Index().Has(id, restic.TreeBlob) {
+		return nil
+	}
+
+	tree, err := c.src.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case "file":
+			for _, blobID := range node.Content {
+				err = c.copyBlob(ctx, restic.BlobHandle{ID: blobID, Type: restic.DataBlob})
+				if err != nil {
+					return err
+				}
+			}
+		case "dir":
+			if node.Subtree == nil {
+				continue
+			}
+
+			err = c.copyTree(ctx, *node.Subtree)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the tree is saved after all blobs it references
+	return c.copyBlob(ctx, h)
+}
+
+// contentReader returns the content of a file stored in a list of data blobs.
+type contentReader struct {
+	ctx     context.Context
+	repo    restic.Repository
+	content restic.IDs
+
+	// blob is the last blob loaded, buf the part which was not read yet
+	blob, buf []byte
+}
+
+func (rd *contentReader) Read(p []byte) (int, error) {
+	for len(rd.buf) == 0 {
+		if len(rd.content) == 0 {
+			return 0, io.EOF
+		}
+
+		blob, err := rd.repo.LoadBlob(rd.ctx, restic.DataBlob, rd.content[0], rd.blob[:0])
+		if err != nil {
+			return 0, err
+		}
+		rd.blob, rd.buf = blob, blob
+		rd.content = rd.content[1:]
+	}
+
+	n := copy(p, rd.buf)
+	rd.buf = rd.buf[n:]
+	return n, nil
+}
+
+// rechunkFile splits the content of a file in src again using the chunker
+// parameters of dst and saves the new data blobs.
+func (c *snapshotCopier) rechunkFile(ctx context.Context, content restic.IDs) (restic.IDs, error) {
+	c.chunker.Reset(&contentReader{ctx: ctx, repo: c.src, content: content}, c.dst.Config().ChunkerPolynomial)
+
+	// a chunk is never larger than the file, so small files do not need a
+	// buffer of chunker.MaxSize
+	var size uint
+	for _, id := range content {
+		blobSize, _ := c.src.LookupBlobSize(id, restic.DataBlob)
+		size += blobSize
+	}
+	if size > chunker.MaxSize {
+		size = chunker.MaxSize
+	}
+	if uint(cap(c.buf)) < size {
+		c.buf = make([]byte, size)
+	}
+
+	newContent := restic.IDs{}
+	for {
+		chunk, err := c.chunker.Next(c.buf)
+		if errors.Cause(err) == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// keep a buffer which grew beyond the estimated size
+		c.buf = chunk.Data
+
+		id := restic.Hash(chunk.Data)
+		h := restic.BlobHandle{ID: id, Type: restic.DataBlob}
+		if !c.copied.Has(h) && !c.dst.Index().Has(id, restic.DataBlob) {
+			_, err = c.dst.SaveBlob(ctx, restic.DataBlob, chunk.Data, id)
+			if err != nil {
+				return nil, err
+			}
+			c.copied.Insert(h)
+		}
+
+		newContent = append(newContent, id)
+	}
+
+	return newContent, nil
+}
+
+// rechunkTree copies the tree with the given id and rechunks all files in it.
+// It returns the ID of the new tree in dst.
+func (c *snapshotCopier) rechunkTree(ctx context.Context, id restic.ID) (restic.ID, error) {
+	if newID, ok := c.rechunked[id]; ok {
+		return newID, nil
+	}
+
+	tree, err := c.src.LoadTree(ctx, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case "file":
+			node.Content, err = c.rechunkFile(ctx, node.Content)
+			if err != nil {
+				return restic.ID{}, err
+			}
+		case "dir":
+			if node.Subtree == nil {
+				continue
+			}
+
+			subtree, err := c.rechunkTree(ctx, *node.Subtree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			node.Subtree = &subtree
+		}
+	}
+
+	newID, err := c.dst.SaveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	c.rechunked[id] = newID
+	return newID, nil
+}
+
+func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
+	srcOpts, err := sourceGlobalOptions(opts.SourceRepoOptions, gopts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	srcRepo, err := OpenRepository(srcOpts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		srcLock, err := lockRepo(srcRepo)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	// --no-lock only applies to the source repository, the destination is
+	// written to like for backup, which always needs a lock
+	dstLock, err := lockRepo(dstRepo)
+	defer unlockRepo(dstLock)
+	if err != nil {
+		return err
+	}
+
+	if srcRepo.Config().ChunkerPolynomial == dstRepo.Config().ChunkerPolynomial {
+		// rechunking would produce exactly the same blobs
+		opts.Rechunk = false
+	} else if !opts.Rechunk {
+		Warnf("the repositories use different chunker parameters, copied data will not deduplicate with new backups, use --rechunk to avoid this\n")
+	}
+
+	Verbosef("loading index for source repository\n")
+	if err = srcRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("loading index for destination repository\n")
+	if err = dstRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	dstSnapshots := make(map[restic.ID][]*restic.Snapshot)
+	// rechunked snapshots have a different tree, they are found by the
+	// original snapshot ID
+	dstOriginals := make(map[restic.ID]*restic.Snapshot)
+	for sn := range FindFilteredSnapshots(ctx, dstRepo, nil, nil, nil, nil) {
+		if sn.Tree != nil {
+			dstSnapshots[*sn.Tree] = append(dstSnapshots[*sn.Tree], sn)
+		}
+		if sn.Original != nil {
+			dstOriginals[*sn.Original] = sn
+		}
+	}
+
+	c := &snapshotCopier{
+		src:    srcRepo,
+		dst:    dstRepo,
+		copied: restic.NewBlobSet(),
+	}
+	if opts.Rechunk {
+		c.chunker = chunker.New(nil, dstRepo.Config().ChunkerPolynomial)
+		c.rechunked = make(map[restic.ID]restic.ID)
+	}
+
+	copied := 0
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("snapshot %s of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %v has nil tree, skipping\n", sn.ID().Str())
+			continue
+		}
+
+		original := sn.ID()
+		if sn.Original != nil {
+			original = sn.Original
+		}
+
+		other := similarSnapshot(dstSnapshots[*sn.Tree], sn)
+		if other == nil && opts.Rechunk {
+			other = dstOriginals[*original]
+		}
+		if other != nil {
+			Verbosef("  skipping, already copied as %s\n", other.ID().Str())
+			continue
+		}
+
+		if opts.Rechunk {
+			Verbosef("  copying and rechunking\n")
+			tree, err := c.rechunkTree(ctx, *sn.Tree)
+			if err != nil {
+				return err
+			}
+			sn.Tree = &tree
+		} else {
+			Verbosef("  copying\n")
+			if err = c.copyTree(ctx, *sn.Tree); err != nil {
+				return err
+			}
+		}
+
+		// the new trees and data must be stored before the snapshot
+		if err = dstRepo.Flush(ctx); err != nil {
+			return err
+		}
+		if err = dstRepo.SaveIndex(ctx); err != nil {
+			return err
+		}
+
+		sn.Original = original
+		// the parent and previous snapshots only exist in the source repository
+		sn.Parent = nil
+		sn.Previous = nil
+
+		id, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+		Verbosef("  saved as snapshot %s\n", id.Str())
+		copied++
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	Verbosef("copied %d snapshots\n", copied)
+	return nil
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"path"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -14,7 +17,7 @@ import (
 )
 
 var cmdDiff = &cobra.Command{
-	Use:   "diff snapshot-ID snapshot-ID",
+	Use:   "diff snapshot-ID (snapshot-ID | local:dir)",
 	Short: "Show differences between two snapshots",
 	Long: `
 The "diff" command shows differences from the first to the second snapshot. The
@@ -27,6 +30,11 @@ directory:
 * M  The file's content was modified
 * T  The type was changed, e.g. a file was made a symlink
 
+If the second argument is "local:" followed by a directory, the snapshot is
+compared to the files in this directory instead, which are read completely.
+By default, the directory with the same path in the snapshot is used, a
+different directory can be selected with "snapshot-ID:/path/in/snapshot".
+
 EXIT STATUS
 ===========
 
@@ -65,13 +73,70 @@ func loadSnapshot(ctx context.Context, repo *repository.Repository, desc string)
 type Comparer struct {
 	repo restic.Repository
 	opts DiffOptions
+
+	// if enc is set, changes are printed as JSON messages
+	enc *json.Encoder
+}
+
+// newComparer returns a Comparer which prints JSON messages to stdout if
+// gopts.JSON is set.
+func newComparer(repo restic.Repository, opts DiffOptions, gopts GlobalOptions) *Comparer {
+	c := &Comparer{
+		repo: repo,
+		opts: opts,
+	}
+	if gopts.JSON {
+		c.enc = json.NewEncoder(gopts.stdout)
+	}
+	return c
+}
+
+// diffChange is printed by diff in JSON mode for each changed item.
+type diffChange struct {
+	MessageType string `json:"message_type"` // "change"
+	Path        string `json:"path"`
+	Modifier    string `json:"modifier"`
+}
+
+// printChange prints that the item at path was changed as described by mode.
+func (c *Comparer) printChange(mode, path string) {
+	if c.enc == nil {
+		Printf("%-5s%v\n", mode, path)
+		return
+	}
+
+	err := c.enc.Encode(diffChange{MessageType: "change", Path: path, Modifier: mode})
+	if err != nil {
+		Warnf("JSON encode failed: %v\n", err)
+	}
+}
+
+// diffStatistics is printed by diff in JSON mode after all changes.
+type diffStatistics struct {
+	MessageType  string   `json:"message_type"` // "statistics"
+	ChangedFiles int      `json:"changed_files"`
+	Added        DiffStat `json:"added"`
+	Removed      DiffStat `json:"removed"`
+}
+
+// printDiffStatistics prints the statistics of a diff run as JSON to wr.
+func printDiffStatistics(wr io.Writer, changedFiles int, added, removed DiffStat) error {
+	return json.NewEncoder(wr).Encode(diffStatistics{
+		MessageType:  "statistics",
+		ChangedFiles: changedFiles,
+		Added:        added,
+		Removed:      removed,
+	})
 }
 
 // DiffStat collects stats for all types of items.
 type DiffStat struct {
-	Files, Dirs, Others  int
-	DataBlobs, TreeBlobs int
-	Bytes                uint64
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Others    int    `json:"others"`
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	Bytes     uint64 `json:"bytes"`
 }
 
 // Add adds stats information for node to s.
@@ -162,7 +227,7 @@ func (c *Comparer) printDir(ctx context.Context, mode string, stats *DiffStat, b
 		if node.Type == "dir" {
 			name += "/"
 		}
-		Printf("%-5s%v\n", mode, name)
+		c.printChange(mode, name)
 		stats.Add(node)
 		addBlobs(blobs, node)
 
@@ -244,7 +309,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			}
 
 			if mod != "" {
-				Printf("%-5s%v\n", mod, name)
+				c.printChange(mod, name)
 			}
 
 			if node1.Type == "dir" && node2.Type == "dir" {
@@ -258,7 +323,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node1.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "-", prefix)
+			c.printChange("-", prefix)
 			stats.Removed.Add(node1)
 
 			if node1.Type == "dir" {
@@ -272,7 +337,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node2.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "+", prefix)
+			c.printChange("+", prefix)
 			stats.Added.Add(node2)
 
 			if node2.Type == "dir" {
@@ -312,6 +377,10 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if strings.HasPrefix(args[1], localDiffPrefix) {
+		return runDiffLocal(ctx, repo, opts, gopts, args[0], strings.TrimPrefix(args[1], localDiffPrefix))
+	}
+
 	sn1, err := loadSnapshot(ctx, repo, args[0])
 	if err != nil {
 		return err
@@ -332,10 +401,7 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		return errors.Errorf("snapshot %v has nil tree", sn2.ID().Str())
 	}
 
-	c := &Comparer{
-		repo: repo,
-		opts: diffOptions,
-	}
+	c := newComparer(repo, opts, gopts)
 
 	stats := NewDiffStats()
 
@@ -348,6 +414,10 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 	updateBlobs(repo, stats.BlobsBefore.Sub(both), &stats.Removed)
 	updateBlobs(repo, stats.BlobsAfter.Sub(both), &stats.Added)
 
+	if gopts.JSON {
+		return printDiffStatistics(gopts.stdout, stats.ChangedFiles, stats.Added, stats.Removed)
+	}
+
 	Printf("\n")
 	Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
 	Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"context"
 	"fmt"
 	"io"
@@ -23,7 +24,8 @@ var cmdDump = &cobra.Command{
 	Short: "Print a backed-up file to stdout",
 	Long: `
 The "dump" command extracts a single file from a snapshot from the repository and
-prints its contents to stdout.
+prints its contents to stdout. If a directory is given, its contents are
+written to stdout as an archive in the format selected with --archive.
 
 The special snapshot "latest" can be used to use the latest snapshot in the
 repository.
@@ -41,9 +43,10 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // DumpOptions collects all options for the dump command.
 type DumpOptions struct {
-	Hosts []string
-	Paths []string
-	Tags  restic.TagLists
+	Hosts   []string
+	Paths   []string
+	Tags    restic.TagLists
+	Archive string
 }
 
 var dumpOptions DumpOptions
@@ -55,6 +58,7 @@ func init() {
 	flags.StringArrayVarP(&dumpOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` as \"tar\" or \"zip\"")
 }
 
 func splitPath(p string) []string {
@@ -69,7 +73,7 @@ func splitPath(p string) []string {
 	return append(s, f)
 }
 
-func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string) error {
+func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repository, prefix string, pathComponents []string, pathToPrint string, archive string) error {
 
 	if tree == nil {
 		return fmt.Errorf("called with a nil tree")
@@ -92,10 +96,16 @@ func printFromTree(ctx context.Context, tree *restic.Tree, repo restic.Repositor
 				if err != nil {
 					return errors.Wrapf(err, "cannot load subtree for %q", item)
 				}
-				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], pathToPrint)
+				return printFromTree(ctx, subtree, repo, item, pathComponents[1:], pathToPrint, archive)
 			case node.Type == "dir":
 				node.Path = pathToPrint
-				return tarTree(ctx, repo, node, pathToPrint)
+				if stdoutIsTerminal() {
+					return fmt.Errorf("stdout is the terminal, please redirect output")
+				}
+				if archive == "zip" {
+					return zipTree(ctx, os.Stdout, repo, node, pathToPrint)
+				}
+				return tarTree(ctx, os.Stdout, repo, node, pathToPrint)
 			case l > 1:
 				return fmt.Errorf("%q should be a dir, but is a %q", item, node.Type)
 			case node.Type != "file":
@@ -120,6 +130,12 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 
 	splittedPath := splitPath(path.Clean(pathToPrint))
 
+	switch opts.Archive {
+	case "tar", "zip":
+	default:
+		return errors.Fatalf("unknown archive format %q", opts.Archive)
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -162,7 +178,7 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "loading tree for snapshot %q failed: %v", snapshotIDString, err)
 	}
 
-	err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint)
+	err = printFromTree(ctx, tree, repo, "", splittedPath, pathToPrint, opts.Archive)
 	if err != nil {
 		Exitf(2, "cannot dump file: %v", err)
 	}
@@ -190,15 +206,10 @@ func getNodeData(ctx context.Context, output io.Writer, repo restic.Repository,
 	return nil
 }
 
-func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string) error {
-
-	if stdoutIsTerminal() {
-		return fmt.Errorf("stdout is the terminal, please redirect output")
-	}
-
-	tw := tar.NewWriter(os.Stdout)
-	defer tw.Close()
-
+// walkDumpTree calls dumpNode for rootNode and all files, symlinks and
+// directories below it. The Path of each node is set to its path in the
+// archive.
+func walkDumpTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node, rootPath string, dumpNode func(node *restic.Node) error) error {
 	// If we want to dump "/" we'll need to add the name of the first node, too
 	// as it would get lost otherwise.
 	if rootNode.Path == "/" {
@@ -207,12 +218,12 @@ func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node,
 	}
 
 	// we know that rootNode is a folder and walker.Walk will already process
-	// the next node, so we have to tar this one first, too
-	if err := tarNode(ctx, tw, rootNode, repo); err != nil {
+	// the next node, so we have to dump this one first, too
+	if err := dumpNode(rootNode); err != nil {
 		return err
 	}
 
-	err := walker.Walk(ctx, repo, *rootNode.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+	return walker.Walk(ctx, repo, *rootNode.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			return false, err
 		}
@@ -223,16 +234,42 @@ func tarTree(ctx context.Context, repo restic.Repository, rootNode *restic.Node,
 		node.Path = path.Join(rootPath, nodepath)
 
 		if node.Type == "file" || node.Type == "symlink" || node.Type == "dir" {
-			err := tarNode(ctx, tw, node, repo)
-			if err != err {
+			err := dumpNode(node)
+			if err != nil {
 				return false, err
 			}
 		}
 
 		return false, nil
 	})
+}
+
+func tarTree(ctx context.Context, w io.Writer, repo restic.Repository, rootNode *restic.Node, rootPath string) error {
+	tw := tar.NewWriter(w)
+
+	err := walkDumpTree(ctx, repo, rootNode, rootPath, func(node *restic.Node) error {
+		return tarNode(ctx, tw, node, repo)
+	})
+	if err != nil {
+		_ = tw.Close()
+		return err
+	}
 
-	return err
+	return errors.Wrap(tw.Close(), "Close")
+}
+
+func zipTree(ctx context.Context, w io.Writer, repo restic.Repository, rootNode *restic.Node, rootPath string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkDumpTree(ctx, repo, rootNode, rootPath, func(node *restic.Node) error {
+		return zipNode(ctx, zw, node, repo)
+	})
+	if err != nil {
+		_ = zw.Close()
+		return err
+	}
+
+	return errors.Wrap(zw.Close(), "Close")
 }
 
 func tarNode(ctx context.Context, tw *tar.Writer, node *restic.Node, repo restic.Repository) error {
@@ -268,6 +305,36 @@ func tarNode(ctx context.Context, tw *tar.Writer, node *restic.Node, repo restic
 
 }
 
+func zipNode(ctx context.Context, zw *zip.Writer, node *restic.Node, repo restic.Repository) error {
+	// zip archives only contain relative paths
+	header := &zip.FileHeader{
+		Name:               strings.TrimPrefix(node.Path, "/"),
+		UncompressedSize64: node.Size,
+		Modified:           node.ModTime,
+	}
+	header.SetMode(node.Mode)
+
+	switch node.Type {
+	case "dir":
+		header.Name += "/"
+	case "file":
+		header.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "ZipHeader")
+	}
+
+	if node.Type == "symlink" {
+		// the target of a symlink is stored as the content of the entry
+		_, err = w.Write([]byte(node.LinkTarget))
+		return errors.Wrap(err, "Write")
+	}
+
+	return getNodeData(ctx, w, repo, node)
+}
+
 func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
 	tmpMap := make(map[string]string)
 
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestZipTreeRoundTrip(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tempdir, cleanupTempdir := rtest.TempDir(t)
+	defer cleanupTempdir()
+
+	archiver.TestCreateFiles(t, tempdir, archiver.TestDir{
+		"dir": archiver.TestDir{
+			"file":  archiver.TestFile{Content: "content of file"},
+			"empty": archiver.TestFile{Content: ""},
+			"link":  archiver.TestSymlink{Target: "file"},
+			"subdir": archiver.TestDir{
+				"other": archiver.TestFile{Content: "content of other"},
+			},
+		},
+	})
+
+	back := fs.TestChdir(t, tempdir)
+	sn := archiver.TestSnapshot(t, repo, "dir", nil)
+	back()
+
+	ctx := context.TODO()
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	rtest.OK(t, err)
+	node := tree.Find("dir")
+	rtest.Assert(t, node != nil, "directory not found in snapshot")
+	node.Path = "/dir"
+
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, zipTree(ctx, buf, repo, node, "/dir"))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	rtest.OK(t, err)
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	want := map[string]string{
+		"dir/file":         "content of file",
+		"dir/empty":        "",
+		"dir/subdir/other": "content of other",
+	}
+	if runtime.GOOS != "windows" {
+		want["dir/link"] = "file"
+	}
+
+	for name, content := range want {
+		f, ok := files[name]
+		rtest.Assert(t, ok, "%v not found in archive", name)
+
+		rd, err := f.Open()
+		rtest.OK(t, err)
+		data, err := ioutil.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+		rtest.Equals(t, content, string(data))
+	}
+
+	for _, name := range []string{"dir/", "dir/subdir/"} {
+		f, ok := files[name]
+		rtest.Assert(t, ok, "%v not found in archive", name)
+		rtest.Assert(t, f.Mode().IsDir(), "%v is not a directory", name)
+	}
+
+	if runtime.GOOS != "windows" {
+		rtest.Assert(t, files["dir/link"].Mode()&os.ModeSymlink != 0, "dir/link is not a symlink")
+	}
+
+	rtest.Equals(t, len(want)+2, len(zr.File))
+}
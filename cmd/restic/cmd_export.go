@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdExport = &cobra.Command{
+	Use:   "export [flags] snapshotID",
+	Short: "Export a snapshot as an encrypted tar archive",
+	Long: `
+The "export" command writes the files of a snapshot as a tar archive, which is
+encrypted for the recipients given with --encrypt-to. The archive can be
+handed to a third party, who can decrypt it without restic and without access
+to the repository.
+
+Recipients starting with "age1", "ssh-ed25519 " or "ssh-rsa " are age public
+keys, the archive is then encrypted by running "age". All other recipients are
+OpenPGP key IDs, fingerprints or email addresses for "gpg", which must find the
+public keys in its keyring. All recipients must be of the same kind. The
+encrypted archive is written to the file given with --output, or to stdout.
+
+The special snapshot "latest" can be used to use the latest snapshot in the
+repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExport(exportOptions, globalOptions, args)
+	},
+}
+
+// ExportOptions collects all options for the export command.
+type ExportOptions struct {
+	Hosts     []string
+	Paths     []string
+	Tags      restic.TagLists
+	EncryptTo []string
+	Output    string
+}
+
+var exportOptions ExportOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdExport)
+
+	flags := cmdExport.Flags()
+	flags.StringArrayVarP(&exportOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
+	flags.Var(&exportOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
+	flags.StringArrayVar(&exportOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.StringArrayVar(&exportOptions.EncryptTo, "encrypt-to", nil, "encrypt the archive for an age or OpenPGP `recipient` (can be specified multiple times)")
+	flags.StringVar(&exportOptions.Output, "output", "", "write the encrypted archive to `file` instead of stdout")
+}
+
+// isAgeRecipient returns true if recipient is an age or SSH public key.
+func isAgeRecipient(recipient string) bool {
+	for _, prefix := range []string{"age1", "ssh-ed25519 ", "ssh-rsa "} {
+		if strings.HasPrefix(recipient, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptCommand returns the command line which encrypts stdin for the
+// recipients and writes the result to stdout.
+func encryptCommand(recipients []string) ([]string, error) {
+	if len(recipients) == 0 {
+		return nil, errors.Fatal("no recipient given, use --encrypt-to")
+	}
+
+	age := isAgeRecipient(recipients[0])
+	args := []string{"gpg", "--batch", "--encrypt"}
+	if age {
+		args = []string{"age", "--encrypt"}
+	}
+
+	for _, r := range recipients {
+		if isAgeRecipient(r) != age {
+			return nil, errors.Fatal("age and OpenPGP recipients cannot be mixed")
+		}
+		args = append(args, "--recipient", r)
+	}
+
+	return args, nil
+}
+
+func runExport(opts ExportOptions, gopts GlobalOptions, args []string) (err error) {
+	ctx := gopts.ctx
+
+	if len(args) != 1 {
+		return errors.Fatal("no snapshot ID specified")
+	}
+
+	encrypt, err := encryptCommand(opts.EncryptTo)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "" && stdoutIsTerminal() {
+		return errors.Fatal("stdout is the terminal, please redirect output or use --output")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	var id restic.ID
+	if args[0] == "latest" {
+		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+		if err != nil {
+			Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
+		}
+	} else {
+		id, err = restic.FindSnapshot(repo, args[0])
+		if err != nil {
+			Exitf(1, "invalid id %q: %v", args[0], err)
+		}
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		Exitf(2, "loading snapshot %q failed: %v", args[0], err)
+	}
+
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	if err != nil {
+		Exitf(2, "loading tree for snapshot %q failed: %v", args[0], err)
+	}
+
+	// create the output file only after the snapshot has been found, Exitf
+	// above does not run deferred functions and would leave it behind
+	var output io.Writer = os.Stdout
+	if opts.Output != "" {
+		f, ferr := os.OpenFile(opts.Output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if ferr != nil {
+			return errors.Fatalf("unable to create output file: %v", ferr)
+		}
+		defer func() {
+			_ = f.Close()
+			if err != nil {
+				_ = os.Remove(opts.Output)
+			}
+		}()
+		output = f
+	}
+
+	debug.Log("export snapshot %v with %q", id.Str(), encrypt)
+
+	cmd := exec.CommandContext(ctx, encrypt[0], encrypt[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "StdinPipe")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return errors.Fatalf("unable to run %q: %v", encrypt[0], err)
+	}
+
+	tw := tar.NewWriter(stdin)
+	for _, node := range tree.Nodes {
+		node.Path = path.Join("/", node.Name)
+		if node.Type == "dir" {
+			err = walkDumpTree(ctx, repo, node, node.Path, func(node *restic.Node) error {
+				return tarNode(ctx, tw, node, repo)
+			})
+		} else {
+			err = tarNode(ctx, tw, node, repo)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = errors.Wrap(tw.Close(), "Close")
+	}
+	_ = stdin.Close()
+
+	werr := cmd.Wait()
+	if err == nil && werr != nil {
+		err = errors.Fatalf("%q failed: %v", encrypt[0], werr)
+	}
+	if err != nil {
+		return err
+	}
+
+	Verbosef("exported snapshot %v\n", sn.ID().Str())
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestEncryptCommand(t *testing.T) {
+	args, err := encryptCommand([]string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHsKLqeplhpW+uObz5dvMgjz1OxfM/XXUB+VHtZ6isGN"})
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"age", "--encrypt",
+		"--recipient", "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+		"--recipient", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHsKLqeplhpW+uObz5dvMgjz1OxfM/XXUB+VHtZ6isGN"}, args)
+
+	args, err = encryptCommand([]string{"alice@example.com"})
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"gpg", "--batch", "--encrypt", "--recipient", "alice@example.com"}, args)
+
+	_, err = encryptCommand(nil)
+	rtest.Assert(t, err != nil, "missing recipient did not return an error")
+
+	_, err = encryptCommand([]string{"alice@example.com", "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"})
+	rtest.Assert(t, err != nil, "mixed recipients did not return an error")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 
 	"github.com/restic/restic/internal/restic"
@@ -18,6 +19,8 @@ this command really only deletes the snapshot object in the repository, which
 is a reference to data stored there. In order to remove this (now unreferenced)
 data after 'forget' was run successfully, see the 'prune' command.
 
+Snapshots which were protected with "restic tag --protect" are never removed.
+
 EXIT STATUS
 ===========
 
@@ -25,7 +28,15 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runForget(forgetOptions, globalOptions, args)
+		gopts := globalOptions
+		m, err := newMetrics(gopts, "forget")
+		if err != nil {
+			return err
+		}
+		gopts.metrics = m
+
+		err = runForget(forgetOptions, gopts, args)
+		return gopts.metrics.Finish(gopts, err)
 	},
 }
 
@@ -40,6 +51,12 @@ type ForgetOptions struct {
 	Within   restic.Duration
 	KeepTags restic.TagLists
 
+	WithinHourly  restic.Duration
+	WithinDaily   restic.Duration
+	WithinWeekly  restic.Duration
+	WithinMonthly restic.Duration
+	WithinYearly  restic.Duration
+
 	Hosts   []string
 	Tags    restic.TagLists
 	Paths   []string
@@ -49,6 +66,9 @@ type ForgetOptions struct {
 	GroupBy string
 	DryRun  bool
 	Prune   bool
+
+	// PruneOptions are used with --prune
+	PruneOptions PruneOptions
 }
 
 var forgetOptions ForgetOptions
@@ -64,6 +84,11 @@ func init() {
 	f.IntVarP(&forgetOptions.Monthly, "keep-monthly", "m", 0, "keep the last `n` monthly snapshots")
 	f.IntVarP(&forgetOptions.Yearly, "keep-yearly", "y", 0, "keep the last `n` yearly snapshots")
 	f.VarP(&forgetOptions.Within, "keep-within", "", "keep snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinHourly, "keep-within-hourly", "", "keep hourly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinDaily, "keep-within-daily", "", "keep daily snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinWeekly, "keep-within-weekly", "", "keep weekly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinMonthly, "keep-within-monthly", "", "keep monthly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinYearly, "keep-within-yearly", "", "keep yearly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
 
 	f.Var(&forgetOptions.KeepTags, "keep-tag", "keep snapshots with this `taglist` (can be specified multiple times)")
 	f.StringArrayVar(&forgetOptions.Hosts, "host", nil, "only consider snapshots with the given `host` (can be specified multiple times)")
@@ -78,41 +103,113 @@ func init() {
 	f.StringVarP(&forgetOptions.GroupBy, "group-by", "g", "host,paths", "string for grouping snapshots by host,paths,tags")
 	f.BoolVarP(&forgetOptions.DryRun, "dry-run", "n", false, "do not delete anything, just print what would be done")
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
+	addPruneOptions(f, &forgetOptions.PruneOptions)
 
 	f.SortFlags = false
 }
 
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	if opts.Prune {
+		err := verifyPruneOptions(&opts.PruneOptions)
+		if err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
+	gopts.metrics.SetRepository(repo)
 
-	lock, err := lockRepoExclusive(repo)
-	defer unlockRepo(lock)
-	if err != nil {
+	if err = requireAdminKey(gopts, repo, "forget"); err != nil {
 		return err
 	}
 
+	// forget --prune is approved like prune
+	if !opts.DryRun {
+		operation := "forget"
+		if opts.Prune {
+			operation = "prune"
+		}
+		if err = requireApproval(gopts, repo, operation, ""); err != nil {
+			return err
+		}
+	}
+
+	// Removing snapshots does not interfere with concurrent backups, so a
+	// non-exclusive lock is sufficient. With --prune, the exclusive lock is
+	// only acquired by prune before data is removed.
+	prune := opts.Prune && !opts.DryRun
+	var lock *restic.Lock
+	if !opts.DryRun || !gopts.NoLock {
+		lock, err = lockRepo(repo)
+		defer func() {
+			unlockRepo(lock)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
 	removeSnapshots := 0
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
-	var snapshots restic.Snapshots
+	// prune needs all snapshots and the index. The snapshots are only loaded
+	// once, the index is loaded in the background while snapshots are removed.
+	var allSnapshots restic.Snapshots
+	var indexLoaded chan error
+	if prune {
+		indexLoaded = make(chan error, 1)
+		go func() {
+			indexLoaded <- repo.LoadIndex(ctx)
+		}()
+
+		allSnapshots, err = restic.LoadAllSnapshots(ctx, repo)
+		if err != nil {
+			return err
+		}
+	}
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
-		snapshots = append(snapshots, sn)
+	var snapshots restic.Snapshots
+	if prune && len(args) == 0 {
+		for _, sn := range allSnapshots {
+			if sn.HasHostname(opts.Hosts) && sn.HasTagList(opts.Tags) && sn.HasPaths(opts.Paths) {
+				snapshots = append(snapshots, sn)
+			}
+		}
+	} else {
+		for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+			snapshots = append(snapshots, sn)
+		}
 	}
 
+	removed := restic.NewIDSet()
+
 	if len(args) > 0 {
-		// When explicit snapshots args are given, remove them immediately.
+		var remove restic.Snapshots
 		for _, sn := range snapshots {
+			if sn.Protected {
+				Warnf("snapshot %v is protected, use 'restic tag --unprotect' to remove the protection\n", sn.ID().Str())
+				continue
+			}
+			remove = append(remove, sn)
+		}
+
+		if !opts.DryRun && len(remove) > 0 {
+			auditLog(ctx, repo, "forget", snapshotIDs(remove), auditSnapshots(remove)...)
+		}
+
+		// When explicit snapshots args are given, remove them immediately.
+		for _, sn := range remove {
 			if !opts.DryRun {
 				h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 				if err = repo.Backend().Remove(gopts.ctx, h); err != nil {
 					return err
 				}
+				removed.Insert(*sn.ID())
 				if !gopts.JSON {
 					Verbosef("removed snapshot %v\n", sn.ID().Str())
 				}
@@ -138,6 +235,12 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 			Yearly:  opts.Yearly,
 			Within:  opts.Within,
 			Tags:    opts.KeepTags,
+
+			WithinHourly:  opts.WithinHourly,
+			WithinDaily:   opts.WithinDaily,
+			WithinWeekly:  opts.WithinWeekly,
+			WithinMonthly: opts.WithinMonthly,
+			WithinYearly:  opts.WithinYearly,
 		}
 
 		if policy.Empty() && len(args) == 0 {
@@ -153,7 +256,8 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 
 			var jsonGroups []*ForgetGroup
 
-			for k, snapshotGroup := range snapshotGroups {
+			for _, k := range restic.SortedGroupKeys(snapshotGroups) {
+				snapshotGroup := snapshotGroups[k]
 				if gopts.Verbose >= 1 && !gopts.JSON {
 					err = PrintSnapshotGroupHeader(gopts.stdout, k)
 					if err != nil {
@@ -162,7 +266,7 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 				}
 
 				var key restic.SnapshotGroupKey
-				if json.Unmarshal([]byte(k), &key) != nil {
+				if err = json.Unmarshal([]byte(k), &key); err != nil {
 					return err
 				}
 
@@ -171,35 +275,54 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 				fg.Host = key.Hostname
 				fg.Paths = key.Paths
 
-				keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
+				keep, remove, reasons, removeReasons := restic.ApplyPolicyExplained(snapshotGroup, policy)
 
 				if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
+					keepMatches := make([][]string, 0, len(reasons))
+					for _, r := range reasons {
+						keepMatches = append(keepMatches, r.Matches)
+					}
+
 					Printf("keep %d snapshots:\n", len(keep))
-					PrintSnapshots(globalOptions.stdout, keep, reasons, opts.Compact)
+					PrintSnapshots(globalOptions.stdout, keep, keepMatches, opts.Compact)
 					Printf("\n")
 				}
 				addJSONSnapshots(&fg.Keep, keep)
 
 				if len(remove) != 0 && !gopts.Quiet && !gopts.JSON {
-					Printf("remove %d snapshots:\n", len(remove))
-					PrintSnapshots(globalOptions.stdout, remove, nil, opts.Compact)
+					var removeMatches [][]string
+					if opts.DryRun || gopts.Verbose >= 2 {
+						for _, r := range removeReasons {
+							removeMatches = append(removeMatches, r.Reasons)
+						}
+					}
+
+					if opts.DryRun {
+						Printf("would remove %d snapshots:\n", len(remove))
+					} else {
+						Printf("remove %d snapshots:\n", len(remove))
+					}
+					PrintSnapshots(globalOptions.stdout, remove, removeMatches, opts.Compact)
 					Printf("\n")
 				}
 				addJSONSnapshots(&fg.Remove, remove)
 
 				fg.Reasons = reasons
+				fg.RemoveReasons = removeReasons
 
 				jsonGroups = append(jsonGroups, &fg)
 
 				removeSnapshots += len(remove)
 
-				if !opts.DryRun {
+				if !opts.DryRun && len(remove) > 0 {
+					auditLog(ctx, repo, "forget", snapshotIDs(remove), auditSnapshots(remove)...)
 					for _, sn := range remove {
 						h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 						err = repo.Backend().Remove(gopts.ctx, h)
 						if err != nil {
 							return err
 						}
+						removed.Insert(*sn.ID())
 					}
 				}
 			}
@@ -213,12 +336,31 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	gopts.metrics.Set("snapshots_removed", "Number of snapshots removed in the last run.", float64(len(removed)))
+
 	if removeSnapshots > 0 && opts.Prune {
 		if !gopts.JSON {
 			Verbosef("%d snapshots have been removed, running prune\n", removeSnapshots)
 		}
-		if !opts.DryRun {
-			return pruneRepository(gopts, repo)
+		if prune {
+			remaining := restic.Snapshots{}
+			for _, sn := range allSnapshots {
+				if !removed.Has(*sn.ID()) {
+					remaining = append(remaining, sn)
+				}
+			}
+
+			if err = <-indexLoaded; err != nil {
+				return err
+			}
+
+			return pruneRepository(gopts, opts.PruneOptions, repo, remaining, true, func() error {
+				if err := unlockRepo(lock); err != nil {
+					return err
+				}
+				lock, err = lockRepoExclusive(repo)
+				return err
+			})
 		}
 	}
 
@@ -233,6 +375,8 @@ type ForgetGroup struct {
 	Keep    []Snapshot          `json:"keep"`
 	Remove  []Snapshot          `json:"remove"`
 	Reasons []restic.KeepReason `json:"reasons"`
+
+	RemoveReasons []restic.RemoveReason `json:"remove_reasons"`
 }
 
 func addJSONSnapshots(js *[]Snapshot, list restic.Snapshots) {
@@ -249,3 +393,22 @@ func addJSONSnapshots(js *[]Snapshot, list restic.Snapshots) {
 func printJSONForget(stdout io.Writer, forgets []*ForgetGroup) error {
 	return json.NewEncoder(stdout).Encode(forgets)
 }
+
+// snapshotIDs returns the IDs of the snapshots in list.
+func snapshotIDs(list restic.Snapshots) restic.IDs {
+	ids := make(restic.IDs, 0, len(list))
+	for _, sn := range list {
+		ids = append(ids, *sn.ID())
+	}
+	return ids
+}
+
+// auditSnapshots describes the snapshots removed by forget for the audit log.
+func auditSnapshots(list restic.Snapshots) []string {
+	details := make([]string, 0, len(list))
+	for _, sn := range list {
+		details = append(details, fmt.Sprintf("remove snapshot %v of %v at %v by %v@%v",
+			sn.ID().Str(), sn.Paths, sn.Time.Format(TimeFormat), sn.Username, sn.Hostname))
+	}
+	return details
+}
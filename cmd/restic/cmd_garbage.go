@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdGarbage = &cobra.Command{
+	Use:   "garbage [flags]",
+	Short: "Report data in the repository which prune would remove",
+	Long: `
+The "garbage" command analyzes the repository and reports data which is not
+needed any more: blobs which are not referenced by any snapshot, duplicate
+blobs, pack files which are not contained in the index and index entries for
+pack files which do not exist. It also shows how much space a run of "prune"
+could free at most.
+
+The command never modifies the repository and only needs a non-exclusive lock,
+so it can run while backups are created. Data which is added concurrently may
+be reported as unreferenced.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGarbage(globalOptions)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdGarbage)
+}
+
+// garbageReport summarizes the data in a repository which is not needed by
+// any snapshot.
+type garbageReport struct {
+	Packs     int    `json:"packs"`
+	TotalSize uint64 `json:"total_size"`
+	Blobs     int    `json:"blobs"`
+
+	UnreferencedBlobs int    `json:"unreferenced_blobs"`
+	UnreferencedSize  uint64 `json:"unreferenced_size"`
+	DuplicateBlobs    int    `json:"duplicate_blobs"`
+	DuplicateSize     uint64 `json:"duplicate_size"`
+
+	UnusedPacks     int    `json:"unused_packs"`
+	UnusedPacksSize uint64 `json:"unused_packs_size"`
+	PartlyUsedPacks int    `json:"partly_used_packs"`
+
+	UnindexedPacks     int    `json:"unindexed_packs"`
+	UnindexedPacksSize uint64 `json:"unindexed_packs_size"`
+	MissingPacks       int    `json:"missing_packs"`
+	MissingPackEntries int    `json:"missing_pack_entries"`
+
+	PotentialSavings uint64 `json:"potential_savings"`
+}
+
+// analyzeGarbage computes the report for the pack files in the backend with
+// their sizes, the blobs listed in the index and the blobs used by snapshots.
+// Of the copies of a used blob, only the first is counted as used.
+func analyzeGarbage(packSizes map[restic.ID]int64, indexed []restic.PackedBlob, usedBlobs restic.BlobSet) garbageReport {
+	var report garbageReport
+
+	for _, size := range packSizes {
+		report.Packs++
+		report.TotalSize += uint64(size)
+	}
+
+	missingPacks := restic.NewIDSet()
+	indexedPacks := restic.NewIDSet()
+	usedPacks := restic.NewIDSet()
+	garbagePacks := restic.NewIDSet()
+	seenBlobs := restic.NewBlobSet()
+
+	type packedHandle struct {
+		restic.BlobHandle
+		pack restic.ID
+	}
+	seenEntries := make(map[packedHandle]struct{})
+
+	for _, pb := range indexed {
+		h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+
+		// the same pack may be listed in several index files
+		entry := packedHandle{h, pb.PackID}
+		if _, ok := seenEntries[entry]; ok {
+			continue
+		}
+		seenEntries[entry] = struct{}{}
+
+		if _, ok := packSizes[pb.PackID]; !ok {
+			missingPacks.Insert(pb.PackID)
+			report.MissingPackEntries++
+			continue
+		}
+
+		indexedPacks.Insert(pb.PackID)
+		report.Blobs++
+
+		switch {
+		case !usedBlobs.Has(h):
+			report.UnreferencedBlobs++
+			report.UnreferencedSize += uint64(pb.Length)
+			garbagePacks.Insert(pb.PackID)
+		case seenBlobs.Has(h):
+			report.DuplicateBlobs++
+			report.DuplicateSize += uint64(pb.Length)
+			garbagePacks.Insert(pb.PackID)
+		default:
+			seenBlobs.Insert(h)
+			usedPacks.Insert(pb.PackID)
+		}
+	}
+
+	for id, size := range packSizes {
+		switch {
+		case !indexedPacks.Has(id):
+			report.UnindexedPacks++
+			report.UnindexedPacksSize += uint64(size)
+		case !usedPacks.Has(id):
+			report.UnusedPacks++
+			report.UnusedPacksSize += uint64(size)
+		case garbagePacks.Has(id):
+			report.PartlyUsedPacks++
+		}
+	}
+
+	report.MissingPacks = len(missingPacks)
+	report.PotentialSavings = report.UnreferencedSize + report.DuplicateSize + report.UnindexedPacksSize
+
+	return report
+}
+
+func runGarbage(gopts GlobalOptions) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx := gopts.ctx
+
+	Verbosef("load index files\n")
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("list pack files\n")
+	packSizes := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packSizes[id] = size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var indexed []restic.PackedBlob
+	for pb := range repo.Index().Each(ctx) {
+		indexed = append(indexed, pb)
+	}
+
+	// the progress bar would garble the JSON output
+	findOpts := gopts
+	findOpts.Quiet = gopts.Quiet || gopts.JSON
+	usedBlobs, err := findUsedBlobs(findOpts, repo, nil)
+	if err != nil {
+		return err
+	}
+
+	report := analyzeGarbage(packSizes, indexed, usedBlobs)
+
+	if gopts.JSON {
+		err = json.NewEncoder(gopts.stdout).Encode(report)
+		if err != nil {
+			return fmt.Errorf("encoding output: %v", err)
+		}
+		return nil
+	}
+
+	Printf("repository contains %d packs with %s, the index lists %d blobs\n",
+		report.Packs, formatBytes(report.TotalSize), report.Blobs)
+	Printf("unreferenced blobs:   %d (%s)\n", report.UnreferencedBlobs, formatBytes(report.UnreferencedSize))
+	Printf("duplicate blobs:      %d (%s)\n", report.DuplicateBlobs, formatBytes(report.DuplicateSize))
+	Printf("unused packs:         %d (%s)\n", report.UnusedPacks, formatBytes(report.UnusedPacksSize))
+	Printf("partly used packs:    %d\n", report.PartlyUsedPacks)
+	Printf("packs not in index:   %d (%s)\n", report.UnindexedPacks, formatBytes(report.UnindexedPacksSize))
+	if report.MissingPacks > 0 {
+		Warnf("the index lists %d blobs in %d pack files which do not exist, run 'restic check' for details\n",
+			report.MissingPackEntries, report.MissingPacks)
+	}
+	Printf("prune could free up to %s\n", formatBytes(report.PotentialSavings))
+
+	return nil
+}
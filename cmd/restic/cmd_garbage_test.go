@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestAnalyzeGarbage(t *testing.T) {
+	packs := restic.IDs{restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()}
+	blobs := restic.IDs{restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()}
+
+	// packs[3] is not indexed, packs[4] does not exist
+	packSizes := map[restic.ID]int64{
+		packs[0]: 1000,
+		packs[1]: 2000,
+		packs[2]: 3000,
+		packs[3]: 4000,
+	}
+
+	entry := func(blob, pack restic.ID, length uint) restic.PackedBlob {
+		return restic.PackedBlob{
+			Blob:   restic.Blob{ID: blob, Type: restic.DataBlob, Length: length},
+			PackID: pack,
+		}
+	}
+
+	indexed := []restic.PackedBlob{
+		entry(blobs[0], packs[0], 500),
+		entry(blobs[1], packs[0], 400),
+		// listed twice in different index files
+		entry(blobs[1], packs[0], 400),
+		// duplicate of a used blob
+		entry(blobs[0], packs[1], 500),
+		entry(blobs[2], packs[1], 1400),
+		entry(blobs[3], packs[2], 2900),
+		entry(blobs[3], packs[4], 2900),
+	}
+
+	used := restic.NewBlobSet(
+		restic.BlobHandle{ID: blobs[0], Type: restic.DataBlob},
+		restic.BlobHandle{ID: blobs[2], Type: restic.DataBlob},
+	)
+
+	report := analyzeGarbage(packSizes, indexed, used)
+
+	rtest.Equals(t, garbageReport{
+		Packs:     4,
+		TotalSize: 10000,
+		Blobs:     5,
+
+		UnreferencedBlobs: 2,
+		UnreferencedSize:  3300,
+		DuplicateBlobs:    1,
+		DuplicateSize:     500,
+
+		UnusedPacks:     1,
+		UnusedPacksSize: 3000,
+		PartlyUsedPacks: 2,
+
+		UnindexedPacks:     1,
+		UnindexedPacksSize: 4000,
+		MissingPacks:       1,
+		MissingPackEntries: 1,
+
+		PotentialSavings: 7800,
+	}, report)
+}
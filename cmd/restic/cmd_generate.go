@@ -15,6 +15,12 @@ var cmdGenerate = &cobra.Command{
 The "generate" command writes automatically generated files (like the man pages
 and the auto-completion files for bash and zsh).
 
+The bash completion, and the zsh completion written by --completion zsh,
+suggest snapshot IDs, hosts, tags and paths from the repository. The repository
+and password are taken from the command line being completed or from the
+environment variables, restic never asks for a password while completing. With
+RESTIC_COMPLETION_CACHE=1, the values are cached unencrypted for five minutes.
+
 EXIT STATUS
 ===========
 
@@ -28,6 +34,7 @@ type generateOptions struct {
 	ManDir             string
 	BashCompletionFile string
 	ZSHCompletionFile  string
+	Completion         string
 }
 
 var genOpts generateOptions
@@ -38,6 +45,7 @@ func init() {
 	fs.StringVar(&genOpts.ManDir, "man", "", "write man pages to `directory`")
 	fs.StringVar(&genOpts.BashCompletionFile, "bash-completion", "", "write bash completion `file`")
 	fs.StringVar(&genOpts.ZSHCompletionFile, "zsh-completion", "", "write zsh completion `file`")
+	fs.StringVar(&genOpts.Completion, "completion", "", "write the completion for `shell` (bash, zsh) to stdout")
 }
 
 func writeManpages(dir string) error {
@@ -60,6 +68,9 @@ func writeManpages(dir string) error {
 
 func writeBashCompletion(file string) error {
 	Verbosef("writing bash completion file to %v\n", file)
+	if err := setupCompletion(); err != nil {
+		return err
+	}
 	return cmdRoot.GenBashCompletionFile(file)
 }
 
@@ -90,6 +101,13 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if genOpts.Completion != "" {
+		err := writeCompletion(genOpts.Completion)
+		if err != nil {
+			return err
+		}
+	}
+
 	var empty generateOptions
 	if genOpts == empty {
 		return errors.Fatal("nothing to do, please specify at least one output file/dir")
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
 )
@@ -13,6 +17,14 @@ var cmdInit = &cobra.Command{
 	Long: `
 The "init" command initializes a new repository.
 
+With --max-repo-size, the size of the repository is limited, for example to
+"2T". Backups which would exceed the limit are aborted with exit status 4. The
+limit can be changed later with "config set max-repo-size".
+
+With --copy-chunker-params, the new repository uses the same chunker parameters
+as the repository given with --from-repo. Snapshots copied from that repository
+with "copy" then deduplicate with new backups.
+
 EXIT STATUS
 ===========
 
@@ -20,19 +32,73 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit(globalOptions, args)
+		return runInit(initOptions, globalOptions, args)
 	},
 }
 
+// InitOptions bundles all options for the init command.
+type InitOptions struct {
+	SourceRepoOptions
+
+	MaxRepoSize       string
+	CopyChunkerParams bool
+}
+
+var initOptions InitOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdInit)
+
+	f := cmdInit.Flags()
+	addSourceRepoOptions(f, &initOptions.SourceRepoOptions)
+	f.BoolVar(&initOptions.CopyChunkerParams, "copy-chunker-params", false, "copy chunker parameters from the repository given with --from-repo")
+	f.StringVar(&initOptions.MaxRepoSize, "max-repo-size", "", "limit the size of the repository to `size`, backups exceeding it are aborted (allowed suffixes: k/K, m/M, g/G, t/T)")
+}
+
+// sourceChunkerPolynomial returns the chunker polynomial of the source
+// repository.
+func sourceChunkerPolynomial(opts SourceRepoOptions, gopts GlobalOptions) (chunker.Pol, error) {
+	srcOpts, err := sourceGlobalOptions(opts, gopts)
+	if err != nil {
+		return 0, err
+	}
+
+	srcRepo, err := OpenRepository(srcOpts)
+	if err != nil {
+		return 0, err
+	}
+
+	pol := srcRepo.Config().ChunkerPolynomial
+	Verbosef("using chunker parameters from repository at %s\n", opts.FromRepo)
+	return pol, nil
 }
 
-func runInit(gopts GlobalOptions, args []string) error {
+func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	if gopts.Repo == "" {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
+	cfg, err := restic.CreateConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxRepoSize != "" {
+		size, err := parseSizeStr(opts.MaxRepoSize)
+		if err != nil {
+			return errors.Fatalf("invalid --max-repo-size: %v", err)
+		}
+		cfg.MaxRepoSize = uint64(size)
+	}
+
+	if opts.CopyChunkerParams {
+		pol, err := sourceChunkerPolynomial(opts.SourceRepoOptions, gopts)
+		if err != nil {
+			return err
+		}
+		cfg.ChunkerPolynomial = pol
+	}
+
 	be, err := create(gopts.Repo, gopts.extended)
 	if err != nil {
 		return errors.Fatalf("create repository at %s failed: %v\n", gopts.Repo, err)
@@ -47,11 +113,19 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	s := repository.New(be)
 
-	err = s.Init(gopts.ctx, gopts.password)
+	err = s.InitWithConfig(gopts.ctx, gopts.password, cfg)
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", gopts.Repo, err)
 	}
 
+	if gopts.JSON {
+		return json.NewEncoder(gopts.stdout).Encode(initSummary{
+			MessageType: "initialized",
+			ID:          s.Config().ID,
+			Repository:  gopts.Repo,
+		})
+	}
+
 	Verbosef("created restic repository %v at %s\n", s.Config().ID[:10], gopts.Repo)
 	Verbosef("\n")
 	Verbosef("Please note that knowledge of your password is required to access\n")
@@ -60,3 +134,10 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	return nil
 }
+
+// initSummary is printed by init in JSON mode.
+type initSummary struct {
+	MessageType string `json:"message_type"` // "initialized"
+	ID          string `json:"id"`
+	Repository  string `json:"repository"`
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
@@ -16,11 +20,65 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [list|add|remove|passwd] [ID]",
+	Use:   "key [list|add|remove|passwd|rotate-master|store-password|calibrate] [ID]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+The key derivation function (KDF) and its parameters used for new keys can be
+set with --new-kdf-params, either "scrypt:N=32768,r=8,p=1" or
+"argon2id:t=3,m=65536,p=4" (memory in KiB). Parameters which are omitted are
+set to their defaults. With "ms=<milliseconds>", the parameters are calibrated
+so that opening the key takes about this long on the current machine, e.g.
+"argon2id:m=262144,p=4,ms=1000" selects the number of passes for argon2id. The
+parameters of existing keys are shown by "key list".
+
+The "calibrate" operation measures which KDF parameters take about the target
+time given with --new-kdf-params (default "scrypt:ms=1000") on the current
+machine, and stores them in the repository setting "kdf-params". They are then
+used for all new keys for which --new-kdf-params is not given, also on slower
+machines. With --dry-run, the parameters are only printed.
+
+New passwords are checked for their strength, and a warning is printed for
+weak passwords, which are short or use only few different characters.
+
+With --new-wrap-command, "add" and "passwd" create a key which does not need a
+password. The master key is passed on stdin to the command, which must write
+the master key wrapped by a key management service (for example AWS KMS, Azure
+Key Vault, GCP KMS or HashiCorp Vault) to stdout. Such a key is opened with the
+global option --key-unwrap-command, which receives the wrapped key on stdin
+and must write the master key to stdout. If --new-unwrap-command is given, the
+new key is unwrapped once to make sure the master key can be recovered. This is
+required for "passwd", which removes the current key.
+
+The "rotate-master" operation rewraps the master key of the current key with a
+new salt and the KDF parameters from --new-kdf-params, the password stays the
+same. The master key itself is not changed, as this would require encrypting
+all data in the repository again.
+
+With --new-key-expires-after, "add" and "passwd" create a key which expires
+after the given duration, e.g. "90d" or "1y". The repository setting
+"max-key-age" (see "restic config") expires all keys once they are older than
+the given duration. Commands print a warning when an expired key is used, or
+refuse to run if the setting "expired-keys" is "refuse". The "key" command
+always accepts expired keys so that they can be replaced. The expiry of each
+key is shown by "key list".
+
+With --new-key-scope, the operations allowed with the new key can be
+restricted. Keys with the scope "backup" cannot be used for commands which
+remove data, snapshots or keys, like "forget", "prune", "tag" or "key remove",
+while keys with the scope "admin" can be used for all commands. New keys have
+the scope of the current key by default. The scope is stored in the encrypted
+part of the key, so that changing it in the key file is detected. It is
+enforced by restic itself, a modified client can ignore it.
+
+The "store-password" operation saves the password of the current key in the
+keychain of the operating system: the Keychain on macOS, the Credential Manager
+on Windows and the Secret Service (via secret-tool) on other systems. The entry
+is named after the global option --password-keychain, or the repository
+location if it is not set. Afterwards, the password is read from the keychain
+with --password-keychain, or the password source "keyring".
+
 EXIT STATUS
 ===========
 
@@ -33,12 +91,26 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 }
 
 var newPasswordFile string
+var newKDFParams string
+var newWrapCommand string
+var newWrapDescription string
+var newUnwrapCommand string
+var newKeyExpiresAfter restic.Duration
+var newKeyScope string
+var calibrateDryRun bool
 
 func init() {
 	cmdRoot.AddCommand(cmdKey)
 
 	flags := cmdKey.Flags()
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "the file from which to load a new password")
+	flags.StringVarP(&newWrapCommand, "new-wrap-command", "", "", "wrap the master key for the new key with a shell `command` instead of a password")
+	flags.StringVarP(&newUnwrapCommand, "new-unwrap-command", "", "", "verify the key created with --new-wrap-command by unwrapping it with a shell `command`")
+	flags.StringVarP(&newWrapDescription, "new-wrap-description", "", "", "store a `description` of the external key used by --new-wrap-command")
+	flags.VarP(&newKeyExpiresAfter, "new-key-expires-after", "", "expire the new key after the given `duration` (e.g. 90d or 1y)")
+	flags.StringVarP(&newKeyScope, "new-key-scope", "", "", "restrict the operations allowed with the new key to `scope` \"backup\" or allow all with \"admin\" (default: scope of the current key)")
+	flags.BoolVarP(&calibrateDryRun, "dry-run", "n", false, "only print the parameters selected by \"calibrate\", do not store them")
+	flags.StringVarP(&newKDFParams, "new-kdf-params", "", "", "use the key derivation function and `parameters` for new keys, e.g. scrypt:N=32768,r=8,p=1 or argon2id:t=3,m=65536,p=4, calibrated for a target time with ms=1000")
 }
 
 func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
@@ -48,9 +120,14 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 		UserName string `json:"userName"`
 		HostName string `json:"hostName"`
 		Created  string `json:"created"`
+		KDF      string `json:"kdf"`
+		Expires  string `json:"expires,omitempty"`
+		Expired  bool   `json:"expired"`
+		Scope    string `json:"scope"`
 	}
 
 	var keys []keyInfo
+	maxAge := maxKeyAge(s.Config())
 
 	err := s.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
 		k, err := repository.LoadKey(ctx, s, id.String())
@@ -65,6 +142,18 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 			UserName: k.Username,
 			HostName: k.Hostname,
 			Created:  k.Created.Local().Format(TimeFormat),
+			KDF:      k.KDFParams().String(),
+			Scope:    k.Scope,
+		}
+		if key.Scope == "" {
+			key.Scope = repository.ScopeAdmin
+		}
+		if k.KDF == repository.KDFWrapped && k.Wrapper != "" {
+			key.KDF += " (" + k.Wrapper + ")"
+		}
+		if expires, ok := k.ExpiresAt(maxAge); ok {
+			key.Expires = expires.Local().Format(TimeFormat)
+			key.Expired = !time.Now().Before(expires)
 		}
 
 		keys = append(keys, key)
@@ -84,6 +173,9 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 	tab.AddColumn("User", "{{ .UserName }}")
 	tab.AddColumn("Host", "{{ .HostName }}")
 	tab.AddColumn("Created", "{{ .Created }}")
+	tab.AddColumn("Scope", "{{ .Scope }}")
+	tab.AddColumn("KDF", "{{ .KDF }}")
+	tab.AddColumn("Expires", "{{ .Expires }}{{if .Expired}} (expired){{end}}")
 
 	for _, key := range keys {
 		tab.AddRow(key)
@@ -114,55 +206,392 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 		"enter password again: ")
 }
 
-func addKey(gopts GlobalOptions, repo *repository.Repository) error {
-	pw, err := getNewPassword(gopts)
+// weakPasswordBits is the estimated strength below which a warning is printed
+// for new passwords.
+const weakPasswordBits = 50
+
+// passwordStrength returns a rough estimate of the strength of pw in bits.
+// Each character counts with the size of the character classes used in the
+// password, except for repeated characters and simple sequences like "abc"
+// or "123", which count with a single bit.
+func passwordStrength(pw string) float64 {
+	var lower, upper, digit, other bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	var bits float64
+	prev := rune(-1)
+	for _, r := range pw {
+		if r == prev || r == prev+1 || r == prev-1 {
+			bits++
+		} else {
+			bits += math.Log2(float64(pool))
+		}
+		prev = r
+	}
+	return bits
+}
+
+// warnWeakPassword prints a warning if pw is easy to guess.
+func warnWeakPassword(pw string) {
+	if bits := passwordStrength(pw); bits < weakPasswordBits {
+		Warnf("warning: the new password is weak (about %.0f bits), use a longer password or a passphrase of several random words\n", bits)
+	}
+}
+
+// getNewKDFParams returns the KDF parameters for new keys, or nil if the
+// defaults should be used. Without --new-kdf-params, the parameters stored
+// in the repository setting "kdf-params" are used.
+func getNewKDFParams(repo *repository.Repository) (*repository.KDFParams, error) {
+	if newKDFParams == "" {
+		params := repo.Config().KDFParams
+		if params == "" {
+			return nil, nil
+		}
+
+		p, err := repository.ParseKDFParams(params)
+		if err != nil {
+			return nil, errors.Fatalf("invalid kdf-params in the repository config: %v", err)
+		}
+		return &p, nil
+	}
+
+	p, err := repository.ParseKDFParams(newKDFParams)
+	if err != nil {
+		return nil, errors.Fatalf("invalid --new-kdf-params: %v", err)
+	}
+	return &p, nil
+}
+
+// calibrateKDF selects the KDF parameters for the target time given with
+// --new-kdf-params on this machine and stores them in the repository config.
+func calibrateKDF(gopts GlobalOptions, repo *repository.Repository) error {
+	spec := newKDFParams
+	if spec == "" {
+		spec = "scrypt:ms=1000"
+	}
+
+	p, err := repository.ParseKDFParams(spec)
+	if err != nil {
+		return errors.Fatalf("invalid --new-kdf-params: %v", err)
+	}
+	if p.TargetTime == 0 {
+		return errors.Fatal("--new-kdf-params needs a target time for calibration, e.g. scrypt:ms=1000")
+	}
+
+	Verbosef("calibrating %v for %v\n", p.KDF, p.TargetTime)
+	calibrated, err := p.Calibrate()
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key())
+	if calibrateDryRun {
+		if gopts.JSON {
+			return printCalibrateSummary(gopts, calibrated.String(), false)
+		}
+		Printf("%v\n", calibrated)
+		return nil
+	}
+
+	cfg := repo.Config()
+	cfg.KDFParams = calibrated.String()
+	if err = repo.SaveConfig(gopts.ctx, cfg); err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return printCalibrateSummary(gopts, cfg.KDFParams, true)
+	}
+	Printf("kdf-params set to %v\n", cfg.KDFParams)
+	return nil
+}
+
+// calibrateSummary is printed by "key calibrate" in JSON mode.
+type calibrateSummary struct {
+	MessageType string `json:"message_type"` // "summary"
+	KDFParams   string `json:"kdf_params"`
+	Saved       bool   `json:"saved"`
+}
+
+func printCalibrateSummary(gopts GlobalOptions, params string, saved bool) error {
+	return json.NewEncoder(gopts.stdout).Encode(calibrateSummary{
+		MessageType: "summary",
+		KDFParams:   params,
+		Saved:       saved,
+	})
+}
+
+// getNewKeyOptions returns the meta data for new keys. The expiry is zero if
+// --new-key-expires-after is not set, and the scope defaults to the scope of
+// the current key, which cannot be extended.
+func getNewKeyOptions(gopts GlobalOptions, repo *repository.Repository) (repository.KeyOptions, error) {
+	var opts repository.KeyOptions
+
+	if d := newKeyExpiresAfter; !d.Zero() {
+		opts.Expires = time.Now().AddDate(d.Years, d.Months, d.Days).Add(time.Duration(d.Hours) * time.Hour)
+	}
+
+	current, err := repository.LoadKey(gopts.ctx, repo, repo.KeyName())
 	if err != nil {
-		return errors.Fatalf("creating new key failed: %v\n", err)
+		return opts, err
+	}
+
+	switch newKeyScope {
+	case "":
+		opts.Scope = current.Scope
+	case repository.ScopeAdmin, repository.ScopeBackup:
+		opts.Scope = newKeyScope
+	default:
+		return opts, errors.Fatalf("invalid key scope %q, must be %q or %q", newKeyScope, repository.ScopeAdmin, repository.ScopeBackup)
+	}
+
+	if (opts.Scope == "" || opts.Scope == repository.ScopeAdmin) && !current.IsAdmin() {
+		return opts, errors.Fatalf("a key of scope %q cannot create keys of scope %q", current.Scope, repository.ScopeAdmin)
+	}
+
+	return opts, nil
+}
+
+// createNewKey adds a new key for the master key of repo, which is either
+// wrapped with --new-wrap-command or encrypted with a new password.
+func createNewKey(gopts GlobalOptions, repo *repository.Repository) (*repository.Key, error) {
+	opts, err := getNewKeyOptions(gopts, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if newWrapCommand != "" {
+		wrap, err := keyCommand(newWrapCommand)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := repository.AddWrappedKey(gopts.ctx, repo, newWrapDescription, wrap, repo.Key(), opts)
+		if err != nil {
+			return nil, errors.Fatalf("creating new key failed: %v\n", err)
+		}
+
+		if newUnwrapCommand == "" {
+			return id, nil
+		}
+
+		// make sure the master key can be recovered from the new key
+		unwrap, err := keyCommand(newUnwrapCommand)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = repository.OpenWrappedKey(gopts.ctx, repo, id.Name(), unwrap); err != nil {
+			h := restic.Handle{Type: restic.KeyFile, Name: id.Name()}
+			_ = repo.Backend().Remove(gopts.ctx, h)
+			return nil, errors.Fatalf("unable to unwrap the new key: %v", err)
+		}
+
+		return id, nil
+	}
+
+	kdf, err := getNewKDFParams(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := getNewPassword(gopts)
+	if err != nil {
+		return nil, err
+	}
+	warnWeakPassword(pw)
+
+	id, err := repository.AddKeyWithParams(gopts.ctx, repo, pw, repo.Key(), kdf, opts)
+	if err != nil {
+		return nil, errors.Fatalf("creating new key failed: %v\n", err)
+	}
+
+	if kdf != nil && kdf.TargetTime > 0 {
+		Verbosef("calibrated KDF parameters are %v\n", id.KDFParams())
 	}
 
+	return id, nil
+}
+
+func addKey(gopts GlobalOptions, repo *repository.Repository) error {
+	id, err := createNewKey(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return printKeyChange(gopts, "add", id.Name())
+	}
 	Verbosef("saved new key as %s\n", id)
 
 	return nil
 }
 
-func deleteKey(ctx context.Context, repo *repository.Repository, name string) error {
+// keyChange is printed by the key commands which modify keys in JSON mode.
+type keyChange struct {
+	MessageType string `json:"message_type"` // "key"
+	Action      string `json:"action"`
+	ID          string `json:"id"`
+}
+
+func printKeyChange(gopts GlobalOptions, action, id string) error {
+	return json.NewEncoder(gopts.stdout).Encode(keyChange{
+		MessageType: "key",
+		Action:      action,
+		ID:          id,
+	})
+}
+
+func deleteKey(gopts GlobalOptions, repo *repository.Repository, name string) error {
+	ctx := gopts.ctx
+
 	if name == repo.KeyName() {
 		return errors.Fatal("refusing to remove key currently used to access repository")
 	}
 
+	auditLog(ctx, repo, "key remove", nil, fmt.Sprintf("remove key %v", name))
+
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
 	err := repo.Backend().Remove(ctx, h)
 	if err != nil {
 		return err
 	}
 
+	if gopts.JSON {
+		return printKeyChange(gopts, "remove", name)
+	}
 	Verbosef("removed key %v\n", name)
 	return nil
 }
 
 func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
-	pw, err := getNewPassword(gopts)
+	if newWrapCommand != "" && newUnwrapCommand == "" {
+		return errors.Fatal("--new-unwrap-command is required to replace the current key with a wrapped key")
+	}
+
+	id, err := createNewKey(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	auditLog(gopts.ctx, repo, "key passwd", nil, fmt.Sprintf("replace key %v by %v", repo.KeyName(), id.Name()))
+
+	h := restic.Handle{Type: restic.KeyFile, Name: repo.KeyName()}
+	err = repo.Backend().Remove(gopts.ctx, h)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key())
+	if gopts.JSON {
+		return printKeyChange(gopts, "passwd", id.Name())
+	}
+	Verbosef("saved new key as %s\n", id)
+
+	return nil
+}
+
+// storePassword saves the password of the current key in the keychain of the
+// operating system.
+func storePassword(gopts GlobalOptions, repo *repository.Repository) error {
+	var err error
+	pw := gopts.password
+	if pw == "" {
+		pw, err = ReadPassword(gopts, "enter password to store: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	// make sure the password belongs to the current key
+	_, err = repository.OpenKey(gopts.ctx, repo, repo.KeyName(), pw)
+	if err != nil {
+		return errors.Fatalf("password does not match the current key: %v", err)
+	}
+
+	name := keychainName(gopts)
+	if err = storeKeychain(name, pw); err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	Verbosef("stored password in keychain entry %q\n", name)
+	return nil
+}
+
+// rotateMasterKey replaces the current key by a new key for the same
+// password, which wraps the master key with a new salt and KDF parameters.
+func rotateMasterKey(gopts GlobalOptions, repo *repository.Repository) error {
+	kdf, err := getNewKDFParams(repo)
+	if err != nil {
+		return err
+	}
+
+	pw := gopts.password
+	if pw == "" {
+		pw, err = ReadPassword(gopts, "enter current password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	// make sure the password belongs to the current key
+	current, err := repository.OpenKey(gopts.ctx, repo, repo.KeyName(), pw)
+	if err != nil {
+		return errors.Fatalf("password does not match the current key: %v", err)
+	}
+
+	opts, err := getNewKeyOptions(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	// the password stays the same, so keep the expiry unless a new one is given
+	if opts.Expires.IsZero() && current.Expires != nil {
+		opts.Expires = *current.Expires
+	}
+
+	id, err := repository.AddKeyWithParams(gopts.ctx, repo, pw, repo.Key(), kdf, opts)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
 
+	auditLog(gopts.ctx, repo, "key rotate-master", nil, fmt.Sprintf("replace key %v by %v", repo.KeyName(), id.Name()))
+
 	h := restic.Handle{Type: restic.KeyFile, Name: repo.KeyName()}
 	err = repo.Backend().Remove(gopts.ctx, h)
 	if err != nil {
 		return err
 	}
 
-	Verbosef("saved new key as %s\n", id)
+	if gopts.JSON {
+		return printKeyChange(gopts, "rotate-master", id.Name())
+	}
+	Verbosef("rewrapped master key with %v, saved new key as %s\n", id.KDFParams(), id)
 
 	return nil
 }
@@ -175,6 +604,9 @@ func runKey(gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	// expired keys must still be usable to replace them
+	gopts.allowExpiredKey = true
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -196,6 +628,10 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
+		if err = requireAdminKey(gopts, repo, "key add"); err != nil {
+			return err
+		}
+
 		return addKey(gopts, repo)
 	case "remove":
 		lock, err := lockRepoExclusive(repo)
@@ -204,12 +640,20 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
+		if err = requireAdminKey(gopts, repo, "key remove"); err != nil {
+			return err
+		}
+
 		id, err := restic.Find(repo.Backend(), restic.KeyFile, args[1])
 		if err != nil {
 			return err
 		}
 
-		return deleteKey(gopts.ctx, repo, id)
+		if err = requireApproval(gopts, repo, "key-remove", id); err != nil {
+			return err
+		}
+
+		return deleteKey(gopts, repo, id)
 	case "passwd":
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
@@ -218,6 +662,32 @@ func runKey(gopts GlobalOptions, args []string) error {
 		}
 
 		return changePassword(gopts, repo)
+	case "rotate-master":
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return rotateMasterKey(gopts, repo)
+	case "store-password":
+		return storePassword(gopts, repo)
+	case "calibrate":
+		if calibrateDryRun {
+			return calibrateKDF(gopts, repo)
+		}
+
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		if err = requireAdminKey(gopts, repo, "key calibrate"); err != nil {
+			return err
+		}
+
+		return calibrateKDF(gopts, repo)
 	}
 
 	return nil
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestPasswordStrength(t *testing.T) {
+	var tests = []struct {
+		password string
+		weak     bool
+	}{
+		{"", true},
+		{"password", true},
+		{"aaaaaaaaaaaaaaaaaaaaaaaa", true},
+		{"abcdefghijklmnopqrstuvwxyz", true},
+		{"1234567890123", true},
+		{"Tr0ub4dor&3", false},
+		{"correct horse battery staple", false},
+		{"OnnyiasyatvodsEvVodyawit", false},
+	}
+
+	for _, test := range tests {
+		bits := passwordStrength(test.password)
+		if weak := bits < weakPasswordBits; weak != test.weak {
+			t.Errorf("password %q: want weak %v, got %.1f bits", test.password, test.weak, bits)
+		}
+	}
+}
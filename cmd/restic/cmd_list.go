@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
@@ -49,6 +49,8 @@ func runList(cmd *cobra.Command, opts GlobalOptions, args []string) error {
 		}
 	}
 
+	enc := json.NewEncoder(opts.stdout)
+
 	var t restic.FileType
 	switch args[0] {
 	case "packs":
@@ -69,7 +71,14 @@ func runList(cmd *cobra.Command, opts GlobalOptions, args []string) error {
 
 		for _, pack := range idx.Packs {
 			for _, entry := range pack.Entries {
-				fmt.Printf("%v %v\n", entry.Type, entry.ID)
+				if opts.JSON {
+					err = enc.Encode(listEntry{MessageType: "blob", Type: entry.Type.String(), ID: entry.ID})
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				Printf("%v %v\n", entry.Type, entry.ID)
 			}
 		}
 
@@ -79,7 +88,17 @@ func runList(cmd *cobra.Command, opts GlobalOptions, args []string) error {
 	}
 
 	return repo.List(opts.ctx, t, func(id restic.ID, size int64) error {
+		if opts.JSON {
+			return enc.Encode(listEntry{MessageType: "file", ID: id})
+		}
 		Printf("%s\n", id)
 		return nil
 	})
 }
+
+// listEntry is printed by list in JSON mode for each file or blob.
+type listEntry struct {
+	MessageType string    `json:"message_type"` // "file" or "blob"
+	Type        string    `json:"type,omitempty"`
+	ID          restic.ID `json:"id"`
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+
 	"github.com/restic/restic/internal/migrations"
 	"github.com/restic/restic/internal/restic"
 
@@ -38,9 +40,25 @@ func init() {
 	f.BoolVarP(&migrateOptions.Force, "force", "f", false, `apply a migration a second time`)
 }
 
+// availableMigration is printed by migrate in JSON mode for each migration
+// which can be applied.
+type availableMigration struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// migrationResult is printed by migrate in JSON mode for each migration which
+// was applied.
+type migrationResult struct {
+	MessageType string `json:"message_type"` // "migration"
+	Name        string `json:"name"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+}
+
 func checkMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repository) error {
 	ctx := gopts.ctx
-	Printf("available migrations:\n")
+	available := []availableMigration{}
 	for _, m := range migrations.All {
 		ok, err := m.Check(ctx, repo)
 		if err != nil {
@@ -48,10 +66,19 @@ func checkMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 		}
 
 		if ok {
-			Printf("  %v: %v\n", m.Name(), m.Desc())
+			available = append(available, availableMigration{Name: m.Name(), Description: m.Desc()})
 		}
 	}
 
+	if gopts.JSON {
+		return json.NewEncoder(gopts.stdout).Encode(available)
+	}
+
+	Printf("available migrations:\n")
+	for _, m := range available {
+		Printf("  %v: %v\n", m.Name, m.Description)
+	}
+
 	return nil
 }
 
@@ -76,16 +103,27 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 					Warnf("check for migration %v failed, continuing anyway\n", m.Name())
 				}
 
-				Printf("applying migration %v...\n", m.Name())
+				if gopts.JSON {
+					Verbosef("applying migration %v...\n", m.Name())
+				} else {
+					Printf("applying migration %v...\n", m.Name())
+				}
 				if err = m.Apply(ctx, repo); err != nil {
 					Warnf("migration %v failed: %v\n", m.Name(), err)
 					if firsterr == nil {
 						firsterr = err
 					}
+					if gopts.JSON {
+						printMigrationResult(gopts, migrationResult{MessageType: "migration", Name: m.Name(), Error: err.Error()})
+					}
 					continue
 				}
 
-				Printf("migration %v: success\n", m.Name())
+				if gopts.JSON {
+					printMigrationResult(gopts, migrationResult{MessageType: "migration", Name: m.Name(), Success: true})
+				} else {
+					Printf("migration %v: success\n", m.Name())
+				}
 			}
 		}
 	}
@@ -93,6 +131,12 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 	return firsterr
 }
 
+func printMigrationResult(gopts GlobalOptions, res migrationResult) {
+	if err := json.NewEncoder(gopts.stdout).Encode(res); err != nil {
+		Warnf("JSON encode failed: %v\n", err)
+	}
+}
+
 func runMigrate(opts MigrateOptions, gopts GlobalOptions, args []string) error {
 	repo, err := OpenRepository(gopts)
 	if err != nil {
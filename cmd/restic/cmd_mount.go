@@ -6,7 +6,10 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 
@@ -28,11 +31,31 @@ var cmdMount = &cobra.Command{
 	Short: "Mount the repository",
 	Long: `
 The "mount" command mounts the repository via fuse to a directory. This is a
-read-only mount.
+read-only mount, unless --writable is given. Then the files in the snapshots
+can be modified, e.g. by programs which always open files for writing. The
+changes are stored in a temporary directory and are discarded when the
+repository is unmounted, the snapshots in the repository are never modified.
 
 Snapshot Directories
 ====================
 
+The snapshots are organized in directories according to path templates, by
+default:
+
+    ids/%i          snapshots by ID
+    snapshots/%T    all snapshots by time
+    hosts/%h/%T     snapshots by host and time
+    tags/%t/%T      snapshots by tag and time
+    paths/%p/%T     snapshots by their paths and time
+
+The placeholders are %i (short snapshot ID), %I (long snapshot ID), %T (time,
+see below), %h (hostname), %u (username), %t (tag, a snapshot is listed for
+each of its tags), %p (a path of the snapshot, as nested directories) and %%
+(a percent sign). The last component must contain %i, %I or %T. Each
+directory which contains snapshots also contains a symlink "latest" to the
+newest snapshot. To use different templates, pass --path-template for each
+of them, e.g. --path-template "%h/%u/%T".
+
 If you need a different template for all directories that contain snapshots,
 you can pass a template via --snapshot-template. Example without colons:
 
@@ -45,6 +68,26 @@ You need to specify a sample format for exactly the following timestamp:
 For details please see the documentation for time.Format() at:
   https://godoc.org/time#Time.Format
 
+Ownership
+=========
+
+Files are owned by the user and group IDs recorded in the snapshot, which may
+not exist on this system. Pass --uid-map and --gid-map with "from:to" to map
+IDs, e.g. --uid-map 1000:1001, or "*:to" to map all other IDs, e.g.
+--uid-map "*:$(id -u)". With --owner-root, all files are owned by root.
+
+By default, only the user who mounted the repository can access it. With
+--allow-other, all users can access the files according to their
+permissions. For users other than root, this requires the option
+"user_allow_other" in /etc/fuse.conf.
+
+Repository Objects
+==================
+
+With --expose-internal, the directory ".repo" contains the decrypted objects
+of the repository: "trees/<id>" is the JSON document of a tree and "data/<id>"
+the contents of a data blob. The objects are listed from the index.
+
 EXIT STATUS
 ===========
 
@@ -61,10 +104,16 @@ type MountOptions struct {
 	OwnerRoot            bool
 	AllowOther           bool
 	NoDefaultPermissions bool
+	Writable             bool
+	BlobCacheSize        int
 	Hosts                []string
 	Tags                 restic.TagLists
 	Paths                []string
 	SnapshotTemplate     string
+	PathTemplates        []string
+	UIDMap               []string
+	GIDMap               []string
+	ExposeInternal       bool
 }
 
 var mountOptions MountOptions
@@ -75,30 +124,90 @@ func init() {
 	mountFlags := cmdMount.Flags()
 	mountFlags.BoolVar(&mountOptions.OwnerRoot, "owner-root", false, "use 'root' as the owner of files and dirs")
 	mountFlags.BoolVar(&mountOptions.AllowOther, "allow-other", false, "allow other users to access the data in the mounted directory")
+	mountFlags.StringArrayVar(&mountOptions.UIDMap, "uid-map", nil, "map the user ID `from:to` of the files, from can be '*' for all other IDs (can be specified multiple times)")
+	mountFlags.StringArrayVar(&mountOptions.GIDMap, "gid-map", nil, "map the group ID `from:to` of the files, from can be '*' for all other IDs (can be specified multiple times)")
 	mountFlags.BoolVar(&mountOptions.NoDefaultPermissions, "no-default-permissions", false, "for 'allow-other', ignore Unix permissions and allow users to read all snapshot files")
+	mountFlags.IntVar(&mountOptions.BlobCacheSize, "blob-cache-size", 64, "cache up to `MiB` of file contents and directories in memory, 0 disables the cache")
+	mountFlags.BoolVar(&mountOptions.Writable, "writable", false, "allow modifying the snapshot files, changes are stored in a temporary directory and discarded when unmounting")
+	mountFlags.BoolVar(&mountOptions.ExposeInternal, "expose-internal", false, "add the directory .repo with the decrypted tree and data blobs of the repository by ID")
 
 	mountFlags.StringArrayVarP(&mountOptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
 	mountFlags.Var(&mountOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
 	mountFlags.StringArrayVar(&mountOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
 
 	mountFlags.StringVar(&mountOptions.SnapshotTemplate, "snapshot-template", time.RFC3339, "set `template` to use for snapshot dirs")
+	mountFlags.StringArrayVar(&mountOptions.PathTemplates, "path-template", nil, "set `template` for the directories which contain snapshots, e.g. \"hosts/%h/%T\" (can be specified multiple times, default: ids, snapshots, hosts, tags and paths)")
+}
+
+// mountBackend describes how the repository is mounted.
+type mountBackend struct {
+	// name of the implementation which is reported to the user
+	name string
+
+	// options for the FUSE implementation
+	options []systemFuse.MountOption
+
+	// fallback mounts the repository if no FUSE implementation is available
+	fallback func(repo restic.Repository, mountpoint string) error
+
+	// note is printed as a warning before mounting
+	note string
+}
+
+// checkAllowOther returns an error if fusermount will refuse --allow-other,
+// because user_allow_other is not set in /etc/fuse.conf.
+func checkAllowOther() error {
+	if runtime.GOOS != "linux" || os.Geteuid() == 0 {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile("/etc/fuse.conf")
+	if err != nil && !os.IsNotExist(err) {
+		// let fusermount decide
+		debug.Log("unable to read fuse.conf: %v", err)
+		return nil
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.TrimSpace(line) == "user_allow_other" {
+			return nil
+		}
+	}
+
+	return errors.Fatal("--allow-other is only allowed for root unless the line \"user_allow_other\" is added to /etc/fuse.conf")
 }
 
 func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 	debug.Log("start mount")
 	defer debug.Log("finish mount")
 
-	repo, err := OpenRepository(gopts)
+	uidMap, err := fuse.ParseIDMap(opts.UIDMap)
+	if err != nil {
+		return errors.Fatal(err.Error())
+	}
+	gidMap, err := fuse.ParseIDMap(opts.GIDMap)
+	if err != nil {
+		return errors.Fatal(err.Error())
+	}
+
+	backend, err := detectMountBackend(opts, gopts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepo(repo)
-	defer unlockRepo(lock)
+	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = repo.LoadIndex(gopts.ctx)
 	if err != nil {
 		return err
@@ -112,10 +221,41 @@ func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 		}
 	}
 
+	if backend.note != "" {
+		Warnf("%v\n", backend.note)
+	}
+	if backend.fallback != nil {
+		Verbosef("no FUSE implementation found, mounting via %v\n", backend.name)
+		return backend.fallback(repo, mountpoint)
+	}
+	if backend.name != "" {
+		Verbosef("mounting via %v\n", backend.name)
+	}
+
+	var overlayDir string
+	if opts.Writable {
+		overlayDir, err = ioutil.TempDir("", "restic-mount-")
+		if err != nil {
+			return errors.Fatalf("unable to create temporary directory for changes: %v", err)
+		}
+		debug.Log("storing changes in %v", overlayDir)
+
+		AddCleanupHandler(func() error {
+			return os.RemoveAll(overlayDir)
+		})
+		defer func() {
+			_ = os.RemoveAll(overlayDir)
+		}()
+	}
+
 	mountOptions := []systemFuse.MountOption{
-		systemFuse.ReadOnly(),
 		systemFuse.FSName("restic"),
 	}
+	mountOptions = append(mountOptions, backend.options...)
+
+	if !opts.Writable {
+		mountOptions = append(mountOptions, systemFuse.ReadOnly())
+	}
 
 	if opts.AllowOther {
 		mountOptions = append(mountOptions, systemFuse.AllowOther())
@@ -141,13 +281,26 @@ func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 		Tags:             opts.Tags,
 		Paths:            opts.Paths,
 		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+		UIDMap:           uidMap,
+		GIDMap:           gidMap,
+		BlobCacheSize:    opts.BlobCacheSize * 1024 * 1024,
+		OverlayDir:       overlayDir,
+		ExposeInternal:   opts.ExposeInternal,
 	}
-	root, err := fuse.NewRoot(gopts.ctx, repo, cfg)
+	// stops work in the background when the mount is removed
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	root, err := fuse.NewRoot(ctx, repo, cfg)
 	if err != nil {
 		return err
 	}
 
 	Printf("Now serving the repository at %s\n", mountpoint)
+	if opts.Writable {
+		Printf("Changes to the snapshots are discarded when unmounting.\n")
+	}
 	Printf("When finished, quit with Ctrl-c or umount the mountpoint.\n")
 
 	debug.Log("serving mount at %v", mountpoint)
@@ -173,6 +326,26 @@ func runMount(opts MountOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("snapshot template string contains a slash (/) or backslash (\\) character")
 	}
 
+	if opts.BlobCacheSize < 0 {
+		return errors.Fatal("blob cache size must not be negative")
+	}
+
+	if opts.OwnerRoot && (len(opts.UIDMap) > 0 || len(opts.GIDMap) > 0) {
+		return errors.Fatal("--owner-root and --uid-map/--gid-map are mutually exclusive")
+	}
+
+	if opts.AllowOther {
+		if err := checkAllowOther(); err != nil {
+			return err
+		}
+	}
+
+	for _, template := range opts.PathTemplates {
+		if err := fuse.CheckPathTemplate(template); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
 	if len(args) == 0 {
 		return errors.Fatal("wrong number of parameters")
 	}
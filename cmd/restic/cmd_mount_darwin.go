@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	systemFuse "bazil.org/fuse"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/webdav"
+)
+
+// macFUSELocations are the FUSE implementations for macOS which can be used,
+// in order of preference.
+var macFUSELocations = []struct {
+	name  string
+	paths systemFuse.OSXFUSEPaths
+}{
+	{"macFUSE", systemFuse.OSXFUSEPaths{
+		DevicePrefix: "/dev/macfuse",
+		Load:         "/Library/Filesystems/macfuse.fs/Contents/Resources/load_macfuse",
+		Mount:        "/Library/Filesystems/macfuse.fs/Contents/Resources/mount_macfuse",
+		DaemonVar:    "_FUSE_DAEMON_PATH",
+	}},
+	{"osxfuse 3", systemFuse.OSXFUSELocationV3},
+	{"osxfuse 2", systemFuse.OSXFUSELocationV2},
+}
+
+// fuseTLocations are the files installed by FUSE-T.
+var fuseTLocations = []string{
+	"/usr/local/lib/libfuse-t.dylib",
+	"/opt/homebrew/lib/libfuse-t.dylib",
+	"/Library/Application Support/fuse-t",
+}
+
+// detectMountBackend returns the first FUSE implementation which is
+// installed. Without one, the repository is served via WebDAV and mounted
+// with the WebDAV client of macOS, which needs no kernel extension.
+func detectMountBackend(opts MountOptions, gopts GlobalOptions) (mountBackend, error) {
+	for _, l := range macFUSELocations {
+		if _, err := os.Stat(l.paths.Mount); err == nil {
+			debug.Log("found %v at %v", l.name, l.paths.Mount)
+			return mountBackend{
+				name:    l.name,
+				options: []systemFuse.MountOption{systemFuse.OSXFUSELocations(l.paths)},
+			}, nil
+		}
+	}
+
+	if opts.Writable {
+		return mountBackend{}, errors.Fatal("--writable requires macFUSE, which is not installed")
+	}
+
+	backend := mountBackend{
+		name: "WebDAV (mount_webdav)",
+		fallback: func(repo restic.Repository, mountpoint string) error {
+			return mountWebDAV(opts, repo, mountpoint)
+		},
+	}
+
+	for _, p := range fuseTLocations {
+		if _, err := os.Stat(p); err == nil {
+			backend.note = "FUSE-T is installed, but restic does not support it, the WebDAV fallback is used instead"
+			break
+		}
+	}
+
+	return backend, nil
+}
+
+// webdavCredentials returns the credentials in the format mount_webdav reads
+// them from the file descriptor passed with -a: the length of the user name as
+// a 32 bit integer in host byte order, the user name, and the same for the
+// password.
+func webdavCredentials(user, password string) []byte {
+	var buf bytes.Buffer
+	for _, s := range []string{user, password} {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	return buf.Bytes()
+}
+
+// mountWebDAV serves the repository via WebDAV on the local host and mounts
+// it with mount_webdav. It returns when the server fails, the mount is removed
+// by the cleanup handler of runMount. The server requires a random password,
+// which is passed to mount_webdav via a pipe.
+func mountWebDAV(opts MountOptions, repo restic.Repository, mountpoint string) error {
+	password, err := newWebDAVMountPassword()
+	if err != nil {
+		return err
+	}
+
+	cfg := webdav.Config{
+		Hosts:            opts.Hosts,
+		Tags:             opts.Tags,
+		Paths:            opts.Paths,
+		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+		Username:         webdavMountUser,
+		Password:         password,
+		// mount_webdav does not send basic auth credentials via plain HTTP
+		Digest: true,
+	}
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "Pipe")
+	}
+	defer func() {
+		_ = rd.Close()
+	}()
+
+	// the credentials are much smaller than the pipe buffer
+	_, err = wr.Write(webdavCredentials(webdavMountUser, password))
+	if cerr := wr.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: webdav.NewHandler(repo, cfg)}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+
+	url := fmt.Sprintf("http://%v/", ln.Addr())
+	debug.Log("serving WebDAV at %v", url)
+
+	// the first entry of ExtraFiles is file descriptor 3 in the child
+	cmd := exec.Command("mount_webdav", "-S", "-a", "3", "-v", "restic", url, mountpoint)
+	cmd.ExtraFiles = []*os.File{rd}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		_ = srv.Close()
+		return errors.Fatalf("unable to mount %v via WebDAV: %v: %s", mountpoint, err, strings.TrimSpace(string(out)))
+	}
+
+	Printf("Now serving the repository at %s\n", mountpoint)
+	Printf("When finished, quit with Ctrl-c or umount the mountpoint.\n")
+
+	return <-done
+}
@@ -0,0 +1,13 @@
+// +build !darwin
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package main
+
+// detectMountBackend returns the FUSE implementation of the kernel, which
+// needs no further options.
+func detectMountBackend(opts MountOptions, gopts GlobalOptions) (mountBackend, error) {
+	return mountBackend{}, nil
+}
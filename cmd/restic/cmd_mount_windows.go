@@ -0,0 +1,202 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/webdav"
+)
+
+var cmdMount = &cobra.Command{
+	Use:   "mount [flags] drive",
+	Short: "Mount the repository",
+	Long: `
+The "mount" command mounts the repository as a drive, e.g. "restic mount X:".
+This is a read-only mount. The repository is served via WebDAV on a port of
+the local host, which is connected to the drive by the WebDAV redirector of
+Windows, instead of a file system driver like WinFSP. The service "WebClient"
+must be running. The WebDAV server only accepts a random password, which is
+passed to the redirector when the drive is connected.
+
+Snapshot Directories
+====================
+
+The snapshots are organized in directories according to path templates, by
+default:
+
+    ids/%i          snapshots by ID
+    snapshots/%T    all snapshots by time
+    hosts/%h/%T     snapshots by host and time
+    tags/%t/%T      snapshots by tag and time
+    paths/%p/%T     snapshots by their paths and time
+
+The placeholders are %i (short snapshot ID), %I (long snapshot ID), %T (time,
+see below), %h (hostname), %u (username), %t (tag, a snapshot is listed for
+each of its tags), %p (a path of the snapshot, as nested directories) and %%
+(a percent sign). The last component must contain %i, %I or %T. Each
+directory which contains snapshots also contains a directory "latest" with
+the newest snapshot. To use different templates, pass --path-template for each
+of them, e.g. --path-template "%h/%u/%T".
+
+The time of the snapshot directories is formatted with --snapshot-template,
+which must not contain characters which are invalid in file names on Windows,
+like colons. You need to specify a sample format for exactly the following
+timestamp:
+
+    Mon Jan 2 15:04:05 -0700 MST 2006
+
+For details please see the documentation for time.Format() at:
+  https://godoc.org/time#Time.Format
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMount(mountOptions, globalOptions, args)
+	},
+}
+
+// MountOptions collects all options for the mount command.
+type MountOptions struct {
+	Hosts            []string
+	Tags             restic.TagLists
+	Paths            []string
+	SnapshotTemplate string
+	PathTemplates    []string
+}
+
+var mountOptions MountOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdMount)
+
+	mountFlags := cmdMount.Flags()
+	mountFlags.StringArrayVarP(&mountOptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
+	mountFlags.Var(&mountOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	mountFlags.StringArrayVar(&mountOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+
+	mountFlags.StringVar(&mountOptions.SnapshotTemplate, "snapshot-template", "2006-01-02T15-04-05Z07-00", "set `template` to use for snapshot dirs")
+	mountFlags.StringArrayVar(&mountOptions.PathTemplates, "path-template", nil, "set `template` for the directories which contain snapshots, e.g. \"hosts/%h/%T\" (can be specified multiple times, default: ids, snapshots, hosts, tags and paths)")
+}
+
+var driveLetter = regexp.MustCompile(`^[A-Za-z]:$`)
+
+// netUse runs "net use" with the arguments.
+func netUse(args ...string) error {
+	out, err := exec.Command("net", append([]string{"use"}, args...)...).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("net use failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func mount(opts MountOptions, gopts GlobalOptions, drive string) error {
+	debug.Log("start mount")
+	defer debug.Log("finish mount")
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	password, err := newWebDAVMountPassword()
+	if err != nil {
+		return err
+	}
+
+	cfg := webdav.Config{
+		Hosts:            opts.Hosts,
+		Tags:             opts.Tags,
+		Paths:            opts.Paths,
+		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+		Username:         webdavMountUser,
+		Password:         password,
+		// the redirector only sends basic auth credentials via HTTPS
+		Digest: true,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: webdav.NewHandler(repo, cfg)}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+
+	url := fmt.Sprintf("http://%v/", ln.Addr())
+	debug.Log("serving WebDAV at %v", url)
+
+	err = netUse(drive, url, password, "/user:"+webdavMountUser, "/persistent:no")
+	if err != nil {
+		_ = srv.Close()
+		return errors.Fatalf("unable to mount %v, is the WebClient service running? %v", drive, err)
+	}
+
+	AddCleanupHandler(func() error {
+		debug.Log("running umount cleanup handler for mount at %v", drive)
+		err := netUse(drive, "/delete", "/y")
+		if err != nil {
+			Warnf("unable to umount (maybe already umounted?): %v\n", err)
+		}
+		return nil
+	})
+
+	Printf("Now serving the repository at %s\n", drive)
+	Printf("When finished, quit with Ctrl-c.\n")
+
+	return <-done
+}
+
+func runMount(opts MountOptions, gopts GlobalOptions, args []string) error {
+	if opts.SnapshotTemplate == "" {
+		return errors.Fatal("snapshot template string cannot be empty")
+	}
+
+	if strings.ContainsAny(opts.SnapshotTemplate, `\/:*?"<>|`) {
+		return errors.Fatal(`snapshot template string contains one of the characters \/:*?"<>| which are invalid in file names`)
+	}
+
+	for _, template := range opts.PathTemplates {
+		if err := fuse.CheckPathTemplate(template); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if len(args) != 1 || !driveLetter.MatchString(args[0]) {
+		return errors.Fatal("please specify a drive letter, e.g. X:")
+	}
+
+	return mount(opts, gopts, args[0])
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
@@ -11,6 +17,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var cmdPrune = &cobra.Command{
@@ -20,6 +27,16 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+Pack files which still contain data in use are rewritten. With --max-unused,
+some unused data is tolerated to reduce the amount of data that has to be
+downloaded and uploaded again, --max-repack-size limits the size of the pack
+files rewritten in a single run. With --repack-small, pack files smaller than
+the minimal pack size are combined into larger packs.
+
+With --grace-period, pack files which are no longer needed are only removed
+from the index at first. They are deleted by a later prune run after the grace
+period has passed, unless their data is referenced again in the meantime.
+
 EXIT STATUS
 ===========
 
@@ -27,12 +44,130 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		gopts := globalOptions
+		m, err := newMetrics(gopts, "prune")
+		if err != nil {
+			return err
+		}
+		gopts.metrics = m
+
+		err = runPrune(pruneOptions, gopts)
+		return gopts.metrics.Finish(gopts, err)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	MaxUnused     string
+	MaxRepackSize string
+	RepackSmall   bool
+	GracePeriod   restic.Duration
+
+	// maxUnusedBytes returns the number of unused bytes which are tolerated
+	// for the given number of used bytes, set by verifyPruneOptions
+	maxUnusedBytes func(used uint64) uint64
+	maxRepackBytes uint64
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	addPruneOptions(cmdPrune.Flags(), &pruneOptions)
+}
+
+func addPruneOptions(f *pflag.FlagSet, opts *PruneOptions) {
+	f.StringVar(&opts.MaxUnused, "max-unused", "0%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
+	f.StringVar(&opts.MaxRepackSize, "max-repack-size", "", "maximum `size` of pack files to rewrite (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.BoolVar(&opts.RepackSmall, "repack-small", false, "rewrite small pack files into full-size packs")
+	f.Var(&opts.GracePeriod, "grace-period", "only delete pack files which have been obsolete for at least `duration` (eg. 1y5m7d2h), newer ones are kept")
+}
+
+// verifyPruneOptions parses the limits in opts.
+func verifyPruneOptions(opts *PruneOptions) error {
+	opts.maxRepackBytes = math.MaxUint64
+	if opts.MaxRepackSize != "" {
+		size, err := parseSizeStr(opts.MaxRepackSize)
+		if err != nil {
+			return errors.Fatalf("invalid --max-repack-size: %v", err)
+		}
+		opts.maxRepackBytes = uint64(size)
+	}
+
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	switch {
+	case maxUnused == "":
+		// same as the default of 0%
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return 0
+		}
+
+	case maxUnused == "unlimited":
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return math.MaxUint64
+		}
+
+	case strings.HasSuffix(maxUnused, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(maxUnused, "%"), 64)
+		if err != nil {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		if p < 0 || p >= 100 {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused, must be between 0%% and 100%%, use 'unlimited' to keep all unused data", opts.MaxUnused)
+		}
+
+		// p is the share of unused data in the size of all pack files
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(p / (100 - p) * float64(used))
+		}
+
+	default:
+		size, err := parseSizeStr(maxUnused)
+		if err != nil {
+			return errors.Fatalf("invalid value %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(size)
+		}
+	}
+
+	return nil
+}
+
+// parseSizeStr parses a size like "500M", the suffixes k, m, g and t are
+// multiples of 1024. Without a suffix, the size is in bytes.
+func parseSizeStr(sizeStr string) (int64, error) {
+	sizeStr = strings.TrimSpace(sizeStr)
+	if sizeStr == "" {
+		return 0, errors.New("empty size")
+	}
+
+	unit := int64(1)
+	switch sizeStr[len(sizeStr)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	case 't', 'T':
+		unit = 1 << 40
+	}
+	if unit != 1 {
+		sizeStr = sizeStr[:len(sizeStr)-1]
+	}
+
+	value, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, errors.Errorf("negative size %v", value)
+	}
+
+	return value * unit, nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -49,6 +184,21 @@ func shortenStatus(maxLength int, s string) string {
 
 // newProgressMax returns a progress that counts blobs.
 func newProgressMax(show bool, max uint64, description string) *restic.Progress {
+	return newProgressStatus(show, func(s restic.Stat) string {
+		return fmt.Sprintf("%s  %d / %d %s", formatPercent(s.Blobs, max), s.Blobs, max, description)
+	})
+}
+
+// newProgressBytes returns a progress that counts bytes.
+func newProgressBytes(show bool, max uint64, description string) *restic.Progress {
+	return newProgressStatus(show, func(s restic.Stat) string {
+		return fmt.Sprintf("%s  %s / %s %s", formatPercent(s.Bytes, max), formatBytes(s.Bytes), formatBytes(max), description)
+	})
+}
+
+// newProgressStatus returns a progress which prints the elapsed time followed
+// by the status returned by status.
+func newProgressStatus(show bool, status func(s restic.Stat) string) *restic.Progress {
 	if !show {
 		return nil
 	}
@@ -56,38 +206,62 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 	p := restic.NewProgress()
 
 	p.OnUpdate = func(s restic.Stat, d time.Duration, ticker bool) {
-		status := fmt.Sprintf("[%s] %s  %d / %d %s",
-			formatDuration(d),
-			formatPercent(s.Blobs, max),
-			s.Blobs, max, description)
+		line := fmt.Sprintf("[%s] %s", formatDuration(d), status(s))
 
 		if w := stdoutTerminalWidth(); w > 0 {
-			status = shortenStatus(w, status)
+			line = shortenStatus(w, line)
 		}
 
-		PrintProgress("%s", status)
+		PrintProgress("%s", line)
 	}
 
 	p.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
-		fmt.Printf("\n")
+		// PrintProgress does not print anything in JSON mode
+		if !globalOptions.JSON {
+			fmt.Printf("\n")
+		}
 	}
 
 	return p
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	err := verifyPruneOptions(&opts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
+	gopts.metrics.SetRepository(repo)
 
-	lock, err := lockRepoExclusive(repo)
-	defer unlockRepo(lock)
+	if err = requireAdminKey(gopts, repo, "prune"); err != nil {
+		return err
+	}
+
+	if err = requireApproval(gopts, repo, "prune", ""); err != nil {
+		return err
+	}
+
+	// Backups can continue while prune analyzes the repository, the exclusive
+	// lock is only acquired before data is removed.
+	lock, err := lockRepo(repo)
+	defer func() {
+		unlockRepo(lock)
+	}()
 	if err != nil {
 		return err
 	}
 
-	return pruneRepository(gopts, repo)
+	return pruneRepository(gopts, opts, repo, nil, false, func() error {
+		if err := unlockRepo(lock); err != nil {
+			return err
+		}
+		lock, err = lockRepoExclusive(repo)
+		return err
+	})
 }
 
 func mixedBlobs(list []restic.Blob) bool {
@@ -109,19 +283,333 @@ func mixedBlobs(list []restic.Blob) bool {
 	return false
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+// repackCandidate is a pack file which contains both used and unused data,
+// or which is smaller than the minimal pack size.
+type repackCandidate struct {
+	id           restic.ID
+	size, unused uint64
+
+	// mixed packs contain tree and data blobs, small packs are rewritten with
+	// --repack-small. Both are always rewritten unless the limit for the
+	// repack size is reached.
+	mixed, small bool
+}
+
+func (c repackCandidate) mandatory() bool {
+	return c.mixed || c.small
+}
+
+// selectRepackPacks returns the candidates which are rewritten such that at
+// most maxUnused bytes of unused data are kept and the size of all rewritten
+// packs does not exceed maxRepack. Packs with the largest share of unused
+// data are rewritten first. keptUnused is the amount of unused data which is
+// not removed.
+func selectRepackPacks(candidates []repackCandidate, maxUnused, maxRepack uint64) (repack restic.IDSet, keptUnused uint64) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.mandatory() != b.mandatory() {
+			return a.mandatory()
+		}
+		// compare a.unused/a.size > b.unused/b.size without division
+		return float64(a.unused)*float64(b.size) > float64(b.unused)*float64(a.size)
+	})
+
+	for _, c := range candidates {
+		keptUnused += c.unused
+	}
+
+	repack = restic.NewIDSet()
+	var repackSize uint64
+	for _, c := range candidates {
+		if !c.mandatory() && keptUnused <= maxUnused {
+			break
+		}
+
+		if repackSize+c.size > maxRepack {
+			continue
+		}
+
+		repack.Insert(c.id)
+		repackSize += c.size
+		keptUnused -= c.unused
+	}
+
+	return repack, keptUnused
+}
+
+// pruneCheckpointPacks is the number of packs which are rewritten before the
+// progress of prune is saved.
+const pruneCheckpointPacks = 100
+
+// pruneRepository removes unneeded data from the repository. If snapshots is
+// not nil, it must contain all snapshots in the repository, otherwise they are
+// loaded from the repository. If indexLoaded is true, the caller has already
+// loaded the index of repo.
+//
+// If lockExclusive is not nil, the caller holds a non-exclusive lock, which is
+// sufficient to determine the packs to remove. lockExclusive is called before
+// anything is modified and must replace it with an exclusive lock. If the
+// repository was modified in the meantime, the packs are determined again.
+func pruneRepository(gopts GlobalOptions, opts PruneOptions, repo restic.Repository, snapshots restic.Snapshots, indexLoaded bool, lockExclusive func() error) error {
 	ctx := gopts.ctx
 
-	err := repo.LoadIndex(ctx)
+	var err error
+	if !indexLoaded {
+		err = repo.LoadIndex(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	stateFile := pruneStateFile(repo)
+	saveState := func(state *pruneState) {
+		if stateFile == "" {
+			return
+		}
+		if err := state.save(stateFile); err != nil {
+			Warnf("unable to save prune progress, an interrupted prune cannot be resumed: %v\n", err)
+		}
+	}
+
+	var state *pruneState
+	var usedBlobs restic.BlobSet
+	for {
+		var snapshotIDs, indexIDs restic.IDs
+		snapshotIDs, err = listIDs(ctx, repo, restic.SnapshotFile)
+		if err != nil {
+			return err
+		}
+
+		indexIDs, err = listIDs(ctx, repo, restic.IndexFile)
+		if err != nil {
+			return err
+		}
+
+		if stateFile != "" {
+			state, err = loadPruneState(stateFile)
+			if err != nil {
+				Warnf("unable to load progress of interrupted prune: %v\n", err)
+			}
+
+			if state != nil && !state.valid(snapshotIDs, indexIDs) {
+				Verbosef("repository was modified since prune was interrupted, starting over\n")
+				state = nil
+			}
+		}
+
+		if state == nil {
+			var removePacks, rewritePacks restic.IDSet
+			removePacks, rewritePacks, usedBlobs, err = planPrune(gopts, opts, repo, snapshots)
+			if err != nil {
+				return err
+			}
+
+			state = &pruneState{
+				Snapshots: snapshotIDs,
+				Indexes:   indexIDs,
+				Remove:    removePacks.List(),
+				Repack:    rewritePacks.List(),
+			}
+			saveState(state)
+		} else {
+			Verbosef("resuming interrupted prune: will delete %d packs and rewrite %d of %d packs\n",
+				len(state.Remove), len(state.remaining()), len(state.Repack))
+		}
+
+		if lockExclusive == nil {
+			break
+		}
+
+		Verbosef("locking repository exclusively\n")
+		if err = lockExclusive(); err != nil {
+			return err
+		}
+		lockExclusive = nil
+
+		// concurrent backups and forget may have modified the repository
+		// until the exclusive lock was acquired
+		if snapshotIDs, err = listIDs(ctx, repo, restic.SnapshotFile); err != nil {
+			return err
+		}
+		if indexIDs, err = listIDs(ctx, repo, restic.IndexFile); err != nil {
+			return err
+		}
+		if state.valid(snapshotIDs, indexIDs) {
+			break
+		}
+
+		Verbosef("repository was modified while prune was running, starting over\n")
+		removePruneState(stateFile)
+		state, usedBlobs, snapshots = nil, nil, nil
+		if err = repo.SetIndex(repository.NewMasterIndex()); err != nil {
+			return err
+		}
+		if err = repo.LoadIndex(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !state.IndexWritten {
+		rewritePacks := state.remaining()
+		if len(rewritePacks) != 0 && usedBlobs == nil {
+			usedBlobs, err = findUsedBlobs(gopts, repo, snapshots)
+			if err != nil {
+				return err
+			}
+
+			// blobs which were already copied by the interrupted run are
+			// contained in packs which are not deleted
+			removeKeptBlobs(ctx, repo, usedBlobs, state.obsolete())
+		}
+
+		if len(rewritePacks) != 0 {
+			bar := newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
+			bar.Start()
+			batch := restic.NewIDSet()
+			for _, id := range rewritePacks.List() {
+				batch.Insert(id)
+				if len(batch) < pruneCheckpointPacks && len(batch) < len(rewritePacks) {
+					continue
+				}
+
+				_, err = repository.Repack(ctx, repo, batch, usedBlobs, bar)
+				if err != nil {
+					return err
+				}
+
+				// make the new packs known before recording the progress
+				if err = repo.SaveIndex(ctx); err != nil {
+					return err
+				}
+
+				if state.Indexes, err = listIDs(ctx, repo, restic.IndexFile); err != nil {
+					return err
+				}
+
+				state.Repacked = append(state.Repacked, batch.List()...)
+				saveState(state)
+
+				for id := range batch {
+					rewritePacks.Delete(id)
+				}
+				batch = restic.NewIDSet()
+			}
+			bar.Done()
+		}
+
+		if err = rebuildIndex(ctx, repo, state.obsolete()); err != nil {
+			return err
+		}
+
+		if state.Indexes, err = listIDs(ctx, repo, restic.IndexFile); err != nil {
+			return err
+		}
+		state.IndexWritten = true
+		saveState(state)
+	}
+
+	removePacks := state.obsolete()
+	if !opts.GracePeriod.Zero() {
+		removePacks, err = expireObsoletePacks(repo, removePacks, opts.GracePeriod)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(removePacks) != 0 {
+		auditLog(ctx, repo, "prune", nil, fmt.Sprintf("remove %d pack files, %d of them repacked", len(removePacks), len(state.Repacked)))
+
+		bar := newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
+		bar.Start()
+		for packID := range removePacks {
+			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+			err = repo.Backend().Remove(ctx, h)
+			if err != nil && !repo.Backend().IsNotExist(err) {
+				Warnf("unable to remove file %v from the repository\n", packID.Str())
+			}
+			bar.Report(restic.Stat{Blobs: 1})
+		}
+		bar.Done()
+	}
+
+	removePruneState(stateFile)
+
+	if gopts.JSON {
+		return json.NewEncoder(gopts.stdout).Encode(pruneSummary{
+			MessageType:    "summary",
+			PacksDeleted:   len(removePacks),
+			PacksRewritten: len(state.Repacked),
+			PacksKept:      len(state.obsolete()) - len(removePacks),
+		})
+	}
+
+	Verbosef("done\n")
+	return nil
+}
+
+// pruneSummary is printed by prune in JSON mode when it has finished.
+type pruneSummary struct {
+	MessageType    string `json:"message_type"` // "summary"
+	PacksDeleted   int    `json:"packs_deleted"`
+	PacksRewritten int    `json:"packs_rewritten"`
+	PacksKept      int    `json:"packs_kept"`
+}
+
+// expireObsoletePacks records when the packs in obsolete were first found to
+// be obsolete and returns those for which the grace period has passed. The
+// other packs are not deleted, they are kept until a later prune run.
+func expireObsoletePacks(repo restic.Repository, obsolete restic.IDSet, grace restic.Duration) (restic.IDSet, error) {
+	filename := cacheFile(repo, obsoletePacksFilename)
+	if filename == "" {
+		return nil, errors.Fatal("--grace-period requires a local cache")
+	}
+
+	packs, err := loadObsoletePacks(filename)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	now := time.Now()
+	deadline := now.AddDate(-grace.Years, -grace.Months, -grace.Days).Add(time.Duration(-grace.Hours) * time.Hour)
+	expired := packs.update(obsolete, now, deadline)
+
+	// the expired packs stay in the list until the next prune run finds that
+	// they don't exist any more, so a failed deletion is retried
+	if err = packs.save(filename); err != nil {
+		return nil, err
 	}
 
+	Verbosef("keeping %d obsolete packs until the grace period of %v has passed\n", len(packs)-len(expired), grace)
+	return expired, nil
+}
+
+// removeKeptBlobs removes all blobs from usedBlobs which are stored in at
+// least one pack file that is not obsolete.
+func removeKeptBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.BlobSet, obsolete restic.IDSet) {
+	kept := restic.NewBlobSet()
+	for pb := range repo.Index().Each(ctx) {
+		h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+		if usedBlobs.Has(h) && !obsolete.Has(pb.PackID) {
+			kept.Insert(h)
+		}
+	}
+
+	for h := range kept {
+		usedBlobs.Delete(h)
+	}
+}
+
+// planPrune analyzes the repository and returns the packs which can be
+// removed, the packs which need to be rewritten and the blobs which must be
+// copied when rewriting them. Blobs which are stored more than once are only
+// copied if no pack which contains them is kept.
+func planPrune(gopts GlobalOptions, opts PruneOptions, repo restic.Repository, snapshots restic.Snapshots) (removePacks, rewritePacks restic.IDSet, usedBlobs restic.BlobSet, err error) {
+	ctx := gopts.ctx
+
 	var stats struct {
-		blobs     int
-		packs     int
-		snapshots int
-		bytes     int64
+		blobs int
+		packs int
+		bytes int64
 	}
 
 	Verbosef("counting files in repo\n")
@@ -130,7 +618,7 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	Verbosef("building new index for repo\n")
@@ -138,7 +626,7 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
 	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	for _, id := range invalidFiles {
@@ -173,42 +661,14 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 
 	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
 		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
 
-	// find referenced blobs
-	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	usedBlobs, err = findUsedBlobs(gopts, repo, snapshots)
 	if err != nil {
-		return err
-	}
-
-	stats.snapshots = len(snapshots)
-
-	Verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
-
-	usedBlobs := restic.NewBlobSet()
-	seenBlobs := restic.NewBlobSet()
-
-	bar = newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
-	bar.Start()
-	for _, sn := range snapshots {
-		debug.Log("process snapshot %v", sn.ID())
-
-		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
-		if err != nil {
-			if repo.Backend().IsNotExist(err) {
-				return errors.Fatal("unable to load a tree from the repo: " + err.Error())
-			}
-
-			return err
-		}
-
-		debug.Log("processed snapshot %v", sn.ID())
-		bar.Report(restic.Stat{Blobs: 1})
+		return nil, nil, nil, err
 	}
-	bar.Done()
 
 	if len(usedBlobs) > stats.blobs {
-		return errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
+		return nil, nil, nil, errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
 			"Please report this error (along with the output of the 'prune' run) at\n" +
 			"https://github.com/restic/restic/issues/new")
 	}
@@ -216,8 +676,20 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	Verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
 		len(usedBlobs), stats.blobs, stats.blobs-len(usedBlobs))
 
+	var usedBytes uint64
+	countedBlobs := restic.NewBlobSet()
+	for _, pack := range idx.Packs {
+		for _, blob := range pack.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if usedBlobs.Has(h) && !countedBlobs.Has(h) {
+				countedBlobs.Insert(h)
+				usedBytes += uint64(blob.Length)
+			}
+		}
+	}
+
 	// find packs that need a rewrite
-	rewritePacks := restic.NewIDSet()
+	rewritePacks = restic.NewIDSet()
 	for _, pack := range idx.Packs {
 		if mixedBlobs(pack.Entries) {
 			rewritePacks.Insert(pack.ID)
@@ -240,7 +712,7 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	removeBytes := duplicateBytes
 
 	// find packs that are unneeded
-	removePacks := restic.NewIDSet()
+	removePacks = restic.NewIDSet()
 
 	Verbosef("will remove %d invalid files\n", len(invalidFiles))
 	for _, id := range invalidFiles {
@@ -267,46 +739,161 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		removePacks.Insert(packID)
 
 		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+			return nil, nil, nil, errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
 		}
 
 		rewritePacks.Delete(packID)
 	}
 
+	// find the packs of all used blobs which are stored more than once
+	duplicatePacks := make(map[restic.BlobHandle]restic.IDs)
+	for packID, p := range idx.Packs {
+		for _, blob := range p.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if blobCount[h] > 1 && usedBlobs.Has(h) {
+				duplicatePacks[h] = append(duplicatePacks[h], packID)
+			}
+		}
+	}
+
+	// packs in which all used blobs are also stored in another pack, for
+	// example after an interrupted backup, are removed without rewriting them
+	duplicateOnlyPacks := 0
+	for packID, p := range idx.Packs {
+		if !rewritePacks.Has(packID) {
+			continue
+		}
+
+		onlyCopies := true
+		for _, blob := range p.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if usedBlobs.Has(h) && !hasCopyInOtherPack(duplicatePacks[h], packID, removePacks) {
+				onlyCopies = false
+				break
+			}
+		}
+
+		if onlyCopies {
+			removePacks.Insert(packID)
+			rewritePacks.Delete(packID)
+			duplicateOnlyPacks++
+		}
+	}
+	if duplicateOnlyPacks > 0 {
+		Verbosef("will remove %d packs which only contain duplicate or unused blobs\n", duplicateOnlyPacks)
+	}
+
+	// only rewrite as many packs as necessary to honor --max-unused
+	var candidates []repackCandidate
+	for packID := range rewritePacks {
+		p := idx.Packs[packID]
+		c := repackCandidate{id: packID, size: uint64(p.Size), mixed: mixedBlobs(p.Entries)}
+		for _, blob := range p.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if !usedBlobs.Has(h) || blobCount[h] > 1 {
+				c.unused += uint64(blob.Length)
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	if opts.RepackSmall {
+		var small []repackCandidate
+		for packID, p := range idx.Packs {
+			if p.Size >= repository.MinPackSize || rewritePacks.Has(packID) || removePacks.Has(packID) {
+				continue
+			}
+			small = append(small, repackCandidate{id: packID, size: uint64(p.Size), small: true})
+		}
+
+		// rewriting a single small pack would only create another one
+		if len(small) > 1 {
+			Verbosef("found %d small packs\n", len(small))
+			candidates = append(candidates, small...)
+		}
+	}
+
+	var keptUnused uint64
+	rewritePacks, keptUnused = selectRepackPacks(candidates, opts.maxUnusedBytes(usedBytes), opts.maxRepackBytes)
+	if keptUnused > 0 {
+		if keptUnused > removeBytes {
+			keptUnused = removeBytes
+		}
+		removeBytes -= keptUnused
+		Verbosef("keeping %d packs with %s of unused data\n", len(candidates)-len(rewritePacks), formatBytes(keptUnused))
+	}
+
 	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
 		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	gopts.metrics.Set("packs_deleted", "Number of packs deleted in the last run.", float64(len(removePacks)))
+	gopts.metrics.Set("packs_rewritten", "Number of packs rewritten in the last run.", float64(len(rewritePacks)))
+	gopts.metrics.Set("freed_bytes", "Number of bytes freed in the last run.", float64(removeBytes))
+
+	// duplicates which are still stored in a pack that is kept must not be
+	// copied when rewriting the other packs
+	obsolete := restic.NewIDSet()
+	obsolete.Merge(removePacks)
+	obsolete.Merge(rewritePacks)
+	for h, packs := range duplicatePacks {
+		for _, id := range packs {
+			if !obsolete.Has(id) {
+				usedBlobs.Delete(h)
+				break
+			}
+		}
+	}
 
-	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
-		bar.Start()
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
-		if err != nil {
-			return err
+	return removePacks, rewritePacks, usedBlobs, nil
+}
+
+// hasCopyInOtherPack returns true if packs contains a pack other than packID
+// which is not removed.
+func hasCopyInOtherPack(packs restic.IDs, packID restic.ID, removePacks restic.IDSet) bool {
+	for _, id := range packs {
+		if id != packID && !removePacks.Has(id) {
+			return true
 		}
-		bar.Done()
 	}
+	return false
+}
 
-	removePacks.Merge(obsoletePacks)
+// findUsedBlobs returns all blobs referenced by the snapshots in the
+// repository. The snapshots are loaded from the repository if snapshots is nil.
+func findUsedBlobs(gopts GlobalOptions, repo restic.Repository, snapshots restic.Snapshots) (restic.BlobSet, error) {
+	ctx := gopts.ctx
 
-	if err = rebuildIndex(ctx, repo, removePacks); err != nil {
-		return err
+	var err error
+	if snapshots == nil {
+		Verbosef("load all snapshots\n")
+		snapshots, err = restic.LoadAllSnapshots(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if len(removePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
-		bar.Start()
-		for packID := range removePacks {
-			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
-			err = repo.Backend().Remove(ctx, h)
-			if err != nil {
-				Warnf("unable to remove file %v from the repository\n", packID.Str())
+	Verbosef("find data that is still in use for %d snapshots\n", len(snapshots))
+
+	usedBlobs := restic.NewBlobSet()
+	seenBlobs := restic.NewBlobSet()
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
+	bar.Start()
+	for _, sn := range snapshots {
+		debug.Log("process snapshot %v", sn.ID())
+
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
+		if err != nil {
+			if repo.Backend().IsNotExist(err) {
+				return nil, errors.Fatal("unable to load a tree from the repo: " + err.Error())
 			}
-			bar.Report(restic.Stat{Blobs: 1})
+
+			return nil, err
 		}
-		bar.Done()
+
+		debug.Log("processed snapshot %v", sn.ID())
+		bar.Report(restic.Stat{Blobs: 1})
 	}
+	bar.Done()
 
-	Verbosef("done\n")
-	return nil
+	return usedBlobs, nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseSizeStr(t *testing.T) {
+	var tests = []struct {
+		input string
+		size  int64
+		err   bool
+	}{
+		{"1024", 1024, false},
+		{"5k", 5 * 1024, false},
+		{"5K", 5 * 1024, false},
+		{"100m", 100 * 1024 * 1024, false},
+		{"2G", 2 * 1024 * 1024 * 1024, false},
+		{"1t", 1024 * 1024 * 1024 * 1024, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"1.5G", 0, true},
+		{"foo", 0, true},
+	}
+
+	for _, test := range tests {
+		size, err := parseSizeStr(test.input)
+		if test.err {
+			rtest.Assert(t, err != nil, "expected error for %q", test.input)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.size, size)
+	}
+}
+
+func TestVerifyPruneOptions(t *testing.T) {
+	var tests = []struct {
+		opts      PruneOptions
+		maxUnused uint64
+		maxRepack uint64
+		err       bool
+	}{
+		{PruneOptions{MaxUnused: "0%"}, 0, math.MaxUint64, false},
+		{PruneOptions{MaxUnused: "20%"}, 250, math.MaxUint64, false},
+		{PruneOptions{MaxUnused: "unlimited", MaxRepackSize: "1k"}, math.MaxUint64, 1024, false},
+		{PruneOptions{MaxUnused: "500"}, 500, math.MaxUint64, false},
+		{PruneOptions{MaxUnused: "100%"}, 0, 0, true},
+		{PruneOptions{MaxUnused: "x%"}, 0, 0, true},
+		{PruneOptions{MaxUnused: "0%", MaxRepackSize: "foo"}, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		opts := test.opts
+		err := verifyPruneOptions(&opts)
+		if test.err {
+			rtest.Assert(t, err != nil, "expected error for %+v", test.opts)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.maxUnused, opts.maxUnusedBytes(1000))
+		rtest.Equals(t, test.maxRepack, opts.maxRepackBytes)
+	}
+}
+
+func TestSelectRepackPacks(t *testing.T) {
+	ids := make([]restic.ID, 4)
+	for i := range ids {
+		ids[i] = restic.NewRandomID()
+	}
+
+	candidates := func() []repackCandidate {
+		return []repackCandidate{
+			{id: ids[0], size: 100, unused: 10},
+			{id: ids[1], size: 100, unused: 90},
+			{id: ids[2], size: 100, unused: 50},
+			{id: ids[3], size: 100, unused: 0, mixed: true},
+		}
+	}
+
+	var tests = []struct {
+		maxUnused, maxRepack uint64
+		repack               restic.IDSet
+		keptUnused           uint64
+	}{
+		{0, math.MaxUint64, restic.NewIDSet(ids...), 0},
+		{60, math.MaxUint64, restic.NewIDSet(ids[1], ids[3]), 60},
+		{math.MaxUint64, math.MaxUint64, restic.NewIDSet(ids[3]), 150},
+		{0, 200, restic.NewIDSet(ids[1], ids[3]), 60},
+	}
+
+	for _, test := range tests {
+		repack, keptUnused := selectRepackPacks(candidates(), test.maxUnused, test.maxRepack)
+		rtest.Equals(t, test.repack, repack)
+		rtest.Equals(t, test.keptUnused, keptUnused)
+	}
+}
+
+func TestSelectRepackPacksSmall(t *testing.T) {
+	small1, small2, unused := restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()
+
+	candidates := []repackCandidate{
+		{id: unused, size: 100, unused: 10},
+		{id: small1, size: 20, small: true},
+		{id: small2, size: 30, small: true},
+	}
+
+	repack, keptUnused := selectRepackPacks(candidates, math.MaxUint64, math.MaxUint64)
+	rtest.Equals(t, restic.NewIDSet(small1, small2), repack)
+	rtest.Equals(t, uint64(10), keptUnused)
+}
+
+func TestPruneState(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, pruneStateFilename)
+
+	state, err := loadPruneState(filename)
+	rtest.OK(t, err)
+	rtest.Assert(t, state == nil, "expected no state, got %v", state)
+
+	snapshots := restic.IDs{restic.NewRandomID(), restic.NewRandomID()}
+	indexes := restic.IDs{restic.NewRandomID()}
+	sort.Sort(snapshots)
+	remove := restic.NewRandomID()
+	repack := restic.IDs{restic.NewRandomID(), restic.NewRandomID()}
+
+	state = &pruneState{
+		Snapshots: snapshots,
+		Indexes:   indexes,
+		Remove:    restic.IDs{remove},
+		Repack:    repack,
+		Repacked:  repack[:1],
+	}
+	rtest.OK(t, state.save(filename))
+
+	loaded, err := loadPruneState(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, state, loaded)
+
+	rtest.Assert(t, loaded.valid(snapshots, indexes), "state should be valid")
+	rtest.Assert(t, !loaded.valid(snapshots[:1], indexes), "state should be invalid for removed snapshot")
+	rtest.Assert(t, !loaded.valid(snapshots, restic.IDs{restic.NewRandomID()}), "state should be invalid for changed index")
+
+	rtest.Equals(t, restic.NewIDSet(repack[1]), loaded.remaining())
+	rtest.Equals(t, restic.NewIDSet(remove, repack[0], repack[1]), loaded.obsolete())
+
+	removePruneState(filename)
+	state, err = loadPruneState(filename)
+	rtest.OK(t, err)
+	rtest.Assert(t, state == nil, "expected no state after removal, got %v", state)
+}
+
+func TestObsoletePacks(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, obsoletePacksFilename)
+
+	packs, err := loadObsoletePacks(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(packs))
+
+	ids := restic.IDs{restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()}
+	day1 := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day9 := day1.AddDate(0, 0, 8)
+
+	// newly found packs are never expired
+	expired := packs.update(restic.NewIDSet(ids[0], ids[1]), day1, day1)
+	rtest.Equals(t, 0, len(expired))
+	rtest.OK(t, packs.save(filename))
+
+	packs, err = loadObsoletePacks(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, obsoletePacks{ids[0]: day1, ids[1]: day1}, packs)
+
+	// ids[1] is in use again and is forgotten
+	expired = packs.update(restic.NewIDSet(ids[0], ids[2]), day2, day1)
+	rtest.Equals(t, 0, len(expired))
+	rtest.Equals(t, obsoletePacks{ids[0]: day1, ids[2]: day2}, packs)
+
+	// a grace period of seven days has passed for ids[0]
+	expired = packs.update(restic.NewIDSet(ids[0], ids[2]), day9, day9.AddDate(0, 0, -7))
+	rtest.Equals(t, restic.NewIDSet(ids[0]), expired)
+	rtest.Equals(t, obsoletePacks{ids[0]: day1, ids[2]: day2}, packs)
+}
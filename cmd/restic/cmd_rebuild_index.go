@@ -11,11 +11,12 @@ import (
 )
 
 var cmdRebuildIndex = &cobra.Command{
-	Use:   "rebuild-index [flags]",
-	Short: "Build a new index file",
+	Use:        "rebuild-index [flags]",
+	Short:      "Build a new index file",
+	Deprecated: `use "repair index" instead`,
 	Long: `
 The "rebuild-index" command creates a new index based on the pack files in the
-repository.
+repository. It is replaced by "repair index".
 
 EXIT STATUS
 ===========
@@ -33,20 +34,7 @@ func init() {
 }
 
 func runRebuildIndex(gopts GlobalOptions) error {
-	repo, err := OpenRepository(gopts)
-	if err != nil {
-		return err
-	}
-
-	lock, err := lockRepoExclusive(repo)
-	defer unlockRepo(lock)
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel := context.WithCancel(gopts.ctx)
-	defer cancel()
-	return rebuildIndex(ctx, repo, restic.NewIDSet())
+	return runRepairIndex(RepairIndexOptions{}, gopts)
 }
 
 func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks restic.IDSet) error {
@@ -84,6 +72,12 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		return err
 	}
 
+	return replaceIndex(ctx, repo, idx, supersedes)
+}
+
+// replaceIndex saves idx as the new index of the repository and removes the
+// index files in supersedes.
+func replaceIndex(ctx context.Context, repo restic.Repository, idx *index.Index, supersedes restic.IDs) error {
 	ids, err := idx.Save(ctx, repo, supersedes)
 	if err != nil {
 		return errors.Fatalf("unable to save index, last error was: %v", err)
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairIndex = &cobra.Command{
+	Use:   "index [flags]",
+	Short: "Build a new index",
+	Long: `
+The "repair index" command creates a new index based on the pack files in the
+repository. The entries of the existing index are reused for all pack files
+which are present in the repository with the expected size, the headers of all
+other pack files are read. Index files which cannot be loaded, entries for
+missing pack files and duplicate entries are dropped. The new index replaces
+all existing index files.
+
+With --read-all-packs, the headers of all pack files are read. If the header of
+a pack file is damaged, the entries of the existing index are kept for it.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairIndex(repairIndexOptions, globalOptions)
+	},
+}
+
+// RepairIndexOptions collects all options for the repair index command.
+type RepairIndexOptions struct {
+	ReadAllPacks bool
+}
+
+var repairIndexOptions RepairIndexOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairIndex)
+
+	f := cmdRepairIndex.Flags()
+	f.BoolVar(&repairIndexOptions.ReadAllPacks, "read-all-packs", false, "read all pack files to generate new index from scratch")
+}
+
+func runRepairIndex(opts RepairIndexOptions, gopts GlobalOptions) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = requireAdminKey(gopts, repo, "repair index"); err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+	return repairIndex(ctx, opts, gopts, repo, restic.NewIDSet())
+}
+
+// loadIndexedPacks loads all index files and returns the blobs contained in
+// each pack file as well as the IDs of the index files. Index files which
+// cannot be loaded are skipped. If a pack is listed in several index files,
+// the entries of the first one are used.
+func loadIndexedPacks(ctx context.Context, repo restic.Repository) (map[restic.ID][]restic.Blob, restic.IDs, error) {
+	indexIDs, err := listIDs(ctx, repo, restic.IndexFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	packs := make(map[restic.ID][]restic.Blob)
+	for _, id := range indexIDs {
+		idx, err := repository.LoadIndex(ctx, repo, id)
+		if err != nil {
+			Warnf("removing index %v, it cannot be loaded: %v\n", id.Str(), err)
+			continue
+		}
+
+		blobs := make(map[restic.ID][]restic.Blob)
+		for blob := range idx.Each(ctx) {
+			blobs[blob.PackID] = append(blobs[blob.PackID], blob.Blob)
+		}
+
+		for packID, list := range blobs {
+			if _, ok := packs[packID]; ok {
+				continue
+			}
+			packs[packID] = list
+		}
+	}
+
+	return packs, indexIDs, ctx.Err()
+}
+
+// repairIndex replaces the index of the repository. The packs in ignorePacks
+// are not included in the new index.
+func repairIndex(ctx context.Context, opts RepairIndexOptions, gopts GlobalOptions, repo restic.Repository, ignorePacks restic.IDSet) error {
+	Verbosef("loading index files\n")
+
+	indexed, supersedes, err := loadIndexedPacks(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("listing pack files\n")
+
+	packSizes := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		if !ignorePacks.Has(id) {
+			packSizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	missing := 0
+	for id := range indexed {
+		if _, ok := packSizes[id]; !ok && !ignorePacks.Has(id) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		Verbosef("removing %d pack files which are missing in the repository from the index\n", missing)
+	}
+
+	// only read the pack files for which the index cannot be trusted
+	trusted := restic.NewIDSet()
+	for id, size := range packSizes {
+		blobs, ok := indexed[id]
+		if !opts.ReadAllPacks && ok && pack.Size(blobs) == size {
+			trusted.Insert(id)
+		}
+	}
+
+	if opts.ReadAllPacks {
+		// the cached pack headers may not match the pack files anymore
+		if r, ok := repo.(*repository.Repository); ok {
+			if c, ok := r.Cache.(*cache.Cache); ok {
+				if err = c.ClearPackHeaders(restic.NewIDSet()); err != nil {
+					Warnf("unable to clear cached pack headers: %v\n", err)
+				}
+			}
+		}
+	}
+
+	Verbosef("reading %d of %d pack files\n", len(packSizes)-len(trusted), len(packSizes))
+
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packSizes)-len(trusted)), "packs")
+	skip := restic.NewIDSet()
+	skip.Merge(trusted)
+	skip.Merge(ignorePacks)
+	idx, _, err := index.New(ctx, repo, skip, bar)
+	if err != nil {
+		return err
+	}
+
+	for id := range trusted {
+		err = idx.AddPack(id, packSizes[id], indexed[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	// fall back to the existing index entries for pack files with damaged
+	// headers
+	for id := range packSizes {
+		if _, ok := idx.Packs[id]; ok {
+			continue
+		}
+
+		blobs, ok := indexed[id]
+		if !ok {
+			Warnf("pack file %v cannot be read and is not indexed\n", id.Str())
+			continue
+		}
+
+		Warnf("pack file %v cannot be read, keeping the existing index entries\n", id.Str())
+		err = idx.AddPack(id, packSizes[id], blobs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return replaceIndex(ctx, repo, idx, supersedes)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairPacks = &cobra.Command{
+	Use:   "packs [pack-ID ...]",
+	Short: "Salvage damaged pack files",
+	Long: `
+The "repair packs" command salvages the blobs stored in damaged pack files, for
+example those reported by "check --read-data". Each pack file is downloaded
+and all blobs which can still be decrypted and match their ID are saved into
+new pack files. Afterwards the index is updated and the damaged pack files are
+removed from the repository.
+
+A copy of each damaged pack file is saved in the current directory before it is
+removed. If blobs could not be salvaged, run "repair snapshots" to remove the
+references to them.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairPacks(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairPacks)
+}
+
+// savePackCopy downloads the pack file id into a file with the same name in
+// the current directory.
+func savePackCopy(ctx context.Context, repo restic.Repository, id restic.ID) error {
+	f, err := fs.OpenFile(id.String(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	err = repo.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		// the backend may retry the download
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(f, rd)
+		return err
+	})
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func runRepairPacks(gopts GlobalOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("no pack IDs given")
+	}
+
+	ids := restic.NewIDSet()
+	for _, arg := range args {
+		id, err := restic.ParseID(arg)
+		if err != nil {
+			return errors.Fatalf("invalid pack ID %q: %v", arg, err)
+		}
+		ids.Insert(id)
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = requireAdminKey(gopts, repo, "repair packs"); err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("saving copies of the pack files in the current directory\n")
+	for id := range ids {
+		if err = savePackCopy(ctx, repo, id); err != nil {
+			return errors.Fatalf("unable to save a copy of pack file %v: %v", id.Str(), err)
+		}
+	}
+
+	Verbosef("salvaging blobs\n")
+	bar := newProgressMax(!gopts.Quiet, uint64(len(ids)), "packs")
+	bar.Start()
+	lost, err := repository.RepairPacks(ctx, repo, ids, bar)
+	if err != nil {
+		return err
+	}
+	bar.Done()
+
+	if err = repo.SaveIndex(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("rebuilding index without the damaged pack files\n")
+	if err = repairIndex(ctx, RepairIndexOptions{}, gopts, repo, ids); err != nil {
+		return err
+	}
+
+	Verbosef("removing damaged pack files\n")
+	for id := range ids {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("unable to remove pack file %v: %v\n", id.Str(), err)
+		}
+	}
+
+	if len(lost) > 0 {
+		if gopts.verbosity >= 2 {
+			for h := range lost {
+				Printf("lost %v blob %v\n", h.Type, h.ID.Str())
+			}
+		}
+		Warnf("%d blobs could not be salvaged, run \"restic repair snapshots\" to remove all references to them\n", len(lost))
+		return nil
+	}
+
+	Verbosef("all blobs were salvaged\n")
+	return nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshot-ID ...]",
+	Short: "Repair snapshots",
+	Long: `
+The "repair snapshots" command rewrites snapshots which reference missing data,
+so that the remaining data can be restored consistently. Files which reference
+data blobs missing in the index are removed, directories whose tree cannot be
+loaded are replaced by empty directories. The repaired snapshots are saved as
+new snapshots with the tag "repaired", the original snapshots are only removed
+when --forget is given.
+
+Run "repair index" before this command to make sure the index only lists data
+which is actually present in the repository.
+
+When no snapshot-ID is given, all snapshots matching the host, tag and path
+filter criteria are repaired.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotsOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotsOptions collects all options for the repair snapshots
+// command.
+type RepairSnapshotsOptions struct {
+	DryRun bool
+	Forget bool
+
+	Hosts []string
+	Paths []string
+	Tags  restic.TagLists
+}
+
+var repairSnapshotsOptions RepairSnapshotsOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVarP(&repairSnapshotsOptions.DryRun, "dry-run", "n", false, "do not modify the repository, only show what would be repaired")
+	f.BoolVar(&repairSnapshotsOptions.Forget, "forget", false, "remove the original snapshots after they were repaired")
+
+	f.StringArrayVarP(&repairSnapshotsOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&repairSnapshotsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.StringArrayVar(&repairSnapshotsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+}
+
+// repairedTag is added to all snapshots created by repair snapshots.
+const repairedTag = "repaired"
+
+// treeRepairer rewrites trees so that they only reference data present in
+// the repository.
+type treeRepairer struct {
+	repo   restic.Repository
+	dryRun bool
+
+	repaired  map[restic.ID]restic.ID // trees which were already checked
+	emptyTree *restic.ID
+
+	// report collects the changes to the current snapshot in JSON mode
+	report *repairedSnapshot
+}
+
+// repairedSnapshot is printed by repair snapshots in JSON mode for each
+// snapshot.
+type repairedSnapshot struct {
+	MessageType     string     `json:"message_type"` // "snapshot"
+	ID              restic.ID  `json:"id"`
+	Changed         bool       `json:"changed"`
+	Changes         []string   `json:"changes"`
+	NewID           *restic.ID `json:"new_id,omitempty"`
+	RemovedOriginal bool       `json:"removed_original,omitempty"`
+}
+
+// repairSummary is printed by repair snapshots in JSON mode at the end.
+type repairSummary struct {
+	MessageType      string `json:"message_type"` // "summary"
+	ChangedSnapshots int    `json:"changed_snapshots"`
+	DryRun           bool   `json:"dry_run"`
+}
+
+// printChange prints a change to the current snapshot, in JSON mode it is
+// added to the report.
+func (r *treeRepairer) printChange(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if r.report != nil {
+		r.report.Changes = append(r.report.Changes, msg)
+		return
+	}
+	Printf("  %s\n", msg)
+}
+
+func newTreeRepairer(repo restic.Repository, dryRun bool) *treeRepairer {
+	return &treeRepairer{
+		repo:     repo,
+		dryRun:   dryRun,
+		repaired: make(map[restic.ID]restic.ID),
+	}
+}
+
+// saveEmptyTree returns the ID of an empty tree, which replaces trees that
+// cannot be loaded.
+func (r *treeRepairer) saveEmptyTree(ctx context.Context) (restic.ID, error) {
+	if r.emptyTree != nil {
+		return *r.emptyTree, nil
+	}
+
+	var id restic.ID
+	if !r.dryRun {
+		var err error
+		id, err = r.repo.SaveTree(ctx, restic.NewTree())
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	r.emptyTree = &id
+	return id, nil
+}
+
+// checkFile returns an error describing the first data blob of node which is
+// missing in the index.
+func (r *treeRepairer) checkFile(node *restic.Node) error {
+	for _, id := range node.Content {
+		if !r.repo.Index().Has(id, restic.DataBlob) {
+			return errors.Errorf("blob %v is missing", id.Str())
+		}
+	}
+	return nil
+}
+
+// repairTree returns the ID of a repaired copy of the tree id. Problems are
+// printed with dir as the path of the tree. If the tree is intact, id is
+// returned.
+func (r *treeRepairer) repairTree(ctx context.Context, dir string, id restic.ID) (restic.ID, error) {
+	if newID, ok := r.repaired[id]; ok {
+		return newID, nil
+	}
+
+	tree, err := r.repo.LoadTree(ctx, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	changed := false
+	newTree := restic.NewTree()
+	for _, node := range tree.Nodes {
+		nodePath := path.Join(dir, node.Name)
+
+		switch node.Type {
+		case "file":
+			if err := r.checkFile(node); err != nil {
+				r.printChange("file %q: removed, %v", nodePath, err)
+				changed = true
+				continue
+			}
+		case "dir":
+			if node.Subtree == nil {
+				break
+			}
+
+			subtree, err := r.repairTree(ctx, nodePath, *node.Subtree)
+			if ctx.Err() != nil {
+				return restic.ID{}, ctx.Err()
+			}
+			if err != nil {
+				debug.Log("unable to load tree %v: %v", node.Subtree, err)
+				r.printChange("dir %q: replaced with empty directory, tree %v cannot be loaded", nodePath, node.Subtree.Str())
+				subtree, err = r.saveEmptyTree(ctx)
+				if err != nil {
+					return restic.ID{}, err
+				}
+			}
+
+			if subtree != *node.Subtree {
+				changed = true
+				node.Subtree = &subtree
+			}
+		}
+
+		err = newTree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	newID := id
+	if changed {
+		if r.dryRun {
+			// the ID only needs to be different from the original one
+			newID = restic.ID{}
+		} else {
+			newID, err = r.repo.SaveTree(ctx, newTree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+		}
+	}
+
+	r.repaired[id] = newID
+	return newID, nil
+}
+
+// repairSnapshot repairs the trees of sn and saves a new snapshot if
+// necessary. It returns true if the snapshot was changed.
+func repairSnapshot(ctx context.Context, r *treeRepairer, opts RepairSnapshotsOptions, sn *restic.Snapshot) (bool, error) {
+	if sn.Tree == nil {
+		return false, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	root, err := r.repairTree(ctx, "/", *sn.Tree)
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		debug.Log("unable to load tree %v: %v", sn.Tree, err)
+		r.printChange("root tree %v cannot be loaded, replaced with empty directory", sn.Tree.Str())
+		root, err = r.saveEmptyTree(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if root == *sn.Tree {
+		return false, nil
+	}
+
+	if opts.DryRun {
+		return true, nil
+	}
+
+	// make sure the new trees are saved before they are referenced
+	if err = r.repo.Flush(ctx); err != nil {
+		return false, err
+	}
+	if err = r.repo.SaveIndex(ctx); err != nil {
+		return false, err
+	}
+
+	oldID := *sn.ID()
+	if sn.Original == nil {
+		sn.Original = sn.ID()
+	}
+	sn.Tree = &root
+	sn.AddTags([]string{repairedTag})
+
+	id, err := r.repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return false, err
+	}
+	if r.report != nil {
+		r.report.NewID = &id
+	} else {
+		Printf("  saved new snapshot %v\n", id.Str())
+	}
+
+	if opts.Forget {
+		auditLog(ctx, r.repo, "repair snapshots", restic.IDs{oldID}, fmt.Sprintf("replace snapshot %v by %v", oldID.Str(), id.Str()))
+
+		h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+		if err = r.repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		if r.report != nil {
+			r.report.RemovedOriginal = true
+		} else {
+			Printf("  removed original snapshot %v\n", oldID.Str())
+		}
+	}
+
+	return true, nil
+}
+
+func runRepairSnapshots(opts RepairSnapshotsOptions, gopts GlobalOptions, args []string) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Forget && !opts.DryRun {
+		if err = requireAdminKey(gopts, repo, "repair snapshots --forget"); err != nil {
+			return err
+		}
+		if err = requireApproval(gopts, repo, "repair-snapshots", ""); err != nil {
+			return err
+		}
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.Forget && !opts.DryRun {
+			lock, err = lockRepoExclusive(repo)
+		} else {
+			lock, err = lockRepo(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	r := newTreeRepairer(repo, opts.DryRun)
+
+	enc := json.NewEncoder(gopts.stdout)
+
+	changeCnt := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		if gopts.JSON {
+			r.report = &repairedSnapshot{MessageType: "snapshot", ID: *sn.ID(), Changes: []string{}}
+		} else {
+			Printf("snapshot %v of %v at %v\n", sn.ID().Str(), sn.Paths, sn.Time)
+		}
+
+		changed, err := repairSnapshot(ctx, r, opts, sn)
+		if err != nil {
+			Warnf("unable to repair snapshot %v: %v\n", sn.ID().Str(), err)
+			continue
+		}
+		if changed {
+			changeCnt++
+		} else if !gopts.JSON {
+			Printf("  snapshot is intact\n")
+		}
+
+		if gopts.JSON {
+			r.report.Changed = changed
+			if err = enc.Encode(r.report); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if gopts.JSON {
+		return enc.Encode(repairSummary{MessageType: "summary", ChangedSnapshots: changeCnt, DryRun: opts.DryRun})
+	}
+
+	switch {
+	case changeCnt == 0:
+		Verbosef("no snapshots were modified\n")
+	case opts.DryRun:
+		Verbosef("would repair %d snapshots\n", changeCnt)
+	default:
+		Verbosef("repaired %d snapshots\n", changeCnt)
+	}
+	return nil
+}
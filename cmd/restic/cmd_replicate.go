@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdReplicate = &cobra.Command{
+	Use:   "replicate [flags]",
+	Short: "Mirror the repository files to another location",
+	Long: `
+The "replicate" command copies the files of the repository given with --repo
+byte for byte to the location given with --to. The data is not decrypted or
+re-encrypted, so the mirror is an exact copy of the repository and can be
+accessed with the same passwords.
+
+Only files which are missing in the mirror or have a different size are
+transferred, so an interrupted run continues where it stopped. Snapshots are
+transferred last, so the mirror only references data which was already
+copied. With --delete, files which no longer exist in the repository, for
+example after "forget" and "prune", are removed from the mirror.
+
+The mirror must not be used for backups, otherwise it diverges from the
+repository. Locks and the audit log are not replicated.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReplicate(replicateOptions, globalOptions)
+	},
+}
+
+// ReplicateOptions bundles all options for the replicate command.
+type ReplicateOptions struct {
+	To     string
+	Delete bool
+}
+
+var replicateOptions ReplicateOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdReplicate)
+
+	f := cmdReplicate.Flags()
+	f.StringVar(&replicateOptions.To, "to", "", "`repository` location to replicate the repository to")
+	f.BoolVar(&replicateOptions.Delete, "delete", false, "remove files from the mirror which no longer exist in the repository")
+}
+
+// replicateFileTypes are the file types in the order they are replicated.
+// Deletions are propagated in the reverse order.
+var replicateFileTypes = []restic.FileType{
+	restic.KeyFile,
+	restic.DataFile,
+	restic.IndexFile,
+	restic.SnapshotFile,
+}
+
+// replicateStats counts the files processed by replicate.
+type replicateStats struct {
+	copied, skipped, removed int
+	bytes                    uint64
+}
+
+// listFileSizes returns the names and sizes of all files of type t in be.
+func listFileSizes(ctx context.Context, be restic.Backend, t restic.FileType) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := be.List(ctx, t, func(fi restic.FileInfo) error {
+		files[fi.Name] = fi.Size
+		return nil
+	})
+	return files, err
+}
+
+// replicateFile copies the file h from src to dst. Files which are named after
+// the hash of their content are verified before they are saved.
+func replicateFile(ctx context.Context, src, dst restic.Backend, h restic.Handle, buf []byte) ([]byte, error) {
+	buf, err := backend.LoadAll(ctx, buf, src, h)
+	if err != nil {
+		return buf, err
+	}
+
+	if h.Type != restic.ConfigFile {
+		id, err := restic.ParseID(h.Name)
+		if err == nil && !restic.Hash(buf).Equal(id) {
+			return buf, errors.Fatalf("file %v is damaged, run 'restic check'", h)
+		}
+	}
+
+	err = dst.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		return buf, err
+	}
+
+	debug.Log("replicated %v", h)
+	return buf, nil
+}
+
+// openMirror opens the backend at location. If it does not contain a
+// repository yet, it is created and the config of src is saved. Otherwise,
+// the config must match the one of src.
+func openMirror(ctx context.Context, location string, gopts GlobalOptions, src restic.Backend) (restic.Backend, error) {
+	h := restic.Handle{Type: restic.ConfigFile}
+	cfg, err := backend.LoadAll(ctx, nil, src, h)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := open(location, gopts, gopts.extended)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := dst.Test(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		dstCfg, err := backend.LoadAll(ctx, nil, dst, h)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(cfg, dstCfg) {
+			return nil, errors.Fatalf("%s contains a different repository", location)
+		}
+		return dst, nil
+	}
+
+	Verbosef("creating mirror at %s\n", location)
+	dst, err = create(location, gopts.extended)
+	if err != nil {
+		return nil, errors.Fatalf("create repository at %s failed: %v\n", location, err)
+	}
+
+	err = dst.Save(ctx, h, restic.NewByteReader(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func runReplicate(opts ReplicateOptions, gopts GlobalOptions) error {
+	if opts.To == "" {
+		return errors.Fatal("Please specify the location of the mirror (--to)")
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	src := repo.Backend()
+	dst, err := openMirror(ctx, opts.To, gopts, src)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	var stats replicateStats
+	var buf []byte
+	srcFiles := make(map[restic.FileType]map[string]int64)
+	dstFiles := make(map[restic.FileType]map[string]int64)
+
+	// list snapshots before the data they reference, so that all data needed
+	// by the listed snapshots is replicated
+	for i := len(replicateFileTypes) - 1; i >= 0; i-- {
+		t := replicateFileTypes[i]
+		srcFiles[t], err = listFileSizes(ctx, src, t)
+		if err != nil {
+			return err
+		}
+		dstFiles[t], err = listFileSizes(ctx, dst, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range replicateFileTypes {
+		var todo []string
+		for name, size := range srcFiles[t] {
+			if dstSize, ok := dstFiles[t][name]; ok && dstSize == size {
+				stats.skipped++
+				continue
+			}
+			todo = append(todo, name)
+		}
+
+		Verbosef("replicating %d %s files\n", len(todo), t)
+		bar := newProgressMax(!gopts.Quiet, uint64(len(todo)), string(t)+" files")
+		bar.Start()
+		for _, name := range todo {
+			h := restic.Handle{Type: t, Name: name}
+
+			// remove incomplete files left by an interrupted run
+			if _, ok := dstFiles[t][name]; ok {
+				if err = dst.Remove(ctx, h); err != nil {
+					return err
+				}
+			}
+
+			buf, err = replicateFile(ctx, src, dst, h, buf)
+			if err != nil {
+				return err
+			}
+			stats.copied++
+			stats.bytes += uint64(srcFiles[t][name])
+			bar.Report(restic.Stat{Blobs: 1})
+		}
+		bar.Done()
+	}
+
+	if opts.Delete {
+		for i := len(replicateFileTypes) - 1; i >= 0; i-- {
+			t := replicateFileTypes[i]
+			for name := range dstFiles[t] {
+				if _, ok := srcFiles[t][name]; ok {
+					continue
+				}
+
+				h := restic.Handle{Type: t, Name: name}
+				if err = dst.Remove(ctx, h); err != nil {
+					return err
+				}
+				debug.Log("removed %v from mirror", h)
+				stats.removed++
+			}
+		}
+	}
+
+	Verbosef("copied %d files (%s), skipped %d files, removed %d files\n",
+		stats.copied, formatBytes(stats.bytes), stats.skipped, stats.removed)
+	return nil
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
 
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui/json"
 
 	"github.com/spf13/cobra"
 )
@@ -39,11 +47,30 @@ type RestoreOptions struct {
 	InsensitiveExclude []string
 	Include            []string
 	InsensitiveInclude []string
+	FilesFrom          []string
 	Target             string
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
 	Verify             bool
+	Overwrite          string
+	Delete             bool
+	Resume             bool
+	NoOwner            bool
+	NoPermissions      bool
+	NoTimes            bool
+	NoXattrs           bool
+	WindowsMetadata    bool
+	Device             string
+	Image              string
+	Sparse             bool
+	DryRun             bool
+	StripPrefix        string
+	Map                []string
+	MetadataReport     string
+	StrictMetadata     bool
+	Seed               []string
+	SkipSpaceCheck     bool
 }
 
 var restoreOptions RestoreOptions
@@ -56,12 +83,31 @@ func init() {
 	flags.StringArrayVar(&restoreOptions.InsensitiveExclude, "iexclude", nil, "same as `--exclude` but ignores the casing of filenames")
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as `--include` but ignores the casing of filenames")
-	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
+	flags.StringArrayVar(&restoreOptions.FilesFrom, "files-from", nil, "only restore the paths listed in `file`, one per line (can be specified multiple times)")
+	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to, may be a remote directory (sftp:user@host:/path)")
 
 	flags.StringArrayVarP(&restoreOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.StringVar(&restoreOptions.Overwrite, "overwrite", "always", "how to handle files which already exist in the target directory (always, if-changed)")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from target directory if they do not exist in snapshot")
+	flags.BoolVar(&restoreOptions.Resume, "resume", false, "record progress in the target directory and skip files already restored by an interrupted restore")
+	flags.BoolVar(&restoreOptions.NoOwner, "no-owner", false, "do not restore the owner and group of files")
+	flags.BoolVar(&restoreOptions.NoPermissions, "no-permissions", false, "do not restore file permissions")
+	flags.BoolVar(&restoreOptions.NoTimes, "no-times", false, "do not restore access and modification times")
+	flags.BoolVar(&restoreOptions.NoXattrs, "no-xattrs", false, "do not restore extended attributes")
+	flags.BoolVar(&restoreOptions.WindowsMetadata, "restore-windows-metadata", false, "restore file attributes, security descriptors and alternate data streams (Windows only)")
+	flags.StringVar(&restoreOptions.Device, "device", "", "write the image file from the snapshot to the block `device` instead of restoring to a directory")
+	flags.StringVar(&restoreOptions.Image, "image", "", "`path` of the image file in the snapshot for --device (default: the only file in the snapshot)")
+	flags.StringVar(&restoreOptions.StripPrefix, "strip-prefix", "", "restore the contents of `path` in the snapshot directly into the target directory")
+	flags.StringArrayVar(&restoreOptions.Map, "map", nil, "restore `/old/path=/new/path` from the snapshot to a different path within the target directory (can be specified multiple times)")
+	flags.StringVar(&restoreOptions.MetadataReport, "metadata-report", "", "write a JSON report of data which cannot be restored on this platform, e.g. alternate data streams, to `file`")
+	flags.BoolVar(&restoreOptions.StrictMetadata, "strict-metadata", false, "treat data which cannot be restored on this platform as errors")
+	flags.StringArrayVar(&restoreOptions.Seed, "seed", nil, "copy data found in files below `dir` instead of downloading it, e.g. from an older restore (can be specified multiple times)")
+	flags.BoolVar(&restoreOptions.SkipSpaceCheck, "skip-space-check", false, "do not check that the target directory has enough free space before restoring")
+	flags.BoolVarP(&restoreOptions.DryRun, "dry-run", "n", false, "do not write anything, just print what would be done")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "skip writing blocks which only contain zeroes for --device, the device must be zeroed already")
 }
 
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
@@ -84,14 +130,84 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatalf("more than one snapshot ID specified: %v", args)
 	}
 
-	if opts.Target == "" {
+	if opts.Device != "" {
+		if opts.Target != "" {
+			return errors.Fatal("--target and --device are mutually exclusive")
+		}
+		if opts.Delete || opts.Resume {
+			return errors.Fatal("--delete and --resume cannot be used with --device")
+		}
+	} else if opts.Sparse {
+		return errors.Fatal("--sparse can only be used with --device")
+	} else if opts.Image != "" && opts.Target != "-" {
+		return errors.Fatal("--image can only be used with --device or --target -")
+	}
+
+	toStdout := opts.Target == "-"
+	if toStdout {
+		if opts.Delete || opts.Resume || opts.Verify || opts.DryRun || opts.Overwrite == "if-changed" ||
+			len(opts.Seed) > 0 || opts.StripPrefix != "" || len(opts.Map) > 0 ||
+			hasExcludes || hasIncludes || len(opts.FilesFrom) > 0 {
+			return errors.Fatal("--target - only supports --image and --json")
+		}
+		if stdoutIsTerminal() {
+			return errors.Fatal("stdout is a terminal, please redirect the output of restore --target -")
+		}
+	}
+
+	if opts.Target == "" && opts.Device == "" {
 		return errors.Fatal("please specify a directory to restore to (--target)")
 	}
 
-	if hasExcludes && hasIncludes {
+	var filesFrom []string
+	for _, filename := range opts.FilesFrom {
+		lines, err := readLinesFromFile(filename)
+		if err != nil {
+			return errors.Fatalf("unable to read --files-from %v: %v", filename, err)
+		}
+		filesFrom = append(filesFrom, lines...)
+	}
+	hasFilesFrom := len(opts.FilesFrom) > 0
+
+	if hasExcludes && (hasIncludes || hasFilesFrom) {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	if opts.WindowsMetadata && runtime.GOOS != "windows" {
+		Warnf("--restore-windows-metadata is only supported on Windows, ignoring\n")
+	}
+
+	var overwrite restorer.OverwriteBehavior
+	switch opts.Overwrite {
+	case "", "always":
+		overwrite = restorer.OverwriteAlways
+	case "if-changed":
+		overwrite = restorer.OverwriteIfChanged
+	default:
+		return errors.Fatalf("invalid value for --overwrite: %q, must be one of always, if-changed", opts.Overwrite)
+	}
+
+	isRemote := strings.HasPrefix(opts.Target, "sftp:")
+	if opts.DryRun && (isRemote || opts.Device != "") {
+		return errors.Fatal("--dry-run is only supported for restoring to a local directory")
+	}
+	if len(opts.Seed) > 0 && (isRemote || opts.Device != "") {
+		return errors.Fatal("--seed is only supported for restoring to a local directory")
+	}
+	if isRemote {
+		if overwrite != restorer.OverwriteAlways || opts.Delete || opts.Resume || opts.Verify {
+			return errors.Fatal("--overwrite, --delete, --resume and --verify are not supported for remote targets")
+		}
+	}
+
+	mappings, err := parsePathMappings(opts)
+	if err != nil {
+		return err
+	}
+	if len(mappings) > 0 && opts.Delete {
+		return errors.Fatal("--delete cannot be combined with --strip-prefix or --map")
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -133,6 +249,31 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
 
+	res.Overwrite = overwrite
+	res.Delete = opts.Delete
+	res.Resume = opts.Resume
+	res.PathMappings = mappings
+	// only the contents of the stripped prefix and mapped paths are restored
+	res.SkipUnmapped = opts.StripPrefix != ""
+	res.Seed = opts.Seed
+	res.CheckSpace = !opts.SkipSpaceCheck
+
+	report := &metadataReport{}
+	res.UnsupportedMetadata = func(location string, kinds []string) error {
+		report.add(location, kinds)
+		if opts.StrictMetadata {
+			return errors.Errorf("cannot restore %s on %s", strings.Join(kinds, ", "), runtime.GOOS)
+		}
+		return nil
+	}
+	res.MetadataOptions = restic.RestoreMetadataOptions{
+		NoOwner:              opts.NoOwner,
+		NoPermissions:        opts.NoPermissions,
+		NoTimes:              opts.NoTimes,
+		NoExtendedAttributes: opts.NoXattrs,
+		WindowsMetadata:      opts.WindowsMetadata,
+	}
+
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
 		Warnf("ignoring error for %s: %s\n", location, err)
@@ -140,6 +281,85 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return nil
 	}
 
+	var progress *json.Restore
+	if gopts.JSON {
+		out := gopts.stdout
+		if toStdout {
+			// the data is written to stdout
+			out = gopts.stderr
+		}
+		progress = json.NewRestore(out)
+		res.Error = progress.Error
+		res.ReportTotal = progress.ReportTotal
+		res.StartFile = progress.StartFile
+		res.CompleteBlob = progress.CompleteBlob
+		res.CompleteFile = progress.CompleteFile
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go progress.Run(ctx)
+	}
+
+	statusOut, err := openStatusWriter(gopts)
+	if err != nil {
+		return err
+	}
+
+	if statusOut != nil {
+		defer statusOut.Close()
+
+		// status receives the JSON progress messages for --status-fd and
+		// --status-socket in addition to the callbacks set above
+		status := json.NewRestore(statusOut)
+		defer func() {
+			status.Finish(id)
+		}()
+
+		prevError := res.Error
+		res.Error = func(location string, err error) error {
+			_ = status.Error(location, err)
+			return prevError(location, err)
+		}
+
+		prevReportTotal := res.ReportTotal
+		res.ReportTotal = func(files, bytes uint64) {
+			status.ReportTotal(files, bytes)
+			if prevReportTotal != nil {
+				prevReportTotal(files, bytes)
+			}
+		}
+
+		prevStartFile := res.StartFile
+		res.StartFile = func(location string) {
+			status.StartFile(location)
+			if prevStartFile != nil {
+				prevStartFile(location)
+			}
+		}
+
+		prevCompleteBlob := res.CompleteBlob
+		res.CompleteBlob = func(location string, bytes uint64) {
+			status.CompleteBlob(location, bytes)
+			if prevCompleteBlob != nil {
+				prevCompleteBlob(location, bytes)
+			}
+		}
+
+		prevCompleteFile := res.CompleteFile
+		res.CompleteFile = func(location string, size uint64, skipped bool) {
+			status.CompleteFile(location, size, skipped)
+			if prevCompleteFile != nil {
+				prevCompleteFile(location, size, skipped)
+			}
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go status.Run(ctx)
+	}
+
 	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
 		matched, _, err := filter.List(opts.Exclude, item)
 		if err != nil {
@@ -178,23 +398,354 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return selectedForRestore, childMayBeSelected
 	}
 
-	if hasExcludes {
+	selectFilesFromFilter := newFilesFromFilter(filesFrom)
+
+	switch {
+	case hasExcludes:
 		res.SelectFilter = selectExcludeFilter
-	} else if hasIncludes {
+	case hasIncludes && hasFilesFrom:
+		res.SelectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			selected, childMayBeSelected := selectIncludeFilter(item, dstpath, node)
+			selectedFrom, childMayBeSelectedFrom := selectFilesFromFilter(item, dstpath, node)
+			return selected || selectedFrom, childMayBeSelected || childMayBeSelectedFrom
+		}
+	case hasIncludes:
 		res.SelectFilter = selectIncludeFilter
+	case hasFilesFrom:
+		res.SelectFilter = selectFilesFromFilter
+	}
+
+	if opts.DryRun {
+		err = printRestorePlan(ctx, opts, gopts, res, progress)
+		if progress != nil {
+			progress.Finish(id)
+		}
+		return err
+	}
+
+	if toStdout {
+		err = restoreStdout(ctx, opts, gopts, res, progress)
+		if progress != nil {
+			progress.Finish(id)
+		}
+		return err
+	}
+
+	if opts.Device != "" {
+		err = restoreDevice(ctx, opts, gopts, res)
+		if progress != nil {
+			progress.Finish(id)
+		}
+		return err
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if !gopts.JSON {
+		Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	}
+
+	if isRemote {
+		var target *sftp.Target
+		var dir string
+		target, dir, err = openRemoteTarget(gopts, opts.Target)
+		if err != nil {
+			return err
+		}
 
-	err = res.RestoreTo(ctx, opts.Target)
+		err = res.RestoreToRemote(ctx, target, dir)
+		if cerr := target.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	} else {
+		err = res.RestoreTo(ctx, opts.Target)
+		if e, ok := err.(*restorer.InsufficientSpaceError); ok {
+			if e.NeededBytes > e.FreeBytes {
+				return errors.Fatalf("not enough free space in %s: restore needs %s, but only %s are available",
+					opts.Target, formatBytes(e.NeededBytes), formatBytes(e.FreeBytes))
+			}
+			return errors.Fatalf("not enough free inodes in %s: restore needs %d, but only %d are available",
+				opts.Target, e.NeededInodes, e.FreeInodes)
+		}
+	}
 	if err == nil && opts.Verify {
-		Verbosef("verifying files in %s\n", opts.Target)
+		if !gopts.JSON {
+			Verbosef("verifying files in %s\n", opts.Target)
+		}
 		var count int
 		count, err = res.VerifyFiles(ctx, opts.Target)
-		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
+		if !gopts.JSON {
+			Verbosef("finished verifying %d files in %s\n", count, opts.Target)
+		}
+	}
+	if opts.MetadataReport != "" {
+		if rerr := report.save(opts.MetadataReport); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if progress != nil {
+		progress.Finish(id)
+		return err
+	}
+	if len(report.Items) > 0 && !opts.StrictMetadata {
+		Warnf("some data cannot be restored on %s and was skipped: %s\n", runtime.GOOS, report.summary())
+	}
+	if res.MetadataOptions.SkipsAny() {
+		Verbosef("did not restore %s for %d items\n", strings.Join(skippedMetadata(opts), ", "), res.MetadataSkipped())
 	}
 	if totalErrors > 0 {
 		Printf("There were %d errors\n", totalErrors)
 	}
 	return err
 }
+
+// restoreDevice writes the image file from the snapshot to opts.Device.
+func restoreDevice(ctx context.Context, opts RestoreOptions, gopts GlobalOptions, res *restorer.Restorer) error {
+	node, err := res.FindImage(ctx, opts.Image)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	res.ReportTotal(1, node.Size)
+
+	if !gopts.JSON {
+		Verbosef("restoring %s from %s to %s\n", node.Name, res.Snapshot(), opts.Device)
+
+		p := newProgressBytes(!gopts.Quiet, node.Size, "written")
+		res.CompleteBlob = func(_ string, bytes uint64) {
+			p.Report(restic.Stat{Bytes: bytes})
+		}
+		p.Start()
+		defer p.Done()
+	}
+
+	err = res.RestoreToDevice(ctx, node, opts.Device, opts.Sparse)
+	if err != nil {
+		return err
+	}
+
+	if opts.Verify {
+		if !gopts.JSON {
+			Verbosef("verifying %s\n", opts.Device)
+		}
+		err = res.VerifyDevice(ctx, node, opts.Device)
+		if err != nil {
+			return errors.Fatalf("verifying %s failed: %v", opts.Device, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreStdout writes the file from the snapshot to stdout, which is usually
+// the only file in a snapshot created from stdin.
+func restoreStdout(ctx context.Context, opts RestoreOptions, gopts GlobalOptions, res *restorer.Restorer, progress *json.Restore) error {
+	node, err := res.FindImage(ctx, opts.Image)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	if progress != nil {
+		progress.SetFilename(node.Name)
+	}
+	res.ReportTotal(1, node.Size)
+
+	return res.RestoreToWriter(ctx, node, gopts.stdout)
+}
+
+// openRemoteTarget connects to the remote target directory given as
+// sftp:user@host:/path or sftp://user@host/path. Extended options for the sftp
+// backend, e.g. sftp.command, are applied.
+func openRemoteTarget(gopts GlobalOptions, s string) (*sftp.Target, string, error) {
+	loc, err := location.Parse(s)
+	if err != nil {
+		return nil, "", errors.Fatalf("parsing target %q failed: %v", s, err)
+	}
+
+	cfg, err := parseConfig(loc, gopts.extended)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sftpCfg := cfg.(sftp.Config)
+	target, err := sftp.OpenTarget(sftpCfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return target, sftpCfg.Path, nil
+}
+
+// printRestorePlan prints what restoring to opts.Target would do.
+func printRestorePlan(ctx context.Context, opts RestoreOptions, gopts GlobalOptions, res *restorer.Restorer, progress *json.Restore) error {
+	var files, dirs, other, conflicts, bytes uint64
+	actions := make(map[restorer.Action]uint64)
+
+	err := res.Plan(ctx, opts.Target, func(item restorer.PlannedItem) error {
+		actions[item.Action]++
+		if item.Conflict {
+			conflicts++
+		}
+
+		nodeType := "-"
+		var size uint64
+		if item.Node != nil {
+			nodeType = item.Node.Type
+			switch nodeType {
+			case "file":
+				files++
+				size = item.Node.Size
+				if item.Action != restorer.ActionSkip {
+					bytes += size
+				}
+			case "dir":
+				dirs++
+			default:
+				other++
+			}
+		}
+
+		if progress != nil {
+			progress.PlannedItem(item.Location, nodeType, item.Action.String(), size, item.Conflict)
+			return nil
+		}
+
+		sizeStr := ""
+		if nodeType == "file" {
+			sizeStr = formatBytes(size)
+		}
+		line := fmt.Sprintf("would %-9s %-7s %10s  %s", item.Action, nodeType, sizeStr, item.Location)
+		if item.Conflict {
+			line += " (conflict: existing item has a different type)"
+		}
+		Printf("%s\n", line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress.ReportTotal(files, bytes)
+		return nil
+	}
+
+	Printf("\nwould restore %d files, %d directories and %d other items, writing %s\n",
+		files, dirs, other, formatBytes(bytes))
+	Printf("%d new, %d overwritten, %d updated, %d unchanged, %d deleted, %d conflicts\n",
+		actions[restorer.ActionCreate], actions[restorer.ActionOverwrite], actions[restorer.ActionUpdate],
+		actions[restorer.ActionSkip], actions[restorer.ActionDelete], conflicts)
+
+	restored := restoredMetadata(opts)
+	if len(restored) > 0 {
+		Printf("would restore %s\n", strings.Join(restored, ", "))
+	}
+	if skipped := skippedMetadata(opts); len(skipped) > 0 {
+		Printf("would not restore %s\n", strings.Join(skipped, ", "))
+	}
+
+	return nil
+}
+
+// newFilesFromFilter returns a filter which selects the given paths within
+// the snapshot and everything below them.
+func newFilesFromFilter(paths []string) func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+	selected := make(map[string]struct{}, len(paths))
+	parents := make(map[string]struct{})
+	for _, p := range paths {
+		p = filepath.FromSlash(snapshotPath(p))
+		selected[p] = struct{}{}
+
+		for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+			parents[dir] = struct{}{}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	return func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		for p := item; ; p = filepath.Dir(p) {
+			if _, ok := selected[p]; ok {
+				selectedForRestore = true
+				break
+			}
+			if p == filepath.Dir(p) {
+				break
+			}
+		}
+
+		_, isParent := parents[item]
+		childMayBeSelected = node.Type == "dir" && (selectedForRestore || isParent)
+
+		return selectedForRestore, childMayBeSelected
+	}
+}
+
+// parsePathMappings returns the path mappings selected by --strip-prefix and
+// --map.
+func parsePathMappings(opts RestoreOptions) ([]restorer.PathMapping, error) {
+	var mappings []restorer.PathMapping
+	if opts.StripPrefix != "" {
+		mappings = append(mappings, restorer.PathMapping{From: snapshotPath(opts.StripPrefix), To: "/"})
+	}
+
+	for _, m := range opts.Map {
+		data := strings.SplitN(m, "=", 2)
+		if len(data) != 2 || data[0] == "" || data[1] == "" {
+			return nil, errors.Fatalf("invalid path mapping %q, must be /old/path=/new/path", m)
+		}
+		mappings = append(mappings, restorer.PathMapping{From: snapshotPath(data[0]), To: snapshotPath(data[1])})
+	}
+
+	return mappings, nil
+}
+
+// snapshotPath converts p to a slash-separated path within a snapshot. Windows
+// paths with a drive letter such as D:\Data are stored as /D/Data.
+func snapshotPath(p string) string {
+	p = strings.Replace(p, "\\", "/", -1)
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/" + p[:1] + p[2:]
+	}
+	return path.Clean("/" + p)
+}
+
+// restoredMetadata returns the names of the kinds of metadata which are
+// restored.
+func restoredMetadata(opts RestoreOptions) []string {
+	var restored []string
+	if !opts.NoOwner {
+		restored = append(restored, "owner")
+	}
+	if !opts.NoPermissions {
+		restored = append(restored, "permissions")
+	}
+	if !opts.NoTimes {
+		restored = append(restored, "timestamps")
+	}
+	if !opts.NoXattrs {
+		restored = append(restored, "extended attributes")
+	}
+	if opts.WindowsMetadata && runtime.GOOS == "windows" {
+		restored = append(restored, "Windows metadata")
+	}
+	return restored
+}
+
+// skippedMetadata returns the names of the kinds of metadata which are not
+// restored.
+func skippedMetadata(opts RestoreOptions) []string {
+	var skipped []string
+	if opts.NoOwner {
+		skipped = append(skipped, "owner")
+	}
+	if opts.NoPermissions {
+		skipped = append(skipped, "permissions")
+	}
+	if opts.NoTimes {
+		skipped = append(skipped, "timestamps")
+	}
+	if opts.NoXattrs {
+		skipped = append(skipped, "extended attributes")
+	}
+	return skipped
+}
@@ -19,6 +19,10 @@ GitHub and replaces the currently running binary. After download, the
 authenticity of the binary is verified using the GPG signature on the release
 files.
 
+The new binary is written to a temporary file next to the old one, which is
+then replaced atomically. If the new binary does not run or reports an
+unexpected version, the previous binary is restored.
+
 EXIT STATUS
 ===========
 
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+var cmdServe = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the repository to other programs",
+	Long: `
+The "serve" command contains subcommands which make the snapshots in the
+repository available to other programs via network protocols.
+`,
+	DisableAutoGenTag: true,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdServe)
+}
+
+// loadServePassword returns the password for HTTP basic auth from file, or
+// from the environment variable env if file is empty.
+func loadServePassword(file, env string) (string, error) {
+	password := os.Getenv(env)
+	if file != "" {
+		pw, err := loadPasswordFromFile(file)
+		if err != nil {
+			return "", err
+		}
+		password = pw
+	}
+
+	if password == "" {
+		return "", errors.Fatalf("--auth-user requires a password, use --auth-password-file or $%v", env)
+	}
+	return password, nil
+}
+
+// serveHTTP serves handler on the address listen until an error occurs. If
+// tlsCert is set, HTTPS is used. A warning is printed when clients from other
+// hosts can connect without authentication.
+func serveHTTP(handler http.Handler, listen, tlsCert, tlsKey string, auth bool) error {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Fatalf("unable to listen on %v: %v", listen, err)
+	}
+
+	srv := &http.Server{Handler: handler}
+
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	if !auth && !isLoopback(ln.Addr()) {
+		Warnf("warning: serving without authentication on %v, everyone who can connect can access the repository\n", ln.Addr())
+	}
+
+	Printf("Now serving the repository at %s://%v/\n", scheme, ln.Addr())
+	Printf("When finished, quit with Ctrl-c.\n")
+	debug.Log("serving at %v", ln.Addr())
+
+	if tlsCert != "" {
+		return srv.ServeTLS(ln, tlsCert, tlsKey)
+	}
+	return srv.Serve(ln)
+}
+
+// isLoopback returns true if addr can only be reached from the local host.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// isLoopbackListen returns true if the address listen can only be reached
+// from the local host.
+func isLoopbackListen(listen string) bool {
+	addr, err := net.ResolveTCPAddr("tcp", listen)
+	return err == nil && addr.IP != nil && addr.IP.IsLoopback()
+}
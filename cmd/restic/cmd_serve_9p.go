@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/ninepserver"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/webdav"
+)
+
+var cmdServe9P = &cobra.Command{
+	Use:   "9p [flags]",
+	Short: "Serve the snapshots read-only via the 9P protocol",
+	Long: `
+The "serve 9p" command serves the snapshots in the repository read-only via
+9P2000.L over TCP, so that they can be mounted with the 9p file system of
+Linux, e.g. in virtual machines or WSL instances without FUSE:
+
+    mount -t 9p -o trans=tcp,port=5640,version=9p2000.L,ro HOST /mnt/restic
+
+The snapshots are organized in the same directories as for "restic mount",
+the templates for the directories can be set with --path-template. Each
+directory which contains snapshots also contains a directory "latest" with the
+newest snapshot.
+
+The 9P protocol does not authenticate clients, everyone who can connect can
+read all snapshots. By default, the server only listens on the local host.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServe9P(serve9POptions, globalOptions, args)
+	},
+}
+
+// Serve9POptions collects all options for the serve 9p command.
+type Serve9POptions struct {
+	Listen           string
+	Hosts            []string
+	Tags             restic.TagLists
+	Paths            []string
+	SnapshotTemplate string
+	PathTemplates    []string
+}
+
+var serve9POptions Serve9POptions
+
+func init() {
+	cmdServe.AddCommand(cmdServe9P)
+
+	f := cmdServe9P.Flags()
+	f.StringVar(&serve9POptions.Listen, "listen", "localhost:5640", "listen on this `address`")
+
+	f.StringArrayVarP(&serve9POptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
+	f.Var(&serve9POptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	f.StringArrayVar(&serve9POptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	f.StringVar(&serve9POptions.SnapshotTemplate, "snapshot-template", "2006-01-02T15-04-05Z07-00", "set `template` to use for snapshot dirs")
+	f.StringArrayVar(&serve9POptions.PathTemplates, "path-template", nil, "set `template` for the directories which contain snapshots, e.g. \"hosts/%h/%T\" (can be specified multiple times, default: ids, snapshots, hosts, tags and paths)")
+}
+
+func runServe9P(opts Serve9POptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the serve 9p command expects no arguments")
+	}
+
+	if opts.SnapshotTemplate == "" || strings.ContainsAny(opts.SnapshotTemplate, `\/`) {
+		return errors.Fatal("snapshot template string is empty or contains a slash (/) or backslash (\\) character")
+	}
+
+	for _, template := range opts.PathTemplates {
+		if err := fuse.CheckPathTemplate(template); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	fs := webdav.NewFileSystem(repo, webdav.Config{
+		Hosts:            opts.Hosts,
+		Tags:             opts.Tags,
+		Paths:            opts.Paths,
+		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+	})
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return errors.Fatalf("unable to listen on %v: %v", opts.Listen, err)
+	}
+
+	if !isLoopback(ln.Addr()) {
+		Warnf("warning: 9P has no authentication, everyone who can connect to %v can read the snapshots\n", ln.Addr())
+	}
+
+	Printf("Now serving the repository via 9P at %v\n", ln.Addr())
+	Printf("When finished, quit with Ctrl-c.\n")
+	debug.Log("serving 9P at %v", ln.Addr())
+
+	return ninepserver.New(fs).Serve(gopts.ctx, ln)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/s3server"
+	"github.com/restic/restic/internal/webdav"
+)
+
+var cmdServeS3 = &cobra.Command{
+	Use:   "s3 [flags] snapshotID",
+	Short: "Serve the files of a snapshot read-only via the S3 API",
+	Long: `
+The "serve s3" command serves the files of a snapshot as the objects of a
+bucket via a minimal, read-only subset of the S3 API, so that programs which
+can only read from S3 can process the files without restoring them first.
+Objects can be listed and downloaded, the key of an object is the path of the
+file within the snapshot without the leading slash.
+
+The special snapshot "latest" can be used to serve the latest snapshot in the
+repository.
+
+By default, the server only listens on the local host and requests are not
+authenticated. To serve to other hosts, set the access key with --auth-user
+and the secret key with --auth-password-file or the environment variable
+RESTIC_S3_SERVER_PASSWORD. Clients must then sign their requests with these
+credentials (AWS Signature Version 4). The server refuses to listen on other
+addresses without authentication. Use TLS with --tls-cert and --tls-key, as
+everyone who can connect can read all files in the snapshot.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeS3(serveS3Options, globalOptions, args)
+	},
+}
+
+// ServeS3Options collects all options for the serve s3 command.
+type ServeS3Options struct {
+	Listen           string
+	Bucket           string
+	AuthUser         string
+	AuthPasswordFile string
+	TLSCert          string
+	TLSKey           string
+	Hosts            []string
+	Tags             restic.TagLists
+	Paths            []string
+}
+
+var serveS3Options ServeS3Options
+
+func init() {
+	cmdServe.AddCommand(cmdServeS3)
+
+	f := cmdServeS3.Flags()
+	f.StringVar(&serveS3Options.Listen, "listen", "localhost:9000", "listen on this `address`")
+	f.StringVar(&serveS3Options.Bucket, "bucket", "restic", "serve the snapshot as the bucket with this `name`")
+	f.StringVar(&serveS3Options.AuthUser, "auth-user", "", "require requests to be signed with this access `key`")
+	f.StringVar(&serveS3Options.AuthPasswordFile, "auth-password-file", "", "read the secret key for --auth-user from `file` (default: $RESTIC_S3_SERVER_PASSWORD)")
+	f.StringVar(&serveS3Options.TLSCert, "tls-cert", "", "serve via HTTPS with the certificate in `file` (PEM)")
+	f.StringVar(&serveS3Options.TLSKey, "tls-key", "", "private key for --tls-cert in `file` (PEM)")
+
+	f.StringArrayVarP(&serveS3Options.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
+	f.Var(&serveS3Options.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
+	f.StringArrayVar(&serveS3Options.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+}
+
+func runServeS3(opts ServeS3Options, gopts GlobalOptions, args []string) error {
+	if len(args) != 1 {
+		return errors.Fatal("the serve s3 command expects a snapshot ID")
+	}
+
+	if opts.Bucket == "" || strings.ContainsAny(opts.Bucket, "/") {
+		return errors.Fatal("bucket name is empty or contains a slash (/)")
+	}
+
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be given together")
+	}
+
+	cfg := s3server.Config{
+		Bucket:    opts.Bucket,
+		AccessKey: opts.AuthUser,
+	}
+
+	if opts.AuthUser != "" {
+		secret, err := loadServePassword(opts.AuthPasswordFile, "RESTIC_S3_SERVER_PASSWORD")
+		if err != nil {
+			return err
+		}
+		cfg.SecretKey = secret
+	} else if !isLoopbackListen(opts.Listen) {
+		return errors.Fatalf("refusing to serve without authentication on %v, use --auth-user or listen on the local host", opts.Listen)
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	var id restic.ID
+	if args[0] == "latest" {
+		id, err = restic.FindLatestSnapshot(gopts.ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+		if err != nil {
+			return errors.Fatalf("latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
+		}
+	} else {
+		id, err = restic.FindSnapshot(repo, args[0])
+		if err != nil {
+			return errors.Fatalf("invalid id %q: %v", args[0], err)
+		}
+	}
+
+	// the snapshots are served by their ID, only the one is used
+	fs := webdav.NewFileSystem(repo, webdav.Config{PathTemplates: []string{"%I"}})
+	cfg.Root = "/" + id.String()
+
+	Verbosef("serving snapshot %v as bucket %v\n", id.Str(), opts.Bucket)
+	return serveHTTP(s3server.NewHandler(fs, cfg), opts.Listen, opts.TLSCert, opts.TLSKey, cfg.AccessKey != "")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/sftpserver"
+	"github.com/restic/restic/internal/webdav"
+)
+
+var cmdServeSFTP = &cobra.Command{
+	Use:   "sftp [flags]",
+	Short: "Serve the snapshots read-only via SFTP",
+	Long: `
+The "serve sftp" command serves the snapshots in the repository read-only via
+SFTP, so that files can be recovered on hosts which only have an SFTP or SCP
+client, e.g. "sftp -P 2022 localhost". The snapshots are organized in the same
+directories as for "restic mount", the templates for the directories can be
+set with --path-template. Each directory which contains snapshots also
+contains a directory "latest" with the newest snapshot.
+
+The server identifies itself with the private key in --host-key. Without it, a
+new key is generated each time the server is started, and its fingerprint is
+printed so that it can be compared to the one shown by the client.
+
+Clients can log in with the user set with --auth-user and the password from
+--auth-password-file or the environment variable RESTIC_SFTP_PASSWORD, or
+with any user and one of the keys in the file given with --authorized-keys.
+By default, the server only listens on the local host.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeSFTP(serveSFTPOptions, globalOptions, args)
+	},
+}
+
+// ServeSFTPOptions collects all options for the serve sftp command.
+type ServeSFTPOptions struct {
+	Listen           string
+	HostKey          string
+	AuthUser         string
+	AuthPasswordFile string
+	AuthorizedKeys   string
+	Hosts            []string
+	Tags             restic.TagLists
+	Paths            []string
+	SnapshotTemplate string
+	PathTemplates    []string
+}
+
+var serveSFTPOptions ServeSFTPOptions
+
+func init() {
+	cmdServe.AddCommand(cmdServeSFTP)
+
+	f := cmdServeSFTP.Flags()
+	f.StringVar(&serveSFTPOptions.Listen, "listen", "localhost:2022", "listen on this `address`")
+	f.StringVar(&serveSFTPOptions.HostKey, "host-key", "", "identify the server with the private key in `file` (default: generate a new key)")
+	f.StringVar(&serveSFTPOptions.AuthUser, "auth-user", "", "allow logging in as `user` with a password")
+	f.StringVar(&serveSFTPOptions.AuthPasswordFile, "auth-password-file", "", "read the password for --auth-user from `file` (default: $RESTIC_SFTP_PASSWORD)")
+	f.StringVar(&serveSFTPOptions.AuthorizedKeys, "authorized-keys", "", "allow logging in with the public keys in `file` (OpenSSH authorized_keys format)")
+
+	f.StringArrayVarP(&serveSFTPOptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
+	f.Var(&serveSFTPOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	f.StringArrayVar(&serveSFTPOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	f.StringVar(&serveSFTPOptions.SnapshotTemplate, "snapshot-template", "2006-01-02T15-04-05Z07-00", "set `template` to use for snapshot dirs")
+	f.StringArrayVar(&serveSFTPOptions.PathTemplates, "path-template", nil, "set `template` for the directories which contain snapshots, e.g. \"hosts/%h/%T\" (can be specified multiple times, default: ids, snapshots, hosts, tags and paths)")
+}
+
+// loadHostKey returns the private key in file, or a new key if file is empty.
+func loadHostKey(file string) (ssh.Signer, error) {
+	if file == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "GenerateKey")
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read host key: %v", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(buf)
+	if err != nil {
+		return nil, errors.Fatalf("unable to parse host key %v: %v", file, err)
+	}
+	return signer, nil
+}
+
+// loadAuthorizedKeys returns the public keys in file.
+func loadAuthorizedKeys(file string) ([]ssh.PublicKey, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read authorized keys: %v", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(strings.TrimSpace(string(buf))) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(buf)
+		if err != nil {
+			return nil, errors.Fatalf("unable to parse authorized keys in %v: %v", file, err)
+		}
+		keys = append(keys, key)
+		buf = rest
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.Fatalf("no keys found in %v", file)
+	}
+	return keys, nil
+}
+
+func runServeSFTP(opts ServeSFTPOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the serve sftp command expects no arguments")
+	}
+
+	if opts.SnapshotTemplate == "" || strings.ContainsAny(opts.SnapshotTemplate, `\/`) {
+		return errors.Fatal("snapshot template string is empty or contains a slash (/) or backslash (\\) character")
+	}
+
+	for _, template := range opts.PathTemplates {
+		if err := fuse.CheckPathTemplate(template); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	var cfg sftpserver.Config
+	var err error
+
+	cfg.HostKey, err = loadHostKey(opts.HostKey)
+	if err != nil {
+		return err
+	}
+
+	if opts.AuthUser != "" {
+		cfg.Username = opts.AuthUser
+		cfg.Password, err = loadServePassword(opts.AuthPasswordFile, "RESTIC_SFTP_PASSWORD")
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.AuthorizedKeys != "" {
+		cfg.AuthorizedKeys, err = loadAuthorizedKeys(opts.AuthorizedKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	fs := webdav.NewFileSystem(repo, webdav.Config{
+		Hosts:            opts.Hosts,
+		Tags:             opts.Tags,
+		Paths:            opts.Paths,
+		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+	})
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return errors.Fatalf("unable to listen on %v: %v", opts.Listen, err)
+	}
+
+	if cfg.Username == "" && len(cfg.AuthorizedKeys) == 0 && !isLoopback(ln.Addr()) {
+		Warnf("warning: serving without authentication on %v, everyone who can connect can read the snapshots\n", ln.Addr())
+	}
+
+	Printf("Now serving the repository at sftp://%v/\n", ln.Addr())
+	Printf("Host key fingerprint: %v\n", ssh.FingerprintSHA256(cfg.HostKey.PublicKey()))
+	Printf("When finished, quit with Ctrl-c.\n")
+	debug.Log("serving SFTP at %v", ln.Addr())
+
+	return sftpserver.New(fs, cfg).Serve(gopts.ctx, ln)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestIsLoopbackListen(t *testing.T) {
+	var tests = []struct {
+		listen   string
+		loopback bool
+	}{
+		{"localhost:9000", true},
+		{"127.0.0.1:9000", true},
+		{"[::1]:9000", true},
+		{":9000", false},
+		{"0.0.0.0:9000", false},
+		{"192.168.1.1:9000", false},
+		{"invalid", false},
+	}
+
+	for _, test := range tests {
+		if got := isLoopbackListen(test.listen); got != test.loopback {
+			t.Errorf("isLoopbackListen(%q) = %v, want %v", test.listen, got, test.loopback)
+		}
+	}
+}
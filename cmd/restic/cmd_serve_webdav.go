@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/webdav"
+)
+
+var cmdServeWebDAV = &cobra.Command{
+	Use:   "webdav [flags]",
+	Short: "Serve the snapshots read-only via WebDAV",
+	Long: `
+The "serve webdav" command serves the snapshots in the repository read-only via
+WebDAV, so that they can be browsed with the file manager of any operating
+system, or with a web browser. The snapshots are organized in the same
+directories as for "restic mount", the templates for the directories can be
+set with --path-template. Each directory which contains snapshots also
+contains a directory "latest" with the newest snapshot.
+
+By default, the server only listens on the local host. When it is reachable
+from other hosts, set a user with --auth-user and the password with
+--auth-password-file or the environment variable RESTIC_WEBDAV_PASSWORD, and
+use TLS with --tls-cert and --tls-key, as everyone who can connect can read all
+files in the snapshots.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeWebDAV(serveWebDAVOptions, globalOptions, args)
+	},
+}
+
+// ServeWebDAVOptions collects all options for the serve webdav command.
+type ServeWebDAVOptions struct {
+	Listen           string
+	AuthUser         string
+	AuthPasswordFile string
+	TLSCert          string
+	TLSKey           string
+	Hosts            []string
+	Tags             restic.TagLists
+	Paths            []string
+	SnapshotTemplate string
+	PathTemplates    []string
+}
+
+var serveWebDAVOptions ServeWebDAVOptions
+
+func init() {
+	cmdServe.AddCommand(cmdServeWebDAV)
+
+	f := cmdServeWebDAV.Flags()
+	f.StringVar(&serveWebDAVOptions.Listen, "listen", "localhost:8080", "listen on this `address`")
+	f.StringVar(&serveWebDAVOptions.AuthUser, "auth-user", "", "require HTTP basic auth with this `user`")
+	f.StringVar(&serveWebDAVOptions.AuthPasswordFile, "auth-password-file", "", "read the password for --auth-user from `file` (default: $RESTIC_WEBDAV_PASSWORD)")
+	f.StringVar(&serveWebDAVOptions.TLSCert, "tls-cert", "", "serve via HTTPS with the certificate in `file` (PEM)")
+	f.StringVar(&serveWebDAVOptions.TLSKey, "tls-key", "", "private key for --tls-cert in `file` (PEM)")
+
+	f.StringArrayVarP(&serveWebDAVOptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
+	f.Var(&serveWebDAVOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	f.StringArrayVar(&serveWebDAVOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	f.StringVar(&serveWebDAVOptions.SnapshotTemplate, "snapshot-template", "2006-01-02T15-04-05Z07-00", "set `template` to use for snapshot dirs")
+	f.StringArrayVar(&serveWebDAVOptions.PathTemplates, "path-template", nil, "set `template` for the directories which contain snapshots, e.g. \"hosts/%h/%T\" (can be specified multiple times, default: ids, snapshots, hosts, tags and paths)")
+}
+
+func runServeWebDAV(opts ServeWebDAVOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the serve webdav command expects no arguments")
+	}
+
+	if opts.SnapshotTemplate == "" || strings.ContainsAny(opts.SnapshotTemplate, `\/`) {
+		return errors.Fatal("snapshot template string is empty or contains a slash (/) or backslash (\\) character")
+	}
+
+	for _, template := range opts.PathTemplates {
+		if err := fuse.CheckPathTemplate(template); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be given together")
+	}
+
+	cfg := webdav.Config{
+		Hosts:            opts.Hosts,
+		Tags:             opts.Tags,
+		Paths:            opts.Paths,
+		SnapshotTemplate: opts.SnapshotTemplate,
+		PathTemplates:    opts.PathTemplates,
+		Username:         opts.AuthUser,
+	}
+
+	if opts.AuthUser != "" {
+		pw, err := loadServePassword(opts.AuthPasswordFile, "RESTIC_WEBDAV_PASSWORD")
+		if err != nil {
+			return err
+		}
+		cfg.Password = pw
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	return serveHTTP(webdav.NewHandler(repo, cfg), opts.Listen, opts.TLSCert, opts.TLSKey, cfg.Username != "")
+}
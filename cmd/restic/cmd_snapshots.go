@@ -96,7 +96,8 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 		return nil
 	}
 
-	for k, list := range snapshotGroups {
+	for _, k := range restic.SortedGroupKeys(snapshotGroups) {
+		list := snapshotGroups[k]
 		if grouped {
 			err := PrintSnapshotGroupHeader(gopts.stdout, k)
 			if err != nil {
@@ -147,14 +148,14 @@ func FilterLastSnapshots(list restic.Snapshots) restic.Snapshots {
 }
 
 // PrintSnapshots prints a text table of the snapshots in list to stdout.
-func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.KeepReason, compact bool) {
-	// keep the reasons a snasphot is being kept in a map, so that it doesn't
-	// get lost when the list of snapshots is sorted
-	keepReasons := make(map[restic.ID]restic.KeepReason, len(reasons))
+func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons [][]string, compact bool) {
+	// keep the reasons a snasphot is being kept or removed in a map, so that
+	// it doesn't get lost when the list of snapshots is sorted
+	snapshotReasons := make(map[restic.ID][]string, len(reasons))
 	if len(reasons) > 0 {
 		for i, sn := range list {
 			id := sn.ID()
-			keepReasons[*id] = reasons[i]
+			snapshotReasons[*id] = reasons[i]
 		}
 	}
 
@@ -215,7 +216,7 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 
 		if len(reasons) > 0 {
 			id := sn.ID()
-			data.Reasons = keepReasons[*id].Matches
+			data.Reasons = snapshotReasons[*id]
 		}
 
 		if len(sn.Paths) > 1 && !compact {
@@ -301,7 +302,8 @@ func printSnapshotGroupJSON(stdout io.Writer, snGroups map[string]restic.Snapsho
 	if grouped {
 		var snapshotGroups []SnapshotGroup
 
-		for k, list := range snGroups {
+		for _, k := range restic.SortedGroupKeys(snGroups) {
+			list := snGroups[k]
 			var key restic.SnapshotGroupKey
 			var err error
 			var snapshots []Snapshot
@@ -5,28 +5,28 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
 	"github.com/spf13/cobra"
 )
 
 var cmdStats = &cobra.Command{
-	Use:   "stats [flags] [snapshot-ID]",
+	Use:   "stats [flags] [snapshot-ID ...]",
 	Short: "Scan the repository and show basic statistics",
 	Long: `
-The "stats" command walks one or all snapshots in a repository and
+The "stats" command walks one or multiple snapshots in a repository and
 accumulates statistics about the data stored therein. It reports on
 the number of unique files and their sizes, according to one of
 the counting modes as given by the --mode flag.
 
-If no snapshot is specified, all snapshots will be considered. Some
-modes make more sense over just a single snapshot, while others
-are useful across all snapshots, depending on what you are trying
-to calculate.
+If no snapshot is specified, all snapshots matching the --host, --tag and
+--path filters will be considered. Some modes make more sense over just a
+single snapshot, while others are useful across all snapshots, depending on
+what you are trying to calculate. With --per-snapshot, the statistics are
+also shown for each snapshot on its own.
 
 The modes are:
 
@@ -46,19 +46,33 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runStats(globalOptions, args)
+		return runStats(statsOptions, globalOptions, args)
 	},
 }
 
+// StatsOptions collects all options for the stats command.
+type StatsOptions struct {
+	Mode        string
+	Hosts       []string
+	Tags        restic.TagLists
+	Paths       []string
+	PerSnapshot bool
+}
+
+var statsOptions StatsOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdStats)
 	f := cmdStats.Flags()
-	f.StringVar(&countMode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file, or raw-data")
-	f.StringArrayVarP(&snapshotByHosts, "host", "H", nil, "filter latest snapshot by this hostname (can be specified multiple times)")
+	f.StringVar(&statsOptions.Mode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file, or raw-data")
+	f.StringArrayVarP(&statsOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host` (can be specified multiple times)")
+	f.Var(&statsOptions.Tags, "tag", "only consider snapshots which include this `taglist` (can be specified multiple times)")
+	f.StringArrayVar(&statsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
+	f.BoolVar(&statsOptions.PerSnapshot, "per-snapshot", false, "also show the statistics for each snapshot")
 }
 
-func runStats(gopts GlobalOptions, args []string) error {
-	err := verifyStatsInput(gopts, args)
+func runStats(opts StatsOptions, gopts GlobalOptions, args []string) error {
+	err := verifyStatsInput(opts)
 	if err != nil {
 		return err
 	}
@@ -88,67 +102,46 @@ func runStats(gopts GlobalOptions, args []string) error {
 	}
 
 	// create a container for the stats (and other needed state)
-	stats := &statsContainer{
-		uniqueFiles:  make(map[fileID]struct{}),
-		uniqueInodes: make(map[uint64]struct{}),
-		fileBlobs:    make(map[string]restic.IDSet),
-		blobs:        restic.NewBlobSet(),
-		blobsSeen:    restic.NewBlobSet(),
-	}
+	stats := newStatsContainer(opts.Mode)
 
-	if snapshotIDString != "" {
-		// scan just a single snapshot
-
-		var sID restic.ID
-		if snapshotIDString == "latest" {
-			sID, err = restic.FindLatestSnapshot(ctx, repo, []string{}, []restic.TagList{}, snapshotByHosts)
-			if err != nil {
-				return errors.Fatalf("latest snapshot for criteria not found: %v", err)
-			}
-		} else {
-			sID, err = restic.FindSnapshot(repo, snapshotIDString)
+	var snapshotStats []statsSnapshot
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		if !opts.PerSnapshot {
+			err = statsWalkSnapshot(ctx, sn, repo, stats)
 			if err != nil {
-				return errors.Fatalf("error loading snapshot: %v", err)
+				return fmt.Errorf("error walking snapshot: %v", err)
 			}
+			continue
 		}
 
-		snapshot, err := restic.LoadSnapshot(ctx, repo, sID)
+		// collect the stats for this snapshot separately, the shared state
+		// for the total is updated by the same walk
+		snStats := newStatsContainer(opts.Mode)
+		err = statsWalkSnapshot(ctx, sn, repo, snStats, stats)
 		if err != nil {
-			return errors.Fatalf("error loading snapshot from repo: %v", err)
+			return fmt.Errorf("error walking snapshot: %v", err)
 		}
 
-		err = statsWalkSnapshot(ctx, snapshot, repo, stats)
-		if err != nil {
-			return fmt.Errorf("error walking snapshot: %v", err)
+		if err = snStats.countBlobs(repo); err != nil {
+			return err
 		}
-	} else {
-		// iterate every snapshot in the repo
-		err = repo.List(ctx, restic.SnapshotFile, func(snapshotID restic.ID, size int64) error {
-			snapshot, err := restic.LoadSnapshot(ctx, repo, snapshotID)
-			if err != nil {
-				return fmt.Errorf("Error loading snapshot %s: %v", snapshotID.Str(), err)
-			}
-			return statsWalkSnapshot(ctx, snapshot, repo, stats)
-		})
+		snapshotStats = append(snapshotStats, newStatsSnapshot(sn, snStats))
 	}
-	if err != nil {
+
+	if err = stats.countBlobs(repo); err != nil {
 		return err
 	}
-
-	if countMode == countModeRawData {
-		// the blob handles have been collected, but not yet counted
-		for blobHandle := range stats.blobs {
-			blobSize, found := repo.LookupBlobSize(blobHandle.ID, blobHandle.Type)
-			if !found {
-				return fmt.Errorf("blob %v not found", blobHandle)
-			}
-			stats.TotalSize += uint64(blobSize)
-			stats.TotalBlobCount++
-		}
+	if err = stats.countDuplicates(ctx, repo); err != nil {
+		return err
 	}
 
 	if gopts.JSON {
-		err = json.NewEncoder(os.Stdout).Encode(stats)
+		var v interface{} = stats
+		if opts.PerSnapshot {
+			v = statsPerSnapshot{Snapshots: snapshotStats, Total: stats}
+		}
+
+		err = json.NewEncoder(gopts.stdout).Encode(v)
 		if err != nil {
 			return fmt.Errorf("encoding output: %v", err)
 		}
@@ -156,37 +149,80 @@ func runStats(gopts GlobalOptions, args []string) error {
 	}
 
 	// inform the user what was scanned and how it was scanned
-	snapshotsScanned := snapshotIDString
-	if snapshotsScanned == "latest" {
-		snapshotsScanned = "the latest snapshot"
-	} else if snapshotsScanned == "" {
+	var snapshotsScanned string
+	switch {
+	case len(args) == 0:
 		snapshotsScanned = "all snapshots"
+	case len(args) == 1 && args[0] == "latest":
+		snapshotsScanned = "the latest snapshot"
+	case len(args) == 1:
+		snapshotsScanned = args[0]
+	default:
+		snapshotsScanned = fmt.Sprintf("%d snapshots", len(args))
 	}
-	Printf("Stats for %s in %s mode:\n", snapshotsScanned, countMode)
+	Printf("Stats for %s in %s mode:\n", snapshotsScanned, opts.Mode)
 
-	if stats.TotalBlobCount > 0 {
-		Printf("  Total Blob Count:   %d\n", stats.TotalBlobCount)
+	for _, sn := range snapshotStats {
+		Printf("\nSnapshot %s of %v at %s:\n", sn.ShortID, sn.Paths, sn.Time.Local().Format(TimeFormat))
+		printStats(sn.TotalBlobCount, sn.TotalFileCount, sn.TotalSize)
 	}
-	if stats.TotalFileCount > 0 {
-		Printf("  Total File Count:   %d\n", stats.TotalFileCount)
+
+	if opts.PerSnapshot {
+		Printf("\nTotal:\n")
+	}
+	printStats(stats.TotalBlobCount, stats.TotalFileCount, stats.TotalSize)
+	if stats.DuplicateBlobCount > 0 {
+		Printf("   Duplicate Blobs:   %d\n", stats.DuplicateBlobCount)
+		Printf("    Duplicate Size:   %-5s\n", formatBytes(stats.DuplicateSize))
 	}
-	Printf("        Total Size:   %-5s\n", formatBytes(stats.TotalSize))
 
 	return nil
 }
 
-func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo restic.Repository, stats *statsContainer) error {
+func printStats(blobCount, fileCount, size uint64) {
+	if blobCount > 0 {
+		Printf("  Total Blob Count:   %d\n", blobCount)
+	}
+	if fileCount > 0 {
+		Printf("  Total File Count:   %d\n", fileCount)
+	}
+	Printf("        Total Size:   %-5s\n", formatBytes(size))
+}
+
+// statsWalkSnapshot adds the data in snapshot to all containers in stats.
+func statsWalkSnapshot(ctx context.Context, snapshot *restic.Snapshot, repo restic.Repository, stats ...*statsContainer) error {
 	if snapshot.Tree == nil {
 		return fmt.Errorf("snapshot %s has nil tree", snapshot.ID().Str())
 	}
 
-	if countMode == countModeRawData {
+	if stats[0].mode == countModeRawData {
 		// count just the sizes of unique blobs; we don't need to walk the tree
 		// ourselves in this case, since a nifty function does it for us
-		return restic.FindUsedBlobs(ctx, repo, *snapshot.Tree, stats.blobs, stats.blobsSeen)
+		err := restic.FindUsedBlobs(ctx, repo, *snapshot.Tree, stats[0].blobs, stats[0].blobsSeen)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats[1:] {
+			s.blobs.Merge(stats[0].blobs)
+		}
+		return nil
 	}
 
-	err := walker.Walk(ctx, repo, *snapshot.Tree, restic.NewIDSet(), statsWalkTree(repo, stats))
+	walkFns := make([]walker.WalkFunc, 0, len(stats))
+	for _, s := range stats {
+		walkFns = append(walkFns, statsWalkTree(repo, s))
+	}
+
+	// all containers use the same mode, so they all ignore the same nodes
+	err := walker.Walk(ctx, repo, *snapshot.Tree, restic.NewIDSet(), func(parentTreeID restic.ID, npath string, node *restic.Node, nodeErr error) (ignore bool, err error) {
+		for _, walkFn := range walkFns {
+			ignore, err = walkFn(parentTreeID, npath, node, nodeErr)
+			if err != nil {
+				return ignore, err
+			}
+		}
+		return ignore, nil
+	})
 	if err != nil {
 		return fmt.Errorf("walking tree %s: %v", *snapshot.Tree, err)
 	}
@@ -202,19 +238,19 @@ func statsWalkTree(repo restic.Repository, stats *statsContainer) walker.WalkFun
 			return true, nil
 		}
 
-		if countMode == countModeUniqueFilesByContents || countMode == countModeBlobsPerFile {
+		if stats.mode == countModeUniqueFilesByContents || stats.mode == countModeBlobsPerFile {
 			// only count this file if we haven't visited it before
 			fid := makeFileIDByContents(node)
 			if _, ok := stats.uniqueFiles[fid]; !ok {
 				// mark the file as visited
 				stats.uniqueFiles[fid] = struct{}{}
 
-				if countMode == countModeUniqueFilesByContents {
+				if stats.mode == countModeUniqueFilesByContents {
 					// simply count the size of each unique file (unique by contents only)
 					stats.TotalSize += node.Size
 					stats.TotalFileCount++
 				}
-				if countMode == countModeBlobsPerFile {
+				if stats.mode == countModeBlobsPerFile {
 					// count the size of each unique blob reference, which is
 					// by unique file (unique by contents and file path)
 					for _, blobID := range node.Content {
@@ -244,7 +280,7 @@ func statsWalkTree(repo restic.Repository, stats *statsContainer) walker.WalkFun
 			}
 		}
 
-		if countMode == countModeRestoreSize {
+		if stats.mode == countModeRestoreSize {
 			// as this is a file in the snapshot, we can simply count its
 			// size without worrying about uniqueness, since duplicate files
 			// will still be restored
@@ -274,25 +310,15 @@ func makeFileIDByContents(node *restic.Node) fileID {
 	return sha256.Sum256(bb)
 }
 
-func verifyStatsInput(gopts GlobalOptions, args []string) error {
+func verifyStatsInput(opts StatsOptions) error {
 	// require a recognized counting mode
-	switch countMode {
+	switch opts.Mode {
 	case countModeRestoreSize:
 	case countModeUniqueFilesByContents:
 	case countModeBlobsPerFile:
 	case countModeRawData:
 	default:
-		return fmt.Errorf("unknown counting mode: %s (use the -h flag to get a list of supported modes)", countMode)
-	}
-
-	// ensure at most one snapshot was specified
-	if len(args) > 1 {
-		return fmt.Errorf("only one snapshot may be specified")
-	}
-
-	// if a snapshot was specified, mark it as the one to scan
-	if len(args) == 1 {
-		snapshotIDString = args[0]
+		return fmt.Errorf("unknown counting mode: %s (use the -h flag to get a list of supported modes)", opts.Mode)
 	}
 
 	return nil
@@ -306,6 +332,11 @@ type statsContainer struct {
 	TotalFileCount uint64 `json:"total_file_count"`
 	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
 
+	// in raw-data mode, additional copies of blobs which are stored in more
+	// than one pack file
+	DuplicateBlobCount uint64 `json:"duplicate_blob_count,omitempty"`
+	DuplicateSize      uint64 `json:"duplicate_size,omitempty"`
+
 	// uniqueFiles marks visited files according to their
 	// contents (hashed sequence of content blob IDs)
 	uniqueFiles map[fileID]struct{}
@@ -321,22 +352,113 @@ type statsContainer struct {
 	// blobs and blobsSeen are used to count individual
 	// unique blobs, independent of references to files
 	blobs, blobsSeen restic.BlobSet
+
+	// mode is the counting mode
+	mode string
 }
 
-// fileID is a 256-bit hash that distinguishes unique files.
-type fileID [32]byte
+func newStatsContainer(mode string) *statsContainer {
+	return &statsContainer{
+		uniqueFiles:  make(map[fileID]struct{}),
+		uniqueInodes: make(map[uint64]struct{}),
+		fileBlobs:    make(map[string]restic.IDSet),
+		blobs:        restic.NewBlobSet(),
+		blobsSeen:    restic.NewBlobSet(),
+		mode:         mode,
+	}
+}
 
-var (
-	// the mode of counting to perform
-	countMode string
+// countBlobs counts the blobs collected in raw-data mode.
+func (s *statsContainer) countBlobs(repo restic.Repository) error {
+	if s.mode != countModeRawData {
+		return nil
+	}
 
-	// the snapshot to scan, as given by the user
-	snapshotIDString string
+	for blobHandle := range s.blobs {
+		blobSize, found := repo.LookupBlobSize(blobHandle.ID, blobHandle.Type)
+		if !found {
+			return fmt.Errorf("blob %v not found", blobHandle)
+		}
+		s.TotalSize += uint64(blobSize)
+		s.TotalBlobCount++
+	}
+	return nil
+}
 
-	// snapshotByHost is the host to filter latest
-	// snapshot by, if given by user
-	snapshotByHosts []string
-)
+// countDuplicates counts the additional copies of the blobs collected in
+// raw-data mode.
+func (s *statsContainer) countDuplicates(ctx context.Context, repo restic.Repository) error {
+	if s.mode != countModeRawData {
+		return nil
+	}
+
+	// the first pack found for each blob holds the copy which is counted, the
+	// same pack may be listed in several index files
+	type packedBlob struct {
+		restic.BlobHandle
+		pack restic.ID
+	}
+	firstPack := make(map[restic.BlobHandle]restic.ID)
+	duplicates := make(map[packedBlob]struct{})
+	for pb := range repo.Index().Each(ctx) {
+		h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+		if !s.blobs.Has(h) {
+			continue
+		}
+
+		pack, ok := firstPack[h]
+		if !ok {
+			firstPack[h] = pb.PackID
+			continue
+		}
+		if pack == pb.PackID {
+			continue
+		}
+
+		if _, ok := duplicates[packedBlob{h, pb.PackID}]; ok {
+			continue
+		}
+		duplicates[packedBlob{h, pb.PackID}] = struct{}{}
+		s.DuplicateBlobCount++
+		s.DuplicateSize += uint64(pb.Length)
+	}
+
+	return ctx.Err()
+}
+
+// statsSnapshot holds the stats of a single snapshot for --per-snapshot.
+type statsSnapshot struct {
+	ID             *restic.ID `json:"id"`
+	ShortID        string     `json:"short_id"`
+	Time           time.Time  `json:"time"`
+	Hostname       string     `json:"hostname,omitempty"`
+	Paths          []string   `json:"paths"`
+	TotalSize      uint64     `json:"total_size"`
+	TotalFileCount uint64     `json:"total_file_count"`
+	TotalBlobCount uint64     `json:"total_blob_count,omitempty"`
+}
+
+func newStatsSnapshot(sn *restic.Snapshot, stats *statsContainer) statsSnapshot {
+	return statsSnapshot{
+		ID:             sn.ID(),
+		ShortID:        sn.ID().Str(),
+		Time:           sn.Time,
+		Hostname:       sn.Hostname,
+		Paths:          sn.Paths,
+		TotalSize:      stats.TotalSize,
+		TotalFileCount: stats.TotalFileCount,
+		TotalBlobCount: stats.TotalBlobCount,
+	}
+}
+
+// statsPerSnapshot is printed as JSON for --per-snapshot.
+type statsPerSnapshot struct {
+	Snapshots []statsSnapshot `json:"snapshots"`
+	Total     *statsContainer `json:"total"`
+}
+
+// fileID is a 256-bit hash that distinguishes unique files.
+type fileID [32]byte
 
 const (
 	countModeRestoreSize           = "restore-size"
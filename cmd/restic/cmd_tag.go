@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/spf13/cobra"
 
@@ -22,6 +23,10 @@ add tags to/remove tags from the existing set.
 
 When no snapshot-ID is given, all snapshots matching the host, tag and path filter criteria are modified.
 
+With --protect, snapshots are protected against removal: "forget" always keeps
+them, so their data is not removed by "prune" either. The protection is removed
+again with --unprotect.
+
 EXIT STATUS
 ===========
 
@@ -41,6 +46,8 @@ type TagOptions struct {
 	SetTags    []string
 	AddTags    []string
 	RemoveTags []string
+	Protect    bool
+	Unprotect  bool
 }
 
 var tagOptions TagOptions
@@ -52,16 +59,19 @@ func init() {
 	tagFlags.StringSliceVar(&tagOptions.SetTags, "set", nil, "`tag` which will replace the existing tags (can be given multiple times)")
 	tagFlags.StringSliceVar(&tagOptions.AddTags, "add", nil, "`tag` which will be added to the existing tags (can be given multiple times)")
 	tagFlags.StringSliceVar(&tagOptions.RemoveTags, "remove", nil, "`tag` which will be removed from the existing tags (can be given multiple times)")
+	tagFlags.BoolVar(&tagOptions.Protect, "protect", false, "protect the snapshots against removal by forget")
+	tagFlags.BoolVar(&tagOptions.Unprotect, "unprotect", false, "remove the protection against removal by forget")
 
 	tagFlags.StringArrayVarP(&tagOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	tagFlags.Var(&tagOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
 }
 
-func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts TagOptions) (bool, error) {
 	var changed bool
 
-	if len(setTags) != 0 {
+	if len(opts.SetTags) != 0 {
+		setTags := opts.SetTags
 		// Setting the tag to an empty string really means no tags.
 		if len(setTags) == 1 && setTags[0] == "" {
 			setTags = nil
@@ -69,12 +79,17 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 		sn.Tags = setTags
 		changed = true
 	} else {
-		changed = sn.AddTags(addTags)
-		if sn.RemoveTags(removeTags) {
+		changed = sn.AddTags(opts.AddTags)
+		if sn.RemoveTags(opts.RemoveTags) {
 			changed = true
 		}
 	}
 
+	if (opts.Protect && !sn.Protected) || (opts.Unprotect && sn.Protected) {
+		sn.Protected = opts.Protect
+		changed = true
+	}
+
 	if changed {
 		// Retain the original snapshot id over all tag changes.
 		if sn.Original == nil {
@@ -93,6 +108,8 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 			return false, err
 		}
 
+		auditLog(ctx, repo, "tag", restic.IDs{*sn.ID()}, fmt.Sprintf("replace snapshot %v by %v", sn.ID().Str(), id.Str()))
+
 		// Remove the old snapshot.
 		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 		if err = repo.Backend().Remove(ctx, h); err != nil {
@@ -105,9 +122,12 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 }
 
 func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
-	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 {
+	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 && !opts.Protect && !opts.Unprotect {
 		return errors.Fatal("nothing to do!")
 	}
+	if opts.Protect && opts.Unprotect {
+		return errors.Fatal("--protect and --unprotect cannot be given at the same time")
+	}
 	if len(opts.SetTags) != 0 && (len(opts.AddTags) != 0 || len(opts.RemoveTags) != 0) {
 		return errors.Fatal("--set and --add/--remove cannot be given at the same time")
 	}
@@ -117,6 +137,10 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = requireAdminKey(gopts, repo, "tag"); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(repo)
@@ -130,7 +154,7 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
-		changed, err := changeTags(ctx, repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
+		changed, err := changeTags(ctx, repo, sn, opts)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
 			continue
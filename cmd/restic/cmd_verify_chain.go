@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdVerifyChain = &cobra.Command{
+	Use:   "verify-chain [flags]",
+	Short: "Check that no snapshots were removed unnoticed",
+	Long: `
+The "verify-chain" command checks that no snapshots were removed from the
+repository without being recorded. Each new snapshot contains the ID of the
+newest snapshot of the same host and paths at the time it was created, so the
+snapshots form chains. A snapshot is reported if the previous snapshot in its
+chain is missing, and the removal is not recorded in the audit log (see
+"restic audit log").
+
+Snapshots are encrypted and authenticated with the master key, so someone with
+access to the storage but without a key cannot create or modify snapshots
+unnoticed. Such an attacker can only delete snapshots, which this command
+detects unless the newest snapshot of a chain was removed.
+
+Anyone with a key for the repository can create snapshots and audit log
+entries. If they were signed with the global option --sign-command, the
+signatures are verified with the shell command given by --verify-command. It
+reads the signed data from stdin, gets the signature in the environment
+variable RESTIC_SIGNATURE and must exit with status zero only if the signature
+is valid. Snapshots and audit log entries without a valid signature are then
+reported, and such entries do not account for removed snapshots. Snapshots
+created before signing was enabled are reported as well.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVerifyChain(verifyChainOptions, globalOptions)
+	},
+}
+
+// VerifyChainOptions collects all options for the verify-chain command.
+type VerifyChainOptions struct {
+	VerifyCommand string
+}
+
+var verifyChainOptions VerifyChainOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdVerifyChain)
+
+	f := cmdVerifyChain.Flags()
+	f.StringVar(&verifyChainOptions.VerifyCommand, "verify-command", os.Getenv("RESTIC_VERIFY_COMMAND"), "verify the signatures of snapshots and audit log entries with a shell `command` (default: $RESTIC_VERIFY_COMMAND)")
+}
+
+// verifySignatures returns the number of snapshots and audit entries without
+// a valid signature, which are printed as warnings. Entries with a valid
+// signature are returned.
+func verifySignatures(ctx context.Context, command string, snapshots restic.Snapshots, entries []*restic.AuditEntry) (int, []*restic.AuditEntry, error) {
+	verify, err := verifyCommand(command)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	check := func(signature []byte, data []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(signature) == 0 {
+			return errors.New("not signed")
+		}
+		return verify(ctx, data, signature)
+	}
+
+	invalid := 0
+	for _, sn := range snapshots {
+		data, err := sn.SignedData()
+		if err = check(sn.Signature, data, err); err != nil {
+			Warnf("snapshot %v of %v at %v by %v: invalid signature: %v\n",
+				sn.ID().Str(), sn.Paths, sn.Time.Local().Format(TimeFormat), sn.Hostname, err)
+			invalid++
+		}
+	}
+
+	var valid []*restic.AuditEntry
+	for _, e := range entries {
+		data, err := e.SignedData()
+		if err = check(e.Signature, data, err); err != nil {
+			Warnf("audit log entry %v for %v at %v: invalid signature: %v\n",
+				e.ID().Str(), e.Command, e.Time.Local().Format(TimeFormat), err)
+			invalid++
+			continue
+		}
+		valid = append(valid, e)
+	}
+
+	return invalid, valid, nil
+}
+
+func runVerifyChain(opts VerifyChainOptions, gopts GlobalOptions) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx := gopts.ctx
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	entries, err := loadAuditEntries(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	if opts.VerifyCommand != "" {
+		invalid, entries, err = verifySignatures(ctx, opts.VerifyCommand, snapshots, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	accounted := restic.NewIDSet()
+	for _, e := range entries {
+		for _, id := range e.Snapshots {
+			accounted.Insert(id)
+		}
+	}
+
+	problems := restic.VerifyChains(snapshots, accounted)
+
+	if gopts.JSON {
+		type jsonProblem struct {
+			Snapshot *restic.ID `json:"snapshot"`
+			Time     time.Time  `json:"time"`
+			Hostname string     `json:"hostname"`
+			Paths    []string   `json:"paths"`
+			Previous restic.ID  `json:"previous"`
+		}
+
+		list := []jsonProblem{}
+		for _, p := range problems {
+			list = append(list, jsonProblem{
+				Snapshot: p.Snapshot.ID(),
+				Time:     p.Snapshot.Time,
+				Hostname: p.Snapshot.Hostname,
+				Paths:    p.Snapshot.Paths,
+				Previous: p.Previous,
+			})
+		}
+
+		err = json.NewEncoder(gopts.stdout).Encode(list)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			Printf("snapshot %v of %v at %v by %v: previous snapshot %v is missing\n",
+				p.Snapshot.ID().Str(), p.Snapshot.Paths, p.Snapshot.Time.Local().Format(TimeFormat),
+				p.Snapshot.Hostname, p.Previous.Str())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Fatalf("%d snapshots reference a previous snapshot which was removed without a record in the audit log", len(problems))
+	}
+
+	if invalid > 0 {
+		return errors.Fatalf("%d snapshots or audit log entries have no valid signature", invalid)
+	}
+
+	chains := make(map[string]struct{})
+	for _, sn := range snapshots {
+		chains[sn.ChainKey()] = struct{}{}
+	}
+	Verbosef("checked %d snapshots in %d chains, no missing snapshots found\n", len(snapshots), len(chains))
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"runtime"
 
@@ -20,9 +21,30 @@ EXIT STATUS
 Exit status is 0 if the command was successful, and non-zero if there was any error.
 `,
 	DisableAutoGenTag: true,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if globalOptions.JSON {
+			type jsonVersionOutput struct {
+				MessageType string `json:"message_type"` // "version"
+				Version     string `json:"version"`
+				GoVersion   string `json:"go_version"`
+				GoOS        string `json:"go_os"`
+				GoArch      string `json:"go_arch"`
+				JSONVersion int    `json:"json_version"`
+			}
+
+			return json.NewEncoder(globalOptions.stdout).Encode(jsonVersionOutput{
+				MessageType: "version",
+				Version:     version,
+				GoVersion:   runtime.Version(),
+				GoOS:        runtime.GOOS,
+				GoArch:      runtime.GOARCH,
+				JSONVersion: jsonVersion,
+			})
+		}
+
 		fmt.Printf("restic %s compiled with %v on %v/%v\n",
 			version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return nil
 	},
 }
 
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+
+	"github.com/spf13/cobra"
+)
+
+// cmdCompleteValues is called by the shell completion to get snapshot IDs,
+// hosts, tags and paths from the repository.
+var cmdCompleteValues = &cobra.Command{
+	Use:    "__complete-values [snapshots|hosts|tags|paths]",
+	Short:  "Print values for the shell completion",
+	Hidden: true,
+
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCompleteValues(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdCompleteValues)
+}
+
+// completionCacheTTL is the time the values for the shell completion are
+// cached, so that pressing tab repeatedly does not access the repository
+// each time.
+const completionCacheTTL = 5 * time.Minute
+
+// completionCacheEnv enables the cache for the values of the shell completion
+// when set to "1". The cached values are not encrypted, so the cache is
+// disabled by default.
+const completionCacheEnv = "RESTIC_COMPLETION_CACHE"
+
+// completionValues are the values suggested by the shell completion.
+type completionValues struct {
+	Time      time.Time `json:"time"`
+	Snapshots []string  `json:"snapshots"`
+	Hosts     []string  `json:"hosts"`
+	Tags      []string  `json:"tags"`
+	Paths     []string  `json:"paths"`
+}
+
+// list returns the values of the given kind.
+func (v *completionValues) list(kind string) ([]string, error) {
+	switch kind {
+	case "snapshots":
+		return append([]string{"latest"}, v.Snapshots...), nil
+	case "hosts":
+		return v.Hosts, nil
+	case "tags":
+		return v.Tags, nil
+	case "paths":
+		return v.Paths, nil
+	default:
+		return nil, errors.Fatalf("unknown kind of values %q", kind)
+	}
+}
+
+func runCompleteValues(gopts GlobalOptions, args []string) error {
+	if len(args) != 1 {
+		return errors.Fatal("exactly one kind of values must be specified")
+	}
+
+	// never ask for a password while the user presses tab
+	if gopts.password == "" && gopts.UnwrapCommand == "" {
+		return nil
+	}
+
+	values, err := loadCompletionValues(gopts, os.Getenv(completionCacheEnv) == "1")
+	if err != nil {
+		return err
+	}
+
+	list, err := values.list(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, s := range list {
+		Printf("%s\n", s)
+	}
+
+	return nil
+}
+
+// completionCacheFile returns the file the values for the repository are
+// cached in, or the empty string if the cache is disabled.
+func completionCacheFile(gopts GlobalOptions) string {
+	if gopts.NoCache || gopts.Repo == "" {
+		return ""
+	}
+
+	dir := gopts.CacheDir
+	if dir == "" {
+		var err error
+		dir, err = cache.DefaultDir()
+		if err != nil {
+			return ""
+		}
+	}
+
+	hash := sha256.Sum256([]byte(completionCacheKey(gopts.Repo)))
+	return filepath.Join(dir, "completion", hex.EncodeToString(hash[:16])+".json")
+}
+
+// completionCacheKey returns the repository location used to identify the
+// cache file. Relative paths of local repositories are made absolute, so that
+// the same relative path in different directories does not share the cache.
+func completionCacheKey(repo string) string {
+	loc, err := location.Parse(repo)
+	if err != nil || loc.Scheme != "local" {
+		return repo
+	}
+
+	abs, err := filepath.Abs(loc.Config.(local.Config).Path)
+	if err != nil {
+		return repo
+	}
+	return "local:" + abs
+}
+
+// readCompletionCache returns the values cached in filename if they are not
+// older than completionCacheTTL.
+func readCompletionCache(filename string, now time.Time) (*completionValues, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var values completionValues
+	if err = json.Unmarshal(buf, &values); err != nil {
+		return nil, err
+	}
+
+	if now.Sub(values.Time) > completionCacheTTL || values.Time.After(now) {
+		return nil, errors.New("cached values are outdated")
+	}
+
+	return &values, nil
+}
+
+// writeCompletionCache saves values to filename.
+func writeCompletionCache(filename string, values *completionValues) error {
+	buf, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	if err = fs.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, buf, 0600)
+}
+
+// loadCompletionValues returns the values for the shell completion, from the
+// cache if it is enabled and the values are recent enough.
+func loadCompletionValues(gopts GlobalOptions, useCache bool) (*completionValues, error) {
+	var cacheFile string
+	if useCache {
+		cacheFile = completionCacheFile(gopts)
+	}
+	if cacheFile != "" {
+		values, err := readCompletionCache(cacheFile, time.Now())
+		if err == nil {
+			return values, nil
+		}
+		debug.Log("unable to use cached values: %v", err)
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]struct{})
+	tags := make(map[string]struct{})
+	paths := make(map[string]struct{})
+
+	values := &completionValues{Time: time.Now()}
+	for sn := range FindFilteredSnapshots(gopts.ctx, repo, nil, nil, nil, nil) {
+		values.Snapshots = append(values.Snapshots, sn.ID().Str())
+		if sn.Hostname != "" {
+			hosts[sn.Hostname] = struct{}{}
+		}
+		for _, tag := range sn.Tags {
+			tags[tag] = struct{}{}
+		}
+		for _, p := range sn.Paths {
+			paths[p] = struct{}{}
+		}
+	}
+
+	sort.Strings(values.Snapshots)
+	values.Hosts = sortedKeys(hosts)
+	values.Tags = sortedKeys(tags)
+	values.Paths = sortedKeys(paths)
+
+	if cacheFile != "" {
+		if err := writeCompletionCache(cacheFile, values); err != nil {
+			debug.Log("unable to cache values: %v", err)
+		}
+	}
+
+	return values, nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// completionSnapshotArgs lists the commands which take snapshot IDs as
+// arguments, with the number of these arguments (zero means any number).
+var completionSnapshotArgs = map[string]int{
+	"browse":  1,
+	"copy":    0,
+	"diff":    2,
+	"dump":    1,
+	"export":  1,
+	"forget":  0,
+	"ls":      1,
+	"restore": 1,
+	"stats":   0,
+	"tag":     0,
+}
+
+// completionFlagValues maps the names of flags to the kind of values
+// suggested for them.
+var completionFlagValues = map[string]string{
+	"host":     "hosts",
+	"tag":      "tags",
+	"path":     "paths",
+	"snapshot": "snapshots",
+}
+
+// completionGlobalFlags are passed on to restic when the completion queries
+// the repository.
+var completionGlobalFlags = []string{
+	"-r", "--repo", "-p", "--password-file", "--password-command",
+	"--password-source", "--password-keychain", "--key-unwrap-command",
+	"--cache-dir", "-o", "--option", "--cacert", "--tls-client-cert",
+}
+
+// bashCompletionFunction returns the bash functions which suggest values
+// from the repository. cobra calls __custom_func when it has no other
+// completions for an argument.
+func bashCompletionFunction() string {
+	var cases []string
+	names := make([]string, 0, len(completionSnapshotArgs))
+	for name := range completionSnapshotArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cond := "true"
+		if n := completionSnapshotArgs[name]; n > 0 {
+			cond = fmt.Sprintf("[[ ${#nouns[@]} -lt %d ]]", n)
+		}
+		cases = append(cases, fmt.Sprintf("        restic_%s)\n            %s && __restic_complete_values snapshots\n            ;;", name, cond))
+	}
+
+	return `__restic_complete_values()
+{
+    local args=() i
+    for ((i = 1; i < cword; i++)); do
+        case "${words[i]}" in
+            ` + strings.Join(completionGlobalFlags, "|") + `)
+                args+=("${words[i]}" "${words[i+1]}")
+                ;;
+            ` + strings.Join(longFlagPatterns(completionGlobalFlags), "|") + `|--no-cache)
+                args+=("${words[i]}")
+                ;;
+        esac
+    done
+
+    local IFS=$'\n'
+    COMPREPLY=( $(compgen -W "$("${words[0]}" "${args[@]}" --quiet __complete-values "$1" 2>/dev/null)" -- "$cur") )
+}
+
+__restic_complete_snapshots()
+{
+    __restic_complete_values snapshots
+}
+
+__restic_complete_hosts()
+{
+    __restic_complete_values hosts
+}
+
+__restic_complete_tags()
+{
+    __restic_complete_values tags
+}
+
+__restic_complete_paths()
+{
+    __restic_complete_values paths
+}
+
+__custom_func()
+{
+    case ${last_command} in
+` + strings.Join(cases, "\n") + `
+    esac
+}
+`
+}
+
+// longFlagPatterns returns shell patterns which match the long flags in list
+// with the value given after "=".
+func longFlagPatterns(list []string) []string {
+	var res []string
+	for _, s := range list {
+		if strings.HasPrefix(s, "--") {
+			res = append(res, s+"=*")
+		}
+	}
+	return res
+}
+
+// setupCompletion configures the shell completion of all commands to
+// suggest values from the repository.
+func setupCompletion() error {
+	cmdRoot.BashCompletionFunction = bashCompletionFunction()
+	return annotateCompletionFlags(cmdRoot)
+}
+
+func annotateCompletionFlags(cmd *cobra.Command) error {
+	for name, kind := range completionFlagValues {
+		if cmd.Flags().Lookup(name) == nil {
+			continue
+		}
+
+		err := cmd.Flags().SetAnnotation(name, cobra.BashCompCustom, []string{"__restic_complete_" + kind})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range cmd.Commands() {
+		if err := annotateCompletionFlags(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeCompletion writes the completion script for shell to stdout.
+func writeCompletion(shell string) error {
+	if err := setupCompletion(); err != nil {
+		return err
+	}
+
+	switch shell {
+	case "bash":
+		return cmdRoot.GenBashCompletion(os.Stdout)
+	case "zsh":
+		// the bash completion suggests values from the repository, zsh can
+		// use it with bashcompinit
+		fmt.Fprintf(os.Stdout, "#compdef restic\n\nautoload -U +X bashcompinit && bashcompinit\n\n")
+		return cmdRoot.GenBashCompletion(os.Stdout)
+	default:
+		return errors.Fatalf("unsupported shell %q, must be one of bash, zsh", shell)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompletionCache(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	gopts := GlobalOptions{Repo: "/srv/restic-repo", CacheDir: tempdir}
+	filename := completionCacheFile(gopts)
+	rtest.Assert(t, strings.HasPrefix(filename, filepath.Join(tempdir, "completion")), "unexpected cache file %v", filename)
+
+	// relative paths of local repositories are resolved
+	wd, err := os.Getwd()
+	rtest.OK(t, err)
+	rtest.Equals(t, completionCacheFile(GlobalOptions{Repo: filepath.Join(wd, "repo"), CacheDir: tempdir}),
+		completionCacheFile(GlobalOptions{Repo: "local:repo", CacheDir: tempdir}))
+	rtest.Assert(t, completionCacheFile(GlobalOptions{Repo: "sftp:host:repo", CacheDir: tempdir}) !=
+		completionCacheFile(GlobalOptions{Repo: "sftp:other:repo", CacheDir: tempdir}),
+		"different repositories share the cache file")
+
+	gopts.NoCache = true
+	rtest.Equals(t, "", completionCacheFile(gopts))
+
+	now := time.Now()
+	values := &completionValues{
+		Time:      now,
+		Snapshots: []string{"12345678"},
+		Hosts:     []string{"host"},
+		Tags:      []string{"foo", "bar baz"},
+	}
+	rtest.OK(t, writeCompletionCache(filename, values))
+
+	cached, err := readCompletionCache(filename, now.Add(time.Minute))
+	rtest.OK(t, err)
+
+	list, err := cached.list("snapshots")
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"latest", "12345678"}, list)
+
+	list, err = cached.list("tags")
+	rtest.OK(t, err)
+	rtest.Equals(t, values.Tags, list)
+
+	_, err = cached.list("keys")
+	rtest.Assert(t, err != nil, "expected error for unknown kind of values")
+
+	_, err = readCompletionCache(filename, now.Add(completionCacheTTL+time.Second))
+	rtest.Assert(t, err != nil, "expected outdated values to be ignored")
+}
+
+func TestAnnotateCompletionFlags(t *testing.T) {
+	rtest.OK(t, annotateCompletionFlags(cmdRoot))
+
+	for _, test := range []struct {
+		cmd  *cobra.Command
+		flag string
+		fn   string
+	}{
+		{cmdRestore, "host", "__restic_complete_hosts"},
+		{cmdRestore, "tag", "__restic_complete_tags"},
+		{cmdFind, "snapshot", "__restic_complete_snapshots"},
+	} {
+		f := test.cmd.Flags().Lookup(test.flag)
+		rtest.Assert(t, f != nil, "flag --%v of %v not found", test.flag, test.cmd.Name())
+		rtest.Equals(t, []string{test.fn}, f.Annotations[cobra.BashCompCustom])
+	}
+
+	fn := bashCompletionFunction()
+	rtest.Assert(t, strings.Contains(fn, "restic_restore)"), "restore is missing in the completion function")
+}
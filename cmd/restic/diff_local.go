@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// localDiffPrefix marks the second argument of the diff command as a local
+// directory.
+const localDiffPrefix = "local:"
+
+// LocalDiffStats collects the differences between a snapshot and a local
+// directory.
+type LocalDiffStats struct {
+	ChangedFiles   int
+	Added, Removed DiffStat
+}
+
+// findSubtree returns the ID of the tree for the directory dir, which is a
+// slash-separated path within the tree with the ID id.
+func findSubtree(ctx context.Context, repo restic.Repository, id restic.ID, dir string) (restic.ID, error) {
+	dir = strings.Trim(path.Clean(dir), "/")
+	if dir == "" || dir == "." {
+		return id, nil
+	}
+
+	for _, name := range strings.Split(dir, "/") {
+		tree, err := repo.LoadTree(ctx, id)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		node := tree.Find(name)
+		if node == nil || node.Type != "dir" || node.Subtree == nil {
+			return restic.ID{}, errors.Fatalf("directory %q not found in snapshot", "/"+dir)
+		}
+		id = *node.Subtree
+	}
+
+	return id, nil
+}
+
+// readLocalDir returns the sorted names of the entries in dir.
+func readLocalDir(dir string) ([]string, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Readdirnames")
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// compareLocalContent returns true if the file at filename contains exactly
+// the data of node.
+func (c *Comparer) compareLocalContent(node *restic.Node, filename string, fi os.FileInfo) (bool, error) {
+	if uint64(fi.Size()) != node.Size {
+		return false, nil
+	}
+
+	f, err := fs.Open(filename)
+	if err != nil {
+		return false, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	var buf []byte
+	for _, id := range node.Content {
+		length, found := c.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return false, errors.Errorf("unable to find blob %v", id.Str())
+		}
+
+		if uint(cap(buf)) < length {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+
+		_, err = io.ReadFull(f, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "ReadFull")
+		}
+
+		if !restic.Hash(buf).Equal(id) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// localMetadataChanged returns true if the permissions, owner or modification
+// time of the local item differ from node.
+func localMetadataChanged(node *restic.Node, filename string, fi os.FileInfo) bool {
+	local, err := restic.NodeFromFileInfo(filename, fi)
+	if err != nil {
+		debug.Log("unable to get metadata for %v: %v", filename, err)
+		return true
+	}
+
+	return node.Mode != local.Mode ||
+		node.UID != local.UID ||
+		node.GID != local.GID ||
+		(node.Type != "dir" && !node.ModTime.Equal(local.ModTime))
+}
+
+// printLocalDir prints all items below the local directory dir as added.
+func (c *Comparer) printLocalDir(stats *DiffStat, prefix, dir string) error {
+	names, err := readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(dir, name)
+		fi, err := fs.Lstat(filename)
+		if err != nil {
+			Warnf("error: %v\n", err)
+			continue
+		}
+
+		nodeType := restic.NodeTypeFromFileInfo(fi)
+		item := path.Join(prefix, name)
+		if nodeType == "dir" {
+			item += "/"
+		}
+		c.printChange("+", item)
+		stats.Add(&restic.Node{Type: nodeType})
+
+		if nodeType == "dir" {
+			err := c.printLocalDir(stats, item, filename)
+			if err != nil {
+				Warnf("error: %v\n", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffLocal compares the tree with the ID id to the local directory dir.
+func (c *Comparer) diffLocal(ctx context.Context, stats *LocalDiffStats, prefix string, id restic.ID, dir string) error {
+	debug.Log("diffing %v to local %v", id, dir)
+	tree, err := c.repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	localNames, err := readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	treeNodes := make(map[string]*restic.Node)
+	names := make(map[string]struct{})
+	for _, node := range tree.Nodes {
+		treeNodes[node.Name] = node
+		names[node.Name] = struct{}{}
+	}
+	localFiles := make(map[string]struct{})
+	for _, name := range localNames {
+		localFiles[name] = struct{}{}
+		names[name] = struct{}{}
+	}
+
+	uniqueNames := make([]string, 0, len(names))
+	for name := range names {
+		uniqueNames = append(uniqueNames, name)
+	}
+	sort.Strings(uniqueNames)
+
+	for _, name := range uniqueNames {
+		node, inTree := treeNodes[name]
+		_, inLocal := localFiles[name]
+		filename := filepath.Join(dir, name)
+		item := path.Join(prefix, name)
+
+		var fi os.FileInfo
+		if inLocal {
+			fi, err = fs.Lstat(filename)
+			if err != nil {
+				Warnf("error: %v\n", err)
+				continue
+			}
+		}
+
+		switch {
+		case inTree && inLocal:
+			localType := restic.NodeTypeFromFileInfo(fi)
+			mod := ""
+
+			if node.Type != localType {
+				mod += "T"
+			}
+
+			if localType == "dir" {
+				item += "/"
+			}
+
+			switch {
+			case mod != "":
+			case node.Type == "file":
+				equal, err := c.compareLocalContent(node, filename, fi)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+				if !equal {
+					mod += "M"
+					stats.ChangedFiles++
+				}
+			case node.Type == "symlink":
+				target, err := fs.Readlink(filename)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+				if target != node.LinkTarget {
+					mod += "M"
+				}
+			}
+
+			if mod == "" && c.opts.ShowMetadata && localMetadataChanged(node, filename, fi) {
+				mod += "U"
+			}
+
+			if mod != "" {
+				c.printChange(mod, item)
+			}
+
+			if node.Type == "dir" && localType == "dir" {
+				err := c.diffLocal(ctx, stats, item, *node.Subtree, filename)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		case inTree:
+			if node.Type == "dir" {
+				item += "/"
+			}
+			c.printChange("-", item)
+			stats.Removed.Add(node)
+
+			if node.Type == "dir" {
+				err := c.printDir(ctx, "-", &stats.Removed, restic.NewBlobSet(), item, *node.Subtree)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		case inLocal:
+			nodeType := restic.NodeTypeFromFileInfo(fi)
+			if nodeType == "dir" {
+				item += "/"
+			}
+			c.printChange("+", item)
+			stats.Added.Add(&restic.Node{Type: nodeType})
+
+			if nodeType == "dir" {
+				err := c.printLocalDir(&stats.Added, item, filename)
+				if err != nil {
+					Warnf("error: %v\n", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// runDiffLocal compares the snapshot described by snapshotArg to the local
+// directory dir. snapshotArg may be followed by a colon and the directory
+// within the snapshot to compare, by default the directory with the same path
+// as the local directory is used.
+func runDiffLocal(ctx context.Context, repo *repository.Repository, opts DiffOptions, gopts GlobalOptions, snapshotArg, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return errors.Wrap(err, "Abs")
+	}
+
+	fi, err := fs.Stat(dir)
+	if err != nil {
+		return errors.Fatalf("unable to access %v: %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return errors.Fatalf("%v is not a directory", dir)
+	}
+
+	snPath := snapshotPath(dir)
+	if i := strings.Index(snapshotArg, ":"); i >= 0 {
+		snapshotArg, snPath = snapshotArg[:i], snapshotPath(snapshotArg[i+1:])
+	}
+
+	sn, err := loadSnapshot(ctx, repo, snapshotArg)
+	if err != nil {
+		return err
+	}
+
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	id, err := findSubtree(ctx, repo, *sn.Tree, snPath)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("comparing %v in snapshot %v to %v:\n\n", snPath, sn.ID().Str(), dir)
+
+	c := newComparer(repo, opts, gopts)
+
+	stats := &LocalDiffStats{}
+	err = c.diffLocal(ctx, stats, "/", id, dir)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return printDiffStatistics(gopts.stdout, stats.ChangedFiles, stats.Added, stats.Removed)
+	}
+
+	Printf("\n")
+	Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
+	Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
+	Printf("Others:      %5d new, %5d removed\n", stats.Added.Others, stats.Removed.Others)
+
+	return nil
+}
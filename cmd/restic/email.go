@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// emailConfig contains the SMTP settings for the email reports, which are read
+// from the file set with --email-config.
+type emailConfig struct {
+	Host     string `option:"host"`
+	Port     int    `option:"port"`
+	Username string `option:"username"`
+	Password string `option:"password"`
+	From     string `option:"from"`
+	To       string `option:"to"`
+	TLS      bool   `option:"tls"`
+	On       string `option:"on"`
+}
+
+// loadEmailConfig reads the settings from filename. Each line contains a
+// setting as key=value, empty lines and lines starting with # are ignored.
+func loadEmailConfig(filename string) (*emailConfig, error) {
+	lines, err := readOptionFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read email config: %v", err)
+	}
+
+	opts, err := options.Parse(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &emailConfig{
+		Port: 587,
+		On:   "always",
+	}
+	if err := opts.Apply("email", cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.Host == "":
+		return nil, errors.Fatal("email config: host is not set")
+	case cfg.From == "":
+		return nil, errors.Fatal("email config: from is not set")
+	case cfg.To == "":
+		return nil, errors.Fatal("email config: to is not set")
+	}
+
+	switch cfg.On {
+	case "always", "warning", "failure":
+	default:
+		return nil, errors.Fatalf("email config: invalid value %q for on, must be always, warning or failure", cfg.On)
+	}
+
+	return cfg, nil
+}
+
+// statusLevel orders the status of a run for the setting "on" of the email
+// reports and for --notify.
+var statusLevel = map[string]int{
+	"success": 0,
+	"always":  0,
+	"warning": 1,
+	"failure": 2,
+}
+
+// emailReport is the summary of a run which is sent by email.
+type emailReport struct {
+	Command  string
+	Hostname string
+	Status   string // "success", "warning" or "failure"
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+	Error    string
+	Values   []metricValue
+	Errors   []string
+}
+
+// format returns the report as an email message.
+func (r emailReport) format(cfg *emailConfig) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(buf, "To: %s\r\n", cfg.To)
+	fmt.Fprintf(buf, "Subject: restic %s on %s: %s\r\n", r.Command, r.Hostname, r.Status)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "\r\n")
+
+	fmt.Fprintf(buf, "restic %s on %s finished with status %s.\r\n\r\n", r.Command, r.Hostname, r.Status)
+	fmt.Fprintf(buf, "started:   %s\r\n", r.Start.Format(TimeFormat))
+	fmt.Fprintf(buf, "duration:  %s\r\n", formatDuration(r.Duration))
+	fmt.Fprintf(buf, "exit code: %d\r\n", r.ExitCode)
+	if r.Error != "" {
+		fmt.Fprintf(buf, "error:     %s\r\n", r.Error)
+	}
+
+	if len(r.Values) > 0 {
+		fmt.Fprintf(buf, "\r\n")
+		for _, v := range r.Values {
+			fmt.Fprintf(buf, "%-28s %s\r\n", v.name+":", strconv.FormatFloat(v.value, 'f', -1, 64))
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(buf, "\r\nerrors:\r\n")
+		for _, e := range r.Errors {
+			fmt.Fprintf(buf, "  %s\r\n", e)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// sendEmailReport sends r if its status matches the setting "on" in cfg.
+func sendEmailReport(cfg *emailConfig, r emailReport) error {
+	if statusLevel[r.Status] < statusLevel[cfg.On] {
+		return nil
+	}
+
+	var to []string
+	for _, addr := range strings.Split(cfg.To, ",") {
+		to = append(to, strings.TrimSpace(addr))
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	msg := r.format(cfg)
+
+	if !cfg.TLS {
+		// smtp.SendMail uses STARTTLS if the server supports it
+		return smtp.SendMail(addr, auth, cfg.From, to, msg)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: cfg.Host})
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	wr, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = wr.Write(msg); err != nil {
+		return err
+	}
+	if err = wr.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLoadEmailConfig(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "email.conf")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte(`
+# mail server of the office
+host = mail.example.com
+username = backup
+password = secret
+from = backup@example.com
+to = admin@example.com, it@example.com
+on = warning
+`), 0600))
+
+	cfg, err := loadEmailConfig(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, emailConfig{
+		Host:     "mail.example.com",
+		Port:     587,
+		Username: "backup",
+		Password: "secret",
+		From:     "backup@example.com",
+		To:       "admin@example.com, it@example.com",
+		On:       "warning",
+	}, *cfg)
+
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("host=mail.example.com\nfrom=a@example.com\nto=b@example.com\non=sometimes\n"), 0600))
+	_, err = loadEmailConfig(filename)
+	rtest.Assert(t, err != nil, "expected error for invalid value of on")
+}
+
+func TestEmailReportFormat(t *testing.T) {
+	cfg := &emailConfig{From: "backup@example.com", To: "admin@example.com"}
+	r := emailReport{
+		Command:  "backup",
+		Hostname: "kasimir",
+		Status:   "warning",
+		Start:    time.Now(),
+		Duration: 90 * time.Second,
+		Values:   []metricValue{{name: "files_new", value: 3}},
+		Errors:   []string{"/home/user/secret: permission denied"},
+	}
+
+	msg := string(r.format(cfg))
+	for _, s := range []string{
+		"Subject: restic backup on kasimir: warning\r\n",
+		"files_new:",
+		"  /home/user/secret: permission denied\r\n",
+	} {
+		rtest.Assert(t, strings.Contains(msg, s), "%q not found in message:\n%s", s, msg)
+	}
+}
+
+func TestSendEmailReportSkipped(t *testing.T) {
+	// the host is not reachable, so the report must not be sent
+	cfg := &emailConfig{Host: "127.0.0.1", Port: 1, From: "a@example.com", To: "b@example.com", On: "failure"}
+	rtest.OK(t, sendEmailReport(cfg, emailReport{Status: "warning"}))
+}
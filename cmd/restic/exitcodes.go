@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The exit codes of restic, which are documented in doc/075_scripting.rst.
+const (
+	exitCodeSuccess = 0
+	exitCodeError   = 1
+
+	// exitCodeSkippedFiles is returned when a snapshot was created, but some
+	// files could not be read.
+	exitCodeSkippedFiles = 3
+
+	// exitCodeQuotaExceeded is returned when a backup was aborted because the
+	// repository would exceed its size quota.
+	exitCodeQuotaExceeded = 4
+
+	// exitCodeRepositoryDamaged is returned when check found errors in the
+	// repository.
+	exitCodeRepositoryDamaged = 5
+
+	// exitCodeBackendUnavailable is returned when the backend could not be
+	// reached or does not contain a repository.
+	exitCodeBackendUnavailable = 10
+
+	// exitCodeLocked is returned when the repository is locked by another
+	// process.
+	exitCodeLocked = 11
+
+	// exitCodeWrongPassword is returned when no key could be opened with the
+	// password.
+	exitCodeWrongPassword = 12
+
+	// exitCodeInterrupted is returned when restic was interrupted with
+	// SIGINT.
+	exitCodeInterrupted = 130
+)
+
+// exitError is an error which makes restic exit with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+// withExitCode returns err annotated with the exit code, or nil if err is nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{code: code, err: err}
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the annotated error, so that errors.Cause() and
+// errors.IsFatal() see the original error.
+func (e *exitError) Cause() error {
+	return e.err
+}
+
+// exitCodeFor returns the exit code restic terminates with for err.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitCodeSuccess
+	}
+
+	for e := err; e != nil; {
+		if ee, ok := e.(*exitError); ok {
+			return ee.code
+		}
+
+		c, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+
+	switch cause := errors.Cause(err); {
+	case backend.IsQuotaExceeded(cause):
+		return exitCodeQuotaExceeded
+	case restic.IsAlreadyLocked(cause):
+		return exitCodeLocked
+	default:
+		return exitCodeError
+	}
+}
+
+// exitErrorCodes maps the exit codes to the error codes printed in the JSON
+// messages.
+var exitErrorCodes = map[int]errors.Code{
+	exitCodeSkippedFiles:       errors.CodeFilesSkipped,
+	exitCodeQuotaExceeded:      errors.CodeQuotaExceeded,
+	exitCodeRepositoryDamaged:  errors.CodeRepositoryDamaged,
+	exitCodeBackendUnavailable: errors.CodeBackendUnavailable,
+	exitCodeLocked:             errors.CodeRepositoryLocked,
+	exitCodeWrongPassword:      errors.CodeWrongPassword,
+	exitCodeInterrupted:        errors.CodeInterrupted,
+}
+
+// errorCodeFor returns the error code for err. Errors with a specific exit
+// code use the matching error code, for all others the code is determined by
+// errors.CodeOf.
+func errorCodeFor(err error) errors.Code {
+	if code, ok := exitErrorCodes[exitCodeFor(err)]; ok {
+		return code
+	}
+
+	return errors.CodeOf(err)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	damaged := withExitCode(exitCodeRepositoryDamaged, errors.Fatal("repository contains errors"))
+
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{nil, exitCodeSuccess},
+		{errors.New("some error"), exitCodeError},
+		{errors.Fatal("fatal error"), exitCodeError},
+		{damaged, exitCodeRepositoryDamaged},
+		{errors.Wrap(damaged, "check"), exitCodeRepositoryDamaged},
+		{&backend.QuotaExceededError{Quota: 10, Used: 20}, exitCodeQuotaExceeded},
+	} {
+		rtest.Equals(t, test.code, exitCodeFor(test.err))
+	}
+
+	rtest.Equals(t, nil, withExitCode(exitCodeError, nil))
+	rtest.Assert(t, errors.IsFatal(errors.Cause(damaged)), "annotated error is not fatal anymore")
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorCodeFor(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code errors.Code
+	}{
+		{nil, ""},
+		{errors.New("some error"), errors.CodeUnknown},
+		{withExitCode(exitCodeWrongPassword, errors.New("wrong password")), errors.CodeWrongPassword},
+		{&backend.QuotaExceededError{Quota: 10, Used: 20}, errors.CodeQuotaExceeded},
+		{errors.Wrap(timeoutError{}, "Load"), errors.CodeBackendTimeout},
+		{errors.Wrap(os.ErrPermission, "Open"), errors.CodePermissionDenied},
+		{errors.Wrap(errors.WithCode(errors.CodeBlobMissing, errors.New("not found")), "LoadBlob"), errors.CodeBlobMissing},
+		{withExitCode(exitCodeBackendUnavailable, os.ErrNotExist), errors.CodeBackendUnavailable},
+	} {
+		rtest.Equals(t, test.code, errorCodeFor(test.err))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -46,19 +47,37 @@ const TimeFormat = "2006-01-02 15:04:05"
 
 // GlobalOptions hold all global options for restic.
 type GlobalOptions struct {
-	Repo            string
-	PasswordFile    string
-	PasswordCommand string
-	KeyHint         string
-	Quiet           bool
-	Verbose         int
-	NoLock          bool
-	JSON            bool
-	CacheDir        string
-	NoCache         bool
-	CACerts         []string
-	TLSClientCert   string
-	CleanupCache    bool
+	Repo             string
+	PasswordFile     string
+	PasswordCommand  string
+	PasswordKeychain string
+	PasswordSource   string
+	KeyHint          string
+	UnwrapCommand    string
+	Approval         string
+	SignCommand      string
+	Quiet            bool
+	Verbose          int
+	NoLock           bool
+	JSON             bool
+	StatusFD         int
+	StatusSocket     string
+	MetricsFile      string
+	MetricsPushURL   string
+	WebhookURLs      []string
+	EmailConfig      string
+	Notify           string
+	LogFile          string
+	LogFormat        string
+	LogMaxSize       string
+	LogSyslog        bool
+	CacheDir         string
+	NoCache          bool
+	CACerts          []string
+	TLSClientCert    string
+	TLSServerPins    []string
+	CleanupCache     bool
+	IndexOnDisk      bool
 
 	LimitUploadKb   int
 	LimitDownloadKb int
@@ -68,6 +87,18 @@ type GlobalOptions struct {
 	stdout   io.Writer
 	stderr   io.Writer
 
+	// passwordFromKeyring is set when the password was read from the keyring,
+	// so that a wrong stored password falls back to the prompt.
+	passwordFromKeyring bool
+
+	// metrics is set by commands which report metrics with --metrics-file
+	// and --metrics-push-url.
+	metrics *metrics
+
+	// allowExpiredKey is set by commands which must work with an expired key,
+	// so that it can be replaced.
+	allowExpiredKey bool
+
 	// verbosity is set as follows:
 	//  0 means: don't print any messages except errors, this is used when --quiet is specified
 	//  1 is the default: print essential messages
@@ -75,7 +106,8 @@ type GlobalOptions struct {
 	//  3 means: print very detailed debug messages, this is used when --verbose 2 is specified
 	verbosity uint
 
-	Options []string
+	Options    []string
+	OptionFile string
 
 	extended options.Options
 }
@@ -87,31 +119,52 @@ var globalOptions = GlobalOptions{
 
 var isReadingPassword bool
 
+// cancelGlobalContext cancels globalOptions.ctx and thereby the running command.
+var cancelGlobalContext context.CancelFunc
+
 func init() {
-	var cancel context.CancelFunc
-	globalOptions.ctx, cancel = context.WithCancel(context.Background())
+	globalOptions.ctx, cancelGlobalContext = context.WithCancel(context.Background())
 	AddCleanupHandler(func() error {
-		cancel()
+		cancelGlobalContext()
 		return nil
 	})
 
 	f := cmdRoot.PersistentFlags()
 	f.StringVarP(&globalOptions.Repo, "repo", "r", os.Getenv("RESTIC_REPOSITORY"), "`repository` to backup to or restore from (default: $RESTIC_REPOSITORY)")
 	f.StringVarP(&globalOptions.PasswordFile, "password-file", "p", os.Getenv("RESTIC_PASSWORD_FILE"), "read the repository password from a `file` (default: $RESTIC_PASSWORD_FILE)")
+	f.StringVarP(&globalOptions.PasswordSource, "password-source", "", os.Getenv("RESTIC_PASSWORD_SOURCE"), "try the password `sources` command, file, env, keyring and prompt in the given order (default: $RESTIC_PASSWORD_SOURCE)")
 	f.StringVarP(&globalOptions.KeyHint, "key-hint", "", os.Getenv("RESTIC_KEY_HINT"), "`key` ID of key to try decrypting first (default: $RESTIC_KEY_HINT)")
 	f.StringVarP(&globalOptions.PasswordCommand, "password-command", "", os.Getenv("RESTIC_PASSWORD_COMMAND"), "specify a shell `command` to obtain a password (default: $RESTIC_PASSWORD_COMMAND)")
+	f.StringVarP(&globalOptions.PasswordKeychain, "password-keychain", "", os.Getenv("RESTIC_PASSWORD_KEYCHAIN"), "read the repository password from the entry `name` in the keychain of the operating system (default: $RESTIC_PASSWORD_KEYCHAIN)")
+	f.StringVarP(&globalOptions.UnwrapCommand, "key-unwrap-command", "", os.Getenv("RESTIC_KEY_UNWRAP_COMMAND"), "open the repository with a wrapped key, using a shell `command` which unwraps the key read from stdin (default: $RESTIC_KEY_UNWRAP_COMMAND)")
+	f.StringVarP(&globalOptions.Approval, "approval", "", os.Getenv("RESTIC_APPROVAL"), "approve a destructive operation with a `token` created by \"restic approve\" (default: $RESTIC_APPROVAL)")
+	f.StringVarP(&globalOptions.SignCommand, "sign-command", "", os.Getenv("RESTIC_SIGN_COMMAND"), "sign audit log entries and new snapshots with a shell `command` which reads the data from stdin and writes the signature to stdout (default: $RESTIC_SIGN_COMMAND)")
 	f.BoolVarP(&globalOptions.Quiet, "quiet", "q", false, "do not output comprehensive progress report")
 	f.CountVarP(&globalOptions.Verbose, "verbose", "v", "be verbose (specify --verbose multiple times or level `n`)")
 	f.BoolVar(&globalOptions.NoLock, "no-lock", false, "do not lock the repo, this allows some operations on read-only repos")
 	f.BoolVarP(&globalOptions.JSON, "json", "", false, "set output mode to JSON for commands that support it")
+	f.IntVar(&globalOptions.StatusFD, "status-fd", 0, "write JSON progress messages of backup and restore to the file descriptor `fd`")
+	f.StringVar(&globalOptions.StatusSocket, "status-socket", os.Getenv("RESTIC_STATUS_SOCKET"), "write JSON progress messages of backup and restore to the unix socket or named pipe at `path` (default: $RESTIC_STATUS_SOCKET)")
+	f.StringVar(&globalOptions.MetricsFile, "metrics-file", os.Getenv("RESTIC_METRICS_FILE"), "write metrics of backup, forget and prune in the Prometheus text format to `file` (default: $RESTIC_METRICS_FILE)")
+	f.StringVar(&globalOptions.MetricsPushURL, "metrics-push-url", os.Getenv("RESTIC_METRICS_PUSH_URL"), "push metrics of backup, forget and prune to the Prometheus Pushgateway at `url` (default: $RESTIC_METRICS_PUSH_URL)")
+	f.StringSliceVar(&globalOptions.WebhookURLs, "webhook-url", envList("RESTIC_WEBHOOK_URL"), "post a JSON summary of backup, forget and prune to `url` when they start and finish, the url can be a Go template (can be specified multiple times, default: $RESTIC_WEBHOOK_URL)")
+	f.StringVar(&globalOptions.EmailConfig, "email-config", os.Getenv("RESTIC_EMAIL_CONFIG"), "send an email report after backup, forget and prune with the SMTP settings in `file` (default: $RESTIC_EMAIL_CONFIG)")
+	f.StringVar(&globalOptions.Notify, "notify", os.Getenv("RESTIC_NOTIFY"), "show a desktop notification after backup, forget and prune, `when` is always, warning or failure (default: $RESTIC_NOTIFY)")
+	f.StringVar(&globalOptions.LogFile, "log-file", os.Getenv("RESTIC_LOG_FILE"), "append log messages to `file` (default: $RESTIC_LOG_FILE)")
+	f.StringVar(&globalOptions.LogFormat, "log-format", "text", "write log messages as \"text\" or \"json\"")
+	f.StringVar(&globalOptions.LogMaxSize, "log-max-size", "10M", "rotate the log file when it exceeds `size`, the last 5 files are kept (\"0\" disables the rotation)")
+	f.BoolVar(&globalOptions.LogSyslog, "log-syslog", false, "send log messages to syslog")
 	f.StringVar(&globalOptions.CacheDir, "cache-dir", "", "set the cache `directory`. (default: use system default cache directory)")
 	f.BoolVar(&globalOptions.NoCache, "no-cache", false, "do not use a local cache")
-	f.StringSliceVar(&globalOptions.CACerts, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
+	f.StringSliceVar(&globalOptions.CACerts, "cacert", envList("RESTIC_CACERT"), "`file` to load root certificates from (default: $RESTIC_CACERT or use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
+	f.StringSliceVar(&globalOptions.TLSServerPins, "tls-server-sha256-pin", envList("RESTIC_TLS_SERVER_SHA256_PIN"), "only connect to servers which present a certificate with the SHA-256 `fingerprint` (can be specified multiple times, default: $RESTIC_TLS_SERVER_SHA256_PIN)")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.BoolVar(&globalOptions.IndexOnDisk, "index-on-disk", false, "keep the index in memory mapped files in the cache directory to reduce memory usage")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.StringVar(&globalOptions.OptionFile, "option-file", os.Getenv("RESTIC_OPTION_FILE"), "read extended options from `file`, one key=value per line, options set with --option take precedence (default: $RESTIC_OPTION_FILE)")
 
 	restoreTerminal()
 }
@@ -219,20 +272,41 @@ func Println(args ...interface{}) {
 }
 
 // Verbosef calls Printf to write the message when the verbose flag is set.
+// In JSON mode, the message is written to stderr so that stdout only contains
+// JSON.
 func Verbosef(format string, args ...interface{}) {
-	if globalOptions.verbosity >= 1 {
-		Printf(format, args...)
+	logf("info", format, args...)
+	systemd.Status(fmt.Sprintf(format, args...))
+
+	if globalOptions.verbosity < 1 {
+		return
 	}
+
+	if globalOptions.JSON {
+		_, err := fmt.Fprintf(globalOptions.stderr, format, args...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to stderr: %v\n", err)
+		}
+		return
+	}
+
+	Printf(format, args...)
 }
 
 // PrintProgress wraps fmt.Printf to handle the difference in writing progress
-// information to terminals and non-terminal stdout
+// information to terminals and non-terminal stdout. Nothing is printed in JSON
+// mode.
 func PrintProgress(format string, args ...interface{}) {
 	var (
 		message         string
 		carriageControl string
 	)
 	message = fmt.Sprintf(format, args...)
+	systemd.Status(message)
+
+	if globalOptions.JSON {
+		return
+	}
 
 	if !(strings.HasSuffix(message, "\r") || strings.HasSuffix(message, "\n")) {
 		if stdoutIsTerminal() {
@@ -252,6 +326,8 @@ func PrintProgress(format string, args ...interface{}) {
 
 // Warnf writes the message to the configured stderr stream.
 func Warnf(format string, args ...interface{}) {
+	logf("warning", format, args...)
+
 	_, err := fmt.Fprintf(globalOptions.stderr, format, args...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "unable to write to stderr: %v\n", err)
@@ -269,37 +345,287 @@ func Exitf(exitcode int, format string, args ...interface{}) {
 	Exit(exitcode)
 }
 
+// envList returns the comma separated values of the environment variable, or
+// nil if it is not set.
+func envList(name string) []string {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// readOptionFile returns the lines of filename which contain an option as
+// key=value, empty lines and lines starting with # are ignored.
+func readOptionFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, sc.Err()
+}
+
+// parseExtendedOptions parses the extended options set with --option and
+// adds those from --option-file which are not set on the command line.
+func parseExtendedOptions(gopts GlobalOptions) (options.Options, error) {
+	opts, err := options.Parse(gopts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	if gopts.OptionFile == "" {
+		return opts, nil
+	}
+
+	lines, err := readOptionFile(gopts.OptionFile)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read option file: %v", err)
+	}
+
+	fileOpts, err := options.Parse(lines)
+	if err != nil {
+		return nil, errors.Fatalf("option file %v: %v", gopts.OptionFile, err)
+	}
+
+	for k, v := range fileOpts {
+		if _, ok := opts[k]; !ok {
+			opts[k] = v
+		}
+	}
+
+	return opts, nil
+}
+
 // resolvePassword determines the password to be used for opening the repository.
-func resolvePassword(opts GlobalOptions) (string, error) {
+func resolvePassword(opts GlobalOptions, envStr string) (string, error) {
+	pwd, _, err := resolvePasswordSource(opts, envStr)
+	return pwd, err
+}
+
+// resolvePasswordSource works like resolvePassword and additionally returns
+// the name of the source the password was read from.
+func resolvePasswordSource(opts GlobalOptions, envStr string) (string, string, error) {
+	if opts.PasswordSource != "" {
+		return resolvePasswordSources(opts, envStr)
+	}
+
 	if opts.PasswordFile != "" && opts.PasswordCommand != "" {
-		return "", errors.Fatalf("Password file and command are mutually exclusive options")
+		return "", "", errors.Fatalf("Password file and command are mutually exclusive options")
+	}
+	if opts.PasswordKeychain != "" && (opts.PasswordFile != "" || opts.PasswordCommand != "") {
+		return "", "", errors.Fatalf("Password keychain, file and command are mutually exclusive options")
+	}
+	if opts.PasswordKeychain != "" {
+		pwd, err := readKeychain(opts.PasswordKeychain)
+		if err == nil && pwd == "" {
+			err = errors.Fatalf("no password found in the keychain entry %q", opts.PasswordKeychain)
+		}
+		return pwd, "keyring", err
 	}
 	if opts.PasswordCommand != "" {
-		args, err := backend.SplitShellStrings(opts.PasswordCommand)
+		pwd, err := readPasswordCommand(opts.PasswordCommand)
+		return pwd, "command", err
+	}
+	if opts.PasswordFile != "" {
+		pwd, err := readPasswordFile(opts.PasswordFile)
+		return pwd, "file", err
+	}
+
+	if pwd := os.Getenv(envStr); pwd != "" {
+		return pwd, "env", nil
+	}
+
+	return "", "prompt", nil
+}
+
+// passwordSources lists the valid sources for --password-source.
+var passwordSources = []string{"command", "file", "env", "keyring", "prompt"}
+
+// parsePasswordSources parses a comma separated list of password sources.
+func parsePasswordSources(s string) ([]string, error) {
+	var sources []string
+	for _, src := range strings.Split(s, ",") {
+		src = strings.TrimSpace(src)
+
+		valid := false
+		for _, name := range passwordSources {
+			if src == name {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, errors.Fatalf("invalid password source %q, valid are %s", src, strings.Join(passwordSources, ", "))
+		}
+
+		sources = append(sources, src)
+	}
+
+	return sources, nil
+}
+
+// resolvePasswordSources tries the sources given with --password-source in
+// order and returns the first password found together with the name of its
+// source. Sources which are not configured or fail are skipped. If the sources
+// contain "prompt", an empty password is returned when it is reached, so that
+// the user is asked.
+func resolvePasswordSources(opts GlobalOptions, envStr string) (string, string, error) {
+	sources, err := parsePasswordSources(opts.PasswordSource)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, src := range sources {
+		var pwd string
+		var err error
+
+		switch src {
+		case "command":
+			if opts.PasswordCommand == "" {
+				continue
+			}
+			pwd, err = readPasswordCommand(opts.PasswordCommand)
+		case "file":
+			if opts.PasswordFile == "" {
+				continue
+			}
+			pwd, err = readPasswordFile(opts.PasswordFile)
+		case "env":
+			pwd = os.Getenv(envStr)
+		case "keyring":
+			pwd, err = readKeychain(keychainName(opts))
+		case "prompt":
+			return "", src, nil
+		}
+
 		if err != nil {
-			return "", err
+			Warnf("password source %v failed: %v\n", src, err)
+			continue
+		}
+		if pwd != "" {
+			debug.Log("using password from source %v", src)
+			return pwd, src, nil
 		}
-		cmd := exec.Command(args[0], args[1:]...)
+	}
+
+	return "", "", errors.Fatalf("no password found in the sources %v", opts.PasswordSource)
+}
+
+// readPasswordCommand runs the shell command and returns its output.
+func readPasswordCommand(command string) (string, error) {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return (strings.TrimSpace(string(output))), nil
+}
+
+// readPasswordFile returns the password stored in the file.
+func readPasswordFile(filename string) (string, error) {
+	s, err := textfile.Read(filename)
+	if os.IsNotExist(errors.Cause(err)) {
+		return "", errors.Fatalf("%s does not exist", filename)
+	}
+	return strings.TrimSpace(string(s)), errors.Wrap(err, "Readfile")
+}
+
+// keychainName returns the name of the keychain entry for the repository
+// password, which defaults to the repository location.
+func keychainName(opts GlobalOptions) string {
+	if opts.PasswordKeychain != "" {
+		return opts.PasswordKeychain
+	}
+	return opts.Repo
+}
+
+// keyCommand returns a function which runs the shell command with the data on
+// stdin and returns its output. It is used to wrap and unwrap the master key
+// with an external key management service.
+func keyCommand(command string) (repository.WrapFunc, error) {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.Fatal("empty key command")
+	}
+
+	return func(ctx context.Context, data []byte) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
 		cmd.Stderr = os.Stderr
 		output, err := cmd.Output()
 		if err != nil {
-			return "", err
+			return nil, errors.Wrapf(err, "running %q", args[0])
 		}
-		return (strings.TrimSpace(string(output))), nil
+		return output, nil
+	}, nil
+}
+
+// signCommand returns a function which signs data with the shell command. The
+// command reads the data from stdin and writes a printable signature to
+// stdout, for example by calling the transit engine of HashiCorp Vault or a
+// KMS, so that the signing key never leaves the service.
+func signCommand(command string) (func(ctx context.Context, data []byte) ([]byte, error), error) {
+	run, err := keyCommand(command)
+	if err != nil {
+		return nil, err
 	}
-	if opts.PasswordFile != "" {
-		s, err := textfile.Read(opts.PasswordFile)
-		if os.IsNotExist(errors.Cause(err)) {
-			return "", errors.Fatalf("%s does not exist", opts.PasswordFile)
+
+	return func(ctx context.Context, data []byte) ([]byte, error) {
+		sig, err := run(ctx, data)
+		if err != nil {
+			return nil, err
 		}
-		return strings.TrimSpace(string(s)), errors.Wrap(err, "Readfile")
-	}
+		sig = bytes.TrimSpace(sig)
+		if len(sig) == 0 {
+			return nil, errors.New("sign command returned an empty signature")
+		}
+		return sig, nil
+	}, nil
+}
 
-	if pwd := os.Getenv("RESTIC_PASSWORD"); pwd != "" {
-		return pwd, nil
+// verifyCommand returns a function which verifies a signature created by
+// signCommand with the shell command. The command reads the data from stdin
+// and gets the signature in the environment variable RESTIC_SIGNATURE, it
+// must exit with status zero only if the signature is valid.
+func verifyCommand(command string) (func(ctx context.Context, data, signature []byte) error, error) {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.Fatal("empty verify command")
 	}
 
-	return "", nil
+	return func(ctx context.Context, data, signature []byte) error {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), "RESTIC_SIGNATURE="+string(signature))
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "running %q", args[0])
+		}
+		return nil
+	}, nil
 }
 
 // readPassword reads the password from the given reader directly.
@@ -397,11 +723,31 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 
 	s := repository.New(be)
 
+	uploadOpts := repository.DefaultUploadOptions
+	if err := opts.extended.Extract("upload").Apply("upload", &uploadOpts); err != nil {
+		return nil, err
+	}
+	if err := s.SetUploadOptions(uploadOpts); err != nil {
+		return nil, err
+	}
+
 	passwordTriesLeft := 1
 	if stdinIsTerminal() && opts.password == "" {
 		passwordTriesLeft = 3
 	}
 
+	if opts.UnwrapCommand != "" {
+		unwrap, err := keyCommand(opts.UnwrapCommand)
+		if err != nil {
+			return nil, err
+		}
+		err = s.SearchWrappedKey(opts.ctx, unwrap, opts.KeyHint)
+		if err != nil {
+			return nil, errors.Fatalf("unable to open repository with a wrapped key: %v", err)
+		}
+		passwordTriesLeft = 0
+	}
+
 	for ; passwordTriesLeft > 0; passwordTriesLeft-- {
 		opts.password, err = ReadPassword(opts, "enter password for repository: ")
 		if err != nil && passwordTriesLeft > 1 {
@@ -413,18 +759,33 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		}
 
 		err = s.SearchKey(opts.ctx, opts.password, maxKeys, opts.KeyHint)
+		if err != nil && opts.passwordFromKeyring && errors.Cause(err) == repository.ErrNoKeyFound && stdinIsTerminal() {
+			// the stored password is outdated, ask the user instead
+			Warnf("the password from the keyring is wrong, falling back to the prompt\n")
+			opts.password = ""
+			opts.passwordFromKeyring = false
+			passwordTriesLeft = 4
+			continue
+		}
 		if err != nil && passwordTriesLeft > 1 {
 			opts.password = ""
 			fmt.Printf("%s. Try again\n", err)
 		}
 	}
 	if err != nil {
+		if errors.Cause(err) == repository.ErrNoKeyFound {
+			return nil, withExitCode(exitCodeWrongPassword, errors.Fatalf("%s", err))
+		}
 		if errors.IsFatal(err) {
 			return nil, err
 		}
 		return nil, errors.Fatalf("%s", err)
 	}
 
+	if err = checkKeyExpiry(opts, s); err != nil {
+		return nil, err
+	}
+
 	if stdoutIsTerminal() && !opts.JSON {
 		id := s.Config().ID
 		if len(id) > 8 {
@@ -435,6 +796,13 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		}
 	}
 
+	if opts.IndexOnDisk {
+		// the index is moved to the cache directory below if possible
+		if err = s.UseIndexOnDisk(os.TempDir()); err != nil {
+			return nil, err
+		}
+	}
+
 	if opts.NoCache {
 		return s, nil
 	}
@@ -452,6 +820,12 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	// start using the cache
 	s.UseCache(c)
 
+	if opts.IndexOnDisk {
+		if err = s.UseIndexOnDisk(c.Path); err != nil {
+			return nil, err
+		}
+	}
+
 	oldCacheDirs, err := cache.Old(c.Base)
 	if err != nil {
 		Warnf("unable to find old cache directories: %v", err)
@@ -483,6 +857,46 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	return s, nil
 }
 
+// checkKeyExpiry warns if the key used to open the repository has expired, or
+// refuses to use it if the repository is configured to do so.
+func checkKeyExpiry(opts GlobalOptions, s *repository.Repository) error {
+	k, err := repository.LoadKey(opts.ctx, s, s.KeyName())
+	if err != nil {
+		return err
+	}
+
+	expires, ok := k.ExpiresAt(maxKeyAge(s.Config()))
+	if !ok || time.Now().Before(expires) {
+		return nil
+	}
+
+	id := s.KeyName()
+	if len(id) > 8 {
+		id = id[:8]
+	}
+
+	if s.Config().RefuseExpiredKeys && !opts.allowExpiredKey {
+		return errors.Fatalf("key %v expired on %v, replace it using \"restic key passwd\"", id, expires.Local().Format(TimeFormat))
+	}
+
+	Warnf("key %v expired on %v, please replace it using \"restic key passwd\"\n", id, expires.Local().Format(TimeFormat))
+	return nil
+}
+
+// requireAdminKey returns an error if the key used to open the repository has
+// a scope which does not allow the command, which removes data or keys.
+func requireAdminKey(opts GlobalOptions, s *repository.Repository, command string) error {
+	k, err := repository.LoadKey(opts.ctx, s, s.KeyName())
+	if err != nil {
+		return err
+	}
+
+	if !k.IsAdmin() {
+		return errors.Fatalf("%v is not allowed with a key of scope %q, use a key of scope %q", command, k.Scope, repository.ScopeAdmin)
+	}
+	return nil
+}
+
 func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
 	// only apply options for a particular backend here
 	opts = opts.Extract(loc.Scheme)
@@ -617,6 +1031,44 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 }
 
+// TLSOptions are the extended options for the HTTPS connections of the
+// backends, which can be set per repository in the option file.
+type TLSOptions struct {
+	CACert     string `option:"cacert" help:"file to load root certificates from, in addition to --cacert"`
+	ServerPins string `option:"server-sha256-pin" help:"comma separated SHA-256 fingerprints of pinned server certificates, in addition to --tls-server-sha256-pin"`
+}
+
+func init() {
+	options.Register("tls", TLSOptions{})
+}
+
+// transportOptions returns the settings for the HTTP transport from the global
+// flags and the extended options in the namespace "tls".
+func transportOptions(opts options.Options) (backend.TransportOptions, error) {
+	// copy the lists, the values from the options are appended
+	tropts := backend.TransportOptions{
+		RootCertFilenames:        append([]string(nil), globalOptions.CACerts...),
+		TLSClientCertKeyFilename: globalOptions.TLSClientCert,
+		ServerCertPins:           append([]string(nil), globalOptions.TLSServerPins...),
+	}
+
+	var tlsOpts TLSOptions
+	if err := opts.Extract("tls").Apply("tls", &tlsOpts); err != nil {
+		return backend.TransportOptions{}, err
+	}
+
+	if tlsOpts.CACert != "" {
+		tropts.RootCertFilenames = append(tropts.RootCertFilenames, tlsOpts.CACert)
+	}
+	for _, pin := range strings.Split(tlsOpts.ServerPins, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			tropts.ServerCertPins = append(tropts.ServerCertPins, pin)
+		}
+	}
+
+	return tropts, nil
+}
+
 // Open the backend specified by a location config.
 func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", s)
@@ -632,9 +1084,9 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		return nil, err
 	}
 
-	tropts := backend.TransportOptions{
-		RootCertFilenames:        globalOptions.CACerts,
-		TLSClientCertKeyFilename: globalOptions.TLSClientCert,
+	tropts, err := transportOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 	rt, err := backend.Transport(tropts)
 	if err != nil {
@@ -674,13 +1126,13 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 	}
 
 	if err != nil {
-		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
+		return nil, withExitCode(exitCodeBackendUnavailable, errors.Fatalf("unable to open repo at %v: %v", s, err))
 	}
 
 	// check if config is there
 	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
+		return nil, withExitCode(exitCodeBackendUnavailable, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s))
 	}
 
 	if fi.Size == 0 {
@@ -703,9 +1155,9 @@ func create(s string, opts options.Options) (restic.Backend, error) {
 		return nil, err
 	}
 
-	tropts := backend.TransportOptions{
-		RootCertFilenames:        globalOptions.CACerts,
-		TLSClientCertKeyFilename: globalOptions.TLSClientCert,
+	tropts, err := transportOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 	rt, err := backend.Transport(tropts)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 
+	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 
@@ -21,6 +22,8 @@ var (
 	traceProfilePath string
 	blockProfilePath string
 	insecure         bool
+	debugModules     []string
+	debugPaused      bool
 )
 
 func init() {
@@ -31,6 +34,8 @@ func init() {
 	f.StringVar(&traceProfilePath, "trace-profile", "", "write trace to `dir`")
 	f.StringVar(&blockProfilePath, "block-profile", "", "write block profile to `dir`")
 	f.BoolVar(&insecure, "insecure-kdf", false, "use insecure KDF settings")
+	f.StringSliceVar(&debugModules, "debug", nil, "only write debug messages of the `modules` to the debug log, e.g. archiver,backend.s3")
+	f.BoolVar(&debugPaused, "debug-paused", false, "start with the debug log paused, send SIGUSR1 to resume it")
 }
 
 type fakeTestingTB struct{}
@@ -40,6 +45,16 @@ func (fakeTestingTB) Logf(msg string, args ...interface{}) {
 }
 
 func runDebug() error {
+	if len(debugModules) > 0 {
+		if err := debug.SetModules(debugModules); err != nil {
+			return errors.Fatal(err.Error())
+		}
+	}
+
+	if debugPaused {
+		debug.SetPaused(true)
+	}
+
 	if listenProfile != "" {
 		fmt.Fprintf(os.Stderr, "running profile HTTP server on %v\n", listenProfile)
 		go func() {
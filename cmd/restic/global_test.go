@@ -2,8 +2,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/restic/restic/internal/errors"
 	rtest "github.com/restic/restic/internal/test"
 )
 
@@ -26,3 +31,145 @@ func Test_PrintFunctionsRespectsGlobalStdout(t *testing.T) {
 		buf.Reset()
 	}
 }
+
+func TestVerbosefJSONWritesToStderr(t *testing.T) {
+	gopts := globalOptions
+	defer func() {
+		globalOptions = gopts
+	}()
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	globalOptions.stdout = stdout
+	globalOptions.stderr = stderr
+	globalOptions.verbosity = 1
+	globalOptions.JSON = true
+
+	Verbosef("mes%s\n", "sage")
+	rtest.Equals(t, "", stdout.String())
+	rtest.Equals(t, "message\n", stderr.String())
+}
+
+func TestPrintJSONExitError(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	printJSONExitError(buf, 1, errors.New("repository contains errors"))
+
+	var msg exitErrorMessage
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &msg))
+	rtest.Equals(t, exitErrorMessage{
+		MessageType: "exit_error",
+		Code:        1,
+		Message:     "repository contains errors",
+	}, msg)
+}
+
+func TestResolvePasswordSources(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	pwfile := filepath.Join(tempdir, "password")
+	rtest.OK(t, ioutil.WriteFile(pwfile, []byte("from-file\n"), 0600))
+
+	rtest.OK(t, os.Setenv("RESTIC_TEST_SOURCE_PASSWORD", "from-env"))
+	defer func() {
+		rtest.OK(t, os.Unsetenv("RESTIC_TEST_SOURCE_PASSWORD"))
+	}()
+
+	var tests = []struct {
+		sources  string
+		file     string
+		password string
+		err      bool
+	}{
+		{"file,env", pwfile, "from-file", false},
+		{"env,file", pwfile, "from-env", false},
+		{"command,file", pwfile, "from-file", false},
+		{"file,env", filepath.Join(tempdir, "missing"), "from-env", false},
+		{"file,prompt", filepath.Join(tempdir, "missing"), "", false},
+		{"file", filepath.Join(tempdir, "missing"), "", true},
+		{"file,foo", pwfile, "", true},
+	}
+
+	for _, test := range tests {
+		opts := GlobalOptions{PasswordSource: test.sources, PasswordFile: test.file}
+		password, err := resolvePassword(opts, "RESTIC_TEST_SOURCE_PASSWORD")
+		if test.err {
+			rtest.Assert(t, err != nil, "sources %q: expected error, got none", test.sources)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.password, password)
+	}
+}
+
+func TestResolvePasswordSourceName(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	pwfile := filepath.Join(tempdir, "password")
+	rtest.OK(t, ioutil.WriteFile(pwfile, []byte("from-file\n"), 0600))
+
+	var tests = []struct {
+		opts   GlobalOptions
+		source string
+	}{
+		{GlobalOptions{PasswordFile: pwfile}, "file"},
+		{GlobalOptions{PasswordSource: "file,prompt", PasswordFile: pwfile}, "file"},
+		{GlobalOptions{PasswordSource: "prompt"}, "prompt"},
+		{GlobalOptions{}, "prompt"},
+	}
+
+	for _, test := range tests {
+		_, source, err := resolvePasswordSource(test.opts, "RESTIC_TEST_UNSET_PASSWORD")
+		rtest.OK(t, err)
+		rtest.Equals(t, test.source, source)
+	}
+}
+
+func TestResolvePasswordKeychainExclusive(t *testing.T) {
+	for _, opts := range []GlobalOptions{
+		{PasswordKeychain: "backup", PasswordFile: "/etc/restic/password"},
+		{PasswordKeychain: "backup", PasswordCommand: "pass show backup"},
+	} {
+		_, err := resolvePassword(opts, "RESTIC_PASSWORD")
+		rtest.Assert(t, err != nil, "keychain with file or command was accepted: %+v", opts)
+	}
+}
+
+func TestOptionFile(t *testing.T) {
+	gopts := globalOptions
+	defer func() {
+		globalOptions = gopts
+	}()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "options")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte(`
+# per repository settings
+tls.cacert = /etc/restic/ca.pem
+tls.server-sha256-pin=aa:bb, cc
+s3.region=eu-west-1
+`), 0600))
+
+	opts, err := parseExtendedOptions(GlobalOptions{
+		Options:    []string{"s3.region=us-east-1"},
+		OptionFile: filename,
+	})
+	rtest.OK(t, err)
+
+	// the command line takes precedence
+	rtest.Equals(t, "us-east-1", opts["s3.region"])
+
+	globalOptions.CACerts = []string{"/etc/ssl/ca.pem"}
+	globalOptions.TLSServerPins = []string{"dd"}
+	tropts, err := transportOptions(opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/etc/ssl/ca.pem", "/etc/restic/ca.pem"}, tropts.RootCertFilenames)
+	rtest.Equals(t, []string{"dd", "aa:bb", "cc"}, tropts.ServerCertPins)
+	rtest.Equals(t, []string{"dd"}, globalOptions.TLSServerPins)
+
+	_, err = parseExtendedOptions(GlobalOptions{OptionFile: filepath.Join(tempdir, "missing")})
+	rtest.Assert(t, err != nil, "missing option file was accepted")
+}
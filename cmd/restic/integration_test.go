@@ -13,11 +13,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
@@ -50,11 +53,15 @@ func testRunInit(t testing.TB, opts GlobalOptions) {
 	restic.TestDisableCheckPolynomial(t)
 	restic.TestSetLockTimeout(t, 0)
 
-	rtest.OK(t, runInit(opts, nil))
+	rtest.OK(t, runInit(InitOptions{}, opts, nil))
 	t.Logf("repository initialized at %v", opts.Repo)
 }
 
 func testRunBackup(t testing.TB, dir string, target []string, opts BackupOptions, gopts GlobalOptions) {
+	rtest.OK(t, testRunBackupAssumeFailure(t, dir, target, opts, gopts))
+}
+
+func testRunBackupAssumeFailure(t testing.TB, dir string, target []string, opts BackupOptions, gopts GlobalOptions) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
@@ -69,7 +76,7 @@ func testRunBackup(t testing.TB, dir string, target []string, opts BackupOptions
 		defer cleanup()
 	}
 
-	rtest.OK(t, runBackup(opts, gopts, term, target))
+	backupErr := runBackup(opts, gopts, term, target)
 
 	cancel()
 
@@ -77,6 +84,8 @@ func testRunBackup(t testing.TB, dir string, target []string, opts BackupOptions
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	return backupErr
 }
 
 func testRunList(t testing.TB, tpe string, opts GlobalOptions) restic.IDs {
@@ -214,6 +223,15 @@ func testRunSnapshots(t testing.TB, gopts GlobalOptions) (newest *Snapshot, snap
 	return
 }
 
+func testRunStats(t testing.TB, opts StatsOptions, gopts GlobalOptions, args []string, v interface{}) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	rtest.OK(t, runStats(opts, gopts, args))
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), v))
+}
+
 func testRunForget(t testing.TB, gopts GlobalOptions, args ...string) {
 	opts := ForgetOptions{}
 	rtest.OK(t, runForget(opts, gopts, args))
@@ -249,7 +267,7 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 }
 
 func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+	rtest.OK(t, runPrune(PruneOptions{MaxUnused: "0%"}, gopts))
 }
 
 func TestBackup(t *testing.T) {
@@ -317,6 +335,88 @@ func TestBackup(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestBackupQuota(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	rtest.OK(t, runConfigSet(env.gopts, []string{"max-repo-size", "100k"}))
+	rtest.Equals(t, uint64(100*1024), testRunConfig(t, env.gopts).MaxRepoSize)
+
+	opts := BackupOptions{}
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, backend.IsQuotaExceeded(errors.Cause(err)),
+		"expected quota exceeded error, got %v", err)
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+
+	rtest.OK(t, runConfigSet(env.gopts, []string{"max-repo-size", "none"}))
+	rtest.Equals(t, uint64(0), testRunConfig(t, env.gopts).MaxRepoSize)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	// prune removes the data saved by the aborted backup
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+}
+
+func testRunConfig(t testing.TB, gopts GlobalOptions) restic.Config {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	return repo.Config()
+}
+
+func TestStats(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// back up the same data from two hosts
+	for _, host := range []string{"host1", "host2"} {
+		opts := BackupOptions{Host: host}
+		testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	}
+
+	var restoreSize, hostRestoreSize, rawData statsContainer
+	testRunStats(t, StatsOptions{Mode: countModeRestoreSize}, env.gopts, nil, &restoreSize)
+	testRunStats(t, StatsOptions{Mode: countModeRestoreSize, Hosts: []string{"host1"}}, env.gopts, nil, &hostRestoreSize)
+	testRunStats(t, StatsOptions{Mode: countModeRawData}, env.gopts, nil, &rawData)
+
+	rtest.Assert(t, hostRestoreSize.TotalSize > 0, "expected restore size > 0")
+	rtest.Equals(t, 2*hostRestoreSize.TotalSize, restoreSize.TotalSize)
+	rtest.Equals(t, 2*hostRestoreSize.TotalFileCount, restoreSize.TotalFileCount)
+	rtest.Assert(t, rawData.TotalBlobCount > 0, "expected blob count > 0")
+	rtest.Assert(t, rawData.TotalSize < restoreSize.TotalSize,
+		"raw data size %d is not smaller than the restore size %d", rawData.TotalSize, restoreSize.TotalSize)
+
+	// the data of both snapshots is deduplicated
+	var perSnapshot struct {
+		Snapshots []statsSnapshot `json:"snapshots"`
+		Total     statsContainer  `json:"total"`
+	}
+	testRunStats(t, StatsOptions{Mode: countModeRawData, PerSnapshot: true}, env.gopts, nil, &perSnapshot)
+	rtest.Equals(t, 2, len(perSnapshot.Snapshots))
+	for _, sn := range perSnapshot.Snapshots {
+		rtest.Assert(t, sn.TotalSize > 0 && sn.TotalSize <= rawData.TotalSize,
+			"invalid raw data size %d for snapshot %v, total is %d", sn.TotalSize, sn.ShortID, rawData.TotalSize)
+	}
+	rtest.Equals(t, rawData.TotalSize, perSnapshot.Total.TotalSize)
+}
+
 func TestBackupNonExistingFile(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -607,6 +707,150 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func TestProtectSnapshot(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunTag(t, TagOptions{Protect: true}, env.gopts)
+
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest != nil, "expected a new backup, got nil")
+	rtest.Assert(t, newest.Protected, "snapshot is not protected")
+
+	// neither an explicit forget nor a policy removes the snapshot
+	testRunForget(t, env.gopts, newest.ID.String())
+	rtest.OK(t, runForget(ForgetOptions{KeepTags: restic.TagLists{{"foo"}}}, env.gopts, nil))
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	testRunTag(t, TagOptions{Unprotect: true}, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, !newest.Protected, "snapshot is still protected")
+
+	testRunForget(t, env.gopts, newest.ID.String())
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+}
+
+func testAuditCommands(t testing.TB, gopts GlobalOptions) []string {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+
+	entries, err := loadAuditEntries(gopts.ctx, repo)
+	rtest.OK(t, err)
+
+	var commands []string
+	for _, e := range entries {
+		rtest.Equals(t, repo.KeyName(), e.KeyID)
+		commands = append(commands, e.Command)
+	}
+	return commands
+}
+
+func TestAuditLog(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// a new repository has no audit log
+	rtest.Equals(t, 0, len(testAuditCommands(t, env.gopts)))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.Equals(t, 0, len(testAuditCommands(t, env.gopts)))
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	rtest.Equals(t, []string{"forget"}, testAuditCommands(t, env.gopts))
+
+	testRunKeyAddNewKey(t, "geheim2", env.gopts)
+	testRunKeyRemove(t, env.gopts, testRunKeyListOtherIDs(t, env.gopts))
+	rtest.Equals(t, []string{"forget", "key remove"}, testAuditCommands(t, env.gopts))
+
+	rtest.OK(t, runAudit(env.gopts, []string{"log"}))
+}
+
+func TestVerifyChain(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// snapshots are only chained with --chain
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	snapshots, err := restic.LoadAllSnapshots(env.gopts.ctx, repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, snapshots[0].Previous == nil, "snapshot has previous snapshot %v without --chain", snapshots[0].Previous)
+	testRunForget(t, env.gopts, snapshots[0].ID().String())
+
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{env.testdata}, BackupOptions{Chain: true}, env.gopts)
+	}
+
+	snapshots, err = restic.LoadAllSnapshots(env.gopts.ctx, repo)
+	rtest.OK(t, err)
+	sort.Sort(restic.Snapshots(snapshots))
+
+	rtest.Assert(t, snapshots[0].Previous == nil, "first snapshot has previous snapshot %v", snapshots[0].Previous)
+	for i := 1; i < len(snapshots); i++ {
+		rtest.Equals(t, snapshots[i-1].ID(), snapshots[i].Previous)
+	}
+	rtest.OK(t, runVerifyChain(VerifyChainOptions{}, env.gopts))
+
+	// forget records the removal in the audit log
+	testRunForget(t, env.gopts, snapshots[0].ID().String())
+	rtest.OK(t, runVerifyChain(VerifyChainOptions{}, env.gopts))
+
+	// a snapshot removed from the storage directly is detected
+	h := restic.Handle{Type: restic.SnapshotFile, Name: snapshots[1].ID().String()}
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, h))
+	rtest.Assert(t, runVerifyChain(VerifyChainOptions{}, env.gopts) != nil, "removed snapshot was not detected")
+}
+
+func TestVerifyChainSignatures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signing commands use sh")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// the checksum of the data serves as a signature
+	env.gopts.SignCommand = "sh -c 'cksum'"
+	globalOptions.SignCommand = env.gopts.SignCommand
+	defer func() {
+		globalOptions.SignCommand = ""
+	}()
+	opts := VerifyChainOptions{
+		VerifyCommand: `sh -c 'test "$(cksum)" = "$RESTIC_SIGNATURE"'`,
+	}
+
+	for i := 0; i < 2; i++ {
+		testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	}
+	testRunForget(t, env.gopts, testRunList(t, "snapshots", env.gopts)[0].String())
+	rtest.OK(t, runVerifyChain(opts, env.gopts))
+
+	// snapshots created without signing are reported
+	env.gopts.SignCommand = ""
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.Assert(t, runVerifyChain(opts, env.gopts) != nil, "unsigned snapshot was not detected")
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -683,6 +927,230 @@ func TestKeyAddRemove(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func testRunKeyList(t testing.TB, gopts GlobalOptions) string {
+	buf := bytes.NewBuffer(nil)
+
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runKey(gopts, []string{"list"}))
+	return buf.String()
+}
+
+func TestKeyKDFParams(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	defer func() {
+		newKDFParams = ""
+	}()
+
+	newKDFParams = "argon2id:t=1,m=64,p=1"
+	testRunKeyPasswd(t, "geheim2", env.gopts)
+	env.gopts.password = "geheim2"
+
+	rtest.Assert(t, strings.Contains(testRunKeyList(t, env.gopts), newKDFParams),
+		"key list does not show the argon2id parameters")
+
+	newKDFParams = "scrypt:N=1024,r=1,p=1"
+	rtest.OK(t, runKey(env.gopts, []string{"rotate-master"}))
+
+	out := testRunKeyList(t, env.gopts)
+	rtest.Assert(t, strings.Contains(out, newKDFParams),
+		"key list does not show the new scrypt parameters")
+	rtest.Assert(t, !strings.Contains(out, "argon2id"),
+		"old key was not removed")
+	rtest.Equals(t, 0, len(testRunKeyListOtherIDs(t, env.gopts)))
+
+	newKDFParams = "invalid"
+	rtest.Assert(t, runKey(env.gopts, []string{"add"}) != nil,
+		"adding a key with invalid KDF parameters did not fail")
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyCalibrate(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	defer func() {
+		newKDFParams = ""
+	}()
+
+	newKDFParams = "argon2id:m=64,p=1"
+	rtest.Assert(t, runKey(env.gopts, []string{"calibrate"}) != nil,
+		"calibrate without a target time did not fail")
+
+	newKDFParams = "argon2id:m=64,p=1,ms=10"
+	rtest.OK(t, runKey(env.gopts, []string{"calibrate"}))
+
+	params := testRunConfig(t, env.gopts).KDFParams
+	rtest.Assert(t, strings.HasPrefix(params, "argon2id:t="),
+		"calibrated parameters %q were not stored", params)
+
+	newKDFParams = ""
+	testRunKeyAddNewKey(t, "OnnyiasyatvodsEvVodyawit", env.gopts)
+	rtest.Assert(t, strings.Count(testRunKeyList(t, env.gopts), params) == 1,
+		"new key does not use the calibrated parameters")
+
+	rtest.Assert(t, runConfigSet(env.gopts, []string{"kdf-params", "scrypt:ms=500"}) != nil,
+		"setting kdf-params with a target time did not fail")
+	rtest.OK(t, runConfigSet(env.gopts, []string{"kdf-params", "default"}))
+	rtest.Equals(t, "", testRunConfig(t, env.gopts).KDFParams)
+}
+
+func TestKeyExpiry(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, runConfigSet(env.gopts, []string{"max-key-age", "1y"}))
+	rtest.OK(t, runConfigSet(env.gopts, []string{"expired-keys", "refuse"}))
+	cfg := testRunConfig(t, env.gopts)
+	rtest.Equals(t, "1y", cfg.MaxKeyAge)
+	rtest.Assert(t, cfg.RefuseExpiredKeys, "expired-keys was not set to refuse")
+
+	rtest.Assert(t, runConfigSet(env.gopts, []string{"max-key-age", "0d"}) != nil,
+		"setting a zero key age did not fail")
+	rtest.Assert(t, runConfigSet(env.gopts, []string{"expired-keys", "ignore"}) != nil,
+		"setting an invalid value for expired-keys did not fail")
+
+	defer func() {
+		newKeyExpiresAfter = restic.Duration{}
+	}()
+
+	newKeyExpiresAfter = restic.Duration{Days: 30}
+	testRunKeyPasswd(t, "geheim2", env.gopts)
+	env.gopts.password = "geheim2"
+
+	expires := time.Now().AddDate(0, 0, 30).Local().Format("2006-01-02")
+	rtest.Assert(t, strings.Contains(testRunKeyList(t, env.gopts), expires),
+		"key list does not show the expiry %v", expires)
+
+	rtest.OK(t, runConfigSet(env.gopts, []string{"max-key-age", "none"}))
+	rtest.Equals(t, "", testRunConfig(t, env.gopts).MaxKeyAge)
+}
+
+func TestKeyScope(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	defer func() {
+		newKeyScope = ""
+	}()
+
+	newKeyScope = repository.ScopeBackup
+	testRunKeyAddNewKey(t, "geheim2", env.gopts)
+	newKeyScope = ""
+
+	adminOpts := env.gopts
+	env.gopts.password = "geheim2"
+	rtest.Assert(t, strings.Contains(testRunKeyList(t, env.gopts), repository.ScopeBackup),
+		"key list does not show the backup scope")
+
+	// a backup key can create snapshots, but not remove them
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(snapshotIDs))
+
+	rtest.Assert(t, runForget(ForgetOptions{}, env.gopts, []string{snapshotIDs[0].String()}) != nil,
+		"forget with a backup key did not fail")
+	rtest.Assert(t, runKey(env.gopts, []string{"add"}) != nil,
+		"adding a key with a backup key did not fail")
+
+	newKeyScope = repository.ScopeAdmin
+	rtest.Assert(t, runKey(env.gopts, []string{"passwd"}) != nil,
+		"extending the scope of a backup key did not fail")
+	newKeyScope = ""
+
+	testRunForget(t, adminOpts, snapshotIDs[0].String())
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+}
+
+func testRunApprove(t testing.TB, gopts GlobalOptions, args ...string) string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runApprove(ApproveOptions{ValidFor: time.Hour}, gopts, args))
+	return strings.TrimSpace(buf.String())
+}
+
+func TestApproval(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	testRunKeyAddNewKey(t, "geheim2", env.gopts)
+
+	other := env.gopts
+	other.password = "geheim2"
+
+	rtest.OK(t, runConfigSet(env.gopts, []string{"require-approval", "yes"}))
+
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "0%"}, env.gopts) != nil,
+		"prune without approval did not fail")
+
+	// the token must be created with a different key
+	env.gopts.Approval = testRunApprove(t, env.gopts, "prune")
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "0%"}, env.gopts) != nil,
+		"prune approved by the same key did not fail")
+
+	// the token only allows the approved operation
+	env.gopts.Approval = testRunApprove(t, other, "key-remove")
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "0%"}, env.gopts) != nil,
+		"prune with a token for key-remove did not fail")
+
+	env.gopts.Approval = testRunApprove(t, other, "prune")
+	testRunPrune(t, env.gopts)
+
+	// each token can only be used once
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "0%"}, env.gopts) != nil,
+		"prune with a token which was already used did not fail")
+
+	// forget without --prune needs an approval, too
+	env.gopts.Approval = ""
+	rtest.Assert(t, runForget(ForgetOptions{}, env.gopts, nil) != nil,
+		"forget without approval did not fail")
+	env.gopts.Approval = testRunApprove(t, other, "forget")
+	rtest.OK(t, runForget(ForgetOptions{}, env.gopts, nil))
+
+	// a token for key-remove only allows removing the approved key
+	testRunKeyAddNewKey(t, "geheim3", env.gopts)
+	third := env.gopts
+	third.password = "geheim3"
+	keyID := func(gopts GlobalOptions) string {
+		repo, err := OpenRepository(gopts)
+		rtest.OK(t, err)
+		return repo.KeyName()
+	}
+	thirdID := keyID(third)
+
+	env.gopts.Approval = testRunApprove(t, other, "key-remove", thirdID[:8])
+	rtest.Assert(t, runKey(env.gopts, []string{"remove", keyID(other)}) != nil,
+		"removing a key which was not approved did not fail")
+	rtest.OK(t, runKey(env.gopts, []string{"remove", thirdID}))
+
+	env.gopts.Approval = testRunApprove(t, other, "config")
+	rtest.OK(t, runConfigSet(env.gopts, []string{"require-approval", "no"}))
+	env.gopts.Approval = ""
+
+	testRunPrune(t, env.gopts)
+}
+
 func testFileSize(filename string, size int64) error {
 	fi, err := os.Stat(filename)
 	if err != nil {
@@ -746,6 +1214,53 @@ func TestRestoreFilter(t *testing.T) {
 	}
 }
 
+func TestRestoreFilesFrom(t *testing.T) {
+	testfiles := []struct {
+		name     string
+		restored bool
+	}{
+		{"testfile1.c", true},
+		{"testfile2.exe", false},
+		{"subdir1/subdir2/testfile3.docx", true},
+		{"subdir1/subdir2/testfile4.c", true},
+		{"subdir1/testfile5.c", false},
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	for _, testFile := range testfiles {
+		p := filepath.Join(env.testdata, testFile.name)
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, 100))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	list := filepath.Join(env.base, "files-from")
+	rtest.OK(t, ioutil.WriteFile(list, []byte("# files to restore\n/testdata/testfile1.c\n\n/testdata/subdir1/subdir2\n"), 0644))
+
+	base := filepath.Join(env.base, "restore")
+	opts := RestoreOptions{
+		Target:    base,
+		FilesFrom: []string{list},
+	}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotID.String()}))
+
+	for _, testFile := range testfiles {
+		err := testFileSize(filepath.Join(base, "testdata", testFile.name), 100)
+		if testFile.restored {
+			rtest.OK(t, err)
+		} else {
+			rtest.Assert(t, os.IsNotExist(errors.Cause(err)),
+				"expected %v to not exist, but it exists, err %v", testFile.name, err)
+		}
+	}
+}
+
 func TestRestore(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1002,8 +1517,8 @@ func TestRebuildIndex(t *testing.T) {
 		t.Fatalf("expected no error from checker for test repository, got %v", err)
 	}
 
-	if !strings.Contains(out, "restic rebuild-index") {
-		t.Fatalf("did not find hint for rebuild-index command")
+	if !strings.Contains(out, "restic repair index") {
+		t.Fatalf("did not find hint for repair index command")
 	}
 
 	testRunRebuildIndex(t, env.gopts)
@@ -1023,6 +1538,232 @@ func TestRebuildIndexAlwaysFull(t *testing.T) {
 	TestRebuildIndex(t)
 }
 
+func TestRepairIndexReadAllPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("..", "..", "internal", "checker", "testdata", "duplicate-packs-in-index-test-repo.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
+
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+	rtest.OK(t, runRepairIndex(RepairIndexOptions{ReadAllPacks: true}, env.gopts))
+
+	out, err := testRunCheckOutput(env.gopts)
+	if len(out) != 0 {
+		t.Fatalf("expected no output from the checker, got: %v", out)
+	}
+
+	if err != nil {
+		t.Fatalf("expected no error from checker after repair index, got: %v", err)
+	}
+}
+
+func TestRepairSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "small-repo.tar.gz")
+	rtest.SetupTarTestFixture(t, env.base, datafile)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+
+	// remove the largest pack file to simulate damage
+	var largest string
+	var size int64
+	err := filepath.Walk(filepath.Join(env.repo, "data"), func(p string, fi os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if fi.Mode().IsRegular() && fi.Size() > size {
+			largest, size = p, fi.Size()
+		}
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, os.Remove(largest))
+
+	testRunRebuildIndex(t, env.gopts)
+
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{Forget: true}, env.gopts, nil))
+
+	_, err = testRunCheckOutput(env.gopts)
+	if err != nil {
+		t.Fatalf("expected no error from checker after repair snapshots, got: %v", err)
+	}
+
+	rtest.Equals(t, len(snapshotIDs), len(testRunList(t, "snapshots", env.gopts)))
+}
+
+func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions) {
+	copyOpts := CopyOptions{
+		SourceRepoOptions: SourceRepoOptions{
+			FromRepo: srcGopts.Repo,
+			password: srcGopts.password,
+		},
+	}
+
+	rtest.OK(t, runCopy(copyOpts, dstGopts, nil))
+}
+
+func TestCopy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	testRunInit(t, env2.gopts)
+	testRunCopy(t, env.gopts, env2.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+	rtest.Equals(t, len(snapshotIDs), len(copiedSnapshotIDs))
+
+	testRunCheck(t, env2.gopts)
+
+	// restore the copied snapshots and compare them to the original data
+	for i, snapshotID := range copiedSnapshotIDs {
+		restoredir := filepath.Join(env2.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env2.gopts, restoredir, snapshotID)
+		rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+			"directories are not equal")
+	}
+
+	// copying again must not create new snapshots
+	testRunCopy(t, env.gopts, env2.gopts)
+	rtest.Equals(t, len(copiedSnapshotIDs), len(testRunList(t, "snapshots", env2.gopts)))
+}
+
+func TestCopyRechunk(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	// both repositories use a random chunker polynomial
+	testRunInit(t, env2.gopts)
+
+	copyOpts := CopyOptions{
+		SourceRepoOptions: SourceRepoOptions{
+			FromRepo: env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		Rechunk: true,
+	}
+	rtest.OK(t, runCopy(copyOpts, env2.gopts, nil))
+
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+	rtest.Equals(t, 1, len(copiedSnapshotIDs))
+	testRunCheck(t, env2.gopts)
+
+	restoredir := filepath.Join(env2.base, "restore")
+	testRunRestore(t, env2.gopts, restoredir, copiedSnapshotIDs[0])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+
+	// the rechunked snapshot has a different tree, but is still found
+	rtest.OK(t, runCopy(copyOpts, env2.gopts, nil))
+	rtest.Equals(t, copiedSnapshotIDs, testRunList(t, "snapshots", env2.gopts))
+}
+
+func TestInitCopyChunkerParams(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testRunInit(t, env.gopts)
+
+	initOpts := InitOptions{
+		SourceRepoOptions: SourceRepoOptions{
+			FromRepo: env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		CopyChunkerParams: true,
+	}
+	rtest.OK(t, runInit(initOpts, env2.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	repo2, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, repo.Config().ChunkerPolynomial, repo2.Config().ChunkerPolynomial)
+}
+
+// testMirrorEqual checks that both repositories contain the same files.
+func testMirrorEqual(t testing.TB, gopts, mirrorGopts GlobalOptions) {
+	for _, fileType := range []string{"snapshots", "index", "packs", "keys"} {
+		want := testRunList(t, fileType, gopts)
+		got := testRunList(t, fileType, mirrorGopts)
+		sort.Sort(want)
+		sort.Sort(got)
+		rtest.Equals(t, want, got)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	opts := ReplicateOptions{To: env2.gopts.Repo}
+	rtest.OK(t, runReplicate(opts, env.gopts))
+
+	// the mirror is accessible with the same password
+	testRunCheck(t, env2.gopts)
+	testMirrorEqual(t, env.gopts, env2.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	testRunPrune(t, env.gopts)
+
+	// without --delete, removed files are kept in the mirror
+	rtest.OK(t, runReplicate(opts, env.gopts))
+	rtest.Equals(t, len(snapshotIDs), len(testRunList(t, "snapshots", env2.gopts)))
+
+	opts.Delete = true
+	rtest.OK(t, runReplicate(opts, env.gopts))
+	testRunCheck(t, env2.gopts)
+	testMirrorEqual(t, env.gopts, env2.gopts)
+}
+
 func TestCheckRestoreNoLock(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1086,6 +1827,60 @@ func TestPrune(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+// testDuplicateBlobs stores all data blobs of the repository a second time.
+func testDuplicateBlobs(t testing.TB, gopts GlobalOptions) {
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(gopts.ctx))
+
+	var blobs []restic.PackedBlob
+	for pb := range repo.Index().Each(gopts.ctx) {
+		if pb.Type == restic.DataBlob {
+			blobs = append(blobs, pb)
+		}
+	}
+
+	for _, pb := range blobs {
+		buf, err := repo.LoadBlob(gopts.ctx, pb.Type, pb.ID, nil)
+		rtest.OK(t, err)
+		_, err = repo.SaveBlob(gopts.ctx, pb.Type, buf, pb.ID)
+		rtest.OK(t, err)
+	}
+
+	rtest.OK(t, repo.Flush(gopts.ctx))
+	rtest.OK(t, repo.SaveIndex(gopts.ctx))
+}
+
+func TestPruneDuplicates(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+
+	var before statsContainer
+	testRunStats(t, StatsOptions{Mode: countModeRawData}, env.gopts, nil, &before)
+	rtest.Equals(t, uint64(0), before.DuplicateBlobCount)
+
+	testDuplicateBlobs(t, env.gopts)
+
+	var duplicated statsContainer
+	testRunStats(t, StatsOptions{Mode: countModeRawData}, env.gopts, nil, &duplicated)
+	rtest.Assert(t, duplicated.DuplicateBlobCount > 0, "no duplicate blobs found")
+	rtest.Equals(t, before.TotalBlobCount, duplicated.TotalBlobCount)
+
+	// prune removes the duplicates without any snapshot being forgotten
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	var after statsContainer
+	testRunStats(t, StatsOptions{Mode: countModeRawData}, env.gopts, nil, &after)
+	rtest.Equals(t, uint64(0), after.DuplicateBlobCount)
+	rtest.Equals(t, before.TotalBlobCount, after.TotalBlobCount)
+}
+
 func TestHardLink(t *testing.T) {
 	// this test assumes a test set with a single directory containing hard linked files
 	env, cleanup := withTestEnvironment(t)
@@ -1218,3 +2013,198 @@ func TestQuietBackup(t *testing.T) {
 
 	testRunCheck(t, env.gopts)
 }
+
+func TestInitJSON(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, runInit(InitOptions{}, gopts, nil))
+
+	var summary initSummary
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &summary))
+	rtest.Equals(t, "initialized", summary.MessageType)
+	rtest.Equals(t, env.gopts.Repo, summary.Repository)
+	rtest.Assert(t, len(summary.ID) == 64, "invalid repository ID %q", summary.ID)
+}
+
+// testRunJSON runs fn with --json and returns the JSON values printed to stdout.
+func testRunJSON(t testing.TB, gopts GlobalOptions, fn func(GlobalOptions) error) []json.RawMessage {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	gopts.stdout = buf
+	gopts.JSON = true
+	rtest.OK(t, fn(gopts))
+
+	var values []json.RawMessage
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var v json.RawMessage
+		rtest.OK(t, dec.Decode(&v))
+		values = append(values, v)
+	}
+	rtest.Assert(t, len(values) > 0, "no JSON output found")
+	return values
+}
+
+// testMessageTypes returns the message types of the JSON messages in values.
+func testMessageTypes(t testing.TB, values []json.RawMessage) []string {
+	var types []string
+	for _, v := range values {
+		var msg struct {
+			MessageType string `json:"message_type"`
+		}
+		rtest.OK(t, json.Unmarshal(v, &msg))
+		types = append(types, msg.MessageType)
+	}
+	return types
+}
+
+func TestJSONOutput(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.testdata, "data")
+	rtest.OK(t, os.MkdirAll(datadir, 0700))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "file"), []byte("content"), 0600))
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "other"), []byte("other content"), 0600))
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+	sort.Slice(snapshotIDs, func(i, j int) bool {
+		return snapshotIDs[i].String() < snapshotIDs[j].String()
+	})
+
+	values := testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runDiff(DiffOptions{}, gopts, []string{snapshotIDs[0].String(), snapshotIDs[1].String()})
+	})
+	types := testMessageTypes(t, values)
+	rtest.Equals(t, "statistics", types[len(types)-1])
+	for _, tpe := range types[:len(types)-1] {
+		rtest.Equals(t, "change", tpe)
+	}
+
+	values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runList(cmdList, gopts, []string{"snapshots"})
+	})
+	rtest.Equals(t, len(snapshotIDs), len(values))
+	for _, tpe := range testMessageTypes(t, values) {
+		rtest.Equals(t, "file", tpe)
+	}
+
+	for _, args := range [][]string{nil, {"max-repo-size"}} {
+		values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+			return runConfigGet(gopts, args)
+		})
+		rtest.Equals(t, 1, len(values))
+		var settings map[string]string
+		rtest.OK(t, json.Unmarshal(values[0], &settings))
+		_, ok := settings["max-repo-size"]
+		rtest.Assert(t, ok, "max-repo-size not found in %v", settings)
+	}
+
+	for _, opts := range []CacheOptions{{}, {Stats: true}} {
+		values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+			return runCache(opts, gopts, nil)
+		})
+		rtest.Equals(t, 1, len(values))
+		var dirs []map[string]interface{}
+		rtest.OK(t, json.Unmarshal(values[0], &dirs))
+	}
+
+	values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runMigrate(MigrateOptions{}, gopts, nil)
+	})
+	rtest.Equals(t, 1, len(values))
+	var available []availableMigration
+	rtest.OK(t, json.Unmarshal(values[0], &available))
+
+	values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runRepairSnapshots(RepairSnapshotsOptions{DryRun: true}, gopts, nil)
+	})
+	rtest.Equals(t, []string{"snapshot", "snapshot", "summary"}, testMessageTypes(t, values))
+
+	values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runPrune(PruneOptions{MaxUnused: "0%"}, gopts)
+	})
+	rtest.Equals(t, []string{"summary"}, testMessageTypes(t, values))
+
+	testKeyNewPassword = "new password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+	values = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runKey(gopts, []string{"add"})
+	})
+	rtest.Equals(t, 1, len(values))
+	var key keyChange
+	rtest.OK(t, json.Unmarshal(values[0], &key))
+	rtest.Equals(t, "key", key.MessageType)
+	rtest.Equals(t, "add", key.Action)
+	rtest.Assert(t, len(key.ID) == 64, "invalid key ID %q", key.ID)
+}
+
+func testRunDiffOutput(t testing.TB, gopts GlobalOptions, firstSnapshotID string, secondSnapshotID string) string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runDiff(DiffOptions{}, gopts, []string{firstSnapshotID, secondSnapshotID}))
+	return buf.String()
+}
+
+func TestDiffLocal(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.testdata, "data")
+	rtest.OK(t, os.MkdirAll(filepath.Join(datadir, "subdir"), 0700))
+	for _, name := range []string{"modified", "removed", "unchanged", filepath.Join("subdir", "file")} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, name), []byte(name), 0600))
+	}
+
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "modified"), []byte("new content"), 0600))
+	rtest.OK(t, os.Remove(filepath.Join(datadir, "removed")))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "added"), []byte("added"), 0600))
+
+	out := testRunDiffOutput(t, env.gopts, snapshotIDs[0].String(), localDiffPrefix+datadir)
+	for _, line := range []string{"+    /added\n", "M    /modified\n", "-    /removed\n"} {
+		rtest.Assert(t, strings.Contains(out, line), "line %q not found in output:\n%s", line, out)
+	}
+	for _, item := range []string{"/unchanged", "/subdir"} {
+		rtest.Assert(t, !strings.Contains(out, item), "unchanged item %q reported in output:\n%s", item, out)
+	}
+	rtest.Assert(t, strings.Contains(out, "Files:           1 new,     1 removed,     1 changed"),
+		"unexpected statistics in output:\n%s", out)
+
+	// compare a directory within the snapshot to another local directory
+	otherdir := filepath.Join(env.testdata, "other")
+	rtest.OK(t, os.MkdirAll(otherdir, 0700))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(otherdir, "file"), []byte(filepath.Join("subdir", "file")), 0600))
+
+	subdir := snapshotPath(filepath.Join(datadir, "subdir"))
+	out = testRunDiffOutput(t, env.gopts, snapshotIDs[0].String()+":"+subdir, localDiffPrefix+otherdir)
+	rtest.Assert(t, !strings.Contains(out, "/file"), "unchanged file reported in output:\n%s", out)
+}
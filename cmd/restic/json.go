@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonVersion is the version of the JSON messages restic prints when --json
+// is set. It is increased when a message is changed in an incompatible way,
+// new fields and new message types do not change the version.
+const jsonVersion = 1
+
+// exitErrorMessage is printed to stderr in JSON mode when a command fails.
+type exitErrorMessage struct {
+	MessageType string `json:"message_type"` // "exit_error"
+	Code        int    `json:"code"`
+	ErrorCode   string `json:"error_code"`
+	Message     string `json:"message"`
+}
+
+// printJSONExitError writes err as a JSON message to wr.
+func printJSONExitError(wr io.Writer, code int, err error) {
+	msg := exitErrorMessage{
+		MessageType: "exit_error",
+		Code:        code,
+		ErrorCode:   string(errorCodeFor(err)),
+		Message:     err.Error(),
+	}
+
+	if e := json.NewEncoder(wr).Encode(msg); e != nil {
+		Warnf("JSON encode failed: %v\n", e)
+	}
+}
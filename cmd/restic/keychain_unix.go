@@ -0,0 +1,64 @@
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// readKeychain returns the password stored under name for the service
+// "restic" in the keychain of the operating system. It uses the Keychain on
+// macOS and the freedesktop Secret Service on other systems. An empty password
+// is returned if there is no entry.
+func readKeychain(name string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", "restic", "-a", name, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		cmd = exec.Command("secret-tool", "lookup", "service", "restic", "repository", name)
+	default:
+		return "", errors.Errorf("keychain is not supported on %v", runtime.GOOS)
+	}
+
+	output, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); ok {
+		debug.Log("no keychain entry for %v: %v", name, err)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// storeKeychain saves the password under name for the service "restic" in the
+// keychain of the operating system, replacing an existing entry. The password
+// is passed on stdin, so that it does not show up in the process list.
+func storeKeychain(name, password string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// "security -i" reads the command from stdin
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader("add-generic-password -U -s restic -a \"" + quote.Replace(name) +
+			"\" -w \"" + quote.Replace(password) + "\"\n")
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		cmd = exec.Command("secret-tool", "store", "--label", "restic "+name, "service", "restic", "repository", name)
+		cmd.Stdin = strings.NewReader(password)
+	default:
+		return errors.Errorf("keychain is not supported on %v", runtime.GOOS)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Errorf("storing the password failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
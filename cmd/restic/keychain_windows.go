@@ -0,0 +1,108 @@
+// +build windows
+
+package main
+
+import (
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+var advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+var (
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = 1168
+)
+
+// credential is the CREDENTIALW structure of the Windows Credential Manager.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keychainTarget returns the name of the generic credential for name.
+func keychainTarget(name string) (*uint16, error) {
+	return syscall.UTF16PtrFromString("restic:" + name)
+}
+
+// readKeychain returns the password stored under name in the Windows
+// Credential Manager, as the generic credential "restic:<name>". An empty
+// password is returned if there is no entry.
+func readKeychain(name string) (string, error) {
+	target, err := keychainTarget(name)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	res, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if res == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+			debug.Log("no keychain entry for %v", name)
+			return "", nil
+		}
+		return "", errors.Errorf("CredRead: %v", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	// the password is stored as UTF-16, like credentials created by cmdkey
+	blob := make([]uint16, cred.CredentialBlobSize/2)
+	for i := range blob {
+		blob[i] = *(*uint16)(unsafe.Pointer(uintptr(unsafe.Pointer(cred.CredentialBlob)) + uintptr(2*i)))
+	}
+	return string(utf16.Decode(blob)), nil
+}
+
+// storeKeychain saves the password under name in the Windows Credential
+// Manager, replacing an existing entry.
+func storeKeychain(name, password string) error {
+	target, err := keychainTarget(name)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	blob := utf16.Encode([]rune(password))
+	if len(blob) == 0 {
+		return errors.New("an empty password is not a password")
+	}
+
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(2 * len(blob)),
+		CredentialBlob:     (*byte)(unsafe.Pointer(&blob[0])),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	res, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if res == 0 {
+		return errors.Errorf("CredWrite: %v", err)
+	}
+	return nil
+}
@@ -46,7 +46,7 @@ func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock,
 		globalLocks.cancelRefresh = make(chan struct{})
 		globalLocks.refreshWG = sync.WaitGroup{}
 		globalLocks.refreshWG.Add(1)
-		go refreshLocks(&globalLocks.refreshWG, globalLocks.cancelRefresh)
+		go refreshLocks(&globalLocks.refreshWG, globalLocks.cancelRefresh, cancelGlobalContext)
 	}
 
 	globalLocks.locks = append(globalLocks.locks, lock)
@@ -57,7 +57,16 @@ func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock,
 
 var refreshInterval = 5 * time.Minute
 
-func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}) {
+// refreshLock refreshes a single lock, it is replaced in tests.
+var refreshLock = func(ctx context.Context, lock *restic.Lock) error {
+	return lock.Refresh(ctx)
+}
+
+// refreshLocks refreshes all locks every refreshInterval until done is
+// closed. If a lock cannot be refreshed before other processes consider it
+// stale and may remove it, the command must not continue without the lock, so
+// cancel is called to abort it.
+func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}, cancel context.CancelFunc) {
 	debug.Log("start")
 	defer func() {
 		wg.Done()
@@ -67,6 +76,7 @@ func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}) {
 	}()
 
 	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
@@ -75,14 +85,26 @@ func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}) {
 			return
 		case <-ticker.C:
 			debug.Log("refreshing locks")
+			lost := false
 			globalLocks.Lock()
 			for _, lock := range globalLocks.locks {
-				err := lock.Refresh(context.TODO())
+				lastRefresh := lock.Time
+				err := refreshLock(context.TODO(), lock)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "unable to refresh lock: %v\n", err)
+					if time.Since(lastRefresh) > restic.StaleLockTimeout-refreshInterval {
+						fmt.Fprintf(os.Stderr, "the lock was last refreshed at %v and will be considered stale by other processes, aborting\n",
+							lastRefresh.Format(TimeFormat))
+						lost = true
+					}
 				}
 			}
 			globalLocks.Unlock()
+
+			if lost {
+				cancel()
+				return
+			}
 		}
 	}
 }
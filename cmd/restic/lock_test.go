@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func testRefreshLocks(lock *restic.Lock) (ctx context.Context, stop func()) {
+	globalLocks.Lock()
+	globalLocks.locks = append(globalLocks.locks, lock)
+	globalLocks.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go refreshLocks(&wg, done, cancel)
+
+	return ctx, func() {
+		close(done)
+		wg.Wait()
+		cancel()
+
+		globalLocks.Lock()
+		globalLocks.locks = globalLocks.locks[:0]
+		globalLocks.Unlock()
+	}
+}
+
+func TestRefreshLocksFailure(t *testing.T) {
+	defer func(interval time.Duration, refresh func(context.Context, *restic.Lock) error) {
+		refreshInterval = interval
+		refreshLock = refresh
+	}(refreshInterval, refreshLock)
+
+	refreshInterval = 10 * time.Millisecond
+	refreshLock = func(context.Context, *restic.Lock) error {
+		return errors.New("backend unavailable")
+	}
+
+	// a recently refreshed lock is kept although the refresh fails
+	ctx, stop := testRefreshLocks(&restic.Lock{Time: time.Now()})
+	select {
+	case <-ctx.Done():
+		t.Fatal("context was cancelled although the lock is not stale yet")
+	case <-time.After(10 * refreshInterval):
+	}
+	stop()
+
+	// the command is aborted once the lock cannot be refreshed in time
+	ctx, stop = testRefreshLocks(&restic.Lock{Time: time.Now().Add(-restic.StaleLockTimeout)})
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("context was not cancelled after the lock could not be refreshed")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// logFileBackups is the number of rotated log files which are kept, as
+// <file>.1 to <file>.5.
+const logFileBackups = 5
+
+// runLogger writes the messages of a restic run to the file set with
+// --log-file and to syslog with --log-syslog.
+type runLogger struct {
+	m       sync.Mutex
+	format  string
+	command string
+	file    *rotatingFile
+	syslog  syslogWriter
+}
+
+// syslogWriter is implemented by *syslog.Writer.
+type syslogWriter interface {
+	Info(msg string) error
+	Warning(msg string) error
+	Err(msg string) error
+	Close() error
+}
+
+// runLog is set in the PersistentPreRunE function of the root command when
+// logging is enabled.
+var runLog *runLogger
+
+// openRunLog returns the logger for command, or nil if neither --log-file nor
+// --log-syslog is set.
+func openRunLog(gopts GlobalOptions, command string) (*runLogger, error) {
+	switch gopts.LogFormat {
+	case "text", "json":
+	default:
+		return nil, errors.Fatalf("invalid log format %q, must be text or json", gopts.LogFormat)
+	}
+
+	if gopts.LogFile == "" && !gopts.LogSyslog {
+		return nil, nil
+	}
+
+	l := &runLogger{
+		format:  gopts.LogFormat,
+		command: command,
+	}
+
+	if gopts.LogFile != "" {
+		maxSize, err := parseSizeStr(gopts.LogMaxSize)
+		if err != nil {
+			return nil, errors.Fatalf("invalid value for --log-max-size: %v", err)
+		}
+
+		l.file, err = openRotatingFile(gopts.LogFile, maxSize)
+		if err != nil {
+			return nil, errors.Fatalf("unable to open log file: %v", err)
+		}
+	}
+
+	if gopts.LogSyslog {
+		w, err := openSyslog()
+		if err != nil {
+			if l.file != nil {
+				_ = l.file.Close()
+			}
+			return nil, err
+		}
+		l.syslog = w
+	}
+
+	return l, nil
+}
+
+// logEntry is written to the log in JSON format.
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Command string    `json:"command"`
+	PID     int       `json:"pid"`
+	Message string    `json:"message"`
+}
+
+// Log writes the message with level "info", "warning" or "error".
+func (l *runLogger) Log(level, msg string) {
+	if l == nil {
+		return
+	}
+
+	msg = strings.TrimRight(msg, "\r\n")
+	if msg == "" {
+		return
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := time.Now()
+
+	var line string
+	if l.format == "json" {
+		buf, err := json.Marshal(logEntry{
+			Time:    now,
+			Level:   level,
+			Command: l.command,
+			PID:     os.Getpid(),
+			Message: msg,
+		})
+		if err != nil {
+			return
+		}
+		line = string(buf)
+	} else {
+		line = fmt.Sprintf("[%s] %s: %s", l.command, level, msg)
+	}
+
+	if l.file != nil {
+		entry := line
+		if l.format == "text" {
+			entry = now.Format(TimeFormat) + " " + line
+		}
+		if _, err := l.file.Write([]byte(entry + "\n")); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to log file: %v\n", err)
+		}
+	}
+
+	if l.syslog != nil {
+		var err error
+		switch level {
+		case "error":
+			err = l.syslog.Err(line)
+		case "warning":
+			err = l.syslog.Warning(line)
+		default:
+			err = l.syslog.Info(line)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to syslog: %v\n", err)
+		}
+	}
+}
+
+// Close closes the log file and the connection to syslog.
+func (l *runLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+	}
+	if l.syslog != nil {
+		if serr := l.syslog.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// logf formats the message and writes it to the log, if enabled.
+func logf(level, format string, args ...interface{}) {
+	runLog.Log(level, fmt.Sprintf(format, args...))
+}
+
+// rotatingFile appends to a file, which is rotated when it would exceed
+// maxSize bytes. A maxSize of zero disables the rotation.
+type rotatingFile struct {
+	filename string
+	maxSize  int64
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(filename string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{
+		filename: filename,
+		maxSize:  maxSize,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := fs.OpenFile(r.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// rotate renames the file to <file>.1, the previous <file>.1 to <file>.2 and
+// so on, and opens a new file.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	_ = fs.Remove(fmt.Sprintf("%s.%d", r.filename, logFileBackups))
+	for i := logFileBackups - 1; i >= 1; i-- {
+		err := fs.Rename(fmt.Sprintf("%s.%d", r.filename, i), fmt.Sprintf("%s.%d", r.filename, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := fs.Rename(r.filename, r.filename+".1"); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+// Write appends p to the file.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
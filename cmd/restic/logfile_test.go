@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRunLogJSON(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	gopts := GlobalOptions{
+		LogFile:    filepath.Join(tempdir, "restic.log"),
+		LogFormat:  "json",
+		LogMaxSize: "0",
+	}
+
+	l, err := openRunLog(gopts, "backup")
+	rtest.OK(t, err)
+	l.Log("warning", "unable to read file\n")
+	rtest.OK(t, l.Close())
+
+	buf, err := ioutil.ReadFile(gopts.LogFile)
+	rtest.OK(t, err)
+
+	var entry logEntry
+	rtest.OK(t, json.Unmarshal(buf, &entry))
+	rtest.Equals(t, "warning", entry.Level)
+	rtest.Equals(t, "backup", entry.Command)
+	rtest.Equals(t, "unable to read file", entry.Message)
+}
+
+func TestRunLogRotate(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "restic.log")
+	r, err := openRotatingFile(filename, 100)
+	rtest.OK(t, err)
+
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < logFileBackups+3; i++ {
+		_, err = r.Write([]byte(line))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, r.Close())
+
+	buf, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, line, string(buf))
+
+	for i := 1; i <= logFileBackups; i++ {
+		_, err = ioutil.ReadFile(fmt.Sprintf("%s.%d", filename, i))
+		rtest.OK(t, err)
+	}
+
+	_, err = ioutil.ReadFile(fmt.Sprintf("%s.%d", filename, logFileBackups+1))
+	rtest.Assert(t, err != nil, "expected at most %d rotated files", logFileBackups)
+}
+
+func TestRunLogInvalidFormat(t *testing.T) {
+	_, err := openRunLog(GlobalOptions{LogFormat: "xml"}, "backup")
+	rtest.Assert(t, err != nil, "expected error for invalid log format")
+}
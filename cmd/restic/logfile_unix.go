@@ -0,0 +1,18 @@
+// +build !windows
+
+package main
+
+import (
+	"log/syslog"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// openSyslog connects to the local syslog daemon.
+func openSyslog() (syslogWriter, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "restic")
+	if err != nil {
+		return nil, errors.Fatalf("unable to connect to syslog: %v", err)
+	}
+	return w, nil
+}
@@ -0,0 +1,10 @@
+// +build windows
+
+package main
+
+import "github.com/restic/restic/internal/errors"
+
+// openSyslog returns an error, syslog is not available on Windows.
+func openSyslog() (syslogWriter, error) {
+	return nil, errors.Fatal("--log-syslog is not supported on Windows")
+}
@@ -7,9 +7,10 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
-	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
@@ -46,20 +47,36 @@ directories in an encrypted repository stored on different backends.
 		}
 
 		// parse extended options
-		opts, err := options.Parse(globalOptions.Options)
+		opts, err := parseExtendedOptions(globalOptions)
 		if err != nil {
 			return err
 		}
 		globalOptions.extended = opts
+
+		runLog, err = openRunLog(globalOptions, strings.TrimPrefix(c.CommandPath(), "restic "))
+		if err != nil {
+			return err
+		}
+		AddCleanupHandler(runLog.Close)
+		logf("info", "starting %s", c.CommandPath())
+
+		systemd = openSystemdNotifier()
+		AddCleanupHandler(func() error {
+			systemd.Stopping()
+			return systemd.Close()
+		})
+		systemd.Ready()
+		go systemd.RunWatchdog(globalOptions.ctx, watchdogInterval())
+
 		if c.Name() == "version" {
 			return nil
 		}
-		pwd, err := resolvePassword(globalOptions)
+		pwd, src, err := resolvePasswordSource(globalOptions, "RESTIC_PASSWORD")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Resolving password failed: %v\n", err)
-			Exit(1)
+			return errors.Fatalf("Resolving password failed: %v", err)
 		}
 		globalOptions.password = pwd
+		globalOptions.passwordFromKeyring = src == "keyring"
 
 		// run the debug functions for all subcommands (if build tag "debug" is
 		// enabled)
@@ -85,9 +102,21 @@ func main() {
 		version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	err := cmdRoot.Execute()
 
+	exitCode := exitCodeFor(err)
+
+	if err != nil {
+		logf("error", "%v (exit code %d)", err, exitCode)
+	} else {
+		logf("info", "finished successfully")
+	}
+
 	switch {
+	case err != nil && globalOptions.JSON:
+		printJSONExitError(os.Stderr, exitCode, err)
 	case restic.IsAlreadyLocked(errors.Cause(err)):
 		fmt.Fprintf(os.Stderr, "%v\nthe `unlock` command can be used to remove stale locks\n", err)
+	case backend.IsQuotaExceeded(errors.Cause(err)):
+		fmt.Fprintf(os.Stderr, "%v\nthe backup was aborted, run `prune` to remove the data saved so far\n", err)
 	case errors.IsFatal(errors.Cause(err)):
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	case err != nil:
@@ -102,10 +131,5 @@ func main() {
 		}
 	}
 
-	var exitCode int
-	if err != nil {
-		exitCode = 1
-	}
-
 	Exit(exitCode)
 }
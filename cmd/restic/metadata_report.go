@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// metadataReport collects the items for which data recorded in the snapshot
+// could not be restored on this platform.
+type metadataReport struct {
+	m      sync.Mutex
+	Items  []metadataReportItem `json:"items"`
+	counts map[string]int
+}
+
+type metadataReportItem struct {
+	Path        string   `json:"path"`
+	Unsupported []string `json:"unsupported"`
+}
+
+// add records that kinds could not be restored for the item at location.
+func (r *metadataReport) add(location string, kinds []string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.counts == nil {
+		r.counts = make(map[string]int)
+	}
+
+	r.Items = append(r.Items, metadataReportItem{Path: location, Unsupported: kinds})
+	for _, kind := range kinds {
+		r.counts[kind]++
+	}
+}
+
+// summary returns the number of items per kind of data which could not be
+// restored.
+func (r *metadataReport) summary() string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var kinds []string
+	for kind, count := range r.counts {
+		kinds = append(kinds, fmt.Sprintf("%s (%d items)", kind, count))
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+// save writes the report as JSON to filename.
+func (r *metadataReport) save(filename string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Fatalf("unable to create metadata report: %v", err)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(r)
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Encode")
+	}
+
+	return errors.Wrap(f.Close(), "Close")
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// metrics collects values about a run of a command, which are written in the
+// Prometheus text format to the file set with --metrics-file and pushed to
+// the Pushgateway set with --metrics-push-url. They are also included in the
+// summary sent to the URLs set with --webhook-url and in the email report
+// configured with --email-config, and --notify shows the result on the
+// desktop. All methods can be called on a nil *metrics, which does nothing.
+type metrics struct {
+	command  string
+	start    time.Time
+	values   []metricValue
+	be       restic.Backend
+	webhooks []*template.Template
+	email    *emailConfig
+	notify   string
+
+	// errors is guarded by errorsMu, since AddError may be called
+	// concurrently
+	errorsMu sync.Mutex
+	errors   []string
+}
+
+// maxReportedErrors is the maximum number of errors included in the email
+// report.
+const maxReportedErrors = 100
+
+type metricValue struct {
+	name, help string
+	value      float64
+}
+
+// newMetrics returns the metrics for command, or nil if none of
+// --metrics-file, --metrics-push-url, --webhook-url, --email-config and
+// --notify is set. The start of the command is sent to the webhooks.
+func newMetrics(gopts GlobalOptions, command string) (*metrics, error) {
+	if err := checkNotify(gopts.Notify); err != nil {
+		return nil, err
+	}
+
+	if gopts.MetricsFile == "" && gopts.MetricsPushURL == "" && len(gopts.WebhookURLs) == 0 && gopts.EmailConfig == "" && gopts.Notify == "" {
+		return nil, nil
+	}
+
+	webhooks, err := parseWebhookURLs(gopts.WebhookURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &metrics{
+		command:  command,
+		start:    time.Now(),
+		webhooks: webhooks,
+		notify:   gopts.Notify,
+	}
+
+	if gopts.EmailConfig != "" {
+		m.email, err = loadEmailConfig(gopts.EmailConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sendWebhooks(gopts.ctx, m.webhooks, webhookEvent{
+		Event:   "start",
+		Command: command,
+		Time:    m.start,
+	})
+
+	return m, nil
+}
+
+// Set records the metric restic_<command>_<name>.
+func (m *metrics) Set(name, help string, value float64) {
+	if m == nil {
+		return
+	}
+
+	m.values = append(m.values, metricValue{
+		name:  name,
+		help:  help,
+		value: value,
+	})
+}
+
+// AddError records an error for item, which did not abort the command.
+func (m *metrics) AddError(item string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.errorsMu.Lock()
+	defer m.errorsMu.Unlock()
+
+	if len(m.errors) < maxReportedErrors {
+		m.errors = append(m.errors, fmt.Sprintf("%v: %v", item, err))
+	}
+}
+
+// SetRepository records the backend of the repository, the size of the
+// repository is reported when the command has finished.
+func (m *metrics) SetRepository(repo restic.Repository) {
+	if m == nil {
+		return
+	}
+
+	m.be = repo.Backend()
+}
+
+// Finish records the duration and the result of the command and writes the
+// metrics. Errors which occur while writing the metrics are printed, err is
+// returned unchanged.
+func (m *metrics) Finish(gopts GlobalOptions, err error) error {
+	if m == nil {
+		return err
+	}
+
+	exitCode := exitCodeFor(err)
+
+	// a backup which could not read some files has still created a snapshot
+	status := "success"
+	switch {
+	case err != nil && exitCode != exitCodeSkippedFiles:
+		status = "failure"
+	case err != nil || len(m.errors) > 0:
+		status = "warning"
+	}
+
+	success := 0.0
+	if status != "failure" {
+		success = 1
+	}
+
+	m.Set("duration_seconds", "Duration of the last run in seconds.", time.Since(m.start).Seconds())
+	m.Set("success", "Whether the last run was successful (1) or failed (0).", success)
+	m.Set("exit_code", "Exit code of the last run.", float64(exitCode))
+	m.Set("last_run_timestamp_seconds", "Time of the last run as a Unix timestamp.", float64(m.start.Unix()))
+
+	if m.be != nil {
+		size, serr := backend.Size(gopts.ctx, m.be)
+		if serr != nil {
+			Warnf("unable to determine the repository size for the metrics: %v\n", serr)
+		} else {
+			m.Set("repository_size_bytes", "Size of all files in the repository in bytes.", float64(size))
+		}
+	}
+
+	buf := m.format()
+
+	if gopts.MetricsFile != "" {
+		if werr := writeMetricsFile(gopts.MetricsFile, buf); werr != nil {
+			Warnf("unable to write metrics file: %v\n", werr)
+		}
+	}
+
+	if gopts.MetricsPushURL != "" {
+		if perr := pushMetrics(gopts.ctx, gopts.MetricsPushURL, m.command, buf); perr != nil {
+			Warnf("unable to push metrics: %v\n", perr)
+		}
+	}
+
+	ev := webhookEvent{
+		Event:    status,
+		Command:  m.command,
+		Time:     time.Now(),
+		Duration: time.Since(m.start).Seconds(),
+		ExitCode: exitCode,
+		Summary:  make(map[string]float64, len(m.values)),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	for _, v := range m.values {
+		ev.Summary[v.name] = v.value
+	}
+	sendWebhooks(gopts.ctx, m.webhooks, ev)
+
+	if m.email != nil {
+		report := emailReport{
+			Command:  m.command,
+			Hostname: ev.Hostname,
+			Status:   status,
+			Start:    m.start,
+			Duration: time.Since(m.start),
+			ExitCode: ev.ExitCode,
+			Error:    ev.Error,
+			Values:   m.values,
+			Errors:   m.errors,
+		}
+		if report.Hostname == "" {
+			report.Hostname, _ = os.Hostname()
+		}
+
+		if eerr := sendEmailReport(m.email, report); eerr != nil {
+			Warnf("unable to send email report: %v\n", eerr)
+		}
+	}
+
+	if m.notify != "" && statusLevel[status] >= statusLevel[m.notify] {
+		n := newDesktopNotification(m.command, status, time.Since(m.start), err)
+		if nerr := notifyDesktop(gopts.ctx, n); nerr != nil {
+			Warnf("unable to show desktop notification: %v\n", nerr)
+		}
+	}
+
+	return err
+}
+
+// format returns the metrics in the Prometheus text format.
+func (m *metrics) format() []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, v := range m.values {
+		name := "restic_" + m.command + "_" + v.name
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, v.help)
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(buf, "%s %s\n", name, strconv.FormatFloat(v.value, 'f', -1, 64))
+	}
+	return buf.Bytes()
+}
+
+// writeMetricsFile replaces the file filename with buf. The data is written
+// to a temporary file first, so that the textfile collector of node_exporter
+// never reads an incomplete file.
+func writeMetricsFile(filename string, buf []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// pushMetrics replaces the metrics of the job "restic" for command and this
+// host on the Prometheus Pushgateway at pushURL.
+func pushMetrics(ctx context.Context, pushURL, command string, buf []byte) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	target := strings.TrimSuffix(pushURL, "/") + "/metrics/job/restic" +
+		"/instance/" + url.PathEscape(hostname) +
+		"/command/" + url.PathEscape(command)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected response from %v: %v", pushURL, res.Status)
+	}
+
+	return nil
+}
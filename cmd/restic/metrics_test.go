@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMetricsFile(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	gopts := globalOptions
+	gopts.MetricsFile = filepath.Join(tempdir, "restic.prom")
+
+	m, err := newMetrics(gopts, "backup")
+	rtest.OK(t, err)
+	m.Set("files_new", "Number of new files in the last run.", 23)
+	err = m.Finish(gopts, errors.New("backup failed"))
+	rtest.Assert(t, err != nil, "expected the error of the command to be returned")
+
+	buf, err := ioutil.ReadFile(gopts.MetricsFile)
+	rtest.OK(t, err)
+
+	for _, line := range []string{
+		"# HELP restic_backup_files_new Number of new files in the last run.\n",
+		"# TYPE restic_backup_files_new gauge\n",
+		"restic_backup_files_new 23\n",
+		"restic_backup_success 0\n",
+	} {
+		rtest.Assert(t, strings.Contains(string(buf), line), "line %q not found in metrics:\n%s", line, buf)
+	}
+}
+
+func TestMetricsPush(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadAll(req.Body)
+		method, path, body = req.Method, req.URL.Path, string(buf)
+	}))
+	defer srv.Close()
+
+	gopts := globalOptions
+	gopts.MetricsPushURL = srv.URL
+
+	m, err := newMetrics(gopts, "prune")
+	rtest.OK(t, err)
+	rtest.OK(t, m.Finish(gopts, nil))
+
+	rtest.Equals(t, http.MethodPut, method)
+	rtest.Assert(t, strings.HasPrefix(path, "/metrics/job/restic/instance/"), "unexpected path %q", path)
+	rtest.Assert(t, strings.HasSuffix(path, "/command/prune"), "unexpected path %q", path)
+	rtest.Assert(t, strings.Contains(body, "restic_prune_success 1\n"), "unexpected body:\n%s", body)
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	m, err := newMetrics(GlobalOptions{}, "backup")
+	rtest.OK(t, err)
+	rtest.Assert(t, m == nil, "expected no metrics without --metrics-file and --metrics-push-url")
+
+	m.Set("files_new", "", 1)
+	err = errors.New("test")
+	rtest.Equals(t, err, m.Finish(GlobalOptions{}, err))
+}
@@ -0,0 +1,26 @@
+// +build darwin windows
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// webdavMountUser is the user name clients of a WebDAV mount authenticate
+// with.
+const webdavMountUser = "restic"
+
+// newWebDAVMountPassword returns a random password for a WebDAV mount, so
+// that other users of the local host cannot access the repository via the
+// port the mount is served on.
+func newWebDAVMountPassword() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+	return hex.EncodeToString(buf), nil
+}
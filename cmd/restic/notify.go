@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// notificationTimeout is the maximum time the program which shows a desktop
+// notification may take.
+const notificationTimeout = 10 * time.Second
+
+// desktopNotification is shown after a command has finished.
+type desktopNotification struct {
+	Title   string
+	Body    string
+	Failure bool
+}
+
+// notifyDesktop shows n on the desktop, it is replaced in tests.
+var notifyDesktop = func(ctx context.Context, n desktopNotification) error {
+	ctx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	defer cancel()
+
+	return showDesktopNotification(ctx, n)
+}
+
+// checkNotify returns an error if when is not a valid value for --notify.
+func checkNotify(when string) error {
+	switch when {
+	case "", "always", "warning", "failure":
+		return nil
+	default:
+		return errors.Fatalf("invalid value %q for --notify, must be always, warning or failure", when)
+	}
+}
+
+// newDesktopNotification returns the notification for a run of command which
+// finished with status after duration.
+func newDesktopNotification(command, status string, duration time.Duration, err error) desktopNotification {
+	n := desktopNotification{
+		Body: "finished after " + formatDuration(duration),
+	}
+
+	switch status {
+	case "failure":
+		n.Title = "restic " + command + " failed"
+		n.Failure = true
+	case "warning":
+		n.Title = "restic " + command + " finished with warnings"
+	default:
+		n.Title = "restic " + command + " finished"
+	}
+
+	if err != nil {
+		n.Body = err.Error()
+	}
+
+	return n
+}
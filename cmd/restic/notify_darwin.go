@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// showDesktopNotification shows n in the Notification Center. The text is
+// passed in environment variables, so that it needs not be quoted for
+// AppleScript.
+func showDesktopNotification(ctx context.Context, n desktopNotification) error {
+	script := `display notification (system attribute "RESTIC_NOTIFY_BODY") with title (system attribute "RESTIC_NOTIFY_TITLE")`
+	if n.Failure {
+		script += ` sound name "Basso"`
+	}
+
+	cmd := exec.CommandContext(ctx, "osascript", "-e", script)
+	cmd.Env = append(os.Environ(), "RESTIC_NOTIFY_TITLE="+n.Title, "RESTIC_NOTIFY_BODY="+n.Body)
+	return cmd.Run()
+}
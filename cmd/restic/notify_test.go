@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNotify(t *testing.T) {
+	var notifications []desktopNotification
+	defer func(prev func(context.Context, desktopNotification) error) {
+		notifyDesktop = prev
+	}(notifyDesktop)
+	notifyDesktop = func(ctx context.Context, n desktopNotification) error {
+		notifications = append(notifications, n)
+		return nil
+	}
+
+	gopts := globalOptions
+	gopts.Notify = "warning"
+
+	m, err := newMetrics(gopts, "backup")
+	rtest.OK(t, err)
+	rtest.OK(t, m.Finish(gopts, nil))
+	rtest.Equals(t, 0, len(notifications))
+
+	m, err = newMetrics(gopts, "backup")
+	rtest.OK(t, err)
+	err = m.Finish(gopts, errors.New("repository not found"))
+	rtest.Assert(t, err != nil, "expected the error of the command to be returned")
+
+	rtest.Equals(t, []desktopNotification{{
+		Title:   "restic backup failed",
+		Body:    "repository not found",
+		Failure: true,
+	}}, notifications)
+
+	gopts.Notify = "sometimes"
+	_, err = newMetrics(gopts, "backup")
+	rtest.Assert(t, err != nil, "expected error for invalid --notify")
+}
+
+func TestNewDesktopNotification(t *testing.T) {
+	n := newDesktopNotification("prune", "success", 90*time.Second, nil)
+	rtest.Equals(t, "restic prune finished", n.Title)
+	rtest.Equals(t, "finished after "+formatDuration(90*time.Second), n.Body)
+	rtest.Assert(t, !n.Failure, "successful run reported as failure")
+
+	n = newDesktopNotification("backup", "warning", time.Second, errors.New("at least one source file could not be read"))
+	rtest.Equals(t, "restic backup finished with warnings", n.Title)
+	rtest.Equals(t, "at least one source file could not be read", n.Body)
+}
@@ -0,0 +1,29 @@
+// +build !windows,!darwin
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// showDesktopNotification sends n to the notification daemon via D-Bus, using
+// notify-send or, if it is not installed, gdbus.
+func showDesktopNotification(ctx context.Context, n desktopNotification) error {
+	urgency := "normal"
+	if n.Failure {
+		urgency = "critical"
+	}
+
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return exec.CommandContext(ctx, "notify-send", "--app-name=restic", "--urgency="+urgency, "--", n.Title, n.Body).Run()
+	}
+
+	// call org.freedesktop.Notifications.Notify(app_name, replaces_id,
+	// app_icon, summary, body, actions, hints, expire_timeout) directly
+	return exec.CommandContext(ctx, "gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"restic", "0", "", n.Title, n.Body, "[]", "{}", "-1").Run()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"unicode/utf16"
+)
+
+// toastScript shows a toast notification with the title and text from the
+// environment. It uses the application ID of PowerShell, since restic is not
+// registered as an application.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$title = [Security.SecurityElement]::Escape($env:RESTIC_NOTIFY_TITLE)
+$body = [Security.SecurityElement]::Escape($env:RESTIC_NOTIFY_BODY)
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml("<toast><visual><binding template='ToastGeneric'><text>$title</text><text>$body</text></binding></visual></toast>")
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+// encodePowerShellCommand returns script encoded for -EncodedCommand, which
+// avoids quoting it on the command line.
+func encodePowerShellCommand(script string) string {
+	codes := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(codes))
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(buf[2*i:], c)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// showDesktopNotification shows n as a toast notification. The text is
+// passed in environment variables, so that it needs not be quoted for
+// PowerShell.
+func showDesktopNotification(ctx context.Context, n desktopNotification) error {
+	cmd := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShellCommand(toastScript))
+	cmd.Env = append(os.Environ(), "RESTIC_NOTIFY_TITLE="+n.Title, "RESTIC_NOTIFY_BODY="+n.Body)
+	return cmd.Run()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// pruneStateFilename is the name of the file in the repository's cache
+// directory which records the progress of a prune run.
+const pruneStateFilename = "prune.json"
+
+// pruneState is the plan of a prune run together with its progress. It is
+// saved locally so that an interrupted prune can be resumed without
+// analyzing all pack files again.
+type pruneState struct {
+	// Snapshots and Indexes are the snapshot and index files in the
+	// repository the plan is valid for.
+	Snapshots restic.IDs `json:"snapshots"`
+	Indexes   restic.IDs `json:"indexes"`
+
+	// Remove contains the packs which are deleted, Repack the packs which are
+	// rewritten and then deleted.
+	Remove restic.IDs `json:"remove"`
+	Repack restic.IDs `json:"repack"`
+
+	// Repacked contains the packs which were already rewritten, the new packs
+	// are contained in Indexes.
+	Repacked restic.IDs `json:"repacked"`
+
+	// IndexWritten is set when the index without the obsolete packs was
+	// saved, only then packs are deleted.
+	IndexWritten bool `json:"index_written"`
+}
+
+// pruneStateFile returns the path of the file the prune state for repo is
+// saved in. If the repository does not use a local cache, an empty string is
+// returned and prune cannot be resumed.
+func pruneStateFile(repo restic.Repository) string {
+	return cacheFile(repo, pruneStateFilename)
+}
+
+// cacheFile returns the path of the file name in the cache directory of repo,
+// or an empty string if the repository does not use a local cache.
+func cacheFile(repo restic.Repository, name string) string {
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return ""
+	}
+
+	c, ok := r.Cache.(*cache.Cache)
+	if !ok {
+		return ""
+	}
+
+	return filepath.Join(c.Path, name)
+}
+
+// listIDs returns the sorted IDs of all files of type t in the repository.
+func listIDs(ctx context.Context, repo restic.Repository, t restic.FileType) (restic.IDs, error) {
+	var ids restic.IDs
+	err := repo.List(ctx, t, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(ids)
+	return ids, nil
+}
+
+// equalIDs returns true if both sorted lists contain the same IDs.
+func equalIDs(a, b restic.IDs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPruneState reads the prune state from filename. If the file does not
+// exist, nil is returned.
+func loadPruneState(filename string) (*pruneState, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	state := &pruneState{}
+	err = json.Unmarshal(buf, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	return state, nil
+}
+
+// valid returns true if the repository still contains exactly the snapshots
+// and index files the plan was made for. Otherwise new data may reference
+// blobs in packs which are about to be removed.
+func (s *pruneState) valid(snapshots, indexes restic.IDs) bool {
+	return equalIDs(s.Snapshots, snapshots) && equalIDs(s.Indexes, indexes)
+}
+
+// remaining returns the packs which still need to be repacked.
+func (s *pruneState) remaining() restic.IDSet {
+	packs := restic.NewIDSet(s.Repack...)
+	for _, id := range s.Repacked {
+		packs.Delete(id)
+	}
+	return packs
+}
+
+// obsolete returns all packs which are deleted at the end of the prune run.
+func (s *pruneState) obsolete() restic.IDSet {
+	packs := restic.NewIDSet(s.Remove...)
+	packs.Merge(restic.NewIDSet(s.Repack...))
+	return packs
+}
+
+// save atomically writes the prune state to filename.
+func (s *pruneState) save(filename string) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	tmpfile := filename + ".tmp"
+	err = ioutil.WriteFile(tmpfile, buf, 0600)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	return errors.Wrap(fs.Rename(tmpfile, filename), "Rename")
+}
+
+// removePruneState removes the prune state file, if any.
+func removePruneState(filename string) {
+	if filename == "" {
+		return
+	}
+
+	err := fs.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		debug.Log("unable to remove prune state %v: %v", filename, err)
+	}
+}
+
+// obsoletePacksFilename is the name of the file in the repository's cache
+// directory which records when packs were found to be obsolete by prune.
+const obsoletePacksFilename = "obsolete-packs.json"
+
+// obsoletePacks maps packs which are no longer contained in the index to the
+// time they were first found to be obsolete. With --grace-period, prune only
+// deletes these packs after the grace period has passed.
+type obsoletePacks map[restic.ID]time.Time
+
+// loadObsoletePacks reads the obsolete packs from filename. If the file does
+// not exist, an empty list is returned.
+func loadObsoletePacks(filename string) (obsoletePacks, error) {
+	packs := make(obsoletePacks)
+
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return packs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	err = json.Unmarshal(buf, &packs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	return packs, nil
+}
+
+// save atomically writes the obsolete packs to filename.
+func (o obsoletePacks) save(filename string) error {
+	buf, err := json.Marshal(o)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	tmpfile := filename + ".tmp"
+	err = ioutil.WriteFile(tmpfile, buf, 0600)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	return errors.Wrap(fs.Rename(tmpfile, filename), "Rename")
+}
+
+// update records the packs in obsolete which were not known before and forgets
+// about the packs which are not obsolete any more, for example because a
+// concurrent backup references their data again. It returns the packs which
+// were found to be obsolete before deadline.
+func (o obsoletePacks) update(obsolete restic.IDSet, now, deadline time.Time) (expired restic.IDSet) {
+	for id := range o {
+		if !obsolete.Has(id) {
+			delete(o, id)
+		}
+	}
+
+	expired = restic.NewIDSet()
+	for id := range obsolete {
+		marked, ok := o[id]
+		if !ok {
+			o[id] = now
+			continue
+		}
+
+		if marked.Before(deadline) {
+			expired.Insert(id)
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// openStatusWriter returns the writer for the JSON progress messages
+// requested with --status-fd or --status-socket. If neither is set, nil is
+// returned.
+func openStatusWriter(gopts GlobalOptions) (io.WriteCloser, error) {
+	switch {
+	case gopts.StatusFD > 0 && gopts.StatusSocket != "":
+		return nil, errors.Fatal("--status-fd and --status-socket cannot be used together")
+	case gopts.StatusFD < 0:
+		return nil, errors.Fatalf("invalid file descriptor %d for --status-fd", gopts.StatusFD)
+	case gopts.StatusFD > 0:
+		return os.NewFile(uintptr(gopts.StatusFD), "status-fd"), nil
+	case strings.HasPrefix(gopts.StatusSocket, `\\.\pipe\`):
+		// named pipes on Windows are opened like files
+		f, err := os.OpenFile(gopts.StatusSocket, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, errors.Fatalf("unable to open status pipe: %v", err)
+		}
+		return f, nil
+	case gopts.StatusSocket != "":
+		conn, err := net.Dial("unix", gopts.StatusSocket)
+		if err != nil {
+			return nil, errors.Fatalf("unable to connect to status socket: %v", err)
+		}
+		return conn, nil
+	}
+
+	return nil, nil
+}
+
+// startStatusTerminal runs a terminal which writes the messages for
+// --status-fd and --status-socket to out. The returned function stops the
+// terminal, waits until all messages were written and closes out.
+func startStatusTerminal(ctx context.Context, out io.WriteCloser) (term *termstatus.Terminal, stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	term = termstatus.New(out, out, true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		term.Run(ctx)
+	}()
+
+	return term, func() {
+		cancel()
+		<-done
+		_ = out.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOpenStatusWriter(t *testing.T) {
+	wr, err := openStatusWriter(GlobalOptions{})
+	rtest.OK(t, err)
+	rtest.Assert(t, wr == nil, "expected no writer without --status-fd and --status-socket")
+
+	_, err = openStatusWriter(GlobalOptions{StatusFD: 3, StatusSocket: "status.sock"})
+	rtest.Assert(t, err != nil, "expected error for --status-fd and --status-socket")
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	socket := filepath.Join(tempdir, "status.sock")
+	l, err := net.Listen("unix", socket)
+	rtest.OK(t, err)
+	defer l.Close()
+
+	wr, err = openStatusWriter(GlobalOptions{StatusSocket: socket})
+	rtest.OK(t, err)
+
+	conn, err := l.Accept()
+	rtest.OK(t, err)
+	defer conn.Close()
+
+	_, err = wr.Write([]byte("{\"message_type\":\"status\"}\n"))
+	rtest.OK(t, err)
+	rtest.OK(t, wr.Close())
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	rtest.OK(t, err)
+	rtest.Equals(t, "{\"message_type\":\"status\"}\n", line)
+}
+
+// closeBuffer is a buffer which fails writes after it was closed.
+type closeBuffer struct {
+	m      sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return 0, fmt.Errorf("write after close")
+	}
+	return b.buf.Write(p)
+}
+
+func (b *closeBuffer) Close() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.closed = true
+	return nil
+}
+
+func TestStatusTerminalFlush(t *testing.T) {
+	out := &closeBuffer{}
+	term, stop := startStatusTerminal(context.Background(), out)
+
+	var want bytes.Buffer
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("{\"message_type\":\"status\",\"n\":%d}\n", i)
+		term.Print(line)
+		want.WriteString(line)
+	}
+	stop()
+
+	// all messages must have been written before the writer was closed
+	rtest.Assert(t, out.closed, "writer was not closed")
+	rtest.Equals(t, want.String(), out.buf.String())
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// systemdNotifier sends notifications to systemd for services with
+// Type=notify, as described in sd_notify(3). The status text is shown by
+// "systemctl status". If the service has a watchdog, it is only kept alive
+// while restic makes progress.
+type systemdNotifier struct {
+	m            sync.Mutex
+	conn         net.Conn
+	status       string
+	lastActivity time.Time
+}
+
+// systemd is set in the PersistentPreRunE function of the root command when
+// restic runs as a systemd service with Type=notify.
+var systemd *systemdNotifier
+
+// openSystemdNotifier connects to the socket in $NOTIFY_SOCKET. If the
+// variable is not set or the socket cannot be reached, nil is returned, as
+// restic works without notifying systemd.
+func openSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(socket, "@") {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		debug.Log("unable to connect to %q: %v", socket, err)
+		Warnf("unable to connect to systemd notify socket, not sending notifications: %v\n", err)
+		return nil
+	}
+
+	return &systemdNotifier{
+		conn:         conn,
+		lastActivity: time.Now(),
+	}
+}
+
+func (n *systemdNotifier) send(state string) {
+	if _, err := n.conn.Write([]byte(state)); err != nil {
+		debug.Log("sending %q to systemd failed: %v", state, err)
+	}
+}
+
+// Ready tells systemd that restic has started.
+func (n *systemdNotifier) Ready() {
+	if n == nil {
+		return
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.send("READY=1")
+}
+
+// Status sets the status text of the service. A status text which differs
+// from the previous one, ignoring the elapsed time printed at the beginning of
+// progress lines, counts as progress for the watchdog.
+func (n *systemdNotifier) Status(text string) {
+	if n == nil {
+		return
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if stripElapsed(text) != stripElapsed(n.status) {
+		n.lastActivity = time.Now()
+	}
+	n.status = text
+
+	// the status must be a single line
+	n.send("STATUS=" + strings.Replace(text, "\n", " ", -1))
+}
+
+// stripElapsed removes the elapsed time "[0:23] " from the beginning of a
+// progress line.
+func stripElapsed(s string) string {
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "] "); i >= 0 {
+			return s[i+2:]
+		}
+	}
+	return s
+}
+
+// Stopping tells systemd that restic is about to exit.
+func (n *systemdNotifier) Stopping() {
+	if n == nil {
+		return
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.send("STOPPING=1")
+}
+
+// watchdogInterval returns the interval in which systemd expects to be
+// notified, or zero if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog notifies the watchdog of systemd twice per interval as long as
+// restic has made progress during the last interval. It returns when ctx is
+// cancelled.
+func (n *systemdNotifier) RunWatchdog(ctx context.Context, interval time.Duration) {
+	if n == nil || interval == 0 {
+		return
+	}
+
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n.m.Lock()
+			if time.Since(n.lastActivity) < interval {
+				n.send("WATCHDOG=1")
+			}
+			n.m.Unlock()
+		}
+	}
+}
+
+// Close closes the connection to systemd.
+func (n *systemdNotifier) Close() error {
+	if n == nil {
+		return nil
+	}
+
+	return n.conn.Close()
+}
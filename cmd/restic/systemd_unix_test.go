@@ -0,0 +1,84 @@
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSystemdNotifier(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	socket := filepath.Join(tempdir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	rtest.OK(t, err)
+	defer conn.Close()
+
+	prev, ok := os.LookupEnv("NOTIFY_SOCKET")
+	rtest.OK(t, os.Setenv("NOTIFY_SOCKET", socket))
+	defer func() {
+		if ok {
+			_ = os.Setenv("NOTIFY_SOCKET", prev)
+		} else {
+			_ = os.Unsetenv("NOTIFY_SOCKET")
+		}
+	}()
+
+	n := openSystemdNotifier()
+	rtest.Assert(t, n != nil, "unable to connect to the notify socket")
+	defer n.Close()
+
+	n.Ready()
+	n.Status("[0:01] 10.00%  1 / 10 packs\r")
+
+	buf := make([]byte, 1024)
+	for _, want := range []string{"READY=1", "STATUS=[0:01] 10.00%  1 / 10 packs"} {
+		l, err := conn.Read(buf)
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(buf[:l]))
+	}
+}
+
+func TestSystemdNotifierDisabled(t *testing.T) {
+	prev, ok := os.LookupEnv("NOTIFY_SOCKET")
+	rtest.OK(t, os.Unsetenv("NOTIFY_SOCKET"))
+	defer func() {
+		if ok {
+			_ = os.Setenv("NOTIFY_SOCKET", prev)
+		}
+	}()
+
+	n := openSystemdNotifier()
+	rtest.Assert(t, n == nil, "expected no notifier without $NOTIFY_SOCKET")
+
+	// all methods must work on a nil notifier
+	n.Ready()
+	n.Status("test")
+	n.Stopping()
+	rtest.OK(t, n.Close())
+}
+
+func TestSystemdNotifierUnreachable(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	prev, ok := os.LookupEnv("NOTIFY_SOCKET")
+	rtest.OK(t, os.Setenv("NOTIFY_SOCKET", filepath.Join(tempdir, "missing.sock")))
+	defer func() {
+		if ok {
+			_ = os.Setenv("NOTIFY_SOCKET", prev)
+		} else {
+			_ = os.Unsetenv("NOTIFY_SOCKET")
+		}
+	}()
+
+	// restic continues without notifying systemd
+	n := openSystemdNotifier()
+	rtest.Assert(t, n == nil, "expected no notifier for an unreachable socket")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// webhookEvent is sent as JSON to the URLs set with --webhook-url. The URLs
+// are templates, which are expanded with the event.
+type webhookEvent struct {
+	Event    string             `json:"event"` // "start", "success", "warning" or "failure"
+	Command  string             `json:"command"`
+	Hostname string             `json:"hostname"`
+	Time     time.Time          `json:"time"`
+	Duration float64            `json:"duration,omitempty"` // in seconds
+	ExitCode int                `json:"exit_code"`
+	Error    string             `json:"error,omitempty"`
+	Summary  map[string]float64 `json:"summary,omitempty"`
+}
+
+// parseWebhookURLs parses the templates for the webhook URLs.
+func parseWebhookURLs(urls []string) ([]*template.Template, error) {
+	var templates []*template.Template
+	for _, u := range urls {
+		tmpl, err := template.New("webhook").Option("missingkey=error").Parse(u)
+		if err != nil {
+			return nil, errors.Fatalf("invalid webhook URL %q: %v", u, err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	return templates, nil
+}
+
+// sendWebhooks posts ev to all webhook URLs. Errors are printed, they do not
+// abort the command.
+func sendWebhooks(ctx context.Context, templates []*template.Template, ev webhookEvent) {
+	if len(templates) == 0 {
+		return
+	}
+
+	if ev.Hostname == "" {
+		ev.Hostname, _ = os.Hostname()
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		Warnf("unable to encode webhook event: %v\n", err)
+		return
+	}
+
+	for _, tmpl := range templates {
+		buf := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(buf, ev); err != nil {
+			Warnf("unable to expand webhook URL: %v\n", err)
+			continue
+		}
+
+		if err := postWebhook(ctx, strings.TrimSpace(buf.String()), body); err != nil {
+			Warnf("webhook for %v failed: %v\n", ev.Event, err)
+		}
+	}
+}
+
+// postWebhook sends body to url.
+func postWebhook(ctx context.Context, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected response: %v", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSendWebhooks(t *testing.T) {
+	var (
+		m      sync.Mutex
+		paths  []string
+		events []webhookEvent
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev webhookEvent
+		err := json.NewDecoder(req.Body).Decode(&ev)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		paths = append(paths, req.URL.Path)
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	templates, err := parseWebhookURLs([]string{
+		srv.URL + `/ping{{if eq .Event "start"}}/start{{else}}/{{.ExitCode}}{{end}}`,
+	})
+	rtest.OK(t, err)
+
+	sendWebhooks(context.TODO(), templates, webhookEvent{Event: "start", Command: "backup"})
+	sendWebhooks(context.TODO(), templates, webhookEvent{Event: "failure", Command: "backup", ExitCode: 1, Error: "failed"})
+
+	rtest.Equals(t, []string{"/ping/start", "/ping/1"}, paths)
+	rtest.Equals(t, "start", events[0].Event)
+	rtest.Equals(t, "failure", events[1].Event)
+	rtest.Equals(t, "failed", events[1].Error)
+}
+
+func TestParseWebhookURLsInvalid(t *testing.T) {
+	_, err := parseWebhookURLs([]string{"https://example.com/{{.Event"})
+	rtest.Assert(t, err != nil, "expected error for invalid template")
+}
@@ -687,6 +687,37 @@ type SnapshotOptions struct {
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot restic.ID
+
+	// Chain stores the ID of the previous snapshot of the same host and
+	// paths in the new snapshot.
+	Chain bool
+
+	// Sign, if set, returns a signature for the data returned by
+	// Snapshot.SignedData, which is stored in the new snapshot.
+	Sign func(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// findPreviousSnapshot returns the ID of the newest snapshot in the chain of
+// sn. The parent snapshot is used if it belongs to the same chain, otherwise
+// all snapshots are searched. The data of the backup has already been saved at
+// this point, so errors are only logged and nil is returned.
+func (arch *Archiver) findPreviousSnapshot(ctx context.Context, sn *restic.Snapshot, parentID restic.ID) *restic.ID {
+	if !parentID.IsNull() {
+		parent, err := restic.LoadSnapshot(ctx, arch.Repo, parentID)
+		if err == nil && parent.ChainKey() == sn.ChainKey() {
+			return &parentID
+		}
+		if err != nil {
+			debug.Log("unable to load parent snapshot %v: %v", parentID.Str(), err)
+		}
+	}
+
+	id, err := restic.FindPreviousSnapshot(ctx, arch.Repo, sn)
+	if err != nil {
+		debug.Log("unable to find the previous snapshot: %v", err)
+		return nil
+	}
+	return id
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -800,6 +831,21 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	}
 	sn.Tree = &rootTreeID
 
+	if opts.Chain {
+		sn.Previous = arch.findPreviousSnapshot(ctx, sn, opts.ParentSnapshot)
+	}
+
+	if opts.Sign != nil {
+		data, err := sn.SignedData()
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+		sn.Signature, err = opts.Sign(ctx, data)
+		if err != nil {
+			return nil, restic.ID{}, errors.Wrap(err, "Sign")
+		}
+	}
+
 	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return nil, restic.ID{}, err
@@ -149,6 +149,12 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	return errors.Wrap(err, "CreateBlockBlobFromReader")
 }
 
+// Replace atomically replaces the file h with the data from rd, uploading an
+// object replaces an existing object with the same name.
+func (be *Backend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.Save(ctx, h, rd)
+}
+
 func (be *Backend) saveLarge(ctx context.Context, objName string, rd restic.RewindReader) error {
 	// create the file on the server
 	file := be.container.GetBlobReference(objName)
@@ -387,7 +393,8 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.AuditFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -211,6 +211,12 @@ func (be *b2Backend) Save(ctx context.Context, h restic.Handle, rd restic.Rewind
 	return errors.Wrap(w.Close(), "Close")
 }
 
+// Replace atomically replaces the file h with the data from rd, uploading an
+// object replaces an existing object with the same name.
+func (be *b2Backend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.Save(ctx, h, rd)
+}
+
 // Stat returns information about a blob.
 func (be *b2Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInfo, err error) {
 	debug.Log("Stat %v", h)
@@ -312,7 +318,8 @@ func (be *b2Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.AuditFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -62,6 +62,15 @@ func (be *ErrorBackend) Save(ctx context.Context, h restic.Handle, rd restic.Rew
 	return be.Backend.Save(ctx, h, rd)
 }
 
+// Replace atomically replaces the file h, see restic.Replacer.
+func (be *ErrorBackend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.fail(be.FailSave) {
+		return errors.Errorf("Replace(%v) random error induced", h)
+	}
+
+	return restic.Replace(ctx, be.Backend, h, rd)
+}
+
 // Load returns a reader that yields the contents of the file at h at the
 // given offset. If length is larger than zero, only a portion of the file
 // is returned. rd must be closed after use. If an error is returned, the
@@ -69,6 +69,23 @@ func (be *RetryBackend) Save(ctx context.Context, h restic.Handle, rd restic.Rew
 	})
 }
 
+// Replace atomically replaces the file h with the data from rd, see
+// restic.Replacer. In contrast to Save, the file is not removed on errors.
+func (be *RetryBackend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.retry(ctx, fmt.Sprintf("Replace(%v)", h), func() error {
+		err := rd.Rewind()
+		if err != nil {
+			return err
+		}
+
+		err = restic.Replace(ctx, be.Backend, h, rd)
+		if err == restic.ErrReplaceUnsupported {
+			return backoff.Permanent(err)
+		}
+		return err
+	})
+}
+
 // Load returns a reader that yields the contents of the file at h at the
 // given offset. If length is larger than zero, only a portion of the file
 // is returned. rd must be closed after use. If an error is returned, the
@@ -253,6 +253,12 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	return nil
 }
 
+// Replace atomically replaces the file h with the data from rd, uploading an
+// object replaces an existing object with the same name.
+func (be *Backend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.Save(ctx, h, rd)
+}
+
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
 type wrapReader struct {
 	io.ReadCloser
@@ -443,7 +449,8 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.AuditFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
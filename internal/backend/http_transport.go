@@ -1,8 +1,11 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"io/ioutil"
 	"net"
@@ -22,6 +25,57 @@ type TransportOptions struct {
 
 	// contains the name of a file containing the TLS client certificate and private key in PEM format
 	TLSClientCertKeyFilename string
+
+	// contains hex encoded SHA-256 fingerprints of certificates, one of which
+	// must be part of the verified certificate chain of the server
+	ServerCertPins []string
+}
+
+// parseCertPins decodes the hex encoded SHA-256 fingerprints in pins, which
+// may contain colons as printed by "openssl x509 -fingerprint -sha256".
+func parseCertPins(pins []string) ([][]byte, error) {
+	var res [][]byte
+	for _, pin := range pins {
+		buf, err := hex.DecodeString(strings.Replace(strings.TrimSpace(pin), ":", "", -1))
+		if err != nil || len(buf) != sha256.Size {
+			return nil, errors.Errorf("invalid SHA-256 certificate fingerprint %q", pin)
+		}
+		res = append(res, buf)
+	}
+	return res, nil
+}
+
+// verifyCertPins returns a function for tls.Config.VerifyPeerCertificate which
+// accepts the connection only if one of the certificates in the verified chains
+// matches a pinned fingerprint. Certificates which the server presents but
+// which are not part of a verified chain are ignored, otherwise a server with
+// a mis-issued certificate could pass the check by sending the pinned
+// certificate along. If the chain is not verified, only the leaf certificate
+// is checked.
+func verifyCertPins(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var candidates [][]byte
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				candidates = append(candidates, cert.Raw)
+			}
+		}
+		if len(verifiedChains) == 0 && len(rawCerts) > 0 {
+			candidates = rawCerts[:1]
+		}
+
+		for _, raw := range candidates {
+			sum := sha256.Sum256(raw)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+
+		debug.Log("no server certificate matches the %d pinned fingerprints", len(pins))
+		return errors.New("no certificate of the server matches the pinned SHA-256 fingerprints")
+	}
 }
 
 // readPEMCertKey reads a file and returns the PEM encoded certificate and key
@@ -60,7 +114,9 @@ func readPEMCertKey(filename string) (certs []byte, key []byte, err error) {
 
 // Transport returns a new http.RoundTripper with default settings applied. If
 // a custom rootCertFilename is non-empty, it must point to a valid PEM file,
-// otherwise the function will return an error.
+// otherwise the function will return an error. If server certificate pins are
+// given, connections to servers which do not present a matching certificate
+// fail.
 func Transport(opts TransportOptions) (http.RoundTripper, error) {
 	// copied from net/http
 	tr := &http.Transport{
@@ -108,6 +164,14 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		tr.TLSClientConfig.RootCAs = pool
 	}
 
+	if len(opts.ServerCertPins) > 0 {
+		pins, err := parseCertPins(opts.ServerCertPins)
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig.VerifyPeerCertificate = verifyCertPins(pins)
+	}
+
 	// wrap in the debug round tripper (if active)
 	return debug.RoundTripper(tr), nil
 }
@@ -0,0 +1,147 @@
+package backend_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTransportServerCertPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// trust the self-signed certificate of the test server
+	cert := srv.Certificate()
+	certfile := filepath.Join(tempdir, "cert.pem")
+	rtest.OK(t, ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+
+	sum := sha256.Sum256(cert.Raw)
+	var tests = []struct {
+		pins []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{hex.EncodeToString(sum[:])}, true},
+		{[]string{"00" + hex.EncodeToString(sum[1:]), hex.EncodeToString(sum[:])}, true},
+		{[]string{"00" + hex.EncodeToString(sum[1:])}, false},
+	}
+
+	for _, test := range tests {
+		rt, err := backend.Transport(backend.TransportOptions{
+			RootCertFilenames: []string{certfile},
+			ServerCertPins:    test.pins,
+		})
+		rtest.OK(t, err)
+
+		res, err := (&http.Client{Transport: rt}).Get(srv.URL)
+		if test.ok {
+			rtest.OK(t, err)
+			rtest.OK(t, res.Body.Close())
+		} else {
+			rtest.Assert(t, err != nil, "connection with pins %v did not fail", test.pins)
+		}
+	}
+
+	_, err := backend.Transport(backend.TransportOptions{ServerCertPins: []string{"invalid"}})
+	rtest.Assert(t, err != nil, "invalid pin was accepted")
+}
+
+// newTestCert returns a certificate for 127.0.0.1 signed by parent, or a
+// self-signed CA certificate if parent is nil.
+func newTestCert(t testing.TB, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtest.OK(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	rtest.OK(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	rtest.OK(t, err)
+	return cert, key
+}
+
+func TestTransportServerCertPinsExtraCert(t *testing.T) {
+	ca, caKey := newTestCert(t, "ca", nil, nil)
+	leaf, leafKey := newTestCert(t, "leaf", ca, caKey)
+
+	// the certificate of the real server, which is pinned
+	pinned, _ := newTestCert(t, "pinned", nil, nil)
+
+	// the server presents a valid but unpinned certificate and sends the
+	// pinned certificate along
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leaf.Raw, pinned.Raw},
+			PrivateKey:  leafKey,
+		}},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	certfile := filepath.Join(tempdir, "ca.pem")
+	rtest.OK(t, ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+
+	for _, test := range []struct {
+		cert *x509.Certificate
+		ok   bool
+	}{
+		{pinned, false},
+		{leaf, true},
+		{ca, true},
+	} {
+		sum := sha256.Sum256(test.cert.Raw)
+		rt, err := backend.Transport(backend.TransportOptions{
+			RootCertFilenames: []string{certfile},
+			ServerCertPins:    []string{hex.EncodeToString(sum[:])},
+		})
+		rtest.OK(t, err)
+
+		res, err := (&http.Client{Transport: rt}).Get(srv.URL)
+		if test.ok {
+			rtest.OK(t, err)
+			rtest.OK(t, res.Body.Close())
+		} else {
+			rtest.Assert(t, err != nil, "connection with the pin of %v did not fail", test.cert.Subject.CommonName)
+		}
+	}
+}
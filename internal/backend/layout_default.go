@@ -21,6 +21,7 @@ var defaultLayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "locks",
 	restic.KeyFile:      "keys",
+	restic.AuditFile:    "audit",
 }
 
 func (l *DefaultLayout) String() string {
@@ -16,6 +16,7 @@ var s3LayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "lock",
 	restic.KeyFile:      "key",
+	restic.AuditFile:    "audit",
 }
 
 func (l *S3LegacyLayout) String() string {
@@ -9,8 +9,9 @@ import (
 
 // Config holds all information needed to open a local repository.
 type Config struct {
-	Path   string
-	Layout string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
+	Path         string
+	Layout       string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
+	SecureDelete bool   `option:"secure-delete" help:"overwrite files with zeros before removing them (default: false)"`
 }
 
 func init() {
@@ -3,6 +3,7 @@ package local
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -127,6 +128,43 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 	return setNewFileMode(filename, backend.Modes.File)
 }
 
+// Replace atomically replaces the file h with the data from rd. The data is
+// written to a temporary file in the same directory first, which is then
+// renamed over the old file.
+func (b *Local) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("Replace %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	filename := b.Filename(h)
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".tmp-"+filepath.Base(filename)+"-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+
+	_, err = io.Copy(f, rd)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = setNewFileMode(f.Name(), backend.Modes.File)
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), filename)
+	}
+
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Replace")
+	}
+
+	return nil
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (b *Local) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
@@ -203,9 +241,52 @@ func (b *Local) Remove(ctx context.Context, h restic.Handle) error {
 		return errors.Wrap(err, "Chmod")
 	}
 
+	if b.SecureDelete {
+		if err = overwriteFile(fn); err != nil {
+			return err
+		}
+	}
+
 	return fs.Remove(fn)
 }
 
+// overwriteFile replaces the contents of the file with zeros and syncs it to
+// disk. This is only effective on storage which writes the data in place, not
+// on SSDs, flash drives or copy-on-write filesystems.
+func overwriteFile(fn string) error {
+	f, err := fs.OpenFile(fn, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Stat")
+	}
+
+	_, err = io.CopyN(f, zeroReader{}, fi.Size())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "overwrite")
+	}
+
+	return errors.Wrap(f.Close(), "Close")
+}
+
+// zeroReader returns an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
 func isFile(fi os.FileInfo) bool {
 	return fi.Mode()&(os.ModeType|os.ModeCharDevice) == 0
 }
@@ -1,6 +1,8 @@
 package local_test
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -134,3 +136,28 @@ func TestOpenNotExistingDirectory(t *testing.T) {
 	removeAll(t, filepath.Join(dir, "data"))
 	empty(t, dir)
 }
+
+func TestSecureDelete(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := local.Create(local.Config{Path: filepath.Join(dir, "repo"), SecureDelete: true})
+	rtest.OK(t, err)
+
+	data := rtest.Random(23, 300*1024)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	// a second link to the file shows its contents after it was removed
+	link := filepath.Join(dir, "link")
+	rtest.OK(t, os.Link(be.Filename(h), link))
+
+	rtest.OK(t, be.Remove(context.TODO(), h))
+
+	buf, err := ioutil.ReadFile(link)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(data), len(buf))
+	rtest.Assert(t, bytes.Equal(buf, make([]byte, len(data))), "file was not overwritten with zeros")
+
+	rtest.OK(t, be.Close())
+}
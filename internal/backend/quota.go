@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// QuotaExceededError is returned by QuotaBackend when saving a file would
+// exceed the quota.
+type QuotaExceededError struct {
+	Quota uint64
+	Used  uint64
+	Size  uint64
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Size == 0 {
+		return fmt.Sprintf("repository size quota exceeded: %d bytes used, the quota is %d bytes", e.Used, e.Quota)
+	}
+	return fmt.Sprintf("repository size quota exceeded: %d bytes used, saving %d bytes would exceed the quota of %d bytes",
+		e.Used, e.Size, e.Quota)
+}
+
+// IsQuotaExceeded returns true if err is a QuotaExceededError.
+func IsQuotaExceeded(err error) bool {
+	_, ok := err.(*QuotaExceededError)
+	return ok
+}
+
+// QuotaBackend refuses to save files once the total size of all files in the
+// backend would exceed the quota. Lock files are exempt, so that locks can
+// still be refreshed. Removing files does not decrease the used size.
+type QuotaBackend struct {
+	restic.Backend
+	quota uint64
+
+	m    sync.Mutex
+	used uint64
+	err  *QuotaExceededError
+}
+
+// statically ensure that QuotaBackend implements restic.Backend.
+var _ restic.Backend = &QuotaBackend{}
+
+// NewQuotaBackend wraps be with a backend which enforces quota. used is the
+// number of bytes already stored in the backend.
+func NewQuotaBackend(be restic.Backend, quota, used uint64) *QuotaBackend {
+	return &QuotaBackend{
+		Backend: be,
+		quota:   quota,
+		used:    used,
+	}
+}
+
+// Save stores the data in the backend under the given handle, unless this
+// would exceed the quota.
+func (be *QuotaBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.LockFile {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	size := uint64(rd.Length())
+
+	be.m.Lock()
+	if be.used+size > be.quota {
+		err := &QuotaExceededError{Quota: be.quota, Used: be.used, Size: size}
+		be.err = err
+		be.m.Unlock()
+		debug.Log("refusing to save %v: %v", h, err)
+		return err
+	}
+	be.used += size
+	be.m.Unlock()
+
+	err := be.Backend.Save(ctx, h, rd)
+	if err != nil {
+		be.m.Lock()
+		be.used -= size
+		be.m.Unlock()
+	}
+	return err
+}
+
+// Replace atomically replaces the file h, see restic.Replacer. Replacing a
+// file is not counted against the quota.
+func (be *QuotaBackend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return restic.Replace(ctx, be.Backend, h, rd)
+}
+
+// Err returns the error for the last file which was refused because of the
+// quota, or nil if no file was refused.
+func (be *QuotaBackend) Err() error {
+	be.m.Lock()
+	defer be.m.Unlock()
+	if be.err == nil {
+		return nil
+	}
+	return be.err
+}
+
+// Used returns the number of bytes stored in the backend.
+func (be *QuotaBackend) Used() uint64 {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.used
+}
+
+// Size returns the total size of all files stored in the backend.
+func Size(ctx context.Context, be restic.Backend) (uint64, error) {
+	fi, err := be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(fi.Size)
+
+	for _, t := range []restic.FileType{restic.KeyFile, restic.SnapshotFile, restic.IndexFile, restic.DataFile, restic.LockFile} {
+		err := be.List(ctx, t, func(fi restic.FileInfo) error {
+			size += uint64(fi.Size)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
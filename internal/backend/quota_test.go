@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/mock"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestQuotaBackend(t *testing.T) {
+	saved := 0
+	be := &mock.Backend{
+		SaveFn: func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+			saved++
+			_, err := io.Copy(ioutil.Discard, rd)
+			return err
+		},
+	}
+
+	qbe := NewQuotaBackend(be, 1000, 600)
+	data := make([]byte, 300)
+
+	h := restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+	test.OK(t, qbe.Save(context.TODO(), h, restic.NewByteReader(data)))
+	test.Equals(t, uint64(900), qbe.Used())
+	test.OK(t, qbe.Err())
+
+	h = restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+	err := qbe.Save(context.TODO(), h, restic.NewByteReader(data))
+	test.Assert(t, IsQuotaExceeded(err), "expected quota exceeded error, got %v", err)
+	test.Equals(t, err, qbe.Err())
+	test.Equals(t, uint64(900), qbe.Used())
+
+	// lock files can always be saved
+	h = restic.Handle{Type: restic.LockFile, Name: restic.NewRandomID().String()}
+	test.OK(t, qbe.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	test.Equals(t, 2, saved)
+}
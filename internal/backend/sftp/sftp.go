@@ -301,6 +301,47 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 	return errors.Wrap(r.c.Chmod(filename, backend.Modes.File), "Chmod")
 }
 
+// Replace atomically replaces the file h with the data from rd. The data is
+// written to a temporary file first, which is then renamed over the old file
+// with the posix-rename extension of OpenSSH. Servers without the extension
+// are refused.
+func (r *SFTP) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("Replace %v", h)
+	if err := r.clientError(); err != nil {
+		return err
+	}
+
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	filename := r.Filename(h)
+	tmpname := path.Join(path.Dir(filename), fmt.Sprintf(".tmp-%s-%s", path.Base(filename), restic.NewRandomID().Str()))
+
+	f, err := r.c.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	_, err = io.Copy(f, rd)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = r.c.Chmod(tmpname, backend.Modes.File)
+	}
+	if err == nil {
+		err = r.c.PosixRename(tmpname, filename)
+	}
+
+	if err != nil {
+		_ = r.c.Remove(tmpname)
+		return errors.Wrap(err, "Replace")
+	}
+
+	return nil
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (r *SFTP) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
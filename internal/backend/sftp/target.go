@@ -0,0 +1,113 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+)
+
+// sshFxPermissionDenied is the status code SSH_FX_PERMISSION_DENIED of the
+// SFTP protocol.
+const sshFxPermissionDenied = 3
+
+// Target is a directory tree on a remote host accessed via SFTP, files from a
+// snapshot can be restored to it.
+type Target struct {
+	s *SFTP
+}
+
+// OpenTarget connects to the host described by cfg by running "ssh" with the
+// appropriate arguments (or cfg.Command, if set).
+func OpenTarget(cfg Config) (*Target, error) {
+	debug.Log("open target with config %#v", cfg)
+
+	cmd, args, err := buildSSHCommand(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := startClient(cmd, args...)
+	if err != nil {
+		debug.Log("unable to start program: %v", err)
+		return nil, err
+	}
+
+	s.Config = cfg
+	s.p = cfg.Path
+	return &Target{s: s}, nil
+}
+
+// MkdirAll creates the directory dir and all missing parents.
+func (t *Target) MkdirAll(dir string) error {
+	if err := t.s.clientError(); err != nil {
+		return err
+	}
+
+	return errors.Wrap(t.s.c.MkdirAll(dir), "MkdirAll")
+}
+
+// Create creates or truncates the file name and returns it for writing.
+func (t *Target) Create(name string) (io.WriteCloser, error) {
+	if err := t.s.clientError(); err != nil {
+		return nil, err
+	}
+
+	f, err := t.s.c.Create(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "Create")
+	}
+
+	return f, nil
+}
+
+// Remove removes the file or empty directory name.
+func (t *Target) Remove(name string) error {
+	return errors.Wrap(t.s.c.Remove(name), "Remove")
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (t *Target) Symlink(oldname, newname string) error {
+	return errors.Wrap(t.s.c.Symlink(oldname, newname), "Symlink")
+}
+
+// Chmod changes the permissions of name.
+func (t *Target) Chmod(name string, mode os.FileMode) error {
+	return errors.Wrap(t.s.c.Chmod(name, mode.Perm()), "Chmod")
+}
+
+// Chown changes the owner and group of name.
+func (t *Target) Chown(name string, uid, gid int) error {
+	return errors.Wrap(t.s.c.Chown(name, uid, gid), "Chown")
+}
+
+// Chtimes changes the access and modification times of name.
+func (t *Target) Chtimes(name string, atime, mtime time.Time) error {
+	return errors.Wrap(t.s.c.Chtimes(name, atime, mtime), "Chtimes")
+}
+
+// IsNotExist returns true if the error is caused by a not existing file.
+func (t *Target) IsNotExist(err error) bool {
+	return t.s.IsNotExist(err)
+}
+
+// IsPermission returns true if the error was caused by missing permissions.
+func (t *Target) IsPermission(err error) bool {
+	err = errors.Cause(err)
+
+	if os.IsPermission(err) {
+		return true
+	}
+
+	statusError, ok := err.(*sftp.StatusError)
+	return ok && statusError.Code == sshFxPermissionDenied
+}
+
+// Close closes the sftp connection and terminates the underlying command.
+func (t *Target) Close() error {
+	return t.s.Close()
+}
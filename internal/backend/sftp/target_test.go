@@ -0,0 +1,30 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+)
+
+func TestTargetIsPermission(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want bool
+	}{
+		{errors.Wrap(&sftp.StatusError{Code: sshFxPermissionDenied}, "Create"), true},
+		{errors.Wrap(os.ErrPermission, "Chown"), true},
+		{&os.PathError{Op: "chmod", Path: "file", Err: os.ErrPermission}, true},
+		{errors.Wrap(&sftp.StatusError{Code: 2}, "Create"), false},
+		{errors.New("permission denied"), false},
+	}
+
+	target := &Target{}
+	for _, test := range tests {
+		if got := target.IsPermission(test.err); got != test.want {
+			t.Errorf("IsPermission(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
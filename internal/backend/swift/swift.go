@@ -178,6 +178,12 @@ func (be *beSwift) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	return errors.Wrap(err, "client.PutObject")
 }
 
+// Replace atomically replaces the file h with the data from rd, uploading an
+// object replaces an existing object with the same name.
+func (be *beSwift) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.Save(ctx, h, rd)
+}
+
 // Stat returns information about a blob.
 func (be *beSwift) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInfo, err error) {
 	debug.Log("%v", h)
@@ -302,7 +308,8 @@ func (be *beSwift) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.AuditFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
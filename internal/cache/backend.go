@@ -43,6 +43,18 @@ func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	return b.Cache.Remove(h)
 }
 
+// Replace atomically replaces a file in the backend and removes it from the
+// cache.
+func (b *Backend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("cache Replace(%v)", h)
+	err := restic.Replace(ctx, b.Backend, h, rd)
+	if err != nil {
+		return err
+	}
+
+	return b.Cache.Remove(h)
+}
+
 var autoCacheTypes = map[restic.FileType]struct{}{
 	restic.IndexFile:    {},
 	restic.SnapshotFile: {},
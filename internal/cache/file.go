@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/restic/restic/internal/crypto"
@@ -12,6 +13,10 @@ import (
 	"github.com/restic/restic/internal/restic"
 )
 
+// touchInterval is the minimal interval between updates of the timestamp of a
+// cached file when it is loaded.
+const touchInterval = time.Hour
+
 func (c *Cache) filename(h restic.Handle) string {
 	if len(h.Name) < 2 {
 		panic("Name is empty or too short")
@@ -69,6 +74,14 @@ func (c *Cache) Load(h restic.Handle, length int, offset int64) (io.ReadCloser,
 		return nil, errors.Errorf("cached file %v is too small, removing", h)
 	}
 
+	// record the last use of the file, Trim removes the least recently used
+	// files first
+	if time.Since(fi.ModTime()) > touchInterval {
+		if err := updateTimestamp(c.filename(h)); err != nil {
+			debug.Log("unable to update timestamp of %v: %v", h, err)
+		}
+	}
+
 	if offset > 0 {
 		if _, err = f.Seek(offset, io.SeekStart); err != nil {
 			_ = f.Close()
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// packHeaderDir is the sub-directory of the cache which contains the headers
+// of pack files.
+const packHeaderDir = "packheaders"
+
+func (c *Cache) packHeaderFilename(id restic.ID) string {
+	name := id.String()
+	return filepath.Join(c.Path, packHeaderDir, name[:2], name)
+}
+
+// LoadPackHeader returns the cached header of the pack file id, which is
+// still encrypted. The header is only returned if the pack file had the same
+// size when the header was saved.
+func (c *Cache) LoadPackHeader(id restic.ID, size int64) ([]byte, bool) {
+	buf, err := ioutil.ReadFile(c.packHeaderFilename(id))
+	if err != nil {
+		return nil, false
+	}
+
+	if len(buf) < 8 || int64(binary.LittleEndian.Uint64(buf)) != size {
+		debug.Log("cached header for pack %v is invalid, removing", id.Str())
+		_ = c.RemovePackHeader(id)
+		return nil, false
+	}
+
+	return buf[8:], true
+}
+
+// SavePackHeader saves the header of the pack file id with the given size in
+// the cache.
+func (c *Cache) SavePackHeader(id restic.ID, size int64, header []byte) error {
+	filename := c.packHeaderFilename(id)
+	if err := fs.MkdirAll(filepath.Dir(filename), dirMode); err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	buf := make([]byte, 8+len(header))
+	binary.LittleEndian.PutUint64(buf, uint64(size))
+	copy(buf[8:], header)
+
+	// write to a temporary file first, so that concurrent readers never see
+	// a partial header
+	f, err := ioutil.TempFile(filepath.Dir(filename), "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+
+	if _, err = f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Write")
+	}
+
+	if err = f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Close")
+	}
+
+	if err = fs.Rename(f.Name(), filename); err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "Rename")
+	}
+
+	return nil
+}
+
+// RemovePackHeader removes the cached header of the pack file id. When the
+// header is not cached, no error is returned.
+func (c *Cache) RemovePackHeader(id restic.ID) error {
+	err := fs.Remove(c.packHeaderFilename(id))
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	return nil
+}
+
+// ClearPackHeaders removes the cached headers of all pack files which are not
+// contained in the set valid.
+func (c *Cache) ClearPackHeaders(valid restic.IDSet) error {
+	dir := filepath.Join(c.Path, packHeaderDir)
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil
+			}
+			return errors.Wrap(err, "Walk")
+		}
+
+		if !isFile(fi) {
+			return nil
+		}
+
+		id, err := restic.ParseID(fi.Name())
+		if err == nil && valid.Has(id) {
+			return nil
+		}
+
+		// also removes stale temporary files
+		return fs.Remove(name)
+	})
+
+	return err
+}
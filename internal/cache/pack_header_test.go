@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestPackHeader(t *testing.T) {
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+
+	id := restic.NewRandomID()
+	header := test.Random(23, 200)
+
+	_, ok := c.LoadPackHeader(id, 5000)
+	test.Assert(t, !ok, "header found before it was saved")
+
+	test.OK(t, c.SavePackHeader(id, 5000, header))
+
+	buf, ok := c.LoadPackHeader(id, 5000)
+	test.Assert(t, ok, "header not found")
+	test.Equals(t, header, buf)
+
+	// a different size means the pack file was modified
+	_, ok = c.LoadPackHeader(id, 4000)
+	test.Assert(t, !ok, "header found for wrong pack size")
+	_, ok = c.LoadPackHeader(id, 5000)
+	test.Assert(t, !ok, "invalid header was not removed")
+}
+
+func TestClearPackHeaders(t *testing.T) {
+	c, cleanup := TestNewCache(t)
+	defer cleanup()
+
+	valid := restic.NewIDSet()
+	var ids restic.IDs
+	for i := 0; i < 10; i++ {
+		id := restic.NewRandomID()
+		test.OK(t, c.SavePackHeader(id, 1000, test.Random(i, 100)))
+		ids = append(ids, id)
+		if i%2 == 0 {
+			valid.Insert(id)
+		}
+	}
+
+	test.OK(t, c.ClearPackHeaders(valid))
+
+	for _, id := range ids {
+		_, ok := c.LoadPackHeader(id, 1000)
+		test.Equals(t, valid.Has(id), ok)
+	}
+}
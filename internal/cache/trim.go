@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// FileStats contains the number and total size of cached files.
+type FileStats struct {
+	Count int
+	Size  int64
+}
+
+// cachedFile is a file stored in a cache directory.
+type cachedFile struct {
+	path string
+	fi   os.FileInfo
+}
+
+// walkFiles calls fn for all cached files of type t in the cache directory
+// dir.
+func walkFiles(dir string, t restic.FileType, fn func(cachedFile)) error {
+	subdir := filepath.Join(dir, cacheLayoutPaths[t])
+	err := filepath.Walk(subdir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil
+			}
+			return errors.Wrap(err, "Walk")
+		}
+
+		if !isFile(fi) {
+			return nil
+		}
+
+		if _, err := restic.ParseID(fi.Name()); err != nil {
+			return nil
+		}
+
+		fn(cachedFile{path: name, fi: fi})
+		return nil
+	})
+	return err
+}
+
+// DirStats returns the number and size of the cached files in the cache
+// directory dir by file type.
+func DirStats(dir string) (map[restic.FileType]FileStats, error) {
+	stats := make(map[restic.FileType]FileStats)
+	for t := range cacheLayoutPaths {
+		var s FileStats
+		err := walkFiles(dir, t, func(f cachedFile) {
+			s.Count++
+			s.Size += f.fi.Size()
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats[t] = s
+	}
+
+	return stats, nil
+}
+
+// Trim removes cached files from all cache directories in basedir until the
+// total size of the cached files is at most maxSize. The files in the least
+// recently used cache directories are removed first, within a directory the
+// least recently used files are removed first. Returned are the number and
+// size of the removed files.
+func Trim(basedir string, maxSize int64) (removed FileStats, err error) {
+	dirs, err := listCacheDirs(basedir)
+	if err != nil {
+		return FileStats{}, err
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].ModTime().Before(dirs[j].ModTime())
+	})
+
+	var files []cachedFile
+	var total int64
+	for _, dir := range dirs {
+		var dirFiles []cachedFile
+		for t := range cacheLayoutPaths {
+			err = walkFiles(filepath.Join(basedir, dir.Name()), t, func(f cachedFile) {
+				dirFiles = append(dirFiles, f)
+				total += f.fi.Size()
+			})
+			if err != nil {
+				return FileStats{}, err
+			}
+		}
+
+		sort.Slice(dirFiles, func(i, j int) bool {
+			return dirFiles[i].fi.ModTime().Before(dirFiles[j].fi.ModTime())
+		})
+		files = append(files, dirFiles...)
+	}
+
+	debug.Log("cache contains %d files with %d bytes, limit is %d bytes", len(files), total, maxSize)
+
+	for _, f := range files {
+		if total <= maxSize {
+			break
+		}
+
+		if err = fs.Remove(f.path); err != nil {
+			return removed, err
+		}
+
+		total -= f.fi.Size()
+		removed.Count++
+		removed.Size += f.fi.Size()
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+// saveFile saves a random file of the given size in the cache and sets its
+// modification time.
+func saveFile(t testing.TB, c *Cache, tpe restic.FileType, size int, modTime time.Time) restic.Handle {
+	buf := test.Random(int(modTime.Unix()), size)
+	h := restic.Handle{Type: tpe, Name: restic.Hash(buf).String()}
+	test.OK(t, c.Save(h, bytes.NewReader(buf)))
+	test.OK(t, os.Chtimes(c.filename(h), modTime, modTime))
+	return h
+}
+
+func TestTrim(t *testing.T) {
+	basedir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	old, err := New(restic.NewRandomID().String(), basedir)
+	test.OK(t, err)
+	current, err := New(restic.NewRandomID().String(), basedir)
+	test.OK(t, err)
+
+	now := time.Now()
+	test.OK(t, os.Chtimes(old.Path, now.Add(-48*time.Hour), now.Add(-48*time.Hour)))
+
+	oldFile := saveFile(t, old, restic.IndexFile, 1000, now.Add(-48*time.Hour))
+	unusedFile := saveFile(t, current, restic.DataFile, 1000, now.Add(-2*time.Hour))
+	usedFile := saveFile(t, current, restic.DataFile, 1000, now.Add(-time.Hour))
+	snapshotFile := saveFile(t, current, restic.SnapshotFile, 1000, now)
+
+	stats, err := DirStats(current.Path)
+	test.OK(t, err)
+	test.Equals(t, FileStats{Count: 2, Size: 2000}, stats[restic.DataFile])
+	test.Equals(t, FileStats{Count: 1, Size: 1000}, stats[restic.SnapshotFile])
+	test.Equals(t, FileStats{}, stats[restic.IndexFile])
+
+	// the limit is not reached, nothing is removed
+	removed, err := Trim(basedir, 4000)
+	test.OK(t, err)
+	test.Equals(t, FileStats{}, removed)
+
+	removed, err = Trim(basedir, 2500)
+	test.OK(t, err)
+	test.Equals(t, FileStats{Count: 2, Size: 2000}, removed)
+
+	test.Assert(t, !old.Has(oldFile), "file in least recently used cache dir was not removed")
+	test.Assert(t, !current.Has(unusedFile), "least recently used file was not removed")
+	test.Assert(t, current.Has(usedFile), "recently used file was removed")
+	test.Assert(t, current.Has(snapshotFile), "recently used file was removed")
+}
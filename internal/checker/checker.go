@@ -2,13 +2,17 @@ package checker
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
-	"os"
+	"io/ioutil"
+	"runtime"
+	"sort"
 	"sync"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/hashing"
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -32,6 +36,9 @@ type Checker struct {
 	masterIndex *repository.MasterIndex
 
 	repo restic.Repository
+
+	// parallelism is the number of goroutines used to load and check trees
+	parallelism int
 }
 
 // New returns a new checker which runs on repo.
@@ -42,6 +49,13 @@ func New(repo restic.Repository) *Checker {
 		masterIndex: repository.NewMasterIndex(),
 		indexes:     make(map[restic.ID]*repository.Index),
 		repo:        repo,
+		parallelism: defaultParallelism,
+	}
+
+	// checking trees is CPU bound for large repositories, so use all
+	// available cores
+	if n := runtime.GOMAXPROCS(0); n > c.parallelism {
+		c.parallelism = n
 	}
 
 	c.blobRefs.M = make(map[restic.ID]uint)
@@ -51,6 +65,14 @@ func New(repo restic.Repository) *Checker {
 
 const defaultParallelism = 5
 
+// SetParallelism sets the number of goroutines used for loading and checking
+// trees in Structure. Values smaller than one are ignored.
+func (c *Checker) SetParallelism(n int) {
+	if n > 0 {
+		c.parallelism = n
+	}
+}
+
 // ErrDuplicatePacks is returned when a pack is found in more than one index.
 type ErrDuplicatePacks struct {
 	PackID  restic.ID
@@ -93,6 +115,9 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 	ch := make(chan FileInfo)
 	resultCh := make(chan Result)
 
+	// hints are added by all workers concurrently
+	var hintsMu sync.Mutex
+
 	// send list of index files through ch, which is closed afterwards
 	wg.Go(func() error {
 		defer close(ch)
@@ -116,7 +141,9 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 			idx, buf, err = repository.LoadIndexWithDecoder(ctx, c.repo, buf[:0], fi.ID, repository.DecodeIndex)
 			if errors.Cause(err) == repository.ErrOldIndexFormat {
 				debug.Log("index %v has old format", fi.ID.Str())
+				hintsMu.Lock()
 				hints = append(hints, ErrOldIndexFormat{fi.ID})
+				hintsMu.Unlock()
 
 				idx, buf, err = repository.LoadIndexWithDecoder(ctx, c.repo, buf[:0], fi.ID, repository.DecodeOldIndex)
 			}
@@ -231,21 +258,27 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 	debug.Log("listing repository packs")
 	repoPacks := restic.NewIDSet()
 
+	// orphaned: present in the repo but not in c.packs, these are reported
+	// while the list is still running
 	err := c.repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
 		repoPacks.Insert(id)
+		if c.packs.Has(id) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case errChan <- PackError{ID: id, Orphaned: true, Err: errors.New("not referenced in any index")}:
+		}
 		return nil
 	})
 
 	if err != nil {
-		errChan <- err
-	}
-
-	// orphaned: present in the repo but not in c.packs
-	for orphanID := range repoPacks.Sub(c.packs) {
 		select {
 		case <-ctx.Done():
 			return
-		case errChan <- PackError{ID: orphanID, Orphaned: true, Err: errors.New("not referenced in any index")}:
+		case errChan <- err:
 		}
 	}
 
@@ -483,6 +516,9 @@ func (c *Checker) checkTreeWorker(ctx context.Context, in <-chan treeJob, out ch
 	}
 }
 
+// filterTrees sends the trees in backlog and all their subtrees to
+// loaderChan, and forwards the loaded trees received from in to out. Each tree
+// is only loaded once, even if it is referenced several times.
 func filterTrees(ctx context.Context, backlog restic.IDs, loaderChan chan<- restic.ID, in <-chan treeJob, out chan<- treeJob) {
 	defer func() {
 		debug.Log("closing output channels")
@@ -490,6 +526,16 @@ func filterTrees(ctx context.Context, backlog restic.IDs, loaderChan chan<- rest
 		close(out)
 	}()
 
+	seen := restic.NewIDSet()
+	enqueued := backlog[:0]
+	for _, id := range backlog {
+		if !seen.Has(id) {
+			seen.Insert(id)
+			enqueued = append(enqueued, id)
+		}
+	}
+	backlog = enqueued
+
 	var (
 		inCh                    = in
 		outCh                   = out
@@ -550,6 +596,10 @@ func filterTrees(ctx context.Context, backlog restic.IDs, loaderChan chan<- rest
 						debug.Log("tree %v has nil subtree", j.ID)
 						continue
 					}
+					if seen.Has(id) {
+						continue
+					}
+					seen.Insert(id)
 					backlog = append(backlog, id)
 				}
 			}
@@ -592,8 +642,10 @@ func (c *Checker) Structure(ctx context.Context, errChan chan<- error) {
 	treeJobChan1 := make(chan treeJob)
 	treeJobChan2 := make(chan treeJob)
 
+	debug.Log("using %d workers to load and check trees", c.parallelism)
+
 	var wg sync.WaitGroup
-	for i := 0; i < defaultParallelism; i++ {
+	for i := 0; i < c.parallelism; i++ {
 		wg.Add(2)
 		go loadTreeWorker(ctx, c.repo, treeIDChan, treeJobChan1, &wg)
 		go c.checkTreeWorker(ctx, treeJobChan2, errChan, &wg)
@@ -625,7 +677,7 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 				blobs = append(blobs, blobID)
 				blobSize, found := c.repo.LookupBlobSize(blobID, restic.DataBlob)
 				if !found {
-					errs = append(errs, Error{TreeID: id, Err: errors.Errorf("file %q blob %d size could not be found", node.Name, b)})
+					errs = append(errs, Error{TreeID: id, Err: errors.WithCode(errors.CodeBlobMissing, errors.Errorf("file %q blob %d size could not be found", node.Name, b))})
 				}
 				size += uint64(blobSize)
 			}
@@ -652,16 +704,18 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 		}
 	}
 
+	// take the lock only once per tree, it is shared by all workers
+	c.blobRefs.Lock()
 	for _, blobID := range blobs {
-		c.blobRefs.Lock()
 		c.blobRefs.M[blobID]++
-		debug.Log("blob %v refcount %d", blobID, c.blobRefs.M[blobID])
-		c.blobRefs.Unlock()
+	}
+	c.blobRefs.Unlock()
 
+	for _, blobID := range blobs {
 		if !c.blobs.Has(blobID) {
 			debug.Log("tree %v references blob %v which isn't contained in index", id, blobID)
 
-			errs = append(errs, Error{TreeID: id, BlobID: blobID, Err: errors.New("not found in index")})
+			errs = append(errs, Error{TreeID: id, BlobID: blobID, Err: errors.WithCode(errors.CodeBlobMissing, errors.New("not found in index"))})
 		}
 	}
 
@@ -694,70 +748,106 @@ func (c *Checker) GetPacks() restic.IDSet {
 	return c.packs
 }
 
-// checkPack reads a pack and checks the integrity of all blobs.
+// checkPack reads a pack and checks the integrity of all blobs. The pack is
+// streamed from the backend and verified blob by blob, so that at most one
+// blob is held in memory and no temporary file is needed.
 func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	debug.Log("checking pack %v", id)
 	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
 
-	packfile, hash, size, err := repository.DownloadAndHash(ctx, r.Backend(), h)
+	fi, err := r.Backend().Stat(ctx, h)
 	if err != nil {
 		return errors.Wrap(err, "checkPack")
 	}
 
-	defer func() {
-		_ = packfile.Close()
-		_ = os.Remove(packfile.Name())
-	}()
-
-	debug.Log("hash for pack %v is %v", id, hash)
-
-	if !hash.Equal(id) {
-		debug.Log("Pack ID does not match, want %v, got %v", id, hash)
-		return errors.Errorf("Pack ID does not match, want %v, got %v", id.Str(), hash.Str())
-	}
-
-	blobs, err := pack.List(r.Key(), packfile, size)
+	// the header is stored at the end of the pack, load it first to find the
+	// blobs in the data which is streamed afterwards
+	blobs, err := pack.List(r.Key(), restic.ReaderAt(r.Backend(), h), fi.Size)
 	if err != nil {
 		return err
 	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Offset < blobs[j].Offset
+	})
 
-	var errs []error
-	var buf []byte
+	// downloading a truncated pack again does not help, so fail before the
+	// backend retries the download
 	for i, blob := range blobs {
-		debug.Log("  check blob %d: %v", i, blob)
-
-		buf = buf[:cap(buf)]
-		if uint(len(buf)) < blob.Length {
-			buf = make([]byte, blob.Length)
+		if int64(blob.Offset+blob.Length) > fi.Size {
+			return errors.Errorf("pack %v is truncated, blob %v ends at %v beyond the pack size %v",
+				id.Str(), i, blob.Offset+blob.Length, fi.Size)
 		}
-		buf = buf[:blob.Length]
+	}
 
-		_, err := packfile.Seek(int64(blob.Offset), 0)
-		if err != nil {
-			return errors.Errorf("Seek(%v): %v", blob.Offset, err)
-		}
+	var hash restic.ID
+	var errs []error
+	err = r.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		// Only errors while reading are returned, the backend retries the
+		// download for them. Blobs which cannot be decrypted or have the wrong
+		// hash are recorded instead, downloading them again would not change
+		// the result. The function is called again when the download is
+		// retried.
+		errs = errs[:0]
+
+		hrd := hashing.NewReader(rd, sha256.New())
+		var buf []byte
+		var pos uint
+		for i, blob := range blobs {
+			debug.Log("  check blob %d: %v", i, blob)
 
-		_, err = io.ReadFull(packfile, buf)
-		if err != nil {
-			debug.Log("  error loading blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
-			continue
-		}
+			if blob.Offset < pos {
+				errs = append(errs, errors.Errorf("blob %v: overlaps the previous blob", i))
+				continue
+			}
+
+			// skip data which does not belong to any blob
+			if _, err := io.CopyN(ioutil.Discard, hrd, int64(blob.Offset-pos)); err != nil {
+				return err
+			}
+
+			buf = buf[:cap(buf)]
+			if uint(len(buf)) < blob.Length {
+				buf = make([]byte, blob.Length)
+			}
+			buf = buf[:blob.Length]
+
+			if _, err := io.ReadFull(hrd, buf); err != nil {
+				return err
+			}
+			pos = blob.Offset + blob.Length
 
-		nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
-		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
-		if err != nil {
-			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
-			continue
+			nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
+			plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+			if err != nil {
+				debug.Log("  error decrypting blob %v: %v", blob.ID, err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+
+			hash := restic.Hash(plaintext)
+			if !hash.Equal(blob.ID) {
+				debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
+				errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
+				continue
+			}
 		}
 
-		hash := restic.Hash(plaintext)
-		if !hash.Equal(blob.ID) {
-			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
-			errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
-			continue
+		// the header is part of the pack ID
+		if _, err := io.Copy(ioutil.Discard, hrd); err != nil {
+			return err
 		}
+		hash = restic.IDFromHash(hrd.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "checkPack")
+	}
+
+	debug.Log("hash for pack %v is %v", id, hash)
+
+	if !hash.Equal(id) {
+		debug.Log("Pack ID does not match, want %v, got %v", id, hash)
+		return errors.Errorf("Pack ID does not match, want %v, got %v", id.Str(), hash.Str())
 	}
 
 	if len(errs) > 0 {
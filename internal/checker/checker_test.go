@@ -8,10 +8,13 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/test"
@@ -164,23 +167,27 @@ func TestUnreferencedBlobs(t *testing.T) {
 
 	sort.Sort(unusedBlobsBySnapshot)
 
-	chkr := checker.New(repo)
-	hints, errs := chkr.LoadIndex(context.TODO())
-	if len(errs) > 0 {
-		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
-	}
+	// the result must not depend on the number of workers
+	for _, parallelism := range []int{1, 2, 16} {
+		chkr := checker.New(repo)
+		chkr.SetParallelism(parallelism)
+		hints, errs := chkr.LoadIndex(context.TODO())
+		if len(errs) > 0 {
+			t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+		}
 
-	if len(hints) > 0 {
-		t.Errorf("expected no hints, got %v: %v", len(hints), hints)
-	}
+		if len(hints) > 0 {
+			t.Errorf("expected no hints, got %v: %v", len(hints), hints)
+		}
 
-	test.OKs(t, checkPacks(chkr))
-	test.OKs(t, checkStruct(chkr))
+		test.OKs(t, checkPacks(chkr))
+		test.OKs(t, checkStruct(chkr))
 
-	blobs := chkr.UnusedBlobs()
-	sort.Sort(blobs)
+		blobs := chkr.UnusedBlobs()
+		sort.Sort(blobs)
 
-	test.Equals(t, unusedBlobsBySnapshot, blobs)
+		test.Equals(t, unusedBlobsBySnapshot, blobs)
+	}
 }
 
 func TestModifiedIndex(t *testing.T) {
@@ -363,6 +370,108 @@ func TestCheckerModifiedData(t *testing.T) {
 	}
 }
 
+// loadCountBackend counts the downloads of complete data files, the first
+// download of each file fails after reading some data if FailFirst is set.
+type loadCountBackend struct {
+	restic.Backend
+	FailFirst bool
+
+	m     sync.Mutex
+	loads map[string]int
+}
+
+func (b *loadCountBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, consumer func(rd io.Reader) error) error {
+	if h.Type != restic.DataFile || length != 0 || offset != 0 {
+		return b.Backend.Load(ctx, h, length, offset, consumer)
+	}
+
+	b.m.Lock()
+	b.loads[h.Name]++
+	first := b.loads[h.Name] == 1
+	b.m.Unlock()
+
+	return b.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		if b.FailFirst && first {
+			return consumer(io.MultiReader(io.LimitReader(rd, 10), failingReader{}))
+		}
+		return consumer(rd)
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestCheckerRetryTransientErrors(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	archiver.TestSnapshot(t, repo, ".", nil)
+
+	be := &loadCountBackend{Backend: repo.Backend(), loads: make(map[string]int)}
+	checkRepo := repository.New(backend.NewRetryBackend(be, 2, nil))
+	test.OK(t, checkRepo.SearchKey(context.TODO(), test.TestPassword, 5, ""))
+
+	chkr := checker.New(checkRepo)
+	_, errs := chkr.LoadIndex(context.TODO())
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+
+	// interrupted downloads are retried
+	be.FailFirst = true
+	if errs := checkData(chkr); len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+	for name, n := range be.loads {
+		if n != 2 {
+			t.Errorf("pack %v was downloaded %d times, want 2", name, n)
+		}
+	}
+}
+
+func TestCheckerNoRetryForCorruptedPack(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	archiver.TestSnapshot(t, repo, ".", nil)
+
+	// modify the first blob of a pack, which cannot be decrypted afterwards
+	var h restic.Handle
+	test.OK(t, repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		h = restic.Handle{Type: restic.DataFile, Name: id.String()}
+		return nil
+	}))
+
+	var buf []byte
+	test.OK(t, repo.Backend().Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	}))
+	buf[20] ^= 1
+	test.OK(t, repo.Backend().Remove(context.TODO(), h))
+	test.OK(t, repo.Backend().Save(context.TODO(), h, restic.NewByteReader(buf)))
+
+	be := &loadCountBackend{Backend: repo.Backend(), loads: make(map[string]int)}
+	checkRepo := repository.New(backend.NewRetryBackend(be, 2, nil))
+	test.OK(t, checkRepo.SearchKey(context.TODO(), test.TestPassword, 5, ""))
+
+	chkr := checker.New(checkRepo)
+	_, errs := chkr.LoadIndex(context.TODO())
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+
+	if errs := checkData(chkr); len(errs) != 1 {
+		t.Fatalf("expected one error, got %v: %v", len(errs), errs)
+	}
+	if n := be.loads[h.Name]; n != 1 {
+		t.Errorf("corrupted pack was downloaded %d times, want 1", n)
+	}
+}
+
 func BenchmarkChecker(t *testing.B) {
 	repodir, cleanup := test.Env(t, checkerTestData)
 	defer cleanup()
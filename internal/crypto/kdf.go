@@ -7,6 +7,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 
 	sscrypt "github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -26,6 +27,19 @@ var DefaultKDFParams = Params{
 	P: sscrypt.DefaultParams.P,
 }
 
+// Check returns an error if the parameters are not valid.
+func (p Params) Check() error {
+	params := sscrypt.Params{
+		N:       p.N,
+		R:       p.R,
+		P:       p.P,
+		DKLen:   sscrypt.DefaultParams.DKLen,
+		SaltLen: saltLength,
+	}
+
+	return errors.Wrap(params.Check(), "scrypt")
+}
+
 // Calibrate determines new KDF parameters for the current hardware.
 func Calibrate(timeout time.Duration, memory int) (Params, error) {
 	defaultParams := sscrypt.Params{
@@ -89,6 +103,91 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 	return derKeys, nil
 }
 
+// Argon2Params are the parameters for the key derivation function Argon2KDF().
+type Argon2Params struct {
+	Time    uint32 // number of passes over the memory
+	Memory  uint32 // memory in KiB
+	Threads uint8
+}
+
+// DefaultArgon2Params are the default parameters used for Argon2KDF(), as
+// recommended in RFC 9106 for memory-constrained environments.
+var DefaultArgon2Params = Argon2Params{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Check returns an error if the parameters are not valid.
+func (p Argon2Params) Check() error {
+	if p.Time < 1 {
+		return errors.New("argon2id: time must be at least 1")
+	}
+	if p.Threads < 1 {
+		return errors.New("argon2id: threads must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return errors.Errorf("argon2id: memory must be at least %d KiB", 8*uint32(p.Threads))
+	}
+	return nil
+}
+
+// maxArgon2Time limits the number of passes chosen by CalibrateArgon2.
+const maxArgon2Time = 1000
+
+// CalibrateArgon2 determines the number of passes for argon2id with the memory
+// and threads of p, so that deriving a key takes about timeout on the current
+// hardware. At least one pass is used.
+func CalibrateArgon2(timeout time.Duration, p Argon2Params) (Argon2Params, error) {
+	p.Time = 1
+	if err := p.Check(); err != nil {
+		return p, err
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return p, err
+	}
+
+	start := time.Now()
+	argon2.IDKey([]byte("calibrate"), salt, p.Time, p.Memory, p.Threads, uint32(macKeySize+aesKeySize))
+	d := time.Since(start)
+
+	// the runtime grows linearly with the number of passes
+	if d > 0 && d < timeout {
+		passes := int64(timeout / d)
+		if passes > maxArgon2Time {
+			passes = maxArgon2Time
+		}
+		p.Time = uint32(passes)
+	}
+
+	return p, nil
+}
+
+// Argon2KDF derives encryption and message authentication keys from the
+// password using argon2id with the supplied parameters and the salt.
+func Argon2KDF(p Argon2Params, salt []byte, password string) (*Key, error) {
+	if len(salt) != saltLength {
+		return nil, errors.Errorf("argon2id() called with invalid salt bytes (len %d)", len(salt))
+	}
+
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
+	keybytes := macKeySize + aesKeySize
+	buf := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(keybytes))
+
+	derKeys := &Key{}
+
+	// same layout as for scrypt: encryption key followed by the mac key
+	copy(derKeys.EncryptionKey[:], buf[:aesKeySize])
+	macKeyFromSlice(&derKeys.MACKey, buf[aesKeySize:])
+
+	return derKeys, nil
+}
+
 // NewSalt returns new random salt bytes to use with KDF(). If NewSalt returns
 // an error, this is a grave situation and the program must abort and terminate.
 func NewSalt() ([]byte, error) {
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"bytes"
 	"testing"
 	"time"
 )
@@ -12,3 +13,73 @@ func TestCalibrate(t *testing.T) {
 	}
 	t.Logf("testing calibrate, params after: %v", params)
 }
+
+func TestCalibrateArgon2(t *testing.T) {
+	params, err := CalibrateArgon2(50*time.Millisecond, Argon2Params{Time: 3, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Time < 1 || params.Memory != 64 || params.Threads != 1 {
+		t.Fatalf("invalid parameters after calibration: %v", params)
+	}
+	t.Logf("testing calibrate, params after: %v", params)
+}
+
+func TestArgon2KDF(t *testing.T) {
+	params := Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1, err := Argon2KDF(params, salt, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := Argon2KDF(params, salt, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(k1.EncryptionKey[:], k2.EncryptionKey[:]) {
+		t.Fatal("derived keys differ for the same password and salt")
+	}
+
+	k3, err := Argon2KDF(params, salt, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(k1.EncryptionKey[:], k3.EncryptionKey[:]) {
+		t.Fatal("derived keys are equal for different passwords")
+	}
+
+	if !k1.Valid() {
+		t.Fatal("derived key is not valid")
+	}
+}
+
+func TestArgon2ParamsCheck(t *testing.T) {
+	var tests = []struct {
+		params Argon2Params
+		valid  bool
+	}{
+		{DefaultArgon2Params, true},
+		{Argon2Params{Time: 1, Memory: 8, Threads: 1}, true},
+		{Argon2Params{Time: 0, Memory: 64, Threads: 1}, false},
+		{Argon2Params{Time: 1, Memory: 64, Threads: 0}, false},
+		{Argon2Params{Time: 1, Memory: 16, Threads: 4}, false},
+	}
+
+	for _, test := range tests {
+		err := test.params.Check()
+		if test.valid && err != nil {
+			t.Errorf("params %v: unexpected error %v", test.params, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("params %v: expected error, got nil", test.params)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 
 	"github.com/restic/restic/internal/fs"
 
@@ -17,9 +18,14 @@ import (
 )
 
 var opts struct {
-	logger *log.Logger
-	funcs  map[string]bool
-	files  map[string]bool
+	logger  *log.Logger
+	funcs   map[string]bool
+	files   map[string]bool
+	modules map[string]bool
+
+	// paused is set to 1 while debug logging is paused, it is accessed
+	// atomically.
+	paused int32
 }
 
 // make sure that all the initialization happens before the init() functions
@@ -29,6 +35,7 @@ var _ = initDebug()
 func initDebug() bool {
 	initDebugLogger()
 	initDebugTags()
+	initToggle()
 
 	fmt.Fprintf(os.Stderr, "debug enabled\n")
 
@@ -124,6 +131,104 @@ func padFile(s string) string {
 func initDebugTags() {
 	opts.funcs = parseFilter("DEBUG_FUNCS", padFunc)
 	opts.files = parseFilter("DEBUG_FILES", padFile)
+
+	if env := os.Getenv("DEBUG_MODULES"); env != "" {
+		if err := SetModules(strings.Split(env, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(5)
+		}
+	}
+}
+
+// SetModules restricts the debug log to the given modules. A module is the
+// path of a package below internal/ with dots as separators, e.g. "archiver"
+// or "backend.s3", messages from the main package belong to the module
+// "main". A module includes all of its sub-modules, a module prefixed with "-"
+// is excluded. Without modules, all messages are logged. SetModules must be
+// called before other goroutines use the debug log.
+func SetModules(list []string) error {
+	modules := make(map[string]bool)
+	for _, m := range list {
+		m = strings.TrimSpace(m)
+		val := true
+		if strings.HasPrefix(m, "-") {
+			val = false
+			m = m[1:]
+		}
+
+		if m == "" || strings.ContainsAny(m, "/*?[") {
+			return errors.Errorf("invalid debug module %q", m)
+		}
+
+		modules[m] = val
+	}
+
+	opts.modules = modules
+	return nil
+}
+
+// moduleName returns the module for the fully qualified function name fn,
+// e.g. "backend.s3" for "github.com/restic/restic/internal/backend/s3.Open".
+func moduleName(fn string) string {
+	pkg := fn
+	slash := strings.LastIndex(pkg, "/")
+	if i := strings.Index(pkg[slash+1:], "."); i >= 0 {
+		pkg = pkg[:slash+1+i]
+	}
+
+	if i := strings.LastIndex(pkg, "/internal/"); i >= 0 {
+		pkg = pkg[i+len("/internal/"):]
+	}
+
+	return strings.Replace(pkg, "/", ".", -1)
+}
+
+// moduleEnabled returns true if messages of module should be logged.
+func moduleEnabled(module string) bool {
+	if len(opts.modules) == 0 {
+		return true
+	}
+
+	for m := module; ; {
+		if v, ok := opts.modules[m]; ok {
+			return v
+		}
+
+		i := strings.LastIndex(m, ".")
+		if i < 0 {
+			break
+		}
+		m = m[:i]
+	}
+
+	// if only exclusions are given, all other modules are logged
+	for _, v := range opts.modules {
+		if v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetPaused pauses or resumes the debug log.
+func SetPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&opts.paused, v)
+}
+
+// togglePaused pauses the debug log if it is running and resumes it
+// otherwise. It returns true if the debug log is paused afterwards.
+func togglePaused() bool {
+	for {
+		old := atomic.LoadInt32(&opts.paused)
+		if atomic.CompareAndSwapInt32(&opts.paused, old, 1-old) {
+			return old == 0
+		}
+	}
 }
 
 // taken from https://github.com/VividCortex/trace
@@ -137,17 +242,17 @@ func goroutineNum() int {
 }
 
 // taken from https://github.com/VividCortex/trace
-func getPosition() (fn, dir, file string, line int) {
+func getPosition() (fn, module, dir, file string, line int) {
 	pc, file, line, ok := runtime.Caller(2)
 	if !ok {
-		return "", "", "", 0
+		return "", "", "", "", 0
 	}
 
 	dirname, filename := filepath.Base(filepath.Dir(file)), filepath.Base(file)
 
 	Func := runtime.FuncForPC(pc)
 
-	return path.Base(Func.Name()), dirname, filename, line
+	return path.Base(Func.Name()), moduleName(Func.Name()), dirname, filename, line
 }
 
 func checkFilter(filter map[string]bool, key string) bool {
@@ -173,7 +278,15 @@ func checkFilter(filter map[string]bool, key string) bool {
 
 // Log prints a message to the debug log (if debug is enabled).
 func Log(f string, args ...interface{}) {
-	fn, dir, file, line := getPosition()
+	if atomic.LoadInt32(&opts.paused) != 0 {
+		return
+	}
+
+	fn, module, dir, file, line := getPosition()
+	if !moduleEnabled(module) {
+		return
+	}
+
 	goroutine := goroutineNum()
 
 	if len(f) == 0 || f[len(f)-1] != '\n' {
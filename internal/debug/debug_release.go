@@ -4,3 +4,9 @@ package debug
 
 // Log prints a message to the debug log (if debug is enabled).
 func Log(fmt string, args ...interface{}) {}
+
+// SetModules is a noop without the debug tag.
+func SetModules(list []string) error { return nil }
+
+// SetPaused is a noop without the debug tag.
+func SetPaused(paused bool) {}
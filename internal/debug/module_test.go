@@ -0,0 +1,49 @@
+// +build debug
+
+package debug
+
+import "testing"
+
+func TestModuleName(t *testing.T) {
+	for fn, module := range map[string]string{
+		"github.com/restic/restic/internal/archiver.(*Archiver).Save":  "archiver",
+		"github.com/restic/restic/internal/backend/s3.Open":            "backend.s3",
+		"github.com/restic/restic/internal/backend/s3.(*Backend).Load": "backend.s3",
+		"main.runBackup":       "main",
+		"main.runBackup.func1": "main",
+	} {
+		if got := moduleName(fn); got != module {
+			t.Errorf("moduleName(%q) = %q, want %q", fn, got, module)
+		}
+	}
+}
+
+func TestModuleEnabled(t *testing.T) {
+	defer func(modules map[string]bool) {
+		opts.modules = modules
+	}(opts.modules)
+
+	for _, test := range []struct {
+		modules []string
+		module  string
+		enabled bool
+	}{
+		{nil, "archiver", true},
+		{[]string{"archiver"}, "archiver", true},
+		{[]string{"archiver"}, "backend.s3", false},
+		{[]string{"backend"}, "backend.s3", true},
+		{[]string{"backend.s3"}, "backend", false},
+		{[]string{"backend", "-backend.rest"}, "backend.rest", false},
+		{[]string{"backend", "-backend.rest"}, "backend.s3", true},
+		{[]string{"-archiver"}, "archiver", false},
+		{[]string{"-archiver"}, "repository", true},
+	} {
+		if err := SetModules(test.modules); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := moduleEnabled(test.module); got != test.enabled {
+			t.Errorf("modules %v: moduleEnabled(%q) = %v, want %v", test.modules, test.module, got, test.enabled)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// +build debug,!windows
+
+package debug
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// initToggle pauses or resumes the debug log when SIGUSR1 is received.
+func initToggle() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			if togglePaused() {
+				fmt.Fprintf(os.Stderr, "debug log paused\n")
+			} else {
+				fmt.Fprintf(os.Stderr, "debug log resumed\n")
+			}
+		}
+	}()
+}
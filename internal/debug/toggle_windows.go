@@ -0,0 +1,6 @@
+// +build debug
+
+package debug
+
+// initToggle is a noop, there is no SIGUSR1 on Windows.
+func initToggle() {}
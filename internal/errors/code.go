@@ -0,0 +1,100 @@
+package errors
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+)
+
+// Code classifies an error so that programs calling restic can react to it
+// without parsing the error message. Codes are part of the JSON output and
+// must not be changed once released.
+type Code string
+
+// The error codes restic uses, which are documented in doc/075_scripting.rst.
+const (
+	CodeUnknown            Code = "ERR_UNKNOWN"
+	CodeBackendTimeout     Code = "ERR_BACKEND_TIMEOUT"
+	CodeBackendUnavailable Code = "ERR_BACKEND_UNAVAILABLE"
+	CodeBlobMissing        Code = "ERR_BLOB_MISSING"
+	CodeFileNotFound       Code = "ERR_FILE_NOT_FOUND"
+	CodeFilesSkipped       Code = "ERR_FILES_SKIPPED"
+	CodeInterrupted        Code = "ERR_INTERRUPTED"
+	CodePermissionDenied   Code = "ERR_PERMISSION_DENIED"
+	CodeQuotaExceeded      Code = "ERR_QUOTA_EXCEEDED"
+	CodeRepositoryDamaged  Code = "ERR_REPOSITORY_DAMAGED"
+	CodeRepositoryLocked   Code = "ERR_REPOSITORY_LOCKED"
+	CodeWrongPassword      Code = "ERR_WRONG_PASSWORD"
+)
+
+// codeError annotates an error with a Code.
+type codeError struct {
+	code Code
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the annotated error.
+func (e *codeError) Cause() error {
+	return e.err
+}
+
+// WithCode annotates err with code. If err is nil, WithCode returns nil.
+func WithCode(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &codeError{code: code, err: err}
+}
+
+// CodeOf returns the code of err. The outermost code err has been annotated
+// with by WithCode is used, otherwise the code is derived from well-known
+// errors such as timeouts and permission errors. For all other errors,
+// CodeUnknown is returned. If err is nil, CodeOf returns the empty string.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+
+	type Causer interface {
+		Cause() error
+	}
+
+	for e := err; e != nil; {
+		if ce, ok := e.(*codeError); ok {
+			return ce.code
+		}
+
+		switch c := e.(type) {
+		case *url.Error:
+			e = c.Err
+		case Causer:
+			e = c.Cause()
+		default:
+			e = nil
+		}
+	}
+
+	cause := Cause(err)
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return CodeBackendTimeout
+	}
+
+	switch {
+	case cause == context.DeadlineExceeded:
+		return CodeBackendTimeout
+	case cause == context.Canceled:
+		return CodeInterrupted
+	case os.IsNotExist(cause):
+		return CodeFileNotFound
+	case os.IsPermission(cause):
+		return CodePermissionDenied
+	}
+
+	return CodeUnknown
+}
@@ -0,0 +1,16 @@
+package fs
+
+// DiskSpace describes the free space of a file system.
+type DiskSpace struct {
+	// FreeBytes is the number of bytes available to the current user.
+	FreeBytes uint64
+
+	// BlockSize is the size in which space is allocated for files, it is
+	// zero if unknown.
+	BlockSize uint64
+
+	// FreeInodes is the number of free inodes, HasInodes is false if the
+	// file system does not have a fixed number of inodes.
+	FreeInodes uint64
+	HasInodes  bool
+}
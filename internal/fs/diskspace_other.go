@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!freebsd,!windows
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// FreeDiskSpace returns the free space of the file system path is located on.
+// It is not supported on this platform.
+func FreeDiskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, errors.New("determining the free disk space is not supported on this platform")
+}
@@ -0,0 +1,25 @@
+// +build linux darwin freebsd
+
+package fs
+
+import (
+	"syscall"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// FreeDiskSpace returns the free space of the file system path is located on.
+func FreeDiskSpace(path string) (DiskSpace, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(fixpath(path), &st)
+	if err != nil {
+		return DiskSpace{}, errors.Wrap(err, "Statfs")
+	}
+
+	return DiskSpace{
+		FreeBytes:  uint64(st.Bavail) * uint64(st.Bsize),
+		BlockSize:  uint64(st.Bsize),
+		FreeInodes: uint64(st.Ffree),
+		HasInodes:  st.Files > 0,
+	}, nil
+}
@@ -0,0 +1,25 @@
+// +build windows
+
+package fs
+
+import (
+	"golang.org/x/sys/windows"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// FreeDiskSpace returns the free space of the file system path is located on.
+func FreeDiskSpace(path string) (DiskSpace, error) {
+	p, err := windows.UTF16PtrFromString(fixpath(path))
+	if err != nil {
+		return DiskSpace{}, errors.Wrap(err, "UTF16PtrFromString")
+	}
+
+	var free, total, totalFree uint64
+	err = windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree)
+	if err != nil {
+		return DiskSpace{}, errors.Wrap(err, "GetDiskFreeSpaceEx")
+	}
+
+	return DiskSpace{FreeBytes: free}, nil
+}
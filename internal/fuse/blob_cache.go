@@ -0,0 +1,161 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"golang.org/x/net/context"
+)
+
+// blobCache is a LRU cache for the contents of blobs, which is bounded by the
+// total size of the blobs. Blobs which are requested concurrently are only
+// loaded once. A nil *blobCache loads all blobs from the repository.
+type blobCache struct {
+	m       sync.Mutex
+	size    int
+	free    int
+	lru     *list.List
+	entries map[restic.BlobHandle]*list.Element
+	loading map[restic.BlobHandle]chan struct{}
+}
+
+type blobCacheEntry struct {
+	h   restic.BlobHandle
+	buf []byte
+}
+
+// newBlobCache returns a cache for blobs with the total size in bytes.
+func newBlobCache(size int) *blobCache {
+	return &blobCache{
+		size:    size,
+		free:    size,
+		lru:     list.New(),
+		entries: make(map[restic.BlobHandle]*list.Element),
+		loading: make(map[restic.BlobHandle]chan struct{}),
+	}
+}
+
+// add inserts the blob and evicts the least recently used blobs until it
+// fits. The caller must hold the mutex.
+func (c *blobCache) add(h restic.BlobHandle, buf []byte) {
+	if len(buf) > c.size {
+		return
+	}
+	if _, ok := c.entries[h]; ok {
+		return
+	}
+
+	for c.free < len(buf) {
+		e := c.lru.Back()
+		old := c.lru.Remove(e).(*blobCacheEntry)
+		delete(c.entries, old.h)
+		c.free += len(old.buf)
+	}
+
+	c.entries[h] = c.lru.PushFront(&blobCacheEntry{h: h, buf: buf})
+	c.free -= len(buf)
+}
+
+// load returns the blob h, which is loaded from the repository unless it is
+// cached. The returned buffer must not be modified.
+func (c *blobCache) load(ctx context.Context, repo restic.Repository, h restic.BlobHandle) ([]byte, error) {
+	if c == nil {
+		return repo.LoadBlob(ctx, h.Type, h.ID, nil)
+	}
+
+	c.m.Lock()
+	for {
+		if e, ok := c.entries[h]; ok {
+			c.lru.MoveToFront(e)
+			buf := e.Value.(*blobCacheEntry).buf
+			c.m.Unlock()
+			return buf, nil
+		}
+
+		ch, ok := c.loading[h]
+		if !ok {
+			break
+		}
+
+		// wait for the blob which is loaded by someone else
+		c.m.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.m.Lock()
+	}
+
+	ch := make(chan struct{})
+	c.loading[h] = ch
+	c.m.Unlock()
+
+	buf, err := repo.LoadBlob(ctx, h.Type, h.ID, nil)
+
+	c.m.Lock()
+	delete(c.loading, h)
+	close(ch)
+	if err == nil {
+		c.add(h, buf)
+	}
+	c.m.Unlock()
+
+	return buf, err
+}
+
+// prefetch loads the blobs in the background, unless they are cached or
+// already being loaded. Loading stops when ctx is cancelled.
+func (c *blobCache) prefetch(ctx context.Context, repo restic.Repository, handles []restic.BlobHandle) {
+	if c == nil {
+		return
+	}
+
+	c.m.Lock()
+	var missing []restic.BlobHandle
+	for _, h := range handles {
+		_, cached := c.entries[h]
+		_, loading := c.loading[h]
+		if !cached && !loading {
+			missing = append(missing, h)
+		}
+	}
+	c.m.Unlock()
+
+	if len(missing) == 0 {
+		return
+	}
+
+	go func() {
+		for _, h := range missing {
+			if _, err := c.load(ctx, repo, h); err != nil {
+				debug.Log("prefetching %v failed: %v", h, err)
+				return
+			}
+		}
+	}()
+}
+
+// loadTree loads the tree id, using the blob cache.
+func (r *Root) loadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	buf, err := r.blobCache.load(ctx, r.repo, restic.BlobHandle{ID: id, Type: restic.TreeBlob})
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &restic.Tree{}
+	err = json.Unmarshal(buf, tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return tree, nil
+}
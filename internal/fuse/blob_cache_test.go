@@ -0,0 +1,42 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBlobCacheEvict(t *testing.T) {
+	c := newBlobCache(10)
+
+	handles := make([]restic.BlobHandle, 4)
+	for i := range handles {
+		handles[i] = restic.BlobHandle{ID: restic.NewRandomID(), Type: restic.DataBlob}
+	}
+
+	c.add(handles[0], make([]byte, 4))
+	c.add(handles[1], make([]byte, 4))
+	rtest.Equals(t, 2, len(c.entries))
+	rtest.Equals(t, 2, c.free)
+
+	// the least recently used blob is evicted
+	c.lru.MoveToFront(c.entries[handles[0]])
+	c.add(handles[2], make([]byte, 4))
+	rtest.Equals(t, 2, len(c.entries))
+	_, ok := c.entries[handles[1]]
+	rtest.Assert(t, !ok, "blob 1 was not evicted")
+	_, ok = c.entries[handles[0]]
+	rtest.Assert(t, ok, "blob 0 was evicted")
+
+	// blobs larger than the cache are not added
+	c.add(handles[3], make([]byte, 11))
+	_, ok = c.entries[handles[3]]
+	rtest.Assert(t, !ok, "blob larger than the cache was added")
+	rtest.Equals(t, 2, c.free)
+}
@@ -7,7 +7,9 @@ package fuse
 
 import (
 	"os"
+	"path"
 	"path/filepath"
+	"sync"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -28,16 +30,23 @@ type dir struct {
 	parentInode uint64
 	node        *restic.Node
 
-	blobsize *BlobSizeCache
+	// path is the path of the directory within the overlay of a writable
+	// mount, which starts with the snapshot ID
+	path string
+
+	// snapshot is set for the top-level directory of a snapshot, its items
+	// are loaded on first access
+	snapshot *restic.Snapshot
+	m        sync.Mutex
 }
 
 func cleanupNodeName(name string) string {
 	return filepath.Base(name)
 }
 
-func newDir(ctx context.Context, root *Root, inode, parentInode uint64, node *restic.Node) (*dir, error) {
+func newDir(ctx context.Context, root *Root, inode, parentInode uint64, node *restic.Node, path string) (*dir, error) {
 	debug.Log("new dir for %v (%v)", node.Name, node.Subtree)
-	tree, err := root.repo.LoadTree(ctx, *node.Subtree)
+	tree, err := root.loadTree(ctx, *node.Subtree)
 	if err != nil {
 		debug.Log("  error loading tree %v: %v", node.Subtree, err)
 		return nil, err
@@ -53,12 +62,13 @@ func newDir(ctx context.Context, root *Root, inode, parentInode uint64, node *re
 		items:       items,
 		inode:       inode,
 		parentInode: parentInode,
+		path:        path,
 	}, nil
 }
 
 // replaceSpecialNodes replaces nodes with name "." and "/" by their contents.
 // Otherwise, the node is returned.
-func replaceSpecialNodes(ctx context.Context, repo restic.Repository, node *restic.Node) ([]*restic.Node, error) {
+func replaceSpecialNodes(ctx context.Context, root *Root, node *restic.Node) ([]*restic.Node, error) {
 	if node.Type != "dir" || node.Subtree == nil {
 		return []*restic.Node{node}, nil
 	}
@@ -67,7 +77,7 @@ func replaceSpecialNodes(ctx context.Context, repo restic.Repository, node *rest
 		return []*restic.Node{node}, nil
 	}
 
-	tree, err := repo.LoadTree(ctx, *node.Subtree)
+	tree, err := root.loadTree(ctx, *node.Subtree)
 	if err != nil {
 		return nil, err
 	}
@@ -77,17 +87,48 @@ func replaceSpecialNodes(ctx context.Context, repo restic.Repository, node *rest
 
 func newDirFromSnapshot(ctx context.Context, root *Root, inode uint64, snapshot *restic.Snapshot) (*dir, error) {
 	debug.Log("new dir for snapshot %v (%v)", snapshot.ID(), snapshot.Tree)
-	tree, err := root.repo.LoadTree(ctx, *snapshot.Tree)
+
+	mode := os.FileMode(0555)
+	if root.overlay != nil {
+		mode = 0755
+	}
+
+	return &dir{
+		root: root,
+		node: &restic.Node{
+			AccessTime: snapshot.Time,
+			ModTime:    snapshot.Time,
+			ChangeTime: snapshot.Time,
+			Mode:       os.ModeDir | mode,
+		},
+		inode:    inode,
+		path:     snapshot.ID().String(),
+		snapshot: snapshot,
+	}, nil
+}
+
+// loadItems loads the items of the top-level directory of a snapshot. This
+// is deferred until they are needed, so that listing the directories which
+// contain many snapshots does not load the trees of all of them.
+func (d *dir) loadItems(ctx context.Context) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.items != nil || d.snapshot == nil {
+		return nil
+	}
+
+	tree, err := d.root.loadTree(ctx, *d.snapshot.Tree)
 	if err != nil {
-		debug.Log("  loadTree(%v) failed: %v", snapshot.ID(), err)
-		return nil, err
+		debug.Log("  loadTree(%v) failed: %v", d.snapshot.ID(), err)
+		return err
 	}
 	items := make(map[string]*restic.Node)
 	for _, n := range tree.Nodes {
-		nodes, err := replaceSpecialNodes(ctx, root.repo, n)
+		nodes, err := replaceSpecialNodes(ctx, d.root, n)
 		if err != nil {
 			debug.Log("  replaceSpecialNodes(%v) failed: %v", n, err)
-			return nil, err
+			return err
 		}
 
 		for _, node := range nodes {
@@ -95,17 +136,8 @@ func newDirFromSnapshot(ctx context.Context, root *Root, inode uint64, snapshot
 		}
 	}
 
-	return &dir{
-		root: root,
-		node: &restic.Node{
-			AccessTime: snapshot.Time,
-			ModTime:    snapshot.Time,
-			ChangeTime: snapshot.Time,
-			Mode:       os.ModeDir | 0555,
-		},
-		items: items,
-		inode: inode,
-	}, nil
+	d.items = items
+	return nil
 }
 
 func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
@@ -124,6 +156,15 @@ func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
 }
 
 func (d *dir) calcNumberOfLinks() uint32 {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	// the number of subdirectories is not known before the items are loaded,
+	// which tools like find treat as one link
+	if d.items == nil {
+		return 1
+	}
+
 	// a directory d has 2 hardlinks + the number
 	// of directories contained by d
 	var count uint32
@@ -138,6 +179,10 @@ func (d *dir) calcNumberOfLinks() uint32 {
 
 func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	debug.Log("ReadDirAll()")
+	if err := d.loadItems(ctx); err != nil {
+		return nil, err
+	}
+
 	ret := make([]fuse.Dirent, 0, len(d.items)+2)
 
 	ret = append(ret, fuse.Dirent{
@@ -152,6 +197,7 @@ func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		Type:  fuse.DT_Dir,
 	})
 
+	types := make(map[string]fuse.DirentType, len(d.items))
 	for _, node := range d.items {
 		name := cleanupNodeName(node.Name)
 		var typ fuse.DirentType
@@ -163,7 +209,16 @@ func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		case "symlink":
 			typ = fuse.DT_Link
 		}
+		types[name] = typ
+	}
 
+	if d.root.overlay != nil {
+		if err := d.mergeOverlay(types); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, typ := range types {
 		ret = append(ret, fuse.Dirent{
 			Inode: fs.GenerateDynamicInode(d.inode, name),
 			Type:  typ,
@@ -176,6 +231,17 @@ func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 
 func (d *dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	debug.Log("Lookup(%v)", name)
+	if err := d.loadItems(ctx); err != nil {
+		return nil, err
+	}
+
+	if d.root.overlay != nil {
+		node, err := d.lookupOverlay(ctx, name, fs.GenerateDynamicInode(d.inode, name))
+		if node != nil || err != nil {
+			return node, err
+		}
+	}
+
 	node, ok := d.items[name]
 	if !ok {
 		debug.Log("  Lookup(%v) -> not found", name)
@@ -183,8 +249,13 @@ func (d *dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	}
 	switch node.Type {
 	case "dir":
-		return newDir(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), d.inode, node)
+		return newDir(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), d.inode, node, path.Join(d.path, name))
 	case "file":
+		if d.root.overlay != nil {
+			return d.root.overlay.lookupFile(path.Join(d.path, name), func() (*file, error) {
+				return newFile(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), node)
+			})
+		}
 		return newFile(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), node)
 	case "symlink":
 		return newLink(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), node)
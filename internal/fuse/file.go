@@ -6,6 +6,9 @@
 package fuse
 
 import (
+	"os"
+	"sync"
+
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 
@@ -19,6 +22,10 @@ import (
 // The default block size to report in stat
 const blockSize = 512
 
+// The number of blobs which are loaded in advance when a file is read
+// sequentially
+const readaheadBlobs = 4
+
 // Statically ensure that *file implements the given interface
 var _ = fs.HandleReader(&file{})
 var _ = fs.HandleReleaser(&file{})
@@ -28,8 +35,20 @@ type file struct {
 	node  *restic.Node
 	inode uint64
 
+	// path is the path of the file within the overlay of a writable mount,
+	// node is nil for files which only exist in the overlay directory
+	path string
+
 	sizes []int
-	blobs [][]byte
+
+	// lastBlob is the index of the blob which was read last
+	m        sync.Mutex
+	lastBlob int
+
+	// blob is the content of the blob at blobIndex, it is kept if the mount
+	// has no blob cache so that small reads do not load the blob repeatedly
+	blob      []byte
+	blobIndex int
 }
 
 func newFile(ctx context.Context, root *Root, inode uint64, node *restic.Node) (fusefile *file, err error) {
@@ -37,13 +56,9 @@ func newFile(ctx context.Context, root *Root, inode uint64, node *restic.Node) (
 	var bytes uint64
 	sizes := make([]int, len(node.Content))
 	for i, id := range node.Content {
-		size, ok := root.blobSizeCache.Lookup(id)
-		if !ok {
-			var found bool
-			size, found = root.repo.LookupBlobSize(id, restic.DataBlob)
-			if !found {
-				return nil, errors.Errorf("id %v not found in repository", id)
-			}
+		size, found := root.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return nil, errors.Errorf("id %v not found in repository", id)
 		}
 
 		sizes[i] = int(size)
@@ -56,15 +71,27 @@ func newFile(ctx context.Context, root *Root, inode uint64, node *restic.Node) (
 	}
 
 	return &file{
-		inode: inode,
-		root:  root,
-		node:  node,
-		sizes: sizes,
-		blobs: make([][]byte, len(node.Content)),
+		inode:    inode,
+		root:     root,
+		node:     node,
+		sizes:    sizes,
+		lastBlob: -1,
 	}, nil
 }
 
 func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
+	if upper, ok := f.upper(); ok {
+		fi, err := os.Lstat(upper)
+		if err != nil {
+			return toErrno(err)
+		}
+		f.root.upperAttr(f.inode, fi, a)
+		return nil
+	}
+	if f.node == nil {
+		return fuse.ENOENT
+	}
+
 	debug.Log("Attr(%v)", f.node.Name)
 	a.Inode = f.inode
 	a.Mode = f.node.Mode
@@ -73,10 +100,7 @@ func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.BlockSize = blockSize
 	a.Nlink = uint32(f.node.Links)
 
-	if !f.root.cfg.OwnerIsRoot {
-		a.Uid = f.node.UID
-		a.Gid = f.node.GID
-	}
+	a.Uid, a.Gid = f.root.owner(f.node)
 	a.Atime = f.node.AccessTime
 	a.Ctime = f.node.ChangeTime
 	a.Mtime = f.node.ModTime
@@ -87,25 +111,58 @@ func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
 
 func (f *file) getBlobAt(ctx context.Context, i int) (blob []byte, err error) {
 	debug.Log("getBlobAt(%v, %v)", f.node.Name, i)
-	if f.blobs[i] != nil {
-		return f.blobs[i], nil
-	}
 
-	// release earlier blobs
-	for j := 0; j < i; j++ {
-		f.blobs[j] = nil
+	noCache := f.root.blobCache == nil
+	if noCache {
+		f.m.Lock()
+		blob = f.blob
+		cached := blob != nil && f.blobIndex == i
+		f.m.Unlock()
+		if cached {
+			return blob, nil
+		}
 	}
 
-	blob, err = f.root.repo.LoadBlob(ctx, restic.DataBlob, f.node.Content[i], nil)
+	blob, err = f.root.blobCache.load(ctx, f.root.repo, restic.BlobHandle{ID: f.node.Content[i], Type: restic.DataBlob})
 	if err != nil {
 		debug.Log("LoadBlob(%v, %v) failed: %v", f.node.Name, f.node.Content[i], err)
 		return nil, err
 	}
-	f.blobs[i] = blob
+
+	if noCache {
+		// free the memory of the previous blob
+		f.m.Lock()
+		f.blob, f.blobIndex = blob, i
+		f.m.Unlock()
+	}
 
 	return blob, nil
 }
 
+// readahead loads the blobs following blob i in the background if the file
+// is read sequentially.
+func (f *file) readahead(i int) {
+	f.m.Lock()
+	sequential := i == f.lastBlob+1
+	f.lastBlob = i
+	f.m.Unlock()
+
+	if !sequential {
+		return
+	}
+
+	end := i + 1 + readaheadBlobs
+	if end > len(f.node.Content) {
+		end = len(f.node.Content)
+	}
+
+	var handles []restic.BlobHandle
+	for _, id := range f.node.Content[i+1 : end] {
+		handles = append(handles, restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	}
+	f.root.blobCache.prefetch(f.root.ctx, f.root.repo, handles)
+}
+
 func (f *file) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	debug.Log("Read(%v, %v, %v), file size %v", f.node.Name, req.Size, req.Offset, f.node.Size)
 	offset := req.Offset
@@ -132,6 +189,8 @@ func (f *file) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 		startContent++
 	}
 
+	f.readahead(startContent)
+
 	dst := resp.Data[0:req.Size]
 	readBytes := 0
 	remainingBytes := req.Size
@@ -158,13 +217,16 @@ func (f *file) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 }
 
 func (f *file) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	for i := range f.blobs {
-		f.blobs[i] = nil
-	}
+	f.m.Lock()
+	f.blob = nil
+	f.m.Unlock()
 	return nil
 }
 
 func (f *file) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if f.node == nil {
+		return nil
+	}
 	debug.Log("Listxattr(%v, %v)", f.node.Name, req.Size)
 	for _, attr := range f.node.ExtendedAttributes {
 		resp.Append(attr.Name)
@@ -173,6 +235,9 @@ func (f *file) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *
 }
 
 func (f *file) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if f.node == nil {
+		return fuse.ErrNoXattr
+	}
 	debug.Log("Getxattr(%v, %v, %v)", f.node.Name, req.Name, req.Size)
 	attrval := f.node.GetExtendedAttribute(req.Name)
 	if attrval != nil {
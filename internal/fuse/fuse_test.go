@@ -118,12 +118,9 @@ func TestFuseFile(t *testing.T) {
 		Content: content,
 	}
 	root := &Root{
-		blobSizeCache: NewBlobSizeCache(context.TODO(), repo.Index()),
-		repo:          repo,
+		repo: repo,
 	}
 
-	t.Logf("blob cache has %d entries", len(root.blobSizeCache.m))
-
 	inode := fs.GenerateDynamicInode(1, "foo")
 	f, err := newFile(context.TODO(), root, inode, node)
 	rtest.OK(t, err)
@@ -150,7 +147,10 @@ func TestFuseFile(t *testing.T) {
 		}
 	}
 
+	// without a blob cache, the last blob is kept until the file is released
+	rtest.Assert(t, f.blob != nil, "last blob was not kept")
 	rtest.OK(t, f.Release(ctx, nil))
+	rtest.Assert(t, f.blob == nil, "last blob was not released")
 }
 
 // Test top-level directories for their UID and GID.
@@ -193,3 +193,177 @@ func testTopUidGid(t *testing.T, cfg Config, repo restic.Repository, uid, gid ui
 	rtest.Equals(t, uid, attr.Uid)
 	rtest.Equals(t, gid, attr.Gid)
 }
+
+func TestWritableOverlay(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 207401672), 1, 0)
+
+	overlayDir, cleanupDir := rtest.TempDir(t)
+	defer cleanupDir()
+
+	ctx := context.Background()
+	root, err := NewRoot(ctx, repo, Config{OverlayDir: overlayDir})
+	rtest.OK(t, err)
+
+	idsdir, err := root.Lookup(ctx, "ids")
+	rtest.OK(t, err)
+	node, err := idsdir.(fs.NodeStringLookuper).Lookup(ctx, loadFirstSnapshot(t, repo).ID().Str())
+	rtest.OK(t, err)
+	snapshotdir := node.(*dir)
+	rtest.OK(t, snapshotdir.loadItems(ctx))
+
+	var name string
+	for n, item := range snapshotdir.items {
+		if item.Type == "file" {
+			name = n
+			break
+		}
+	}
+	rtest.Assert(t, name != "", "snapshot contains no file")
+
+	// create a new file
+	_, handle, err := snapshotdir.Create(ctx, &fuse.CreateRequest{
+		Name:  "new",
+		Flags: fuse.OpenFlags(os.O_RDWR),
+		Mode:  0644,
+	}, &fuse.CreateResponse{})
+	rtest.OK(t, err)
+	rtest.OK(t, handle.(fs.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("foobar")}, &fuse.WriteResponse{}))
+	rtest.OK(t, handle.(fs.HandleReleaser).Release(ctx, nil))
+
+	node, err = snapshotdir.Lookup(ctx, "new")
+	rtest.OK(t, err)
+	var attr fuse.Attr
+	rtest.OK(t, node.Attr(ctx, &attr))
+	rtest.Equals(t, uint64(6), attr.Size)
+
+	// truncate a file of the snapshot
+	node, err = snapshotdir.Lookup(ctx, name)
+	rtest.OK(t, err)
+	handle, err = node.(fs.NodeOpener).Open(ctx, &fuse.OpenRequest{
+		Flags: fuse.OpenFlags(os.O_WRONLY | os.O_TRUNC),
+	}, &fuse.OpenResponse{})
+	rtest.OK(t, err)
+	rtest.OK(t, handle.(fs.HandleReleaser).Release(ctx, nil))
+	rtest.OK(t, node.Attr(ctx, &attr))
+	rtest.Equals(t, uint64(0), attr.Size)
+
+	// remove the file of the snapshot
+	rtest.OK(t, snapshotdir.Remove(ctx, &fuse.RemoveRequest{Name: name}))
+	_, err = snapshotdir.Lookup(ctx, name)
+	rtest.Equals(t, fuse.ENOENT, err)
+
+	entries, err := snapshotdir.ReadDirAll(ctx)
+	rtest.OK(t, err)
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name] = true
+	}
+	rtest.Assert(t, names["new"], "new file is not listed")
+	rtest.Assert(t, !names[name], "removed file %v is still listed", name)
+
+	// the snapshot is unchanged without the overlay
+	root, err = NewRoot(ctx, repo, Config{})
+	rtest.OK(t, err)
+	idsdir, err = root.Lookup(ctx, "ids")
+	rtest.OK(t, err)
+	node, err = idsdir.(fs.NodeStringLookuper).Lookup(ctx, loadFirstSnapshot(t, repo).ID().Str())
+	rtest.OK(t, err)
+	_, err = node.(*dir).Lookup(ctx, name)
+	rtest.OK(t, err)
+	_, err = node.(*dir).Lookup(ctx, "new")
+	rtest.Equals(t, fuse.ENOENT, err)
+}
+
+func TestVersionsDir(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 207401672), 1, 0)
+	sn := loadFirstSnapshot(t, repo)
+
+	ctx := context.Background()
+	root, err := NewRoot(ctx, repo, Config{SnapshotTemplate: time.RFC3339})
+	rtest.OK(t, err)
+
+	tree, err := root.loadTree(ctx, *sn.Tree)
+	rtest.OK(t, err)
+	var node *restic.Node
+	for _, n := range tree.Nodes {
+		if n.Type == "file" {
+			node = n
+			break
+		}
+	}
+	rtest.Assert(t, node != nil, "snapshot contains no file")
+
+	versionsdir, err := root.Lookup(ctx, versionsDirName)
+	rtest.OK(t, err)
+	filedir, err := versionsdir.(fs.NodeStringLookuper).Lookup(ctx, node.Name)
+	rtest.OK(t, err)
+
+	entries, err := filedir.(fs.HandleReadDirAller).ReadDirAll(ctx)
+	rtest.OK(t, err)
+	name := sn.Time.Format(time.RFC3339)
+	rtest.Equals(t, 3, len(entries))
+	rtest.Equals(t, name, entries[2].Name)
+
+	version, err := filedir.(fs.NodeStringLookuper).Lookup(ctx, name)
+	rtest.OK(t, err)
+	var attr fuse.Attr
+	rtest.OK(t, version.Attr(ctx, &attr))
+	rtest.Equals(t, node.Size, attr.Size)
+
+	_, err = filedir.(fs.NodeStringLookuper).Lookup(ctx, "missing")
+	rtest.Equals(t, fuse.ENOENT, err)
+}
+
+func TestRepoDir(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 207401672), 1, 0)
+	sn := loadFirstSnapshot(t, repo)
+
+	ctx := context.Background()
+	root, err := NewRoot(ctx, repo, Config{SnapshotTemplate: time.RFC3339})
+	rtest.OK(t, err)
+
+	_, err = root.Lookup(ctx, repoDirName)
+	rtest.Equals(t, fuse.ENOENT, err)
+
+	root, err = NewRoot(ctx, repo, Config{SnapshotTemplate: time.RFC3339, ExposeInternal: true})
+	rtest.OK(t, err)
+
+	repodir, err := root.Lookup(ctx, repoDirName)
+	rtest.OK(t, err)
+	treesdir, err := repodir.(fs.NodeStringLookuper).Lookup(ctx, "trees")
+	rtest.OK(t, err)
+
+	entries, err := treesdir.(fs.HandleReadDirAller).ReadDirAll(ctx)
+	rtest.OK(t, err)
+	found := false
+	for _, e := range entries {
+		found = found || e.Name == sn.Tree.String()
+	}
+	rtest.Assert(t, found, "tree %v is not listed", sn.Tree.Str())
+
+	node, err := treesdir.(fs.NodeStringLookuper).Lookup(ctx, sn.Tree.String())
+	rtest.OK(t, err)
+
+	want, err := repo.LoadBlob(ctx, restic.TreeBlob, *sn.Tree, nil)
+	rtest.OK(t, err)
+
+	var attr fuse.Attr
+	rtest.OK(t, node.Attr(ctx, &attr))
+	rtest.Equals(t, uint64(len(want)), attr.Size)
+
+	resp := &fuse.ReadResponse{Data: make([]byte, len(want))}
+	rtest.OK(t, node.(fs.HandleReader).Read(ctx, &fuse.ReadRequest{Size: len(want)}, resp))
+	rtest.Equals(t, want, resp.Data)
+
+	_, err = treesdir.(fs.NodeStringLookuper).Lookup(ctx, restic.NewRandomID().String())
+	rtest.Equals(t, fuse.ENOENT, err)
+}
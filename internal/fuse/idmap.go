@@ -0,0 +1,59 @@
+package fuse
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// IDMap maps the user or group IDs of the files in the snapshots to IDs on
+// the local system. IDs without a mapping are kept, unless a default is set.
+type IDMap struct {
+	ids        map[uint32]uint32
+	def        uint32
+	hasDefault bool
+}
+
+// ParseIDMap parses mappings of the form "from:to", where both are numeric
+// IDs. A "from" of "*" maps all IDs which are not mapped otherwise.
+func ParseIDMap(mappings []string) (IDMap, error) {
+	m := IDMap{ids: make(map[uint32]uint32)}
+
+	for _, s := range mappings {
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return IDMap{}, errors.Errorf("invalid ID mapping %q, expected from:to", s)
+		}
+
+		to, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return IDMap{}, errors.Errorf("invalid ID mapping %q: %q is not a numeric ID", s, parts[1])
+		}
+
+		if parts[0] == "*" {
+			m.def = uint32(to)
+			m.hasDefault = true
+			continue
+		}
+
+		from, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return IDMap{}, errors.Errorf("invalid ID mapping %q: %q is not a numeric ID", s, parts[0])
+		}
+		m.ids[uint32(from)] = uint32(to)
+	}
+
+	return m, nil
+}
+
+// Map returns the local ID for id.
+func (m IDMap) Map(id uint32) uint32 {
+	if to, ok := m.ids[id]; ok {
+		return to
+	}
+	if m.hasDefault {
+		return m.def
+	}
+	return id
+}
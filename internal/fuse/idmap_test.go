@@ -0,0 +1,29 @@
+package fuse
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestIDMap(t *testing.T) {
+	m, err := ParseIDMap(nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint32(1000), m.Map(1000))
+
+	m, err = ParseIDMap([]string{"1000:1001", "0:1002"})
+	rtest.OK(t, err)
+	rtest.Equals(t, uint32(1001), m.Map(1000))
+	rtest.Equals(t, uint32(1002), m.Map(0))
+	rtest.Equals(t, uint32(33), m.Map(33))
+
+	m, err = ParseIDMap([]string{"*:1001", "0:0"})
+	rtest.OK(t, err)
+	rtest.Equals(t, uint32(1001), m.Map(1000))
+	rtest.Equals(t, uint32(0), m.Map(0))
+
+	for _, s := range []string{"1000", "1000:", ":1000", "foo:1000", "1000:bar", "1:2:3", "-1:1000"} {
+		_, err = ParseIDMap([]string{s})
+		rtest.Assert(t, err != nil, "mapping %q: expected error", s)
+	}
+}
@@ -0,0 +1,610 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// overlay stores the changes to the snapshots of a writable mount in a
+// directory. New and modified files are kept below the directory at the path
+// within the snapshot, prefixed with the snapshot ID. Files of the snapshot
+// are copied to the directory before they are modified. Removed entries of
+// the snapshots are only recorded in memory, so all changes are lost when the
+// directory is removed.
+type overlay struct {
+	dir string
+
+	m sync.Mutex
+	// removed contains the paths of removed entries of the snapshots
+	removed map[string]struct{}
+	// opaque contains the paths of directories which were created after an
+	// entry of the snapshot was removed, they hide the old entries
+	opaque map[string]struct{}
+	// files contains the file nodes which are known to the kernel, so that
+	// renames are reflected in them
+	files map[string]*file
+}
+
+func newOverlay(dir string) *overlay {
+	return &overlay{
+		dir:     dir,
+		removed: make(map[string]struct{}),
+		opaque:  make(map[string]struct{}),
+		files:   make(map[string]*file),
+	}
+}
+
+// upperPath returns the path of p in the overlay directory.
+func (o *overlay) upperPath(p string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(p))
+}
+
+func (o *overlay) isRemoved(p string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	_, ok := o.removed[p]
+	return ok
+}
+
+func (o *overlay) isOpaque(p string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	_, ok := o.opaque[p]
+	return ok
+}
+
+// remove records that the entry of the snapshot at p was removed.
+func (o *overlay) remove(p string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.removed[p] = struct{}{}
+	delete(o.opaque, p)
+}
+
+// create records that the entry at p was created in the overlay directory.
+// If an entry of the snapshot was removed before, a directory hides its
+// entries.
+func (o *overlay) create(p string, dir bool) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if _, ok := o.removed[p]; ok && dir {
+		o.opaque[p] = struct{}{}
+	}
+	delete(o.removed, p)
+}
+
+// mkdirUpper creates the directory p in the overlay directory, including
+// its parents.
+func (o *overlay) mkdirUpper(p string) error {
+	return os.MkdirAll(o.upperPath(p), 0700)
+}
+
+// lookupFile returns the file node for p, newFile is called to create it if
+// the kernel does not know it yet.
+func (o *overlay) lookupFile(p string, newFile func() (*file, error)) (*file, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if f, ok := o.files[p]; ok {
+		return f, nil
+	}
+
+	f, err := newFile()
+	if err != nil {
+		return nil, err
+	}
+	f.path = p
+	o.files[p] = f
+	return f, nil
+}
+
+// pathOf returns the path of the file node f.
+func (o *overlay) pathOf(f *file) string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return f.path
+}
+
+// rename updates the path of the file node for oldPath, if there is one.
+func (o *overlay) rename(oldPath, newPath string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	delete(o.files, newPath)
+	if f, ok := o.files[oldPath]; ok {
+		f.path = newPath
+		o.files[newPath] = f
+		delete(o.files, oldPath)
+	}
+}
+
+// drop removes the file node for p, so that the next lookup returns a new
+// node.
+func (o *overlay) drop(p string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	delete(o.files, p)
+}
+
+// forget removes the file node f.
+func (o *overlay) forget(f *file) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.files[f.path] == f {
+		delete(o.files, f.path)
+	}
+}
+
+// toErrno returns the errno of the operating system error err, so that it is
+// passed on to the kernel.
+func toErrno(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		return fuse.Errno(errno)
+	}
+	return err
+}
+
+// upperAttr fills a with the attributes of a file or directory in the
+// overlay directory.
+func (r *Root) upperAttr(inode uint64, fi os.FileInfo, a *fuse.Attr) {
+	a.Inode = inode
+	a.Mode = fi.Mode()
+	a.Size = uint64(fi.Size())
+	a.Blocks = (a.Size / blockSize) + 1
+	a.BlockSize = blockSize
+	a.Uid = r.uid
+	a.Gid = r.gid
+	a.Atime = fi.ModTime()
+	a.Ctime = fi.ModTime()
+	a.Mtime = fi.ModTime()
+	a.Nlink = 1
+}
+
+// Statically ensure that *dir and *file implement the interfaces for
+// writable mounts
+var _ = fs.NodeCreater(&dir{})
+var _ = fs.NodeMkdirer(&dir{})
+var _ = fs.NodeRemover(&dir{})
+var _ = fs.NodeRenamer(&dir{})
+var _ = fs.NodeOpener(&file{})
+var _ = fs.NodeSetattrer(&file{})
+var _ = fs.NodeFsyncer(&file{})
+var _ = fs.NodeForgetter(&file{})
+
+// newUpperDir returns a directory which only exists in the overlay directory.
+func newUpperDir(root *Root, inode, parentInode uint64, p string, fi os.FileInfo) *dir {
+	return &dir{
+		root: root,
+		node: &restic.Node{
+			AccessTime: fi.ModTime(),
+			ModTime:    fi.ModTime(),
+			ChangeTime: fi.ModTime(),
+			Mode:       fi.Mode(),
+		},
+		items:       make(map[string]*restic.Node),
+		inode:       inode,
+		parentInode: parentInode,
+		path:        p,
+	}
+}
+
+// lookupOverlay returns the node for name if it was created or removed in the
+// overlay, or nil if the entry of the snapshot is to be used.
+func (d *dir) lookupOverlay(ctx context.Context, name string, inode uint64) (fs.Node, error) {
+	o := d.root.overlay
+	p := path.Join(d.path, name)
+
+	fi, err := os.Lstat(o.upperPath(p))
+	if os.IsNotExist(err) {
+		if o.isRemoved(p) {
+			return nil, fuse.ENOENT
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	if !fi.IsDir() {
+		return o.lookupFile(p, func() (*file, error) {
+			return &file{root: d.root, inode: inode}, nil
+		})
+	}
+
+	// merge the directory with the one from the snapshot
+	node, ok := d.items[name]
+	if ok && node.Type == "dir" && !o.isRemoved(p) && !o.isOpaque(p) {
+		return newDir(ctx, d.root, inode, d.inode, node, p)
+	}
+	return newUpperDir(d.root, inode, d.inode, p, fi), nil
+}
+
+// mergeOverlay applies the changes in the overlay to the entries of the
+// directory.
+func (d *dir) mergeOverlay(types map[string]fuse.DirentType) error {
+	o := d.root.overlay
+	for name := range types {
+		if o.isRemoved(path.Join(d.path, name)) {
+			delete(types, name)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(o.upperPath(d.path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return toErrno(err)
+	}
+
+	for _, fi := range entries {
+		typ := fuse.DT_File
+		if fi.IsDir() {
+			typ = fuse.DT_Dir
+		}
+		types[fi.Name()] = typ
+	}
+	return nil
+}
+
+// Create creates a new file in the overlay directory.
+func (d *dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	debug.Log("Create(%v, %v)", d.path, req.Name)
+	o := d.root.overlay
+	if o == nil {
+		return nil, nil, fuse.Errno(syscall.EROFS)
+	}
+
+	err := o.mkdirUpper(d.path)
+	if err != nil {
+		return nil, nil, toErrno(err)
+	}
+
+	p := path.Join(d.path, req.Name)
+	flags := int(req.Flags) &^ os.O_APPEND
+	f, err := os.OpenFile(o.upperPath(p), flags|os.O_CREATE, req.Mode.Perm())
+	if err != nil {
+		return nil, nil, toErrno(err)
+	}
+	o.create(p, false)
+	o.drop(p)
+
+	node, err := o.lookupFile(p, func() (*file, error) {
+		return &file{root: d.root, inode: fs.GenerateDynamicInode(d.inode, req.Name)}, nil
+	})
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	return node, &upperHandle{f: f}, nil
+}
+
+// Mkdir creates a new directory in the overlay directory.
+func (d *dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	debug.Log("Mkdir(%v, %v)", d.path, req.Name)
+	o := d.root.overlay
+	if o == nil {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+
+	err := o.mkdirUpper(d.path)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+
+	p := path.Join(d.path, req.Name)
+	err = os.Mkdir(o.upperPath(p), req.Mode.Perm()|0700)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	o.create(p, true)
+
+	return d.Lookup(ctx, req.Name)
+}
+
+// Remove removes a file or an empty directory. Entries of the snapshot are
+// only hidden.
+func (d *dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	debug.Log("Remove(%v, %v)", d.path, req.Name)
+	o := d.root.overlay
+	if o == nil {
+		return fuse.Errno(syscall.EROFS)
+	}
+
+	if err := d.loadItems(ctx); err != nil {
+		return err
+	}
+
+	if req.Dir {
+		node, err := d.Lookup(ctx, req.Name)
+		if err != nil {
+			return err
+		}
+		sub, ok := node.(*dir)
+		if !ok {
+			return fuse.Errno(syscall.ENOTDIR)
+		}
+		entries, err := sub.ReadDirAll(ctx)
+		if err != nil {
+			return err
+		}
+		// the entries always contain "." and ".."
+		if len(entries) > 2 {
+			return fuse.Errno(syscall.ENOTEMPTY)
+		}
+	}
+
+	p := path.Join(d.path, req.Name)
+	err := os.RemoveAll(o.upperPath(p))
+	if err != nil {
+		return toErrno(err)
+	}
+	o.drop(p)
+
+	if _, ok := d.items[req.Name]; ok {
+		o.remove(p)
+	}
+	return nil
+}
+
+// Rename moves a file, which is copied to the overlay directory first.
+// Directories cannot be renamed, most programs then copy them instead.
+func (d *dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	debug.Log("Rename(%v, %v, %v)", d.path, req.OldName, req.NewName)
+	o := d.root.overlay
+	target, ok := newDir.(*dir)
+	if o == nil || !ok {
+		return fuse.Errno(syscall.EROFS)
+	}
+
+	node, err := d.Lookup(ctx, req.OldName)
+	if err != nil {
+		return err
+	}
+	f, ok := node.(*file)
+	if !ok {
+		return fuse.Errno(syscall.EXDEV)
+	}
+
+	if existing, err := target.Lookup(ctx, req.NewName); err == nil {
+		if _, ok := existing.(*dir); ok {
+			return fuse.Errno(syscall.EISDIR)
+		}
+	}
+
+	err = f.copyUp(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	err = o.mkdirUpper(target.path)
+	if err != nil {
+		return toErrno(err)
+	}
+
+	oldPath := path.Join(d.path, req.OldName)
+	newPath := path.Join(target.path, req.NewName)
+	err = os.Rename(o.upperPath(oldPath), o.upperPath(newPath))
+	if err != nil {
+		return toErrno(err)
+	}
+	o.create(newPath, false)
+	o.rename(oldPath, newPath)
+
+	if _, ok := d.items[req.OldName]; ok {
+		o.remove(oldPath)
+	}
+	return nil
+}
+
+// upper returns the path of the file in the overlay directory, if it has
+// been created or copied there.
+func (f *file) upper() (string, bool) {
+	if f.root.overlay == nil {
+		return "", false
+	}
+
+	p := f.root.overlay.pathOf(f)
+	if p == "" {
+		return "", false
+	}
+
+	p = f.root.overlay.upperPath(p)
+	if _, err := os.Lstat(p); err != nil {
+		return "", false
+	}
+	return p, true
+}
+
+// copyUp copies the file to the overlay directory, unless it is already
+// there. If empty is true, only an empty file is created.
+func (f *file) copyUp(ctx context.Context, empty bool) error {
+	if _, ok := f.upper(); ok {
+		return nil
+	}
+	if f.node == nil {
+		return fuse.ENOENT
+	}
+
+	o := f.root.overlay
+	p := o.pathOf(f)
+	debug.Log("copy %v to the overlay directory", p)
+
+	err := o.mkdirUpper(path.Dir(p))
+	if err != nil {
+		return toErrno(err)
+	}
+
+	tmp, err := ioutil.TempFile(o.dir, "copy-")
+	if err != nil {
+		return toErrno(err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if !empty {
+		for _, id := range f.node.Content {
+			blob, err := f.root.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tmp.Write(blob); err != nil {
+				return toErrno(err)
+			}
+		}
+	}
+
+	if err := tmp.Chmod(f.node.Mode.Perm()); err != nil {
+		return toErrno(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return toErrno(err)
+	}
+	if err := os.Chtimes(tmp.Name(), f.node.AccessTime, f.node.ModTime); err != nil {
+		return toErrno(err)
+	}
+
+	return toErrno(os.Rename(tmp.Name(), o.upperPath(p)))
+}
+
+// Open opens the file. If it is opened for writing, it is copied to the
+// overlay directory first.
+func (f *file) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	upper, ok := f.upper()
+	if req.Flags.IsReadOnly() && !ok {
+		if f.node == nil {
+			return nil, fuse.ENOENT
+		}
+		return f, nil
+	}
+
+	if f.root.overlay == nil || f.root.overlay.pathOf(f) == "" {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+
+	if !ok {
+		err := f.copyUp(ctx, req.Flags&fuse.OpenTruncate != 0)
+		if err != nil {
+			return nil, err
+		}
+		upper, _ = f.upper()
+	}
+
+	flags := int(req.Flags) &^ (os.O_CREATE | os.O_EXCL | os.O_APPEND)
+	fd, err := os.OpenFile(upper, flags, 0)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	return &upperHandle{f: fd}, nil
+}
+
+// Setattr changes the size, mode or times of the file, which is copied to
+// the overlay directory first.
+func (f *file) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if f.root.overlay == nil || f.root.overlay.pathOf(f) == "" {
+		return fuse.Errno(syscall.EROFS)
+	}
+
+	err := f.copyUp(ctx, req.Valid.Size() && req.Size == 0)
+	if err != nil {
+		return err
+	}
+	upper, _ := f.upper()
+
+	if req.Valid.Size() {
+		if err := os.Truncate(upper, int64(req.Size)); err != nil {
+			return toErrno(err)
+		}
+	}
+	if req.Valid.Mode() {
+		if err := os.Chmod(upper, req.Mode.Perm()); err != nil {
+			return toErrno(err)
+		}
+	}
+	if req.Valid.Mtime() || req.Valid.Atime() {
+		fi, err := os.Lstat(upper)
+		if err != nil {
+			return toErrno(err)
+		}
+		atime, mtime := fi.ModTime(), fi.ModTime()
+		if req.Valid.Atime() {
+			atime = req.Atime
+		}
+		if req.Valid.Mtime() {
+			mtime = req.Mtime
+		}
+		if err := os.Chtimes(upper, atime, mtime); err != nil {
+			return toErrno(err)
+		}
+	}
+
+	return f.Attr(ctx, &resp.Attr)
+}
+
+// Fsync does nothing, the changes are discarded anyway.
+func (f *file) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return nil
+}
+
+// Forget is called when the kernel no longer knows the file.
+func (f *file) Forget() {
+	if f.root.overlay != nil {
+		f.root.overlay.forget(f)
+	}
+}
+
+// upperHandle is an open file in the overlay directory.
+type upperHandle struct {
+	f *os.File
+}
+
+// Statically ensure that *upperHandle implements the given interfaces
+var _ = fs.HandleReader(&upperHandle{})
+var _ = fs.HandleWriter(&upperHandle{})
+var _ = fs.HandleReleaser(&upperHandle{})
+
+func (h *upperHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := resp.Data[:req.Size]
+	n, err := h.f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return toErrno(err)
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *upperHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	n, err := h.f.WriteAt(req.Data, req.Offset)
+	resp.Size = n
+	return toErrno(err)
+}
+
+func (h *upperHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return toErrno(h.f.Close())
+}
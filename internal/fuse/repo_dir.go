@@ -0,0 +1,215 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// repoDirName is the name of the directory in the root of the mount which
+// contains the decrypted objects of the repository, if Config.ExposeInternal
+// is set.
+const repoDirName = ".repo"
+
+// repoDirs are the directories in repoDirName and the type of the blobs they
+// contain.
+var repoDirs = map[string]restic.BlobType{
+	"trees": restic.TreeBlob,
+	"data":  restic.DataBlob,
+}
+
+// repoDir is the directory ".repo", which contains a directory for each type
+// of blob.
+type repoDir struct {
+	root        *Root
+	inode       uint64
+	parentInode uint64
+}
+
+// blobsDir contains a file for each blob of type tpe in the index, named by
+// the ID of the blob.
+type blobsDir struct {
+	root        *Root
+	inode       uint64
+	parentInode uint64
+	tpe         restic.BlobType
+}
+
+// rawBlob is a file with the decrypted contents of a blob. For tree blobs,
+// this is the JSON document of the tree.
+type rawBlob struct {
+	root  *Root
+	inode uint64
+	h     restic.BlobHandle
+	size  uint
+}
+
+// ensure that the nodes implement these interfaces
+var _ = fs.HandleReadDirAller(&repoDir{})
+var _ = fs.NodeStringLookuper(&repoDir{})
+var _ = fs.HandleReadDirAller(&blobsDir{})
+var _ = fs.NodeStringLookuper(&blobsDir{})
+var _ = fs.NodeOpener(&rawBlob{})
+var _ = fs.HandleReader(&rawBlob{})
+
+func newRepoDir(root *Root, inode, parentInode uint64) *repoDir {
+	debug.Log("new repo dir, inode %d", inode)
+	return &repoDir{
+		root:        root,
+		inode:       inode,
+		parentInode: parentInode,
+	}
+}
+
+// Attr returns the attributes for the directory.
+func (d *repoDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.inode
+	a.Mode = os.ModeDir | 0500
+	a.Uid = d.root.uid
+	a.Gid = d.root.gid
+	return nil
+}
+
+// ReadDirAll returns the directories for the blob types.
+func (d *repoDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	items := []fuse.Dirent{
+		{Inode: d.inode, Name: ".", Type: fuse.DT_Dir},
+		{Inode: d.parentInode, Name: "..", Type: fuse.DT_Dir},
+	}
+	for name := range repoDirs {
+		items = append(items, fuse.Dirent{
+			Inode: fs.GenerateDynamicInode(d.inode, name),
+			Name:  name,
+			Type:  fuse.DT_Dir,
+		})
+	}
+	return items, nil
+}
+
+// Lookup returns the directory for a blob type.
+func (d *repoDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	tpe, ok := repoDirs[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	return &blobsDir{
+		root:        d.root,
+		inode:       fs.GenerateDynamicInode(d.inode, name),
+		parentInode: d.inode,
+		tpe:         tpe,
+	}, nil
+}
+
+// Attr returns the attributes for the directory.
+func (d *blobsDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.inode
+	a.Mode = os.ModeDir | 0500
+	a.Uid = d.root.uid
+	a.Gid = d.root.gid
+	return nil
+}
+
+// ReadDirAll lists the blobs in the index.
+func (d *blobsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	debug.Log("ReadDirAll(%v)", d.tpe)
+
+	items := []fuse.Dirent{
+		{Inode: d.inode, Name: ".", Type: fuse.DT_Dir},
+		{Inode: d.parentInode, Name: "..", Type: fuse.DT_Dir},
+	}
+
+	// a blob may be listed in several indexes
+	seen := restic.NewIDSet()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for pb := range d.root.repo.Index().Each(ctx) {
+		if pb.Type != d.tpe || seen.Has(pb.ID) {
+			continue
+		}
+		seen.Insert(pb.ID)
+
+		name := pb.ID.String()
+		items = append(items, fuse.Dirent{
+			Inode: fs.GenerateDynamicInode(d.inode, name),
+			Name:  name,
+			Type:  fuse.DT_File,
+		})
+	}
+
+	return items, ctx.Err()
+}
+
+// Lookup returns the blob with the ID name.
+func (d *blobsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	id, err := restic.ParseID(name)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+
+	size, found := d.root.repo.LookupBlobSize(id, d.tpe)
+	if !found {
+		return nil, fuse.ENOENT
+	}
+
+	return &rawBlob{
+		root:  d.root,
+		inode: fs.GenerateDynamicInode(d.inode, name),
+		h:     restic.BlobHandle{ID: id, Type: d.tpe},
+		size:  size,
+	}, nil
+}
+
+// Attr returns the attributes for the file.
+func (f *rawBlob) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = f.inode
+	a.Mode = 0400
+	a.Size = uint64(f.size)
+	a.Blocks = (uint64(f.size) / blockSize) + 1
+	a.BlockSize = blockSize
+	a.Nlink = 1
+	a.Uid = f.root.uid
+	a.Gid = f.root.gid
+	return nil
+}
+
+// Open refuses to open the blob for writing.
+func (f *rawBlob) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+	return f, nil
+}
+
+// Read returns the decrypted contents of the blob. The blob is loaded and
+// verified as a whole, a damaged blob cannot be read at all.
+func (f *rawBlob) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	debug.Log("Read(%v, %v, %v)", f.h, req.Size, req.Offset)
+
+	buf, err := f.root.blobCache.load(ctx, f.root.repo, f.h)
+	if err != nil {
+		debug.Log("LoadBlob(%v) failed: %v", f.h, err)
+		return err
+	}
+
+	if req.Offset >= int64(len(buf)) {
+		resp.Data = resp.Data[:0]
+		return nil
+	}
+
+	n := copy(resp.Data[:req.Size], buf[req.Offset:])
+	resp.Data = resp.Data[:n]
+	return nil
+}
@@ -7,7 +7,6 @@ package fuse
 
 import (
 	"os"
-	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/restic"
@@ -24,20 +23,41 @@ type Config struct {
 	Tags             []restic.TagList
 	Paths            []string
 	SnapshotTemplate string
+
+	// PathTemplates are the templates for the directories which contain the
+	// snapshots, DefaultPathTemplates are used if it is empty.
+	PathTemplates []string
+
+	// BlobCacheSize is the size of the blobs in bytes which are cached in
+	// memory, the cache is disabled if it is zero.
+	BlobCacheSize int
+
+	// UIDMap and GIDMap map the owners of the files in the snapshots to users
+	// and groups on the local system.
+	UIDMap IDMap
+	GIDMap IDMap
+
+	// OverlayDir makes the snapshots writable if it is set. All changes are
+	// stored in this directory and are lost when it is removed.
+	OverlayDir string
+
+	// ExposeInternal adds the directory ".repo", which contains the decrypted
+	// tree and data blobs by ID.
+	ExposeInternal bool
 }
 
 // Root is the root node of the fuse mount of a repository.
 type Root struct {
-	repo          restic.Repository
-	cfg           Config
-	inode         uint64
-	snapshots     restic.Snapshots
-	blobSizeCache *BlobSizeCache
+	// ctx is used for work in the background, like readahead, it is
+	// cancelled when the mount is removed.
+	ctx context.Context
 
-	snCount   int
-	lastCheck time.Time
+	repo      restic.Repository
+	cfg       Config
+	blobCache *blobCache
+	overlay   *overlay
 
-	*MetaDir
+	*SnapshotsDir
 
 	uid, gid uint32
 }
@@ -53,10 +73,17 @@ func NewRoot(ctx context.Context, repo restic.Repository, cfg Config) (*Root, er
 	debug.Log("NewRoot(), config %v", cfg)
 
 	root := &Root{
-		repo:          repo,
-		inode:         rootInode,
-		cfg:           cfg,
-		blobSizeCache: NewBlobSizeCache(ctx, repo.Index()),
+		ctx:  ctx,
+		repo: repo,
+		cfg:  cfg,
+	}
+
+	if cfg.BlobCacheSize > 0 {
+		root.blobCache = newBlobCache(cfg.BlobCacheSize)
+	}
+
+	if cfg.OverlayDir != "" {
+		root.overlay = newOverlay(cfg.OverlayDir)
 	}
 
 	if !cfg.OwnerIsRoot {
@@ -64,18 +91,25 @@ func NewRoot(ctx context.Context, repo restic.Repository, cfg Config) (*Root, er
 		root.gid = uint32(os.Getgid())
 	}
 
-	entries := map[string]fs.Node{
-		"snapshots": NewSnapshotsDir(root, fs.GenerateDynamicInode(root.inode, "snapshots"), "", ""),
-		"tags":      NewTagsDir(root, fs.GenerateDynamicInode(root.inode, "tags")),
-		"hosts":     NewHostsDir(root, fs.GenerateDynamicInode(root.inode, "hosts")),
-		"ids":       NewSnapshotsIDSDir(root, fs.GenerateDynamicInode(root.inode, "ids")),
+	pathTemplates := cfg.PathTemplates
+	if len(pathTemplates) == 0 {
+		pathTemplates = DefaultPathTemplates
 	}
 
-	root.MetaDir = NewMetaDir(root, rootInode, entries)
+	dirStruct := NewSnapshotsDirStructure(repo, cfg.Hosts, cfg.Tags, cfg.Paths, pathTemplates, cfg.SnapshotTemplate)
+	root.SnapshotsDir = NewSnapshotsDir(root, rootInode, rootInode, dirStruct, "")
 
 	return root, nil
 }
 
+// owner returns the user and group which own node in the mount.
+func (r *Root) owner(node *restic.Node) (uid, gid uint32) {
+	if r.cfg.OwnerIsRoot {
+		return 0, 0
+	}
+	return r.cfg.UIDMap.Map(node.UID), r.cfg.GIDMap.Map(node.GID)
+}
+
 // Root is just there to satisfy fs.Root, it returns itself.
 func (r *Root) Root() (fs.Node, error) {
 	debug.Log("Root()")
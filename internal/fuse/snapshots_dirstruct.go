@@ -0,0 +1,415 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultPathTemplates are the templates for the directories which contain
+// snapshots, see CheckPathTemplate.
+var DefaultPathTemplates = []string{
+	"ids/%i",
+	"snapshots/%T",
+	"hosts/%h/%T",
+	"tags/%t/%T",
+	"paths/%p/%T",
+}
+
+// CheckPathTemplate returns an error if the path template is invalid. A path
+// template consists of components separated by slashes, which may contain
+// the placeholders %i (short snapshot ID), %I (long snapshot ID), %T (time
+// formatted with the time template), %h (hostname), %u (username), %t (tag,
+// the snapshot is listed once for each tag) and %% (a percent sign). The
+// placeholder %p must be a component on its own and is replaced by the
+// directories of each path of the snapshot. The last component must contain
+// %i, %I or %T so that snapshots can be told apart.
+func CheckPathTemplate(template string) error {
+	components := strings.Split(template, "/")
+	for i, c := range components {
+		if c == "" || c == "." || c == ".." {
+			return errors.Errorf("invalid path template %q: empty component or . or ..", template)
+		}
+
+		for j := 0; j < len(c); j++ {
+			if c[j] != '%' {
+				continue
+			}
+			j++
+			if j == len(c) || !strings.ContainsRune("iIThutp%", rune(c[j])) {
+				return errors.Errorf("invalid path template %q: unknown placeholder in %q", template, c)
+			}
+			if c[j] == 'p' && c != "%p" {
+				return errors.Errorf("invalid path template %q: %%p must be a component on its own", template)
+			}
+		}
+
+		if i == len(components)-1 {
+			if !strings.Contains(c, "%i") && !strings.Contains(c, "%I") && !strings.Contains(c, "%T") {
+				return errors.Errorf("invalid path template %q: the last component must contain %%i, %%I or %%T", template)
+			}
+		}
+	}
+	return nil
+}
+
+// MetaDirData is an entry in the directories which contain snapshots. It is
+// either a directory with more entries, a snapshot, or a symlink to a
+// snapshot in the same directory.
+type MetaDirData struct {
+	// LinkTarget is set for symlinks, Snapshot for snapshots and symlinks.
+	LinkTarget string
+	Snapshot   *restic.Snapshot
+
+	// Names contains the entries of a directory.
+	Names map[string]*MetaDirData
+}
+
+// IsDir returns true if the entry is a directory.
+func (m *MetaDirData) IsDir() bool {
+	return m.Snapshot == nil
+}
+
+// SnapshotsDirStructure builds the directories which contain the snapshots
+// of a repository from the path templates. The list of snapshots is checked
+// at most once a minute, and only new snapshots are loaded.
+type SnapshotsDirStructure struct {
+	repo          restic.Repository
+	hosts         []string
+	tags          []restic.TagList
+	paths         []string
+	pathTemplates []string
+	timeTemplate  string
+
+	mutex     sync.Mutex
+	loaded    map[restic.ID]*restic.Snapshot
+	snapshots restic.Snapshots
+	entries   map[string]*MetaDirData
+	hash      [sha256.Size]byte
+	lastCheck time.Time
+}
+
+// NewSnapshotsDirStructure returns a directory structure for the snapshots
+// which match hosts, tags and paths.
+func NewSnapshotsDirStructure(repo restic.Repository, hosts []string, tags []restic.TagList, paths []string, pathTemplates []string, timeTemplate string) *SnapshotsDirStructure {
+	return &SnapshotsDirStructure{
+		repo:          repo,
+		hosts:         hosts,
+		tags:          tags,
+		paths:         paths,
+		pathTemplates: pathTemplates,
+		timeTemplate:  timeTemplate,
+	}
+}
+
+// expandComponent returns the names for the template component c for the
+// snapshot, or nil if the snapshot is not listed for this component.
+func expandComponent(c string, sn *restic.Snapshot, timeTemplate string) []string {
+	names := []string{""}
+	for i := 0; i < len(c); i++ {
+		if c[i] != '%' || i == len(c)-1 {
+			for j := range names {
+				names[j] += string(c[i])
+			}
+			continue
+		}
+
+		i++
+		var values []string
+		switch c[i] {
+		case 'i':
+			values = []string{sn.ID().Str()}
+		case 'I':
+			values = []string{sn.ID().String()}
+		case 'T':
+			values = []string{sn.Time.Format(timeTemplate)}
+		case 'h':
+			values = []string{sn.Hostname}
+		case 'u':
+			values = []string{sn.Username}
+		case 't':
+			values = sn.Tags
+		default:
+			values = []string{string(c[i])}
+		}
+
+		var expanded []string
+		for _, name := range names {
+			for _, v := range values {
+				// a name must not create additional directories
+				v = strings.Replace(v, "/", "_", -1)
+				if v == "" {
+					continue
+				}
+				expanded = append(expanded, name+v)
+			}
+		}
+		names = expanded
+	}
+	return names
+}
+
+// expandTemplate returns the list of components of all paths at which the
+// snapshot is listed for the template.
+func expandTemplate(template string, sn *restic.Snapshot, timeTemplate string) [][]string {
+	result := [][]string{nil}
+	for _, c := range strings.Split(template, "/") {
+		var values [][]string
+		if c == "%p" {
+			for _, p := range sn.Paths {
+				var dirs []string
+				for _, d := range strings.Split(strings.Replace(p, "\\", "/", -1), "/") {
+					// drive letters like "C:" become "C"
+					d = strings.TrimSuffix(d, ":")
+					if d != "" && d != "." && d != ".." {
+						dirs = append(dirs, d)
+					}
+				}
+				if len(dirs) > 0 {
+					values = append(values, dirs)
+				}
+			}
+		} else {
+			for _, name := range expandComponent(c, sn, timeTemplate) {
+				values = append(values, []string{name})
+			}
+		}
+
+		var expanded [][]string
+		for _, r := range result {
+			for _, v := range values {
+				item := make([]string, 0, len(r)+len(v))
+				item = append(item, r...)
+				expanded = append(expanded, append(item, v...))
+			}
+		}
+		result = expanded
+	}
+	return result
+}
+
+// add inserts the snapshot below the directory m at the path given by
+// components. Existing names of other snapshots get a numeric suffix.
+func (m *MetaDirData) add(components []string, sn *restic.Snapshot) {
+	dir := m
+	for _, c := range components[:len(components)-1] {
+		next, ok := dir.Names[c]
+		if !ok {
+			next = &MetaDirData{Names: make(map[string]*MetaDirData)}
+			dir.Names[c] = next
+		}
+		if !next.IsDir() {
+			debug.Log("%v is a snapshot, not adding %v below it", c, sn.ID().Str())
+			return
+		}
+		dir = next
+	}
+
+	base := components[len(components)-1]
+	name := base
+	for i := 1; ; i++ {
+		entry, ok := dir.Names[name]
+		if !ok {
+			break
+		}
+		if entry.Snapshot == sn {
+			return
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	dir.Names[name] = &MetaDirData{Snapshot: sn}
+}
+
+// addLatestLinks adds a symlink "latest" to the newest snapshot in all
+// directories below m which contain snapshots, and records the directories
+// in entries.
+func (m *MetaDirData) addLatestLinks(prefix string, entries map[string]*MetaDirData) {
+	entries[prefix] = m
+
+	var latest string
+	var latestTime time.Time
+	for name, entry := range m.Names {
+		if entry.IsDir() {
+			entry.addLatestLinks(path.Join(prefix, name), entries)
+			continue
+		}
+		if latest == "" || entry.Snapshot.Time.After(latestTime) ||
+			(entry.Snapshot.Time.Equal(latestTime) && name > latest) {
+			latest = name
+			latestTime = entry.Snapshot.Time
+		}
+	}
+
+	if _, ok := m.Names["latest"]; latest != "" && !ok {
+		m.Names["latest"] = &MetaDirData{LinkTarget: latest, Snapshot: m.Names[latest].Snapshot}
+	}
+}
+
+// makeDirs builds the directories for the snapshots.
+func (d *SnapshotsDirStructure) makeDirs(snapshots restic.Snapshots) {
+	// older snapshots get the names without suffix
+	sort.Sort(snapshots)
+
+	root := &MetaDirData{Names: make(map[string]*MetaDirData)}
+	for _, sn := range snapshots {
+		for _, template := range d.pathTemplates {
+			for _, components := range expandTemplate(template, sn, d.timeTemplate) {
+				root.add(components, sn)
+			}
+		}
+	}
+
+	d.entries = make(map[string]*MetaDirData)
+	root.addLatestLinks("", d.entries)
+}
+
+const minSnapshotsReloadTime = 60 * time.Second
+
+// updateSnapshots reloads the snapshots if the last check is older than
+// minSnapshotsReloadTime, and rebuilds the directories if they changed.
+func (d *SnapshotsDirStructure) updateSnapshots(ctx context.Context) error {
+	if d.entries != nil && time.Since(d.lastCheck) < minSnapshotsReloadTime {
+		return nil
+	}
+
+	var ids restic.IDs
+	err := d.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Sort(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.Write(id[:])
+	}
+	hash := sha256.Sum256(buf.Bytes())
+
+	if d.entries == nil || hash != d.hash {
+		if d.entries != nil {
+			// new snapshots may reference blobs which are not in the index yet
+			if err := d.repo.LoadIndex(ctx); err != nil {
+				return err
+			}
+		}
+
+		if err := d.loadSnapshots(ctx, ids); err != nil {
+			return err
+		}
+
+		snapshots := make(restic.Snapshots, 0, len(d.loaded))
+		for _, sn := range d.loaded {
+			if sn.HasHostname(d.hosts) && sn.HasTagList(d.tags) && sn.HasPaths(d.paths) {
+				snapshots = append(snapshots, sn)
+			}
+		}
+
+		d.makeDirs(snapshots)
+		d.snapshots = snapshots
+		d.hash = hash
+	}
+	d.lastCheck = time.Now()
+
+	return nil
+}
+
+// loadSnapshotsWorkers is the number of snapshots which are loaded
+// concurrently.
+const loadSnapshotsWorkers = 8
+
+// loadSnapshots updates the loaded snapshots to the ones in ids. Snapshots
+// which were loaded before are kept, so that only new snapshots are loaded
+// from the repository.
+func (d *SnapshotsDirStructure) loadSnapshots(ctx context.Context, ids restic.IDs) error {
+	loaded := make(map[restic.ID]*restic.Snapshot, len(ids))
+	var missing restic.IDs
+	for _, id := range ids {
+		if sn, ok := d.loaded[id]; ok {
+			loaded[id] = sn
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	debug.Log("loading %d new of %d snapshots", len(missing), len(ids))
+
+	var m sync.Mutex
+	wg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan restic.ID)
+
+	wg.Go(func() error {
+		defer close(ch)
+		for _, id := range missing {
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < loadSnapshotsWorkers; i++ {
+		wg.Go(func() error {
+			for id := range ch {
+				sn, err := restic.LoadSnapshot(ctx, d.repo, id)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "could not load snapshot %v: %v\n", id.Str(), err)
+					continue
+				}
+
+				m.Lock()
+				loaded[id] = sn
+				m.Unlock()
+			}
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+
+	d.loaded = loaded
+	return nil
+}
+
+// UpdatePrefix returns the directory at prefix, for example "hosts/foo", or
+// nil if it does not exist. The empty prefix is the top-level directory.
+func (d *SnapshotsDirStructure) UpdatePrefix(ctx context.Context, prefix string) (*MetaDirData, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.updateSnapshots(ctx); err != nil {
+		return nil, err
+	}
+
+	return d.entries[prefix], nil
+}
+
+// Snapshots returns the snapshots, sorted by time with the oldest first.
+func (d *SnapshotsDirStructure) Snapshots(ctx context.Context) (restic.Snapshots, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.updateSnapshots(ctx); err != nil {
+		return nil, err
+	}
+
+	return d.snapshots, nil
+}
@@ -0,0 +1,81 @@
+package fuse
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCheckPathTemplate(t *testing.T) {
+	for _, template := range append(DefaultPathTemplates, "%h/%u/%I", "by-id/%i-%%") {
+		if err := CheckPathTemplate(template); err != nil {
+			t.Errorf("template %q: unexpected error %v", template, err)
+		}
+	}
+
+	for _, template := range []string{"", "hosts/%h", "hosts//%T", "../%T", "%x/%T", "paths/x%p/%T", "%T/%"} {
+		if err := CheckPathTemplate(template); err == nil {
+			t.Errorf("template %q: expected an error", template)
+		}
+	}
+}
+
+func TestSnapshotsDirStructure(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	const timeTemplate = "2006-01-02T15:04:05"
+	first := restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 0), 0, 0)
+	second := restic.TestCreateSnapshot(t, repo, time.Unix(1460289941, 0), 0, 0)
+
+	ctx := context.Background()
+	d := NewSnapshotsDirStructure(repo, nil, nil, nil, DefaultPathTemplates, timeTemplate)
+
+	root, err := d.UpdatePrefix(ctx, "")
+	rtest.OK(t, err)
+	for _, name := range []string{"ids", "snapshots", "hosts", "tags", "paths"} {
+		entry, ok := root.Names[name]
+		rtest.Assert(t, ok && entry.IsDir(), "directory %v is missing", name)
+	}
+
+	for _, prefix := range []string{"snapshots", "hosts/foo", "tags/test", path.Join("paths", strings.Trim(first.Paths[0], "/"))} {
+		dir, err := d.UpdatePrefix(ctx, prefix)
+		rtest.OK(t, err)
+		rtest.Assert(t, dir != nil, "directory %v is missing", prefix)
+		rtest.Equals(t, 3, len(dir.Names))
+		rtest.Equals(t, *first.ID(), *dir.Names[first.Time.Format(timeTemplate)].Snapshot.ID())
+		rtest.Equals(t, second.Time.Format(timeTemplate), dir.Names["latest"].LinkTarget)
+	}
+
+	ids, err := d.UpdatePrefix(ctx, "ids")
+	rtest.OK(t, err)
+	rtest.Equals(t, *second.ID(), *ids.Names[second.ID().Str()].Snapshot.ID())
+
+	missing, err := d.UpdatePrefix(ctx, "hosts/bar")
+	rtest.OK(t, err)
+	rtest.Assert(t, missing == nil, "unexpected directory hosts/bar")
+}
+
+func TestSnapshotsDirStructureDuplicates(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	at := time.Unix(1460289341, 0)
+	restic.TestCreateSnapshot(t, repo, at, 0, 0)
+	restic.TestCreateSnapshot(t, repo, at, 0, 0)
+
+	d := NewSnapshotsDirStructure(repo, nil, nil, nil, []string{"%h/%T"}, "2006")
+	dir, err := d.UpdatePrefix(context.Background(), "foo")
+	rtest.OK(t, err)
+
+	_, ok := dir.Names["2016"]
+	rtest.Assert(t, ok, "first snapshot is missing")
+	_, ok = dir.Names["2016-1"]
+	rtest.Assert(t, ok, "second snapshot is missing")
+}
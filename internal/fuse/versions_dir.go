@@ -0,0 +1,282 @@
+// +build !netbsd
+// +build !openbsd
+// +build !solaris
+// +build !windows
+
+package fuse
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// versionsDirName is the name of the directory in the root of the mount which
+// contains the versions of all files.
+const versionsDirName = ".versions"
+
+// versionsDir is a directory below ".versions" which contains the entries at
+// path in all snapshots. Directories in any of the snapshots are again
+// versionsDirs, files are fileVersionsDirs.
+type versionsDir struct {
+	root        *Root
+	inode       uint64
+	parentInode uint64
+	path        []string
+}
+
+// fileVersionsDir contains the distinct versions of the file at path, named
+// by the time of the oldest snapshot which contains the version.
+type fileVersionsDir struct {
+	root        *Root
+	inode       uint64
+	parentInode uint64
+	path        []string
+}
+
+// ensure that *versionsDir and *fileVersionsDir implement these interfaces
+var _ = fs.HandleReadDirAller(&versionsDir{})
+var _ = fs.NodeStringLookuper(&versionsDir{})
+var _ = fs.HandleReadDirAller(&fileVersionsDir{})
+var _ = fs.NodeStringLookuper(&fileVersionsDir{})
+
+// treeAt returns the tree of the directory at path in the snapshot, or nil if
+// there is no such directory.
+func (r *Root) treeAt(ctx context.Context, sn *restic.Snapshot, path []string) (*restic.Tree, error) {
+	tree, err := r.loadTree(ctx, *sn.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range path {
+		node := findNode(tree, name)
+		if node == nil || node.Type != "dir" || node.Subtree == nil {
+			return nil, nil
+		}
+
+		tree, err = r.loadTree(ctx, *node.Subtree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// findNode returns the node with the name in tree, or nil.
+func findNode(tree *restic.Tree, name string) *restic.Node {
+	for _, node := range tree.Nodes {
+		if cleanupNodeName(node.Name) == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func newVersionsDir(root *Root, inode, parentInode uint64, path []string) *versionsDir {
+	debug.Log("new versions dir for %v", path)
+	return &versionsDir{
+		root:        root,
+		inode:       inode,
+		parentInode: parentInode,
+		path:        path,
+	}
+}
+
+// Attr returns the attributes for the directory.
+func (d *versionsDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.inode
+	a.Mode = os.ModeDir | 0555
+	a.Uid = d.root.uid
+	a.Gid = d.root.gid
+	return nil
+}
+
+// entries returns the names of the entries in all snapshots, and whether
+// they are a directory in any of them.
+func (d *versionsDir) entries(ctx context.Context) (map[string]bool, error) {
+	snapshots, err := d.root.SnapshotsDir.dirStruct.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]bool)
+	for _, sn := range snapshots {
+		tree, err := d.root.treeAt(ctx, sn, d.path)
+		if err != nil {
+			return nil, err
+		}
+		if tree == nil {
+			continue
+		}
+
+		for _, node := range tree.Nodes {
+			name := cleanupNodeName(node.Name)
+			switch node.Type {
+			case "dir":
+				entries[name] = true
+			case "file":
+				if _, ok := entries[name]; !ok {
+					entries[name] = false
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// ReadDirAll returns the entries of all snapshots at the path.
+func (d *versionsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	debug.Log("ReadDirAll(%v)", d.path)
+	entries, err := d.entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []fuse.Dirent{
+		{
+			Inode: d.inode,
+			Name:  ".",
+			Type:  fuse.DT_Dir,
+		},
+		{
+			Inode: d.parentInode,
+			Name:  "..",
+			Type:  fuse.DT_Dir,
+		},
+	}
+
+	for name := range entries {
+		items = append(items, fuse.Dirent{
+			Inode: fs.GenerateDynamicInode(d.inode, name),
+			Name:  name,
+			Type:  fuse.DT_Dir,
+		})
+	}
+	return items, nil
+}
+
+// Lookup returns the directory for the entry name.
+func (d *versionsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	debug.Log("Lookup(%v, %v)", d.path, name)
+	entries, err := d.entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	isDir, ok := entries[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	path := make([]string, 0, len(d.path)+1)
+	path = append(append(path, d.path...), name)
+
+	inode := fs.GenerateDynamicInode(d.inode, name)
+	if isDir {
+		return newVersionsDir(d.root, inode, d.inode, path), nil
+	}
+	return &fileVersionsDir{root: d.root, inode: inode, parentInode: d.inode, path: path}, nil
+}
+
+// Attr returns the attributes for the directory.
+func (d *fileVersionsDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = d.inode
+	a.Mode = os.ModeDir | 0555
+	a.Uid = d.root.uid
+	a.Gid = d.root.gid
+	return nil
+}
+
+// versions returns the distinct versions of the file by name.
+func (d *fileVersionsDir) versions(ctx context.Context) (map[string]*restic.Node, error) {
+	snapshots, err := d.root.SnapshotsDir.dirStruct.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]*restic.Node)
+	seen := make(map[string]struct{})
+	for _, sn := range snapshots {
+		tree, err := d.root.treeAt(ctx, sn, d.path[:len(d.path)-1])
+		if err != nil {
+			return nil, err
+		}
+		if tree == nil {
+			continue
+		}
+
+		node := findNode(tree, d.path[len(d.path)-1])
+		if node == nil || node.Type != "file" {
+			continue
+		}
+
+		// files with the same content are the same version
+		content := fmt.Sprint(node.Content)
+		if _, ok := seen[content]; ok {
+			continue
+		}
+		seen[content] = struct{}{}
+
+		base := sn.Time.Format(d.root.cfg.SnapshotTemplate)
+		name := base
+		for i := 1; versions[name] != nil; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		versions[name] = node
+	}
+	return versions, nil
+}
+
+// ReadDirAll returns the versions of the file.
+func (d *fileVersionsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	debug.Log("ReadDirAll(%v)", strings.Join(d.path, "/"))
+	versions, err := d.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []fuse.Dirent{
+		{
+			Inode: d.inode,
+			Name:  ".",
+			Type:  fuse.DT_Dir,
+		},
+		{
+			Inode: d.parentInode,
+			Name:  "..",
+			Type:  fuse.DT_Dir,
+		},
+	}
+
+	for name := range versions {
+		items = append(items, fuse.Dirent{
+			Inode: fs.GenerateDynamicInode(d.inode, name),
+			Name:  name,
+			Type:  fuse.DT_File,
+		})
+	}
+	return items, nil
+}
+
+// Lookup returns the version of the file with the name.
+func (d *fileVersionsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	debug.Log("Lookup(%v, %v)", strings.Join(d.path, "/"), name)
+	versions, err := d.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	node, ok := versions[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return newFile(ctx, d.root, fs.GenerateDynamicInode(d.inode, name), node)
+}
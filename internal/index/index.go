@@ -186,6 +186,9 @@ func loadIndexJSON(ctx context.Context, repo ListLoader, id restic.ID) (*indexJS
 	return &idx, nil
 }
 
+// loadIndexWorkers is the number of index files which are loaded concurrently.
+const loadIndexWorkers = 8
+
 // Load creates an index by loading all index files from the repo.
 func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, error) {
 	debug.Log("loading indexes")
@@ -193,28 +196,87 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 	p.Start()
 	defer p.Done()
 
+	type Result struct {
+		Error error
+		ID    restic.ID
+		Index *indexJSON
+	}
+
+	// stop loading index files as soon as an error occurred
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	inputCh := make(chan restic.ID)
+	outputCh := make(chan Result)
+	wg, wgCtx := errgroup.WithContext(ctx)
+
+	// list the index files in the repo, send to inputCh
+	wg.Go(func() error {
+		defer close(inputCh)
+		return repo.List(wgCtx, restic.IndexFile, func(id restic.ID, size int64) error {
+			select {
+			case inputCh <- id:
+			case <-wgCtx.Done():
+			}
+			return nil
+		})
+	})
+
+	// run the workers loading the index files, read from inputCh, send to outputCh
+	var workers sync.WaitGroup
+	for i := 0; i < loadIndexWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for id := range inputCh {
+				debug.Log("Load index %v", id)
+				res := Result{ID: id}
+				res.Index, res.Error = loadIndexJSON(wgCtx, repo, id)
+
+				select {
+				case outputCh <- res:
+				case <-wgCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// wait until all the workers are done, then close outputCh
+	wg.Go(func() error {
+		workers.Wait()
+		close(outputCh)
+		return nil
+	})
+
 	supersedes := make(map[restic.ID]restic.IDSet)
 	results := make(map[restic.ID]map[restic.ID]Pack)
 
 	index := newIndex()
 
-	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+	var err error
+	for res := range outputCh {
 		p.Report(restic.Stat{Blobs: 1})
 
-		debug.Log("Load index %v", id)
-		idx, err := loadIndexJSON(ctx, repo, id)
 		if err != nil {
-			return err
+			// drain outputCh so that the workers can terminate
+			continue
+		}
+
+		if res.Error != nil {
+			err = res.Error
+			cancel()
+			continue
 		}
 
-		res := make(map[restic.ID]Pack)
-		supersedes[id] = restic.NewIDSet()
-		for _, sid := range idx.Supersedes {
-			debug.Log("  index %v supersedes %v", id, sid)
-			supersedes[id].Insert(sid)
+		supersedes[res.ID] = restic.NewIDSet()
+		for _, sid := range res.Index.Supersedes {
+			debug.Log("  index %v supersedes %v", res.ID, sid)
+			supersedes[res.ID].Insert(sid)
 		}
 
-		for _, jpack := range idx.Packs {
+		packs := make(map[restic.ID]Pack)
+		for _, jpack := range res.Index.Packs {
 			entries := make([]restic.Blob, 0, len(jpack.Blobs))
 			for _, blob := range jpack.Blobs {
 				entry := restic.Blob{
@@ -227,16 +289,25 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 			}
 
 			if err = index.AddPack(jpack.ID, 0, entries); err != nil {
-				return err
+				cancel()
+				break
 			}
+			packs[jpack.ID] = index.Packs[jpack.ID]
 		}
 
-		results[id] = res
-		index.IndexIDs.Insert(id)
+		// only index files which were added completely are recorded
+		if err != nil {
+			continue
+		}
 
-		return nil
-	})
+		results[res.ID] = packs
+		index.IndexIDs.Insert(res.ID)
+	}
 
+	werr := wg.Wait()
+	if err == nil {
+		err = werr
+	}
 	if err != nil {
 		return nil, err
 	}
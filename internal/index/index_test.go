@@ -2,6 +2,7 @@ package index
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -155,6 +156,65 @@ func TestIndexNewPackErrors(t *testing.T) {
 	}
 }
 
+type loadErrorRepo struct {
+	restic.Repository
+}
+
+func (repo loadErrorRepo) LoadJSONUnpacked(ctx context.Context, t restic.FileType, id restic.ID, item interface{}) error {
+	if t == restic.IndexFile {
+		return errors.New("test load error")
+	}
+
+	return repo.Repository.LoadJSONUnpacked(ctx, t, id, item)
+}
+
+func TestIndexLoadErrors(t *testing.T) {
+	repo, cleanup := createFilledRepo(t, 3, 0)
+	defer cleanup()
+
+	idx, err := Load(context.TODO(), loadErrorRepo{repo}, nil)
+	if err == nil {
+		t.Errorf("expected error not found, got nil")
+	}
+
+	if idx != nil {
+		t.Errorf("expected nil index, got %v", idx)
+	}
+}
+
+func TestIndexLoadDuplicatePacks(t *testing.T) {
+	repo, cleanup := createFilledRepo(t, 3, 0)
+	defer cleanup()
+
+	var ids restic.IDs
+	err := repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// save a second index file which contains the same packs
+	idxJSON, err := loadIndexJSON(context.TODO(), repo, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxJSON.Supersedes = nil
+	if _, err = repo.SaveJSONUnpacked(context.TODO(), restic.IndexFile, idxJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Load(context.TODO(), repo, nil)
+	if err == nil || !strings.Contains(err.Error(), "already present") {
+		t.Errorf("expected error for duplicate pack, got %v", err)
+	}
+
+	if idx != nil {
+		t.Errorf("expected nil index, got %v", idx)
+	}
+}
+
 func TestIndexLoad(t *testing.T) {
 	repo, cleanup := createFilledRepo(t, 3, 0)
 	defer cleanup()
@@ -30,6 +30,15 @@ func (r rateLimitedBackend) Save(ctx context.Context, h restic.Handle, rd restic
 	return r.Backend.Save(ctx, h, limited)
 }
 
+func (r rateLimitedBackend) Replace(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	limited := limitedRewindReader{
+		RewindReader: rd,
+		limited:      r.limiter.Upstream(rd),
+	}
+
+	return restic.Replace(ctx, r.Backend, h, limited)
+}
+
 type limitedRewindReader struct {
 	restic.RewindReader
 
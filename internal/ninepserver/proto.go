@@ -0,0 +1,177 @@
+package ninepserver
+
+import (
+	"encoding/binary"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// message types of 9P2000.L, the requests start with T and the responses
+// with R
+const (
+	msgRlerror      = 7
+	msgTstatfs      = 8
+	msgRstatfs      = 9
+	msgTlopen       = 12
+	msgRlopen       = 13
+	msgTlcreate     = 14
+	msgTsymlink     = 16
+	msgTmknod       = 18
+	msgTrename      = 20
+	msgTgetattr     = 24
+	msgRgetattr     = 25
+	msgTsetattr     = 26
+	msgTxattrwalk   = 30
+	msgTxattrcreate = 32
+	msgTreaddir     = 40
+	msgRreaddir     = 41
+	msgTfsync       = 50
+	msgRfsync       = 51
+	msgTlink        = 70
+	msgTmkdir       = 72
+	msgTrenameat    = 74
+	msgTunlinkat    = 76
+	msgTversion     = 100
+	msgRversion     = 101
+	msgTattach      = 104
+	msgRattach      = 105
+	msgTflush       = 108
+	msgRflush       = 109
+	msgTwalk        = 110
+	msgRwalk        = 111
+	msgTread        = 116
+	msgRread        = 117
+	msgTwrite       = 118
+	msgTclunk       = 120
+	msgRclunk       = 121
+	msgTremove      = 122
+)
+
+// error numbers sent to the client, these are the values used by Linux
+const (
+	errENOENT     = 2
+	errEIO        = 5
+	errEBADF      = 9
+	errENOTDIR    = 20
+	errEISDIR     = 21
+	errEINVAL     = 22
+	errEROFS      = 30
+	errENOSYS     = 38
+	errEOPNOTSUPP = 95
+)
+
+const (
+	qidTypeDir  = 0x80
+	qidTypeFile = 0x00
+)
+
+// qid identifies a file on the server.
+type qid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+// errShortMessage is returned when a message is shorter than its fields.
+var errShortMessage = errors.New("message too short")
+
+// decoder reads the fields of a message.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint16()
+	return string(d.next(int(n)))
+}
+
+// encoder appends the fields of a message to buf.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.uint8(q.Type)
+	e.uint32(q.Version)
+	e.uint64(q.Path)
+}
+
+// message returns the message of type typ with tag, the body is the data
+// appended to the encoder so far.
+func (e *encoder) message(typ uint8, tag uint16) []byte {
+	msg := encoder{buf: make([]byte, 0, 7+len(e.buf))}
+	msg.uint32(uint32(7 + len(e.buf)))
+	msg.uint8(typ)
+	msg.uint16(tag)
+	msg.buf = append(msg.buf, e.buf...)
+	return msg.buf
+}
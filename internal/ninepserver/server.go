@@ -0,0 +1,539 @@
+// Package ninepserver serves a read-only file system via the 9P2000.L
+// protocol, which is used by the 9p file system of Linux.
+package ninepserver
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// maxMessageSize is the largest message the server accepts, clients may
+// negotiate a smaller size.
+const maxMessageSize = 512 * 1024
+
+// Server serves a file system via 9P2000.L.
+type Server struct {
+	fs webdav.FileSystem
+}
+
+// New returns a server for the file system fs. The protocol has no
+// authentication, everyone who can connect can read all files.
+func New(fs webdav.FileSystem) *Server {
+	return &Server{fs: fs}
+}
+
+// Serve accepts connections on ln until an error occurs.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// serveConn answers the requests of a client until the connection is closed.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	debug.Log("new connection from %v", conn.RemoteAddr())
+
+	c := &session{
+		ctx:   ctx,
+		fs:    s.fs,
+		msize: maxMessageSize,
+		fids:  make(map[uint32]*fid),
+	}
+	defer c.clunkAll()
+
+	err := c.serve(conn)
+	if err != nil && err != io.EOF {
+		debug.Log("connection from %v failed: %v", conn.RemoteAddr(), err)
+	}
+	_ = conn.Close()
+}
+
+// fid is a file or directory which the client has walked to.
+type fid struct {
+	path string
+	qid  qid
+
+	// file is set when the fid is opened
+	file webdav.File
+
+	// entries of a directory, read on the first readdir
+	entries []os.FileInfo
+}
+
+// session is the state of a connection.
+type session struct {
+	ctx   context.Context
+	fs    webdav.FileSystem
+	msize uint32
+	fids  map[uint32]*fid
+}
+
+// serve processes the requests on rw one after another.
+func (c *session) serve(rw io.ReadWriter) error {
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+			return err
+		}
+
+		size := binary.LittleEndian.Uint32(hdr[:])
+		if size < 7 || size > c.msize {
+			return errors.Errorf("invalid message size %d", size)
+		}
+
+		buf := make([]byte, size-4)
+		if _, err := io.ReadFull(rw, buf); err != nil {
+			return err
+		}
+
+		d := &decoder{buf: buf}
+		typ := d.uint8()
+		tag := d.uint16()
+
+		if _, err := rw.Write(c.handle(typ, tag, d)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the response to the request.
+func (c *session) handle(typ uint8, tag uint16, d *decoder) []byte {
+	var e encoder
+	var rtyp uint8
+	var errno uint32
+
+	switch typ {
+	case msgTversion:
+		rtyp, errno = msgRversion, c.version(d, &e)
+	case msgTattach:
+		rtyp, errno = msgRattach, c.attach(d, &e)
+	case msgTwalk:
+		rtyp, errno = msgRwalk, c.walk(d, &e)
+	case msgTgetattr:
+		rtyp, errno = msgRgetattr, c.getattr(d, &e)
+	case msgTlopen:
+		rtyp, errno = msgRlopen, c.lopen(d, &e)
+	case msgTread:
+		rtyp, errno = msgRread, c.read(d, &e)
+	case msgTreaddir:
+		rtyp, errno = msgRreaddir, c.readdir(d, &e)
+	case msgTclunk:
+		rtyp, errno = msgRclunk, c.clunk(d)
+	case msgTstatfs:
+		rtyp, errno = msgRstatfs, c.statfs(d, &e)
+	case msgTflush, msgTfsync:
+		// requests are answered in order, so there is nothing to flush, and
+		// nothing is ever written
+		rtyp = typ + 1
+	case msgTxattrwalk, msgTxattrcreate:
+		errno = errEOPNOTSUPP
+	case msgTlcreate, msgTsymlink, msgTmknod, msgTrename, msgTsetattr, msgTlink,
+		msgTmkdir, msgTrenameat, msgTunlinkat, msgTwrite, msgTremove:
+		errno = errEROFS
+	default:
+		debug.Log("unsupported message type %d", typ)
+		errno = errENOSYS
+	}
+
+	if errno != 0 {
+		e = encoder{}
+		e.uint32(errno)
+		return e.message(msgRlerror, tag)
+	}
+	return e.message(rtyp, tag)
+}
+
+// toErrno returns the error number for err.
+func toErrno(err error) uint32 {
+	if os.IsNotExist(errors.Cause(err)) {
+		return errENOENT
+	}
+	debug.Log("error: %v", err)
+	return errEIO
+}
+
+// qidFor returns the qid for the file at p.
+func qidFor(p string, isDir bool) qid {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(p))
+
+	q := qid{Type: qidTypeFile, Path: h.Sum64()}
+	if isDir {
+		q.Type = qidTypeDir
+	}
+	return q
+}
+
+func (c *session) version(d *decoder, e *encoder) uint32 {
+	msize := d.uint32()
+	version := d.string()
+	if d.err != nil || msize < 4096 {
+		return errEINVAL
+	}
+
+	// a new version starts a new session
+	c.clunkAll()
+	if msize < c.msize {
+		c.msize = msize
+	}
+
+	e.uint32(c.msize)
+	if strings.HasPrefix(version, "9P2000.L") {
+		e.string("9P2000.L")
+	} else {
+		e.string("unknown")
+	}
+	return 0
+}
+
+func (c *session) attach(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	_ = d.uint32() // afid
+	_ = d.string() // uname
+	_ = d.string() // aname
+	_ = d.uint32() // n_uname
+	if d.err != nil {
+		return errEINVAL
+	}
+	if _, ok := c.fids[id]; ok {
+		return errEINVAL
+	}
+
+	if _, err := c.fs.Stat(c.ctx, "/"); err != nil {
+		return toErrno(err)
+	}
+
+	f := &fid{path: "/", qid: qidFor("/", true)}
+	c.fids[id] = f
+	e.qid(f.qid)
+	return 0
+}
+
+func (c *session) walk(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	newID := d.uint32()
+	names := make([]string, d.uint16())
+	for i := range names {
+		names[i] = d.string()
+	}
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok {
+		return errEBADF
+	}
+	if _, ok := c.fids[newID]; ok && newID != id {
+		return errEINVAL
+	}
+
+	p := f.path
+	q := f.qid
+	var qids []qid
+	for i, name := range names {
+		if name == "" || name == "." || strings.Contains(name, "/") {
+			return errENOENT
+		}
+
+		next := path.Join(p, name)
+		fi, err := c.fs.Stat(c.ctx, next)
+		if err != nil {
+			if i == 0 {
+				return toErrno(err)
+			}
+			break
+		}
+
+		p = next
+		q = qidFor(p, fi.IsDir())
+		qids = append(qids, q)
+	}
+
+	// the new fid is only set if all names could be walked
+	if len(qids) == len(names) {
+		if newID == id {
+			c.closeFid(f)
+		}
+		c.fids[newID] = &fid{path: p, qid: q}
+	}
+
+	e.uint16(uint16(len(qids)))
+	for _, q := range qids {
+		e.qid(q)
+	}
+	return 0
+}
+
+// getattrBasic is the mask for the attributes which are returned by getattr.
+const getattrBasic = 0x7ff
+
+func (c *session) getattr(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	_ = d.uint64() // request_mask
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok {
+		return errEBADF
+	}
+
+	fi, err := c.fs.Stat(c.ctx, f.path)
+	if err != nil {
+		return toErrno(err)
+	}
+
+	mode, nlink := uint32(0100444), uint64(1)
+	if fi.IsDir() {
+		mode, nlink = 040555, 2
+	}
+
+	size := uint64(fi.Size())
+	mtime := fi.ModTime()
+
+	e.uint64(getattrBasic)
+	e.qid(f.qid)
+	e.uint32(mode)
+	e.uint32(0) // uid
+	e.uint32(0) // gid
+	e.uint64(nlink)
+	e.uint64(0) // rdev
+	e.uint64(size)
+	e.uint64(4096)               // blksize
+	e.uint64((size + 511) / 512) // blocks
+	for i := 0; i < 3; i++ {
+		// atime, mtime and ctime
+		e.uint64(uint64(mtime.Unix()))
+		e.uint64(uint64(mtime.Nanosecond()))
+	}
+	e.uint64(0) // btime_sec
+	e.uint64(0) // btime_nsec
+	e.uint64(0) // gen
+	e.uint64(0) // data_version
+	return 0
+}
+
+// flags of lopen which would modify the file, with the values of Linux
+const (
+	flagWriteOnly = 01
+	flagReadWrite = 02
+	flagCreate    = 0100
+	flagTruncate  = 01000
+)
+
+func (c *session) lopen(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	flags := d.uint32()
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok {
+		return errEBADF
+	}
+	if f.file != nil {
+		return errEINVAL
+	}
+	if flags&(flagWriteOnly|flagReadWrite|flagCreate|flagTruncate) != 0 {
+		return errEROFS
+	}
+
+	file, err := c.fs.OpenFile(c.ctx, f.path, os.O_RDONLY, 0)
+	if err != nil {
+		return toErrno(err)
+	}
+	f.file = file
+
+	e.qid(f.qid)
+	e.uint32(c.msize - 24) // iounit
+	return 0
+}
+
+func (c *session) read(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	offset := d.uint64()
+	count := d.uint32()
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok || f.file == nil {
+		return errEBADF
+	}
+	if f.qid.Type == qidTypeDir {
+		return errEISDIR
+	}
+
+	// the header of the response takes 11 bytes
+	if count > c.msize-11 {
+		count = c.msize - 11
+	}
+
+	if _, err := f.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return toErrno(err)
+	}
+
+	buf := make([]byte, count)
+	n, err := io.ReadFull(f.file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return toErrno(err)
+	}
+
+	e.uint32(uint32(n))
+	e.buf = append(e.buf, buf[:n]...)
+	return 0
+}
+
+// entry types returned by readdir
+const (
+	direntDir  = 4
+	direntFile = 8
+)
+
+func (c *session) readdir(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	offset := d.uint64()
+	count := d.uint32()
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok || f.file == nil {
+		return errEBADF
+	}
+	if f.qid.Type != qidTypeDir {
+		return errENOTDIR
+	}
+
+	// (re)load the entries when the client starts at the beginning
+	if f.entries == nil || offset == 0 {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return toErrno(err)
+		}
+		entries, err := f.file.Readdir(0)
+		if err != nil {
+			return toErrno(err)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+		f.entries = entries
+	}
+
+	// the header of the response takes 11 bytes
+	if count > c.msize-11 {
+		count = c.msize - 11
+	}
+
+	var data encoder
+	for i := offset; i < uint64(len(f.entries))+2; i++ {
+		var name string
+		var q qid
+		switch i {
+		case 0:
+			name, q = ".", f.qid
+		case 1:
+			name, q = "..", qidFor(path.Dir(f.path), true)
+		default:
+			fi := f.entries[i-2]
+			name, q = fi.Name(), qidFor(path.Join(f.path, fi.Name()), fi.IsDir())
+		}
+
+		// qid, offset, type and name
+		if len(data.buf)+13+8+1+2+len(name) > int(count) {
+			break
+		}
+
+		data.qid(q)
+		data.uint64(i + 1)
+		if q.Type == qidTypeDir {
+			data.uint8(direntDir)
+		} else {
+			data.uint8(direntFile)
+		}
+		data.string(name)
+	}
+
+	e.uint32(uint32(len(data.buf)))
+	e.buf = append(e.buf, data.buf...)
+	return 0
+}
+
+func (c *session) clunk(d *decoder) uint32 {
+	id := d.uint32()
+	if d.err != nil {
+		return errEINVAL
+	}
+
+	f, ok := c.fids[id]
+	if !ok {
+		return errEBADF
+	}
+
+	c.closeFid(f)
+	delete(c.fids, id)
+	return 0
+}
+
+// v9fsMagic is the type of the file system returned by statfs
+const v9fsMagic = 0x01021997
+
+func (c *session) statfs(d *decoder, e *encoder) uint32 {
+	id := d.uint32()
+	if d.err != nil {
+		return errEINVAL
+	}
+	if _, ok := c.fids[id]; !ok {
+		return errEBADF
+	}
+
+	e.uint32(v9fsMagic)
+	e.uint32(4096) // bsize
+	e.uint64(0)    // blocks
+	e.uint64(0)    // bfree
+	e.uint64(0)    // bavail
+	e.uint64(0)    // files
+	e.uint64(0)    // ffree
+	e.uint64(0)    // fsid
+	e.uint32(255)  // namelen
+	return 0
+}
+
+// closeFid closes the file of f, if it is open.
+func (c *session) closeFid(f *fid) {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+	f.entries = nil
+}
+
+// clunkAll releases all fids.
+func (c *session) clunkAll() {
+	for id, f := range c.fids {
+		c.closeFid(f)
+		delete(c.fids, id)
+	}
+}
@@ -0,0 +1,165 @@
+package ninepserver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testClient sends requests to a server and returns the responses.
+type testClient struct {
+	t    testing.TB
+	conn net.Conn
+}
+
+func (c testClient) request(typ uint8, e encoder) (uint8, *decoder) {
+	c.t.Helper()
+
+	_, err := c.conn.Write(e.message(typ, 1))
+	rtest.OK(c.t, err)
+
+	var hdr [4]byte
+	_, err = io.ReadFull(c.conn, hdr[:])
+	rtest.OK(c.t, err)
+
+	buf := make([]byte, binary.LittleEndian.Uint32(hdr[:])-4)
+	_, err = io.ReadFull(c.conn, buf)
+	rtest.OK(c.t, err)
+
+	d := &decoder{buf: buf}
+	rtyp := d.uint8()
+	rtest.Equals(c.t, uint16(1), d.uint16())
+	return rtyp, d
+}
+
+// ok sends the request and checks that it succeeds.
+func (c testClient) ok(typ uint8, e encoder) *decoder {
+	c.t.Helper()
+
+	rtyp, d := c.request(typ, e)
+	if rtyp == msgRlerror {
+		c.t.Fatalf("request %d failed with error %d", typ, d.uint32())
+	}
+	rtest.Equals(c.t, typ+1, rtyp)
+	return d
+}
+
+// fails sends the request and checks that it fails with errno.
+func (c testClient) fails(typ uint8, e encoder, errno uint32) {
+	c.t.Helper()
+
+	rtyp, d := c.request(typ, e)
+	rtest.Equals(c.t, uint8(msgRlerror), rtyp)
+	rtest.Equals(c.t, errno, d.uint32())
+}
+
+func walk(id, newID uint32, names ...string) encoder {
+	var e encoder
+	e.uint32(id)
+	e.uint32(newID)
+	e.uint16(uint16(len(names)))
+	for _, name := range names {
+		e.string(name)
+	}
+	return e
+}
+
+func open(id, flags uint32) encoder {
+	var e encoder
+	e.uint32(id)
+	e.uint32(flags)
+	return e
+}
+
+func read(id uint32, offset uint64, count uint32) encoder {
+	var e encoder
+	e.uint32(id)
+	e.uint64(offset)
+	e.uint32(count)
+	return e
+}
+
+func TestServer(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "foo"), []byte("foobar"), 0644))
+
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go New(webdav.Dir(tempdir)).serveConn(context.TODO(), server)
+
+	c := testClient{t: t, conn: client}
+
+	var e encoder
+	e.uint32(8192)
+	e.string("9P2000.L")
+	d := c.ok(msgTversion, e)
+	rtest.Equals(t, uint32(8192), d.uint32())
+	rtest.Equals(t, "9P2000.L", d.string())
+
+	e = encoder{}
+	e.uint32(0)
+	e.uint32(^uint32(0))
+	e.string("user")
+	e.string("")
+	e.uint32(1000)
+	d = c.ok(msgTattach, e)
+	rtest.Equals(t, uint8(qidTypeDir), d.uint8())
+
+	// read the file
+	d = c.ok(msgTwalk, walk(0, 1, "foo"))
+	rtest.Equals(t, uint16(1), d.uint16())
+	rtest.Equals(t, uint8(qidTypeFile), d.uint8())
+
+	e = encoder{}
+	e.uint32(1)
+	e.uint64(getattrBasic)
+	d = c.ok(msgTgetattr, e)
+	d.next(8 + 13)
+	rtest.Equals(t, uint32(0100444), d.uint32())
+	d.next(4 + 4 + 8 + 8)
+	rtest.Equals(t, uint64(6), d.uint64())
+
+	c.fails(msgTlopen, open(1, flagReadWrite), errEROFS)
+	c.ok(msgTlopen, open(1, 0))
+
+	d = c.ok(msgTread, read(1, 3, 100))
+	rtest.Equals(t, uint32(3), d.uint32())
+	rtest.Equals(t, "bar", string(d.buf))
+
+	e = encoder{}
+	e.uint32(1)
+	c.ok(msgTclunk, e)
+
+	// list the root directory
+	c.fails(msgTwalk, walk(0, 2, "missing"), errENOENT)
+	c.ok(msgTwalk, walk(0, 2))
+	c.ok(msgTlopen, open(2, 0))
+
+	d = c.ok(msgTreaddir, read(2, 0, 4096))
+	d = &decoder{buf: d.next(int(d.uint32()))}
+	var names []string
+	for len(d.buf) > 0 {
+		d.next(13 + 8 + 1)
+		names = append(names, d.string())
+	}
+	rtest.OK(t, d.err)
+	rtest.Equals(t, []string{".", "..", "foo"}, names)
+
+	d = c.ok(msgTreaddir, read(2, 3, 4096))
+	rtest.Equals(t, uint32(0), d.uint32())
+
+	// modifying files is rejected
+	c.fails(msgTmkdir, encoder{}, errEROFS)
+}
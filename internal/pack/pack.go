@@ -173,6 +173,15 @@ const (
 	eagerEntries = 15
 )
 
+// Size returns the size of a pack file which contains exactly the blobs.
+func Size(blobs []restic.Blob) int64 {
+	size := int64(crypto.Extension + headerLengthSize)
+	for _, blob := range blobs {
+		size += int64(blob.Length + entrySize)
+	}
+	return size
+}
+
 // readRecords reads up to max records from the underlying ReaderAt, returning
 // the raw header, the total number of records in the header, and any error.
 // If the header contains fewer than max entries, the header is truncated to
@@ -267,12 +276,24 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 		return nil, err
 	}
 
+	return ParseHeader(k, buf)
+}
+
+// ReadHeader returns the still encrypted header of the pack file in rd. size
+// is the length of the pack file.
+func ReadHeader(rd io.ReaderAt, size int64) ([]byte, error) {
+	return readHeader(rd, size)
+}
+
+// ParseHeader decrypts the pack header buf, as returned by ReadHeader, and
+// returns the list of entries. buf is not modified.
+func ParseHeader(k *crypto.Key, buf []byte) (entries []restic.Blob, err error) {
 	if len(buf) < k.NonceSize()+k.Overhead() {
 		return nil, errors.New("invalid header, too small")
 	}
 
 	nonce, buf := buf[:k.NonceSize()], buf[k.NonceSize():]
-	buf, err = k.Open(buf[:0], nonce, buf, nil)
+	buf, err = k.Open(nil, nonce, buf, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +94,16 @@ func TestCreatePack(t *testing.T) {
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
+func TestPackSize(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	_, packData, _ := newPack(t, k, testLens)
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+
+	rtest.Equals(t, int64(len(packData)), pack.Size(entries))
+}
+
 var blobTypeJSON = []struct {
 	t   restic.BlobType
 	res string
@@ -16,7 +16,9 @@ import (
 // Index holds a lookup table for id -> pack.
 type Index struct {
 	m         sync.Mutex
-	pack      map[restic.BlobHandle][]indexEntry
+	byType    [restic.NumBlobTypes]indexMap
+	packs     restic.IDs
+	packIndex map[restic.ID]int // position of the pack IDs in packs
 	treePacks restic.IDs
 
 	final      bool      // set to true for all indexes read from the backend ("finalized")
@@ -25,28 +27,67 @@ type Index struct {
 	created    time.Time
 }
 
-type indexEntry struct {
-	packID restic.ID
-	offset uint
-	length uint
-}
-
 // NewIndex returns a new index.
 func NewIndex() *Index {
 	return &Index{
-		pack:    make(map[restic.BlobHandle][]indexEntry),
-		created: time.Now(),
+		packIndex: make(map[restic.ID]int),
+		created:   time.Now(),
+	}
+}
+
+// addPack returns the position of id in the list of packs, it is added if
+// necessary.
+func (idx *Index) addPack(id restic.ID) int {
+	if i, ok := idx.packIndex[id]; ok {
+		return i
+	}
+
+	idx.packs = append(idx.packs, id)
+	idx.packIndex[id] = len(idx.packs) - 1
+	return len(idx.packs) - 1
+}
+
+func (idx *Index) store(packIndex int, blob restic.Blob) {
+	idx.byType[blob.Type].add(blob.ID, packIndex, blob.Offset, blob.Length)
+}
+
+// toPackedBlob converts an entry of the index map for blobs of type t.
+func (idx *Index) toPackedBlob(e *indexEntry, t restic.BlobType) restic.PackedBlob {
+	return restic.PackedBlob{
+		Blob: restic.Blob{
+			ID:     e.id,
+			Type:   t,
+			Offset: uint(e.offset),
+			Length: uint(e.length),
+		},
+		PackID: idx.packs[e.packIndex],
 	}
 }
 
-func (idx *Index) store(blob restic.PackedBlob) {
-	newEntry := indexEntry{
-		packID: blob.PackID,
-		offset: blob.Offset,
-		length: blob.Length,
+// len returns the number of blobs in the index.
+func (idx *Index) len() (n uint) {
+	for i := range idx.byType {
+		n += idx.byType[i].len()
 	}
-	h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-	idx.pack[h] = append(idx.pack[h], newEntry)
+	return n
+}
+
+// moveToFile moves the blob entries of a finalized index to memory mapped
+// files in dir, see indexMap.moveToFile.
+func (idx *Index) moveToFile(dir string) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if !idx.final {
+		return errors.New("index is not final")
+	}
+
+	for i := range idx.byType {
+		if err := idx.byType[i].moveToFile(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -72,7 +113,7 @@ var IndexFull = func(idx *Index) bool {
 
 	debug.Log("checking whether index %p is full", idx)
 
-	packs := len(idx.pack)
+	packs := idx.len()
 	age := time.Now().Sub(idx.created)
 
 	if age > indexMaxAge {
@@ -106,7 +147,7 @@ func (idx *Index) Store(blob restic.PackedBlob) {
 
 	debug.Log("%v", blob)
 
-	idx.store(blob)
+	idx.store(idx.addPack(blob.PackID), blob.Blob)
 }
 
 // Lookup queries the index for the blob ID and returns a restic.PackedBlob.
@@ -114,29 +155,15 @@ func (idx *Index) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.Pack
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	h := restic.BlobHandle{ID: id, Type: tpe}
-
-	if packs, ok := idx.pack[h]; ok {
-		blobs = make([]restic.PackedBlob, 0, len(packs))
-
-		for _, p := range packs {
-			blob := restic.PackedBlob{
-				Blob: restic.Blob{
-					Type:   tpe,
-					Length: p.length,
-					ID:     id,
-					Offset: p.offset,
-				},
-				PackID: p.packID,
-			}
-
-			blobs = append(blobs, blob)
-		}
-
-		return blobs, true
+	if tpe >= restic.NumBlobTypes {
+		return nil, false
 	}
 
-	return nil, false
+	idx.byType[tpe].foreachWithID(id, func(e *indexEntry) {
+		blobs = append(blobs, idx.toPackedBlob(e, tpe))
+	})
+
+	return blobs, len(blobs) > 0
 }
 
 // ListPack returns a list of blobs contained in a pack.
@@ -144,21 +171,25 @@ func (idx *Index) ListPack(id restic.ID) (list []restic.PackedBlob) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	for h, packList := range idx.pack {
-		for _, entry := range packList {
-			if entry.packID == id {
-				list = append(list, restic.PackedBlob{
-					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Length: entry.length,
-						Offset: entry.offset,
-					},
-					PackID: entry.packID,
-				})
-			}
+	packIndex := -1
+	for i, packID := range idx.packs {
+		if packID == id {
+			packIndex = i
+			break
 		}
 	}
+	if packIndex < 0 {
+		return nil
+	}
+
+	for t := range idx.byType {
+		idx.byType[t].foreach(func(e *indexEntry) bool {
+			if e.packIndex == uint32(packIndex) {
+				list = append(list, idx.toPackedBlob(e, restic.BlobType(t)))
+			}
+			return true
+		})
+	}
 
 	return list
 }
@@ -168,10 +199,11 @@ func (idx *Index) Has(id restic.ID, tpe restic.BlobType) bool {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	h := restic.BlobHandle{ID: id, Type: tpe}
+	if tpe >= restic.NumBlobTypes {
+		return false
+	}
 
-	_, ok := idx.pack[h]
-	return ok
+	return idx.byType[tpe].get(id) != nil
 }
 
 // LookupSize returns the length of the plaintext content of the blob with the
@@ -218,21 +250,19 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 			close(ch)
 		}()
 
-		for h, packs := range idx.pack {
-			for _, blob := range packs {
+		for t := range idx.byType {
+			done := false
+			idx.byType[t].foreach(func(e *indexEntry) bool {
 				select {
 				case <-ctx.Done():
-					return
-				case ch <- restic.PackedBlob{
-					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Offset: blob.offset,
-						Length: blob.length,
-					},
-					PackID: blob.packID,
-				}:
+					done = true
+					return false
+				case ch <- idx.toPackedBlob(e, restic.BlobType(t)):
+					return true
 				}
+			})
+			if done {
+				return
 			}
 		}
 	}()
@@ -246,9 +276,17 @@ func (idx *Index) Packs() restic.IDSet {
 	defer idx.m.Unlock()
 
 	packs := restic.NewIDSet()
-	for _, list := range idx.pack {
-		for _, entry := range list {
-			packs.Insert(entry.packID)
+	used := make([]bool, len(idx.packs))
+	for t := range idx.byType {
+		idx.byType[t].foreach(func(e *indexEntry) bool {
+			used[e.packIndex] = true
+			return true
+		})
+	}
+
+	for i, id := range idx.packs {
+		if used[i] {
+			packs.Insert(id)
 		}
 	}
 
@@ -261,15 +299,11 @@ func (idx *Index) Count(t restic.BlobType) (n uint) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	for h, list := range idx.pack {
-		if h.Type != t {
-			continue
-		}
-
-		n += uint(len(list))
+	if t >= restic.NumBlobTypes {
+		return 0
 	}
 
-	return
+	return idx.byType[t].len()
 }
 
 type packJSON struct {
@@ -287,40 +321,41 @@ type blobJSON struct {
 // generatePackList returns a list of packs.
 func (idx *Index) generatePackList() ([]*packJSON, error) {
 	list := []*packJSON{}
-	packs := make(map[restic.ID]*packJSON)
-
-	for h, packedBlobs := range idx.pack {
-		for _, blob := range packedBlobs {
-			if blob.packID.IsNull() {
-				panic("null pack id")
-			}
+	packs := make([]*packJSON, len(idx.packs))
 
-			debug.Log("handle blob %v", h)
-
-			if blob.packID.IsNull() {
+	for t := range idx.byType {
+		var err error
+		idx.byType[t].foreach(func(e *indexEntry) bool {
+			packID := idx.packs[e.packIndex]
+			if packID.IsNull() {
 				debug.Log("blob %v has no packID! (offset %v, length %v)",
-					h, blob.offset, blob.length)
-				return nil, errors.Errorf("unable to serialize index: pack for blob %v hasn't been written yet", h)
+					e.id, e.offset, e.length)
+				err = errors.Errorf("unable to serialize index: pack for blob %v hasn't been written yet", e.id)
+				return false
 			}
 
-			// see if pack is already in map
-			p, ok := packs[blob.packID]
-			if !ok {
+			// see if pack is already in the list
+			p := packs[e.packIndex]
+			if p == nil {
 				// else create new pack
-				p = &packJSON{ID: blob.packID}
+				p = &packJSON{ID: packID}
 
-				// and append it to the list and map
+				// and append it to the list
 				list = append(list, p)
-				packs[p.ID] = p
+				packs[e.packIndex] = p
 			}
 
 			// add blob
 			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     h.ID,
-				Type:   h.Type,
-				Offset: blob.offset,
-				Length: blob.length,
+				ID:     e.id,
+				Type:   restic.BlobType(t),
+				Offset: uint(e.offset),
+				Length: uint(e.length),
 			})
+			return true
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -367,6 +402,8 @@ func (idx *Index) Finalize(w io.Writer) error {
 	defer idx.m.Unlock()
 
 	idx.final = true
+	// the positions of the pack IDs are only needed to add new blobs
+	idx.packIndex = nil
 
 	return idx.encode(w)
 }
@@ -453,36 +490,41 @@ func isErrOldIndex(err error) bool {
 // ErrOldIndexFormat means an index with the old format was detected.
 var ErrOldIndexFormat = errors.New("index has old format")
 
-// DecodeIndex loads and unserializes an index from rd.
-func DecodeIndex(buf []byte) (idx *Index, err error) {
-	debug.Log("Start decoding index")
-	idxJSON := &jsonIndex{}
-
-	err = json.Unmarshal(buf, idxJSON)
-	if err != nil {
-		debug.Log("Error %v", err)
-
-		if isErrOldIndex(err) {
-			debug.Log("index is probably old format, trying that")
-			err = ErrOldIndexFormat
+// decodePacks returns a new index which contains the blobs in packs.
+func decodePacks(packs []*packJSON) (*Index, error) {
+	var count [restic.NumBlobTypes]int
+	for _, pack := range packs {
+		for _, blob := range pack.Blobs {
+			if blob.Type >= restic.NumBlobTypes {
+				return nil, errors.Errorf("pack %v contains blob %v with invalid type %v",
+					pack.ID.Str(), blob.ID.Str(), blob.Type)
+			}
+			count[blob.Type]++
 		}
+	}
 
-		return nil, errors.Wrap(err, "Decode")
+	idx := &Index{
+		packs:   make(restic.IDs, 0, len(packs)),
+		created: time.Now(),
+	}
+	for t := range idx.byType {
+		idx.byType[t].reserve(count[t])
 	}
 
-	idx = NewIndex()
-	for _, pack := range idxJSON.Packs {
+	for _, pack := range packs {
 		var data, tree bool
 
+		// packs are listed only once in an index, so there's no need for
+		// the lookup table in addPack
+		idx.packs = append(idx.packs, pack.ID)
+		packIndex := len(idx.packs) - 1
+
 		for _, blob := range pack.Blobs {
-			idx.store(restic.PackedBlob{
-				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
-				},
-				PackID: pack.ID,
+			idx.store(packIndex, restic.Blob{
+				Type:   blob.Type,
+				ID:     blob.ID,
+				Offset: blob.Offset,
+				Length: blob.Length,
 			})
 
 			switch blob.Type {
@@ -497,6 +539,32 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 			idx.treePacks = append(idx.treePacks, pack.ID)
 		}
 	}
+
+	return idx, nil
+}
+
+// DecodeIndex loads and unserializes an index from rd.
+func DecodeIndex(buf []byte) (idx *Index, err error) {
+	debug.Log("Start decoding index")
+	idxJSON := &jsonIndex{}
+
+	err = json.Unmarshal(buf, idxJSON)
+	if err != nil {
+		debug.Log("Error %v", err)
+
+		if isErrOldIndex(err) {
+			debug.Log("index is probably old format, trying that")
+			err = ErrOldIndexFormat
+		}
+
+		return nil, errors.Wrap(err, "Decode")
+	}
+
+	idx, err = decodePacks(idxJSON.Packs)
+	if err != nil {
+		return nil, err
+	}
+
 	idx.supersedes = idxJSON.Supersedes
 	idx.final = true
 
@@ -515,33 +583,11 @@ func DecodeOldIndex(buf []byte) (idx *Index, err error) {
 		return nil, errors.Wrap(err, "Decode")
 	}
 
-	idx = NewIndex()
-	for _, pack := range list {
-		var data, tree bool
-
-		for _, blob := range pack.Blobs {
-			idx.store(restic.PackedBlob{
-				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
-				},
-				PackID: pack.ID,
-			})
-
-			switch blob.Type {
-			case restic.DataBlob:
-				data = true
-			case restic.TreeBlob:
-				tree = true
-			}
-		}
-
-		if !data && tree {
-			idx.treePacks = append(idx.treePacks, pack.ID)
-		}
+	idx, err = decodePacks(list)
+	if err != nil {
+		return nil, err
 	}
+
 	idx.final = true
 
 	debug.Log("done")
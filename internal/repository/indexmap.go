@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"unsafe"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// An indexMap is a hash table that maps blob IDs to their locations in pack
+// files. Compared to a Go map of slices, it saves a lot of memory: all
+// entries are stored in a single slice without pointers, the buckets only hold
+// 32 bit positions into that slice and pack IDs are replaced by a small index
+// into the list of packs of the Index.
+//
+// Multiple entries may be stored for the same ID. They are returned in the
+// order they were added.
+//
+// The map is not safe for concurrent use.
+type indexMap struct {
+	buckets []uint32 // position of the first entry in the chain plus one, zero means empty
+	entries []indexEntry
+}
+
+type indexEntry struct {
+	id        restic.ID
+	next      uint32 // position of the next entry in the chain plus one
+	packIndex uint32 // position of the pack ID in Index.packs
+	offset    uint32
+	length    uint32
+}
+
+const indexMapMinBuckets = 64
+
+// add inserts an entry for id into the map.
+func (m *indexMap) add(id restic.ID, packIndex int, offset, length uint) {
+	if len(m.entries) >= len(m.buckets) {
+		m.grow()
+	}
+
+	m.entries = append(m.entries, indexEntry{
+		id:        id,
+		packIndex: uint32(packIndex),
+		offset:    uint32(offset),
+		length:    uint32(length),
+	})
+	m.link(uint32(len(m.entries)))
+}
+
+// reserve prepares the map for n entries, so that adding them does not need
+// to reallocate memory.
+func (m *indexMap) reserve(n int) {
+	if n <= len(m.buckets) {
+		return
+	}
+
+	buckets := indexMapMinBuckets
+	for buckets < n {
+		buckets *= 2
+	}
+
+	entries := make([]indexEntry, len(m.entries), n)
+	copy(entries, m.entries)
+	m.entries = entries
+
+	m.buckets = make([]uint32, buckets/2)
+	m.grow()
+}
+
+// link appends the entry at position pos-1 to the end of its chain.
+func (m *indexMap) link(pos uint32) {
+	e := &m.entries[pos-1]
+	e.next = 0
+
+	next := &m.buckets[m.hash(e.id)]
+	for *next != 0 {
+		next = &m.entries[*next-1].next
+	}
+	*next = pos
+}
+
+// grow doubles the number of buckets and redistributes all entries.
+func (m *indexMap) grow() {
+	n := 2 * len(m.buckets)
+	if n < indexMapMinBuckets {
+		n = indexMapMinBuckets
+	}
+	m.buckets = make([]uint32, n)
+
+	// re-linking in order of insertion keeps the order within the chains
+	for i := range m.entries {
+		m.link(uint32(i + 1))
+	}
+}
+
+// hash returns the bucket for id. IDs are SHA-256 hashes, so their first bytes
+// are already distributed uniformly.
+func (m *indexMap) hash(id restic.ID) uint {
+	return uint(binary.LittleEndian.Uint64(id[:8]) & uint64(len(m.buckets)-1))
+}
+
+// foreachWithID calls fn for all entries for id.
+func (m *indexMap) foreachWithID(id restic.ID, fn func(*indexEntry)) {
+	if len(m.buckets) == 0 {
+		return
+	}
+
+	for pos := m.buckets[m.hash(id)]; pos != 0; {
+		e := &m.entries[pos-1]
+		if e.id == id {
+			fn(e)
+		}
+		pos = e.next
+	}
+}
+
+// get returns the first entry for id or nil if there is none.
+func (m *indexMap) get(id restic.ID) *indexEntry {
+	if len(m.buckets) == 0 {
+		return nil
+	}
+
+	for pos := m.buckets[m.hash(id)]; pos != 0; {
+		e := &m.entries[pos-1]
+		if e.id == id {
+			return e
+		}
+		pos = e.next
+	}
+	return nil
+}
+
+// foreach calls fn for all entries in the order they were added. If fn
+// returns false, the iteration stops.
+func (m *indexMap) foreach(fn func(*indexEntry) bool) {
+	for i := range m.entries {
+		if !fn(&m.entries[i]) {
+			return
+		}
+	}
+}
+
+// len returns the number of entries in the map.
+func (m *indexMap) len() uint {
+	return uint(len(m.entries))
+}
+
+// sliceAt sets the slice described by sh to the n elements starting at p.
+func sliceAt(sh *reflect.SliceHeader, p unsafe.Pointer, n int) {
+	sh.Data = uintptr(p)
+	sh.Len = n
+	sh.Cap = n
+}
+
+// moveToFile writes the buckets and entries of the map to a temporary file in
+// dir and replaces them by a read-only memory mapping of that file. The kernel
+// can then page the map out when memory is short. The file is removed right
+// away, the mapping stays valid until the process exits. The map must not be
+// modified afterwards.
+func (m *indexMap) moveToFile(dir string) error {
+	if len(m.entries) == 0 {
+		return nil
+	}
+
+	bucketBytes := len(m.buckets) * int(unsafe.Sizeof(m.buckets[0]))
+	entryBytes := len(m.entries) * int(unsafe.Sizeof(m.entries[0]))
+
+	f, err := ioutil.TempFile(dir, "index-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	var buf []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	sliceAt(sh, unsafe.Pointer(&m.buckets[0]), bucketBytes)
+	if _, err = f.Write(buf); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	sliceAt(sh, unsafe.Pointer(&m.entries[0]), entryBytes)
+	if _, err = f.Write(buf); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	data, err := mmapFile(f, bucketBytes+entryBytes)
+	if err != nil {
+		return err
+	}
+
+	var buckets []uint32
+	sliceAt((*reflect.SliceHeader)(unsafe.Pointer(&buckets)), unsafe.Pointer(&data[0]), len(m.buckets))
+	var entries []indexEntry
+	sliceAt((*reflect.SliceHeader)(unsafe.Pointer(&entries)), unsafe.Pointer(&data[bucketBytes]), len(m.entries))
+
+	m.buckets, m.entries = buckets, entries
+	return nil
+}
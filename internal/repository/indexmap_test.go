@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestIndexMapBasic(t *testing.T) {
+	var m indexMap
+
+	ids := make(restic.IDs, 5000)
+	for i := range ids {
+		ids[i] = restic.NewRandomID()
+		m.add(ids[i], i, uint(i), uint(2*i))
+	}
+	rtest.Equals(t, uint(len(ids)), m.len())
+
+	for i, id := range ids {
+		e := m.get(id)
+		rtest.Assert(t, e != nil, "entry %d not found", i)
+		rtest.Equals(t, uint32(i), e.packIndex)
+		rtest.Equals(t, uint32(i), e.offset)
+		rtest.Equals(t, uint32(2*i), e.length)
+	}
+
+	rtest.Assert(t, m.get(restic.NewRandomID()) == nil, "found entry for unknown ID")
+
+	n := 0
+	m.foreach(func(e *indexEntry) bool {
+		rtest.Equals(t, ids[n], e.id)
+		n++
+		return n < 10
+	})
+	rtest.Equals(t, 10, n)
+}
+
+func TestIndexMapDuplicates(t *testing.T) {
+	var m indexMap
+
+	id := restic.NewRandomID()
+	for i := 0; i < 3; i++ {
+		m.add(id, i, 0, 0)
+		// force several rehashes in between
+		for j := 0; j < 100; j++ {
+			m.add(restic.NewRandomID(), 1000, 0, 0)
+		}
+	}
+
+	var packs []uint32
+	m.foreachWithID(id, func(e *indexEntry) {
+		packs = append(packs, e.packIndex)
+	})
+	rtest.Equals(t, []uint32{0, 1, 2}, packs)
+}
+
+func TestIndexMapReserve(t *testing.T) {
+	var m indexMap
+	m.reserve(100)
+
+	buckets := len(m.buckets)
+	rtest.Equals(t, 100, cap(m.entries))
+
+	for i := 0; i < 100; i++ {
+		m.add(restic.NewRandomID(), i, 0, 0)
+	}
+	rtest.Equals(t, buckets, len(m.buckets))
+	rtest.Equals(t, 100, cap(m.entries))
+}
+
+func TestIndexMapEmpty(t *testing.T) {
+	var m indexMap
+
+	rtest.Assert(t, m.get(restic.NewRandomID()) == nil, "found entry in empty map")
+	m.foreachWithID(restic.NewRandomID(), func(*indexEntry) {
+		t.Fatal("callback called for empty map")
+	})
+	rtest.Equals(t, uint(0), m.len())
+}
+
+func TestIndexMapMoveToFile(t *testing.T) {
+	if !mmapSupported {
+		t.Skip("memory mapped files are not supported on this platform")
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	var m indexMap
+	ids := make(restic.IDs, 1000)
+	for i := range ids {
+		ids[i] = restic.NewRandomID()
+		m.add(ids[i], i, uint(i), uint(2*i))
+	}
+
+	rtest.OK(t, m.moveToFile(tempdir))
+	rtest.Equals(t, uint(len(ids)), m.len())
+
+	for i, id := range ids {
+		e := m.get(id)
+		rtest.Assert(t, e != nil, "entry %d not found", i)
+		rtest.Equals(t, uint32(i), e.packIndex)
+		rtest.Equals(t, uint32(i), e.offset)
+		rtest.Equals(t, uint32(2*i), e.length)
+	}
+	rtest.Assert(t, m.get(restic.NewRandomID()) == nil, "found entry for unknown ID")
+
+	// the temporary file is removed right away
+	files, err := ioutil.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(files))
+}
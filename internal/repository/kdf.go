@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Names of the supported key derivation functions.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// KDFParams selects the key derivation function used to derive the user key
+// from a password, together with its parameters. Only the parameters for the
+// selected KDF are set. If they are nil, the default parameters are used.
+type KDFParams struct {
+	KDF    string
+	Scrypt *crypto.Params
+	Argon2 *crypto.Argon2Params
+
+	// TargetTime is set when the parameters should be calibrated, so that
+	// deriving a key takes about this long on the current hardware.
+	TargetTime time.Duration
+}
+
+// ParseKDFParams parses a KDF specification in the form "kdf" or
+// "kdf:key=value,...". For scrypt the keys N, r and p are supported, for
+// argon2id the keys t (time), m (memory in KiB) and p (threads). Parameters
+// which are not specified are set to their default values. If no parameters
+// are given for scrypt, they are calibrated when the key is created. The key
+// ms sets a target time in milliseconds for calibrating the parameters, for
+// argon2id the number of passes is chosen for the given memory and threads.
+func ParseKDFParams(s string) (KDFParams, error) {
+	name, list := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, list = s[:i], s[i+1:]
+	}
+
+	values := make(map[string]uint64)
+	if list != "" {
+		for _, kv := range strings.Split(list, ",") {
+			data := strings.SplitN(kv, "=", 2)
+			if len(data) != 2 {
+				return KDFParams{}, errors.Errorf("invalid KDF parameter %q, expected key=value", kv)
+			}
+
+			v, err := strconv.ParseUint(strings.TrimSpace(data[1]), 10, 32)
+			if err != nil {
+				return KDFParams{}, errors.Errorf("invalid value for KDF parameter %q: %v", data[0], err)
+			}
+			values[strings.TrimSpace(data[0])] = v
+		}
+	}
+
+	// get returns the value for key and removes it from values
+	get := func(key string, def uint64) uint64 {
+		v, ok := values[key]
+		if !ok {
+			return def
+		}
+		delete(values, key)
+		return v
+	}
+
+	var p KDFParams
+	if ms := get("ms", 0); ms > 0 {
+		p.TargetTime = time.Duration(ms) * time.Millisecond
+	}
+
+	switch name {
+	case KDFScrypt:
+		p.KDF = KDFScrypt
+		if len(values) > 0 && p.TargetTime > 0 {
+			return KDFParams{}, errors.New("scrypt: parameters cannot be combined with ms")
+		}
+		if len(values) > 0 {
+			p.Scrypt = &crypto.Params{
+				N: int(get("N", uint64(crypto.DefaultKDFParams.N))),
+				R: int(get("r", uint64(crypto.DefaultKDFParams.R))),
+				P: int(get("p", uint64(crypto.DefaultKDFParams.P))),
+			}
+		}
+	case KDFArgon2id:
+		p.KDF = KDFArgon2id
+		def := crypto.DefaultArgon2Params
+		threads := get("p", uint64(def.Threads))
+		if threads > 255 {
+			return KDFParams{}, errors.Errorf("argon2id: threads must be at most 255, got %d", threads)
+		}
+		if _, ok := values["t"]; ok && p.TargetTime > 0 {
+			return KDFParams{}, errors.New("argon2id: t cannot be combined with ms")
+		}
+		if p.TargetTime > 0 {
+			// start of the calibration
+			def.Time = 1
+		}
+		p.Argon2 = &crypto.Argon2Params{
+			Time:    uint32(get("t", uint64(def.Time))),
+			Memory:  uint32(get("m", uint64(def.Memory))),
+			Threads: uint8(threads),
+		}
+		if err := p.Argon2.Check(); err != nil {
+			return KDFParams{}, err
+		}
+	default:
+		return KDFParams{}, errors.Errorf("unsupported KDF %q, valid are %q and %q", name, KDFScrypt, KDFArgon2id)
+	}
+
+	if len(values) > 0 {
+		var unknown []string
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		return KDFParams{}, errors.Errorf("unknown parameters %q for KDF %v", unknown, p.KDF)
+	}
+
+	if p.Scrypt != nil {
+		if err := p.Scrypt.Check(); err != nil {
+			return KDFParams{}, err
+		}
+	}
+
+	return p, nil
+}
+
+// String returns the parameters in the format accepted by ParseKDFParams.
+func (p KDFParams) String() string {
+	switch {
+	case p.KDF == KDFScrypt && p.TargetTime > 0:
+		return fmt.Sprintf("%v:ms=%d", p.KDF, p.TargetTime/time.Millisecond)
+	case p.KDF == KDFArgon2id && p.Argon2 != nil && p.TargetTime > 0:
+		return fmt.Sprintf("%v:m=%d,p=%d,ms=%d", p.KDF, p.Argon2.Memory, p.Argon2.Threads, p.TargetTime/time.Millisecond)
+	case p.KDF == KDFScrypt && p.Scrypt != nil:
+		return fmt.Sprintf("%v:N=%d,r=%d,p=%d", p.KDF, p.Scrypt.N, p.Scrypt.R, p.Scrypt.P)
+	case p.KDF == KDFArgon2id && p.Argon2 != nil:
+		return fmt.Sprintf("%v:t=%d,m=%d,p=%d", p.KDF, p.Argon2.Time, p.Argon2.Memory, p.Argon2.Threads)
+	}
+	return p.KDF
+}
+
+// Calibrate returns the parameters for the KDF which take about TargetTime
+// to derive a key on the current hardware.
+func (p KDFParams) Calibrate() (KDFParams, error) {
+	switch p.KDF {
+	case KDFScrypt:
+		params, err := crypto.Calibrate(p.TargetTime, KDFMemory)
+		if err != nil {
+			return p, errors.Wrap(err, "Calibrate")
+		}
+		p.Scrypt = &params
+	case KDFArgon2id:
+		argon2 := crypto.DefaultArgon2Params
+		if p.Argon2 != nil {
+			argon2 = *p.Argon2
+		}
+		params, err := crypto.CalibrateArgon2(p.TargetTime, argon2)
+		if err != nil {
+			return p, err
+		}
+		p.Argon2 = &params
+	default:
+		return p, errors.Errorf("unsupported KDF %q", p.KDF)
+	}
+
+	p.TargetTime = 0
+	return p, nil
+}
+
+// deriveKey derives the user key from the password and salt.
+func (p KDFParams) deriveKey(salt []byte, password string) (*crypto.Key, error) {
+	switch {
+	case p.KDF == KDFScrypt && p.Scrypt != nil:
+		k, err := crypto.KDF(*p.Scrypt, salt, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "crypto.KDF")
+		}
+		return k, nil
+	case p.KDF == KDFArgon2id && p.Argon2 != nil:
+		k, err := crypto.Argon2KDF(*p.Argon2, salt, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "crypto.Argon2KDF")
+		}
+		return k, nil
+	}
+
+	return nil, errors.Errorf("unsupported KDF %q", p.KDF)
+}
+
+// KDFParams returns the KDF and its parameters used to derive the user key.
+func (k *Key) KDFParams() KDFParams {
+	switch k.KDF {
+	case KDFScrypt:
+		return KDFParams{
+			KDF:    KDFScrypt,
+			Scrypt: &crypto.Params{N: k.N, R: k.R, P: k.P},
+		}
+	case KDFArgon2id:
+		return KDFParams{
+			KDF:    KDFArgon2id,
+			Argon2: &crypto.Argon2Params{Time: k.Time, Memory: k.Memory, Threads: k.Threads},
+		}
+	}
+	return KDFParams{KDF: k.KDF}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseKDFParams(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  KDFParams
+	}{
+		{"scrypt", KDFParams{KDF: KDFScrypt}},
+		{"scrypt:N=16384,r=8,p=2", KDFParams{KDF: KDFScrypt, Scrypt: &crypto.Params{N: 16384, R: 8, P: 2}}},
+		{"scrypt:N=65536", KDFParams{KDF: KDFScrypt, Scrypt: &crypto.Params{
+			N: 65536,
+			R: crypto.DefaultKDFParams.R,
+			P: crypto.DefaultKDFParams.P,
+		}}},
+		{"argon2id", KDFParams{KDF: KDFArgon2id, Argon2: &crypto.DefaultArgon2Params}},
+		{"argon2id:t=1,m=1024,p=2", KDFParams{KDF: KDFArgon2id, Argon2: &crypto.Argon2Params{Time: 1, Memory: 1024, Threads: 2}}},
+		{"scrypt:ms=500", KDFParams{KDF: KDFScrypt, TargetTime: 500 * time.Millisecond}},
+		{"argon2id:m=1024,p=1,ms=200", KDFParams{KDF: KDFArgon2id, Argon2: &crypto.Argon2Params{Time: 1, Memory: 1024, Threads: 1}, TargetTime: 200 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			p, err := ParseKDFParams(test.input)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.want, p)
+
+			if p.Scrypt != nil || p.Argon2 != nil {
+				// the string representation must be parsed to the same parameters
+				p2, err := ParseKDFParams(p.String())
+				rtest.OK(t, err)
+				rtest.Equals(t, p, p2)
+			}
+		})
+	}
+}
+
+func TestParseKDFParamsInvalid(t *testing.T) {
+	var tests = []string{
+		"",
+		"pbkdf2",
+		"scrypt:N=1000",
+		"scrypt:N",
+		"scrypt:x=1",
+		"scrypt:N=-1",
+		"argon2id:t=0",
+		"argon2id:p=256",
+		"argon2id:m=4,p=1",
+		"scrypt:N=16384,ms=500",
+		"argon2id:t=2,ms=500",
+	}
+
+	for _, input := range tests {
+		_, err := ParseKDFParams(input)
+		if err == nil {
+			t.Errorf("ParseKDFParams(%q) did not return an error", input)
+		}
+	}
+}
+
+func TestCalibrateKDFParams(t *testing.T) {
+	p, err := ParseKDFParams("argon2id:m=64,p=1,ms=50")
+	rtest.OK(t, err)
+
+	calibrated, err := p.Calibrate()
+	rtest.OK(t, err)
+	rtest.Equals(t, time.Duration(0), calibrated.TargetTime)
+	rtest.Assert(t, calibrated.Argon2.Time >= 1, "invalid number of passes %d", calibrated.Argon2.Time)
+	rtest.Equals(t, uint32(64), calibrated.Argon2.Memory)
+	rtest.Equals(t, uint8(1), calibrated.Argon2.Threads)
+}
@@ -22,14 +22,60 @@ var (
 
 	// ErrMaxKeysReached is returned when the maximum number of keys was checked and no key could be found.
 	ErrMaxKeysReached = errors.Fatal("maximum number of keys reached")
+
+	// ErrWrappedKey is returned when a password is used to open a key which is
+	// wrapped by an external key management service.
+	ErrWrappedKey = errors.New("key is wrapped by an external service")
+)
+
+// KDFWrapped is used instead of a KDF for keys which contain the master key
+// wrapped by an external key management service instead of encrypted with a
+// password.
+const KDFWrapped = "wrapped"
+
+// WrapFunc wraps or unwraps the master key, for example by sending it to a
+// key management service.
+type WrapFunc func(ctx context.Context, data []byte) ([]byte, error)
+
+// Key scopes restrict the operations which can be run with a key. The scope is
+// enforced by the client only, as all keys share the same master key. It is
+// also stored in the encrypted data of the key, so that it cannot be changed
+// without the password.
+const (
+	// ScopeAdmin allows all operations, it is the default for keys without a
+	// scope.
+	ScopeAdmin = "admin"
+
+	// ScopeBackup allows all operations which do not remove data, snapshots
+	// or keys from the repository.
+	ScopeBackup = "backup"
 )
 
+// KeyOptions contains the meta data for new keys.
+type KeyOptions struct {
+	// Expires is the time at which the new key expires, the key does not
+	// expire if it is zero.
+	Expires time.Time
+
+	// Scope restricts the operations allowed with the new key, an empty
+	// scope is the same as ScopeAdmin.
+	Scope string
+}
+
 // Key represents an encrypted master key for a repository.
 type Key struct {
 	Created  time.Time `json:"created"`
 	Username string    `json:"username"`
 	Hostname string    `json:"hostname"`
 
+	// Expires is the time after which the key must be replaced, nil if the
+	// key does not expire.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Scope restricts the operations allowed with the key, see ScopeAdmin
+	// and ScopeBackup.
+	Scope string `json:"scope,omitempty"`
+
 	KDF  string `json:"kdf"`
 	N    int    `json:"N"`
 	R    int    `json:"r"`
@@ -37,12 +83,55 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
+	// parameters for argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// description of the external key which wraps the master key
+	Wrapper string `json:"wrapper,omitempty"`
+
 	user   *crypto.Key
 	master *crypto.Key
 
 	name string
 }
 
+// keyData is the data of a key which is encrypted with the user key, or
+// wrapped with the external key. It contains the scope, so that changing the
+// scope in the unencrypted part of the key is detected.
+type keyData struct {
+	crypto.Key
+	Scope string `json:"scope,omitempty"`
+}
+
+// marshalData returns the data of k which is encrypted or wrapped.
+func (k *Key) marshalData() ([]byte, error) {
+	buf, err := json.Marshal(&keyData{Key: *k.master, Scope: k.Scope})
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	return buf, nil
+}
+
+// unmarshalData restores the master key from the decrypted or unwrapped data
+// and checks that the scope of k matches the scope in the data.
+func (k *Key) unmarshalData(buf []byte) error {
+	var data keyData
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		debug.Log("Unmarshal() returned error %v", err)
+		return errors.Wrap(err, "Unmarshal")
+	}
+
+	if data.Scope != k.Scope {
+		return errors.Errorf("scope %q of key does not match the encrypted scope %q, the key was modified", k.Scope, data.Scope)
+	}
+
+	k.master = &data.Key
+	return nil
+}
+
 // Params tracks the parameters used for the KDF. If not set, it will be
 // calibrated on the first run of AddKey().
 var Params *crypto.Params
@@ -69,20 +158,14 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 		return nil, err
 	}
 
-	// check KDF
-	if k.KDF != "scrypt" {
-		return nil, errors.New("only supported KDF is scrypt()")
+	if k.KDF == KDFWrapped {
+		return nil, ErrWrappedKey
 	}
 
 	// derive user key
-	params := crypto.Params{
-		N: k.N,
-		R: k.R,
-		P: k.P,
-	}
-	k.user, err = crypto.KDF(params, k.Salt, password)
+	k.user, err = k.KDFParams().deriveKey(k.Salt, password)
 	if err != nil {
-		return nil, errors.Wrap(err, "crypto.KDF")
+		return nil, err
 	}
 
 	// decrypt master keys
@@ -93,11 +176,9 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	}
 
 	// restore json
-	k.master = &crypto.Key{}
-	err = json.Unmarshal(buf, k.master)
+	err = k.unmarshalData(buf)
 	if err != nil {
-		debug.Log("Unmarshal() returned error %v", err)
-		return nil, errors.Wrap(err, "Unmarshal")
+		return nil, err
 	}
 	k.name = name
 
@@ -153,7 +234,7 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 			debug.Log("key %v returned error %v", fi.Name, err)
 
 			// ErrUnauthenticated means the password is wrong, try the next key
-			if errors.Cause(err) == crypto.ErrUnauthenticated {
+			if errors.Cause(err) == crypto.ErrUnauthenticated || errors.Cause(err) == ErrWrappedKey {
 				return nil
 			}
 
@@ -181,6 +262,102 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 	return k, nil
 }
 
+// OpenWrappedKey loads the key specified by name and unwraps the master key
+// with unwrap.
+func OpenWrappedKey(ctx context.Context, s *Repository, name string, unwrap WrapFunc) (*Key, error) {
+	k, err := LoadKey(ctx, s, name)
+	if err != nil {
+		debug.Log("LoadKey(%v) returned error %v", name, err)
+		return nil, err
+	}
+
+	if k.KDF != KDFWrapped {
+		return nil, errors.Errorf("key %v is not wrapped", name)
+	}
+
+	buf, err := unwrap(ctx, k.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "unwrap")
+	}
+
+	err = k.unmarshalData(buf)
+	if err != nil {
+		return nil, err
+	}
+	k.name = name
+
+	if !k.Valid() {
+		return nil, errors.New("Invalid key for repository")
+	}
+
+	return k, nil
+}
+
+// SearchWrappedKey tries to unwrap the wrapped keys in the backend with
+// unwrap, starting with the key matching keyHint. If none could be unwrapped,
+// ErrNoKeyFound is returned.
+func SearchWrappedKey(ctx context.Context, s *Repository, unwrap WrapFunc, keyHint string) (k *Key, err error) {
+	if len(keyHint) > 0 {
+		id, err := restic.Find(s.Backend(), restic.KeyFile, keyHint)
+		if err == nil {
+			key, err := OpenWrappedKey(ctx, s, id, unwrap)
+			if err == nil {
+				debug.Log("successfully opened hinted key %v", id)
+				return key, nil
+			}
+
+			debug.Log("could not open hinted key %v: %v", id, err)
+		} else {
+			debug.Log("Could not find hinted key %v", keyHint)
+		}
+	}
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err = s.Backend().List(listCtx, restic.KeyFile, func(fi restic.FileInfo) error {
+		if _, err := restic.ParseID(fi.Name); err != nil {
+			debug.Log("rejecting key with invalid name: %v", fi.Name)
+			return nil
+		}
+
+		candidate, err := LoadKey(ctx, s, fi.Name)
+		if err != nil {
+			return err
+		}
+		if candidate.KDF != KDFWrapped {
+			return nil
+		}
+
+		debug.Log("trying wrapped key %q", fi.Name)
+		key, err := OpenWrappedKey(ctx, s, fi.Name, unwrap)
+		if err != nil {
+			// the key may be wrapped by a different external key
+			debug.Log("key %v returned error %v", fi.Name, err)
+			return nil
+		}
+
+		debug.Log("successfully opened key %v", fi.Name)
+		k = key
+		cancel()
+		return nil
+	})
+
+	if err == context.Canceled {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if k == nil {
+		return nil, ErrNoKeyFound
+	}
+
+	return k, nil
+}
+
 // LoadKey loads a key from the backend.
 func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error) {
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
@@ -198,36 +375,60 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 	return k, nil
 }
 
-// AddKey adds a new key to an already existing repository.
+// AddKey adds a new key to an already existing repository. The user key is
+// derived with scrypt, using the parameters in Params.
 func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key) (*Key, error) {
-	// make sure we have valid KDF parameters
-	if Params == nil {
-		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+	return AddKeyWithParams(ctx, s, password, template, nil, KeyOptions{})
+}
+
+// AddKeyWithParams adds a new key to an already existing repository. The
+// user key is derived with the given KDF parameters, if kdf is nil scrypt
+// with the parameters in Params is used. Parameters with a target time are
+// calibrated first. The meta data of the new key is taken from opts.
+func AddKeyWithParams(ctx context.Context, s *Repository, password string, template *crypto.Key, kdf *KDFParams, opts KeyOptions) (*Key, error) {
+	if kdf != nil && kdf.TargetTime > 0 {
+		calibrated, err := kdf.Calibrate()
 		if err != nil {
-			return nil, errors.Wrap(err, "Calibrate")
+			return nil, err
 		}
-
-		Params = &p
-		debug.Log("calibrated KDF parameters are %v", p)
+		debug.Log("calibrated KDF parameters for %v are %v", kdf.TargetTime, calibrated)
+		kdf = &calibrated
 	}
 
-	// fill meta data about key
-	newkey := &Key{
-		Created: time.Now(),
-		KDF:     "scrypt",
-		N:       Params.N,
-		R:       Params.R,
-		P:       Params.P,
+	if kdf == nil || (kdf.KDF == KDFScrypt && kdf.Scrypt == nil) {
+		// make sure we have valid KDF parameters
+		if Params == nil {
+			p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+			if err != nil {
+				return nil, errors.Wrap(err, "Calibrate")
+			}
+
+			Params = &p
+			debug.Log("calibrated KDF parameters are %v", p)
+		}
+
+		kdf = &KDFParams{KDF: KDFScrypt, Scrypt: Params}
 	}
 
-	hn, err := os.Hostname()
-	if err == nil {
-		newkey.Hostname = hn
+	newkey, err := newKey(kdf.KDF, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	usr, err := user.Current()
-	if err == nil {
-		newkey.Username = usr.Username
+	switch kdf.KDF {
+	case KDFScrypt:
+		newkey.N = kdf.Scrypt.N
+		newkey.R = kdf.Scrypt.R
+		newkey.P = kdf.Scrypt.P
+	case KDFArgon2id:
+		if kdf.Argon2 == nil {
+			kdf.Argon2 = &crypto.DefaultArgon2Params
+		}
+		newkey.Time = kdf.Argon2.Time
+		newkey.Memory = kdf.Argon2.Memory
+		newkey.Threads = kdf.Argon2.Threads
+	default:
+		return nil, errors.Errorf("unsupported KDF %q", kdf.KDF)
 	}
 
 	// generate random salt
@@ -237,7 +438,7 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 	}
 
 	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
+	newkey.user, err = newkey.KDFParams().deriveKey(newkey.Salt, password)
 	if err != nil {
 		return nil, err
 	}
@@ -251,9 +452,9 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 	}
 
 	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
+	buf, err := newkey.marshalData()
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return nil, err
 	}
 
 	nonce := crypto.NewRandomNonce()
@@ -262,10 +463,81 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 	ciphertext = newkey.user.Seal(ciphertext, nonce, buf, nil)
 	newkey.Data = ciphertext
 
+	err = saveKey(ctx, s, newkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// AddWrappedKey adds a new key to an already existing repository, which
+// contains the master key template wrapped with wrap. The wrapper describes
+// the external key and is stored unencrypted. The meta data of the new key is
+// taken from opts.
+func AddWrappedKey(ctx context.Context, s *Repository, wrapper string, wrap WrapFunc, template *crypto.Key, opts KeyOptions) (*Key, error) {
+	newkey, err := newKey(KDFWrapped, opts)
+	if err != nil {
+		return nil, err
+	}
+	newkey.Wrapper = wrapper
+	newkey.master = template
+
+	buf, err := newkey.marshalData()
+	if err != nil {
+		return nil, err
+	}
+
+	newkey.Data, err = wrap(ctx, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "wrap")
+	}
+
+	err = saveKey(ctx, s, newkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// newKey returns a key for kdf with the meta data filled in.
+func newKey(kdf string, opts KeyOptions) (*Key, error) {
+	switch opts.Scope {
+	case "", ScopeAdmin, ScopeBackup:
+	default:
+		return nil, errors.Errorf("invalid key scope %q", opts.Scope)
+	}
+
+	k := &Key{
+		Created: time.Now(),
+		KDF:     kdf,
+		Scope:   opts.Scope,
+	}
+
+	if !opts.Expires.IsZero() {
+		k.Expires = &opts.Expires
+	}
+
+	hn, err := os.Hostname()
+	if err == nil {
+		k.Hostname = hn
+	}
+
+	usr, err := user.Current()
+	if err == nil {
+		k.Username = usr.Username
+	}
+
+	return k, nil
+}
+
+// saveKey stores k in the repository and sets its name.
+func saveKey(ctx context.Context, s *Repository, k *Key) error {
 	// dump as json
-	buf, err = json.Marshal(newkey)
+	buf, err := json.Marshal(k)
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return errors.Wrap(err, "Marshal")
 	}
 
 	// store in repository and return
@@ -276,12 +548,11 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 
 	err = s.be.Save(ctx, h, restic.NewByteReader(buf))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	newkey.name = h.Name
-
-	return newkey, nil
+	k.name = h.Name
+	return nil
 }
 
 func (k *Key) String() string {
@@ -296,7 +567,33 @@ func (k Key) Name() string {
 	return k.name
 }
 
+// ExpiresAt returns the time the key expires, which is either its expiry time
+// or when it becomes older than maxAge. If the key does not expire, ok is
+// false.
+func (k *Key) ExpiresAt(maxAge restic.Duration) (t time.Time, ok bool) {
+	if k.Expires != nil {
+		t, ok = *k.Expires, true
+	}
+
+	if !maxAge.Zero() {
+		aged := k.Created.AddDate(maxAge.Years, maxAge.Months, maxAge.Days).Add(time.Duration(maxAge.Hours) * time.Hour)
+		if !ok || aged.Before(t) {
+			t, ok = aged, true
+		}
+	}
+
+	return t, ok
+}
+
+// IsAdmin returns true if all operations are allowed with the key.
+func (k *Key) IsAdmin() bool {
+	return k.Scope == "" || k.Scope == ScopeAdmin
+}
+
 // Valid tests whether the mac and encryption keys are valid (i.e. not zero)
 func (k *Key) Valid() bool {
+	if k.KDF == KDFWrapped {
+		return k.master.Valid()
+	}
 	return k.user.Valid() && k.master.Valid()
 }
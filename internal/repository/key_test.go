@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// xorWrap is a reversible stand-in for a key management service.
+func xorWrap(ctx context.Context, data []byte) ([]byte, error) {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[i] = b ^ 0x5a
+	}
+	return res, nil
+}
+
+func TestWrappedKey(t *testing.T) {
+	r, cleanup := TestRepository(t)
+	defer cleanup()
+	repo := r.(*Repository)
+	ctx := context.TODO()
+
+	key, err := AddWrappedKey(ctx, repo, "test", xorWrap, repo.Key(), KeyOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, KDFWrapped, key.KDF)
+	rtest.Equals(t, "test", key.Wrapper)
+
+	wrapped := New(repo.Backend())
+	rtest.OK(t, wrapped.SearchWrappedKey(ctx, xorWrap, ""))
+	rtest.Equals(t, key.Name(), wrapped.KeyName())
+	rtest.Equals(t, repo.Key(), wrapped.Key())
+	rtest.Equals(t, repo.Config(), wrapped.Config())
+
+	// the password key must still be found next to the wrapped key
+	password := New(repo.Backend())
+	rtest.OK(t, password.SearchKey(ctx, rtest.TestPassword, 10, ""))
+	rtest.Equals(t, repo.KeyName(), password.KeyName())
+
+	fail := func(ctx context.Context, data []byte) ([]byte, error) {
+		return nil, errors.New("access denied")
+	}
+	err = New(repo.Backend()).SearchWrappedKey(ctx, fail, "")
+	rtest.Assert(t, err == ErrNoKeyFound, "expected ErrNoKeyFound, got %v", err)
+
+	_, err = OpenKey(ctx, repo, key.Name(), rtest.TestPassword)
+	rtest.Assert(t, err == ErrWrappedKey, "expected ErrWrappedKey, got %v", err)
+}
+
+func TestKeyExpiresAt(t *testing.T) {
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	expires := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		expires *time.Time
+		maxAge  restic.Duration
+		want    time.Time
+		ok      bool
+	}{
+		{nil, restic.Duration{}, time.Time{}, false},
+		{&expires, restic.Duration{}, expires, true},
+		{nil, restic.Duration{Days: 30}, time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC), true},
+		{&expires, restic.Duration{Months: 1}, time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC), true},
+		{&expires, restic.Duration{Years: 1}, expires, true},
+	}
+
+	for _, test := range tests {
+		k := &Key{Created: created, Expires: test.expires}
+		got, ok := k.ExpiresAt(test.maxAge)
+		rtest.Equals(t, test.ok, ok)
+		rtest.Equals(t, test.want, got)
+	}
+}
+
+func TestKeyScope(t *testing.T) {
+	r, cleanup := TestRepository(t)
+	defer cleanup()
+	repo := r.(*Repository)
+	ctx := context.TODO()
+
+	kdf := &KDFParams{KDF: KDFScrypt, Scrypt: &crypto.Params{N: 1024, R: 1, P: 1}}
+	key, err := AddKeyWithParams(ctx, repo, "secret", repo.Key(), kdf, KeyOptions{Scope: ScopeBackup})
+	rtest.OK(t, err)
+
+	loaded, err := LoadKey(ctx, repo, key.Name())
+	rtest.OK(t, err)
+	rtest.Equals(t, ScopeBackup, loaded.Scope)
+	rtest.Assert(t, !loaded.IsAdmin(), "key with scope %q is an admin key", loaded.Scope)
+
+	_, err = AddKeyWithParams(ctx, repo, "secret", repo.Key(), kdf, KeyOptions{Scope: "invalid"})
+	rtest.Assert(t, err != nil, "adding a key with an invalid scope did not fail")
+
+	opened, err := OpenKey(ctx, repo, key.Name(), "secret")
+	rtest.OK(t, err)
+	rtest.Equals(t, ScopeBackup, opened.Scope)
+
+	// the scope is contained in the encrypted data, so removing it from the
+	// key file is detected
+	loaded.Scope = ""
+	rtest.OK(t, saveKey(ctx, repo, loaded))
+	_, err = OpenKey(ctx, repo, loaded.Name(), "secret")
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "modified"), "modified scope was not detected: %v", err)
+}
+
+func TestWrappedKeyScope(t *testing.T) {
+	r, cleanup := TestRepository(t)
+	defer cleanup()
+	repo := r.(*Repository)
+	ctx := context.TODO()
+
+	key, err := AddWrappedKey(ctx, repo, "test", xorWrap, repo.Key(), KeyOptions{Scope: ScopeBackup})
+	rtest.OK(t, err)
+
+	opened, err := OpenWrappedKey(ctx, repo, key.Name(), xorWrap)
+	rtest.OK(t, err)
+	rtest.Equals(t, ScopeBackup, opened.Scope)
+
+	loaded, err := LoadKey(ctx, repo, key.Name())
+	rtest.OK(t, err)
+	loaded.Scope = ScopeAdmin
+	rtest.OK(t, saveKey(ctx, repo, loaded))
+	_, err = OpenWrappedKey(ctx, repo, loaded.Name(), xorWrap)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "modified"), "modified scope was not detected: %v", err)
+}
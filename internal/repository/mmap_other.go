@@ -0,0 +1,16 @@
+// +build !linux,!darwin,!freebsd
+
+package repository
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+const mmapSupported = false
+
+// mmapFile is not supported on this platform.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory mapped files are not supported on this platform")
+}
@@ -0,0 +1,21 @@
+// +build linux darwin freebsd
+
+package repository
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+const mmapSupported = true
+
+// mmapFile maps the first size bytes of f read-only into memory.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "Mmap")
+	}
+	return data, nil
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// UploadOptions configures the upload of pack files.
+type UploadOptions struct {
+	MaxInflight uint `option:"max-inflight" help:"set the maximum number of pack files which are uploaded concurrently (default: 4)"`
+}
+
+// DefaultUploadOptions are the upload options used unless configured
+// otherwise.
+var DefaultUploadOptions = UploadOptions{
+	MaxInflight: 4,
+}
+
+func init() {
+	options.Register("upload", UploadOptions{})
+}
+
+// packUploader uploads finished packs in the background, so that saving
+// blobs does not wait for the backend. The number of packs which are uploaded
+// concurrently is limited, once the limit is reached new uploads block until
+// a running upload is complete. Each pack is stored in a temporary file until
+// it is uploaded, which bounds the disk space and memory used by pending
+// uploads.
+type packUploader struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	m   sync.Mutex
+	err error
+}
+
+func newPackUploader(opts UploadOptions) *packUploader {
+	return &packUploader{
+		sem: make(chan struct{}, opts.MaxInflight),
+	}
+}
+
+// Err returns the error of the first failed upload.
+func (u *packUploader) Err() error {
+	u.m.Lock()
+	defer u.m.Unlock()
+	return u.err
+}
+
+// upload runs fn in the background as soon as fewer than the maximum number
+// of uploads are running. If a previous upload failed, its error is returned
+// and fn is not run.
+func (u *packUploader) upload(ctx context.Context, fn func() error) error {
+	select {
+	case u.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := u.Err(); err != nil {
+		<-u.sem
+		return err
+	}
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		defer func() { <-u.sem }()
+
+		err := fn()
+		if err != nil {
+			debug.Log("upload failed: %v", err)
+			u.m.Lock()
+			if u.err == nil {
+				u.err = err
+			}
+			u.m.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// wait blocks until all running uploads are complete. It returns the error
+// of the first failed upload.
+func (u *packUploader) wait() error {
+	u.wg.Wait()
+	return u.Err()
+}
+
+// SetUploadOptions configures the upload of pack files. It must be called
+// before any blobs are saved.
+func (r *Repository) SetUploadOptions(opts UploadOptions) error {
+	if opts.MaxInflight == 0 {
+		return errors.Fatal("upload.max-inflight must be at least 1")
+	}
+
+	debug.Log("using upload options %#v", opts)
+	r.uploader = newPackUploader(opts)
+	return nil
+}
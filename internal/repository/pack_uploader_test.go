@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestPackUploaderLimit(t *testing.T) {
+	u := newPackUploader(UploadOptions{MaxInflight: 3})
+
+	var running, maxRunning int32
+	for i := 0; i < 20; i++ {
+		err := u.upload(context.TODO(), func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+		rtest.OK(t, err)
+	}
+
+	rtest.OK(t, u.wait())
+	rtest.Assert(t, maxRunning <= 3, "too many concurrent uploads: %d", maxRunning)
+	rtest.Equals(t, int32(0), running)
+}
+
+func TestPackUploaderError(t *testing.T) {
+	u := newPackUploader(UploadOptions{MaxInflight: 1})
+	testErr := errors.New("test error")
+
+	rtest.OK(t, u.upload(context.TODO(), func() error {
+		return testErr
+	}))
+
+	// the error is returned by the next upload once the failed one is done
+	called := false
+	err := u.upload(context.TODO(), func() error {
+		called = true
+		return nil
+	})
+	rtest.Equals(t, testErr, err)
+	rtest.Equals(t, testErr, u.wait())
+	rtest.Assert(t, !called, "upload was run after an error")
+}
+
+func TestPackUploaderCancel(t *testing.T) {
+	u := newPackUploader(UploadOptions{MaxInflight: 1})
+
+	block := make(chan struct{})
+	rtest.OK(t, u.upload(context.TODO(), func() error {
+		<-block
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err := u.upload(ctx, func() error { return nil })
+	rtest.Equals(t, context.Canceled, err)
+
+	close(block)
+	rtest.OK(t, u.wait())
+}
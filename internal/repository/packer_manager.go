@@ -38,6 +38,10 @@ type packerManager struct {
 
 const minPackSize = 4 * 1024 * 1024
 
+// MinPackSize is the minimal size of pack files written by the repository.
+// Only the last pack file written before a flush may be smaller.
+const MinPackSize = minPackSize
+
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
 func newPackerManager(be Saver, key *crypto.Key) *packerManager {
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// RepairPacks salvages the blobs stored in the damaged packs. Each pack is
+// downloaded completely and every blob which can still be decrypted and
+// matches its ID is saved into a new pack. The blobs are located using the
+// pack header and the index, so a damaged header does not prevent salvaging
+// the blobs known to the index. Blobs which are also stored in other packs
+// are skipped. Returned are the blobs which could not be salvaged. The
+// damaged packs are not removed.
+func RepairPacks(ctx context.Context, repo restic.Repository, packs restic.IDSet, p *restic.Progress) (lost restic.BlobSet, err error) {
+	debug.Log("repairing %d packs", len(packs))
+
+	// collect the blobs the index lists for the damaged packs
+	indexed := make(map[restic.ID][]restic.Blob)
+	for blob := range repo.Index().Each(ctx) {
+		if packs.Has(blob.PackID) {
+			indexed[blob.PackID] = append(indexed[blob.PackID], blob.Blob)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// storedElsewhere returns true if the blob is also contained in an intact
+	// pack.
+	storedElsewhere := func(h restic.BlobHandle) bool {
+		blobs, _ := repo.Index().Lookup(h.ID, h.Type)
+		for _, blob := range blobs {
+			if !packs.Has(blob.PackID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	lost = restic.NewBlobSet()
+	saved := restic.NewBlobSet()
+
+	for packID := range packs {
+		h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+
+		tempfile, _, packLength, err := DownloadAndHash(ctx, repo.Backend(), h)
+		if err != nil {
+			return nil, errors.Wrap(err, "RepairPacks")
+		}
+
+		blobs, err := pack.List(repo.Key(), tempfile, packLength)
+		if err != nil {
+			debug.Log("unable to read header of pack %v: %v", packID, err)
+			blobs = nil
+		}
+		blobs = append(blobs, indexed[packID]...)
+
+		var buf []byte
+		for _, entry := range blobs {
+			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
+			if saved.Has(h) || storedElsewhere(h) {
+				continue
+			}
+
+			if uint(cap(buf)) < entry.Length {
+				buf = make([]byte, entry.Length)
+			}
+			buf = buf[:entry.Length]
+
+			plaintext, err := func() ([]byte, error) {
+				if entry.Length < uint(repo.Key().NonceSize()) {
+					return nil, errors.New("blob too short")
+				}
+
+				n, err := tempfile.ReadAt(buf, int64(entry.Offset))
+				if err != nil {
+					return nil, errors.Wrap(err, "ReadAt")
+				}
+				if n != len(buf) {
+					return nil, errors.New("not enough bytes read")
+				}
+
+				nonce, ciphertext := buf[:repo.Key().NonceSize()], buf[repo.Key().NonceSize():]
+				plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+				if err != nil {
+					return nil, err
+				}
+
+				if !restic.Hash(plaintext).Equal(entry.ID) {
+					return nil, errors.New("hash does not match")
+				}
+				return plaintext, nil
+			}()
+			if err != nil {
+				debug.Log("unable to salvage blob %v from pack %v: %v", h, packID, err)
+				lost.Insert(h)
+				continue
+			}
+
+			_, err = repo.SaveBlob(ctx, entry.Type, plaintext, entry.ID)
+			if err != nil {
+				_ = tempfile.Close()
+				_ = fs.RemoveIfExists(tempfile.Name())
+				return nil, err
+			}
+
+			debug.Log("  salvaged blob %v", h)
+			saved.Insert(h)
+			lost.Delete(h)
+		}
+
+		if err = tempfile.Close(); err != nil {
+			return nil, errors.Wrap(err, "Close")
+		}
+
+		if err = fs.RemoveIfExists(tempfile.Name()); err != nil {
+			return nil, errors.Wrap(err, "Remove")
+		}
+
+		if p != nil {
+			p.Report(restic.Stat{Blobs: 1})
+		}
+	}
+
+	if err := repo.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	return lost, nil
+}
@@ -0,0 +1,70 @@
+package repository_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRepairPacks(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	createRandomBlobs(t, repo, 20, 0.7)
+	saveIndex(t, repo)
+
+	var blobs []restic.PackedBlob
+	for pb := range repo.Index().Each(context.TODO()) {
+		blobs = append(blobs, pb)
+	}
+
+	// damage the first blob in one of the packs
+	var damaged restic.PackedBlob
+	for _, pb := range blobs {
+		if pb.Offset == 0 {
+			damaged = pb
+			break
+		}
+	}
+
+	h := restic.Handle{Type: restic.DataFile, Name: damaged.PackID.String()}
+	var buf []byte
+	err := repo.Backend().Load(context.TODO(), h, 0, 0, func(rd io.Reader) (ierr error) {
+		buf, ierr = ioutil.ReadAll(rd)
+		return ierr
+	})
+	rtest.OK(t, err)
+
+	buf[damaged.Length/2] ^= 0xff
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), h))
+	rtest.OK(t, repo.Backend().Save(context.TODO(), h, restic.NewByteReader(buf)))
+
+	lost, err := repository.RepairPacks(context.TODO(), repo, restic.NewIDSet(damaged.PackID), nil)
+	rtest.OK(t, err)
+
+	damagedHandle := restic.BlobHandle{ID: damaged.ID, Type: damaged.Type}
+	rtest.Equals(t, restic.NewBlobSet(damagedHandle), lost)
+
+	// all other blobs of the pack must have been saved to new packs
+	for _, pb := range blobs {
+		if pb.PackID != damaged.PackID || pb.ID == damaged.ID {
+			continue
+		}
+
+		list, found := repo.Index().Lookup(pb.ID, pb.Type)
+		rtest.Assert(t, found, "blob %v not found", pb.ID.Str())
+
+		salvaged := false
+		for _, other := range list {
+			if other.PackID != damaged.PackID {
+				salvaged = true
+			}
+		}
+		rtest.Assert(t, salvaged, "blob %v was not saved to a new pack", pb.ID.Str())
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
@@ -31,15 +33,22 @@ type Repository struct {
 
 	treePM *packerManager
 	dataPM *packerManager
+
+	uploader *packUploader
+
+	// indexDir is the directory for memory mapped indexes, empty if the
+	// index is kept in memory
+	indexDir string
 }
 
 // New returns a new repository with backend be.
 func New(be restic.Backend) *Repository {
 	repo := &Repository{
-		be:     be,
-		idx:    NewMasterIndex(),
-		dataPM: newPackerManager(be, nil),
-		treePM: newPackerManager(be, nil),
+		be:       be,
+		idx:      NewMasterIndex(),
+		dataPM:   newPackerManager(be, nil),
+		treePM:   newPackerManager(be, nil),
+		uploader: newPackUploader(DefaultUploadOptions),
 	}
 
 	return repo
@@ -60,6 +69,30 @@ func (r *Repository) UseCache(c restic.Cache) {
 	r.be = c.Wrap(r.be)
 }
 
+// UseIndexOnDisk moves the entries of all indexes loaded by LoadIndex to memory
+// mapped files in dir. This allows using repositories whose index does not fit
+// into memory, at the cost of slower lookups.
+func (r *Repository) UseIndexOnDisk(dir string) error {
+	if !mmapSupported {
+		return errors.Fatal("keeping the index on disk is not supported on this platform")
+	}
+	debug.Log("keeping index in %v", dir)
+	r.indexDir = dir
+	return nil
+}
+
+// UseQuota wraps the backend so that saving files fails once the size of the
+// repository would exceed quota bytes. used is the current size of the
+// repository.
+func (r *Repository) UseQuota(quota, used uint64) *backend.QuotaBackend {
+	debug.Log("using quota %d, %d bytes used", quota, used)
+	be := backend.NewQuotaBackend(r.be, quota, used)
+	r.be = be
+	r.dataPM.be = be
+	r.treePM.be = be
+	return be
+}
+
 // PrefixLength returns the number of bytes required so that all prefixes of
 // all IDs of type t are unique.
 func (r *Repository) PrefixLength(t restic.FileType) (int, error) {
@@ -139,7 +172,7 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 	blobs, found := r.idx.Lookup(id, t)
 	if !found {
 		debug.Log("id %v not found in index", id)
-		return nil, errors.Errorf("id %v not found in repository", id)
+		return nil, errors.WithCode(errors.CodeBlobMissing, errors.Errorf("id %v not found in repository", id))
 	}
 
 	// try cached pack files first
@@ -268,8 +301,10 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 		return *id, nil
 	}
 
-	// else write the pack to the backend
-	return *id, r.savePacker(ctx, t, packer)
+	// else upload the pack to the backend in the background
+	return *id, r.uploader.upload(ctx, func() error {
+		return r.savePacker(ctx, t, packer)
+	})
 }
 
 // SaveJSONUnpacked serialises item as JSON and encrypts and saves it in the
@@ -284,15 +319,21 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 	return r.SaveUnpacked(ctx, t, plaintext)
 }
 
-// SaveUnpacked encrypts data and stores it in the backend. Returned is the
-// storage hash.
-func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
+// sealUnpacked encrypts p with a new random nonce, which is prepended to the
+// ciphertext.
+func (r *Repository) sealUnpacked(p []byte) []byte {
 	ciphertext := restic.NewBlobBuffer(len(p))
 	ciphertext = ciphertext[:0]
 	nonce := crypto.NewRandomNonce()
 	ciphertext = append(ciphertext, nonce...)
 
-	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
+	return r.key.Seal(ciphertext, nonce, p, nil)
+}
+
+// SaveUnpacked encrypts data and stores it in the backend. Returned is the
+// storage hash.
+func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
+	ciphertext := r.sealUnpacked(p)
 
 	id = restic.Hash(ciphertext)
 	h := restic.Handle{Type: t, Name: id.String()}
@@ -307,7 +348,7 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	return id, nil
 }
 
-// Flush saves all remaining packs.
+// Flush saves all remaining packs and waits until all packs are uploaded.
 func (r *Repository) Flush(ctx context.Context) error {
 	pms := []struct {
 		t  restic.BlobType
@@ -322,7 +363,10 @@ func (r *Repository) Flush(ctx context.Context) error {
 
 		debug.Log("manually flushing %d packs", len(p.pm.packers))
 		for _, packer := range p.pm.packers {
-			err := r.savePacker(ctx, p.t, packer)
+			t, packer := p.t, packer
+			err := r.uploader.upload(ctx, func() error {
+				return r.savePacker(ctx, t, packer)
+			})
 			if err != nil {
 				p.pm.pm.Unlock()
 				return err
@@ -332,7 +376,7 @@ func (r *Repository) Flush(ctx context.Context) error {
 		p.pm.pm.Unlock()
 	}
 
-	return nil
+	return r.uploader.wait()
 }
 
 // Backend returns the backend for the repository.
@@ -400,7 +444,20 @@ func (r *Repository) SaveFullIndex(ctx context.Context) error {
 	return r.saveIndex(ctx, r.idx.FullIndexes()...)
 }
 
-const loadIndexParallelism = 4
+// loadIndexParallelism returns the number of index files which are loaded and
+// decoded concurrently. Decoding is CPU bound, so more workers are used on
+// machines with many cores. The number of concurrent downloads is further
+// limited by the backend connections.
+func loadIndexParallelism() int {
+	n := runtime.GOMAXPROCS(0)
+	if n < 4 {
+		n = 4
+	}
+	if n > 32 {
+		n = 32
+	}
+	return n
+}
 
 // LoadIndex loads all index files from the backend in parallel and stores them
 // in the master index. The first error that occurred is returned.
@@ -446,6 +503,12 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 				return errors.Wrap(err, fmt.Sprintf("unable to load index %v", fi.ID.Str()))
 			}
 
+			if r.indexDir != "" {
+				if err = idx.moveToFile(r.indexDir); err != nil {
+					return errors.Wrap(err, fmt.Sprintf("unable to move index %v to disk", fi.ID.Str()))
+				}
+			}
+
 			select {
 			case indexCh <- idx:
 			case <-ctx.Done():
@@ -462,7 +525,7 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 
 	// run workers on ch
 	wg.Go(func() error {
-		return RunWorkers(ctx, loadIndexParallelism, worker, final)
+		return RunWorkers(ctx, loadIndexParallelism(), worker, final)
 	})
 
 	// receive decoded indexes
@@ -520,6 +583,14 @@ func (r *Repository) PrepareCache(indexIDs restic.IDSet) error {
 		fmt.Fprintf(os.Stderr, "error clearing data files in cache: %v\n", err)
 	}
 
+	cache := r.Cache.(*cache.Cache)
+
+	// clear headers of removed pack files
+	err = cache.ClearPackHeaders(packs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error clearing pack headers in cache: %v\n", err)
+	}
+
 	treePacks := restic.NewIDSet()
 	for _, idx := range r.idx.All() {
 		for _, id := range idx.TreePacks() {
@@ -529,7 +600,6 @@ func (r *Repository) PrepareCache(indexIDs restic.IDSet) error {
 
 	// use readahead
 	debug.Log("using readahead")
-	cache := r.Cache.(*cache.Cache)
 	cache.PerformReadahead = func(h restic.Handle) bool {
 		if h.Type != restic.DataFile {
 			debug.Log("no readahead for %v, is not data file", h)
@@ -577,6 +647,22 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	return r.useKey(ctx, key)
+}
+
+// SearchWrappedKey finds a wrapped key which can be unwrapped with unwrap,
+// afterwards the config is read and parsed.
+func (r *Repository) SearchWrappedKey(ctx context.Context, unwrap WrapFunc, keyHint string) error {
+	key, err := SearchWrappedKey(ctx, r, unwrap, keyHint)
+	if err != nil {
+		return err
+	}
+
+	return r.useKey(ctx, key)
+}
+
+// useKey sets the master key of the repository and loads the config.
+func (r *Repository) useKey(ctx context.Context, key *Key) (err error) {
 	r.key = key.master
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
@@ -607,6 +693,45 @@ func (r *Repository) Init(ctx context.Context, password string) error {
 	return r.init(ctx, password, cfg)
 }
 
+// InitWithConfig creates a new master key with the supplied password and
+// initializes the repository with the given config, which must be created
+// with restic.CreateConfig.
+func (r *Repository) InitWithConfig(ctx context.Context, password string, cfg restic.Config) error {
+	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return err
+	}
+	if has {
+		return errors.New("repository master key and config already initialized")
+	}
+
+	return r.init(ctx, password, cfg)
+}
+
+// SaveConfig replaces the config of an already initialized repository. The
+// config is replaced atomically, so that the repository always has a valid
+// config. Backends which cannot replace files atomically are refused, as
+// removing the old config first would leave an unusable repository behind if
+// saving the new one fails.
+func (r *Repository) SaveConfig(ctx context.Context, cfg restic.Config) error {
+	plaintext, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	err = restic.Replace(ctx, r.be, h, restic.NewByteReader(r.sealUnpacked(plaintext)))
+	if err == restic.ErrReplaceUnsupported {
+		return errors.Fatalf("the config cannot be changed for the repository at %v, the backend cannot replace it atomically", r.be.Location())
+	}
+	if err != nil {
+		return err
+	}
+
+	r.cfg = cfg
+	return nil
+}
+
 // init creates a new master key with the supplied password and uses it to save
 // the config into the repo.
 func (r *Repository) init(ctx context.Context, password string, cfg restic.Config) error {
@@ -649,13 +774,37 @@ func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic
 // ListPack returns the list of blobs saved in the pack id and the length of
 // the file as stored in the backend.
 func (r *Repository) ListPack(ctx context.Context, id restic.ID, size int64) ([]restic.Blob, int64, error) {
+	// the header of a pack file never changes, so it can be cached
+	c, _ := r.Cache.(*cache.Cache)
+	if c != nil {
+		if header, ok := c.LoadPackHeader(id, size); ok {
+			blobs, err := pack.ParseHeader(r.Key(), header)
+			if err == nil {
+				return blobs, size, nil
+			}
+
+			debug.Log("unable to parse cached header of pack %v: %v", id.Str(), err)
+			_ = c.RemovePackHeader(id)
+		}
+	}
+
 	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	header, err := pack.ReadHeader(restic.ReaderAt(r.Backend(), h), size)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	blobs, err := pack.List(r.Key(), restic.ReaderAt(r.Backend(), h), size)
+	blobs, err := pack.ParseHeader(r.Key(), header)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	if c != nil {
+		if err := c.SavePackHeader(id, size, header); err != nil {
+			debug.Log("unable to cache header of pack %v: %v", id.Str(), err)
+		}
+	}
+
 	return blobs, size, nil
 }
 
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
@@ -482,3 +483,36 @@ func TestDownloadAndHashErrors(t *testing.T) {
 		})
 	}
 }
+
+// noReplaceBackend hides the Replace method of the wrapped backend.
+type noReplaceBackend struct {
+	restic.Backend
+}
+
+func TestSaveConfig(t *testing.T) {
+	be := mem.New()
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+
+	cfg := repo.Config()
+	cfg.MaxRepoSize = 1 << 30
+	rtest.OK(t, repo.(*repository.Repository).SaveConfig(context.TODO(), cfg))
+
+	loaded, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, cfg, loaded)
+
+	// backends which cannot replace the config atomically are refused and
+	// the config is left untouched
+	repo2 := repository.New(noReplaceBackend{be})
+	rtest.OK(t, repo2.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+
+	cfg2 := repo2.Config()
+	cfg2.MaxRepoSize = 0
+	err = repo2.SaveConfig(context.TODO(), cfg2)
+	rtest.Assert(t, err != nil, "SaveConfig did not fail for a backend without Replace")
+
+	loaded, err = restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, cfg, loaded)
+}
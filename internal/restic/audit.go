@@ -0,0 +1,96 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// AuditEntry records a destructive operation on the repository, for example
+// removing snapshots or keys. Audit entries are stored encrypted and
+// authenticated like snapshots, so they can only be created and read with a
+// key for the repository.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	Username string    `json:"username,omitempty"`
+	KeyID    string    `json:"key_id,omitempty"`
+	Command  string    `json:"command"`
+	Details  []string  `json:"details,omitempty"`
+
+	// Snapshots lists the snapshots which were removed or replaced.
+	Snapshots IDs `json:"snapshots,omitempty"`
+
+	// Approval is the ID of the approval token which was used, each token is
+	// only accepted once.
+	Approval string `json:"approval,omitempty"`
+
+	// Signature is created by an external signing service for the data
+	// returned by SignedData.
+	Signature []byte `json:"signature,omitempty"`
+
+	id *ID
+}
+
+// NewAuditEntry returns an audit entry for the command run with the key
+// keyID by the current user on this host.
+func NewAuditEntry(keyID, command string, details []string) *AuditEntry {
+	e := &AuditEntry{
+		Time:    time.Now(),
+		KeyID:   keyID,
+		Command: command,
+		Details: details,
+	}
+
+	if hn, err := os.Hostname(); err == nil {
+		e.Hostname = hn
+	}
+
+	if usr, err := user.Current(); err == nil {
+		e.Username = usr.Username
+	}
+
+	return e
+}
+
+// SaveAuditEntry stores the audit entry in the repository.
+func SaveAuditEntry(ctx context.Context, repo Repository, e *AuditEntry) error {
+	id, err := repo.SaveJSONUnpacked(ctx, AuditFile, e)
+	if err != nil {
+		return err
+	}
+
+	e.id = &id
+	return nil
+}
+
+// LoadAuditEntry loads the audit entry with the id from the repository.
+func LoadAuditEntry(ctx context.Context, repo Repository, id ID) (*AuditEntry, error) {
+	e := &AuditEntry{id: &id}
+	err := repo.LoadJSONUnpacked(ctx, AuditFile, id, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// SignedData returns the data of the audit entry which is covered by its
+// signature, which is the entry without the signature itself.
+func (e AuditEntry) SignedData() ([]byte, error) {
+	e.Signature = nil
+	return json.Marshal(e)
+}
+
+// ID returns the ID of the audit entry.
+func (e AuditEntry) ID() *ID {
+	return e.id
+}
+
+func (e AuditEntry) String() string {
+	return fmt.Sprintf("<AuditEntry %s of %v at %s by %s@%s>",
+		e.id.Str(), e.Command, e.Time, e.Username, e.Hostname)
+}
@@ -3,6 +3,8 @@ package restic
 import (
 	"context"
 	"io"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 // Backend is used to store and access data.
@@ -59,3 +61,24 @@ type FileInfo struct {
 	Size int64
 	Name string
 }
+
+// Replacer is implemented by backends which can replace an existing file
+// atomically, so that the file is never missing and has either the old or the
+// new content.
+type Replacer interface {
+	Replace(ctx context.Context, h Handle, rd RewindReader) error
+}
+
+// ErrReplaceUnsupported is returned by Replace if the backend cannot replace
+// files atomically.
+var ErrReplaceUnsupported = errors.New("the backend cannot replace files atomically")
+
+// Replace replaces the file h in be atomically with the data from rd. If be
+// does not implement Replacer, ErrReplaceUnsupported is returned.
+func Replace(ctx context.Context, be Backend, h Handle, rd RewindReader) error {
+	r, ok := be.(Replacer)
+	if !ok {
+		return ErrReplaceUnsupported
+	}
+	return r.Replace(ctx, h, rd)
+}
@@ -16,6 +16,27 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// MaxRepoSize is the maximum size of the repository in bytes, which is
+	// enforced during backup. Zero means no limit.
+	MaxRepoSize uint64 `json:"max_repo_size,omitempty"`
+
+	// MaxKeyAge is the maximum age of a key as a duration like "1y" after
+	// which it must be replaced. An empty string means keys never expire.
+	MaxKeyAge string `json:"max_key_age,omitempty"`
+
+	// RefuseExpiredKeys causes commands to refuse opening the repository with
+	// an expired key instead of printing a warning.
+	RefuseExpiredKeys bool `json:"refuse_expired_keys,omitempty"`
+
+	// RequireApproval causes destructive commands to require an approval
+	// token created with a second key.
+	RequireApproval bool `json:"require_approval,omitempty"`
+
+	// KDFParams are the parameters of the key derivation function used for
+	// new keys if none are given explicitly, in the format accepted by
+	// repository.ParseKDFParams. An empty string selects the defaults.
+	KDFParams string `json:"kdf_params,omitempty"`
 }
 
 // RepoVersion is the version that is written to the config when a repository
@@ -17,6 +17,7 @@ const (
 	SnapshotFile          = "snapshot"
 	IndexFile             = "index"
 	ConfigFile            = "config"
+	AuditFile             = "audit"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +47,7 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case AuditFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
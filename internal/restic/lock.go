@@ -136,7 +136,7 @@ func (l *Lock) fillUserInfo() error {
 // If an exclusive lock is to be created, checkForOtherLocks returns an error
 // if there are any other locks, regardless if exclusive or not. If a
 // non-exclusive lock is to be created, an error is only returned when an
-// exclusive lock is found.
+// exclusive lock is found. Stale locks are ignored and removed.
 func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 	return l.repo.List(ctx, LockFile, func(id ID, size int64) error {
 		if l.lockID != nil && id.Equal(*l.lockID) {
@@ -150,6 +150,17 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 			return nil
 		}
 
+		if lock.Stale() {
+			// the process which created the lock has terminated or has
+			// not refreshed the lock for a long time
+			debug.Log("remove stale lock %v", id)
+			err = l.repo.Backend().Remove(ctx, Handle{Type: LockFile, Name: id.String()})
+			if err != nil {
+				debug.Log("unable to remove stale lock %v: %v", id, err)
+			}
+			return nil
+		}
+
 		if l.Exclusive {
 			return ErrAlreadyLocked{otherLock: lock}
 		}
@@ -181,14 +192,16 @@ func (l *Lock) Unlock() error {
 	return l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
 }
 
-var staleTimeout = 30 * time.Minute
+// StaleLockTimeout is the age after which a lock which has not been refreshed
+// is considered stale.
+var StaleLockTimeout = 30 * time.Minute
 
 // Stale returns true if the lock is stale. A lock is stale if the timestamp is
 // older than 30 minutes or if it was created on the current machine and the
 // process isn't alive any more.
 func (l *Lock) Stale() bool {
 	debug.Log("testing if lock %v for process %d is stale", l, l.PID)
-	if time.Since(l.Time) > staleTimeout {
+	if time.Since(l.Time) > StaleLockTimeout {
 		debug.Log("lock is stale, timestamp is too old: %v\n", l.Time)
 		return true
 	}
@@ -221,9 +234,12 @@ func (l *Lock) Stale() bool {
 // timestamp. Afterwards the old lock is removed.
 func (l *Lock) Refresh(ctx context.Context) error {
 	debug.Log("refreshing lock %v", l.lockID)
+	oldTime := l.Time
 	l.Time = time.Now()
 	id, err := l.createLock(ctx)
 	if err != nil {
+		// the lock in the repository still has the old timestamp
+		l.Time = oldTime
 		return err
 	}
 
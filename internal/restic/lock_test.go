@@ -196,6 +196,27 @@ func TestLockWithStaleLock(t *testing.T) {
 	rtest.OK(t, removeLock(repo, id2))
 }
 
+func TestLockOnStaleLockedRepo(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id1, err := createFakeLock(repo, time.Now().Add(-time.Hour), os.Getpid())
+	rtest.OK(t, err)
+
+	id2, err := createFakeLock(repo, time.Now().Add(-time.Minute), os.Getpid()+500000)
+	rtest.OK(t, err)
+
+	lock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, lockExists(repo, t, id1) == false,
+		"stale lock still exists after acquiring an exclusive lock")
+	rtest.Assert(t, lockExists(repo, t, id2) == false,
+		"stale lock still exists after acquiring an exclusive lock")
+
+	rtest.OK(t, lock.Unlock())
+}
+
 func TestRemoveAllLocks(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
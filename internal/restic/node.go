@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -26,6 +27,19 @@ type ExtendedAttribute struct {
 	Value []byte `json:"value"`
 }
 
+// AlternateDataStream is an NTFS alternate data stream of a file or directory.
+type AlternateDataStream struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// WindowsAttributes stores metadata which only exists on Windows.
+type WindowsAttributes struct {
+	FileAttributes       uint32                `json:"file_attributes,omitempty"`
+	SecurityDescriptor   []byte                `json:"security_descriptor,omitempty"`
+	AlternateDataStreams []AlternateDataStream `json:"alternate_data_streams,omitempty"`
+}
+
 // Node is a file, directory or other item in a backup.
 type Node struct {
 	Name               string              `json:"name"`
@@ -47,6 +61,7 @@ type Node struct {
 	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
 	Content            IDs                 `json:"content"`
 	Subtree            *ID                 `json:"subtree,omitempty"`
+	Windows            *WindowsAttributes  `json:"windows,omitempty"`
 
 	Error string `json:"error,omitempty"`
 
@@ -94,7 +109,7 @@ func NodeFromFileInfo(path string, fi os.FileInfo) (*Node, error) {
 		ModTime: fi.ModTime(),
 	}
 
-	node.Type = nodeTypeFromFileInfo(fi)
+	node.Type = NodeTypeFromFileInfo(fi)
 	if node.Type == "file" {
 		node.Size = uint64(fi.Size())
 	}
@@ -103,7 +118,8 @@ func NodeFromFileInfo(path string, fi os.FileInfo) (*Node, error) {
 	return node, err
 }
 
-func nodeTypeFromFileInfo(fi os.FileInfo) string {
+// NodeTypeFromFileInfo returns the type of node which represents fi.
+func NodeTypeFromFileInfo(fi os.FileInfo) string {
 	switch fi.Mode() & (os.ModeType | os.ModeCharDevice) {
 	case 0:
 		return "file"
@@ -172,9 +188,98 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo Repository) er
 	return nil
 }
 
+// RestoreMetadataOptions selects metadata which is not restored by
+// RestoreMetadataWithOptions. The zero value restores all metadata.
+type RestoreMetadataOptions struct {
+	NoOwner              bool
+	NoPermissions        bool
+	NoTimes              bool
+	NoExtendedAttributes bool
+
+	// WindowsMetadata restores the file attributes, security descriptor and
+	// alternate data streams recorded on Windows. This has no effect on other
+	// operating systems.
+	WindowsMetadata bool
+}
+
+// SkipsAny returns true if at least one kind of metadata is not restored.
+func (opts RestoreMetadataOptions) SkipsAny() bool {
+	return opts.NoOwner || opts.NoPermissions || opts.NoTimes || opts.NoExtendedAttributes
+}
+
+// SupportedType returns false if nodes of this type cannot be created on the
+// current platform, e.g. device nodes on Windows.
+func (node Node) SupportedType() bool {
+	if runtime.GOOS == "windows" {
+		switch node.Type {
+		case "dev", "chardev", "fifo":
+			return false
+		}
+	}
+	return true
+}
+
+// xattrSupported returns true if extended attributes can be restored on the
+// current platform.
+func xattrSupported() bool {
+	switch runtime.GOOS {
+	case "windows", "netbsd", "openbsd", "solaris":
+		return false
+	}
+	return true
+}
+
+// UnsupportedMetadata returns the kinds of data recorded for node which cannot
+// be restored on the current platform, e.g. alternate data streams of a file
+// backed up on Windows when restoring on Linux. Metadata excluded by opts is
+// not reported.
+func (node Node) UnsupportedMetadata(opts RestoreMetadataOptions) []string {
+	var unsupported []string
+
+	if !node.SupportedType() {
+		unsupported = append(unsupported, node.Type+" node")
+	}
+
+	if !opts.NoExtendedAttributes && !xattrSupported() {
+		var acls, xattrs bool
+		for _, attr := range node.ExtendedAttributes {
+			if strings.HasPrefix(attr.Name, "system.posix_acl_") {
+				acls = true
+			} else {
+				xattrs = true
+			}
+		}
+		if acls {
+			unsupported = append(unsupported, "POSIX ACLs")
+		}
+		if xattrs {
+			unsupported = append(unsupported, "extended attributes")
+		}
+	}
+
+	if node.Windows != nil && runtime.GOOS != "windows" {
+		if node.Windows.FileAttributes != 0 {
+			unsupported = append(unsupported, "file attributes")
+		}
+		if len(node.Windows.SecurityDescriptor) > 0 {
+			unsupported = append(unsupported, "security descriptor")
+		}
+		if len(node.Windows.AlternateDataStreams) > 0 {
+			unsupported = append(unsupported, "alternate data streams")
+		}
+	}
+
+	return unsupported
+}
+
 // RestoreMetadata restores node metadata
 func (node Node) RestoreMetadata(path string) error {
-	err := node.restoreMetadata(path)
+	return node.RestoreMetadataWithOptions(path, RestoreMetadataOptions{})
+}
+
+// RestoreMetadataWithOptions restores the node metadata selected by opts.
+func (node Node) RestoreMetadataWithOptions(path string, opts RestoreMetadataOptions) error {
+	err := node.restoreMetadata(path, opts)
 	if err != nil {
 		debug.Log("restoreMetadata(%s) error %v", path, err)
 	}
@@ -182,45 +287,82 @@ func (node Node) RestoreMetadata(path string) error {
 	return err
 }
 
-func (node Node) restoreMetadata(path string) error {
-	var firsterr error
+// MetadataError is returned by RestoreMetadata if restoring one or more kinds
+// of metadata failed. It contains the errors of all failed operations.
+type MetadataError struct {
+	Errors []error
+}
+
+func (e *MetadataError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (node Node) restoreMetadata(path string, opts RestoreMetadataOptions) error {
+	var errs []error
 
-	if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
-		// Like "cp -a" and "rsync -a" do, we only report lchown permission errors
-		// if we run as root.
-		// On Windows, Geteuid always returns -1, and we always report lchown
-		// permission errors.
-		if os.Geteuid() > 0 && os.IsPermission(err) {
-			debug.Log("not running as root, ignoring lchown permission error for %v: %v",
-				path, err)
-		} else {
-			firsterr = errors.Wrap(err, "Lchown")
+	restoreWindows := opts.WindowsMetadata && node.Windows != nil && node.Type != "symlink"
+
+	// writing alternate data streams changes the timestamps, so this needs to
+	// happen first
+	if restoreWindows {
+		if err := node.restoreAlternateDataStreams(path); err != nil {
+			debug.Log("error restoring alternate data streams for %v: %v", path, err)
+			errs = append(errs, err)
 		}
 	}
 
-	if node.Type != "symlink" {
-		if err := fs.Chmod(path, node.Mode); err != nil {
-			if firsterr != nil {
-				firsterr = errors.Wrap(err, "Chmod")
+	if !opts.NoOwner {
+		if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
+			// Like "cp -a" and "rsync -a" do, we only report lchown permission errors
+			// if we run as root.
+			// On Windows, Geteuid always returns -1, and we always report lchown
+			// permission errors.
+			if os.Geteuid() > 0 && os.IsPermission(err) {
+				debug.Log("not running as root, ignoring lchown permission error for %v: %v",
+					path, err)
+			} else {
+				errs = append(errs, errors.Wrap(err, "Lchown"))
 			}
 		}
 	}
 
-	if err := node.RestoreTimestamps(path); err != nil {
-		debug.Log("error restoring timestamps for dir %v: %v", path, err)
-		if firsterr != nil {
-			firsterr = err
+	if node.Type != "symlink" && !opts.NoPermissions {
+		if err := fs.Chmod(path, node.Mode); err != nil {
+			errs = append(errs, errors.Wrap(err, "Chmod"))
 		}
 	}
 
-	if err := node.restoreExtendedAttributes(path); err != nil {
-		debug.Log("error restoring extended attributes for %v: %v", path, err)
-		if firsterr != nil {
-			firsterr = err
+	if !opts.NoTimes {
+		if err := node.RestoreTimestamps(path); err != nil {
+			debug.Log("error restoring timestamps for dir %v: %v", path, err)
+			errs = append(errs, err)
 		}
 	}
 
-	return firsterr
+	if !opts.NoExtendedAttributes {
+		if err := node.restoreExtendedAttributes(path); err != nil {
+			debug.Log("error restoring extended attributes for %v: %v", path, err)
+			errs = append(errs, err)
+		}
+	}
+
+	// the file attributes and security descriptor may prevent further
+	// modifications, so they are restored last
+	if restoreWindows {
+		if err := node.restoreWindowsAttributes(path); err != nil {
+			debug.Log("error restoring Windows attributes for %v: %v", path, err)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MetadataError{Errors: errs}
+	}
+	return nil
 }
 
 func (node Node) restoreExtendedAttributes(path string) error {
@@ -419,6 +561,9 @@ func (node Node) Equals(other Node) bool {
 	if !node.sameExtendedAttributes(other) {
 		return false
 	}
+	if !node.sameWindowsAttributes(other) {
+		return false
+	}
 	if node.Subtree != nil {
 		if other.Subtree == nil {
 			return false
@@ -460,6 +605,33 @@ func (node Node) sameContent(other Node) bool {
 	return true
 }
 
+func (node Node) sameWindowsAttributes(other Node) bool {
+	if node.Windows == nil || other.Windows == nil {
+		return node.Windows == other.Windows
+	}
+
+	a, b := node.Windows, other.Windows
+	if a.FileAttributes != b.FileAttributes {
+		return false
+	}
+	if !bytes.Equal(a.SecurityDescriptor, b.SecurityDescriptor) {
+		return false
+	}
+	if len(a.AlternateDataStreams) != len(b.AlternateDataStreams) {
+		return false
+	}
+	for i, stream := range a.AlternateDataStreams {
+		if stream.Name != b.AlternateDataStreams[i].Name {
+			return false
+		}
+		if !bytes.Equal(stream.Data, b.AlternateDataStreams[i].Data) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (node Node) sameExtendedAttributes(other Node) bool {
 	if len(node.ExtendedAttributes) != len(other.ExtendedAttributes) {
 		return false
@@ -630,6 +802,10 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 		return err
 	}
 
+	if err = node.fillWindowsAttributes(path); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -221,6 +221,41 @@ func TestNodeRestoreAt(t *testing.T) {
 	}
 }
 
+func TestNodeRestoreMetadataWithOptions(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	node := restic.Node{
+		Name:       "testfile",
+		Type:       "file",
+		Mode:       0600,
+		UID:        uint32(os.Getuid()),
+		GID:        uint32(os.Getgid()),
+		ModTime:    parseTime("2005-05-14 21:07:03.111"),
+		AccessTime: parseTime("2005-05-14 21:07:04.222"),
+	}
+
+	nodePath := filepath.Join(tempdir, node.Name)
+	rtest.OK(t, node.CreateAt(context.TODO(), nodePath, nil))
+
+	fi, err := os.Lstat(nodePath)
+	rtest.OK(t, err)
+	modTime := fi.ModTime()
+
+	rtest.OK(t, node.RestoreMetadataWithOptions(nodePath, restic.RestoreMetadataOptions{NoTimes: true}))
+
+	fi, err = os.Lstat(nodePath)
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(modTime),
+		"modification time was restored: %v", fi.ModTime())
+
+	rtest.OK(t, node.RestoreMetadataWithOptions(nodePath, restic.RestoreMetadataOptions{}))
+
+	fi, err = os.Lstat(nodePath)
+	rtest.OK(t, err)
+	AssertFsTimeEqual(t, "ModTime", node.Type, node.ModTime, fi.ModTime())
+}
+
 func AssertFsTimeEqual(t *testing.T, label string, nodeType string, t1 time.Time, t2 time.Time) {
 	var equal bool
 
@@ -254,6 +289,38 @@ func parseTimeNano(t testing.TB, s string) time.Time {
 	return ts
 }
 
+func TestNodeUnsupportedMetadata(t *testing.T) {
+	node := restic.Node{
+		Type: "file",
+		ExtendedAttributes: []restic.ExtendedAttribute{
+			{Name: "user.foo", Value: []byte("bar")},
+			{Name: "system.posix_acl_access", Value: []byte{2, 0, 0, 0}},
+		},
+		Windows: &restic.WindowsAttributes{
+			FileAttributes: 0x20,
+			AlternateDataStreams: []restic.AlternateDataStream{
+				{Name: ":Zone.Identifier:$DATA", Data: []byte("[ZoneTransfer]")},
+			},
+		},
+	}
+
+	var want, wantNoXattrs []string
+	switch runtime.GOOS {
+	case "windows", "netbsd", "openbsd", "solaris":
+		want = append(want, "POSIX ACLs", "extended attributes")
+	}
+	if runtime.GOOS != "windows" {
+		want = append(want, "file attributes", "alternate data streams")
+		wantNoXattrs = append(wantNoXattrs, "file attributes", "alternate data streams")
+	}
+
+	rtest.Equals(t, want, node.UnsupportedMetadata(restic.RestoreMetadataOptions{}))
+	rtest.Equals(t, wantNoXattrs, node.UnsupportedMetadata(restic.RestoreMetadataOptions{NoExtendedAttributes: true}))
+
+	fifo := restic.Node{Type: "fifo"}
+	rtest.Equals(t, runtime.GOOS != "windows", fifo.SupportedType())
+}
+
 func TestFixTime(t *testing.T) {
 	// load UTC location
 	utc, err := time.LoadLocation("")
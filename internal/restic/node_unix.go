@@ -30,3 +30,8 @@ func (s statUnix) uid() uint32   { return uint32(s.Uid) }
 func (s statUnix) gid() uint32   { return uint32(s.Gid) }
 func (s statUnix) rdev() uint64  { return uint64(s.Rdev) }
 func (s statUnix) size() int64   { return int64(s.Size) }
+
+// Windows attributes are only recorded and restored on Windows.
+func (node *Node) fillWindowsAttributes(path string) error      { return nil }
+func (node Node) restoreAlternateDataStreams(path string) error { return nil }
+func (node Node) restoreWindowsAttributes(path string) error    { return nil }
@@ -3,11 +3,16 @@
 package restic
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 func stat(t testing.TB, filename string) (fi os.FileInfo, ok bool) {
@@ -133,3 +138,53 @@ func TestNodeFromFileInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestRestoreMetadataReportsAllErrors(t *testing.T) {
+	switch runtime.GOOS {
+	case "netbsd", "openbsd", "solaris":
+		t.Skipf("extended attributes are not supported on %v", runtime.GOOS)
+	}
+
+	tempdir, err := ioutil.TempDir("", "restic-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	filename := filepath.Join(tempdir, "file")
+	if err := ioutil.WriteFile(filename, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	chownErr := errors.New("chown failed")
+	defer func(f func(string, int, int) error) { lchown = f }(lchown)
+	lchown = func(string, int, int) error { return chownErr }
+
+	node := Node{
+		Name:       "file",
+		Type:       "file",
+		Mode:       0600,
+		ModTime:    time.Now(),
+		AccessTime: time.Now(),
+		ExtendedAttributes: []ExtendedAttribute{
+			// the name is too long, so setting the attribute fails
+			{Name: "user." + strings.Repeat("x", 300), Value: []byte("value")},
+		},
+	}
+
+	err = node.RestoreMetadata(filename)
+	merr, ok := err.(*MetadataError)
+	if !ok {
+		t.Fatalf("expected a *MetadataError, got %T: %v", err, err)
+	}
+
+	if len(merr.Errors) != 2 {
+		t.Fatalf("expected two errors, got %d: %v", len(merr.Errors), merr)
+	}
+	if errors.Cause(merr.Errors[0]) != chownErr {
+		t.Errorf("first error is not the chown error: %v", merr.Errors[0])
+	}
+	if !strings.Contains(merr.Errors[1].Error(), "Setxattr") {
+		t.Errorf("second error is not the xattr error: %v", merr.Errors[1])
+	}
+}
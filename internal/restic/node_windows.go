@@ -1,8 +1,14 @@
 package restic
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"syscall"
+	"unsafe"
 
+	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 )
 
@@ -79,3 +85,209 @@ func (s statWin) ctim() syscall.Timespec {
 	// Windows does not have the concept of a "change time" in the sense Unix uses it, so we're using the LastWriteTime here.
 	return syscall.NsecToTimespec(s.LastWriteTime.Nanoseconds())
 }
+
+var (
+	modadvapi32 = syscall.NewLazyDLL("advapi32.dll")
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procGetFileSecurityW = modadvapi32.NewProc("GetFileSecurityW")
+	procSetFileSecurityW = modadvapi32.NewProc("SetFileSecurityW")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+const (
+	ownerSecurityInformation = 0x00000001
+	groupSecurityInformation = 0x00000002
+	daclSecurityInformation  = 0x00000004
+
+	errorHandleEOF        syscall.Errno = 38
+	errorInvalidOwner     syscall.Errno = 1307
+	errorPrivilegeNotHeld syscall.Errno = 1314
+
+	// restorableFileAttributes are the file attributes which can be set
+	// with SetFileAttributes.
+	restorableFileAttributes = syscall.FILE_ATTRIBUTE_READONLY |
+		syscall.FILE_ATTRIBUTE_HIDDEN |
+		syscall.FILE_ATTRIBUTE_SYSTEM |
+		syscall.FILE_ATTRIBUTE_ARCHIVE |
+		0x00002000 // FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+
+	// maxAlternateDataStreamSize is the size limit for alternate data streams
+	// which are stored in the tree. Larger streams are skipped.
+	maxAlternateDataStreamSize = 1 << 20
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// fillWindowsAttributes records the file attributes, security descriptor and
+// alternate data streams of path.
+func (node *Node) fillWindowsAttributes(path string) error {
+	if node.Type == "symlink" {
+		return nil
+	}
+
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return errors.Wrap(err, "UTF16PtrFromString")
+	}
+
+	attrs, attrsErr := syscall.GetFileAttributes(p)
+	if attrsErr != nil {
+		return errors.Wrap(attrsErr, "GetFileAttributes")
+	}
+
+	sd, sdErr := getFileSecurity(p)
+	if sdErr != nil {
+		return errors.Wrap(sdErr, "GetFileSecurity")
+	}
+
+	// the item is still backed up if its alternate data streams cannot be
+	// read, the streams which were read successfully are kept
+	streams, streamsErr := readAlternateDataStreams(path, p)
+	if streamsErr != nil {
+		fmt.Fprintf(os.Stderr, "can not read alternate data streams of %v: %v\n", path, streamsErr)
+	}
+
+	node.Windows = &WindowsAttributes{
+		FileAttributes:       attrs & restorableFileAttributes,
+		SecurityDescriptor:   sd,
+		AlternateDataStreams: streams,
+	}
+
+	return nil
+}
+
+func getFileSecurity(p *uint16) ([]byte, error) {
+	info := uintptr(ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation)
+
+	var needed uint32
+	r1, _, err := procGetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), info, 0, 0, uintptr(unsafe.Pointer(&needed)))
+	if r1 == 0 && err != syscall.ERROR_INSUFFICIENT_BUFFER {
+		return nil, err
+	}
+	if needed == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, needed)
+	r1, _, err = procGetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), info, uintptr(unsafe.Pointer(&buf[0])), uintptr(needed), uintptr(unsafe.Pointer(&needed)))
+	if r1 == 0 {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func setFileSecurity(p *uint16, sd []byte, info uintptr) error {
+	r1, _, err := procSetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), info, uintptr(unsafe.Pointer(&sd[0])))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// readAlternateDataStreams returns all alternate data streams of path. Streams
+// which cannot be read are skipped with a warning.
+func readAlternateDataStreams(path string, p *uint16) ([]AlternateDataStream, error) {
+	var data win32FindStreamData
+	h, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if err == errorHandleEOF {
+			// no streams, e.g. for directories
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "FindFirstStream")
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var streams []AlternateDataStream
+	for {
+		// stream names have the form ":name:$DATA", the unnamed stream
+		// "::$DATA" is the file content
+		name := strings.TrimSuffix(strings.TrimPrefix(syscall.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if name != "" {
+			if data.StreamSize > maxAlternateDataStreamSize {
+				debug.Log("skipping alternate data stream %v of %v: size %d exceeds limit", name, path, data.StreamSize)
+			} else {
+				buf, err := ioutil.ReadFile(path + ":" + name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "can not read alternate data stream %v of %v: %v\n", name, path, err)
+				} else {
+					streams = append(streams, AlternateDataStream{Name: name, Data: buf})
+				}
+			}
+		}
+
+		r1, _, err := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if r1 == 0 {
+			if err == errorHandleEOF {
+				break
+			}
+			return streams, errors.Wrap(err, "FindNextStream")
+		}
+	}
+
+	return streams, nil
+}
+
+// restoreAlternateDataStreams writes the recorded alternate data streams.
+func (node Node) restoreAlternateDataStreams(path string) error {
+	for _, stream := range node.Windows.AlternateDataStreams {
+		f, err := os.OpenFile(path+":"+stream.Name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Wrap(err, "OpenFile")
+		}
+
+		_, err = f.Write(stream.Data)
+		closeErr := f.Close()
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+		if closeErr != nil {
+			return errors.Wrap(closeErr, "Close")
+		}
+	}
+
+	return nil
+}
+
+// restoreWindowsAttributes applies the recorded file attributes and security
+// descriptor.
+func (node Node) restoreWindowsAttributes(path string) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return errors.Wrap(err, "UTF16PtrFromString")
+	}
+
+	if len(node.Windows.SecurityDescriptor) > 0 {
+		info := uintptr(ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation)
+		err = setFileSecurity(p, node.Windows.SecurityDescriptor, info)
+		if err == errorInvalidOwner || err == errorPrivilegeNotHeld {
+			// setting an arbitrary owner requires SeRestorePrivilege, restore
+			// at least the access control list
+			debug.Log("unable to set owner of %v, only restoring DACL: %v", path, err)
+			err = setFileSecurity(p, node.Windows.SecurityDescriptor, daclSecurityInformation)
+		}
+		if err != nil {
+			return errors.Wrap(err, "SetFileSecurity")
+		}
+	}
+
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return errors.Wrap(err, "GetFileAttributes")
+	}
+
+	attrs = attrs&^restorableFileAttributes | node.Windows.FileAttributes
+	err = syscall.SetFileAttributes(p, attrs)
+	if err != nil {
+		return errors.Wrap(err, "SetFileAttributes")
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package restic_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNodeWindowsAttributes(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	source := filepath.Join(tempdir, "source")
+	rtest.OK(t, ioutil.WriteFile(source, []byte("content"), 0600))
+	rtest.OK(t, ioutil.WriteFile(source+":Zone.Identifier", []byte("[ZoneTransfer]\r\nZoneId=3\r\n"), 0600))
+
+	p, err := syscall.UTF16PtrFromString(source)
+	rtest.OK(t, err)
+	rtest.OK(t, syscall.SetFileAttributes(p, syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_ARCHIVE))
+
+	fi, err := os.Lstat(source)
+	rtest.OK(t, err)
+
+	node, err := restic.NodeFromFileInfo(source, fi)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, node.Windows != nil, "no Windows attributes recorded")
+	rtest.Equals(t, 1, len(node.Windows.AlternateDataStreams))
+	rtest.Equals(t, "Zone.Identifier", node.Windows.AlternateDataStreams[0].Name)
+	rtest.Assert(t, node.Windows.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0, "hidden attribute not recorded")
+	rtest.Assert(t, len(node.Windows.SecurityDescriptor) > 0, "no security descriptor recorded")
+
+	target := filepath.Join(tempdir, "target")
+	rtest.OK(t, node.CreateAt(context.TODO(), target, nil))
+	rtest.OK(t, node.RestoreMetadataWithOptions(target, restic.RestoreMetadataOptions{WindowsMetadata: true}))
+
+	buf, err := ioutil.ReadFile(target + ":Zone.Identifier")
+	rtest.OK(t, err)
+	rtest.Equals(t, node.Windows.AlternateDataStreams[0].Data, buf)
+
+	p, err = syscall.UTF16PtrFromString(target)
+	rtest.OK(t, err)
+	attrs, err := syscall.GetFileAttributes(p)
+	rtest.OK(t, err)
+	rtest.Assert(t, attrs&syscall.FILE_ATTRIBUTE_HIDDEN != 0, "hidden attribute not restored")
+}
@@ -24,6 +24,17 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	// Previous is the ID of the newest snapshot of the same host and paths
+	// when the snapshot was created, see ChainKey.
+	Previous *ID `json:"previous,omitempty"`
+
+	// Protected snapshots are never removed by forget.
+	Protected bool `json:"protected,omitempty"`
+
+	// Signature is created by an external signing service for the data
+	// returned by SignedData.
+	Signature []byte `json:"signature,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
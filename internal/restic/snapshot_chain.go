@@ -0,0 +1,103 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChainKey returns the key of the chain the snapshot belongs to. A chain
+// consists of all snapshots with the same hostname and paths.
+func (sn *Snapshot) ChainKey() string {
+	paths := make([]string, len(sn.Paths))
+	copy(paths, sn.Paths)
+	sort.Strings(paths)
+
+	return sn.Hostname + "\x00" + strings.Join(paths, "\x00")
+}
+
+// FindPreviousSnapshot returns the ID of the newest snapshot in the repository
+// which belongs to the same chain as sn. If there is none, nil is returned.
+func FindPreviousSnapshot(ctx context.Context, repo Repository, sn *Snapshot) (*ID, error) {
+	key := sn.ChainKey()
+
+	var previous *Snapshot
+	err := repo.List(ctx, SnapshotFile, func(id ID, size int64) error {
+		other, err := LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		if other.ChainKey() != key {
+			return nil
+		}
+
+		if previous == nil || other.Time.After(previous.Time) {
+			previous = other
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if previous == nil {
+		return nil, nil
+	}
+	return previous.ID(), nil
+}
+
+// ChainProblem describes a snapshot whose previous snapshot is missing.
+type ChainProblem struct {
+	Snapshot *Snapshot
+	Previous ID
+}
+
+// VerifyChains checks that the previous snapshot of each snapshot in list
+// exists. Snapshots which were removed or replaced legitimately must be
+// contained in accounted. Snapshots which are referenced as the original of
+// another snapshot are accounted for as well.
+func VerifyChains(list Snapshots, accounted IDSet) []ChainProblem {
+	existing := NewIDSet()
+	for _, sn := range list {
+		existing.Insert(*sn.ID())
+		if sn.Original != nil {
+			existing.Insert(*sn.Original)
+		}
+	}
+
+	var problems []ChainProblem
+	for _, sn := range list {
+		if sn.Previous == nil {
+			continue
+		}
+
+		if existing.Has(*sn.Previous) || accounted.Has(*sn.Previous) {
+			continue
+		}
+
+		problems = append(problems, ChainProblem{Snapshot: sn, Previous: *sn.Previous})
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Snapshot.Time.Before(problems[j].Snapshot.Time)
+	})
+
+	return problems
+}
+
+// SignedData returns the data of the snapshot which is covered by its
+// signature: the time, tree, host, paths and the previous snapshot in the
+// chain. Other fields like the tags can be changed without invalidating the
+// signature.
+func (sn *Snapshot) SignedData() ([]byte, error) {
+	return json.Marshal(struct {
+		Time     time.Time `json:"time"`
+		Tree     *ID       `json:"tree"`
+		Paths    []string  `json:"paths"`
+		Hostname string    `json:"hostname"`
+		Previous *ID       `json:"previous"`
+	}{sn.Time, sn.Tree, sn.Paths, sn.Hostname, sn.Previous})
+}
@@ -30,7 +30,7 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 	GroupOptionList = strings.Split(options, ",")
 
 	for _, option := range GroupOptionList {
-		switch option {
+		switch strings.TrimSpace(option) {
 		case "host", "hosts":
 			GroupByHost = true
 		case "path", "paths":
@@ -74,3 +74,14 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 
 	return snapshotGroups, GroupByTag || GroupByHost || GroupByPath, nil
 }
+
+// SortedGroupKeys returns the keys of the groups returned by GroupSnapshots in
+// a stable order, so that the groups are printed in the same order each time.
+func SortedGroupKeys(groups map[string]Snapshots) []string {
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package restic_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestGroupSnapshots(t *testing.T) {
+	snapshots := restic.Snapshots{
+		{Hostname: "foo", Paths: []string{"/home"}, Tags: []string{"b", "a"}},
+		{Hostname: "foo", Paths: []string{"/home"}, Tags: []string{"a", "b"}},
+		{Hostname: "foo", Paths: []string{"/srv"}},
+		{Hostname: "bar", Paths: []string{"/home"}, Tags: []string{"a"}},
+	}
+
+	var tests = []struct {
+		groupBy string
+		grouped bool
+		groups  []restic.SnapshotGroupKey
+	}{
+		{"", false, []restic.SnapshotGroupKey{{}}},
+		{"host", true, []restic.SnapshotGroupKey{
+			{Hostname: "bar"},
+			{Hostname: "foo"},
+		}},
+		{"host, paths", true, []restic.SnapshotGroupKey{
+			{Hostname: "bar", Paths: []string{"/home"}},
+			{Hostname: "foo", Paths: []string{"/home"}},
+			{Hostname: "foo", Paths: []string{"/srv"}},
+		}},
+		{"tags", true, []restic.SnapshotGroupKey{
+			{Tags: []string{"a", "b"}},
+			{Tags: []string{"a"}},
+			{},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.groupBy, func(t *testing.T) {
+			groups, grouped, err := restic.GroupSnapshots(snapshots, test.groupBy)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.grouped, grouped)
+
+			var keys []restic.SnapshotGroupKey
+			for _, k := range restic.SortedGroupKeys(groups) {
+				var key restic.SnapshotGroupKey
+				rtest.OK(t, json.Unmarshal([]byte(k), &key))
+				keys = append(keys, key)
+			}
+			rtest.Equals(t, test.groups, keys)
+		})
+	}
+
+	_, _, err := restic.GroupSnapshots(snapshots, "host,foo")
+	rtest.Assert(t, err != nil, "expected error for unknown grouping option")
+}
@@ -20,6 +20,12 @@ type ExpirePolicy struct {
 	Yearly  int       // keep the last n yearly snapshots
 	Within  Duration  // keep snapshots made within this duration
 	Tags    []TagList // keep all snapshots that include at least one of the tag lists.
+
+	WithinHourly  Duration // keep hourly snapshots made within this duration
+	WithinDaily   Duration // keep daily snapshots made within this duration
+	WithinWeekly  Duration // keep weekly snapshots made within this duration
+	WithinMonthly Duration // keep monthly snapshots made within this duration
+	WithinYearly  Duration // keep yearly snapshots made within this duration
 }
 
 func (e ExpirePolicy) String() (s string) {
@@ -61,6 +67,29 @@ func (e ExpirePolicy) String() (s string) {
 		s += fmt.Sprintf("all snapshots within %s of the newest", e.Within)
 	}
 
+	var within []string
+	for _, w := range []struct {
+		d    Duration
+		name string
+	}{
+		{e.WithinHourly, "hourly"},
+		{e.WithinDaily, "daily"},
+		{e.WithinWeekly, "weekly"},
+		{e.WithinMonthly, "monthly"},
+		{e.WithinYearly, "yearly"},
+	} {
+		if !w.d.Zero() {
+			within = append(within, fmt.Sprintf("%s snapshots within %s", w.name, w.d))
+		}
+	}
+
+	if len(within) > 0 {
+		if s != "" {
+			s += " and "
+		}
+		s += fmt.Sprintf("%s of the newest", strings.Join(within, ", "))
+	}
+
 	return s
 }
 
@@ -111,6 +140,11 @@ func always(d time.Time, nr int) int {
 	return nr
 }
 
+// subtractDuration returns the time d before t.
+func subtractDuration(t time.Time, d Duration) time.Time {
+	return t.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Hour * time.Duration(-d.Hours))
+}
+
 // findLatestTimestamp returns the time stamp for the newest snapshot.
 func findLatestTimestamp(list Snapshots) time.Time {
 	if len(list) == 0 {
@@ -146,10 +180,27 @@ type KeepReason struct {
 	} `json:"counters"`
 }
 
+// RemoveReason specifies why a particular snapshot is removed.
+type RemoveReason struct {
+	Snapshot *Snapshot `json:"snapshot"`
+
+	// description text why each criterion does not match, e.g. "daily
+	// snapshot: newer snapshot kept for this day"
+	Reasons []string `json:"reasons"`
+}
+
 // ApplyPolicy returns the snapshots from list that are to be kept and removed
 // according to the policy p. list is sorted in the process. reasons contains
 // the reasons to keep each snapshot, it is in the same order as keep.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
+	keep, remove, reasons, _ = ApplyPolicyExplained(list, p)
+	return keep, remove, reasons
+}
+
+// ApplyPolicyExplained works like ApplyPolicy, and in addition returns why
+// each snapshot in remove is not kept by the policy. removeReasons is in the
+// same order as remove.
+func ApplyPolicyExplained(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason, removeReasons []RemoveReason) {
 	sort.Sort(list)
 
 	if p.Empty() {
@@ -159,11 +210,11 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 				Matches:  []string{"policy is empty"},
 			})
 		}
-		return list, remove, reasons
+		return list, remove, reasons, nil
 	}
 
 	if len(list) == 0 {
-		return list, nil, nil
+		return list, nil, nil, nil
 	}
 
 	var buckets = [6]struct {
@@ -171,14 +222,32 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		bucker func(d time.Time, nr int) int
 		Last   int
 		reason string
+		period string
+	}{
+		{p.Last, always, -1, "last snapshot", ""},
+		{p.Hourly, ymdh, -1, "hourly snapshot", "hour"},
+		{p.Daily, ymd, -1, "daily snapshot", "day"},
+		{p.Weekly, yw, -1, "weekly snapshot", "week"},
+		{p.Monthly, ym, -1, "monthly snapshot", "month"},
+		{p.Yearly, y, -1, "yearly snapshot", "year"},
+	}
+	limits := [len(buckets)]int{p.Last, p.Hourly, p.Daily, p.Weekly, p.Monthly, p.Yearly}
+
+	// the buckets for the --keep-within-* options are not counted, instead
+	// they only cover snapshots made within the duration
+	var bucketsWithin = [5]struct {
+		Within Duration
+		bucker func(d time.Time, nr int) int
+		Last   int
+		reason string
 	}{
-		{p.Last, always, -1, "last snapshot"},
-		{p.Hourly, ymdh, -1, "hourly snapshot"},
-		{p.Daily, ymd, -1, "daily snapshot"},
-		{p.Weekly, yw, -1, "weekly snapshot"},
-		{p.Monthly, ym, -1, "monthly snapshot"},
-		{p.Yearly, y, -1, "yearly snapshot"},
+		{p.WithinHourly, ymdh, -1, "hourly within"},
+		{p.WithinDaily, ymd, -1, "daily within"},
+		{p.WithinWeekly, yw, -1, "weekly within"},
+		{p.WithinMonthly, ym, -1, "monthly within"},
+		{p.WithinYearly, y, -1, "yearly within"},
 	}
+	periodsWithin := [len(bucketsWithin)]string{"hour", "day", "week", "month", "year"}
 
 	latest := findLatestTimestamp(list)
 
@@ -186,6 +255,16 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		var keepSnap bool
 		var keepSnapReasons []string
 
+		// removeSnapReasons collects why the criteria do not match, it is
+		// only used when the snapshot is removed
+		var removeSnapReasons []string
+
+		// Protected snapshots are always kept.
+		if cur.Protected {
+			keepSnap = true
+			keepSnapReasons = append(keepSnapReasons, "protected")
+		}
+
 		// Tags are handled specially as they are not counted.
 		for _, l := range p.Tags {
 			if cur.HasTags(l) {
@@ -193,13 +272,17 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 				keepSnapReasons = append(keepSnapReasons, fmt.Sprintf("has tags %v", l))
 			}
 		}
+		if len(p.Tags) > 0 && !keepSnap {
+			removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("does not have tags %v", p.Tags))
+		}
 
 		// If the timestamp of the snapshot is within the range, then keep it.
 		if !p.Within.Zero() {
-			t := latest.AddDate(-p.Within.Years, -p.Within.Months, -p.Within.Days).Add(time.Hour * time.Duration(-p.Within.Hours))
-			if cur.Time.After(t) {
+			if cur.Time.After(subtractDuration(latest, p.Within)) {
 				keepSnap = true
 				keepSnapReasons = append(keepSnapReasons, fmt.Sprintf("within %v", p.Within))
+			} else {
+				removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("not within %v", p.Within))
 			}
 		}
 
@@ -213,7 +296,33 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 					buckets[i].Last = val
 					buckets[i].Count--
 					keepSnapReasons = append(keepSnapReasons, b.reason)
+				} else {
+					removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("%v: newer snapshot kept for this %v", b.reason, b.period))
 				}
+			} else if limits[i] > 0 {
+				removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("%v: limit of %d reached", b.reason, limits[i]))
+			}
+		}
+
+		// Keep the last snapshot in each time period within the duration.
+		for i, b := range bucketsWithin {
+			if b.Within.Zero() {
+				continue
+			}
+
+			if !cur.Time.After(subtractDuration(latest, b.Within)) {
+				removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("%v %v: too old", b.reason, b.Within))
+				continue
+			}
+
+			val := b.bucker(cur.Time, nr)
+			if val != b.Last {
+				debug.Log("keep %v %v, within bucker %v, val %v\n", cur.Time, cur.id.Str(), i, val)
+				keepSnap = true
+				bucketsWithin[i].Last = val
+				keepSnapReasons = append(keepSnapReasons, fmt.Sprintf("%v %v", b.reason, b.Within))
+			} else {
+				removeSnapReasons = append(removeSnapReasons, fmt.Sprintf("%v %v: newer snapshot kept for this %v", b.reason, b.Within, periodsWithin[i]))
 			}
 		}
 
@@ -232,8 +341,12 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 			reasons = append(reasons, kr)
 		} else {
 			remove = append(remove, cur)
+			removeReasons = append(removeReasons, RemoveReason{
+				Snapshot: cur,
+				Reasons:  removeSnapReasons,
+			})
 		}
 	}
 
-	return keep, remove, reasons
+	return keep, remove, reasons, removeReasons
 }
@@ -269,3 +269,142 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyPolicyWithin(t *testing.T) {
+	var snapshots = restic.Snapshots{
+		{Time: parseTimeUTC("2016-01-11 12:00:00")},
+		{Time: parseTimeUTC("2016-01-10 12:00:00")},
+		{Time: parseTimeUTC("2016-01-10 08:00:00")},
+		{Time: parseTimeUTC("2016-01-03 12:00:00")},
+		{Time: parseTimeUTC("2016-01-02 12:00:00")},
+		{Time: parseTimeUTC("2016-01-02 01:00:00")},
+		{Time: parseTimeUTC("2015-12-20 12:00:00")},
+		{Time: parseTimeUTC("2015-06-01 12:00:00")},
+	}
+
+	var tests = []struct {
+		p    restic.ExpirePolicy
+		keep []string
+	}{
+		{restic.ExpirePolicy{WithinHourly: parseDuration("1d")}, []string{
+			"2016-01-11 12:00:00",
+		}},
+		{restic.ExpirePolicy{WithinDaily: parseDuration("2d")}, []string{
+			"2016-01-11 12:00:00",
+			"2016-01-10 12:00:00",
+		}},
+		{restic.ExpirePolicy{WithinDaily: parseDuration("10d")}, []string{
+			"2016-01-11 12:00:00",
+			"2016-01-10 12:00:00",
+			"2016-01-03 12:00:00",
+			"2016-01-02 12:00:00",
+		}},
+		{restic.ExpirePolicy{WithinWeekly: parseDuration("1m")}, []string{
+			"2016-01-11 12:00:00",
+			"2016-01-10 12:00:00",
+			"2016-01-03 12:00:00",
+			"2015-12-20 12:00:00",
+		}},
+		{restic.ExpirePolicy{WithinMonthly: parseDuration("1y")}, []string{
+			"2016-01-11 12:00:00",
+			"2015-12-20 12:00:00",
+			"2015-06-01 12:00:00",
+		}},
+		{restic.ExpirePolicy{WithinYearly: parseDuration("1y")}, []string{
+			"2016-01-11 12:00:00",
+			"2015-12-20 12:00:00",
+		}},
+		{restic.ExpirePolicy{Within: parseDuration("1d"), WithinDaily: parseDuration("10d")}, []string{
+			"2016-01-11 12:00:00",
+			"2016-01-10 12:00:00",
+			"2016-01-03 12:00:00",
+			"2016-01-02 12:00:00",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.p.String(), func(t *testing.T) {
+			keep, remove, reasons := restic.ApplyPolicy(snapshots, test.p)
+
+			if len(keep)+len(remove) != len(snapshots) {
+				t.Errorf("len(keep)+len(remove) = %d != len(snapshots) = %d", len(keep)+len(remove), len(snapshots))
+			}
+
+			if len(keep) != len(reasons) {
+				t.Errorf("got %d keep reasons for %d snapshots to keep, these must be equal", len(reasons), len(keep))
+			}
+
+			var got []string
+			for _, sn := range keep {
+				got = append(got, sn.Time.Format("2006-01-02 15:04:05"))
+			}
+
+			if !cmp.Equal(test.keep, got) {
+				t.Error(cmp.Diff(test.keep, got))
+			}
+		})
+	}
+}
+
+func TestApplyPolicyRemoveReasons(t *testing.T) {
+	var snapshots = restic.Snapshots{
+		{Time: parseTimeUTC("2016-01-03 12:00:00")},
+		{Time: parseTimeUTC("2016-01-03 08:00:00")},
+		{Time: parseTimeUTC("2016-01-02 12:00:00")},
+		{Time: parseTimeUTC("2016-01-01 12:00:00"), Tags: []string{"foo"}},
+		{Time: parseTimeUTC("2015-12-31 12:00:00")},
+	}
+
+	p := restic.ExpirePolicy{Daily: 2, Tags: []restic.TagList{{"foo"}}}
+	keep, remove, reasons, removeReasons := restic.ApplyPolicyExplained(snapshots, p)
+
+	if len(keep) != 3 || len(reasons) != 3 {
+		t.Fatalf("expected 3 snapshots to keep, got %d with %d reasons", len(keep), len(reasons))
+	}
+
+	if len(remove) != len(removeReasons) {
+		t.Fatalf("got %d remove reasons for %d snapshots to remove, these must be equal", len(removeReasons), len(remove))
+	}
+
+	want := []restic.RemoveReason{
+		{Snapshot: snapshots[1], Reasons: []string{
+			"does not have tags [[foo]]",
+			"daily snapshot: newer snapshot kept for this day",
+		}},
+		{Snapshot: snapshots[4], Reasons: []string{
+			"does not have tags [[foo]]",
+			"daily snapshot: limit of 2 reached",
+		}},
+	}
+
+	cmpOpts := cmpopts.IgnoreUnexported(restic.Snapshot{})
+	if !cmp.Equal(want, removeReasons, cmpOpts) {
+		t.Error(cmp.Diff(want, removeReasons, cmpOpts))
+	}
+}
+
+func TestApplyPolicyProtected(t *testing.T) {
+	var snapshots = restic.Snapshots{
+		{Time: parseTimeUTC("2016-01-03 12:00:00")},
+		{Time: parseTimeUTC("2016-01-02 12:00:00"), Protected: true},
+		{Time: parseTimeUTC("2016-01-01 12:00:00")},
+	}
+
+	p := restic.ExpirePolicy{Last: 1}
+	keep, remove, reasons, _ := restic.ApplyPolicyExplained(snapshots, p)
+
+	cmpOpts := cmpopts.IgnoreUnexported(restic.Snapshot{})
+	wantKeep := restic.Snapshots{snapshots[0], snapshots[1]}
+	if !cmp.Equal(wantKeep, keep, cmpOpts) {
+		t.Error(cmp.Diff(wantKeep, keep, cmpOpts))
+	}
+
+	wantRemove := restic.Snapshots{snapshots[2]}
+	if !cmp.Equal(wantRemove, remove, cmpOpts) {
+		t.Error(cmp.Diff(wantRemove, remove, cmpOpts))
+	}
+
+	if len(reasons) != 2 || len(reasons[1].Matches) != 1 || reasons[1].Matches[0] != "protected" {
+		t.Errorf("unexpected reasons for protected snapshot: %v", reasons)
+	}
+}
@@ -0,0 +1,246 @@
+package restorer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// deviceBlockSize is the size of the blocks written to and read from devices.
+// All writes except the last one are aligned to this size, which is required
+// for raw disks on Windows.
+const deviceBlockSize = 1 << 20
+
+// FindImage returns the node for the file at location within the snapshot,
+// which holds the image of a device. If location is empty, the snapshot must
+// contain exactly one file, e.g. because it was created from stdin.
+func (res *Restorer) FindImage(ctx context.Context, location string) (*restic.Node, error) {
+	tree, err := res.repo.LoadTree(ctx, *res.sn.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	if location == "" {
+		if len(tree.Nodes) != 1 || tree.Nodes[0].Type != "file" {
+			return nil, errors.New("snapshot does not contain exactly one file, please specify the image file")
+		}
+		return tree.Nodes[0], nil
+	}
+
+	components := strings.Split(strings.Trim(path.Clean("/"+location), "/"), "/")
+	for i, name := range components {
+		node := tree.Find(name)
+		if node == nil {
+			return nil, errors.Errorf("%q not found in snapshot", location)
+		}
+
+		if i == len(components)-1 {
+			if node.Type != "file" {
+				return nil, errors.Errorf("%q is a %v, not a file", location, node.Type)
+			}
+			return node, nil
+		}
+
+		if node.Type != "dir" || node.Subtree == nil {
+			return nil, errors.Errorf("%q not found in snapshot", location)
+		}
+
+		tree, err = res.repo.LoadTree(ctx, *node.Subtree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errors.Errorf("%q not found in snapshot", location)
+}
+
+// RestoreToDevice writes the content of the file node to the device, which
+// must already exist and be large enough. If sparse is true, blocks which
+// only contain zeroes are skipped, so the device must be zeroed beforehand.
+// Progress is reported via StartFile, CompleteBlob and CompleteFile.
+func (res *Restorer) RestoreToDevice(ctx context.Context, node *restic.Node, device string, sparse bool) error {
+	f, err := fs.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	err = checkDeviceSize(f, node.Size)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	res.StartFile(node.Name)
+
+	buf := make([]byte, 0, deviceBlockSize)
+	var offset int64
+
+	flush := func() error {
+		if sparse && isZero(buf) {
+			debug.Log("skipping %d zero bytes at offset %d", len(buf), offset)
+		} else {
+			_, err := f.WriteAt(buf, offset)
+			if err != nil {
+				return errors.Wrap(err, "WriteAt")
+			}
+		}
+		offset += int64(len(buf))
+		buf = buf[:0]
+		return nil
+	}
+
+	var blob []byte
+	for _, id := range node.Content {
+		blob, err = res.repo.LoadBlob(ctx, restic.DataBlob, id, blob)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		for data := blob; len(data) > 0; {
+			n := copy(buf[len(buf):cap(buf)], data)
+			buf = buf[:len(buf)+n]
+			data = data[n:]
+
+			if len(buf) == cap(buf) {
+				if err = flush(); err != nil {
+					_ = f.Close()
+					return err
+				}
+			}
+		}
+
+		res.CompleteBlob(node.Name, uint64(len(blob)))
+	}
+
+	if len(buf) > 0 {
+		if err = flush(); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	// skipped blocks at the end of a regular file must be added explicitly
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		if err = f.Truncate(int64(node.Size)); err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "Truncate")
+		}
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Sync")
+	}
+
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	res.CompleteFile(node.Name, node.Size, false)
+	return nil
+}
+
+// RestoreToWriter writes the content of the file node to wr, e.g. to
+// reproduce the data of a snapshot created from stdin on stdout. Progress is
+// reported via StartFile, CompleteBlob and CompleteFile.
+func (res *Restorer) RestoreToWriter(ctx context.Context, node *restic.Node, wr io.Writer) error {
+	res.StartFile(node.Name)
+
+	var buf []byte
+	var err error
+	for _, id := range node.Content {
+		buf, err = res.repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+		if err != nil {
+			return err
+		}
+
+		_, err = wr.Write(buf)
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+
+		res.CompleteBlob(node.Name, uint64(len(buf)))
+	}
+
+	res.CompleteFile(node.Name, node.Size, false)
+	return nil
+}
+
+// VerifyDevice checks that the device starts with the content of node.
+func (res *Restorer) VerifyDevice(ctx context.Context, node *restic.Node, device string) error {
+	f, err := fs.OpenFile(device, os.O_RDONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	defer f.Close()
+
+	rd := bufio.NewReaderSize(io.NewSectionReader(f, 0, int64(node.Size)), deviceBlockSize)
+
+	var buf []byte
+	var offset int64
+	for _, id := range node.Content {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		length, found := res.repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return errors.Errorf("Unknown blob %s", id.String())
+		}
+
+		if uint(cap(buf)) < length {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+
+		_, err = io.ReadFull(rd, buf)
+		if err != nil {
+			return errors.Wrap(err, "ReadFull")
+		}
+
+		if !id.Equal(restic.Hash(buf)) {
+			return errors.Errorf("Unexpected contents starting at offset %d", offset)
+		}
+		offset += int64(length)
+	}
+
+	return nil
+}
+
+// checkDeviceSize returns an error if f is smaller than size.
+func checkDeviceSize(f *os.File, size uint64) error {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+
+	// regular files are extended when written to
+	fi, err := f.Stat()
+	if err == nil && fi.Mode().IsRegular() {
+		return nil
+	}
+
+	if end > 0 && uint64(end) < size {
+		return errors.Errorf("device is too small, need %d bytes, but only %d are available", size, end)
+	}
+
+	return nil
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package restorer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerRestoreToDevice(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	data := strings.Repeat("\x00", 2*deviceBlockSize) + "image data\n"
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"disk.img": File{Data: data},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	_, err = res.FindImage(ctx, "")
+	rtest.Assert(t, err != nil, "expected error for snapshot without a single file")
+
+	_, err = res.FindImage(ctx, "/dir")
+	rtest.Assert(t, err != nil, "expected error for directory")
+
+	node, err := res.FindImage(ctx, "/dir/disk.img")
+	rtest.OK(t, err)
+
+	for _, sparse := range []bool{false, true} {
+		device := filepath.Join(tempdir, "device")
+		rtest.OK(t, ioutil.WriteFile(device, nil, 0600))
+
+		rtest.OK(t, res.RestoreToDevice(ctx, node, device, sparse))
+		rtest.OK(t, res.VerifyDevice(ctx, node, device))
+
+		buf, err := ioutil.ReadFile(device)
+		rtest.OK(t, err)
+		rtest.Assert(t, string(buf) == data, "wrong content for sparse=%v", sparse)
+
+		rtest.OK(t, os.Remove(device))
+	}
+
+	err = res.RestoreToDevice(ctx, node, filepath.Join(tempdir, "missing"), false)
+	rtest.Assert(t, err != nil, "expected error for missing device")
+}
+
+func TestRestorerRestoreToWriter(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	data := strings.Repeat("database dump\n", 1000)
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"stdin": File{Data: data},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	node, err := res.FindImage(ctx, "")
+	rtest.OK(t, err)
+	rtest.Equals(t, "stdin", node.Name)
+
+	var buf bytes.Buffer
+	rtest.OK(t, res.RestoreToWriter(ctx, node, &buf))
+	rtest.Equals(t, data, buf.String())
+}
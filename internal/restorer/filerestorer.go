@@ -33,8 +33,22 @@ const (
 type fileInfo struct {
 	lock     sync.Mutex
 	flags    int
+	pending  int // number of blob writes which are still outstanding
+	size     int64
 	location string      // file on local filesystem relative to restorer basedir
 	blobs    interface{} // blobs of the file
+	state    *fileState  // state of an existing file, nil for new files
+}
+
+// fileState describes which parts of an existing file already contain the
+// data from the snapshot.
+type fileState struct {
+	blobMatches []bool // for each blob, true if the file already contains it
+}
+
+// hasMatch returns true if the blob with index i does not need to be restored.
+func (s *fileState) hasMatch(i int) bool {
+	return s != nil && i < len(s.blobMatches) && s.blobMatches[i]
 }
 
 type fileBlobInfo struct {
@@ -56,8 +70,21 @@ type fileRestorer struct {
 
 	filesWriter *filesWriter
 
+	// progress callbacks, may be nil
+	startFile    func(location string)
+	completeBlob func(location string, bytes uint64)
+	fileDone     func(location string)
+
 	dst   string
 	files []*fileInfo
+
+	// blobPacks holds the pack chosen for blobs which are stored in more
+	// than one pack, it is only modified before the download starts
+	blobPacks map[restic.ID]restic.ID
+
+	// localBlobs are copies of blobs in local files, which are used instead
+	// of downloading the blobs, may be nil
+	localBlobs map[restic.ID]localBlob
 }
 
 func newFileRestorer(dst string,
@@ -71,36 +98,188 @@ func newFileRestorer(dst string,
 		packLoader:  packLoader,
 		filesWriter: newFilesWriter(workerCount),
 		dst:         dst,
+		blobPacks:   make(map[restic.ID]restic.ID),
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64, state *fileState) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, state: state})
 }
 
 func (r *fileRestorer) targetPath(location string) string {
 	return filepath.Join(r.dst, location)
 }
 
-func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID, packBlob restic.Blob)) error {
+func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID, packBlob restic.Blob, idx int)) error {
 	if len(blobIDs) == 0 {
 		return nil
 	}
 
-	for _, blobID := range blobIDs {
+	for i, blobID := range blobIDs {
 		packs, found := r.idx(blobID, restic.DataBlob)
 		if !found {
 			return errors.Errorf("Unknown blob %s", blobID.String())
 		}
-		fn(packs[0].PackID, packs[0].Blob)
+		pb := r.choosePack(blobID, packs)
+		fn(pb.PackID, pb.Blob, i)
+	}
+
+	return nil
+}
+
+// choosePack returns the copy of the blob which is restored from.
+func (r *fileRestorer) choosePack(blobID restic.ID, packs []restic.PackedBlob) restic.PackedBlob {
+	if len(packs) > 1 {
+		if packID, ok := r.blobPacks[blobID]; ok {
+			for _, pb := range packs {
+				if pb.PackID.Equal(packID) {
+					return pb
+				}
+			}
+		}
+	}
+	return packs[0]
+}
+
+// assignPacks selects the pack each blob stored in more than one pack is
+// restored from, preferring packs which are needed for other blobs anyway so
+// that fewer packs have to be downloaded.
+func (r *fileRestorer) assignPacks() error {
+	needed := restic.NewIDSet()
+	var duplicates restic.IDs
+	for _, file := range r.files {
+		for i, blobID := range file.blobs.(restic.IDs) {
+			if file.state.hasMatch(i) {
+				continue
+			}
+			packs, found := r.idx(blobID, restic.DataBlob)
+			if !found {
+				return errors.Errorf("Unknown blob %s", blobID.String())
+			}
+			if len(packs) == 1 {
+				needed.Insert(packs[0].PackID)
+			} else {
+				duplicates = append(duplicates, blobID)
+			}
+		}
+	}
+
+	for _, blobID := range duplicates {
+		if _, ok := r.blobPacks[blobID]; ok {
+			continue
+		}
+		packs, _ := r.idx(blobID, restic.DataBlob)
+		packID := packs[0].PackID
+		for _, pb := range packs {
+			if needed.Has(pb.PackID) {
+				packID = pb.PackID
+				break
+			}
+		}
+		r.blobPacks[blobID] = packID
+		needed.Insert(packID)
+	}
+
+	return nil
+}
+
+// neededBlobs returns the blobs which must be written to the files.
+func (r *fileRestorer) neededBlobs() restic.IDSet {
+	blobs := restic.NewIDSet()
+	for _, file := range r.files {
+		for i, blobID := range file.blobs.(restic.IDs) {
+			if !file.state.hasMatch(i) {
+				blobs.Insert(blobID)
+			}
+		}
+	}
+	return blobs
+}
+
+// copyLocalBlobs writes all blobs of the files which are available locally
+// and marks them as present. Blobs whose local copy has been modified in the
+// meantime are downloaded as usual.
+func (r *fileRestorer) copyLocalBlobs(ctx context.Context) error {
+	if len(r.localBlobs) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, file := range r.files {
+		fileOffset := int64(0)
+		for i, blobID := range file.blobs.(restic.IDs) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			length, found := r.blobLength(blobID)
+			if !found {
+				return errors.Errorf("Unknown blob %s", blobID.String())
+			}
+			offset := fileOffset
+			fileOffset += int64(length)
+
+			local, ok := r.localBlobs[blobID]
+			if !ok || file.state.hasMatch(i) {
+				continue
+			}
+
+			var err error
+			buf, err = readLocalBlob(local, buf)
+			if err != nil || !restic.Hash(buf).Equal(blobID) {
+				debug.Log("local copy of blob %v in %v is unusable: %v", blobID.Str(), local.path, err)
+				continue
+			}
+
+			createSize := int64(-1)
+			if file.flags&fileProgress == 0 {
+				file.flags |= fileProgress
+				createSize = file.size
+				if r.startFile != nil {
+					r.startFile(file.location)
+				}
+			}
+
+			err = r.filesWriter.writeToFile(r.targetPath(file.location), buf, offset, createSize)
+			if err != nil {
+				return err
+			}
+
+			if file.state == nil {
+				file.state = &fileState{blobMatches: make([]bool, len(file.blobs.(restic.IDs)))}
+			}
+			file.state.blobMatches[i] = true
+		}
 	}
 
 	return nil
 }
 
+// blobLength returns the plaintext length of the blob.
+func (r *fileRestorer) blobLength(blobID restic.ID) (uint, bool) {
+	packs, found := r.idx(blobID, restic.DataBlob)
+	if !found {
+		return 0, false
+	}
+	return packs[0].Length - crypto.Extension, true
+}
+
 func (r *fileRestorer) restoreFiles(ctx context.Context) error {
+	err := r.copyLocalBlobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.assignPacks()
+	if err != nil {
+		// repository index is messed up, can't do anything
+		return err
+	}
 
 	packs := make(map[restic.ID]*packInfo) // all packs
+	// packs in the order they are first needed by the files, so that files
+	// are completed roughly in order and only few of them are open at once
+	var packOrder []*packInfo
 
 	// create packInfo from fileInfo
 	for _, file := range r.files {
@@ -111,7 +290,14 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 			packsMap = make(map[restic.ID][]fileBlobInfo)
 		}
 		fileOffset := int64(0)
-		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob, idx int) {
+			if file.state.hasMatch(idx) {
+				fileOffset += int64(blob.Length) - crypto.Extension
+				if r.completeBlob != nil {
+					r.completeBlob(file.location, uint64(blob.Length)-crypto.Extension)
+				}
+				return
+			}
 			if largeFile {
 				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
 				fileOffset += int64(blob.Length) - crypto.Extension
@@ -123,6 +309,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 					files: make(map[*fileInfo]struct{}),
 				}
 				packs[packID] = pack
+				packOrder = append(packOrder, pack)
 			}
 			pack.files[file] = struct{}{}
 		})
@@ -133,6 +320,19 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		if largeFile {
 			file.blobs = packsMap
 		}
+
+		for i := range fileBlobs {
+			if !file.state.hasMatch(i) {
+				file.pending++
+			}
+		}
+	}
+
+	// files which were completely copied from local blobs
+	for _, file := range r.files {
+		if file.pending == 0 && file.flags&fileProgress != 0 && r.fileDone != nil {
+			r.fileDone(file.location)
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -156,8 +356,8 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		go worker()
 	}
 
-	// the main restore loop
-	for _, pack := range packs {
+	// the main restore loop, each pack is downloaded exactly once
+	for _, pack := range packOrder {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -200,8 +400,8 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 		}
 		if fileBlobs, ok := file.blobs.(restic.IDs); ok {
 			fileOffset := int64(0)
-			r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
-				if packID.Equal(pack.id) {
+			r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob, idx int) {
+				if packID.Equal(pack.id) && !file.state.hasMatch(idx) {
 					addBlob(blob, fileOffset)
 				}
 				fileOffset += int64(blob.Length) - crypto.Extension
@@ -271,19 +471,37 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 					// write other blobs after releasing the lock
 					file.lock.Lock()
 					create := file.flags&fileProgress == 0
+					createSize := int64(-1)
 					if create {
 						defer file.lock.Unlock()
 						file.flags |= fileProgress
+						createSize = file.size
+						if r.startFile != nil {
+							r.startFile(file.location)
+						}
 					} else {
 						file.lock.Unlock()
 					}
-					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, create)
+					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize)
 				}
 				err := writeToFile()
 				if err != nil {
 					markFileError(file, err)
 					break
 				}
+
+				if r.completeBlob != nil {
+					r.completeBlob(file.location, uint64(len(blobData)))
+				}
+
+				file.lock.Lock()
+				file.pending--
+				done := file.pending == 0 && file.flags&fileError == 0
+				file.lock.Unlock()
+
+				if done && r.fileDone != nil {
+					r.fileDone(file.location)
+				}
 			}
 		}
 	}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"sync"
 	"testing"
 
 	"github.com/restic/restic/internal/crypto"
@@ -123,10 +124,12 @@ func newTestRepo(content []TestFile) *TestRepo {
 	var files []*fileInfo
 	for _, file := range content {
 		content := restic.IDs{}
+		size := int64(0)
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
+			size += int64(len(blob.data))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		files = append(files, &fileInfo{location: file.name, blobs: content, size: size})
 	}
 
 	repo := &TestRepo{
@@ -203,3 +206,57 @@ func TestFileRestorerBasic(t *testing.T) {
 		},
 	})
 }
+
+func TestFileRestorerPackOnce(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	content := []TestFile{
+		TestFile{
+			name: "file1",
+			blobs: []TestBlob{
+				TestBlob{"data1-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+			},
+		},
+		TestFile{
+			name: "file2",
+			blobs: []TestBlob{
+				TestBlob{"data2-1", "pack1"},
+				TestBlob{"data1-2", "pack2"},
+			},
+		},
+		TestFile{
+			name: "file3",
+			blobs: []TestBlob{
+				// also stored in pack1, which must be used instead
+				TestBlob{"data1-1", "pack3"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	var m sync.Mutex
+	loaded := make(map[string]int)
+	loader := func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+		id, err := restic.ParseID(h.Name)
+		rtest.OK(t, err)
+		m.Lock()
+		loaded[repo.packsIDToName[id]]++
+		m.Unlock()
+		return repo.loader(ctx, h, length, offset, fn)
+	}
+
+	r := newFileRestorer(tempdir, loader, repo.key, repo.Lookup)
+	r.files = repo.files
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	rtest.Equals(t, map[string]int{"pack1": 1, "pack2": 1}, loaded)
+
+	for _, file := range repo.files {
+		data, err := ioutil.ReadFile(r.targetPath(file.location))
+		rtest.OK(t, err)
+		rtest.Equals(t, repo.fileContent(file), string(data))
+	}
+}
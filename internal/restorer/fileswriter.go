@@ -33,7 +33,10 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create bool) error {
+// writeToFile writes blob to the file at path at the given offset. If
+// createSize is not negative, the file is created if necessary and truncated to
+// createSize bytes. Data already present in an existing file is kept.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -46,8 +49,8 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		}
 
 		var flags int
-		if create {
-			flags = os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+		if createSize >= 0 {
+			flags = os.O_CREATE | os.O_WRONLY
 		} else {
 			flags = os.O_WRONLY
 		}
@@ -57,6 +60,14 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
+		if createSize >= 0 {
+			err = wr.Truncate(createSize)
+			if err != nil {
+				_ = wr.Close()
+				return nil, err
+			}
+		}
+
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
@@ -0,0 +1,61 @@
+package restorer
+
+import (
+	"github.com/restic/restic/internal/restic"
+)
+
+// hardlinkGroups keeps track of the files restored for each group of hard
+// links (nodes sharing the same inode and device ID) in a snapshot. The first
+// file of a group which is selected for restore is written to disk, all other
+// members of the group are then created as hard links to that file.
+type hardlinkGroups struct {
+	idx     *restic.HardlinkIndex
+	content map[restic.HardlinkKey]restic.IDs
+}
+
+func newHardlinkGroups(idx *restic.HardlinkIndex) *hardlinkGroups {
+	return &hardlinkGroups{
+		idx:     idx,
+		content: make(map[restic.HardlinkKey]restic.IDs),
+	}
+}
+
+// add records location as the file for the link group of node, unless the
+// group already has a file.
+func (g *hardlinkGroups) add(node *restic.Node, location string) {
+	if g.idx.Has(node.Inode, node.DeviceID) {
+		return
+	}
+
+	g.idx.Add(node.Inode, node.DeviceID, location)
+	g.content[restic.HardlinkKey{Inode: node.Inode, Device: node.DeviceID}] = node.Content
+}
+
+// linkTarget returns the location of the file node should be linked to, or the
+// empty string if node must be restored as a file of its own. This is the case
+// for the first member of a group, but also when the content recorded for node
+// differs from the other members, e.g. because the file was modified while the
+// backup was running. Linking such files would silently lose data.
+func (g *hardlinkGroups) linkTarget(node *restic.Node, location string) string {
+	if !g.idx.Has(node.Inode, node.DeviceID) {
+		return ""
+	}
+
+	target := g.idx.GetFilename(node.Inode, node.DeviceID)
+	if target == location {
+		return ""
+	}
+
+	content := g.content[restic.HardlinkKey{Inode: node.Inode, Device: node.DeviceID}]
+	if len(content) != len(node.Content) {
+		return ""
+	}
+
+	for i, id := range content {
+		if !id.Equal(node.Content[i]) {
+			return ""
+		}
+	}
+
+	return target
+}
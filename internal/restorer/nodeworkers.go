@@ -0,0 +1,110 @@
+package restorer
+
+import (
+	"context"
+	"sync"
+)
+
+// nodeWorkers creates empty files and restores the metadata of files in the
+// second pass of RestoreTo. With more than one worker, this is done
+// concurrently, which is much faster for many small files on file systems
+// where creating files and setting their attributes is expensive, e.g. NTFS.
+type nodeWorkers struct {
+	ctx context.Context
+	ch  chan nodeJob
+	wg  sync.WaitGroup
+
+	handleError func(location string, err error) error
+
+	m   sync.Mutex
+	err error
+}
+
+type nodeJob struct {
+	location string
+	fn       func() error
+}
+
+// newNodeWorkers starts count workers. For count <= 1, jobs are run
+// synchronously by run. handleError is called for all errors returned by
+// jobs, it must be safe for concurrent use.
+func newNodeWorkers(ctx context.Context, count int, handleError func(location string, err error) error) *nodeWorkers {
+	w := &nodeWorkers{
+		ctx:         ctx,
+		handleError: handleError,
+	}
+
+	if count <= 1 {
+		return w
+	}
+
+	w.ch = make(chan nodeJob)
+	for i := 0; i < count; i++ {
+		w.wg.Add(1)
+		go w.worker()
+	}
+
+	return w
+}
+
+// parallel returns true if jobs are run concurrently.
+func (w *nodeWorkers) parallel() bool {
+	return w.ch != nil
+}
+
+func (w *nodeWorkers) worker() {
+	defer w.wg.Done()
+	for job := range w.ch {
+		err := job.fn()
+		if err == nil {
+			continue
+		}
+
+		err = w.handleError(job.location, err)
+		if err != nil {
+			w.m.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.m.Unlock()
+		}
+	}
+}
+
+// run schedules fn. When jobs are run synchronously, the error of fn is
+// returned, otherwise the first error of a previous job which was not ignored
+// by handleError.
+func (w *nodeWorkers) run(location string, fn func() error) error {
+	if !w.parallel() {
+		return fn()
+	}
+
+	w.m.Lock()
+	err := w.err
+	w.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case w.ch <- nodeJob{location: location, fn: fn}:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// wait waits until all jobs have been processed and returns the first error
+// which was not ignored by handleError.
+func (w *nodeWorkers) wait() error {
+	if !w.parallel() {
+		return nil
+	}
+
+	close(w.ch)
+	w.wg.Wait()
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.err
+}
@@ -0,0 +1,7 @@
+// +build !windows
+
+package restorer
+
+// nodeWorkerCount is the number of workers which create empty files and
+// restore the metadata of files.
+var nodeWorkerCount = 1
@@ -0,0 +1,8 @@
+// +build windows
+
+package restorer
+
+// nodeWorkerCount is the number of workers which create empty files and
+// restore the metadata of files. On NTFS, both are expensive per file, so
+// they are done concurrently.
+var nodeWorkerCount = 16
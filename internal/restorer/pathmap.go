@@ -0,0 +1,74 @@
+package restorer
+
+import (
+	"path/filepath"
+
+	"github.com/restic/restic/internal/fs"
+)
+
+// PathMapping moves the node at From in the snapshot and everything below it
+// to To within the target directory. Both are slash-separated paths as shown
+// by `restic ls`, e.g. "/C/Data". Stripping a prefix is expressed as a mapping
+// to "/".
+type PathMapping struct {
+	From, To string
+}
+
+// mapLocation returns the path relative to the target directory the node at
+// location is restored to, using the longest matching PathMapping. If location
+// is a parent directory of a mapped path, restore is false: the node itself
+// is not restored, but its children are visited. If location is not affected
+// by any PathMapping and SkipUnmapped is set, skip is true: neither the node
+// nor its children are restored.
+func (res *Restorer) mapLocation(location string) (mapped string, restore, skip bool) {
+	sep := string(filepath.Separator)
+
+	var best *PathMapping
+	var bestFrom string
+	parent := false
+	for i := range res.PathMappings {
+		m := &res.PathMappings[i]
+		from := filepath.Join(sep, filepath.FromSlash(m.From))
+
+		if fs.HasPathPrefix(from, location) {
+			if best == nil || len(from) > len(bestFrom) {
+				best, bestFrom = m, from
+			}
+			continue
+		}
+
+		if fs.HasPathPrefix(location, from) {
+			parent = true
+		}
+	}
+
+	if best == nil {
+		if parent {
+			return location, false, false
+		}
+		return location, true, res.SkipUnmapped
+	}
+
+	rel, err := filepath.Rel(bestFrom, location)
+	if err != nil {
+		// cannot happen, location is below bestFrom
+		return location, !parent, false
+	}
+
+	return filepath.Join(sep, filepath.FromSlash(best.To), rel), true, false
+}
+
+// targetLocation returns the location of target relative to dst, which is
+// used to identify files written to the target directory. Without path
+// mappings, this is the same as the location within the snapshot.
+func (res *Restorer) targetLocation(dst, target, location string) string {
+	if len(res.PathMappings) == 0 {
+		return location
+	}
+
+	rel, err := filepath.Rel(dst, target)
+	if err != nil {
+		return location
+	}
+	return filepath.Join(string(filepath.Separator), rel)
+}
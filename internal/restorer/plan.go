@@ -0,0 +1,152 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Action describes what a restore does with a single item.
+type Action int
+
+// These are the actions reported by Plan.
+const (
+	// ActionCreate creates an item which does not exist yet.
+	ActionCreate Action = iota
+	// ActionOverwrite replaces an existing item.
+	ActionOverwrite
+	// ActionUpdate only restores the metadata of an existing directory or
+	// removes trailing data from an existing file with unchanged content.
+	ActionUpdate
+	// ActionSkip leaves an existing, unchanged file alone.
+	ActionSkip
+	// ActionDelete removes an item which is not in the snapshot.
+	ActionDelete
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionCreate:
+		return "create"
+	case ActionOverwrite:
+		return "overwrite"
+	case ActionUpdate:
+		return "update"
+	case ActionSkip:
+		return "skip"
+	case ActionDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// PlannedItem is an item in the target directory and what a restore would do
+// with it.
+type PlannedItem struct {
+	Location string
+	Target   string
+	Action   Action
+
+	// Node is the node from the snapshot, it is nil for deleted items.
+	Node *restic.Node
+
+	// Conflict is set if an item exists in the target directory, but has a
+	// different type than the node in the snapshot.
+	Conflict bool
+}
+
+// Plan calls fn for all items RestoreTo would restore to or remove from dst,
+// without modifying anything. The content of existing files is only read when
+// Overwrite is OverwriteIfChanged. Plan does not take the state of an
+// interrupted restore into account.
+func (res *Restorer) Plan(ctx context.Context, dst string, fn func(PlannedItem) error) error {
+	if res.Delete && len(res.PathMappings) > 0 {
+		return errors.New("deleting files cannot be combined with path mappings")
+	}
+
+	var findUnexpected func(tree *restic.Tree, target, location string) error
+	if res.Delete {
+		findUnexpected = func(tree *restic.Tree, target, location string) error {
+			unexpected, err := res.findUnexpected(tree, target, location)
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range unexpected {
+				if tree.Find(filepath.Base(entry.target)) != nil {
+					// type mismatch, reported as a conflict for the node
+					continue
+				}
+
+				err = fn(PlannedItem{
+					Location: entry.location,
+					Target:   entry.target,
+					Action:   ActionDelete,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	visit := func(node *restic.Node, target, location string) error {
+		item, err := res.planItem(node, target, location)
+		if err != nil {
+			return err
+		}
+		return fn(item)
+	}
+
+	return res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		enterDir:  visit,
+		visitNode: visit,
+		leaveDir:  func(*restic.Node, string, string) error { return nil },
+		visitTree: findUnexpected,
+	})
+}
+
+func (res *Restorer) planItem(node *restic.Node, target, location string) (PlannedItem, error) {
+	item := PlannedItem{
+		Location: location,
+		Target:   target,
+		Node:     node,
+		Action:   ActionCreate,
+	}
+
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return item, nil
+	}
+	if err != nil {
+		return item, errors.Wrap(err, "Lstat")
+	}
+
+	item.Action = ActionOverwrite
+	item.Conflict = restic.NodeTypeFromFileInfo(fi) != node.Type
+
+	switch {
+	case item.Conflict:
+	case node.Type == "dir":
+		item.Action = ActionUpdate
+	case node.Type == "file" && res.Overwrite == OverwriteIfChanged && node.Links <= 1:
+		// the plan must not modify the target, so only compare the file
+		_, unchanged, truncate, err := res.compareExistingFile(node, target)
+		if err != nil {
+			return item, err
+		}
+		switch {
+		case unchanged && truncate:
+			item.Action = ActionUpdate
+		case unchanged:
+			item.Action = ActionSkip
+		}
+	}
+
+	return item, nil
+}
@@ -0,0 +1,157 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// RemoteTarget is a directory tree on a different host which files can be
+// restored to. Paths are separated by slashes.
+type RemoteTarget interface {
+	MkdirAll(dir string) error
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	Symlink(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	IsNotExist(err error) bool
+	IsPermission(err error) bool
+}
+
+// RestoreToRemote restores the snapshot to the directory dst on target. Only
+// directories, regular files and symlinks are restored, files are written one
+// after the other. Overwrite, Delete and Resume are not supported, extended
+// attributes are not restored.
+func (res *Restorer) RestoreToRemote(ctx context.Context, target RemoteTarget, dst string) error {
+	debug.Log("restore %v to remote %v", res.sn.ID().Str(), dst)
+
+	// traverseTree checks the local target paths, so use dst as the base
+	localDst := filepath.FromSlash(dst)
+	remotePath := func(target string) string {
+		rel, err := filepath.Rel(localDst, target)
+		if err != nil {
+			rel = "."
+		}
+		return path.Join(dst, filepath.ToSlash(rel))
+	}
+
+	err := target.MkdirAll(dst)
+	if err != nil {
+		return err
+	}
+
+	res.metadataSkipped = 0
+
+	return res.traverseTree(ctx, localDst, localDst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		enterDir: func(node *restic.Node, nodeTarget, location string) error {
+			return target.MkdirAll(remotePath(nodeTarget))
+		},
+		visitNode: func(node *restic.Node, nodeTarget, location string) error {
+			p := remotePath(nodeTarget)
+
+			switch node.Type {
+			case "file":
+				err := res.restoreRemoteFile(ctx, target, node, p, location)
+				if err != nil {
+					return err
+				}
+			case "symlink":
+				err := target.Remove(p)
+				if err != nil && !target.IsNotExist(err) {
+					debug.Log("unable to remove %v: %v", p, err)
+				}
+				// the metadata of symlinks cannot be set via SFTP
+				return target.Symlink(node.LinkTarget, p)
+			default:
+				return errors.Errorf("nodes of type %q cannot be restored to a remote target", node.Type)
+			}
+
+			return res.restoreRemoteMetadata(target, node, p)
+		},
+		leaveDir: func(node *restic.Node, nodeTarget, location string) error {
+			return res.restoreRemoteMetadata(target, node, remotePath(nodeTarget))
+		},
+	})
+}
+
+func (res *Restorer) restoreRemoteFile(ctx context.Context, target RemoteTarget, node *restic.Node, p, location string) error {
+	f, err := target.Create(p)
+	if err != nil {
+		return err
+	}
+
+	res.StartFile(location)
+
+	var buf []byte
+	for _, id := range node.Content {
+		buf, err = res.repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		_, err = f.Write(buf)
+		if err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "Write")
+		}
+
+		res.CompleteBlob(location, uint64(len(buf)))
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	res.CompleteFile(location, node.Size, false)
+	return nil
+}
+
+// restoreRemoteMetadata restores owner, permissions and timestamps of the
+// remote file p, honoring MetadataOptions.
+func (res *Restorer) restoreRemoteMetadata(target RemoteTarget, node *restic.Node, p string) error {
+	opts := res.MetadataOptions
+	if opts.SkipsAny() {
+		atomic.AddInt64(&res.metadataSkipped, 1)
+	}
+
+	var firsterr error
+
+	if !opts.NoOwner {
+		if err := target.Chown(p, int(node.UID), int(node.GID)); err != nil {
+			// Like restoring locally as a user other than root, permission
+			// errors are only logged: they mean that the user on the remote
+			// host is not allowed to change the owner.
+			if target.IsPermission(err) {
+				debug.Log("ignoring chown permission error for %v: %v", p, err)
+			} else {
+				firsterr = err
+			}
+		}
+	}
+
+	if !opts.NoPermissions {
+		if err := target.Chmod(p, node.Mode); err != nil && firsterr == nil {
+			firsterr = err
+		}
+	}
+
+	if !opts.NoTimes {
+		if err := target.Chtimes(p, node.AccessTime, node.ModTime); err != nil && firsterr == nil {
+			firsterr = err
+		}
+	}
+
+	return firsterr
+}
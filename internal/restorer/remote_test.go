@@ -0,0 +1,151 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// dirTarget implements RemoteTarget for a local directory.
+type dirTarget struct {
+	base string
+
+	// chownErr is returned by Chown
+	chownErr error
+}
+
+func (t dirTarget) path(name string) string {
+	return filepath.Join(t.base, filepath.FromSlash(name))
+}
+
+func (t dirTarget) MkdirAll(dir string) error {
+	return os.MkdirAll(t.path(dir), 0700)
+}
+
+func (t dirTarget) Create(name string) (io.WriteCloser, error) {
+	return os.Create(t.path(name))
+}
+
+func (t dirTarget) Remove(name string) error {
+	return os.Remove(t.path(name))
+}
+
+func (t dirTarget) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, t.path(newname))
+}
+
+func (t dirTarget) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(t.path(name), mode)
+}
+
+func (t dirTarget) Chown(name string, uid, gid int) error {
+	return t.chownErr
+}
+
+func (t dirTarget) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(t.path(name), atime, mtime)
+}
+
+func (t dirTarget) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (t dirTarget) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+func TestRestorerRestoreToRemote(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	modTime := time.Date(2019, 11, 2, 10, 0, 0, 0, time.UTC)
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n", ModTime: modTime},
+				},
+			},
+			"foo":   File{Data: "content: foo\n"},
+			"empty": File{Data: ""},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreToRemote(ctx, dirTarget{base: tempdir}, "/srv/restore"))
+
+	for name, data := range map[string]string{
+		"dir/file": "content: file\n",
+		"foo":      "content: foo\n",
+		"empty":    "",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(tempdir, "srv", "restore", filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+
+	fi, err := os.Stat(filepath.Join(tempdir, "srv", "restore", "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(modTime), "wrong modification time, want %v, got %v", modTime, fi.ModTime())
+}
+
+func TestRestorerRestoreToRemoteChown(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	})
+
+	var tests = []struct {
+		err  error
+		fail bool
+	}{
+		{nil, false},
+		// the user on the remote host may not change the owner
+		{os.ErrPermission, false},
+		{errors.New("connection lost"), true},
+	}
+
+	for _, test := range tests {
+		tempdir, cleanup := rtest.TempDir(t)
+
+		res, err := NewRestorer(repo, id)
+		rtest.OK(t, err)
+
+		var errs []error
+		res.Error = func(location string, err error) error {
+			errs = append(errs, err)
+			return nil
+		}
+
+		err = res.RestoreToRemote(context.TODO(), dirTarget{base: tempdir, chownErr: test.err}, "/restore")
+		rtest.OK(t, err)
+		rtest.Assert(t, (len(errs) > 0) == test.fail, "chown error %v: unexpected errors %v", test.err, errs)
+
+		buf, err := ioutil.ReadFile(filepath.Join(tempdir, "restore", "foo"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: foo\n", string(buf))
+
+		cleanup()
+	}
+}
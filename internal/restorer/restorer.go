@@ -2,8 +2,11 @@ package restorer
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
@@ -15,13 +18,89 @@ import (
 
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
+	// accessed atomically, must be the first field for 64 bit alignment
+	metadataSkipped int64
+
 	repo restic.Repository
 	sn   *restic.Snapshot
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// MetadataOptions selects metadata which is not restored, e.g. the
+	// owner when restoring as an unprivileged user.
+	MetadataOptions restic.RestoreMetadataOptions
+
+	// Overwrite controls how files which already exist in the target
+	// directory are handled.
+	Overwrite OverwriteBehavior
+
+	// Delete removes files and directories from the target directory which
+	// are not contained in the snapshot, but would have been selected by
+	// SelectFilter.
+	Delete bool
+
+	// Resume records the progress in a state file in the target directory.
+	// When a restore of the same snapshot is interrupted, files which have
+	// already been restored completely are skipped by the next restore.
+	Resume bool
+
+	// UnsupportedMetadata is called for items with data which cannot be
+	// restored on this platform, see restic.Node.UnsupportedMetadata. If it
+	// returns an error, the error is passed to Error.
+	UnsupportedMetadata func(location string, kinds []string) error
+
+	// PathMappings moves parts of the snapshot to different paths within the
+	// target directory. They cannot be combined with Delete.
+	PathMappings []PathMapping
+
+	// SkipUnmapped skips all nodes which are neither below nor a parent
+	// directory of the From path of one of the PathMappings.
+	SkipUnmapped bool
+
+	// Seed is a list of local directories, e.g. an older restore of the
+	// snapshot. Data found in files below them is copied from there instead
+	// of downloading it from the repository.
+	Seed []string
+
+	// CheckSpace verifies that the file system of the target directory has
+	// enough free space and inodes before anything is written. If not,
+	// RestoreTo returns an InsufficientSpaceError.
+	CheckSpace bool
+
+	// ReportTotal is called once with the number and size of all files
+	// selected for restore.
+	ReportTotal func(files, bytes uint64)
+
+	// StartFile is called when data is first written to a file.
+	StartFile func(location string)
+
+	// CompleteBlob is called for all blobs written to files.
+	CompleteBlob func(location string, bytes uint64)
+
+	// CompleteFile is called when a file has been restored. If the file did
+	// not need to be written, e.g. because it was unchanged, skipped is true.
+	//
+	// StartFile, CompleteBlob and CompleteFile may be called asynchronously
+	// from several different goroutines!
+	CompleteFile func(location string, size uint64, skipped bool)
+
+	state *restoreState
 }
 
+// OverwriteBehavior controls how existing files in the target directory are
+// handled during restore.
+type OverwriteBehavior int
+
+const (
+	// OverwriteAlways replaces all existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged skips files whose size and modification time match
+	// the snapshot. For all other existing files, only the parts which differ
+	// from the snapshot are rewritten.
+	OverwriteIfChanged
+)
+
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
 
 // NewRestorer creates a restorer preloaded with the content from the snapshot id.
@@ -30,6 +109,12 @@ func NewRestorer(repo restic.Repository, id restic.ID) (*Restorer, error) {
 		repo:         repo,
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
+		ReportTotal:  func(uint64, uint64) {},
+		StartFile:    func(string) {},
+		CompleteBlob: func(string, uint64) {},
+		CompleteFile: func(string, uint64, bool) {},
+
+		UnsupportedMetadata: func(string, []string) error { return nil },
 	}
 
 	var err error
@@ -46,11 +131,15 @@ type treeVisitor struct {
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
 	leaveDir  func(node *restic.Node, target, location string) error
+
+	// visitTree is called for each tree before its nodes are visited, may be nil
+	visitTree func(tree *restic.Tree, target, location string) error
 }
 
 // traverseTree traverses a tree from the repo and calls treeVisitor.
-// target is the path in the file system, location within the snapshot.
-func (res *Restorer) traverseTree(ctx context.Context, target, location string, treeID restic.ID, visitor treeVisitor) error {
+// target is the path in the file system, location within the snapshot. root
+// is the target directory of the restore.
+func (res *Restorer) traverseTree(ctx context.Context, root, target, location string, treeID restic.ID, visitor treeVisitor) error {
 	debug.Log("%v %v %v", target, location, treeID)
 	tree, err := res.repo.LoadTree(ctx, treeID)
 	if err != nil {
@@ -58,6 +147,16 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		return res.Error(location, err)
 	}
 
+	if visitor.visitTree != nil {
+		err = visitor.visitTree(tree, target, location)
+		if err != nil {
+			err = res.Error(location, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, node := range tree.Nodes {
 
 		// ensure that the node name does not contain anything that refers to a
@@ -75,7 +174,20 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		nodeTarget := filepath.Join(target, nodeName)
 		nodeLocation := filepath.Join(location, nodeName)
 
-		if target == nodeTarget || !fs.HasPathPrefix(target, nodeTarget) {
+		invalidTarget := target == nodeTarget || !fs.HasPathPrefix(target, nodeTarget)
+		restore := true
+		if len(res.PathMappings) > 0 {
+			var mapped string
+			var skip bool
+			mapped, restore, skip = res.mapLocation(nodeLocation)
+			if skip {
+				continue
+			}
+			nodeTarget = filepath.Join(root, mapped)
+			invalidTarget = !fs.HasPathPrefix(root, nodeTarget) || (nodeTarget == root && node.Type != "dir")
+		}
+
+		if invalidTarget {
 			debug.Log("target: %v %v", target, nodeTarget)
 			debug.Log("node %q has invalid target path %q", node.Name, nodeTarget)
 			err := res.Error(nodeLocation, errors.New("node has invalid path"))
@@ -93,6 +205,11 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		selectedForRestore, childMayBeSelected := res.SelectFilter(nodeLocation, nodeTarget, node)
 		debug.Log("SelectFilter returned %v %v", selectedForRestore, childMayBeSelected)
 
+		if !restore {
+			// parent directory of a mapped path, only visit the children
+			selectedForRestore = false
+		}
+
 		sanitizeError := func(err error) error {
 			if err != nil {
 				err = res.Error(nodeLocation, err)
@@ -113,7 +230,7 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 			}
 
 			if childMayBeSelected {
-				err = sanitizeError(res.traverseTree(ctx, nodeTarget, nodeLocation, *node.Subtree, visitor))
+				err = sanitizeError(res.traverseTree(ctx, root, nodeTarget, nodeLocation, *node.Subtree, visitor))
 				if err != nil {
 					return err
 				}
@@ -143,6 +260,11 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, target, location string) error {
 	debug.Log("restoreNode %v %v %v", node.Name, target, location)
 
+	if !node.SupportedType() {
+		debug.Log("skipping node of unsupported type %v", node.Type)
+		return res.reportUnsupported(node, location)
+	}
+
 	err := node.CreateAt(ctx, target, res.repo)
 	if err != nil {
 		debug.Log("node.CreateAt(%s) error %v", target, err)
@@ -154,9 +276,27 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 	return err
 }
 
+// reportUnsupported passes the data of node which cannot be restored on this
+// platform to UnsupportedMetadata.
+func (res *Restorer) reportUnsupported(node *restic.Node, location string) error {
+	kinds := node.UnsupportedMetadata(res.MetadataOptions)
+	if len(kinds) == 0 {
+		return nil
+	}
+	return res.UnsupportedMetadata(location, kinds)
+}
+
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
-	err := node.RestoreMetadata(target)
+	if res.MetadataOptions.SkipsAny() {
+		atomic.AddInt64(&res.metadataSkipped, 1)
+	}
+
+	if err := res.reportUnsupported(node, location); err != nil {
+		return err
+	}
+
+	err := node.RestoreMetadataWithOptions(target, res.MetadataOptions)
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
 	}
@@ -164,7 +304,7 @@ func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location s
 }
 
 func (res *Restorer) restoreHardlinkAt(node *restic.Node, target, path, location string) error {
-	if err := fs.Remove(path); !os.IsNotExist(err) {
+	if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "RemoveCreateHardlink")
 	}
 	err := fs.Link(target, path)
@@ -188,6 +328,168 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// unexpectedEntry is an entry in the target directory which does not belong
+// there according to the snapshot.
+type unexpectedEntry struct {
+	target, location string
+}
+
+// findUnexpected returns all files and directories in target which are not
+// part of tree, but would have been selected for restore. Entries whose type
+// (directory or not) does not match the snapshot are returned as well.
+func (res *Restorer) findUnexpected(tree *restic.Tree, target, location string) ([]unexpectedEntry, error) {
+	dir, err := fs.Open(target)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	entries, err := dir.Readdir(-1)
+	_ = dir.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "Readdir")
+	}
+
+	expected := make(map[string]*restic.Node, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		expected[node.Name] = node
+	}
+
+	var unexpected []unexpectedEntry
+	for _, fi := range entries {
+		nodeTarget := filepath.Join(target, fi.Name())
+		nodeLocation := filepath.Join(location, fi.Name())
+
+		if res.state != nil && res.state.isStateFile(nodeTarget) {
+			continue
+		}
+
+		node, ok := expected[fi.Name()]
+		if ok && (node.Type == "dir") == fi.IsDir() {
+			continue
+		}
+
+		if !ok {
+			node = &restic.Node{Name: fi.Name(), Type: "file"}
+			if fi.IsDir() {
+				node.Type = "dir"
+			}
+		}
+
+		selectedForRestore, _ := res.SelectFilter(nodeLocation, nodeTarget, node)
+		if !selectedForRestore {
+			continue
+		}
+
+		unexpected = append(unexpected, unexpectedEntry{target: nodeTarget, location: nodeLocation})
+	}
+
+	return unexpected, nil
+}
+
+// removeUnexpected removes all entries in target returned by findUnexpected.
+func (res *Restorer) removeUnexpected(tree *restic.Tree, target, location string) error {
+	unexpected, err := res.findUnexpected(tree, target, location)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range unexpected {
+		debug.Log("removing %v", entry.target)
+		err = fs.RemoveAll(entry.target)
+		if err != nil {
+			err = res.Error(entry.location, errors.Wrap(err, "RemoveAll"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkExistingFile compares the file at target with the content of node. If
+// the file does not need to be restored, unchanged is true and trailing data
+// after the content of node has been removed. Otherwise state describes which
+// blobs of node are already present in the file, it is nil if the file does
+// not exist.
+func (res *Restorer) checkExistingFile(node *restic.Node, target string) (state *fileState, unchanged bool, err error) {
+	state, unchanged, truncate, err := res.compareExistingFile(node, target)
+	if err != nil || !truncate {
+		return state, unchanged, err
+	}
+
+	// only trailing data needs to be removed
+	err = fs.Truncate(target, int64(node.Size))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Truncate")
+	}
+
+	return nil, true, nil
+}
+
+// compareExistingFile is like checkExistingFile, but does not modify the file
+// at target. If the file only has trailing data after the content of node,
+// unchanged and truncate are both true.
+func (res *Restorer) compareExistingFile(node *restic.Node, target string) (state *fileState, unchanged, truncate bool, err error) {
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil, false, false, nil
+	}
+	if err != nil {
+		return nil, false, false, errors.Wrap(err, "Lstat")
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, false, false, nil
+	}
+
+	if fi.Size() == int64(node.Size) && fi.ModTime().Equal(node.ModTime) {
+		return nil, true, false, nil
+	}
+
+	f, err := fs.OpenFile(target, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false, false, errors.Wrap(err, "OpenFile")
+	}
+	defer f.Close()
+
+	state = &fileState{blobMatches: make([]bool, len(node.Content))}
+	allMatch := true
+	offset := int64(0)
+	var buf []byte
+	for i, blobID := range node.Content {
+		length, found := res.repo.LookupBlobSize(blobID, restic.DataBlob)
+		if !found {
+			return nil, false, false, errors.Errorf("Unknown blob %s", blobID.String())
+		}
+
+		if uint(cap(buf)) < length {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, false, false, errors.Wrap(err, "ReadAt")
+		}
+
+		state.blobMatches[i] = n == len(buf) && restic.Hash(buf).Equal(blobID)
+		if !state.blobMatches[i] {
+			allMatch = false
+		}
+		offset += int64(length)
+	}
+
+	if !allMatch {
+		return state, false, false, nil
+	}
+
+	return nil, true, fi.Size() != int64(node.Size), nil
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
@@ -199,17 +501,67 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		}
 	}
 
-	restoreNodeMetadata := func(node *restic.Node, target, location string) error {
-		return res.restoreNodeMetadataTo(node, target, location)
+	if res.Delete && len(res.PathMappings) > 0 {
+		return errors.New("deleting files cannot be combined with path mappings")
 	}
+
+	if res.CheckSpace {
+		err = res.checkSpace(ctx, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	res.metadataSkipped = 0
+
 	noop := func(node *restic.Node, target, location string) error { return nil }
 
+	var removeUnexpected func(tree *restic.Tree, target, location string) error
+	if res.Delete {
+		removeUnexpected = res.removeUnexpected
+	}
+
 	idx := restic.NewHardlinkIndex()
+	hardlinks := newHardlinkGroups(idx)
 
 	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	filerestorer.startFile = res.StartFile
+	filerestorer.completeBlob = res.CompleteBlob
+
+	var totalFiles, totalBytes uint64
+	fileSizes := make(map[string]uint64)
+	filerestorer.fileDone = func(location string) {
+		res.CompleteFile(location, fileSizes[location], false)
+	}
+
+	if res.Resume {
+		err = fs.MkdirAll(dst, 0700)
+		if err != nil {
+			return errors.Wrap(err, "MkdirAll")
+		}
+
+		res.state, err = openRestoreState(filepath.Join(dst, stateFilename), *res.sn.ID())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if res.state != nil {
+				_ = res.state.close()
+				res.state = nil
+			}
+		}()
+
+		filerestorer.fileDone = func(location string) {
+			err := res.state.markDone(location)
+			if err != nil {
+				debug.Log("unable to record %v in restore state: %v", location, err)
+			}
+			res.CompleteFile(location, fileSizes[location], false)
+		}
+	}
 
 	// first tree pass: create directories and collect all files to restore
-	err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	err = res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: func(node *restic.Node, target, location string) error {
 			// create dir with default permissions
 			// #leaveDir restores dir metadata after visiting all children
@@ -228,56 +580,187 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				return nil
 			}
 
+			totalFiles++
+
 			if node.Size == 0 {
 				return nil // deal with empty files later
 			}
 
+			// files are identified by their path within dst
+			location = res.targetLocation(dst, target, location)
+
 			if node.Links > 1 {
-				if idx.Has(node.Inode, node.DeviceID) {
+				if hardlinks.linkTarget(node, location) != "" {
+					return nil // the link is created in the second pass
+				}
+				hardlinks.add(node, location)
+			}
+
+			totalBytes += node.Size
+
+			if res.state != nil && res.state.isDone(location) {
+				fi, err := fs.Lstat(target)
+				if err == nil && fi.Mode().IsRegular() && fi.Size() == int64(node.Size) {
+					debug.Log("skipping %v, already restored", target)
+					res.CompleteFile(location, node.Size, true)
 					return nil
 				}
-				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
-			filerestorer.addFile(location, node.Content)
+			var state *fileState
+			if res.Overwrite == OverwriteIfChanged {
+				var unchanged bool
+				state, unchanged, err = res.checkExistingFile(node, target)
+				if err != nil {
+					return err
+				}
+				if unchanged {
+					debug.Log("skipping unchanged file %v", target)
+					res.CompleteFile(location, node.Size, true)
+					return nil
+				}
+			}
+
+			fileSizes[location] = node.Size
+			filerestorer.addFile(location, node.Content, int64(node.Size), state)
 
 			return nil
 		},
-		leaveDir: noop,
+		leaveDir:  noop,
+		visitTree: removeUnexpected,
 	})
 	if err != nil {
 		return err
 	}
 
+	res.ReportTotal(totalFiles, totalBytes)
+
+	if len(res.Seed) > 0 {
+		filerestorer.localBlobs, err = res.scanSeed(ctx, filerestorer.neededBlobs())
+		if err != nil {
+			return err
+		}
+	}
+
 	err = filerestorer.restoreFiles(ctx)
 	if err != nil {
 		return err
 	}
 
 	// second tree pass: restore special files and filesystem metadata
-	return res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	if nodeWorkerCount > 1 {
+		// the callbacks are called concurrently by the node workers
+		var m sync.Mutex
+		errorFn, unsupportedFn := res.Error, res.UnsupportedMetadata
+		res.Error = func(location string, err error) error {
+			m.Lock()
+			defer m.Unlock()
+			return errorFn(location, err)
+		}
+		res.UnsupportedMetadata = func(location string, kinds []string) error {
+			m.Lock()
+			defer m.Unlock()
+			return unsupportedFn(location, kinds)
+		}
+		defer func() {
+			res.Error, res.UnsupportedMetadata = errorFn, unsupportedFn
+		}()
+	}
+
+	workers := newNodeWorkers(ctx, nodeWorkerCount, func(location string, err error) error {
+		return res.Error(location, err)
+	})
+
+	// when files are restored concurrently, the metadata of directories is
+	// restored at the end, as creating a file modifies its directory
+	type deferredDir struct {
+		node             *restic.Node
+		target, location string
+	}
+	var dirs []deferredDir
+
+	err = res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: noop,
 		visitNode: func(node *restic.Node, target, location string) error {
 			if node.Type != "file" {
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
 
+			fileLocation := res.targetLocation(dst, target, location)
+
+			if node.Links > 1 {
+				if linkTarget := hardlinks.linkTarget(node, fileLocation); linkTarget != "" {
+					err := res.restoreHardlinkAt(node, filerestorer.targetPath(linkTarget), target, location)
+					if err == nil {
+						res.CompleteFile(fileLocation, 0, false)
+					}
+					return err
+				}
+			}
+
 			// create empty files, but not hardlinks to empty files
-			if node.Size == 0 && (node.Links < 2 || !idx.Has(node.Inode, node.DeviceID)) {
+			if node.Size == 0 {
+				restoreEmptyFile := func() error {
+					err := res.restoreEmptyFileAt(node, target, location)
+					if err == nil {
+						res.CompleteFile(fileLocation, 0, false)
+					}
+					return err
+				}
+
 				if node.Links > 1 {
-					idx.Add(node.Inode, node.DeviceID, location)
+					// further links to the file are created right away
+					hardlinks.add(node, fileLocation)
+					return restoreEmptyFile()
 				}
-				return res.restoreEmptyFileAt(node, target, location)
+				return workers.run(location, restoreEmptyFile)
 			}
 
-			if idx.Has(node.Inode, node.DeviceID) && idx.GetFilename(node.Inode, node.DeviceID) != location {
-				return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.GetFilename(node.Inode, node.DeviceID)), target, location)
+			return workers.run(location, func() error {
+				return res.restoreNodeMetadataTo(node, target, location)
+			})
+		},
+		leaveDir: func(node *restic.Node, target, location string) error {
+			if !workers.parallel() {
+				return res.restoreNodeMetadataTo(node, target, location)
 			}
-
-			return res.restoreNodeMetadataTo(node, target, location)
+			dirs = append(dirs, deferredDir{node: node, target: target, location: location})
+			return nil
 		},
-		leaveDir: restoreNodeMetadata,
 	})
+	if werr := workers.wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		err = res.restoreNodeMetadataTo(dir.node, dir.target, dir.location)
+		if err != nil {
+			err = res.Error(dir.location, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if res.state != nil {
+		err = res.state.remove()
+		res.state = nil
+		if err != nil {
+			return errors.Wrap(err, "remove restore state")
+		}
+	}
+
+	return nil
+}
+
+// MetadataSkipped returns the number of items restored by the last call to
+// RestoreTo for which some metadata was skipped as selected by
+// MetadataOptions.
+func (res *Restorer) MetadataSkipped() int {
+	return int(atomic.LoadInt64(&res.metadataSkipped))
 }
 
 // Snapshot returns the snapshot this restorer is configured to use.
@@ -290,7 +773,7 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 	// TODO multithreaded?
 
 	count := 0
-	err := res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	err := res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: func(node *restic.Node, target, location string) error { return nil },
 		visitNode: func(node *restic.Node, target, location string) error {
 			if node.Type != "file" {
@@ -3,14 +3,19 @@ package restorer
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/limiter"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -24,9 +29,10 @@ type Snapshot struct {
 }
 
 type File struct {
-	Data  string
-	Links uint64
-	Inode uint64
+	Data    string
+	Links   uint64
+	Inode   uint64
+	ModTime time.Time
 }
 
 type Dir struct {
@@ -77,6 +83,7 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				Size:    uint64(len(n.(File).Data)),
 				Inode:   fi,
 				Links:   lc,
+				ModTime: node.ModTime,
 			})
 		case Dir:
 			id := saveDir(t, repo, node.Nodes, inode)
@@ -687,10 +694,590 @@ func TestRestorerTraverseTree(t *testing.T) {
 			// make sure we're creating a new subdir of the tempdir
 			target := filepath.Join(tempdir, "target")
 
-			err = res.traverseTree(ctx, target, string(filepath.Separator), *sn.Tree, test.Visitor(t))
+			err = res.traverseTree(ctx, target, target, string(filepath.Separator), *sn.Tree, test.Visitor(t))
 			if err != nil {
 				t.Fatal(err)
 			}
 		})
 	}
 }
+
+func TestRestorerOverwriteIfChanged(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	modTime := time.Date(2019, 12, 1, 10, 12, 13, 0, time.UTC)
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"unchanged": File{Data: "content: unchanged\n", ModTime: modTime},
+			"modified":  File{Data: "content: modified\n", ModTime: modTime},
+			"truncated": File{Data: "content: truncated\n", ModTime: modTime},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	// same size and modification time, this file is not restored again
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "unchanged"), []byte("CONTENT: UNCHANGED\n"), 0600))
+	rtest.OK(t, os.Chtimes(filepath.Join(tempdir, "unchanged"), modTime, modTime))
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "modified"), []byte("content: xxxxxxxx\n"), 0600))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "truncated"), []byte("content: truncated\ntrailing data"), 0600))
+
+	res, err = NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"unchanged": "CONTENT: UNCHANGED\n",
+		"modified":  "content: modified\n",
+		"truncated": "content: truncated\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+
+		fi, err := os.Stat(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.ModTime().Equal(modTime), "%v: modification time not restored, got %v", filename, fi.ModTime())
+	}
+}
+
+func TestRestorerDelete(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+			"file":     File{Data: "content: file\n"},
+			"filedir":  File{Data: "content: filedir\n"},
+			"excluded": File{Data: "content: excluded\n"},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, dir := range []string{"dir/extradir/subdir", "filedir/subdir", "excluded.d"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, dir), 0700))
+	}
+	for _, file := range []string{"extrafile", "dir/extrafile", "dir/extradir/subdir/file", "excluded.d/file"} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, file), []byte("extra"), 0600))
+	}
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Delete = true
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		selected := !strings.HasPrefix(item, "/excluded")
+		return selected, selected && node.Type == "dir"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	var files []string
+	rtest.OK(t, filepath.Walk(tempdir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			rel, err := filepath.Rel(tempdir, path)
+			rtest.OK(t, err)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	}))
+
+	rtest.Equals(t, []string{"dir/file", "excluded.d/file", "file", "filedir"}, files)
+}
+
+func TestRestorerProgress(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+			"file":  File{Data: "content: foo\n"},
+			"empty": File{Data: ""},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type progress struct {
+		totalFiles, totalBytes uint64
+		blobBytes              uint64
+		completed              map[string]uint64
+		skipped                map[string]uint64
+	}
+
+	restore := func(overwrite OverwriteBehavior) progress {
+		p := progress{
+			completed: make(map[string]uint64),
+			skipped:   make(map[string]uint64),
+		}
+		var m sync.Mutex
+
+		res, err := NewRestorer(repo, id)
+		rtest.OK(t, err)
+		res.Overwrite = overwrite
+		res.ReportTotal = func(files, bytes uint64) {
+			p.totalFiles, p.totalBytes = files, bytes
+		}
+		res.CompleteBlob = func(location string, bytes uint64) {
+			m.Lock()
+			p.blobBytes += bytes
+			m.Unlock()
+		}
+		res.CompleteFile = func(location string, size uint64, skipped bool) {
+			m.Lock()
+			location = filepath.ToSlash(location)
+			if skipped {
+				p.skipped[location] = size
+			} else {
+				p.completed[location] = size
+			}
+			m.Unlock()
+		}
+
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+		return p
+	}
+
+	p := restore(OverwriteAlways)
+	rtest.Equals(t, uint64(3), p.totalFiles)
+	rtest.Equals(t, uint64(27), p.totalBytes)
+	rtest.Equals(t, uint64(27), p.blobBytes)
+	rtest.Equals(t, map[string]uint64{"/dir/file": 14, "/file": 13, "/empty": 0}, p.completed)
+	rtest.Equals(t, 0, len(p.skipped))
+
+	p = restore(OverwriteIfChanged)
+	rtest.Equals(t, uint64(3), p.totalFiles)
+	rtest.Equals(t, uint64(27), p.totalBytes)
+	rtest.Equals(t, uint64(0), p.blobBytes)
+	rtest.Equals(t, map[string]uint64{"/empty": 0}, p.completed)
+	rtest.Equals(t, map[string]uint64{"/dir/file": 14, "/file": 13}, p.skipped)
+}
+
+// countingLimiter counts the bytes read through Downstream.
+type countingLimiter struct {
+	m          sync.Mutex
+	downstream int64
+}
+
+func (l *countingLimiter) Upstream(r io.Reader) io.Reader                   { return r }
+func (l *countingLimiter) UpstreamWriter(w io.Writer) io.Writer             { return w }
+func (l *countingLimiter) Transport(rt http.RoundTripper) http.RoundTripper { return rt }
+
+func (l *countingLimiter) Downstream(r io.Reader) io.Reader {
+	return countingReader{rd: r, l: l}
+}
+
+type countingReader struct {
+	rd io.Reader
+	l  *countingLimiter
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.l.m.Lock()
+	r.l.downstream += int64(n)
+	r.l.m.Unlock()
+	return n, err
+}
+
+func TestRestorerLimitDownload(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	lim := &countingLimiter{}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, limiter.LimitBackend(be, lim))
+	defer cleanup()
+
+	data := strings.Repeat("content: file\n", 1000)
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: data},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lim.downstream = 0
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	// all pack files must be downloaded via the limiter
+	rtest.Assert(t, lim.downstream >= int64(len(data)),
+		"expected at least %d bytes to be read via the limiter, got %d", len(data), lim.downstream)
+}
+
+func TestRestorerSeed(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	lim := &countingLimiter{}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, limiter.LimitBackend(be, lim))
+	defer cleanup()
+
+	seeded := strings.Repeat("content: seeded\n", 1000)
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"seeded": File{Data: seeded},
+					"other":  File{Data: "content: other\n"},
+				},
+			},
+		},
+	})
+
+	seed, cleanup := rtest.TempDir(t)
+	defer cleanup()
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(seed, "renamed"), []byte(seeded), 0600))
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Seed = []string{seed}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lim.downstream = 0
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.Assert(t, lim.downstream < int64(len(seeded)),
+		"expected the seeded file not to be downloaded, got %d bytes", lim.downstream)
+
+	for name, data := range map[string]string{"seeded": seeded, "other": "content: other\n"} {
+		buf, err := ioutil.ReadFile(filepath.Join(tempdir, "dir", name))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+}
+
+func TestRestorerNodeWorkers(t *testing.T) {
+	defer func(count int) {
+		nodeWorkerCount = count
+	}(nodeWorkerCount)
+	nodeWorkerCount = 4
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	modTime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	files := make(map[string]Node)
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("empty%d", i)] = File{ModTime: modTime}
+		files[fmt.Sprintf("small%d", i)] = File{Data: fmt.Sprintf("content: %d\n", i), ModTime: modTime}
+	}
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: files},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var completed int
+	var m sync.Mutex
+	res.CompleteFile = func(string, uint64, bool) {
+		m.Lock()
+		completed++
+		m.Unlock()
+	}
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Equals(t, len(files), completed)
+
+	for i := 0; i < 50; i++ {
+		for name, data := range map[string]string{
+			fmt.Sprintf("empty%d", i): "",
+			fmt.Sprintf("small%d", i): fmt.Sprintf("content: %d\n", i),
+		} {
+			filename := filepath.Join(tempdir, "dir", name)
+			buf, err := ioutil.ReadFile(filename)
+			rtest.OK(t, err)
+			rtest.Equals(t, data, string(buf))
+
+			fi, err := os.Stat(filename)
+			rtest.OK(t, err)
+			rtest.Assert(t, fi.ModTime().Equal(modTime), "%v: modification time not restored, got %v", name, fi.ModTime())
+		}
+	}
+}
+
+func TestRestorerPlan(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"unchanged": File{Data: "content: unchanged\n"},
+					"changed":   File{Data: "content: changed\n"},
+				},
+			},
+			"new":      File{Data: "content: new\n"},
+			"conflict": File{Data: "content: conflict\n"},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	rtest.OK(t, os.Remove(filepath.Join(tempdir, "new")))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "dir", "changed"), []byte("modified"), 0600))
+	rtest.OK(t, os.Remove(filepath.Join(tempdir, "conflict")))
+	rtest.OK(t, os.Mkdir(filepath.Join(tempdir, "conflict"), 0700))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "dir", "extra"), []byte("extra"), 0600))
+
+	res, err = NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+	res.Delete = true
+
+	actions := make(map[string]string)
+	rtest.OK(t, res.Plan(ctx, tempdir, func(item PlannedItem) error {
+		action := item.Action.String()
+		if item.Conflict {
+			action += " (conflict)"
+		}
+		actions[filepath.ToSlash(item.Location)] = action
+		return nil
+	}))
+
+	rtest.Equals(t, map[string]string{
+		"/dir":           "update",
+		"/dir/unchanged": "skip",
+		"/dir/changed":   "overwrite",
+		"/dir/extra":     "delete",
+		"/new":           "create",
+		"/conflict":      "overwrite (conflict)",
+	}, actions)
+
+	// nothing must have been modified
+	_, err = os.Stat(filepath.Join(tempdir, "new"))
+	rtest.Assert(t, os.IsNotExist(err), "file new was created")
+	_, err = os.Stat(filepath.Join(tempdir, "dir", "extra"))
+	rtest.OK(t, err)
+}
+
+func TestRestorerPlanTrailingData(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"truncated": File{Data: "content: truncated\n"},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the file only has trailing data, a restore would truncate it
+	filename := filepath.Join(tempdir, "truncated")
+	content := []byte("content: truncated\ntrailing data")
+	rtest.OK(t, ioutil.WriteFile(filename, content, 0600))
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteIfChanged
+
+	actions := make(map[string]string)
+	rtest.OK(t, res.Plan(ctx, tempdir, func(item PlannedItem) error {
+		actions[filepath.ToSlash(item.Location)] = item.Action.String()
+		return nil
+	}))
+	rtest.Equals(t, map[string]string{"/truncated": "update"}, actions)
+
+	// the plan must not modify the file
+	data, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, content, data)
+}
+
+func TestRestorerPathMappings(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"home": Dir{
+				Nodes: map[string]Node{
+					"user": Dir{
+						Nodes: map[string]Node{
+							"work": Dir{
+								Nodes: map[string]Node{
+									"file": File{Data: "content: file\n"},
+								},
+							},
+							"doc": File{Data: "content: doc\n"},
+						},
+					},
+					"other": File{Data: "content: other\n"},
+				},
+			},
+			"etc": Dir{
+				Nodes: map[string]Node{
+					"conf": File{Data: "content: conf\n"},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.PathMappings = []PathMapping{
+		{From: "/home/user", To: "/"},
+		{From: "/etc", To: "/config/etc"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	var files []string
+	rtest.OK(t, filepath.Walk(tempdir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			rel, err := filepath.Rel(tempdir, path)
+			rtest.OK(t, err)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	}))
+
+	rtest.Equals(t, []string{"config/etc/conf", "doc", "home/other", "work/file"}, files)
+
+	res.Delete = true
+	rtest.Assert(t, res.RestoreTo(ctx, tempdir) != nil, "expected error for path mappings combined with delete")
+}
+
+func TestRestorerSkipUnmapped(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"home": Dir{
+				Nodes: map[string]Node{
+					"user": Dir{
+						Nodes: map[string]Node{
+							"doc": File{Data: "content: doc\n"},
+						},
+					},
+					"other": File{Data: "content: other\n"},
+				},
+			},
+			"etc": Dir{
+				Nodes: map[string]Node{
+					"conf": File{Data: "content: conf\n"},
+				},
+			},
+			"var": Dir{
+				Nodes: map[string]Node{
+					"log": File{Data: "content: log\n"},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.PathMappings = []PathMapping{
+		{From: "/home/user", To: "/"},
+		{From: "/etc", To: "/config/etc"},
+	}
+	res.SkipUnmapped = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	var files []string
+	rtest.OK(t, filepath.Walk(tempdir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			rel, err := filepath.Rel(tempdir, path)
+			rtest.OK(t, err)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	}))
+
+	// paths outside of the stripped prefix and the mapped paths are skipped
+	rtest.Equals(t, []string{"config/etc/conf", "doc"}, files)
+}
@@ -4,6 +4,7 @@ package restorer
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -59,3 +60,59 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+func getInode(t testing.TB, path string) uint64 {
+	fi, err := os.Stat(path)
+	rtest.OK(t, err)
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		t.Skip("stat does not return inode numbers")
+	}
+	return uint64(stat.Ino)
+}
+
+func TestRestorerRestoreHardlinkedFiles(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file1": File{Links: 3, Inode: 1, Data: "content"},
+					"file2": File{Links: 3, Inode: 1, Data: "content"},
+					"file3": File{Links: 3, Inode: 1, Data: "modified content"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = res.RestoreTo(ctx, tempdir)
+	rtest.OK(t, err)
+
+	for name, data := range map[string]string{
+		"file1": "content",
+		"file2": "content",
+		"file3": "modified content",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(tempdir, "dirtest", name))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+
+	ino1 := getInode(t, filepath.Join(tempdir, "dirtest/file1"))
+	ino2 := getInode(t, filepath.Join(tempdir, "dirtest/file2"))
+	ino3 := getInode(t, filepath.Join(tempdir, "dirtest/file3"))
+
+	rtest.Equals(t, ino1, ino2)
+	rtest.Assert(t, ino1 != ino3, "file with different content was restored as a hard link")
+}
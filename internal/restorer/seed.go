@@ -0,0 +1,114 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// errSeedComplete stops scanning the seed directories when all blobs were
+// found.
+var errSeedComplete = errors.New("all blobs found")
+
+// localBlob is a copy of a blob in a local file.
+type localBlob struct {
+	path   string
+	offset int64
+	length int
+}
+
+// scanSeed splits the files below the directories in Seed into blobs the same
+// way the backup does and returns the location of all blobs in needed which
+// were found. Files which cannot be read are ignored.
+func (res *Restorer) scanSeed(ctx context.Context, needed restic.IDSet) (map[restic.ID]localBlob, error) {
+	found := make(map[restic.ID]localBlob)
+	if len(needed) == 0 {
+		return found, nil
+	}
+
+	pol := res.repo.Config().ChunkerPolynomial
+	chnker := chunker.New(nil, pol)
+	buf := make([]byte, chunker.MaxSize)
+
+	scanFile := func(path string) error {
+		f, err := fs.Open(path)
+		if err != nil {
+			debug.Log("unable to open %v: %v", path, err)
+			return nil
+		}
+		defer f.Close()
+
+		chnker.Reset(f, pol)
+		for {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			chunk, err := chnker.Next(buf)
+			if errors.Cause(err) == io.EOF {
+				return nil
+			}
+			if err != nil {
+				debug.Log("unable to read %v: %v", path, err)
+				return nil
+			}
+
+			id := restic.Hash(chunk.Data)
+			if _, ok := found[id]; ok || !needed.Has(id) {
+				continue
+			}
+			found[id] = localBlob{path: path, offset: int64(chunk.Start), length: int(chunk.Length)}
+		}
+	}
+
+	for _, dir := range res.Seed {
+		err := fs.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				debug.Log("unable to scan %v: %v", path, err)
+				return nil
+			}
+			if !fi.Mode().IsRegular() || fi.Size() == 0 {
+				return nil
+			}
+			if len(found) == len(needed) {
+				return errSeedComplete
+			}
+			return scanFile(path)
+		})
+		if err == errSeedComplete {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	debug.Log("found %d of %d needed blobs in %v", len(found), len(needed), res.Seed)
+	return found, nil
+}
+
+// readLocalBlob reads the local copy of a blob into buf.
+func readLocalBlob(local localBlob, buf []byte) ([]byte, error) {
+	f, err := fs.OpenFile(local.path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenFile")
+	}
+	defer f.Close()
+
+	if cap(buf) < local.length {
+		buf = make([]byte, local.length)
+	}
+	buf = buf[:local.length]
+
+	_, err = f.ReadAt(buf, local.offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadAt")
+	}
+	return buf, nil
+}
@@ -0,0 +1,146 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// InsufficientSpaceError is returned by RestoreTo if CheckSpace is set and the
+// file system of the target directory does not have enough free space.
+type InsufficientSpaceError struct {
+	Target string
+
+	NeededBytes, FreeBytes   uint64
+	NeededInodes, FreeInodes uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	if e.NeededBytes > e.FreeBytes {
+		return fmt.Sprintf("not enough free space in %v: %d bytes needed, %d bytes available", e.Target, e.NeededBytes, e.FreeBytes)
+	}
+	return fmt.Sprintf("not enough free inodes in %v: %d needed, %d available", e.Target, e.NeededInodes, e.FreeInodes)
+}
+
+// requiredSpace returns the number of bytes and inodes needed to restore the
+// selected items to dst. Existing files are taken into account, hard linked
+// files are only counted once and file sizes are rounded up to blockSize. The
+// full size is counted for sparse files, as they are restored with all of
+// their blocks allocated.
+func (res *Restorer) requiredSpace(ctx context.Context, dst string, blockSize uint64) (bytes, inodes uint64, err error) {
+	roundUp := func(size uint64) uint64 {
+		if blockSize == 0 || size%blockSize == 0 {
+			return size
+		}
+		return size + blockSize - size%blockSize
+	}
+
+	exists := func(target string) (os.FileInfo, bool, error) {
+		fi, err := fs.Lstat(target)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, errors.Wrap(err, "Lstat")
+		}
+		return fi, true, nil
+	}
+
+	// errors are reported by the restore itself, don't report them twice
+	errorFn := res.Error
+	res.Error = func(string, error) error { return nil }
+	defer func() {
+		res.Error = errorFn
+	}()
+
+	links := restic.NewHardlinkIndex()
+
+	err = res.traverseTree(ctx, dst, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		enterDir: func(node *restic.Node, target, location string) error {
+			_, ok, err := exists(target)
+			if err == nil && !ok {
+				inodes++
+			}
+			return err
+		},
+		visitNode: func(node *restic.Node, target, location string) error {
+			if node.Type == "file" && node.Links > 1 {
+				if links.Has(node.Inode, node.DeviceID) {
+					return nil
+				}
+				links.Add(node.Inode, node.DeviceID, location)
+			}
+
+			fi, ok, err := exists(target)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				inodes++
+			}
+
+			if node.Type != "file" {
+				return nil
+			}
+
+			needed := roundUp(node.Size)
+			if ok && fi.Mode().IsRegular() {
+				present := roundUp(uint64(fi.Size()))
+				if present >= needed {
+					return nil
+				}
+				needed -= present
+			}
+			bytes += needed
+			return nil
+		},
+		leaveDir: func(*restic.Node, string, string) error { return nil },
+	})
+
+	return bytes, inodes, err
+}
+
+// checkSpace returns an InsufficientSpaceError if restoring to dst needs more
+// space or inodes than available. If the free space cannot be determined, the
+// check is skipped.
+func (res *Restorer) checkSpace(ctx context.Context, dst string) error {
+	// dst may not exist yet, use the nearest parent directory
+	dir := dst
+	for {
+		if _, err := fs.Lstat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	space, err := fs.FreeDiskSpace(dir)
+	if err != nil {
+		debug.Log("unable to determine free space for %v: %v", dir, err)
+		return nil
+	}
+
+	bytes, inodes, err := res.requiredSpace(ctx, dst, space.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("restore needs %d bytes and %d inodes, available: %+v", bytes, inodes, space)
+
+	if bytes > space.FreeBytes || (space.HasInodes && inodes > space.FreeInodes) {
+		return &InsufficientSpaceError{
+			Target:       dst,
+			NeededBytes:  bytes,
+			FreeBytes:    space.FreeBytes,
+			NeededInodes: inodes,
+			FreeInodes:   space.FreeInodes,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package restorer
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerRequiredSpace(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"a": File{Data: strings.Repeat("a", 10)},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"b":     File{Data: strings.Repeat("b", 20)},
+					"link1": File{Data: strings.Repeat("l", 30), Links: 2, Inode: 999},
+					"link2": File{Data: strings.Repeat("l", 30), Links: 2, Inode: 999},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	var tests = []struct {
+		blockSize uint64
+		bytes     uint64
+		inodes    uint64
+	}{
+		{0, 60, 4},
+		{16, 80, 4},
+	}
+
+	for _, test := range tests {
+		bytes, inodes, err := res.requiredSpace(ctx, tempdir, test.blockSize)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.bytes, bytes)
+		rtest.Equals(t, test.inodes, inodes)
+	}
+
+	// existing files only need the additional space
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "a"), []byte("aaaa"), 0600))
+
+	bytes, inodes, err := res.requiredSpace(ctx, tempdir, 0)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint64(56), bytes)
+	rtest.Equals(t, uint64(3), inodes)
+}
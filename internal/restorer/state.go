@@ -0,0 +1,129 @@
+package restorer
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// stateFilename is the name of the file in the target directory which records
+// the progress of a resumable restore.
+const stateFilename = ".restic-restore-state"
+
+const stateHeader = "restic restore state v1 "
+
+// restoreState records which files of a snapshot have been written
+// completely, so that an interrupted restore can be resumed. The state is
+// stored as a header line followed by one quoted location per line, new
+// locations are appended as soon as the file has been written.
+type restoreState struct {
+	m    sync.Mutex
+	path string
+	f    *os.File
+	done map[string]struct{}
+}
+
+// openRestoreState loads the state at path if it belongs to the snapshot id,
+// otherwise a new state is started.
+func openRestoreState(path string, id restic.ID) (*restoreState, error) {
+	s := &restoreState{
+		path: path,
+		done: make(map[string]struct{}),
+	}
+
+	header := stateHeader + id.String()
+
+	f, err := fs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenFile")
+	}
+
+	sc := bufio.NewScanner(f)
+	valid := sc.Scan() && sc.Text() == header
+	for valid && sc.Scan() {
+		location, err := strconv.Unquote(sc.Text())
+		if err != nil {
+			// the last line may be incomplete if restic was interrupted
+			debug.Log("ignoring invalid line %q in restore state: %v", sc.Text(), err)
+			continue
+		}
+		s.done[location] = struct{}{}
+	}
+
+	if err := sc.Err(); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "Scan")
+	}
+
+	if valid {
+		debug.Log("resuming restore of %v, %d files already restored", id.Str(), len(s.done))
+		_, err = f.Seek(0, io.SeekEnd)
+	} else {
+		err = f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt([]byte(header+"\n"), 0)
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekEnd)
+		}
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "write header")
+	}
+
+	s.f = f
+	return s, nil
+}
+
+// isDone returns true if the file at location was already restored.
+func (s *restoreState) isDone(location string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.done[location]
+	return ok
+}
+
+// markDone records that the file at location has been restored completely.
+func (s *restoreState) markDone(location string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.done[location]; ok {
+		return nil
+	}
+	s.done[location] = struct{}{}
+
+	_, err := s.f.WriteString(strconv.Quote(location) + "\n")
+	return errors.Wrap(err, "Write")
+}
+
+// isStateFile returns true if path is the state file.
+func (s *restoreState) isStateFile(path string) bool {
+	return strings.EqualFold(path, s.path)
+}
+
+// close closes the state file, which is kept for resuming the restore later.
+func (s *restoreState) close() error {
+	return s.f.Close()
+}
+
+// remove closes and removes the state file after the restore has finished.
+func (s *restoreState) remove() error {
+	err := s.f.Close()
+	if err != nil {
+		return err
+	}
+
+	return fs.Remove(s.path)
+}
@@ -0,0 +1,95 @@
+package restorer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreState(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(tempdir, stateFilename)
+	id := restic.NewRandomID()
+
+	s, err := openRestoreState(path, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, !s.isDone("/dir/file"), "new state contains file")
+
+	rtest.OK(t, s.markDone("/dir/file"))
+	rtest.OK(t, s.markDone("/dir/file\nwith newline"))
+	rtest.OK(t, s.close())
+
+	// simulate an interrupted write
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	rtest.OK(t, err)
+	_, err = f.WriteString(`"/dir/incomple`)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	s, err = openRestoreState(path, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, s.isDone("/dir/file"), "file missing from loaded state")
+	rtest.Assert(t, s.isDone("/dir/file\nwith newline"), "file missing from loaded state")
+	rtest.Assert(t, !s.isDone("/dir/incomple"), "incomplete line was loaded")
+	rtest.OK(t, s.close())
+
+	// the state for a different snapshot is discarded
+	s, err = openRestoreState(path, restic.NewRandomID())
+	rtest.OK(t, err)
+	rtest.Assert(t, !s.isDone("/dir/file"), "state of other snapshot was loaded")
+	rtest.OK(t, s.remove())
+
+	_, err = os.Stat(path)
+	rtest.Assert(t, os.IsNotExist(err), "state file was not removed: %v", err)
+}
+
+func TestRestorerResume(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file1": File{Data: "content: file1\n"},
+			"file2": File{Data: "content: file2\n"},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// state left behind by an interrupted restore, file1 was restored
+	s, err := openRestoreState(filepath.Join(tempdir, stateFilename), id)
+	rtest.OK(t, err)
+	rtest.OK(t, s.markDone("/file1"))
+	rtest.OK(t, s.close())
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file1"), []byte("CONTENT: FILE1\n"), 0600))
+
+	res, err := NewRestorer(repo, id)
+	rtest.OK(t, err)
+	res.Resume = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"file1": "CONTENT: FILE1\n",
+		"file2": "content: file2\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+
+	_, err = os.Stat(filepath.Join(tempdir, stateFilename))
+	rtest.Assert(t, os.IsNotExist(err), "state file was not removed: %v", err)
+}
@@ -0,0 +1,338 @@
+// Package restserver serves the files of a repository via the REST protocol,
+// which is used by the rest backend and implemented by rest-server.
+package restserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Config holds settings for the REST server.
+type Config struct {
+	// ReadOnly rejects all requests which modify the repository.
+	ReadOnly bool
+
+	// AppendOnly only allows adding files and removing locks, so that
+	// clients cannot remove or overwrite data.
+	AppendOnly bool
+
+	// If Username is set, clients must authenticate with HTTP basic auth.
+	Username string
+	Password string
+}
+
+// the directories for the file types, the config is served at "/config"
+var fileTypes = map[string]restic.FileType{
+	"data":      restic.DataFile,
+	"keys":      restic.KeyFile,
+	"locks":     restic.LockFile,
+	"snapshots": restic.SnapshotFile,
+	"index":     restic.IndexFile,
+	"audit":     restic.AuditFile,
+}
+
+// NewHandler returns an http.Handler which serves the files in be. The
+// contents of the files are passed on as they are, so the server does not
+// need the password for the repository.
+func NewHandler(be restic.Backend, cfg Config) http.Handler {
+	return handler{be: be, cfg: cfg}
+}
+
+type handler struct {
+	be  restic.Backend
+	cfg Config
+}
+
+func (h handler) authorized(r *http.Request) bool {
+	if h.cfg.Username == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.cfg.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.cfg.Password)) == 1
+	return userOK && passwordOK
+}
+
+// parsePath returns the handle for the path of a request, list is true if the
+// path refers to all files of a type. Except for the config, the names of
+// files must be valid IDs.
+func parsePath(p string) (h restic.Handle, list bool, ok bool) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "config" {
+		return restic.Handle{Type: restic.ConfigFile}, false, true
+	}
+
+	parts := strings.Split(p, "/")
+	t, ok := fileTypes[parts[0]]
+	if !ok {
+		return restic.Handle{}, false, false
+	}
+
+	switch {
+	case len(parts) == 1 || (len(parts) == 2 && parts[1] == ""):
+		return restic.Handle{Type: t}, true, true
+	case len(parts) == 2:
+		if _, err := restic.ParseID(parts[1]); err != nil {
+			return restic.Handle{}, false, false
+		}
+		return restic.Handle{Type: t, Name: parts[1]}, false, true
+	}
+	return restic.Handle{}, false, false
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL)
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/" && r.Method == "POST" && r.URL.Query().Get("create") == "true" {
+		h.create(w, r)
+		return
+	}
+
+	handle, list, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case list && r.Method == "GET":
+		h.list(w, r, handle.Type)
+	case list:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case r.Method == "HEAD":
+		h.stat(w, r, handle)
+	case r.Method == "GET":
+		h.load(w, r, handle)
+	case r.Method == "POST":
+		h.save(w, r, handle)
+	case r.Method == "DELETE":
+		h.remove(w, r, handle)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// create handles a request to create the repository. Like rest-server, it
+// fails if the repository already exists, otherwise there is nothing to do as
+// the backend creates directories when files are saved.
+func (h handler) create(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.ReadOnly {
+		http.Error(w, "repository is read-only", http.StatusForbidden)
+		return
+	}
+
+	_, err := h.be.Stat(r.Context(), restic.Handle{Type: restic.ConfigFile})
+	switch {
+	case err == nil:
+		http.Error(w, "repository already exists", http.StatusForbidden)
+	case !h.be.IsNotExist(err):
+		h.fail(w, r, err)
+	}
+}
+
+// fail sends an error response for err.
+func (h handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.be.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	debug.Log("%v %v failed: %v", r.Method, r.URL.Path, err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (h handler) list(w http.ResponseWriter, r *http.Request, t restic.FileType) {
+	type item struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+
+	items := []item{}
+	err := h.be.List(r.Context(), t, func(fi restic.FileInfo) error {
+		items = append(items, item{Name: fi.Name, Size: fi.Size})
+		return nil
+	})
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	var data interface{} = items
+	contentType := rest.ContentTypeV2
+	if r.Header.Get("Accept") != rest.ContentTypeV2 {
+		names := make([]string, 0, len(items))
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		data = names
+		contentType = rest.ContentTypeV1
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		debug.Log("sending list failed: %v", err)
+	}
+}
+
+func (h handler) stat(w http.ResponseWriter, r *http.Request, handle restic.Handle) {
+	fi, err := h.be.Stat(r.Context(), handle)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprint(fi.Size))
+}
+
+// parseRange returns the offset and length for the Range header, length is
+// zero for the rest of the file.
+func parseRange(header string, size int64) (offset int64, length int, err error) {
+	var end int64
+	n, _ := fmt.Sscanf(header, "bytes=%d-%d", &offset, &end)
+	switch n {
+	case 1:
+		// open-ended range, read to the end of the file
+	case 2:
+		if end < offset {
+			return 0, 0, errors.Errorf("invalid range %q, end is before start", header)
+		}
+		length = int(end - offset + 1)
+	default:
+		return 0, 0, errors.Errorf("invalid range %q", header)
+	}
+
+	if offset < 0 || offset > size {
+		return 0, 0, errors.Errorf("invalid range %q", header)
+	}
+	if int64(length) > size-offset {
+		length = int(size - offset)
+	}
+	return offset, length, nil
+}
+
+func (h handler) load(w http.ResponseWriter, r *http.Request, handle restic.Handle) {
+	fi, err := h.be.Stat(r.Context(), handle)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	var offset int64
+	var length int
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" {
+		offset, length, err = parseRange(header, fi.Size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+	}
+
+	size := fi.Size - offset
+	if length > 0 {
+		size = int64(length)
+	}
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+size-1, fi.Size))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+
+	// the status has already been sent when an error occurs while copying,
+	// the client notices the error by the short response
+	sent := false
+	err = h.be.Load(r.Context(), handle, length, offset, func(rd io.Reader) error {
+		sent = true
+		w.WriteHeader(status)
+		_, err := io.Copy(w, rd)
+		return err
+	})
+	if err != nil && !sent {
+		w.Header().Del("Content-Range")
+		w.Header().Del("Content-Length")
+		h.fail(w, r, err)
+		return
+	}
+	if err != nil {
+		debug.Log("sending %v failed: %v", handle, err)
+	}
+}
+
+func (h handler) save(w http.ResponseWriter, r *http.Request, handle restic.Handle) {
+	if h.cfg.ReadOnly {
+		http.Error(w, "repository is read-only", http.StatusForbidden)
+		return
+	}
+
+	// files are never overwritten
+	if _, err := h.be.Stat(r.Context(), handle); err == nil {
+		http.Error(w, "file already exists", http.StatusForbidden)
+		return
+	}
+
+	// the backend needs to know the length and may need to rewind
+	tmp, err := fs.TempFile("", "restic-serve-")
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := io.Copy(tmp, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rd, err := restic.NewFileReader(tmp)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	err = h.be.Save(r.Context(), handle, rd)
+	if err != nil {
+		h.fail(w, r, err)
+	}
+}
+
+func (h handler) remove(w http.ResponseWriter, r *http.Request, handle restic.Handle) {
+	if h.cfg.ReadOnly {
+		http.Error(w, "repository is read-only", http.StatusForbidden)
+		return
+	}
+	if h.cfg.AppendOnly && handle.Type != restic.LockFile {
+		http.Error(w, "repository is append-only", http.StatusForbidden)
+		return
+	}
+
+	err := h.be.Remove(r.Context(), handle)
+	if err != nil {
+		h.fail(w, r, err)
+	}
+}
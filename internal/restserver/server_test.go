@@ -0,0 +1,179 @@
+package restserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newClient(t *testing.T, cfg Config) (*rest.Backend, func()) {
+	srv := httptest.NewServer(NewHandler(mem.New(), cfg))
+
+	u, err := url.Parse(srv.URL + "/")
+	rtest.OK(t, err)
+
+	restCfg := rest.NewConfig()
+	restCfg.URL = u
+	be, err := rest.Open(restCfg, http.DefaultTransport)
+	rtest.OK(t, err)
+
+	return be, srv.Close
+}
+
+func load(t *testing.T, be restic.Backend, h restic.Handle, length int, offset int64) []byte {
+	var data []byte
+	err := be.Load(context.TODO(), h, length, offset, func(rd io.Reader) (err error) {
+		data, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	return data
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.TODO()
+	be, cleanup := newClient(t, Config{})
+	defer cleanup()
+
+	data := []byte("foobar baz")
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, restic.NewByteReader(data)))
+
+	// files cannot be overwritten
+	rtest.Assert(t, be.Save(ctx, h, restic.NewByteReader(data)) != nil, "overwriting a file succeeded")
+
+	fi, err := be.Stat(ctx, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	rtest.Equals(t, data, load(t, be, h, 0, 0))
+	rtest.Equals(t, data[3:7], load(t, be, h, 4, 3))
+	rtest.Equals(t, data[7:], load(t, be, h, 0, 7))
+
+	var names []string
+	rtest.OK(t, be.List(ctx, restic.DataFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		rtest.Equals(t, int64(len(data)), fi.Size)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+
+	rtest.OK(t, be.Remove(ctx, h))
+	_, err = be.Stat(ctx, h)
+	rtest.Assert(t, be.IsNotExist(err), "file still exists, err %v", err)
+}
+
+func TestServerRange(t *testing.T) {
+	be := mem.New()
+	data := []byte("foobar baz")
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	srv := httptest.NewServer(NewHandler(be, Config{}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		rng    string
+		status int
+		body   string
+	}{
+		{"bytes=3-6", http.StatusPartialContent, "bar "},
+		{"bytes=7-", http.StatusPartialContent, "baz"},
+		{"bytes=7-100", http.StatusPartialContent, "baz"},
+		{"bytes=6-3", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"bytes=-3", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"foo", http.StatusRequestedRangeNotSatisfiable, ""},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/data/"+h.Name, nil)
+		rtest.OK(t, err)
+		req.Header.Set("Range", test.rng)
+		resp, err := http.DefaultClient.Do(req)
+		rtest.OK(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		rtest.OK(t, err)
+		rtest.OK(t, resp.Body.Close())
+
+		rtest.Equals(t, test.status, resp.StatusCode)
+		if test.status == http.StatusPartialContent {
+			rtest.Equals(t, test.body, string(body))
+		}
+	}
+}
+
+func TestServerAppendOnly(t *testing.T) {
+	ctx := context.TODO()
+	be, cleanup := newClient(t, Config{AppendOnly: true})
+	defer cleanup()
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, restic.NewByteReader(data)))
+	rtest.Assert(t, be.Remove(ctx, h) != nil, "removing a snapshot succeeded")
+
+	lock := restic.Handle{Type: restic.LockFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, lock, restic.NewByteReader(data)))
+	rtest.OK(t, be.Remove(ctx, lock))
+}
+
+func TestServerReadOnly(t *testing.T) {
+	be, cleanup := newClient(t, Config{ReadOnly: true})
+	defer cleanup()
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.LockFile, Name: restic.Hash(data).String()}
+	rtest.Assert(t, be.Save(context.TODO(), h, restic.NewByteReader(data)) != nil, "saving a file succeeded")
+}
+
+func TestServerInvalidPaths(t *testing.T) {
+	be := mem.New()
+	rtest.OK(t, be.Save(context.TODO(), restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader([]byte("config"))))
+
+	srv := httptest.NewServer(NewHandler(be, Config{}))
+	defer srv.Close()
+
+	id := restic.NewRandomID()
+	for _, p := range []string{"/keys/foo", "/data/..", "/snapshots/" + id.Str(), "/locks/x/y"} {
+		resp, err := http.Get(srv.URL + p)
+		rtest.OK(t, err)
+		rtest.OK(t, resp.Body.Close())
+		rtest.Equals(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	// the repository already exists
+	resp, err := http.Post(srv.URL+"/?create=true", "", nil)
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+	rtest.Equals(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestServerAuth(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(mem.New(), Config{Username: "user", Password: "secret"}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/keys/")
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+	rtest.Equals(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest("GET", srv.URL+"/keys/", nil)
+	rtest.OK(t, err)
+	req.SetBasicAuth("user", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	rtest.OK(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+	rtest.Equals(t, http.StatusOK, resp.StatusCode)
+	rtest.Equals(t, "[]", string(bytes.TrimSpace(body)))
+}
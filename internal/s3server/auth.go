@@ -0,0 +1,246 @@
+package s3server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureAlgorithm is the only supported signature algorithm, AWS
+// Signature Version 4.
+const signatureAlgorithm = "AWS4-HMAC-SHA256"
+
+// maxClockSkew is the maximal difference between the time a request was
+// signed and the time it is received.
+const maxClockSkew = 15 * time.Minute
+
+// timeNow returns the current time, it is replaced in tests.
+var timeNow = time.Now
+
+// authError is returned when a request is not signed correctly, code is the
+// S3 error code sent to the client.
+type authError struct {
+	code    string
+	message string
+}
+
+func (e authError) Error() string {
+	return e.code + ": " + e.message
+}
+
+// signedRequest contains the parts of a signed request which are needed to
+// verify the signature.
+type signedRequest struct {
+	accessKey     string
+	date          string // yyyymmdd
+	region        string
+	service       string
+	amzDate       string // yyyymmddThhmmssZ
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+// parseCredential parses the credential of a request, which has the form
+// "accesskey/yyyymmdd/region/service/aws4_request".
+func (s *signedRequest) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return authError{"AuthorizationQueryParametersError", "invalid credential"}
+	}
+	s.accessKey, s.date, s.region, s.service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// parseAuthorization returns the signature of the request, which is either
+// sent in the Authorization header or in the query string of a presigned URL.
+func parseAuthorization(r *http.Request) (*signedRequest, error) {
+	s := &signedRequest{}
+	q := r.URL.Query()
+
+	if q.Get("X-Amz-Algorithm") != "" {
+		if q.Get("X-Amz-Algorithm") != signatureAlgorithm {
+			return nil, authError{"AuthorizationQueryParametersError", "unsupported signature algorithm"}
+		}
+		if err := s.parseCredential(q.Get("X-Amz-Credential")); err != nil {
+			return nil, err
+		}
+
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 {
+			return nil, authError{"AuthorizationQueryParametersError", "invalid X-Amz-Expires"}
+		}
+
+		s.presigned = true
+		s.expires = time.Duration(expires) * time.Second
+		s.amzDate = q.Get("X-Amz-Date")
+		s.signedHeaders = strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+		s.signature = q.Get("X-Amz-Signature")
+		s.payloadHash = "UNSIGNED-PAYLOAD"
+		return s, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, authError{"AccessDenied", "the request is not signed"}
+	}
+	if !strings.HasPrefix(auth, signatureAlgorithm+" ") {
+		return nil, authError{"AccessDenied", "unsupported signature algorithm"}
+	}
+
+	for _, field := range strings.Split(strings.TrimPrefix(auth, signatureAlgorithm+" "), ",") {
+		field = strings.TrimSpace(field)
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return nil, authError{"AuthorizationHeaderMalformed", "invalid Authorization header"}
+		}
+
+		value := field[i+1:]
+		switch field[:i] {
+		case "Credential":
+			if err := s.parseCredential(value); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			s.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			s.signature = value
+		}
+	}
+
+	s.amzDate = r.Header.Get("X-Amz-Date")
+	s.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if s.payloadHash == "" {
+		// requests without a body
+		s.payloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+	}
+	return s, nil
+}
+
+// uriEncode encodes s as required for the canonical request, all bytes
+// except for unreserved characters are percent-encoded.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+// canonicalQuery returns the sorted and encoded query string, without the
+// signature of a presigned URL.
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for name, values := range q {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalRequest returns the canonical form of r which is signed by the
+// client.
+func canonicalRequest(r *http.Request, s *signedRequest) string {
+	var headers strings.Builder
+	for _, name := range s.signedHeaders {
+		var value string
+		if name == "host" {
+			value = r.Host
+		} else {
+			value = strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		strings.Join(s.signedHeaders, ";"),
+		s.payloadHash,
+	}, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signature returns the signature of r for the secret key.
+func signature(r *http.Request, s *signedRequest, secretKey string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest(r, s)))
+	scope := strings.Join([]string{s.date, s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{signatureAlgorithm, s.amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), s.date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// authenticate checks that r was signed with the access key and the secret
+// key in cfg.
+func authenticate(r *http.Request, cfg Config) error {
+	s, err := parseAuthorization(r)
+	if err != nil {
+		return err
+	}
+
+	if s.accessKey != cfg.AccessKey {
+		return authError{"InvalidAccessKeyId", "the access key does not exist"}
+	}
+	if s.service != "s3" {
+		return authError{"AuthorizationHeaderMalformed", "the credential is not valid for S3"}
+	}
+
+	signed, err := time.Parse("20060102T150405Z", s.amzDate)
+	if err != nil || !strings.HasPrefix(s.amzDate, s.date) {
+		return authError{"AccessDenied", "invalid or missing X-Amz-Date"}
+	}
+
+	now := timeNow()
+	if s.presigned {
+		if now.Before(signed.Add(-maxClockSkew)) || now.After(signed.Add(s.expires)) {
+			return authError{"AccessDenied", "the presigned URL has expired"}
+		}
+	} else if now.Sub(signed) > maxClockSkew || signed.Sub(now) > maxClockSkew {
+		return authError{"RequestTimeTooSkewed", "the difference between the request time and the server's time is too large"}
+	}
+
+	hasHost := false
+	for _, name := range s.signedHeaders {
+		hasHost = hasHost || name == "host"
+	}
+	if !hasHost {
+		return authError{"AccessDenied", "the host header is not signed"}
+	}
+
+	want := signature(r, s, cfg.SecretKey)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(s.signature))) {
+		return authError{"SignatureDoesNotMatch", "the request signature does not match"}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package s3server
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// cursor is the position of a listing within the directories of the file
+// system. The directories are walked depth-first in the order of the keys.
+type cursor struct {
+	h          handler
+	prefix     string
+	delimiter  string
+	startAfter string
+
+	// stack contains the directories which are currently walked
+	stack []*cursorDir
+
+	// lastPrefix is the last common prefix which was returned
+	lastPrefix string
+
+	// pending is the first object of the next page
+	pending *object
+
+	used time.Time
+}
+
+// cursorDir contains the entries of a directory, sorted by key.
+type cursorDir struct {
+	keys  []string
+	infos []os.FileInfo
+	pos   int
+}
+
+func (d *cursorDir) Len() int           { return len(d.keys) }
+func (d *cursorDir) Less(i, j int) bool { return d.keys[i] < d.keys[j] }
+func (d *cursorDir) Swap(i, j int) {
+	d.keys[i], d.keys[j] = d.keys[j], d.keys[i]
+	d.infos[i], d.infos[j] = d.infos[j], d.infos[i]
+}
+
+// newCursor returns a cursor for the objects with the prefix which come
+// after startAfter.
+func (h handler) newCursor(ctx context.Context, prefix, delimiter, startAfter string) (*cursor, error) {
+	c := &cursor{
+		h:          h,
+		prefix:     prefix,
+		delimiter:  delimiter,
+		startAfter: startAfter,
+	}
+
+	err := c.push(ctx, prefix[:strings.LastIndex(prefix, "/")+1])
+	if os.IsNotExist(errors.Cause(err)) {
+		return c, nil
+	}
+	return c, err
+}
+
+// push reads the directory dir, which is empty or ends with a slash, and
+// continues with its entries.
+func (c *cursor) push(ctx context.Context, dir string) error {
+	f, err := c.h.fs.OpenFile(ctx, c.h.path(dir), os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(0)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	d := &cursorDir{keys: make([]string, len(infos)), infos: infos}
+	for i, fi := range infos {
+		d.keys[i] = dir + fi.Name()
+		if fi.IsDir() {
+			d.keys[i] += "/"
+		}
+	}
+	sort.Sort(d)
+
+	c.stack = append(c.stack, d)
+	return nil
+}
+
+// next returns the next object, ok is false if there are no more objects.
+func (c *cursor) next(ctx context.Context) (obj object, ok bool, err error) {
+	if c.pending != nil {
+		obj, c.pending = *c.pending, nil
+		return obj, true, nil
+	}
+
+	for len(c.stack) > 0 {
+		d := c.stack[len(c.stack)-1]
+		if d.pos == len(d.keys) {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+		key, fi := d.keys[d.pos], d.infos[d.pos]
+		d.pos++
+
+		if !strings.HasPrefix(key, c.prefix) {
+			// directories which are a part of the prefix are walked
+			if fi.IsDir() && strings.HasPrefix(c.prefix, key) {
+				if err := c.push(ctx, key); err != nil {
+					return object{}, false, err
+				}
+			}
+			continue
+		}
+
+		// all keys in the directory come before startAfter
+		if fi.IsDir() && key < c.startAfter && !strings.HasPrefix(c.startAfter, key) {
+			continue
+		}
+
+		if c.delimiter != "" {
+			rest := key[len(c.prefix):]
+			if i := strings.Index(rest, c.delimiter); i >= 0 {
+				// all keys in the directory have the same common prefix
+				common := c.prefix + rest[:i+len(c.delimiter)]
+				if common > c.startAfter && common != c.lastPrefix {
+					c.lastPrefix = common
+					return object{key: common, prefix: true}, true, nil
+				}
+				continue
+			}
+		}
+
+		if fi.IsDir() {
+			if err := c.push(ctx, key); err != nil {
+				return object{}, false, err
+			}
+			continue
+		}
+		if key > c.startAfter {
+			return object{key: key, fi: fi}, true, nil
+		}
+	}
+
+	return object{}, false, nil
+}
+
+const (
+	// maxCursors is the maximal number of listings which are kept to be
+	// continued, the least recently used one is dropped.
+	maxCursors = 100
+
+	// cursorTimeout is the time after which an unused cursor is dropped.
+	cursorTimeout = 5 * time.Minute
+)
+
+type cursorKey struct {
+	prefix, delimiter, startAfter string
+}
+
+// cursorCache keeps the cursors of truncated listings, so that the next page
+// can be returned without walking the directories again. The next page is
+// requested with the last key of the previous page as marker or within the
+// continuation token. If the cursor is not found, the listing is started again
+// after that key, so the result is the same.
+type cursorCache struct {
+	m       sync.Mutex
+	cursors map[cursorKey]*cursor
+}
+
+func newCursorCache() *cursorCache {
+	return &cursorCache{cursors: make(map[cursorKey]*cursor)}
+}
+
+// take removes the cursor for the next page after startAfter from the cache
+// and returns it, or nil if none was found.
+func (cc *cursorCache) take(prefix, delimiter, startAfter string) *cursor {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+
+	k := cursorKey{prefix, delimiter, startAfter}
+	c := cc.cursors[k]
+	delete(cc.cursors, k)
+	return c
+}
+
+// put adds the cursor for the page after startAfter to the cache.
+func (cc *cursorCache) put(prefix, delimiter, startAfter string, c *cursor) {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+
+	now := time.Now()
+	var oldest cursorKey
+	for k, c := range cc.cursors {
+		if now.Sub(c.used) > cursorTimeout {
+			delete(cc.cursors, k)
+			continue
+		}
+		if cc.cursors[oldest] == nil || c.used.Before(cc.cursors[oldest].used) {
+			oldest = k
+		}
+	}
+	if len(cc.cursors) >= maxCursors {
+		delete(cc.cursors, oldest)
+	}
+
+	c.used = now
+	cc.cursors[cursorKey{prefix, delimiter, startAfter}] = c
+}
@@ -0,0 +1,383 @@
+// Package s3server serves the files of a read-only file system as objects of
+// a bucket via a minimal subset of the S3 API, which is sufficient for most
+// clients to list and download objects.
+package s3server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config holds settings for the S3 server.
+type Config struct {
+	// Bucket is the name of the only bucket.
+	Bucket string
+
+	// Root is the directory of the file system which is served as the
+	// bucket.
+	Root string
+
+	// AccessKey and SecretKey are the credentials clients must sign their
+	// requests with (AWS Signature Version 4). If AccessKey is empty,
+	// requests are not authenticated and signatures are ignored.
+	AccessKey string
+	SecretKey string
+}
+
+// NewHandler returns an http.Handler which serves the files below cfg.Root
+// in fs.
+func NewHandler(fs webdav.FileSystem, cfg Config) http.Handler {
+	return handler{fs: fs, cfg: cfg, cursors: newCursorCache()}
+}
+
+type handler struct {
+	fs      webdav.FileSystem
+	cfg     Config
+	cursors *cursorCache
+}
+
+// s3Error is the body of an error response.
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// writeXML sends v as the response.
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		debug.Log("sending response failed: %v", err)
+	}
+}
+
+func fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	debug.Log("%v %v: %v", r.Method, r.URL, code)
+	writeXML(w, status, s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// split returns the bucket and the key for the request. Both path-style
+// requests and requests with the bucket in the host name are supported.
+func (h handler) split(r *http.Request) (bucket, key string) {
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	if strings.HasPrefix(host, h.cfg.Bucket+".") {
+		return h.cfg.Bucket, strings.TrimPrefix(r.URL.Path, "/")
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL)
+
+	if h.cfg.AccessKey != "" {
+		if err := authenticate(r, h.cfg); err != nil {
+			code := "AccessDenied"
+			if e, ok := err.(authError); ok {
+				code = e.code
+			}
+			fail(w, r, http.StatusForbidden, code, err.Error())
+			return
+		}
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		fail(w, r, http.StatusForbidden, "AccessDenied", "the bucket is read-only")
+		return
+	}
+
+	bucket, key := h.split(r)
+	switch {
+	case bucket == "":
+		h.listBuckets(w, r)
+	case bucket != h.cfg.Bucket:
+		fail(w, r, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+	case key != "":
+		h.getObject(w, r, key)
+	case r.Method == "HEAD":
+		// the bucket exists
+	case r.URL.Query()["location"] != nil:
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			XMLNS   string   `xml:"xmlns,attr"`
+		}{XMLNS: "http://s3.amazonaws.com/doc/2006-03-01/"})
+	default:
+		h.listObjects(w, r)
+	}
+}
+
+func (h handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	type bucket struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID string `xml:"ID"`
+		} `xml:"Owner"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{
+		XMLNS:   "http://s3.amazonaws.com/doc/2006-03-01/",
+		Buckets: []bucket{{Name: h.cfg.Bucket, CreationDate: formatTime(time.Unix(0, 0))}},
+	})
+}
+
+// formatTime returns t in the format used by S3.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// etag returns an entity tag for the file. It is not the MD5 of the content,
+// so it contains a dash like the tags of multipart uploads, which clients do
+// not check.
+func etag(key string, fi os.FileInfo) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d", key, fi.Size(), fi.ModTime().UnixNano())
+	return fmt.Sprintf(`"%016x-1"`, h.Sum64())
+}
+
+// path returns the path of the file for key, which is always within the root.
+func (h handler) path(key string) string {
+	return path.Join(h.cfg.Root, path.Clean("/"+key))
+}
+
+func (h handler) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	if strings.HasSuffix(key, "/") {
+		fail(w, r, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+		return
+	}
+
+	f, err := h.fs.OpenFile(r.Context(), h.path(key), os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			fail(w, r, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+			return
+		}
+		fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if fi.IsDir() {
+		fail(w, r, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+		return
+	}
+
+	w.Header().Set("ETag", etag(key, fi))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+}
+
+// object is a file, or a common prefix of several files if prefix is set.
+type object struct {
+	key    string
+	prefix bool
+	fi     os.FileInfo
+}
+
+// list returns up to max objects with the prefix which come after
+// startAfter. Keys which contain the delimiter after the prefix are combined
+// to a common prefix. truncated is true if there are more objects.
+//
+// When the list is truncated, the position is kept, so that the next page
+// continues where this one ended instead of walking the directories again.
+func (h handler) list(ctx context.Context, prefix, delimiter, startAfter string, max int) (objs []object, truncated bool, err error) {
+	c := h.cursors.take(prefix, delimiter, startAfter)
+	if c == nil {
+		c, err = h.newCursor(ctx, prefix, delimiter, startAfter)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	for len(objs) < max {
+		obj, ok, err := c.next(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return objs, false, nil
+		}
+		objs = append(objs, obj)
+	}
+
+	// find out if there are more objects, the next page starts with it
+	obj, ok, err := c.next(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return objs, false, nil
+	}
+	c.pending = &obj
+
+	last := startAfter
+	if len(objs) > 0 {
+		last = objs[len(objs)-1].key
+	}
+	h.cursors.put(prefix, delimiter, last, c)
+
+	return objs, true, nil
+}
+
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listResult is the response for both versions of ListObjects.
+type listResult struct {
+	XMLName        xml.Name      `xml:"ListBucketResult"`
+	XMLNS          string        `xml:"xmlns,attr"`
+	Name           string        `xml:"Name"`
+	Prefix         string        `xml:"Prefix"`
+	Delimiter      string        `xml:"Delimiter,omitempty"`
+	EncodingType   string        `xml:"EncodingType,omitempty"`
+	MaxKeys        int           `xml:"MaxKeys"`
+	IsTruncated    bool          `xml:"IsTruncated"`
+	Contents       []listContent `xml:"Contents"`
+	CommonPrefixes []listPrefix  `xml:"CommonPrefixes"`
+
+	// version 1
+	Marker     *string `xml:"Marker"`
+	NextMarker string  `xml:"NextMarker,omitempty"`
+
+	// version 2
+	KeyCount              *int   `xml:"KeyCount"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+}
+
+func (h handler) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+
+	max := 1000
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			fail(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		if n < max {
+			max = n
+		}
+	}
+
+	startAfter := q.Get("marker")
+	if v2 {
+		startAfter = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			buf, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				fail(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+				return
+			}
+			startAfter = string(buf)
+		}
+	}
+
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	objs, truncated, err := h.list(r.Context(), prefix, delimiter, startAfter, max)
+	if err != nil {
+		fail(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	// keys are URL-encoded on request, so that they can contain any
+	// characters
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+
+	res := listResult{
+		XMLNS:        "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:         h.cfg.Bucket,
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		EncodingType: q.Get("encoding-type"),
+		MaxKeys:      max,
+		IsTruncated:  truncated,
+	}
+
+	for _, obj := range objs {
+		if obj.prefix {
+			res.CommonPrefixes = append(res.CommonPrefixes, listPrefix{Prefix: encode(obj.key)})
+			continue
+		}
+		res.Contents = append(res.Contents, listContent{
+			Key:          encode(obj.key),
+			LastModified: formatTime(obj.fi.ModTime()),
+			ETag:         etag(obj.key, obj.fi),
+			Size:         obj.fi.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	var last string
+	if len(objs) > 0 {
+		last = objs[len(objs)-1].key
+	}
+
+	if v2 {
+		n := len(objs)
+		res.KeyCount = &n
+		res.StartAfter = encode(q.Get("start-after"))
+		res.ContinuationToken = q.Get("continuation-token")
+		if truncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encode(q.Get("marker"))
+		res.Marker = &marker
+		if truncated {
+			res.NextMarker = encode(last)
+		}
+	}
+
+	writeXML(w, http.StatusOK, res)
+}
@@ -0,0 +1,197 @@
+package s3server
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestHandler(t testing.TB) (http.Handler, func()) {
+	tempdir, cleanup := rtest.TempDir(t)
+
+	files := map[string]string{
+		"snapshot/a.txt":       "a",
+		"snapshot/a/b.txt":     "b",
+		"snapshot/a/c/d.txt":   "d",
+		"snapshot/e.txt":       "eeeeee",
+		"snapshot/a b/f+g.txt": "f",
+		"other/secret":         "secret",
+	}
+	for name, data := range files {
+		name = filepath.Join(tempdir, filepath.FromSlash(name))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(name), 0755))
+		rtest.OK(t, ioutil.WriteFile(name, []byte(data), 0644))
+	}
+
+	return NewHandler(webdav.Dir(tempdir), Config{Bucket: "bucket", Root: "/snapshot"}), cleanup
+}
+
+func request(t testing.TB, h http.Handler, method, url string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetObject(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	w := request(t, h, "GET", "/bucket/a/c/d.txt", nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	rtest.Equals(t, "d", w.Body.String())
+	rtest.Assert(t, w.Header().Get("ETag") != "", "ETag is missing")
+
+	w = request(t, h, "GET", "/bucket/e.txt", http.Header{"Range": {"bytes=1-3"}})
+	rtest.Equals(t, http.StatusPartialContent, w.Code)
+	rtest.Equals(t, "eee", w.Body.String())
+
+	// virtual-host style
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.Host = "bucket.localhost:9000"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	rtest.Equals(t, "a", w.Body.String())
+
+	for _, url := range []string{"/bucket/missing", "/bucket/a", "/bucket/a/", "/bucket/../other/secret", "/bucket/a/../../other/secret"} {
+		w = request(t, h, "GET", url, nil)
+		rtest.Equals(t, http.StatusNotFound, w.Code)
+	}
+
+	w = request(t, h, "GET", "/other/a.txt", nil)
+	rtest.Equals(t, http.StatusNotFound, w.Code)
+	rtest.Assert(t, strings.Contains(w.Body.String(), "NoSuchBucket"), "unexpected error %v", w.Body.String())
+
+	w = request(t, h, "PUT", "/bucket/new", nil)
+	rtest.Equals(t, http.StatusForbidden, w.Code)
+}
+
+func list(t testing.TB, h http.Handler, query string) listResult {
+	t.Helper()
+
+	w := request(t, h, "GET", "/bucket?"+query, nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+
+	var res listResult
+	rtest.OK(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	return res
+}
+
+func keys(res listResult) []string {
+	var keys []string
+	for _, c := range res.Contents {
+		keys = append(keys, c.Key)
+	}
+	for _, p := range res.CommonPrefixes {
+		keys = append(keys, p.Prefix)
+	}
+	return keys
+}
+
+func TestListObjects(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	var tests = []struct {
+		query string
+		keys  []string
+	}{
+		{"list-type=2", []string{"a b/f+g.txt", "a.txt", "a/b.txt", "a/c/d.txt", "e.txt"}},
+		{"list-type=2&delimiter=/", []string{"a.txt", "e.txt", "a b/", "a/"}},
+		{"list-type=2&delimiter=/&prefix=a/", []string{"a/b.txt", "a/c/"}},
+		{"list-type=2&prefix=a/c", []string{"a/c/d.txt"}},
+		{"list-type=2&prefix=a.", []string{"a.txt"}},
+		{"list-type=2&prefix=x", nil},
+		{"list-type=2&start-after=a/b.txt", []string{"a/c/d.txt", "e.txt"}},
+		{"marker=a.txt&delimiter=/", []string{"e.txt", "a/"}},
+		{"list-type=2&encoding-type=url&prefix=a+b", []string{"a+b%2Ff%2Bg.txt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			rtest.Equals(t, test.keys, keys(list(t, h, test.query)))
+		})
+	}
+}
+
+func TestListObjectsPages(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	var all []string
+	query := "list-type=2&max-keys=2"
+	for {
+		res := list(t, h, query)
+		all = append(all, keys(res)...)
+		if !res.IsTruncated {
+			break
+		}
+		rtest.Equals(t, 2, *res.KeyCount)
+		query = "list-type=2&max-keys=2&continuation-token=" + res.NextContinuationToken
+	}
+
+	rtest.Equals(t, []string{"a b/f+g.txt", "a.txt", "a/b.txt", "a/c/d.txt", "e.txt"}, all)
+}
+
+func TestListObjectsCursor(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	cursors := h.(handler).cursors
+
+	query := "list-type=2&max-keys=2"
+	var tokens []string
+	for {
+		res := list(t, h, query)
+		if !res.IsTruncated {
+			break
+		}
+		// the position of the truncated listing is kept
+		rtest.Equals(t, 1, len(cursors.cursors))
+		tokens = append(tokens, res.NextContinuationToken)
+		query = "list-type=2&max-keys=2&continuation-token=" + res.NextContinuationToken
+	}
+	rtest.Equals(t, 0, len(cursors.cursors))
+	rtest.Equals(t, 2, len(tokens))
+
+	// a new server without the cursor returns the same page
+	h2, cleanup2 := newTestHandler(t)
+	defer cleanup2()
+	for _, token := range tokens {
+		query = "list-type=2&max-keys=2&continuation-token=" + token
+		rtest.Equals(t, keys(list(t, h, query)), keys(list(t, h2, query)))
+	}
+
+	// version 1 continues with the marker
+	res := list(t, h, "max-keys=3&delimiter=/")
+	rtest.Equals(t, []string{"a.txt", "a b/", "a/"}, keys(res))
+	rtest.Assert(t, res.IsTruncated, "list is not truncated")
+	rtest.Equals(t, 1, len(cursors.cursors))
+	res = list(t, h, "max-keys=3&delimiter=/&marker="+res.NextMarker)
+	rtest.Equals(t, []string{"e.txt"}, keys(res))
+	rtest.Equals(t, 0, len(cursors.cursors))
+}
+
+func TestCursorCacheLimit(t *testing.T) {
+	cc := newCursorCache()
+	for i := 0; i < 2*maxCursors; i++ {
+		cc.put("", "", strconv.Itoa(i), &cursor{})
+	}
+	rtest.Equals(t, maxCursors, len(cc.cursors))
+	rtest.Assert(t, cc.take("", "", strconv.Itoa(2*maxCursors-1)) != nil, "newest cursor was dropped")
+}
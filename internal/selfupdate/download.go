@@ -10,7 +10,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -39,13 +41,16 @@ func findHash(buf []byte, filename string) (hash []byte, err error) {
 	return nil, fmt.Errorf("hash for file %v not found", filename)
 }
 
-func extractToFile(buf []byte, filename, target string, printf func(string, ...interface{})) error {
+// extractToTempFile extracts the binary from buf, which was downloaded as
+// filename, to a new temporary file in the directory of target and returns
+// the name of the temporary file. The file gets the permissions of target.
+func extractToTempFile(buf []byte, filename, target string, printf func(string, ...interface{})) (string, error) {
 	var mode = os.FileMode(0755)
 
 	// get information about the target file
 	fi, err := os.Lstat(target)
 	if err == nil {
-		mode = fi.Mode()
+		mode = fi.Mode().Perm()
 	}
 
 	var rd io.Reader = bytes.NewReader(buf)
@@ -55,16 +60,16 @@ func extractToFile(buf []byte, filename, target string, printf func(string, ...i
 	case ".zip":
 		zrd, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		if len(zrd.File) != 1 {
-			return errors.New("ZIP archive contains more than one file")
+			return "", errors.New("ZIP archive contains more than one file")
 		}
 
 		file, err := zrd.File[0].Open()
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		defer func() {
@@ -74,38 +79,151 @@ func extractToFile(buf []byte, filename, target string, printf func(string, ...i
 		rd = file
 	}
 
-	err = os.Remove(target)
-	if os.IsNotExist(err) {
-		err = nil
+	dest, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".update-")
+	if err != nil {
+		return "", err
+	}
+
+	n, err := io.Copy(dest, rd)
+	if err == nil {
+		err = dest.Sync()
+	}
+	if cerr := dest.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(dest.Name(), mode)
 	}
 	if err != nil {
-		return fmt.Errorf("unable to remove target file: %v", err)
+		_ = os.Remove(dest.Name())
+		return "", err
+	}
+
+	printf("saved %d bytes in %v\n", n, dest.Name())
+	return dest.Name(), nil
+}
+
+// copyFile creates dst with the content and mode of src. It tries to create
+// a hard link first.
+func copyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
 	}
 
-	dest, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	fi, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
 
-	n, err := io.Copy(dest, rd)
+	rd, err := os.Open(src)
 	if err != nil {
-		_ = dest.Close()
-		_ = os.Remove(dest.Name())
 		return err
 	}
+	defer rd.Close()
 
-	err = dest.Close()
+	wr, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
 	if err != nil {
 		return err
 	}
 
-	printf("saved %d bytes in %v\n", n, dest.Name())
+	_, err = io.Copy(wr, rd)
+	if cerr := wr.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+// replaceFile replaces target with the file newFile. The previous file is
+// kept as target.old until check has accepted the new file, if check fails
+// the previous file is restored. The file target.old is removed afterwards
+// if possible, on Windows a running binary cannot be removed.
+//
+// On Windows, target is renamed to target.old before newFile is moved into
+// place, because a running binary cannot be replaced. On all other systems,
+// target.old is a hard link or copy of target, which is replaced by newFile
+// in a single rename, so there is always a binary at target.
+func replaceFile(newFile, target string, check func(filename string) error, printf func(string, ...interface{})) error {
+	backup := target + ".old"
+	moveTarget := runtime.GOOS == "windows"
+
+	hasBackup := false
+	if _, err := os.Lstat(target); err == nil {
+		_ = os.Remove(backup)
+		if moveTarget {
+			err = os.Rename(target, backup)
+		} else {
+			err = copyFile(target, backup)
+		}
+		if err != nil {
+			_ = os.Remove(newFile)
+			return fmt.Errorf("unable to keep the previous file: %v", err)
+		}
+		hasBackup = true
+	}
+
+	rollback := func(cause error) error {
+		if !hasBackup {
+			_ = os.Remove(target)
+			return cause
+		}
+
+		if err := os.Rename(backup, target); err != nil {
+			return fmt.Errorf("%v, restoring the previous file from %v failed: %v", cause, backup, err)
+		}
+		return fmt.Errorf("%v, restored the previous file", cause)
+	}
+
+	if err := os.Rename(newFile, target); err != nil {
+		_ = os.Remove(newFile)
+		err = fmt.Errorf("unable to replace %v: %v", target, err)
+		if !moveTarget {
+			// target is unchanged
+			if hasBackup {
+				_ = os.Remove(backup)
+			}
+			return err
+		}
+		return rollback(err)
+	}
+
+	if check != nil {
+		if err := check(target); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if hasBackup {
+		if err := os.Remove(backup); err != nil {
+			printf("unable to remove the previous file %v: %v\n", backup, err)
+		}
+	}
+
+	return nil
+}
+
+// checkBinary runs the binary filename and checks that it reports version.
+func checkBinary(ctx context.Context, filename, version string) error {
+	out, err := exec.CommandContext(ctx, filename, "version").Output()
+	if err != nil {
+		return fmt.Errorf("unable to run the new binary: %v", err)
+	}
+
+	if !strings.HasPrefix(string(out), "restic "+version+" ") {
+		return fmt.Errorf("the new binary reports an unexpected version: %q", strings.TrimSpace(string(out)))
+	}
+
 	return nil
 }
 
 // DownloadLatestStableRelease downloads the latest stable released version of
-// restic and saves it to target. It returns the version string for the newest
-// version. The function printf is used to print progress information.
+// restic and saves it to target. The file SHA256SUMS must carry a valid
+// signature of the embedded key, and the new binary must run and report the
+// new version, otherwise the previous target is restored. It returns the
+// version string for the newest version. The function printf is used to print
+// progress information.
 func DownloadLatestStableRelease(ctx context.Context, target, currentVersion string, printf func(string, ...interface{})) (version string, err error) {
 	if printf == nil {
 		printf = func(string, ...interface{}) {}
@@ -169,7 +287,14 @@ func DownloadLatestStableRelease(ctx context.Context, target, currentVersion str
 		return "", fmt.Errorf("SHA256 hash mismatch, want hash %02x, got %02x", wantHash, gotHash)
 	}
 
-	err = extractToFile(buf, downloadFilename, target, printf)
+	newFile, err := extractToTempFile(buf, downloadFilename, target, printf)
+	if err != nil {
+		return "", err
+	}
+
+	err = replaceFile(newFile, target, func(filename string) error {
+		return checkBinary(ctx, filename, rel.Version)
+	}, printf)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,97 @@
+package selfupdate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t testing.TB, filename, data string) {
+	if err := ioutil.WriteFile(filename, []byte(data), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t testing.TB, filename, want string) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != want {
+		t.Errorf("wrong content of %v: want %q, got %q", filename, want, buf)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "restic-selfupdate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	target := filepath.Join(tempdir, "restic")
+	newFile := filepath.Join(tempdir, "restic.new")
+	printf := func(string, ...interface{}) {}
+
+	writeFile(t, target, "old")
+	writeFile(t, newFile, "new")
+
+	err = replaceFile(newFile, target, func(string) error {
+		return errors.New("binary does not run")
+	}, printf)
+	if err == nil {
+		t.Fatal("expected error for failed check")
+	}
+
+	checkFile(t, target, "old")
+	for _, filename := range []string{newFile, target + ".old"} {
+		if _, err := os.Lstat(filename); !os.IsNotExist(err) {
+			t.Errorf("file %v was not removed", filename)
+		}
+	}
+
+	writeFile(t, newFile, "new")
+
+	var checked string
+	err = replaceFile(newFile, target, func(filename string) error {
+		checked = filename
+		checkFile(t, target+".old", "old")
+		return nil
+	}, printf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checked != target {
+		t.Errorf("check was called for %q instead of %q", checked, target)
+	}
+	checkFile(t, target, "new")
+	if _, err := os.Lstat(target + ".old"); !os.IsNotExist(err) {
+		t.Errorf("previous file was not removed")
+	}
+}
+
+func TestReplaceFileRenameFails(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "restic-selfupdate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	target := filepath.Join(tempdir, "restic")
+	writeFile(t, target, "old")
+
+	// the new file does not exist, so it cannot be renamed
+	err = replaceFile(filepath.Join(tempdir, "missing"), target, nil, func(string, ...interface{}) {})
+	if err == nil {
+		t.Fatal("expected error for missing new file")
+	}
+
+	checkFile(t, target, "old")
+	if _, err := os.Lstat(target + ".old"); !os.IsNotExist(err) {
+		t.Errorf("previous file was not removed")
+	}
+}
@@ -0,0 +1,252 @@
+// Package sftpserver serves a read-only file system via SFTP.
+package sftpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config holds settings for the SFTP server.
+type Config struct {
+	// HostKey identifies the server to the clients.
+	HostKey ssh.Signer
+
+	// If Username is set, clients can log in with the password.
+	Username string
+	Password string
+
+	// Clients can log in with any user and one of the AuthorizedKeys.
+	AuthorizedKeys []ssh.PublicKey
+}
+
+// Server serves a file system via SFTP.
+type Server struct {
+	fs  webdav.FileSystem
+	cfg *ssh.ServerConfig
+}
+
+// New returns a server for the file system fs. If neither a user nor
+// authorized keys are configured, clients do not need to authenticate.
+func New(fs webdav.FileSystem, cfg Config) *Server {
+	sshCfg := &ssh.ServerConfig{
+		NoClientAuth: cfg.Username == "" && len(cfg.AuthorizedKeys) == 0,
+	}
+
+	if cfg.Username != "" {
+		sshCfg.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			userOK := subtle.ConstantTimeCompare([]byte(conn.User()), []byte(cfg.Username)) == 1
+			passwordOK := subtle.ConstantTimeCompare(password, []byte(cfg.Password)) == 1
+			if userOK && passwordOK {
+				return nil, nil
+			}
+			return nil, errors.New("wrong user or password")
+		}
+	}
+
+	if len(cfg.AuthorizedKeys) > 0 {
+		sshCfg.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range cfg.AuthorizedKeys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown public key")
+		}
+	}
+
+	sshCfg.AddHostKey(cfg.HostKey)
+
+	return &Server{fs: fs, cfg: sshCfg}
+}
+
+// Serve accepts connections on ln until an error occurs.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// serveConn runs the SFTP subsystem for all sessions of the connection.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.cfg)
+	if err != nil {
+		debug.Log("handshake with %v failed: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	debug.Log("new connection from %v for user %v", sshConn.RemoteAddr(), sshConn.User())
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			debug.Log("accepting channel failed: %v", err)
+			continue
+		}
+
+		go s.serveSession(ctx, channel, requests)
+	}
+}
+
+// serveSession serves SFTP for a session once the client requests the
+// subsystem.
+func (s *Server) serveSession(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() {
+		_ = channel.Close()
+	}()
+
+	for req := range requests {
+		// the payload is the name of the subsystem, prefixed by its length
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+
+		h := handlers{ctx: ctx, fs: s.fs}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  h,
+			FilePut:  h,
+			FileCmd:  h,
+			FileList: h,
+		})
+		err := server.Serve()
+		if err != nil && err != io.EOF {
+			debug.Log("sftp server failed: %v", err)
+		}
+		_ = server.Close()
+		return
+	}
+}
+
+// handlers implements the handlers of the SFTP request server.
+type handlers struct {
+	ctx context.Context
+	fs  webdav.FileSystem
+}
+
+// Fileread opens a file for reading.
+func (h handlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.fs.OpenFile(h.ctx, r.Filepath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		_ = f.Close()
+		return nil, errors.New("is a directory")
+	}
+
+	return &readerAt{f: f}, nil
+}
+
+// Filewrite rejects writing files.
+func (h handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return nil, syscall.EPERM
+}
+
+// Filecmd rejects all commands which would modify files.
+func (h handlers) Filecmd(r *sftp.Request) error {
+	return syscall.EPERM
+}
+
+// Filelist lists a directory or returns information about a file.
+func (h handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		f, err := h.fs.OpenFile(h.ctx, r.Filepath, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		list, err := f.Readdir(0)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(list), nil
+	case "Stat", "Lstat":
+		fi, err := h.fs.Stat(h.ctx, r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{fi}, nil
+	default:
+		return nil, syscall.EPERM
+	}
+}
+
+// listerAt implements sftp.ListerAt for a list of files.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(list, l[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readerAt implements io.ReaderAt for a file, which is read sequentially.
+type readerAt struct {
+	m sync.Mutex
+	f webdav.File
+}
+
+func (r *readerAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, err := r.f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(r.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the file.
+func (r *readerAt) Close() error {
+	return r.f.Close()
+}
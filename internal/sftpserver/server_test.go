@@ -0,0 +1,62 @@
+package sftpserver
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/net/webdav"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHandlers(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	data := []byte("foobar baz")
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file"), data, 0644))
+	rtest.OK(t, os.Mkdir(filepath.Join(tempdir, "dir"), 0755))
+
+	h := handlers{ctx: context.TODO(), fs: webdav.Dir(tempdir)}
+
+	rd, err := h.Fileread(&sftp.Request{Filepath: "/file"})
+	rtest.OK(t, err)
+
+	buf := make([]byte, 3)
+	n, err := rd.ReadAt(buf, 4)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+	rtest.Equals(t, []byte("bar"), buf)
+
+	// reading beyond the end returns the rest
+	n, err = rd.ReadAt(buf, 8)
+	rtest.Equals(t, io.EOF, err)
+	rtest.Equals(t, []byte("az"), buf[:n])
+	rtest.OK(t, rd.(io.Closer).Close())
+
+	_, err = h.Fileread(&sftp.Request{Filepath: "/dir"})
+	rtest.Assert(t, err != nil, "reading a directory succeeded")
+
+	_, err = h.Filewrite(&sftp.Request{Filepath: "/new"})
+	rtest.Assert(t, err != nil, "writing a file succeeded")
+	rtest.Assert(t, h.Filecmd(&sftp.Request{Method: "Remove", Filepath: "/file"}) != nil, "removing a file succeeded")
+
+	lister, err := h.Filelist(&sftp.Request{Method: "List", Filepath: "/"})
+	rtest.OK(t, err)
+	list := make([]os.FileInfo, 10)
+	n, err = lister.ListAt(list, 0)
+	rtest.Equals(t, io.EOF, err)
+	rtest.Equals(t, 2, n)
+
+	lister, err = h.Filelist(&sftp.Request{Method: "Stat", Filepath: "/file"})
+	rtest.OK(t, err)
+	n, err = lister.ListAt(list, 0)
+	rtest.Equals(t, io.EOF, err)
+	rtest.Equals(t, 1, n)
+	rtest.Equals(t, int64(len(data)), list[0].Size())
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui"
 	"github.com/restic/restic/internal/ui/termstatus"
@@ -186,6 +187,7 @@ func (b *Backup) ScannerError(item string, fi os.FileInfo, err error) error {
 	b.error(errorUpdate{
 		MessageType: "error",
 		Error:       err,
+		ErrorCode:   string(errors.CodeOf(err)),
 		During:      "scan",
 		Item:        item,
 	})
@@ -197,6 +199,7 @@ func (b *Backup) Error(item string, fi os.FileInfo, err error) error {
 	b.error(errorUpdate{
 		MessageType: "error",
 		Error:       err,
+		ErrorCode:   string(errors.CodeOf(err)),
 		During:      "archival",
 		Item:        item,
 	})
@@ -406,6 +409,7 @@ type statusUpdate struct {
 type errorUpdate struct {
 	MessageType string `json:"message_type"` // "error"
 	Error       error  `json:"error"`
+	ErrorCode   string `json:"error_code"`
 	During      string `json:"during"`
 	Item        string `json:"item"`
 }
@@ -0,0 +1,231 @@
+package json
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Restore reports progress for the `restore` command in JSON.
+type Restore struct {
+	wr    io.Writer
+	start time.Time
+
+	m            sync.Mutex
+	started      bool
+	total        counter
+	restored     counter
+	skipped      counter
+	bytesDone    uint64
+	errors       uint
+	currentFiles map[string]struct{}
+	filename     string
+}
+
+// NewRestore returns a new restore progress reporter which writes JSON
+// messages to wr.
+func NewRestore(wr io.Writer) *Restore {
+	return &Restore{
+		wr:           wr,
+		start:        time.Now(),
+		currentFiles: make(map[string]struct{}),
+	}
+}
+
+func (r *Restore) print(status interface{}) {
+	_ = json.NewEncoder(r.wr).Encode(status)
+}
+
+// Run regularly prints the status. It should be called in a separate
+// goroutine.
+func (r *Restore) Run(ctx context.Context) error {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			r.update()
+		}
+	}
+}
+
+// update prints a status message.
+func (r *Restore) update() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.started {
+		return
+	}
+
+	secs := uint64(time.Since(r.start) / time.Second)
+	status := statusUpdate{
+		MessageType:    "status",
+		SecondsElapsed: secs,
+		TotalFiles:     r.total.Files,
+		FilesDone:      r.restored.Files + r.skipped.Files,
+		TotalBytes:     r.total.Bytes,
+		BytesDone:      r.bytesDone,
+		ErrorCount:     r.errors,
+	}
+
+	if r.total.Bytes > 0 {
+		status.PercentDone = float64(r.bytesDone) / float64(r.total.Bytes)
+	}
+
+	if r.bytesDone > 0 && r.total.Bytes > r.bytesDone {
+		todo := float64(r.total.Bytes - r.bytesDone)
+		status.SecondsRemaining = uint64(float64(secs) / float64(r.bytesDone) * todo)
+	}
+
+	for filename := range r.currentFiles {
+		status.CurrentFiles = append(status.CurrentFiles, filename)
+	}
+	sort.Strings(status.CurrentFiles)
+
+	r.print(status)
+}
+
+// ReportTotal sets the number and size of all files to restore.
+func (r *Restore) ReportTotal(files, bytes uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.total = counter{Files: files, Bytes: bytes}
+	r.started = true
+}
+
+// StartFile is called when the first data is written to a file.
+func (r *Restore) StartFile(location string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.currentFiles[location] = struct{}{}
+}
+
+// CompleteBlob is called for all blobs written to files.
+func (r *Restore) CompleteBlob(location string, bytes uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.bytesDone += bytes
+}
+
+// CompleteFile is called when a file has been restored or skipped.
+func (r *Restore) CompleteFile(location string, size uint64, skipped bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.currentFiles, location)
+	if skipped {
+		r.skipped.Files++
+		r.skipped.Bytes += size
+		r.bytesDone += size
+		return
+	}
+
+	r.restored.Files++
+	r.restored.Bytes += size
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (r *Restore) Error(location string, err error) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.errors++
+	r.print(restoreErrorUpdate{
+		MessageType: "error",
+		Error:       err.Error(),
+		ErrorCode:   string(errors.CodeOf(err)),
+		During:      "restore",
+		Item:        location,
+	})
+	return nil
+}
+
+// PlannedItem prints an item which would be handled by a restore with
+// --dry-run.
+func (r *Restore) PlannedItem(location, nodeType, action string, size uint64, conflict bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.print(dryRunItem{
+		MessageType: "dry_run",
+		Action:      action,
+		Type:        nodeType,
+		Item:        location,
+		Size:        size,
+		Conflict:    conflict,
+	})
+}
+
+// SetFilename records the name of the file in the snapshot which is written
+// to stdout, it is included in the summary.
+func (r *Restore) SetFilename(filename string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.filename = filename
+}
+
+// Finish prints the summary.
+func (r *Restore) Finish(snapshotID restic.ID) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.print(restoreSummaryOutput{
+		MessageType:   "summary",
+		TotalFiles:    r.total.Files,
+		FilesRestored: r.restored.Files,
+		FilesSkipped:  r.skipped.Files,
+		TotalBytes:    r.total.Bytes,
+		BytesRestored: r.restored.Bytes,
+		BytesSkipped:  r.skipped.Bytes,
+		ErrorCount:    r.errors,
+		TotalDuration: time.Since(r.start).Seconds(),
+		SnapshotID:    snapshotID.Str(),
+		Filename:      r.filename,
+	})
+}
+
+type restoreErrorUpdate struct {
+	MessageType string `json:"message_type"` // "error"
+	Error       string `json:"error"`
+	ErrorCode   string `json:"error_code"`
+	During      string `json:"during"`
+	Item        string `json:"item"`
+}
+
+type dryRunItem struct {
+	MessageType string `json:"message_type"` // "dry_run"
+	Action      string `json:"action"`
+	Type        string `json:"type"`
+	Item        string `json:"item"`
+	Size        uint64 `json:"size"`
+	Conflict    bool   `json:"conflict"`
+}
+
+type restoreSummaryOutput struct {
+	MessageType   string  `json:"message_type"` // "summary"
+	TotalFiles    uint64  `json:"total_files"`
+	FilesRestored uint64  `json:"files_restored"`
+	FilesSkipped  uint64  `json:"files_skipped"`
+	TotalBytes    uint64  `json:"total_bytes"`
+	BytesRestored uint64  `json:"bytes_restored"`
+	BytesSkipped  uint64  `json:"bytes_skipped"`
+	ErrorCount    uint    `json:"error_count"`
+	TotalDuration float64 `json:"total_duration"` // in seconds
+	SnapshotID    string  `json:"snapshot_id"`
+	Filename      string  `json:"filename,omitempty"`
+}
@@ -0,0 +1,157 @@
+package webdav
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// digestRealm is the realm used for digest authentication.
+const digestRealm = "restic"
+
+// digestAuth implements HTTP digest authentication (RFC 7616 with MD5) for a
+// single user. The nonces are authenticated with a random secret, so they do
+// not need to be stored.
+type digestAuth struct {
+	username string
+	password string
+	secret   []byte
+}
+
+func newDigestAuth(username, password string) *digestAuth {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return &digestAuth{
+		username: username,
+		password: password,
+		secret:   secret,
+	}
+}
+
+func (d *digestAuth) nonceMAC(buf []byte) []byte {
+	mac := hmac.New(sha256.New, d.secret)
+	_, _ = mac.Write(buf)
+	return mac.Sum(nil)[:16]
+}
+
+// challenge returns the value of the WWW-Authenticate header with a new
+// nonce.
+func (d *digestAuth) challenge() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	nonce := hex.EncodeToString(append(buf, d.nonceMAC(buf)...))
+	return fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s"`, digestRealm, nonce)
+}
+
+func (d *digestAuth) validNonce(nonce string) bool {
+	buf, err := hex.DecodeString(nonce)
+	if err != nil || len(buf) != 32 {
+		return false
+	}
+	return hmac.Equal(buf[16:], d.nonceMAC(buf[:16]))
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorized returns true if the Authorization header of r contains a valid
+// digest response.
+func (d *digestAuth) authorized(r *http.Request) bool {
+	params, ok := parseDigestAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return false
+	}
+
+	if params["realm"] != digestRealm || !d.validNonce(params["nonce"]) {
+		return false
+	}
+
+	// the response must have been computed for the requested path
+	uri, err := url.Parse(params["uri"])
+	if err != nil || uri.Path != r.URL.Path {
+		return false
+	}
+
+	ha1 := md5Hex(d.username + ":" + digestRealm + ":" + d.password)
+	ha2 := md5Hex(r.Method + ":" + params["uri"])
+
+	var expected string
+	switch params["qop"] {
+	case "auth":
+		expected = md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	case "":
+		expected = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	default:
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(params["username"]), []byte(d.username)) == 1
+	responseOK := subtle.ConstantTimeCompare([]byte(params["response"]), []byte(expected)) == 1
+	return userOK && responseOK
+}
+
+// parseDigestAuthorization returns the parameters of a digest Authorization
+// header like `Digest username="user", nc=00000001`.
+func parseDigestAuthorization(header string) (map[string]string, bool) {
+	const prefix = "digest "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	s := header[len(prefix):]
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, true
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, false
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, false
+			}
+			value = b.String()
+			s = s[i+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = value
+	}
+}
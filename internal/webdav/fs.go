@@ -0,0 +1,334 @@
+// Package webdav serves the snapshots of a repository read-only via WebDAV,
+// using the same directory layout as the fuse mount.
+package webdav
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/net/webdav"
+)
+
+// fileSystem implements webdav.FileSystem for the snapshots of a repository.
+type fileSystem struct {
+	repo      restic.Repository
+	dirStruct *fuse.SnapshotsDirStructure
+}
+
+// entry is a directory of the snapshot layout, a snapshot, or a file or
+// directory within a snapshot.
+type entry struct {
+	name string
+
+	// meta is set for directories of the layout and snapshots
+	meta *fuse.MetaDirData
+
+	// node is set for files and directories within a snapshot
+	node *restic.Node
+}
+
+func (e *entry) isDir() bool {
+	return e.node == nil || e.node.Type == "dir"
+}
+
+// Stat returns the file info of the entry.
+func (e *entry) Stat() (os.FileInfo, error) {
+	fi := fileInfo{name: e.name, mode: os.ModeDir | 0555}
+	switch {
+	case e.node != nil:
+		fi.mode = e.node.Mode
+		fi.modTime = e.node.ModTime
+		if e.node.Type == "dir" {
+			fi.mode |= os.ModeDir
+		} else {
+			fi.size = int64(e.node.Size)
+		}
+	case e.meta.Snapshot != nil:
+		fi.modTime = e.meta.Snapshot.Time
+	}
+	return fi, nil
+}
+
+// fileInfo implements os.FileInfo.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+// children returns the entries of the directory e. Symlinks of the layout
+// are resolved, only files and directories within snapshots are returned.
+func (fs *fileSystem) children(ctx context.Context, e *entry) ([]*entry, error) {
+	if !e.isDir() {
+		return nil, errors.New("not a directory")
+	}
+
+	var list []*entry
+	if e.meta != nil && e.meta.IsDir() {
+		for name, m := range e.meta.Names {
+			if m.LinkTarget != "" {
+				m = e.meta.Names[m.LinkTarget]
+			}
+			list = append(list, &entry{name: name, meta: m})
+		}
+	} else {
+		var id restic.ID
+		if e.node != nil {
+			id = *e.node.Subtree
+		} else {
+			id = *e.meta.Snapshot.Tree
+		}
+
+		tree, err := fs.repo.LoadTree(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range tree.Nodes {
+			if node.Type != "file" && (node.Type != "dir" || node.Subtree == nil) {
+				continue
+			}
+			list = append(list, &entry{name: path.Base(node.Name), node: node})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list, nil
+}
+
+// resolve returns the entry for name.
+func (fs *fileSystem) resolve(ctx context.Context, name string) (*entry, error) {
+	meta, err := fs.dirStruct.UpdatePrefix(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{name: "/", meta: meta}
+	for _, c := range strings.Split(path.Clean("/"+name), "/") {
+		if c == "" {
+			continue
+		}
+
+		if e.meta != nil && e.meta.IsDir() {
+			m, ok := e.meta.Names[c]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			if m.LinkTarget != "" {
+				m = e.meta.Names[m.LinkTarget]
+			}
+			e = &entry{name: c, meta: m}
+			continue
+		}
+
+		if !e.isDir() {
+			return nil, os.ErrNotExist
+		}
+
+		list, err := fs.children(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+
+		i := sort.Search(len(list), func(i int) bool {
+			return list[i].name >= c
+		})
+		if i == len(list) || list[i].name != c {
+			return nil, os.ErrNotExist
+		}
+		e = list[i]
+	}
+
+	return e, nil
+}
+
+// Mkdir returns an error as the file system is read-only.
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// RemoveAll returns an error as the file system is read-only.
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+// Rename returns an error as the file system is read-only.
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+// Stat returns the file info for name.
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.Stat()
+}
+
+// OpenFile opens name for reading.
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	e, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{ctx: ctx, fs: fs, entry: e}, nil
+}
+
+// file implements webdav.File for an entry.
+type file struct {
+	ctx context.Context
+	fs  *fileSystem
+	*entry
+
+	// list and pos are used for reading directories
+	list []*entry
+	pos  int
+
+	// offset is the position in the file, offsets contains the start of each
+	// blob of the content followed by the size of the file
+	offset  int64
+	offsets []int64
+	blob    []byte
+	blobIdx int
+}
+
+// Close does nothing.
+func (f *file) Close() error {
+	return nil
+}
+
+// Write returns an error as the file system is read-only.
+func (f *file) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// Readdir returns the next count entries of the directory, or all remaining
+// entries if count <= 0.
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.list == nil {
+		list, err := f.fs.children(f.ctx, f.entry)
+		if err != nil {
+			return nil, err
+		}
+		f.list = list
+	}
+
+	rest := f.list[f.pos:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if len(rest) > count {
+			rest = rest[:count]
+		}
+	}
+	f.pos += len(rest)
+
+	infos := make([]os.FileInfo, 0, len(rest))
+	for _, e := range rest {
+		fi, err := e.Stat()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+// Seek sets the offset for the next Read.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.isDir() {
+		return 0, errors.New("is a directory")
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(f.node.Size)
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Read reads the content of the file, loading one blob at a time.
+func (f *file) Read(p []byte) (int, error) {
+	if f.isDir() {
+		return 0, errors.New("is a directory")
+	}
+
+	if f.offsets == nil {
+		f.offsets = make([]int64, 0, len(f.node.Content)+1)
+		var size int64
+		for _, id := range f.node.Content {
+			f.offsets = append(f.offsets, size)
+			s, ok := f.fs.repo.LookupBlobSize(id, restic.DataBlob)
+			if !ok {
+				return 0, errors.Errorf("id %v not found in repository", id)
+			}
+			size += int64(s)
+		}
+		f.offsets = append(f.offsets, size)
+		f.blobIdx = -1
+	}
+
+	n := 0
+	for n < len(p) {
+		if f.offset >= f.offsets[len(f.offsets)-1] {
+			break
+		}
+
+		// find the blob which contains the offset
+		i := sort.Search(len(f.node.Content), func(i int) bool {
+			return f.offsets[i+1] > f.offset
+		})
+		if i != f.blobIdx {
+			blob, err := f.fs.repo.LoadBlob(f.ctx, restic.DataBlob, f.node.Content[i], f.blob)
+			if err != nil {
+				return n, err
+			}
+			f.blob, f.blobIdx = blob, i
+		}
+
+		c := copy(p[n:], f.blob[f.offset-f.offsets[i]:])
+		n += c
+		f.offset += int64(c)
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,91 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// firstFile returns the path and node of the first file below the tree.
+func firstFile(t testing.TB, repo restic.Repository, id restic.ID, prefix string) (string, *restic.Node) {
+	tree, err := repo.LoadTree(context.TODO(), id)
+	rtest.OK(t, err)
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case "file":
+			return path.Join(prefix, node.Name), node
+		case "dir":
+			if p, n := firstFile(t, repo, *node.Subtree, path.Join(prefix, node.Name)); n != nil {
+				return p, n
+			}
+		}
+	}
+	return "", nil
+}
+
+func TestFileSystem(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	sn := restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 0), 2, 0)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	ctx := context.Background()
+	fs := &fileSystem{
+		repo:      repo,
+		dirStruct: fuse.NewSnapshotsDirStructure(repo, nil, nil, nil, fuse.DefaultPathTemplates, time.RFC3339),
+	}
+
+	fi, err := fs.Stat(ctx, "/hosts/foo/latest")
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.IsDir(), "latest is not a directory")
+	rtest.Equals(t, sn.Time.Unix(), fi.ModTime().Unix())
+
+	_, err = fs.Stat(ctx, "/hosts/bar")
+	rtest.Assert(t, os.IsNotExist(err), "unexpected error %v", err)
+
+	root, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	rtest.OK(t, err)
+	infos, err := root.Readdir(0)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(fuse.DefaultPathTemplates), len(infos))
+
+	name, node := firstFile(t, repo, *sn.Tree, path.Join("/ids", sn.ID().Str()))
+	rtest.Assert(t, node != nil, "snapshot does not contain a file")
+
+	var want []byte
+	for _, id := range node.Content {
+		buf, err := repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		want = append(want, buf...)
+	}
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	rtest.OK(t, err)
+	data, err := ioutil.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(want, data), "wrong content for %v", name)
+
+	if len(want) > 10 {
+		_, err = f.Seek(-10, os.SEEK_END)
+		rtest.OK(t, err)
+		data, err = ioutil.ReadAll(f)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(want[len(want)-10:], data), "wrong content after seeking")
+	}
+	rtest.OK(t, f.Close())
+
+	_, err = fs.OpenFile(ctx, name, os.O_RDWR, 0)
+	rtest.Assert(t, os.IsPermission(err), "opening a file for writing did not fail: %v", err)
+	rtest.Assert(t, os.IsPermission(fs.RemoveAll(ctx, name)), "removing a file did not fail")
+}
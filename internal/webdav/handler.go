@@ -0,0 +1,197 @@
+package webdav
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fuse"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/net/webdav"
+)
+
+// Config holds settings for the WebDAV server.
+type Config struct {
+	Hosts            []string
+	Tags             []restic.TagList
+	Paths            []string
+	SnapshotTemplate string
+
+	// PathTemplates are the templates for the directories which contain the
+	// snapshots, fuse.DefaultPathTemplates are used if it is empty.
+	PathTemplates []string
+
+	// If Username is set, clients must authenticate with HTTP basic auth.
+	Username string
+	Password string
+
+	// Digest selects digest authentication instead of basic authentication,
+	// which the WebDAV clients of Windows and macOS refuse over plain HTTP.
+	Digest bool
+}
+
+// NewFileSystem returns a read-only file system with the snapshots of repo,
+// which can also be used to serve them via other protocols. The index of the
+// repository must be loaded.
+func NewFileSystem(repo restic.Repository, cfg Config) webdav.FileSystem {
+	pathTemplates := cfg.PathTemplates
+	if len(pathTemplates) == 0 {
+		pathTemplates = fuse.DefaultPathTemplates
+	}
+
+	return &fileSystem{
+		repo:      repo,
+		dirStruct: fuse.NewSnapshotsDirStructure(repo, cfg.Hosts, cfg.Tags, cfg.Paths, pathTemplates, cfg.SnapshotTemplate),
+	}
+}
+
+// NewHandler returns an http.Handler which serves the snapshots of repo
+// read-only via WebDAV. The index of the repository must be loaded.
+func NewHandler(repo restic.Repository, cfg Config) http.Handler {
+	fs := NewFileSystem(repo, cfg).(*fileSystem)
+
+	var digest *digestAuth
+	if cfg.Username != "" && cfg.Digest {
+		digest = newDigestAuth(cfg.Username, cfg.Password)
+	}
+
+	return handler{
+		cfg:    cfg,
+		fs:     fs,
+		digest: digest,
+		dav: &webdav.Handler{
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					debug.Log("%v %v: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		},
+	}
+}
+
+// handler checks the credentials, rejects all requests which would modify
+// files and lists directories for browsers.
+type handler struct {
+	cfg    Config
+	fs     *fileSystem
+	digest *digestAuth
+	dav    http.Handler
+}
+
+func (h handler) authorized(r *http.Request) bool {
+	if h.cfg.Username == "" {
+		return true
+	}
+
+	if h.digest != nil {
+		return h.digest.authorized(r)
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.cfg.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.cfg.Password)) == 1
+	return userOK && passwordOK
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		if h.digest != nil {
+			w.Header().Set("WWW-Authenticate", h.digest.challenge())
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		if h.serveDir(w, r) {
+			return
+		}
+		h.dav.ServeHTTP(w, r)
+	case "OPTIONS", "PROPFIND", "LOCK", "UNLOCK":
+		h.dav.ServeHTTP(w, r)
+	default:
+		http.Error(w, "read-only file system", http.StatusMethodNotAllowed)
+	}
+}
+
+var dirTemplate = template.Must(template.New("dir").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{ .Path }}</title></head>
+<body>
+<h1>{{ .Path }}</h1>
+<ul>
+{{- if ne .Path "/" }}
+<li><a href="../">../</a></li>
+{{- end }}
+{{- range .Entries }}
+<li><a href="{{ .Link }}">{{ .Name }}</a></li>
+{{- end }}
+</ul>
+</body>
+</html>
+`))
+
+// serveDir writes an HTML page with the entries of the directory requested
+// by r, it returns false if r does not refer to a directory.
+func (h handler) serveDir(w http.ResponseWriter, r *http.Request) bool {
+	f, err := h.fs.OpenFile(r.Context(), r.URL.Path, 0, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	// relative links only work for paths ending with a slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return true
+	}
+
+	infos, err := f.Readdir(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	type dirEntry struct {
+		Name string
+		Link string
+	}
+
+	data := struct {
+		Path    string
+		Entries []dirEntry
+	}{Path: r.URL.Path}
+
+	for _, fi := range infos {
+		e := dirEntry{Name: fi.Name(), Link: url.PathEscape(fi.Name())}
+		if fi.IsDir() {
+			e.Name += "/"
+			e.Link += "/"
+		}
+		data.Entries = append(data.Entries, e)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dirTemplate.Execute(w, data); err != nil {
+		debug.Log("rendering directory %v failed: %v", r.URL.Path, err)
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHandler(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	restic.TestCreateSnapshot(t, repo, time.Unix(1460289341, 0), 1, 0)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	h := NewHandler(repo, Config{
+		SnapshotTemplate: time.RFC3339,
+		Username:         "user",
+		Password:         "secret",
+	})
+
+	request := func(method, path string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth {
+			req.SetBasicAuth("user", "secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rtest.Equals(t, http.StatusUnauthorized, request("GET", "/", false).Code)
+
+	rec := request("GET", "/", true)
+	rtest.Equals(t, http.StatusOK, rec.Code)
+	rtest.Assert(t, strings.Contains(rec.Body.String(), `<a href="hosts/">hosts/</a>`),
+		"directory listing does not contain hosts: %v", rec.Body.String())
+
+	rec = request("GET", "/hosts", true)
+	rtest.Equals(t, http.StatusMovedPermanently, rec.Code)
+	rtest.Equals(t, "/hosts/", rec.Header().Get("Location"))
+
+	rtest.Equals(t, http.StatusNotFound, request("GET", "/hosts/bar/", true).Code)
+	rtest.Equals(t, http.StatusMethodNotAllowed, request("PUT", "/hosts/foo/test", true).Code)
+	rtest.Equals(t, http.StatusMethodNotAllowed, request("DELETE", "/hosts/foo/latest", true).Code)
+}
+
+func TestHandlerDigestAuth(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	h := NewHandler(repo, Config{
+		SnapshotTemplate: time.RFC3339,
+		Username:         "user",
+		Password:         "secret",
+		Digest:           true,
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	rtest.Equals(t, http.StatusUnauthorized, rec.Code)
+
+	challenge, ok := parseDigestAuthorization(rec.Header().Get("WWW-Authenticate"))
+	rtest.Assert(t, ok, "invalid challenge %q", rec.Header().Get("WWW-Authenticate"))
+	rtest.Equals(t, "auth", challenge["qop"])
+
+	request := func(user, password, uri, path string) int {
+		ha1 := md5Hex(user + ":" + challenge["realm"] + ":" + password)
+		ha2 := md5Hex("GET:" + uri)
+		response := md5Hex(ha1 + ":" + challenge["nonce"] + ":00000001:abcdef:auth:" + ha2)
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=00000001, cnonce="abcdef", response="%s"`,
+			user, challenge["realm"], challenge["nonce"], uri, response))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	rtest.Equals(t, http.StatusOK, request("user", "secret", "/", "/"))
+	rtest.Equals(t, http.StatusUnauthorized, request("user", "wrong", "/", "/"))
+	rtest.Equals(t, http.StatusUnauthorized, request("other", "secret", "/", "/"))
+	rtest.Equals(t, http.StatusUnauthorized, request("user", "secret", "/hosts/", "/"))
+
+	// basic auth is not accepted
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("user", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	rtest.Equals(t, http.StatusUnauthorized, rec.Code)
+}